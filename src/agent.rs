@@ -0,0 +1,702 @@
+//! GitOps reconciliation agent (`imacs agent`)
+//!
+//! Keeps the runtime engines of a service in step with a branch of a git
+//! repository of specs. Each reconciliation fetches the branch and, when
+//! its head moved, loads every spec under the watched path as `imacs
+//! render` does ([`Spec::from_file`]: tables, constant overrides, overlays),
+//! validates them and checks them against the engine [`Limits`]. The active
+//! engines are then replaced as one set, in a single swap, so no request
+//! sees half a commit.
+//!
+//! A spec file that fails is reported and does not hold back the others:
+//! the spec it holds keeps its last good version. YAML that is not a spec —
+//! `overrides/` files, table data, flows, templates, anything without an
+//! `id` such as CI config — is skipped.
+//!
+//! [`serve_status`] exposes the reconciliation status over HTTP:
+//! `GET /status` returns it as JSON, with the last applied commit, each
+//! active spec's version and hash and the files that failed; `GET /healthz`
+//! answers 200 while every spec of the branch head is active and 503
+//! otherwise.
+
+use crate::error::{Error, Result};
+use crate::runtime::{Engine, Evaluation, Limits, Values};
+use crate::spec::Spec;
+use serde::Serialize;
+use std::collections::{BTreeMap, HashMap, HashSet};
+use std::io::{BufRead, BufReader, Write};
+use std::net::TcpListener;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+use std::sync::{Arc, Mutex, RwLock};
+use std::time::Duration;
+
+/// Engines for the active version of every spec, replaced as a whole
+#[derive(Default)]
+pub struct ActiveSpecs {
+    engines: RwLock<Arc<HashMap<String, Arc<Engine>>>>,
+}
+
+impl ActiveSpecs {
+    /// Engine of a spec, if it is active
+    pub fn get(&self, id: &str) -> Option<Arc<Engine>> {
+        self.engines.read().unwrap().get(id).cloned()
+    }
+
+    /// Evaluate an input with the active version of a spec
+    pub fn evaluate(&self, id: &str, input: &Values) -> Result<Evaluation> {
+        self.get(id)
+            .ok_or_else(|| Error::Other(format!("Spec '{}' is not active", id)))?
+            .evaluate(input)
+    }
+
+    /// IDs of the active specs
+    pub fn ids(&self) -> Vec<String> {
+        let mut ids: Vec<String> = self.engines.read().unwrap().keys().cloned().collect();
+        ids.sort();
+        ids
+    }
+
+    fn snapshot(&self) -> Arc<HashMap<String, Arc<Engine>>> {
+        Arc::clone(&self.engines.read().unwrap())
+    }
+
+    fn replace(&self, engines: HashMap<String, Arc<Engine>>) {
+        *self.engines.write().unwrap() = Arc::new(engines);
+    }
+}
+
+/// Where the agent is in reconciling
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum State {
+    /// Nothing reconciled yet
+    #[default]
+    Pending,
+    /// The active specs are the branch head's
+    Synced,
+    /// The branch head is applied, but some of its spec files failed
+    Degraded,
+    /// The branch head could not be fetched or applied
+    Failed,
+}
+
+/// An active spec
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ActiveSpec {
+    /// `meta.version`, when the spec declares one
+    pub version: Option<String>,
+    pub hash: String,
+}
+
+/// A spec file of the applied commit that is not active
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct FileFailure {
+    /// Path within the watched directory
+    pub file: String,
+    /// ID of the spec the file holds, when it could be read
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub spec: Option<String>,
+    pub error: String,
+}
+
+/// Reconciliation status, as `GET /status` reports it
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct Status {
+    pub repo: String,
+    pub branch: String,
+    pub state: State,
+    /// Commit whose specs are active
+    pub last_applied: Option<String>,
+    /// Commit of the last attempt, successful or not
+    pub last_attempted: Option<String>,
+    /// When the last attempt finished (RFC 3339)
+    pub last_reconciled_at: Option<String>,
+    /// Why the last attempt failed
+    pub error: Option<String>,
+    pub specs: BTreeMap<String, ActiveSpec>,
+    /// Spec files of the last applied commit that failed
+    pub failures: Vec<FileFailure>,
+}
+
+/// Reconciles [`ActiveSpecs`] with a branch of a spec repository
+pub struct Agent {
+    repo: String,
+    branch: String,
+    /// Working copy the agent owns; it is reset to the branch head
+    checkout: PathBuf,
+    /// Directory of the specs within the repository
+    path: Option<PathBuf>,
+    limits: Option<Limits>,
+    active: Arc<ActiveSpecs>,
+    status: Mutex<Status>,
+    /// ID of the spec each file of the last applied commit held
+    files: Mutex<HashMap<String, String>>,
+}
+
+impl Agent {
+    /// Agent for `branch` of `repo` (any URL or path `git clone` takes),
+    /// keeping its working copy in `checkout`
+    pub fn new(repo: impl Into<String>, branch: impl Into<String>, checkout: PathBuf) -> Self {
+        let (repo, branch) = (repo.into(), branch.into());
+        Self {
+            status: Mutex::new(Status {
+                repo: repo.clone(),
+                branch: branch.clone(),
+                ..Default::default()
+            }),
+            repo,
+            branch,
+            checkout,
+            path: None,
+            limits: None,
+            active: Arc::default(),
+            files: Mutex::default(),
+        }
+    }
+
+    /// Watch only the specs under `path` in the repository
+    pub fn with_path(mut self, path: impl Into<PathBuf>) -> Self {
+        self.path = Some(path.into());
+        self
+    }
+
+    /// Refuse commits whose specs exceed `limits`, and evaluate within them
+    pub fn with_limits(mut self, limits: Limits) -> Self {
+        self.limits = Some(limits);
+        self
+    }
+
+    /// The engines the agent keeps up to date
+    pub fn active(&self) -> Arc<ActiveSpecs> {
+        Arc::clone(&self.active)
+    }
+
+    pub fn status(&self) -> Status {
+        self.status.lock().unwrap().clone()
+    }
+
+    /// Fetch the branch and apply its head if it moved
+    ///
+    /// Returns whether the active specs changed. Errors are also recorded
+    /// in the status.
+    pub fn reconcile(&self) -> Result<bool> {
+        let result = self
+            .fetch()
+            .and_then(|commit| self.apply(&commit, &self.spec_dir()));
+        if let Err(e) = &result {
+            let mut status = self.status.lock().unwrap();
+            status.state = State::Failed;
+            status.error = Some(e.to_string());
+            status.last_reconciled_at = Some(chrono::Utc::now().to_rfc3339());
+        }
+        result
+    }
+
+    /// Reconcile every `interval`, forever, handing each outcome to
+    /// `report`
+    pub fn run(&self, interval: Duration, mut report: impl FnMut(&Result<bool>)) -> ! {
+        loop {
+            report(&self.reconcile());
+            std::thread::sleep(interval);
+        }
+    }
+
+    fn spec_dir(&self) -> PathBuf {
+        match &self.path {
+            Some(path) => self.checkout.join(path),
+            None => self.checkout.clone(),
+        }
+    }
+
+    /// Bring the working copy to the branch head, returning its commit
+    fn fetch(&self) -> Result<String> {
+        if !self.checkout.join(".git").exists() {
+            git(
+                None,
+                &[
+                    "clone",
+                    "--quiet",
+                    "--single-branch",
+                    "--branch",
+                    &self.branch,
+                    &self.repo,
+                    &self.checkout.to_string_lossy(),
+                ],
+            )?;
+        } else {
+            let dir = Some(self.checkout.as_path());
+            git(dir, &["fetch", "--quiet", "origin", &self.branch])?;
+            git(
+                dir,
+                &["checkout", "--quiet", "--force", "--detach", "FETCH_HEAD"],
+            )?;
+        }
+        Ok(git(Some(&self.checkout), &["rev-parse", "HEAD"])?
+            .trim()
+            .to_string())
+    }
+
+    /// Make the specs in `dir` (the tree of `commit`) active, unless that
+    /// commit is active already
+    fn apply(&self, commit: &str, dir: &Path) -> Result<bool> {
+        if self.status().last_applied.as_deref() == Some(commit) {
+            let mut status = self.status.lock().unwrap();
+            status.state = settled(&status.failures);
+            status.error = failure_summary(&status.failures);
+            status.last_attempted = Some(commit.to_string());
+            status.last_reconciled_at = Some(chrono::Utc::now().to_rfc3339());
+            return Ok(false);
+        }
+        self.status.lock().unwrap().last_attempted = Some(commit.to_string());
+
+        let short = &commit[..commit.len().min(7)];
+        let (specs, mut failures) =
+            load_specs(dir).map_err(|e| Error::Other(format!("{}: {}", short, e)))?;
+        let mut engines = HashMap::new();
+        let mut active = BTreeMap::new();
+        let mut files = HashMap::new();
+        for (file, spec) in specs {
+            let id = spec.id.clone();
+            let version = ActiveSpec {
+                version: spec.meta.version.clone(),
+                hash: spec.hash(),
+            };
+            let engine = match self.limits {
+                Some(limits) => match Engine::new(spec).with_limits(limits) {
+                    Ok(engine) => engine,
+                    Err(e) => {
+                        failures.push(FileFailure {
+                            file,
+                            spec: Some(id),
+                            error: e.to_string(),
+                        });
+                        continue;
+                    }
+                },
+                None => Engine::new(spec),
+            };
+            engines.insert(id.clone(), Arc::new(engine));
+            files.insert(file, id.clone());
+            active.insert(id, version);
+        }
+
+        // A spec whose file failed keeps its last good version. Broken YAML
+        // names no spec, so the one the file held before stands in
+        let previous = self.active.snapshot();
+        let previous_specs = self.status().specs;
+        let mut previous_files = self.files.lock().unwrap();
+        for failure in &mut failures {
+            if failure.spec.is_none() {
+                failure.spec = previous_files.get(&failure.file).cloned();
+            }
+            let Some(id) = &failure.spec else {
+                continue;
+            };
+            files.insert(failure.file.clone(), id.clone());
+            if let (false, Some(engine), Some(version)) = (
+                engines.contains_key(id),
+                previous.get(id),
+                previous_specs.get(id),
+            ) {
+                engines.insert(id.clone(), Arc::clone(engine));
+                active.insert(id.clone(), version.clone());
+            }
+        }
+
+        self.active.replace(engines);
+        *previous_files = files;
+        let mut status = self.status.lock().unwrap();
+        status.state = settled(&failures);
+        status.error = failure_summary(&failures);
+        status.last_applied = Some(commit.to_string());
+        status.last_reconciled_at = Some(chrono::Utc::now().to_rfc3339());
+        status.specs = active;
+        status.failures = failures;
+        Ok(true)
+    }
+}
+
+fn settled(failures: &[FileFailure]) -> State {
+    if failures.is_empty() {
+        State::Synced
+    } else {
+        State::Degraded
+    }
+}
+
+fn failure_summary(failures: &[FileFailure]) -> Option<String> {
+    match failures {
+        [] => None,
+        [failure] => Some(format!("{}: {}", failure.file, failure.error)),
+        _ => Some(format!(
+            "{} spec files failed: {}",
+            failures.len(),
+            failures
+                .iter()
+                .map(|f| f.file.as_str())
+                .collect::<Vec<_>>()
+                .join(", ")
+        )),
+    }
+}
+
+/// The specs under `dir` by file, and the spec files that failed to load,
+/// validate or have an ID another file already took
+fn load_specs(dir: &Path) -> Result<(Vec<(String, Spec)>, Vec<FileFailure>)> {
+    let mut files = Vec::new();
+    let mut dirs = vec![dir.to_path_buf()];
+    while let Some(dir) = dirs.pop() {
+        for entry in std::fs::read_dir(&dir).map_err(Error::Io)? {
+            let path = entry.map_err(Error::Io)?.path();
+            let name = path
+                .file_name()
+                .map(|n| n.to_string_lossy().into_owned())
+                .unwrap_or_default();
+            // Hidden directories, and the constant overrides of environments
+            if name.starts_with('.') || (name == "overrides" && path.is_dir()) {
+                continue;
+            }
+            if path.is_dir() {
+                dirs.push(path);
+            } else if (name.ends_with(".yaml") || name.ends_with(".yml")) && name != "config.yaml" {
+                files.push(path);
+            }
+        }
+    }
+    files.sort();
+
+    // Read each candidate once, keeping the ones that are specs and noting
+    // the data files their tables read
+    let mut candidates = Vec::new();
+    let mut table_files = HashSet::new();
+    for file in files {
+        let yaml = std::fs::read_to_string(&file).map_err(Error::Io)?;
+        let id = match serde_norway::from_str::<serde_norway::Value>(&yaml) {
+            Ok(body) => {
+                let Some(id) = body.get("id").and_then(|id| id.as_str()) else {
+                    continue;
+                };
+                let base = file.parent().unwrap_or(dir);
+                for table in body
+                    .get("tables")
+                    .and_then(|t| t.as_sequence())
+                    .into_iter()
+                    .flatten()
+                {
+                    if let Some(data) = table.get("file").and_then(|f| f.as_str()) {
+                        table_files.insert(canonical(&base.join(data)));
+                    }
+                }
+                Some(id.to_string())
+            }
+            // Broken YAML is reported, as it may well be a spec
+            Err(_) => None,
+        };
+        let flow = yaml.contains("\nchain:") || yaml.contains("\nuses:");
+        let template = crate::instantiate::declared(&yaml).is_ok_and(|p| !p.is_empty());
+        if !flow && !template {
+            candidates.push((file, id));
+        }
+    }
+
+    let mut specs: Vec<(String, Spec)> = Vec::new();
+    let mut failures = Vec::new();
+    for (file, id) in candidates {
+        if table_files.contains(&canonical(&file)) {
+            continue;
+        }
+        let relative = file
+            .strip_prefix(dir)
+            .unwrap_or(&file)
+            .display()
+            .to_string();
+        let loaded = Spec::from_file(&file).and_then(|spec| {
+            let errors: Vec<String> = spec
+                .validate()
+                .into_iter()
+                .filter(|e| !e.starts_with("Warning"))
+                .collect();
+            if !errors.is_empty() {
+                return Err(Error::Other(format!("invalid: {}", errors.join("; "))));
+            }
+            if let Some((other, _)) = specs.iter().find(|(_, s)| s.id == spec.id) {
+                return Err(Error::Other(format!(
+                    "spec '{}' is already defined in {}",
+                    spec.id, other
+                )));
+            }
+            Ok(spec)
+        });
+        match loaded {
+            Ok(spec) => specs.push((relative, spec)),
+            Err(e) => failures.push(FileFailure {
+                file: relative,
+                spec: id,
+                error: e.to_string(),
+            }),
+        }
+    }
+    Ok((specs, failures))
+}
+
+fn canonical(path: &Path) -> PathBuf {
+    path.canonicalize().unwrap_or_else(|_| path.to_path_buf())
+}
+
+fn git(dir: Option<&Path>, args: &[&str]) -> Result<String> {
+    let mut command = Command::new("git");
+    command.args(args);
+    if let Some(dir) = dir {
+        command.current_dir(dir);
+    }
+    let output = command
+        .output()
+        .map_err(|e| Error::Other(format!("Failed to run git: {}", e)))?;
+    if !output.status.success() {
+        return Err(Error::Other(format!(
+            "git {}: {}",
+            args.first().unwrap_or(&""),
+            String::from_utf8_lossy(&output.stderr).trim()
+        )));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+/// How long [`serve_status`] waits on a client, so one that never sends
+/// its request cannot hold up the others
+const CLIENT_TIMEOUT: Duration = Duration::from_secs(5);
+
+/// Answer `GET /status` and `GET /healthz` for `agent` on `listener`, one
+/// request at a time on a background thread
+pub fn serve_status(agent: Arc<Agent>, listener: TcpListener) -> std::thread::JoinHandle<()> {
+    std::thread::spawn(move || {
+        for stream in listener.incoming() {
+            let Ok(mut stream) = stream else {
+                continue;
+            };
+            if stream.set_read_timeout(Some(CLIENT_TIMEOUT)).is_err()
+                || stream.set_write_timeout(Some(CLIENT_TIMEOUT)).is_err()
+            {
+                continue;
+            }
+            let mut request_line = String::new();
+            if BufReader::new(&stream)
+                .read_line(&mut request_line)
+                .is_err()
+            {
+                continue;
+            }
+            let mut parts = request_line.split_whitespace();
+            let (method, path) = (parts.next().unwrap_or(""), parts.next().unwrap_or(""));
+
+            let status = agent.status();
+            let (code, content_type, body) = match (method, path) {
+                ("GET", "/status") => (
+                    "200 OK",
+                    "application/json",
+                    serde_json::to_string_pretty(&status).unwrap_or_default(),
+                ),
+                ("GET", "/healthz") if status.state == State::Synced => {
+                    ("200 OK", "text/plain", "synced\n".to_string())
+                }
+                ("GET", "/healthz") => (
+                    "503 Service Unavailable",
+                    "text/plain",
+                    format!(
+                        "{}\n",
+                        status
+                            .error
+                            .unwrap_or_else(|| "not reconciled yet".to_string())
+                    ),
+                ),
+                _ => ("404 Not Found", "text/plain", "not found\n".to_string()),
+            };
+            let _ = write!(
+                stream,
+                "HTTP/1.1 {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+                code,
+                content_type,
+                body.len(),
+                body
+            );
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::runtime::to_cel_value;
+    use crate::spec::ConditionValue;
+    use std::io::Read;
+    use std::net::TcpStream;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+meta:
+  version: 1.0.0
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+default: 5.0
+"#;
+
+    fn rate(active: &ActiveSpecs, weight: f64) -> ConditionValue {
+        let input = Values::from([(
+            "weight_kg".to_string(),
+            to_cel_value(&ConditionValue::Float(weight)),
+        )]);
+        let evaluation = active.evaluate("shipping_rate", &input).unwrap();
+        crate::runtime::from_cel_value(&evaluation.outputs["rate"])
+    }
+
+    #[test]
+    fn test_apply() {
+        let tree = tempfile::tempdir().unwrap();
+        std::fs::write(tree.path().join("shipping_rate.yaml"), SPEC).unwrap();
+        std::fs::write(tree.path().join("config.yaml"), "targets: [go]\n").unwrap();
+        let agent = Agent::new("git@example.com:rules.git", "main", tree.path().into());
+
+        assert!(agent.apply("a1b2c3d4", tree.path()).unwrap());
+        let status = agent.status();
+        assert_eq!(status.state, State::Synced);
+        assert_eq!(status.last_applied.as_deref(), Some("a1b2c3d4"));
+        assert_eq!(
+            status.specs["shipping_rate"].version.as_deref(),
+            Some("1.0.0")
+        );
+        assert_eq!(rate(&agent.active(), 19.0), ConditionValue::Float(5.0));
+
+        // The same commit again changes nothing
+        assert!(!agent.apply("a1b2c3d4", tree.path()).unwrap());
+
+        // A broken file is reported without holding back the other specs
+        let lowered = SPEC.replace("20.0", "18.0").replace("1.0.0", "1.0.1");
+        std::fs::write(tree.path().join("shipping_rate.yaml"), &lowered).unwrap();
+        std::fs::write(tree.path().join("broken.yaml"), "id: broken\nrules: [").unwrap();
+        assert!(agent.apply("e5f6a7b8", tree.path()).unwrap());
+        assert_eq!(rate(&agent.active(), 19.0), ConditionValue::Float(25.0));
+        let status = agent.status();
+        assert_eq!(status.state, State::Degraded);
+        assert_eq!(status.failures.len(), 1);
+        assert_eq!(status.failures[0].file, "broken.yaml");
+        assert!(status.error.unwrap().starts_with("broken.yaml: "));
+
+        // A spec whose file turns invalid keeps its last good version
+        let invalid = format!(
+            "{}  - id: HEAVY\n    when: \"weight_kg > 1.0\"\n    then: 1.0\n",
+            lowered.replace("default: 5.0\n", "")
+        ) + "default: 5.0\n";
+        std::fs::write(tree.path().join("shipping_rate.yaml"), invalid).unwrap();
+        assert!(agent.apply("c9d0e1f2", tree.path()).unwrap());
+        assert_eq!(rate(&agent.active(), 19.0), ConditionValue::Float(25.0));
+        let status = agent.status();
+        assert_eq!(
+            status.specs["shipping_rate"].version.as_deref(),
+            Some("1.0.1")
+        );
+        assert_eq!(status.failures.len(), 2);
+        assert_eq!(status.failures[1].spec.as_deref(), Some("shipping_rate"));
+
+        // So does one whose YAML no longer parses
+        std::fs::write(
+            tree.path().join("shipping_rate.yaml"),
+            "id: shipping_rate\nrules: [",
+        )
+        .unwrap();
+        assert!(agent.apply("b3c4d5e6", tree.path()).unwrap());
+        assert_eq!(rate(&agent.active(), 19.0), ConditionValue::Float(25.0));
+        let status = agent.status();
+        assert_eq!(status.failures[1].file, "shipping_rate.yaml");
+        assert_eq!(status.failures[1].spec.as_deref(), Some("shipping_rate"));
+        assert_eq!(
+            status.specs["shipping_rate"].version.as_deref(),
+            Some("1.0.1")
+        );
+
+        std::fs::remove_file(tree.path().join("broken.yaml")).unwrap();
+        std::fs::write(tree.path().join("shipping_rate.yaml"), SPEC).unwrap();
+        assert!(agent.apply("a7b8c9d0", tree.path()).unwrap());
+        assert_eq!(agent.status().state, State::Synced);
+        assert_eq!(rate(&agent.active(), 19.0), ConditionValue::Float(5.0));
+        assert_eq!(agent.active().ids(), vec!["shipping_rate"]);
+    }
+
+    #[test]
+    fn test_load_specs_as_render_does() {
+        let tree = tempfile::tempdir().unwrap();
+        let spec = SPEC.replace(
+            "rules:\n",
+            "tables:\n  - name: zone_rates\n    file: zone_rates.csv\n    type: float\nrules:\n",
+        );
+        std::fs::write(tree.path().join("shipping_rate.yaml"), spec).unwrap();
+        std::fs::write(tree.path().join("zone_rates.csv"), "key,value\neu,4.5\n").unwrap();
+        std::fs::create_dir(tree.path().join("overrides")).unwrap();
+        std::fs::write(
+            tree.path().join("overrides").join("staging.yaml"),
+            "shipping_rate:\n  heavy_kg: 18.0\n",
+        )
+        .unwrap();
+        std::fs::write(tree.path().join("ci.yaml"), "on: push\njobs: {}\n").unwrap();
+
+        let (specs, failures) = load_specs(tree.path()).unwrap();
+        assert_eq!(failures, vec![]);
+        assert_eq!(specs.len(), 1);
+        assert_eq!(specs[0].0, "shipping_rate.yaml");
+        assert_eq!(specs[0].1.tables[0].rows["eu"], ConditionValue::Float(4.5));
+    }
+
+    #[test]
+    fn test_duplicate_ids_are_refused() {
+        let tree = tempfile::tempdir().unwrap();
+        std::fs::create_dir(tree.path().join("eu")).unwrap();
+        std::fs::write(tree.path().join("shipping_rate.yaml"), SPEC).unwrap();
+        std::fs::write(tree.path().join("eu").join("shipping_rate.yaml"), SPEC).unwrap();
+        let (specs, failures) = load_specs(tree.path()).unwrap();
+        assert_eq!(specs.len(), 1);
+        assert_eq!(failures[0].file, "shipping_rate.yaml");
+        assert!(failures[0]
+            .error
+            .contains("spec 'shipping_rate' is already defined in eu/shipping_rate.yaml"));
+    }
+
+    #[test]
+    fn test_serve_status() {
+        let tree = tempfile::tempdir().unwrap();
+        std::fs::write(tree.path().join("shipping_rate.yaml"), SPEC).unwrap();
+        let agent = Arc::new(Agent::new("rules.git", "main", tree.path().into()));
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        serve_status(Arc::clone(&agent), listener);
+
+        let get = |path: &str| {
+            let mut stream = TcpStream::connect(addr).unwrap();
+            write!(stream, "GET {} HTTP/1.1\r\nHost: agent\r\n\r\n", path).unwrap();
+            let mut response = String::new();
+            stream.read_to_string(&mut response).unwrap();
+            response
+        };
+        assert!(get("/healthz").starts_with("HTTP/1.1 503"));
+
+        agent.apply("a1b2c3d4", tree.path()).unwrap();
+        assert!(get("/healthz").starts_with("HTTP/1.1 200"));
+        let status = get("/status");
+        assert!(status.contains("\"last_applied\": \"a1b2c3d4\""));
+        assert!(status.contains("\"state\": \"synced\""));
+        assert!(get("/metrics").starts_with("HTTP/1.1 404"));
+
+        std::fs::write(tree.path().join("broken.yaml"), "id: broken\nrules: [").unwrap();
+        agent.apply("e5f6a7b8", tree.path()).unwrap();
+        let health = get("/healthz");
+        assert!(health.starts_with("HTTP/1.1 503"));
+        assert!(health.contains("broken.yaml: "));
+    }
+}
@@ -0,0 +1,406 @@
+//! Spec assertions — behavioral properties checked at generation time
+//!
+//! Each [`Assertion`] is checked by evaluating the spec with
+//! [`crate::runtime`] on sampled inputs. Samples are built from the literals
+//! the spec mentions: every string literal for string inputs, and each
+//! number with its neighbours for numeric inputs, so both sides of every
+//! threshold are covered. Inputs that violate the spec's constraints are not
+//! valid inputs and are skipped.
+//!
+//! Small input spaces are enumerated exhaustively; larger ones are sampled
+//! deterministically up to [`MAX_SAMPLES`] combinations.
+
+use crate::cel::{CelCompiler, CelValue};
+use crate::error::{Error, Result};
+use crate::runtime::{self, to_cel_value, Values};
+use crate::spec::{Assertion, Spec, VarType, Variable};
+use regex::Regex;
+use std::collections::{BTreeMap, BTreeSet, HashMap};
+
+/// Most input combinations checked per assertion
+pub const MAX_SAMPLES: usize = 20_000;
+
+/// Outcome of checking one assertion
+#[derive(Debug, Clone, PartialEq)]
+pub struct AssertionResult {
+    /// Assertion ID
+    pub id: String,
+    /// Inputs the property was checked on
+    pub checked: usize,
+    /// First input the property does not hold for, and why
+    pub counterexample: Option<String>,
+}
+
+impl AssertionResult {
+    pub fn passed(&self) -> bool {
+        self.counterexample.is_none()
+    }
+}
+
+/// Check every assertion of a spec
+pub fn check(spec: &Spec) -> Result<Vec<AssertionResult>> {
+    if spec.assertions.is_empty() {
+        return Ok(Vec::new());
+    }
+    let literals = Literals::collect(spec);
+    spec.assertions
+        .iter()
+        .map(|assertion| check_assertion(spec, assertion, &literals))
+        .collect()
+}
+
+/// Check every assertion, failing with the counterexamples of those that
+/// do not hold
+pub fn enforce(spec: &Spec) -> Result<()> {
+    let failures: Vec<String> = check(spec)?
+        .into_iter()
+        .filter_map(|result| {
+            result
+                .counterexample
+                .map(|reason| format!("{}: {}", result.id, reason))
+        })
+        .collect();
+    if failures.is_empty() {
+        return Ok(());
+    }
+    Err(Error::Other(format!(
+        "Spec {} violates its assertions:\n  {}",
+        spec.id,
+        failures.join("\n  ")
+    )))
+}
+
+fn check_assertion(
+    spec: &Spec,
+    assertion: &Assertion,
+    literals: &Literals,
+) -> Result<AssertionResult> {
+    let varied = assertion.compare.as_ref().map(|c| c.input.as_str());
+    let domains: Vec<(&str, Vec<Option<CelValue>>)> = spec
+        .inputs
+        .iter()
+        .filter(|var| Some(var.name.as_str()) != varied)
+        .map(|var| Ok((var.name.as_str(), literals.domain(var)?)))
+        .collect::<Result<_>>()?;
+
+    let mut checked = 0;
+    for input in samples(&domains) {
+        let counterexample = match &assertion.compare {
+            None => {
+                if !applies(spec, assertion.given.as_deref(), &input)? {
+                    continue;
+                }
+                let scope = match runtime::evaluate(spec, &input) {
+                    Ok(evaluation) => {
+                        let mut scope = input.clone();
+                        scope.extend(evaluation.outputs);
+                        scope
+                    }
+                    Err(e) => return Ok(failed(assertion, &input, e.to_string(), checked)),
+                };
+                (!CelCompiler::eval_bool(&assertion.expect, &scope)?)
+                    .then(|| describe_outputs(spec, &scope))
+            }
+            Some(compare) => {
+                let mut left = input.clone();
+                left.insert(compare.input.clone(), to_cel_value(&compare.left));
+                let mut right = input.clone();
+                right.insert(compare.input.clone(), to_cel_value(&compare.right));
+                // `given` is checked on the left-hand input
+                if !applies(spec, assertion.given.as_deref(), &left)?
+                    || !applies(spec, None, &right)?
+                {
+                    continue;
+                }
+                let (left_result, right_result) = match (
+                    runtime::evaluate(spec, &left),
+                    runtime::evaluate(spec, &right),
+                ) {
+                    (Ok(l), Ok(r)) => (l, r),
+                    (Err(e), _) | (_, Err(e)) => {
+                        return Ok(failed(assertion, &input, e.to_string(), checked))
+                    }
+                };
+                let mut scope = input.clone();
+                scope.insert("left".into(), left_result.outputs.clone().into());
+                scope.insert("right".into(), right_result.outputs.clone().into());
+                (!CelCompiler::eval_bool(&assertion.expect, &scope)?).then(|| {
+                    format!(
+                        "left {}, right {}",
+                        describe_outputs(spec, &left_result.outputs),
+                        describe_outputs(spec, &right_result.outputs)
+                    )
+                })
+            }
+        };
+        checked += 1;
+        if let Some(reason) = counterexample {
+            return Ok(failed(assertion, &input, reason, checked));
+        }
+    }
+
+    Ok(AssertionResult {
+        id: assertion.id.clone(),
+        checked,
+        counterexample: None,
+    })
+}
+
+/// Whether an input is valid and within an assertion's `given`
+fn applies(spec: &Spec, given: Option<&str>, input: &Values) -> Result<bool> {
+    let scope = runtime::canonical_input(spec, input);
+    for constraint in &spec.constraints {
+        if !CelCompiler::eval_bool(&constraint.to_cel(), &scope)? {
+            return Ok(false);
+        }
+    }
+    match given {
+        Some(given) => CelCompiler::eval_bool(given, &scope),
+        None => Ok(true),
+    }
+}
+
+fn failed(
+    assertion: &Assertion,
+    input: &Values,
+    reason: String,
+    checked: usize,
+) -> AssertionResult {
+    AssertionResult {
+        id: assertion.id.clone(),
+        checked,
+        counterexample: Some(format!("fails for {}: {}", describe(input), reason)),
+    }
+}
+
+/// `{a: 1, b: "x"}` with names sorted
+fn describe(values: &Values) -> String {
+    let sorted: BTreeMap<_, _> = values.iter().collect();
+    let fields: Vec<String> = sorted
+        .iter()
+        .map(|(name, value)| format!("{}: {}", name, literal(value)))
+        .collect();
+    format!("{{{}}}", fields.join(", "))
+}
+
+/// The spec's outputs in a scope, as `rate = 2.5`
+fn describe_outputs(spec: &Spec, scope: &Values) -> String {
+    let names: Vec<String> = if spec.outputs.is_empty() {
+        vec!["result".to_string()]
+    } else {
+        spec.outputs.iter().map(|o| o.name.clone()).collect()
+    };
+    names
+        .iter()
+        .filter_map(|name| {
+            scope
+                .get(name)
+                .map(|value| format!("{} = {}", name, literal(value)))
+        })
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+pub(crate) fn literal(value: &CelValue) -> String {
+    match value {
+        CelValue::String(s) => format!("{:?}", s.as_str()),
+        CelValue::Int(i) => i.to_string(),
+        CelValue::UInt(u) => u.to_string(),
+        CelValue::Float(f) => format!("{:?}", f),
+        CelValue::Bool(b) => b.to_string(),
+        CelValue::Null => "null".to_string(),
+        other => format!("{:?}", other),
+    }
+}
+
+/// Literals mentioned anywhere in the spec's expressions
+struct Literals {
+    strings: BTreeSet<String>,
+    numbers: Vec<f64>,
+}
+
+impl Literals {
+    fn collect(spec: &Spec) -> Self {
+        let mut sources: Vec<String> = spec.rules.iter().filter_map(|r| r.as_cel()).collect();
+        sources.extend(spec.constraints.iter().map(|c| c.to_cel()));
+        for assertion in &spec.assertions {
+            sources.extend(assertion.given.clone());
+            sources.push(assertion.expect.clone());
+        }
+
+        let string_re = Regex::new(r#"'([^'\\]*)'|"([^"\\]*)""#).unwrap();
+        let number_re = Regex::new(r"\b\d+(\.\d+)?\b").unwrap();
+        let mut strings = BTreeSet::new();
+        let mut numbers = vec![0.0];
+        for source in &sources {
+            for captures in string_re.captures_iter(source) {
+                let text = captures.get(1).or_else(|| captures.get(2)).unwrap();
+                strings.insert(text.as_str().to_string());
+            }
+            let without_strings = string_re.replace_all(source, "''");
+            for found in number_re.find_iter(&without_strings) {
+                if let Ok(n) = found.as_str().parse::<f64>() {
+                    numbers.push(n);
+                }
+            }
+        }
+        numbers.sort_by(f64::total_cmp);
+        numbers.dedup();
+        Self { strings, numbers }
+    }
+
+    /// Values sampled for one input; `None` is an omitted optional input
+    fn domain(&self, var: &Variable) -> Result<Vec<Option<CelValue>>> {
+        let mut values: Vec<CelValue> = match &var.typ {
+            VarType::Bool => vec![false.into(), true.into()],
+            VarType::Enum(values) => values.iter().map(|v| v.clone().into()).collect(),
+            VarType::String => match &var.values {
+                Some(values) => values.iter().map(|v| v.clone().into()).collect(),
+                None => std::iter::once(String::new())
+                    .chain(self.strings.iter().cloned())
+                    .map(CelValue::from)
+                    .collect(),
+            },
+            VarType::Int => {
+                let ints: BTreeSet<i64> = self
+                    .numbers
+                    .iter()
+                    .flat_map(|n| {
+                        let n = *n as i64;
+                        [n - 1, n, n + 1]
+                    })
+                    .collect();
+                ints.into_iter().map(CelValue::from).collect()
+            }
+            VarType::Float => self
+                .numbers
+                .iter()
+                .flat_map(|n| [n - 0.5, *n, n + 0.5])
+                .map(CelValue::from)
+                .collect(),
+            VarType::List(_) | VarType::Object => {
+                return Err(Error::Other(format!(
+                    "Cannot sample input '{}': assertions support scalar inputs only",
+                    var.name
+                )))
+            }
+        };
+        values.dedup();
+        let mut domain: Vec<Option<CelValue>> = values.into_iter().map(Some).collect();
+        if var.optional {
+            domain.push(None);
+        }
+        Ok(domain)
+    }
+}
+
+/// Input combinations: all of them, or an evenly spread subset of
+/// [`MAX_SAMPLES`] when there are more
+fn samples<'a>(
+    domains: &'a [(&'a str, Vec<Option<CelValue>>)],
+) -> impl Iterator<Item = Values> + 'a {
+    let total: u128 = domains.iter().map(|(_, d)| d.len() as u128).product();
+    let count = total.min(MAX_SAMPLES as u128);
+    (0..count).map(move |i| {
+        // A fixed odd multiplier visits distinct combinations in a spread order
+        let mut index = if total > MAX_SAMPLES as u128 {
+            (i * 0x9E37_79B9_7F4A_7C15) % total
+        } else {
+            i
+        };
+        let mut input = HashMap::new();
+        for (name, domain) in domains {
+            let len = domain.len() as u128;
+            if let Some(value) = &domain[(index % len) as usize] {
+                input.insert(name.to_string(), value.clone());
+            }
+            index /= len;
+        }
+        input
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: tier
+    type: string
+  - name: zone
+    type: string
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "tier == 'gold' && zone == 'domestic'"
+    then: 0.0
+  - id: R2
+    when: "zone == 'domestic'"
+    then: "weight_kg * 2.0"
+  - id: R3
+    when: "weight_kg > 20.0"
+    then: 100.0
+default: "weight_kg * 3.0"
+constraints:
+  - id: positive_weight
+    require: "weight_kg > 0.0"
+assertions:
+  - id: gold_domestic_free
+    description: Gold domestic is always free regardless of weight
+    given: "tier == 'gold' && zone == 'domestic'"
+    expect: "rate == 0.0"
+  - id: intl_at_least_domestic
+    compare: { input: zone, left: intl, right: domestic }
+    expect: "left.rate >= right.rate"
+"#;
+
+    #[test]
+    fn test_assertions_hold() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let results = check(&spec).unwrap();
+        assert_eq!(results.len(), 2);
+        assert!(results.iter().all(|r| r.passed()), "{:?}", results);
+        assert!(results[0].checked > 0);
+        assert!(enforce(&spec).is_ok());
+    }
+
+    #[test]
+    fn test_assertion_counterexample() {
+        // Heavy international parcels are capped below the domestic rate
+        let spec = Spec::from_yaml(&SPEC.replace("then: 100.0", "then: 10.0")).unwrap();
+        let results = check(&spec).unwrap();
+        assert!(results[0].passed());
+        let counterexample = results[1].counterexample.as_deref().unwrap();
+        assert!(
+            counterexample.contains("weight_kg: 20.5"),
+            "{}",
+            counterexample
+        );
+        assert!(
+            counterexample.contains("left rate = 10.0"),
+            "{}",
+            counterexample
+        );
+
+        let err = enforce(&spec).unwrap_err().to_string();
+        assert!(err.contains("intl_at_least_domestic: fails for"));
+    }
+
+    #[test]
+    fn test_samples_cover_thresholds() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let literals = Literals::collect(&spec);
+        let weights = literals.domain(&spec.inputs[2]).unwrap();
+        for weight in [19.5, 20.0, 20.5] {
+            assert!(weights.contains(&Some(weight.into())));
+        }
+        let zones = literals.domain(&spec.inputs[1]).unwrap();
+        assert!(zones.contains(&Some("domestic".to_string().into())));
+    }
+}
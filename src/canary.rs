@@ -0,0 +1,300 @@
+//! Rule hit distribution shifts (`imacs canary`)
+//!
+//! Compares how often each rule decided in two slices of recorded
+//! decisions (the [`crate::replay`] format): two spec versions, when a
+//! canary runs next to the current rules, or two time windows, to see
+//! whether traffic moved between rules after a change or over time.
+
+use crate::error::{Error, Result};
+use crate::replay::{records, Record};
+use chrono::{DateTime, NaiveDate, Utc};
+use serde::Serialize;
+use std::collections::BTreeMap;
+
+/// Which records belong to a slice
+#[derive(Debug, Clone, PartialEq)]
+pub enum Selector {
+    /// Decided by the spec with this hash
+    Hash(String),
+    /// Decided by this spec version
+    Version(String),
+    /// Decided at or after `from` and before `to`
+    Window {
+        from: Option<DateTime<Utc>>,
+        to: Option<DateTime<Utc>>,
+    },
+}
+
+impl Selector {
+    /// `sha256:...` (a spec hash), `version:1.2.0`, or a time window
+    /// `FROM..TO` of RFC 3339 times or dates, either end open
+    pub fn parse(text: &str) -> Result<Self> {
+        if text.starts_with("sha256:") {
+            return Ok(Selector::Hash(text.to_string()));
+        }
+        if let Some(version) = text.strip_prefix("version:") {
+            return Ok(Selector::Version(version.to_string()));
+        }
+        let (from, to) = text.split_once("..").ok_or_else(|| {
+            Error::Other(format!(
+                "'{}': expected sha256:<hash>, version:<version> or <from>..<to>",
+                text
+            ))
+        })?;
+        let bound = |t: &str| -> Result<Option<DateTime<Utc>>> {
+            if t.is_empty() {
+                return Ok(None);
+            }
+            parse_time(t)
+                .map(Some)
+                .ok_or_else(|| Error::Other(format!("'{}' is not a date or RFC 3339 time", t)))
+        };
+        Ok(Selector::Window {
+            from: bound(from)?,
+            to: bound(to)?,
+        })
+    }
+
+    pub fn matches(&self, record: &Record) -> bool {
+        match self {
+            Selector::Hash(hash) => record.hash.as_ref() == Some(hash),
+            Selector::Version(version) => record.version.as_ref() == Some(version),
+            Selector::Window { from, to } => match record.at.as_deref().and_then(parse_time) {
+                Some(at) => from.is_none_or(|f| at >= f) && to.is_none_or(|t| at < t),
+                None => false,
+            },
+        }
+    }
+}
+
+fn parse_time(text: &str) -> Option<DateTime<Utc>> {
+    DateTime::parse_from_rfc3339(text)
+        .map(|t| t.with_timezone(&Utc))
+        .ok()
+        .or_else(|| {
+            NaiveDate::parse_from_str(text, "%Y-%m-%d")
+                .ok()
+                .and_then(|d| d.and_hms_opt(0, 0, 0))
+                .map(|t| t.and_utc())
+        })
+}
+
+/// How one rule's share of the decisions moved
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleShift {
+    /// Rule ID, `default` for the fallback
+    pub rule: String,
+    pub baseline: usize,
+    pub canary: usize,
+    /// Share of the slice's decisions, in percent
+    pub baseline_share: f64,
+    pub canary_share: f64,
+    /// Canary share minus baseline share, in percentage points
+    pub shift: f64,
+}
+
+/// Rule hit distributions of two slices of a recording
+#[derive(Debug, Clone, Serialize)]
+pub struct HitShift {
+    pub spec: Option<String>,
+    pub baseline: usize,
+    pub canary: usize,
+    /// By largest shift first
+    pub rules: Vec<RuleShift>,
+    /// Total variation distance between the distributions, in percentage
+    /// points: the share of decisions that would have to move rules for
+    /// the baseline to look like the canary
+    pub distance: f64,
+    /// Lines that are not decision records
+    pub invalid: usize,
+}
+
+impl HitShift {
+    /// Largest shift of any rule, in percentage points
+    pub fn max_shift(&self) -> f64 {
+        self.rules.iter().map(|r| r.shift.abs()).fold(0.0, f64::max)
+    }
+
+    /// Table of the rules, marking shifts of at least `threshold` points
+    pub fn to_report(&self, threshold: f64) -> String {
+        let mut out = format!(
+            "Rule hits{}: baseline {} decision(s), canary {} decision(s)\n",
+            self.spec
+                .as_ref()
+                .map(|s| format!(" of {}", s))
+                .unwrap_or_default(),
+            self.baseline,
+            self.canary
+        );
+        out.push_str(&format!(
+            "  {:<12} {:>16} {:>16} {:>9}\n",
+            "rule", "baseline", "canary", "shift"
+        ));
+        for r in &self.rules {
+            let flag = if r.shift.abs() >= threshold {
+                "  !"
+            } else {
+                ""
+            };
+            out.push_str(&format!(
+                "  {:<12} {:>8} {:>6.1}% {:>8} {:>6.1}% {:>+8.1}{}\n",
+                r.rule, r.baseline, r.baseline_share, r.canary, r.canary_share, r.shift, flag
+            ));
+        }
+        out.push_str(&format!(
+            "Distribution distance: {:.1} points\n",
+            self.distance
+        ));
+        if self.invalid > 0 {
+            out.push_str(&format!(
+                "{} line(s) were not decision records\n",
+                self.invalid
+            ));
+        }
+        out
+    }
+}
+
+/// Compare the rule hits of the `baseline` and `canary` slices of a
+/// recording, limited to one spec when `spec` is given
+pub fn hit_shift(
+    jsonl: &str,
+    spec: Option<&str>,
+    baseline: &Selector,
+    canary: &Selector,
+) -> HitShift {
+    let mut hits: BTreeMap<String, (usize, usize)> = BTreeMap::new();
+    let (mut baseline_total, mut canary_total, mut invalid) = (0, 0, 0);
+    for (_, record) in records(jsonl) {
+        let Ok(record) = record else {
+            invalid += 1;
+            continue;
+        };
+        if spec.is_some_and(|id| record.spec.as_deref() != Some(id)) {
+            continue;
+        }
+        let rule = record.rule_id.clone().unwrap_or_else(|| "default".into());
+        if baseline.matches(&record) {
+            hits.entry(rule.clone()).or_default().0 += 1;
+            baseline_total += 1;
+        }
+        if canary.matches(&record) {
+            hits.entry(rule).or_default().1 += 1;
+            canary_total += 1;
+        }
+    }
+
+    let share = |n: usize, total: usize| {
+        if total == 0 {
+            0.0
+        } else {
+            n as f64 * 100.0 / total as f64
+        }
+    };
+    let mut rules: Vec<RuleShift> = hits
+        .into_iter()
+        .map(|(rule, (b, c))| {
+            let baseline_share = share(b, baseline_total);
+            let canary_share = share(c, canary_total);
+            RuleShift {
+                rule,
+                baseline: b,
+                canary: c,
+                baseline_share,
+                canary_share,
+                shift: canary_share - baseline_share,
+            }
+        })
+        .collect();
+    rules.sort_by(|a, b| b.shift.abs().total_cmp(&a.shift.abs()));
+    let distance = rules.iter().map(|r| r.shift.abs()).sum::<f64>() / 2.0;
+
+    HitShift {
+        spec: spec.map(str::to_string),
+        baseline: baseline_total,
+        canary: canary_total,
+        rules,
+        distance,
+        invalid,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn record(hash: &str, at: &str, rule: Option<&str>) -> String {
+        let rule = rule
+            .map(|r| format!(", \"rule_id\": \"{}\"", r))
+            .unwrap_or_default();
+        format!(
+            "{{\"spec\": \"shipping_rate\", \"hash\": \"{}\", \"at\": \"{}\", \"input\": {{}}, \"output\": 1{}}}\n",
+            hash, at, rule
+        )
+    }
+
+    #[test]
+    fn test_selectors() {
+        assert_eq!(
+            Selector::parse("sha256:abc").unwrap(),
+            Selector::Hash("sha256:abc".into())
+        );
+        assert_eq!(
+            Selector::parse("version:1.2.0").unwrap(),
+            Selector::Version("1.2.0".into())
+        );
+        let window = Selector::parse("2026-10-01..2026-10-08T12:00:00Z").unwrap();
+        let r: Record =
+            serde_json::from_str(&record("h", "2026-10-08T11:59:59+00:00", None)).unwrap();
+        assert!(window.matches(&r));
+        let r: Record =
+            serde_json::from_str(&record("h", "2026-10-08T14:00:00+02:00", None)).unwrap();
+        assert!(!window.matches(&r));
+        assert!(Selector::parse("..2026-10-01").is_ok());
+        assert!(Selector::parse("last week").is_err());
+        assert!(Selector::parse("yesterday..").is_err());
+    }
+
+    #[test]
+    fn test_hit_shift_between_versions() {
+        let mut jsonl = String::new();
+        for _ in 0..6 {
+            jsonl.push_str(&record("sha256:old", "2026-10-01T00:00:00Z", Some("R1")));
+        }
+        for _ in 0..4 {
+            jsonl.push_str(&record("sha256:old", "2026-10-01T00:00:00Z", None));
+        }
+        for _ in 0..3 {
+            jsonl.push_str(&record("sha256:new", "2026-10-02T00:00:00Z", Some("R1")));
+        }
+        jsonl.push_str(&record("sha256:new", "2026-10-02T00:00:00Z", Some("R2")));
+        jsonl.push_str("garbage\n");
+
+        let shift = hit_shift(
+            &jsonl,
+            Some("shipping_rate"),
+            &Selector::parse("sha256:old").unwrap(),
+            &Selector::parse("sha256:new").unwrap(),
+        );
+        assert_eq!((shift.baseline, shift.canary, shift.invalid), (10, 4, 1));
+        let rules: Vec<(&str, f64)> = shift
+            .rules
+            .iter()
+            .map(|r| (r.rule.as_str(), r.shift))
+            .collect();
+        assert_eq!(rules, vec![("default", -40.0), ("R2", 25.0), ("R1", 15.0)]);
+        assert_eq!(shift.distance, 40.0);
+        assert_eq!(shift.max_shift(), 40.0);
+        let report = shift.to_report(20.0);
+        assert!(report.contains("Distribution distance: 40.0 points"));
+        assert_eq!(report.matches("  !").count(), 2);
+
+        let windows = hit_shift(
+            &jsonl,
+            None,
+            &Selector::parse("..2026-10-02").unwrap(),
+            &Selector::parse("2026-10-02..").unwrap(),
+        );
+        assert_eq!((windows.baseline, windows.canary), (10, 4));
+    }
+}
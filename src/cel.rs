@@ -9,7 +9,9 @@
 //! Generated code has no CEL dependency - only the compiled target language code.
 
 use crate::error::{Error, Result};
+use regex::Regex;
 use std::collections::HashMap;
+use std::sync::Arc;
 
 // cel-parser for AST-based compilation to target languages
 pub use cel_parser::Expression as CelExpr;
@@ -40,6 +42,135 @@ pub enum Target {
 /// CEL compiler - parses, evaluates, and renders to target languages
 pub struct CelCompiler;
 
+/// Rows of lookup tables by table name and key, for evaluating `lookup()`
+pub type Tables = HashMap<String, HashMap<String, CelValue>>;
+
+/// Text of a value as `bucket()` hashes it and `lookup()` keys on it
+fn value_text(value: &Value) -> String {
+    match value {
+        Value::String(s) => s.to_string(),
+        Value::Int(i) => i.to_string(),
+        Value::UInt(u) => u.to_string(),
+        Value::Bool(b) => b.to_string(),
+        Value::Float(f) => f.to_string(),
+        other => format!("{:?}", other),
+    }
+}
+
+/// Math functions of expressions and how many arguments each takes. They
+/// compute on floats: `abs`, `pow`, `sqrt`, `log` (natural) and
+/// `percentage(x, p)`, p percent of x.
+pub const MATH_FUNCTIONS: &[(&str, usize)] = &[
+    ("abs", 1),
+    ("pow", 2),
+    ("sqrt", 1),
+    ("log", 1),
+    ("percentage", 2),
+];
+
+/// Aggregate functions over a list input, or over one field of a list of
+/// objects (`sum(items.weight_kg)`): `sum`, `avg`, `min`, `max` and
+/// `count`. `min` and `max` aggregate with one argument; with two they
+/// compare scalars.
+pub const AGGREGATE_FUNCTIONS: &[&str] = &["sum", "avg", "min", "max", "count"];
+
+/// Functions expressions may call: CEL's built-ins and macros and the
+/// imacs helpers. Projects add their own (see [`crate::spec::CustomFunction`]).
+pub const FUNCTIONS: &[&str] = &[
+    "abs",
+    "all",
+    "avg",
+    "bucket",
+    "bytes",
+    "ceil",
+    "clamp",
+    "coalesce",
+    "contains",
+    "convert",
+    "convert_currency",
+    "count",
+    "double",
+    "duration",
+    "endsWith",
+    "exists",
+    "exists_one",
+    "filter",
+    "float",
+    "floor",
+    "format",
+    "getDate",
+    "getDayOfMonth",
+    "getDayOfWeek",
+    "getDayOfYear",
+    "getFullYear",
+    "getHours",
+    "getMilliseconds",
+    "getMinutes",
+    "getMonth",
+    "getSeconds",
+    "has",
+    "int",
+    "is_present",
+    "log",
+    "lookup",
+    "map",
+    "matches",
+    "max",
+    "min",
+    "percentage",
+    "pow",
+    "region",
+    "round",
+    "size",
+    "sqrt",
+    "startsWith",
+    "string",
+    "sum",
+    "timestamp",
+    "type",
+    "uint",
+    "zone",
+];
+
+/// The measures of an expression that bound the work of evaluating it
+/// (see [`CelCompiler::shape`])
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Shape {
+    /// Depth of the syntax tree; a lone literal or name is 1
+    pub depth: usize,
+    /// Most `all`/`exists`/`map`/`filter` loops inside one another
+    pub loops: usize,
+    /// Functions called, by name, in order
+    pub calls: Vec<String>,
+}
+
+/// A piece of a `format()` template: literal text or a `{name}`
+/// placeholder
+#[derive(Debug, Clone, PartialEq)]
+pub enum FormatPart {
+    Text(String),
+    Placeholder(String),
+}
+
+/// A numeric value as a float; NaN for anything else (specs that validate
+/// only pass numbers)
+fn number(value: &Value) -> f64 {
+    match value {
+        Value::Float(f) => *f,
+        Value::Int(i) => *i as f64,
+        Value::UInt(u) => *u as f64,
+        _ => f64::NAN,
+    }
+}
+
+/// The items of a list value as floats; empty for anything else
+fn numbers(value: &Value) -> Vec<f64> {
+    match value {
+        Value::List(items) => items.iter().map(number).collect(),
+        _ => Vec::new(),
+    }
+}
+
 /// Re-export cel-interpreter Value for use in evaluation
 pub use cel_interpreter::Value as CelValue;
 
@@ -61,10 +192,88 @@ impl CelCompiler {
     /// Evaluate a CEL expression with the given variable bindings
     /// Returns the evaluated Value
     pub fn eval(expr: &str, vars: &HashMap<String, CelValue>) -> Result<CelValue> {
+        Self::eval_with_tables(expr, vars, &Arc::default())
+    }
+
+    /// Evaluate a CEL expression whose `lookup()` calls read `tables`
+    pub fn eval_with_tables(
+        expr: &str,
+        vars: &HashMap<String, CelValue>,
+        tables: &Arc<Tables>,
+    ) -> Result<CelValue> {
+        // cel-interpreter cannot select a field of a list, so aggregates
+        // over `items.field` evaluate over `items.map(x, x.field)`
+        let projection =
+            Regex::new(r"\b(sum|avg|min|max|count)\(\s*([A-Za-z_]\w*)\.([A-Za-z_]\w*)\s*\)")
+                .expect("valid regex");
+        let expr = projection.replace_all(expr, "$1($2.map(agg_item, agg_item.$3))");
+        // format('{a}-{b}') evaluates as the concatenation it stands for
+        let format = Regex::new(r#"\bformat\(\s*(?:"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)')\s*\)"#)
+            .expect("valid regex");
+        let expr: &str = &format.replace_all(&expr, |caps: &regex::Captures| {
+            let (quote, template) = match caps.get(1) {
+                Some(m) => ('"', m.as_str()),
+                None => ('\'', caps.get(2).map_or("", |m| m.as_str())),
+            };
+            match Self::parse_format(template) {
+                Ok(parts) if parts.is_empty() => format!("{0}{0}", quote),
+                Ok(parts) => parts
+                    .iter()
+                    .map(|part| match part {
+                        FormatPart::Text(text) => format!("{0}{1}{0}", quote, text),
+                        FormatPart::Placeholder(name) => format!("string({})", name),
+                    })
+                    .collect::<Vec<_>>()
+                    .join(" + "),
+                Err(_) => caps[0].to_string(),
+            }
+        });
         let program =
             Program::compile(expr).map_err(|e| Error::CelParse(format!("{}: {:?}", expr, e)))?;
 
         let mut context = Context::default();
+        context.add_function("bucket", |key: Value, n: i64| {
+            Self::bucket(&value_text(&key), n)
+        });
+        context.add_function("abs", |x: Value| number(&x).abs());
+        context.add_function("pow", |x: Value, y: Value| number(&x).powf(number(&y)));
+        context.add_function("sqrt", |x: Value| number(&x).sqrt());
+        context.add_function("log", |x: Value| number(&x).ln());
+        context.add_function("percentage", |x: Value, p: Value| {
+            number(&x) * number(&p) / 100.0
+        });
+        context.add_function("sum", |list: Value| numbers(&list).iter().sum::<f64>());
+        context.add_function("avg", |list: Value| {
+            let values = numbers(&list);
+            if values.is_empty() {
+                0.0
+            } else {
+                values.iter().sum::<f64>() / values.len() as f64
+            }
+        });
+        context.add_function("count", |list: Value| numbers(&list).len() as i64);
+        context.add_function("region", |code: Arc<String>| {
+            crate::geo::region(&code).unwrap_or_default().to_string()
+        });
+        let zones = Arc::clone(tables);
+        context.add_function("zone", move |code: Arc<String>| {
+            zones
+                .get("zone")
+                .and_then(|rows| rows.get(code.as_str()))
+                .cloned()
+                .unwrap_or_else(|| Value::String(Arc::new(String::new())))
+        });
+        let tables = Arc::clone(tables);
+        context.add_function(
+            "lookup",
+            move |table: Arc<String>, key: Value, fallback: Value| {
+                tables
+                    .get(table.as_str())
+                    .and_then(|rows| rows.get(&value_text(&key)))
+                    .cloned()
+                    .unwrap_or(fallback)
+            },
+        );
         for (name, value) in vars {
             context.add_variable_from_value(name.clone(), value.clone());
         }
@@ -76,7 +285,16 @@ impl CelCompiler {
 
     /// Evaluate a CEL expression and return result as bool
     pub fn eval_bool(expr: &str, vars: &HashMap<String, CelValue>) -> Result<bool> {
-        let result = Self::eval(expr, vars)?;
+        Self::eval_bool_with_tables(expr, vars, &Arc::default())
+    }
+
+    /// Evaluate a CEL expression reading `tables`, as a bool
+    pub fn eval_bool_with_tables(
+        expr: &str,
+        vars: &HashMap<String, CelValue>,
+        tables: &Arc<Tables>,
+    ) -> Result<bool> {
+        let result = Self::eval_with_tables(expr, vars, tables)?;
         match result {
             Value::Bool(b) => Ok(b),
             other => Err(Error::CelEval(format!(
@@ -151,6 +369,18 @@ impl CelCompiler {
                 Self::collect_variables(&select.operand, vars);
             }
             Expr::Call(call) => {
+                // Placeholders of a format() template read variables too
+                if call.func_name == "format" {
+                    let template = call.args.first().and_then(Self::string_literal);
+                    for part in template
+                        .and_then(|t| Self::parse_format(&t).ok())
+                        .unwrap_or_default()
+                    {
+                        if let FormatPart::Placeholder(name) = part {
+                            vars.push(name);
+                        }
+                    }
+                }
                 // Function calls or operators
                 // Collect from all arguments
                 for arg in &call.args {
@@ -175,6 +405,396 @@ impl CelCompiler {
         }
     }
 
+    /// Names from `optional` that the expression reads outside of
+    /// `is_present(x)` / `coalesce(x, default)`
+    pub fn unguarded_references(expr: &str, optional: &[&str]) -> Result<Vec<String>> {
+        let ast = Self::parse(expr)?;
+        let mut vars = Vec::new();
+        Self::collect_unguarded(&ast, optional, &mut vars);
+        vars.sort();
+        vars.dedup();
+        Ok(vars)
+    }
+
+    fn collect_unguarded(expr: &CelExpr, optional: &[&str], vars: &mut Vec<String>) {
+        match &expr.expr {
+            Expr::Ident(name) => {
+                if optional.contains(&name.as_str()) {
+                    vars.push(name.to_string());
+                }
+            }
+            Expr::Select(select) => Self::collect_unguarded(&select.operand, optional, vars),
+            Expr::Call(call) => {
+                let guarded = call.target.is_none()
+                    && matches!(call.func_name.as_str(), "is_present" | "coalesce");
+                for (i, arg) in call.args.iter().enumerate() {
+                    if guarded && i == 0 && matches!(arg.expr, Expr::Ident(_)) {
+                        continue;
+                    }
+                    Self::collect_unguarded(arg, optional, vars);
+                }
+                if let Some(target) = call.target.as_ref() {
+                    Self::collect_unguarded(target, optional, vars);
+                }
+            }
+            Expr::List(list) => {
+                for item in &list.elements {
+                    Self::collect_unguarded(item, optional, vars);
+                }
+            }
+            _ => {}
+        }
+    }
+
+    /// `bucket(key, n)`: which of `n` buckets (0 to n - 1) a key falls in,
+    /// by the 32-bit FNV-1a hash of its text. Every target computes the
+    /// same bucket, so percentage rollouts are reproducible across runs,
+    /// languages and tests.
+    pub fn bucket(key: &str, n: i64) -> i64 {
+        if n <= 0 {
+            return 0;
+        }
+        let hash = key
+            .bytes()
+            .fold(2166136261u32, |h, b| (h ^ b as u32).wrapping_mul(16777619));
+        hash as i64 % n
+    }
+
+    /// The `bucket()` calls of an expression: the key when it is an
+    /// identifier, the bucket count when it is an integer literal
+    pub fn bucket_calls(expr: &str) -> Result<Vec<(Option<String>, Option<i64>)>> {
+        let mut calls = Vec::new();
+        Self::visit_calls(&Self::parse(expr)?, "bucket", &mut |args| {
+            let n = args.get(1).and_then(Self::int_literal);
+            calls.push((Self::ident(args.first()), n.filter(|_| args.len() == 2)));
+        });
+        Ok(calls)
+    }
+
+    /// The `lookup()` calls of an expression: the table when named by a
+    /// string literal, the key when it is an identifier, and the number of
+    /// arguments
+    pub fn lookup_calls(expr: &str) -> Result<Vec<(Option<String>, Option<String>, usize)>> {
+        let mut calls = Vec::new();
+        Self::visit_calls(&Self::parse(expr)?, "lookup", &mut |args| {
+            let table = args.first().and_then(Self::string_literal);
+            calls.push((table, Self::ident(args.get(1)), args.len()));
+        });
+        Ok(calls)
+    }
+
+    /// The math function calls of an expression: the function, the number
+    /// of arguments, and the arguments that are identifiers
+    pub fn math_calls(expr: &str) -> Result<Vec<(&'static str, usize, Vec<String>)>> {
+        let ast = Self::parse(expr)?;
+        let mut calls = Vec::new();
+        for (name, _) in MATH_FUNCTIONS {
+            Self::visit_calls(&ast, name, &mut |args| {
+                let idents = args.iter().filter_map(|a| Self::ident(Some(a))).collect();
+                calls.push((*name, args.len(), idents));
+            });
+        }
+        Ok(calls)
+    }
+
+    /// The aggregate calls of an expression: the function, the number of
+    /// arguments, and the list they read when it is an identifier or a
+    /// field of one (`items.weight_kg` reads `items`, field `weight_kg`).
+    /// Two-argument `min` and `max` compare scalars and are not included.
+    pub fn aggregate_calls(
+        expr: &str,
+    ) -> Result<Vec<(&'static str, usize, Option<(String, Option<String>)>)>> {
+        let ast = Self::parse(expr)?;
+        let mut calls = Vec::new();
+        for name in AGGREGATE_FUNCTIONS {
+            Self::visit_calls(&ast, name, &mut |args| {
+                if matches!(*name, "min" | "max") && args.len() == 2 {
+                    return;
+                }
+                let list = match args.first().map(|a| &a.expr) {
+                    Some(Expr::Select(select)) => Self::ident(Some(&select.operand))
+                        .map(|list| (list, Some(select.field.to_string()))),
+                    _ => Self::ident(args.first()).map(|list| (list, None)),
+                };
+                calls.push((*name, args.len(), list));
+            });
+        }
+        Ok(calls)
+    }
+
+    /// The `region()` and `zone()` calls of an expression, with their
+    /// number of arguments
+    pub fn geo_calls(expr: &str) -> Result<Vec<(&'static str, usize)>> {
+        let ast = Self::parse(expr)?;
+        let mut calls = Vec::new();
+        for name in ["region", "zone"] {
+            Self::visit_calls(&ast, name, &mut |args| calls.push((name, args.len())));
+        }
+        Ok(calls)
+    }
+
+    /// The calls of function `name` in an expression: for each, its
+    /// arguments, as the identifier each one is, if it is one
+    pub fn function_calls(expr: &str, name: &str) -> Result<Vec<Vec<Option<String>>>> {
+        let mut calls = Vec::new();
+        Self::visit_calls(&Self::parse(expr)?, name, &mut |args| {
+            calls.push(args.iter().map(|a| Self::ident(Some(a))).collect());
+        });
+        Ok(calls)
+    }
+
+    /// Split a `format()` template into text and `{name}` placeholders;
+    /// `{{` and `}}` stand for literal braces
+    pub fn parse_format(template: &str) -> Result<Vec<FormatPart>> {
+        let mut parts = Vec::new();
+        let mut text = String::new();
+        let mut chars = template.chars().peekable();
+        while let Some(c) = chars.next() {
+            match c {
+                '{' if chars.peek() == Some(&'{') => {
+                    chars.next();
+                    text.push('{');
+                }
+                '}' if chars.peek() == Some(&'}') => {
+                    chars.next();
+                    text.push('}');
+                }
+                '{' => {
+                    let mut name = String::new();
+                    let mut closed = false;
+                    for c in chars.by_ref() {
+                        if c == '}' {
+                            closed = true;
+                            break;
+                        }
+                        name.push(c);
+                    }
+                    let valid = closed
+                        && name.starts_with(|c: char| c.is_ascii_alphabetic() || c == '_')
+                        && name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_');
+                    if !valid {
+                        let close = if closed { "}" } else { "" };
+                        return Err(Error::CelParse(format!(
+                            "'{}' has an invalid placeholder '{{{}{}' (write {{name}})",
+                            template, name, close
+                        )));
+                    }
+                    if !text.is_empty() {
+                        parts.push(FormatPart::Text(std::mem::take(&mut text)));
+                    }
+                    parts.push(FormatPart::Placeholder(name));
+                }
+                '}' => {
+                    return Err(Error::CelParse(format!(
+                        "'{}' has an unmatched '}}' (write '}}}}' for a brace)",
+                        template
+                    )))
+                }
+                c => text.push(c),
+            }
+        }
+        if !text.is_empty() {
+            parts.push(FormatPart::Text(text));
+        }
+        Ok(parts)
+    }
+
+    /// The `format()` calls of an expression: the template when it is a
+    /// string literal, and the number of arguments
+    pub fn format_calls(expr: &str) -> Result<Vec<(Option<String>, usize)>> {
+        let mut calls = Vec::new();
+        Self::visit_calls(&Self::parse(expr)?, "format", &mut |args| {
+            calls.push((args.first().and_then(Self::string_literal), args.len()));
+        });
+        Ok(calls)
+    }
+
+    /// Call `f` with the arguments of every global call to `name`
+    fn visit_calls(expr: &CelExpr, name: &str, f: &mut dyn FnMut(&[CelExpr])) {
+        match &expr.expr {
+            Expr::Select(select) => Self::visit_calls(&select.operand, name, f),
+            Expr::Call(call) => {
+                if call.target.is_none() && call.func_name == name {
+                    f(&call.args);
+                }
+                for arg in call.args.iter().chain(call.target.as_deref()) {
+                    Self::visit_calls(arg, name, f);
+                }
+            }
+            Expr::List(list) => {
+                for item in &list.elements {
+                    Self::visit_calls(item, name, f);
+                }
+            }
+            _ => {}
+        }
+    }
+
+    fn ident(expr: Option<&CelExpr>) -> Option<String> {
+        match expr.map(|e| &e.expr) {
+            Some(Expr::Ident(name)) => Some(name.to_string()),
+            _ => None,
+        }
+    }
+
+    /// Depth, loop nesting and called functions of an expression's syntax
+    /// tree
+    pub fn shape(expr: &str) -> Result<Shape> {
+        // Depth of the subtree at `expr`, inside `loops` comprehensions
+        fn walk(expr: &CelExpr, loops: usize, shape: &mut Shape) -> usize {
+            let deepest = |children: &[&CelExpr], loops: usize, shape: &mut Shape| {
+                children
+                    .iter()
+                    .map(|child| walk(child, loops, shape))
+                    .max()
+                    .unwrap_or(0)
+            };
+            1 + match &expr.expr {
+                Expr::Select(select) => {
+                    let operand: [&CelExpr; 1] = [&select.operand];
+                    deepest(&operand, loops, shape)
+                }
+                Expr::Call(call) => {
+                    // Operators (`_+_`, `!_`, `@in`) are not functions
+                    if call.func_name.starts_with(|c: char| c.is_alphabetic()) {
+                        shape.calls.push(call.func_name.clone());
+                    }
+                    let children: Vec<&CelExpr> =
+                        call.args.iter().chain(call.target.as_deref()).collect();
+                    deepest(&children, loops, shape)
+                }
+                Expr::List(list) => {
+                    let children: Vec<&CelExpr> = list.elements.iter().collect();
+                    deepest(&children, loops, shape)
+                }
+                // all/exists/exists_one/map/filter expand to a loop
+                Expr::Comprehension(comprehension) => {
+                    shape.loops = shape.loops.max(loops + 1);
+                    let range: [&CelExpr; 1] = [&comprehension.iter_range];
+                    let body: [&CelExpr; 4] = [
+                        &comprehension.accu_init,
+                        &comprehension.loop_cond,
+                        &comprehension.loop_step,
+                        &comprehension.result,
+                    ];
+                    let range = deepest(&range, loops, shape);
+                    range.max(deepest(&body, loops + 1, shape))
+                }
+                _ => 0,
+            }
+        }
+        let mut shape = Shape::default();
+        let depth = walk(&Self::parse(expr)?, 0, &mut shape);
+        shape.depth = depth;
+        Ok(shape)
+    }
+
+    /// Number of top-level `&&` conjuncts in an expression
+    pub fn conjunct_count(expr: &str) -> Result<usize> {
+        fn count(expr: &CelExpr) -> usize {
+            match &expr.expr {
+                Expr::Call(call) if call.func_name == operators::LOGICAL_AND => {
+                    call.args.iter().map(count).sum()
+                }
+                _ => 1,
+            }
+        }
+        Ok(count(&Self::parse(expr)?))
+    }
+
+    /// Source text of each top-level `&&` conjunct, with redundant outer
+    /// parentheses removed (`a && (b && c)` gives `a`, `b`, `c`)
+    pub fn conjuncts(expr: &str) -> Result<Vec<String>> {
+        fn strip_parens(expr: &str) -> &str {
+            let mut expr = expr.trim();
+            while expr.starts_with('(') && matching_paren(expr) == Some(expr.len() - 1) {
+                expr = expr[1..expr.len() - 1].trim();
+            }
+            expr
+        }
+
+        // Byte index of the paren closing the one at index 0
+        fn matching_paren(expr: &str) -> Option<usize> {
+            let mut depth = 0;
+            let mut quote = None;
+            let mut escaped = false;
+            for (i, c) in expr.char_indices() {
+                if let Some(q) = quote {
+                    if escaped {
+                        escaped = false;
+                    } else if c == '\\' {
+                        escaped = true;
+                    } else if c == q {
+                        quote = None;
+                    }
+                    continue;
+                }
+                match c {
+                    '\'' | '"' => quote = Some(c),
+                    '(' | '[' | '{' => depth += 1,
+                    ')' | ']' | '}' => {
+                        depth -= 1;
+                        if depth == 0 {
+                            return Some(i);
+                        }
+                    }
+                    _ => {}
+                }
+            }
+            None
+        }
+
+        // Split at `sep` outside brackets and string literals
+        fn split_top_level<'a>(expr: &'a str, sep: &str) -> Vec<&'a str> {
+            let mut parts = Vec::new();
+            let mut depth = 0i32;
+            let mut quote = None;
+            let mut escaped = false;
+            let mut start = 0;
+            for (i, c) in expr.char_indices() {
+                if let Some(q) = quote {
+                    if escaped {
+                        escaped = false;
+                    } else if c == '\\' {
+                        escaped = true;
+                    } else if c == q {
+                        quote = None;
+                    }
+                    continue;
+                }
+                match c {
+                    '\'' | '"' => quote = Some(c),
+                    '(' | '[' | '{' => depth += 1,
+                    ')' | ']' | '}' => depth -= 1,
+                    _ if depth == 0 && i >= start && expr[i..].starts_with(sep) => {
+                        parts.push(&expr[start..i]);
+                        start = i + sep.len();
+                    }
+                    _ => {}
+                }
+            }
+            parts.push(&expr[start..]);
+            parts
+        }
+
+        fn collect(expr: &str, out: &mut Vec<String>) {
+            let expr = strip_parens(expr);
+            let parts = split_top_level(expr, "&&");
+            if parts.len() <= 1 {
+                out.push(expr.to_string());
+                return;
+            }
+            for part in parts {
+                collect(part, out);
+            }
+        }
+
+        Self::parse(expr)?;
+        let mut out = Vec::new();
+        collect(expr, &mut out);
+        Ok(out)
+    }
+
     /// PY-4: Validate that all variables in a CEL expression are defined
     pub fn validate_variables(expr: &str, valid_names: &[&str]) -> Result<()> {
         let referenced = Self::extract_variables(expr)?;
@@ -340,6 +960,11 @@ impl CelCompiler {
                             Target::Python => {
                                 format!("({} if {} else {})", if_true, cond, if_false)
                             }
+                            Target::Rust => {
+                                format!("(if {} {{ {} }} else {{ {} }})", cond, if_true, if_false)
+                            }
+                            // Go has no conditional operator; the spec template emits ifElse
+                            Target::Go => format!("ifElse({}, {}, {})", cond, if_true, if_false),
                             _ => format!("({} ? {} : {})", cond, if_true, if_false),
                         };
                     }
@@ -466,6 +1091,11 @@ impl CelCompiler {
 
     fn render_function(name: &str, args: &[CelExpr], target: Target) -> String {
         let args_rendered: Vec<_> = args.iter().map(|a| Self::render(a, target)).collect();
+        // Float arguments of math functions, typed for Rust
+        let float_arg = |i: usize, receiver: bool| match target {
+            Target::Rust => Self::rust_float(&args[i], &args_rendered[i], receiver),
+            _ => args_rendered[i].clone(),
+        };
 
         match (name, target) {
             // size() function
@@ -572,12 +1202,551 @@ impl CelCompiler {
             ("string", Target::CSharp | Target::Java) => format!("{}.toString()", args_rendered[0]),
             ("string", Target::Go) => format!("fmt.Sprintf(\"%v\", {})", args_rendered[0]),
 
+            // is_present()/coalesce() for optional inputs
+            ("is_present", Target::Rust) if args.len() == 1 => {
+                format!("{}.is_some()", args_rendered[0])
+            }
+            ("is_present", Target::TypeScript | Target::CSharp | Target::Java)
+                if args.len() == 1 =>
+            {
+                format!("({} != null)", args_rendered[0])
+            }
+            ("is_present", Target::Python) if args.len() == 1 => {
+                format!("({} is not None)", args_rendered[0])
+            }
+            // Go helpers are emitted by the spec template (pointer or sql.Null)
+            ("is_present", Target::Go) if args.len() == 1 => {
+                format!("isPresent({})", args_rendered[0])
+            }
+            ("coalesce", Target::Rust) if args.len() == 2 => {
+                format!(
+                    "{}.clone().unwrap_or({}.into())",
+                    args_rendered[0], args_rendered[1]
+                )
+            }
+            ("coalesce", Target::TypeScript | Target::CSharp) if args.len() == 2 => {
+                format!("({} ?? {})", args_rendered[0], args_rendered[1])
+            }
+            ("coalesce", Target::Python) if args.len() == 2 => {
+                format!(
+                    "({0} if {0} is not None else {1})",
+                    args_rendered[0], args_rendered[1]
+                )
+            }
+            ("coalesce", Target::Java) if args.len() == 2 => {
+                format!(
+                    "Objects.requireNonNullElse({}, {})",
+                    args_rendered[0], args_rendered[1]
+                )
+            }
+            ("coalesce", Target::Go) if args.len() == 2 => {
+                format!("coalesce({}, {})", args_rendered[0], args_rendered[1])
+            }
+
+            // convert(x, 'lb', 'kg') folds to a constant factor
+            ("convert", _) if args.len() == 3 => {
+                match (
+                    Self::string_literal(&args[1]),
+                    Self::string_literal(&args[2]),
+                ) {
+                    (Some(from), Some(to)) => match crate::units::conversion_factor(&from, &to) {
+                        Ok(factor) => format!("({} * {:?})", args_rendered[0], factor),
+                        Err(_) => format!("{}({})", name, args_rendered.join(", ")),
+                    },
+                    _ => format!("{}({})", name, args_rendered.join(", ")),
+                }
+            }
+
+            // convert_currency(x, 'EUR', 'USD') goes through the caller's ConversionRates
+            ("convert_currency", Target::Go | Target::CSharp) if args.len() == 3 => {
+                format!(
+                    "rates.Convert({}, {}, {})",
+                    args_rendered[0], args_rendered[1], args_rendered[2]
+                )
+            }
+            ("convert_currency", _) if args.len() == 3 => {
+                format!(
+                    "rates.convert({}, {}, {})",
+                    args_rendered[0], args_rendered[1], args_rendered[2]
+                )
+            }
+
+            // bucket(key, n): FNV-1a of the key's text, modulo n (see bucket());
+            // Go calls a helper emitted by the spec template
+            ("bucket", _) if args.len() == 2 && target != Target::Go => {
+                Self::render_bucket(&args_rendered[0], &args_rendered[1], target)
+            }
+
+            // lookup('table', key, fallback) reads a table the spec template
+            // embeds; Go helpers are renamed to spec-scoped ones
+            ("lookup", _) if args.len() == 3 && Self::string_literal(&args[0]).is_some() => {
+                let table = Self::string_literal(&args[0]).unwrap_or_default();
+                Self::render_lookup(&table, &args_rendered[1], &args_rendered[2], target)
+            }
+            // region(country) and zone(country) read the built-in geo tables
+            ("region" | "zone", _) if args.len() == 1 => {
+                let fallback = Self::render_literal(&Val::String(String::new().into()), target);
+                Self::render_lookup(name, &args_rendered[0], &fallback, target)
+            }
+
+            // format("{a}-{b}"): a string built from the placeholders
+            ("format", _) if args.len() == 1 => {
+                let parts = Self::string_literal(&args[0])
+                    .and_then(|template| Self::parse_format(&template).ok());
+                match parts {
+                    Some(parts) => Self::render_format(&parts, target),
+                    None => format!("format({})", args_rendered[0]),
+                }
+            }
+
+            // Aggregates over a list, or a field of a list of objects
+            ("sum" | "avg" | "min" | "max" | "count", _) if args.len() == 1 => {
+                Self::render_aggregate(name, &args[0], target)
+            }
+
+            // min/max/clamp
+            ("min" | "max", Target::Rust) if args.len() == 2 => {
+                format!("{}.{}({})", args_rendered[0], name, args_rendered[1])
+            }
+            ("min" | "max", Target::Python | Target::Go) if args.len() == 2 => {
+                format!("{}({}, {})", name, args_rendered[0], args_rendered[1])
+            }
+            ("min" | "max", Target::TypeScript | Target::Java) if args.len() == 2 => {
+                format!("Math.{}({}, {})", name, args_rendered[0], args_rendered[1])
+            }
+            ("min", Target::CSharp) if args.len() == 2 => {
+                format!("Math.Min({}, {})", args_rendered[0], args_rendered[1])
+            }
+            ("max", Target::CSharp) if args.len() == 2 => {
+                format!("Math.Max({}, {})", args_rendered[0], args_rendered[1])
+            }
+            ("clamp", Target::Rust) if args.len() == 3 => {
+                format!(
+                    "{}.clamp({}, {})",
+                    args_rendered[0], args_rendered[1], args_rendered[2]
+                )
+            }
+            ("clamp", Target::CSharp) if args.len() == 3 => {
+                format!(
+                    "Math.Clamp({}, {}, {})",
+                    args_rendered[0], args_rendered[1], args_rendered[2]
+                )
+            }
+            ("clamp", Target::Python | Target::Go) if args.len() == 3 => {
+                format!(
+                    "min(max({}, {}), {})",
+                    args_rendered[0], args_rendered[1], args_rendered[2]
+                )
+            }
+            ("clamp", Target::TypeScript | Target::Java) if args.len() == 3 => {
+                format!(
+                    "Math.min(Math.max({}, {}), {})",
+                    args_rendered[0], args_rendered[1], args_rendered[2]
+                )
+            }
+
+            // round/floor/ceil; round() is half away from zero in every target
+            ("round", _) if args.len() == 2 => match Self::int_literal(&args[1]) {
+                Some(digits) if (0..=15).contains(&digits) => {
+                    let scale = format!("{:?}", 10f64.powi(digits as i32));
+                    let scaled = format!("({} * {})", float_arg(0, false), scale);
+                    format!(
+                        "({} / {})",
+                        Self::render_rounding("round", &scaled, target),
+                        scale
+                    )
+                }
+                _ => format!("{}({})", name, args_rendered.join(", ")),
+            },
+            ("round" | "floor" | "ceil", _) if args.len() == 1 => {
+                Self::render_rounding(name, &float_arg(0, true), target)
+            }
+
+            // abs/pow/sqrt/log (natural); arguments are floats
+            ("abs" | "sqrt" | "log", _) if args.len() == 1 => {
+                Self::render_math(name, &float_arg(0, true), target)
+            }
+            ("pow", Target::Rust) if args.len() == 2 => match Self::int_literal(&args[1]) {
+                Some(n) if i32::try_from(n).is_ok() => {
+                    format!("{}.powi({})", float_arg(0, true), n)
+                }
+                _ => format!("{}.powf({})", float_arg(0, true), float_arg(1, false)),
+            },
+            ("pow", Target::Go) if args.len() == 2 => {
+                format!("math.Pow({}, {})", args_rendered[0], args_rendered[1])
+            }
+            ("pow", Target::TypeScript | Target::Java) if args.len() == 2 => {
+                format!("Math.pow({}, {})", args_rendered[0], args_rendered[1])
+            }
+            ("pow", Target::Python) if args.len() == 2 => {
+                format!("math.pow({}, {})", args_rendered[0], args_rendered[1])
+            }
+            ("pow", Target::CSharp) if args.len() == 2 => {
+                format!("Math.Pow({}, {})", args_rendered[0], args_rendered[1])
+            }
+            // percentage(x, p): p percent of x
+            ("percentage", _) if args.len() == 2 => {
+                format!(
+                    "({} * {} / 100.0)",
+                    float_arg(0, false),
+                    float_arg(1, false)
+                )
+            }
+
             // Default: preserve as function call
             _ => format!("{}({})", name, args_rendered.join(", ")),
         }
     }
 }
 
+impl CelCompiler {
+    /// Render round/floor/ceil of an already-rendered float expression
+    fn render_rounding(name: &str, x: &str, target: Target) -> String {
+        match (name, target) {
+            ("round", Target::Rust) => format!("{}.round()", x),
+            ("round", Target::Go) => format!("math.Round({})", x),
+            ("round", Target::TypeScript) => {
+                format!("(Math.sign({0}) * Math.round(Math.abs({0})))", x)
+            }
+            ("round", Target::Python) => {
+                format!("math.copysign(math.floor(abs({0}) + 0.5), {0})", x)
+            }
+            ("round", Target::Java) => format!("(Math.signum({0}) * Math.round(Math.abs({0})))", x),
+            ("round", Target::CSharp) => {
+                format!("Math.Round({}, MidpointRounding.AwayFromZero)", x)
+            }
+            ("floor" | "ceil", Target::Rust) => format!("{}.{}()", x, name),
+            ("floor", Target::Go) => format!("math.Floor({})", x),
+            ("ceil", Target::Go) => format!("math.Ceil({})", x),
+            ("floor" | "ceil", Target::TypeScript | Target::Java) => {
+                format!("Math.{}({})", name, x)
+            }
+            ("floor" | "ceil", Target::Python) => format!("math.{}({})", name, x),
+            ("floor", Target::CSharp) => format!("Math.Floor({})", x),
+            ("ceil", Target::CSharp) => format!("Math.Ceiling({})", x),
+            _ => format!("{}({})", name, x),
+        }
+    }
+
+    /// Render abs/sqrt/log of an already-rendered float expression
+    fn render_math(name: &str, x: &str, target: Target) -> String {
+        match (name, target) {
+            ("log", Target::Rust) => format!("{}.ln()", x),
+            (_, Target::Rust) => format!("{}.{}()", x, name),
+            ("abs", Target::Go) => format!("math.Abs({})", x),
+            ("sqrt", Target::Go) => format!("math.Sqrt({})", x),
+            ("log", Target::Go) => format!("math.Log({})", x),
+            (_, Target::TypeScript | Target::Java) => format!("Math.{}({})", name, x),
+            ("abs", Target::Python) => format!("abs({})", x),
+            (_, Target::Python) => format!("math.{}({})", name, x),
+            ("abs", Target::CSharp) => format!("Math.Abs({})", x),
+            ("sqrt", Target::CSharp) => format!("Math.Sqrt({})", x),
+            ("log", Target::CSharp) => format!("Math.Log({})", x),
+            _ => format!("{}({})", name, x),
+        }
+    }
+
+    /// Render `bucket()` of an already-rendered key and count. Keys hash by
+    /// the text the runtime gives them: bools lowercase and floats in plain
+    /// decimal, without a trailing `.0`; with no buckets the result is 0.
+    fn render_bucket(key: &str, n: &str, target: Target) -> String {
+        match target {
+            Target::Rust => format!(
+                "(if {1} <= 0 {{ 0 }} else {{ {0}.to_string().bytes().fold(2166136261u32, |h, b| (h ^ b as u32).wrapping_mul(16777619)) as i64 % {1} }})",
+                key, n
+            ),
+            Target::TypeScript => format!(
+                "({1} <= 0 ? 0 : [...new TextEncoder().encode(String({0}))].reduce((h, b) => Math.imul(h ^ b, 16777619) >>> 0, 2166136261) % {1})",
+                key, n
+            ),
+            Target::Python => {
+                let text = format!(
+                    "(lambda k: 'true' if k is True else 'false' if k is False else str(int(k)) if isinstance(k, float) and k.is_integer() else str(k))({})",
+                    key
+                );
+                format!(
+                    "(0 if {1} <= 0 else functools.reduce(lambda h, b: ((h ^ b) * 16777619) & 0xFFFFFFFF, {0}.encode(), 2166136261) % {1})",
+                    text, n
+                )
+            }
+            Target::Java => {
+                let bytes = format!(
+                    "(((Object) ({0})) instanceof Double ? java.math.BigDecimal.valueOf((Double) (Object) ({0})).stripTrailingZeros().toPlainString() : String.valueOf({0})).getBytes(java.nio.charset.StandardCharsets.UTF_8)",
+                    key
+                );
+                format!(
+                    "({1} <= 0 ? 0L : (long) Integer.remainderUnsigned(java.util.stream.IntStream.range(0, {0}.length).map(i -> {0}[i] & 0xff).reduce(0x811c9dc5, (h, b) -> (h ^ b) * 0x01000193), (int) {1}))",
+                    bytes, n
+                )
+            }
+            Target::CSharp => {
+                let text = format!(
+                    "((object) ({0}) is bool ? ((bool) (object) ({0}) ? \"true\" : \"false\") : System.Convert.ToString((object) ({0}), System.Globalization.CultureInfo.InvariantCulture))",
+                    key
+                );
+                format!(
+                    "({1} <= 0 ? 0L : (long)(System.Linq.Enumerable.Aggregate(System.Text.Encoding.UTF8.GetBytes({0}), 2166136261u, (h, b) => unchecked((h ^ b) * 16777619u)) % (uint){1}))",
+                    text, n
+                )
+            }
+            Target::Go => format!("bucket({}, {})", key, n),
+        }
+    }
+
+    /// Render a read of lookup table `table` by an already-rendered key
+    fn render_lookup(table: &str, key: &str, fallback: &str, target: Target) -> String {
+        match target {
+            Target::Rust => format!("lookup_{}(&{}).unwrap_or({})", table, key, fallback),
+            Target::Go => format!(
+                "lookup{}({}, {})",
+                crate::util::to_pascal_case(table),
+                key,
+                fallback
+            ),
+            Target::Python => format!(
+                "_LOOKUP_{}.get({}, {})",
+                table.to_uppercase(),
+                key,
+                fallback
+            ),
+            Target::TypeScript => format!(
+                "(lookup{}[{}] ?? {})",
+                crate::util::to_pascal_case(table),
+                key,
+                fallback
+            ),
+            Target::Java => format!(
+                "LOOKUP_{}.getOrDefault({}, {})",
+                table.to_uppercase(),
+                key,
+                fallback
+            ),
+            Target::CSharp => format!(
+                "Lookup{}.GetValueOrDefault({}, {})",
+                crate::util::to_pascal_case(table),
+                key,
+                fallback
+            ),
+        }
+    }
+
+    /// Render a parsed `format()` template as the target's string
+    /// formatting: fmt.Sprintf, format!, str.format, String.format,
+    /// string.Format, or a template literal
+    fn render_format(parts: &[FormatPart], target: Target) -> String {
+        let names: Vec<&str> = parts
+            .iter()
+            .filter_map(|part| match part {
+                FormatPart::Placeholder(name) => Some(name.as_str()),
+                FormatPart::Text(_) => None,
+            })
+            .collect();
+        if target == Target::TypeScript {
+            let body: String = parts
+                .iter()
+                .map(|part| match part {
+                    FormatPart::Text(text) => text
+                        .replace('\\', "\\\\")
+                        .replace('`', "\\`")
+                        .replace('$', "\\$"),
+                    FormatPart::Placeholder(name) => format!("${{{}}}", name),
+                })
+                .collect();
+            return format!("`{}`", body);
+        }
+        if names.is_empty() {
+            let text: String = parts
+                .iter()
+                .filter_map(|part| match part {
+                    FormatPart::Text(text) => Some(text.as_str()),
+                    FormatPart::Placeholder(_) => None,
+                })
+                .collect();
+            return Self::render_literal(&Val::String(text.into()), target);
+        }
+        let mut template = String::new();
+        let mut position = 0;
+        for part in parts {
+            match (part, target) {
+                (FormatPart::Text(text), Target::Go | Target::Java) => {
+                    template.push_str(&text.replace('%', "%%"))
+                }
+                (FormatPart::Text(text), _) => {
+                    template.push_str(&text.replace('{', "{{").replace('}', "}}"))
+                }
+                (FormatPart::Placeholder(_), Target::Go) => template.push_str("%v"),
+                (FormatPart::Placeholder(_), Target::Java) => template.push_str("%s"),
+                (FormatPart::Placeholder(_), Target::CSharp) => {
+                    template.push_str(&format!("{{{}}}", position));
+                    position += 1;
+                }
+                (FormatPart::Placeholder(_), _) => template.push_str("{}"),
+            }
+        }
+        let literal = Self::render_literal(&Val::String(template.into()), target);
+        let args = names.join(", ");
+        match target {
+            Target::Rust => format!("format!({}, {})", literal, args),
+            Target::Go => format!("fmt.Sprintf({}, {})", literal, args),
+            Target::Python => format!("{}.format({})", literal, args),
+            Target::Java => format!("String.format({}, {})", literal, args),
+            Target::CSharp => format!("string.Format({}, {})", literal, args),
+            Target::TypeScript => unreachable!("rendered as a template literal"),
+        }
+    }
+
+    /// Render sum/avg/min/max/count of a list as an inline loop. The items
+    /// are read as floats, or their `field` when the argument selects one
+    /// (`items.weight_kg`); empty lists aggregate to 0.
+    fn render_aggregate(name: &str, arg: &CelExpr, target: Target) -> String {
+        let (list, field) = match &arg.expr {
+            Expr::Select(select) => (
+                Self::render(&select.operand, target),
+                Some(select.field.to_string()),
+            ),
+            _ => (Self::render(arg, target), None),
+        };
+        if name == "count" {
+            return match target {
+                Target::Rust => format!("({}.len() as i64)", list),
+                Target::Go => format!("int64(len({}))", list),
+                Target::TypeScript => format!("{}.length", list),
+                Target::Python => format!("len({})", list),
+                Target::Java => format!("(long) {}.size()", list),
+                Target::CSharp => format!("{}.Count", list),
+            };
+        }
+        let item = match (target, &field) {
+            (Target::Rust, Some(f)) => format!("agg_item[\"{}\"].as_f64().unwrap_or(0.0)", f),
+            (Target::Rust, None) => "(*agg_item as f64)".to_string(),
+            // A type switch: decoded JSON holds float64, Go-built maps ints
+            (Target::Go, Some(f)) => format!(
+                "func() float64 {{ switch aggField := aggItem[\"{}\"].(type) {{ case int: return float64(aggField); case int64: return float64(aggField); case float64: return aggField }}; return 0 }}()",
+                f
+            ),
+            (Target::Go, None) => "float64(aggItem)".to_string(),
+            (Target::TypeScript, Some(f)) => format!("(aggItem[\"{}\"] as number)", f),
+            (Target::TypeScript, None) => "aggItem".to_string(),
+            (Target::Python, Some(f)) => format!("agg_item[\"{}\"]", f),
+            (Target::Python, None) => "agg_item".to_string(),
+            (Target::Java, Some(f)) => format!("((Number) aggItem.get(\"{}\")).doubleValue()", f),
+            (Target::Java, None) => "((Number) aggItem).doubleValue()".to_string(),
+            (Target::CSharp, Some(f)) => format!("Convert.ToDouble(aggItem[\"{}\"])", f),
+            (Target::CSharp, None) => "Convert.ToDouble(aggItem)".to_string(),
+        };
+        match target {
+            Target::Rust => {
+                let values = format!("{}.iter().map(|agg_item| {})", list, item);
+                match name {
+                    "sum" => format!("{}.sum::<f64>()", values),
+                    "avg" => format!(
+                        "(if {0}.is_empty() {{ 0.0 }} else {{ {1}.sum::<f64>() / {0}.len() as f64 }})",
+                        list, values
+                    ),
+                    _ => format!("{}.reduce(f64::{}).unwrap_or(0.0)", values, name),
+                }
+            }
+            Target::Go => match name {
+                "sum" => format!(
+                    "func() float64 {{ aggSum := 0.0; for _, aggItem := range {} {{ aggSum += {} }}; return aggSum }}()",
+                    list, item
+                ),
+                "avg" => format!(
+                    "func() float64 {{ if len({0}) == 0 {{ return 0 }}; aggSum := 0.0; for _, aggItem := range {0} {{ aggSum += {1} }}; return aggSum / float64(len({0})) }}()",
+                    list, item
+                ),
+                _ => format!(
+                    "func() float64 {{ aggResult := 0.0; for aggIndex, aggItem := range {} {{ if aggValue := {}; aggIndex == 0 || aggValue {} aggResult {{ aggResult = aggValue }} }}; return aggResult }}()",
+                    list,
+                    item,
+                    if name == "min" { "<" } else { ">" }
+                ),
+            },
+            Target::TypeScript => {
+                let values = format!("{}.map((aggItem) => {})", list, item);
+                match name {
+                    "sum" => format!("{}.reduce((a, b) => a + b, 0)", values),
+                    "avg" => format!(
+                        "({0}.length === 0 ? 0 : {1}.reduce((a, b) => a + b, 0) / {0}.length)",
+                        list, values
+                    ),
+                    _ => format!(
+                        "({}.length === 0 ? 0 : Math.{}(...{}))",
+                        list, name, values
+                    ),
+                }
+            }
+            Target::Python => {
+                let values = format!("[{} for agg_item in {}]", item, list);
+                match name {
+                    "sum" => format!("sum({})", values),
+                    "avg" => format!("(sum({1}) / len({0}) if {0} else 0.0)", list, values),
+                    _ => format!("{}({}, default=0.0)", name, values),
+                }
+            }
+            Target::Java => {
+                let values = format!("{}.stream().mapToDouble(aggItem -> {})", list, item);
+                match name {
+                    "sum" => format!("{}.sum()", values),
+                    "avg" => format!("{}.average().orElse(0.0)", values),
+                    _ => format!("{}.{}().orElse(0.0)", values, name),
+                }
+            }
+            Target::CSharp => {
+                let values = format!("{}.Select(aggItem => {})", list, item);
+                match name {
+                    "sum" => format!("{}.Sum()", values),
+                    "avg" => format!("{}.DefaultIfEmpty(0.0).Average()", values),
+                    "min" => format!("{}.DefaultIfEmpty(0.0).Min()", values),
+                    _ => format!("{}.DefaultIfEmpty(0.0).Max()", values),
+                }
+            }
+        }
+    }
+
+    /// Value of an integer literal expression
+    pub fn int_literal(expr: &CelExpr) -> Option<i64> {
+        match &expr.expr {
+            Expr::Literal(Val::Int(i)) => Some(*i),
+            Expr::Literal(Val::UInt(u)) => i64::try_from(*u).ok(),
+            _ => None,
+        }
+    }
+
+    /// A float argument of a Rust math function: integer literals as floats
+    /// (`15.0`) and, when a method is called on it, literals typed
+    /// (`1.5_f64`) so the call resolves
+    fn rust_float(arg: &CelExpr, rendered: &str, receiver: bool) -> String {
+        let literal = |text: String| match receiver {
+            true if text.starts_with('-') => format!("({}_f64)", text),
+            true => format!("{}_f64", text),
+            false => text,
+        };
+        match &arg.expr {
+            Expr::Literal(Val::Int(i)) => literal(format!("{:?}", *i as f64)),
+            Expr::Literal(Val::UInt(u)) => literal(format!("{:?}", *u as f64)),
+            Expr::Literal(Val::Double(f)) => literal(format!("{:?}", f)),
+            Expr::Call(call)
+                if call.func_name == operators::NEGATE
+                    && call.args.len() == 1
+                    && matches!(call.args[0].expr, Expr::Literal(_)) =>
+            {
+                let inner = Self::render(&call.args[0], Target::Rust);
+                format!("(-{})", Self::rust_float(&call.args[0], &inner, receiver))
+            }
+            _ => rendered.to_string(),
+        }
+    }
+
+    /// Value of a string literal expression
+    pub fn string_literal(expr: &CelExpr) -> Option<String> {
+        match &expr.expr {
+            Expr::Literal(Val::String(s)) => Some(s.to_string()),
+            _ => None,
+        }
+    }
+}
+
 /// Render macros for comprehensions
 impl CelCompiler {
     /// Render list.all(x, predicate)
@@ -828,4 +1997,383 @@ mod tests {
             CelCompiler::eval_bool("role == \"member\" && verified && level >= 50", &vars).unwrap();
         assert!(!result);
     }
+
+    #[test]
+    fn test_optional_functions() {
+        let expr = "is_present(member_tier) && coalesce(member_tier, \"none\") == \"gold\"";
+
+        let rust = CelCompiler::compile(expr, Target::Rust).unwrap();
+        assert!(rust.contains("member_tier.is_some()"));
+        assert!(rust.contains("member_tier.clone().unwrap_or(\"none\".into())"));
+
+        let ts = CelCompiler::compile(expr, Target::TypeScript).unwrap();
+        assert!(ts.contains("(member_tier != null)"));
+        assert!(ts.contains("(member_tier ?? \"none\")"));
+
+        let python = CelCompiler::compile(expr, Target::Python).unwrap();
+        assert!(python.contains("(member_tier is not None)"));
+        assert!(python.contains("(member_tier if member_tier is not None else \"none\")"));
+
+        let go = CelCompiler::compile(expr, Target::Go).unwrap();
+        assert!(go.contains("isPresent(member_tier)"));
+        assert!(go.contains("coalesce(member_tier, \"none\")"));
+    }
+
+    #[test]
+    fn test_convert_units() {
+        let rust =
+            CelCompiler::compile("convert(weight, 'lb', 'kg') > 10.0", Target::Rust).unwrap();
+        assert!(rust.contains("(weight * 0.45359237)"));
+
+        let go = CelCompiler::compile("convert(weight, 'lb', 'kg')", Target::Go).unwrap();
+        assert_eq!(go, "(weight * 0.45359237)");
+
+        // Incompatible units are left as a call (and reported by validation)
+        let bad = CelCompiler::compile("convert(weight, 'kg', 'm')", Target::Go).unwrap();
+        assert!(bad.starts_with("convert("));
+    }
+
+    #[test]
+    fn test_convert_currency() {
+        let expr = "convert_currency(price, 'EUR', 'USD')";
+        let go = CelCompiler::compile(expr, Target::Go).unwrap();
+        assert_eq!(go, "rates.Convert(price, \"EUR\", \"USD\")");
+        let ts = CelCompiler::compile(expr, Target::TypeScript).unwrap();
+        assert_eq!(ts, "rates.convert(price, \"EUR\", \"USD\")");
+    }
+
+    #[test]
+    fn test_bucket() {
+        // FNV-1a reference values
+        assert_eq!(CelCompiler::bucket("", 1 << 40), 2166136261);
+        assert_eq!(CelCompiler::bucket("a", 1 << 40), 3826002220);
+        assert!((0..100).contains(&CelCompiler::bucket("member-42", 100)));
+        assert_eq!(CelCompiler::bucket("member-42", 0), 0);
+
+        let mut vars = HashMap::new();
+        vars.insert("user_id".to_string(), Value::String("a".to_string().into()));
+        assert_eq!(
+            CelCompiler::eval_int("bucket(user_id, 100)", &vars).unwrap(),
+            3826002220 % 100
+        );
+        vars.insert("user_id".to_string(), Value::Int(42));
+        assert_eq!(
+            CelCompiler::eval_int("bucket(user_id, 100)", &vars).unwrap(),
+            CelCompiler::bucket("42", 100)
+        );
+
+        let go = CelCompiler::compile("bucket(user_id, 100) < 5", Target::Go).unwrap();
+        assert!(go.contains("bucket(user_id, 100)"));
+        let rust = CelCompiler::compile("bucket(user_id, 100)", Target::Rust).unwrap();
+        assert!(rust.starts_with("(if 100 <= 0 { 0 } else { user_id.to_string().bytes()"));
+        assert!(rust.ends_with(" as i64 % 100 })"));
+        let py = CelCompiler::compile("bucket(user_id, 100)", Target::Python).unwrap();
+        assert!(py.starts_with("(0 if 100 <= 0 else functools.reduce("));
+        assert!(py.contains("'true' if k is True else 'false' if k is False"));
+        assert!(py.contains("str(int(k)) if isinstance(k, float) and k.is_integer()"));
+        let csharp = CelCompiler::compile("bucket(user_id, 100)", Target::CSharp).unwrap();
+        assert!(csharp.starts_with("(100 <= 0 ? 0L : "));
+        assert!(csharp.contains("((bool) (object) (user_id) ? \"true\" : \"false\")"));
+        assert!(csharp.contains("CultureInfo.InvariantCulture"));
+        let java = CelCompiler::compile("bucket(user_id, 100)", Target::Java).unwrap();
+        assert!(java.contains(".stripTrailingZeros().toPlainString()"));
+
+        // keys hash by their canonical text
+        vars.insert("user_id".to_string(), Value::Float(2.0));
+        assert_eq!(
+            CelCompiler::eval_int("bucket(user_id, 100)", &vars).unwrap(),
+            CelCompiler::bucket("2", 100)
+        );
+        vars.insert("user_id".to_string(), Value::Bool(true));
+        assert_eq!(
+            CelCompiler::eval_int("bucket(user_id, 100)", &vars).unwrap(),
+            CelCompiler::bucket("true", 100)
+        );
+        assert_eq!(
+            CelCompiler::eval_int("bucket(user_id, -3)", &vars).unwrap(),
+            0
+        );
+
+        assert_eq!(
+            CelCompiler::bucket_calls("bucket(user_id, 100) < 5 && bucket(user_id + 'x', n) < 1")
+                .unwrap(),
+            vec![(Some("user_id".to_string()), Some(100)), (None, None)]
+        );
+    }
+
+    #[test]
+    fn test_min_max_clamp() {
+        let expr = "clamp(max(weight * 2.0, 5.0), 5.0, min(cap, 100.0))";
+        let rust = CelCompiler::compile(expr, Target::Rust).unwrap();
+        assert_eq!(rust, "(weight * 2.0).max(5.0).clamp(5.0, cap.min(100.0))");
+
+        let go = CelCompiler::compile("clamp(x, 1.0, 9.0)", Target::Go).unwrap();
+        assert_eq!(go, "min(max(x, 1.0), 9.0)");
+
+        let ts = CelCompiler::compile("max(a, b)", Target::TypeScript).unwrap();
+        assert_eq!(ts, "Math.max(a, b)");
+
+        let cs = CelCompiler::compile("clamp(x, 1, 9)", Target::CSharp).unwrap();
+        assert_eq!(cs, "Math.Clamp(x, 1, 9)");
+    }
+
+    #[test]
+    fn test_rounding() {
+        assert_eq!(
+            CelCompiler::compile("round(price)", Target::Go).unwrap(),
+            "math.Round(price)"
+        );
+        assert_eq!(
+            CelCompiler::compile("round(price, 2)", Target::Go).unwrap(),
+            "(math.Round((price * 100.0)) / 100.0)"
+        );
+        assert_eq!(
+            CelCompiler::compile("ceil(weight)", Target::Rust).unwrap(),
+            "weight.ceil()"
+        );
+        assert_eq!(
+            CelCompiler::compile("floor(weight)", Target::Python).unwrap(),
+            "math.floor(weight)"
+        );
+        assert_eq!(
+            CelCompiler::compile("round(x)", Target::CSharp).unwrap(),
+            "Math.Round(x, MidpointRounding.AwayFromZero)"
+        );
+    }
+
+    #[test]
+    fn test_math_functions() {
+        assert_eq!(
+            CelCompiler::compile("sqrt(pow(side, 3))", Target::Go).unwrap(),
+            "math.Sqrt(math.Pow(side, 3))"
+        );
+        assert_eq!(
+            CelCompiler::compile("sqrt(pow(side, 3))", Target::Rust).unwrap(),
+            "side.powi(3).sqrt()"
+        );
+        assert_eq!(
+            CelCompiler::compile("pow(side, exponent)", Target::Rust).unwrap(),
+            "side.powf(exponent)"
+        );
+        assert_eq!(
+            CelCompiler::compile("log(x)", Target::Rust).unwrap(),
+            "x.ln()"
+        );
+        assert_eq!(
+            CelCompiler::compile("abs(delta)", Target::Python).unwrap(),
+            "abs(delta)"
+        );
+        assert_eq!(
+            CelCompiler::compile("log(x)", Target::CSharp).unwrap(),
+            "Math.Log(x)"
+        );
+        assert_eq!(
+            CelCompiler::compile("percentage(subtotal, 15)", Target::TypeScript).unwrap(),
+            "(subtotal * 15 / 100.0)"
+        );
+        assert_eq!(
+            CelCompiler::compile("percentage(subtotal, 15)", Target::Rust).unwrap(),
+            "(subtotal * 15.0 / 100.0)"
+        );
+        for (expr, rust) in [
+            ("sqrt(2)", "2.0_f64.sqrt()"),
+            ("abs(-1.5)", "(-1.5_f64).abs()"),
+            ("pow(1.5, 2)", "1.5_f64.powi(2)"),
+            ("pow(side, 2.5)", "side.powf(2.5)"),
+        ] {
+            assert_eq!(CelCompiler::compile(expr, Target::Rust).unwrap(), rust);
+        }
+
+        let mut vars = HashMap::new();
+        vars.insert("subtotal".to_string(), Value::Float(80.0));
+        vars.insert("side".to_string(), Value::Int(4));
+        assert_eq!(
+            CelCompiler::eval_float("percentage(subtotal, 15)", &vars).unwrap(),
+            12.0
+        );
+        assert_eq!(
+            CelCompiler::eval_float("sqrt(pow(side, 2)) + abs(-1.5)", &vars).unwrap(),
+            5.5
+        );
+
+        let calls = CelCompiler::math_calls("abs(delta) + pow(side, 2.0, 1.0)").unwrap();
+        assert_eq!(
+            calls,
+            vec![
+                ("abs", 1, vec!["delta".to_string()]),
+                ("pow", 3, vec!["side".to_string()])
+            ]
+        );
+    }
+
+    #[test]
+    fn test_aggregate_functions() {
+        let expr = "sum(items.weight_kg) * 5.0 + 7.0";
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Rust).unwrap(),
+            "items.iter().map(|agg_item| agg_item[\"weight_kg\"].as_f64().unwrap_or(0.0)).sum::<f64>() * 5.0 + 7.0"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Go).unwrap(),
+            "func() float64 { aggSum := 0.0; for _, aggItem := range items { aggSum += func() float64 { switch aggField := aggItem[\"weight_kg\"].(type) { case int: return float64(aggField); case int64: return float64(aggField); case float64: return aggField }; return 0 }() }; return aggSum }() * 5.0 + 7.0"
+        );
+        assert_eq!(
+            CelCompiler::compile("avg(scores)", Target::Python).unwrap(),
+            "(sum([agg_item for agg_item in scores]) / len(scores) if scores else 0.0)"
+        );
+        assert_eq!(
+            CelCompiler::compile("max(items.price)", Target::Java).unwrap(),
+            "items.stream().mapToDouble(aggItem -> ((Number) aggItem.get(\"price\")).doubleValue()).max().orElse(0.0)"
+        );
+        assert_eq!(
+            CelCompiler::compile("count(items)", Target::TypeScript).unwrap(),
+            "items.length"
+        );
+        // Two arguments still compare scalars
+        assert_eq!(
+            CelCompiler::compile("min(a, b)", Target::Go).unwrap(),
+            "min(a, b)"
+        );
+
+        let mut vars = HashMap::new();
+        let item = |weight: f64| {
+            let mut fields = HashMap::new();
+            fields.insert("weight_kg".to_string(), Value::Float(weight));
+            Value::from(fields)
+        };
+        vars.insert("items".to_string(), Value::from(vec![item(1.5), item(2.5)]));
+        vars.insert(
+            "scores".to_string(),
+            Value::from(vec![Value::Int(2), Value::Int(4)]),
+        );
+        assert_eq!(CelCompiler::eval_float(expr, &vars).unwrap(), 27.0);
+        assert_eq!(CelCompiler::eval_float("avg(scores)", &vars).unwrap(), 3.0);
+        assert_eq!(
+            CelCompiler::eval("count(items)", &vars).unwrap(),
+            Value::Int(2)
+        );
+
+        let calls =
+            CelCompiler::aggregate_calls("sum(items.weight_kg) + max(a, b) + count(1)").unwrap();
+        assert_eq!(
+            calls,
+            vec![
+                (
+                    "sum",
+                    1,
+                    Some(("items".to_string(), Some("weight_kg".to_string())))
+                ),
+                ("count", 1, None)
+            ]
+        );
+    }
+
+    #[test]
+    fn test_format_function() {
+        let expr = "format('{carrier}-{zone}-{tier}')";
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Go).unwrap(),
+            "fmt.Sprintf(\"%v-%v-%v\", carrier, zone, tier)"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Rust).unwrap(),
+            "format!(\"{}-{}-{}\", carrier, zone, tier)"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::TypeScript).unwrap(),
+            "`${carrier}-${zone}-${tier}`"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::CSharp).unwrap(),
+            "string.Format(\"{0}-{1}-{2}\", carrier, zone, tier)"
+        );
+        assert_eq!(
+            CelCompiler::compile("format('{{{code}}} 100%')", Target::Python).unwrap(),
+            "\"{{{}}} 100%\".format(code)"
+        );
+        assert_eq!(
+            CelCompiler::compile("format('{{{code}}} 100%')", Target::Java).unwrap(),
+            "String.format(\"{%s} 100%%\", code)"
+        );
+
+        let mut vars = HashMap::new();
+        vars.insert("carrier".to_string(), Value::from("ups".to_string()));
+        vars.insert("tier".to_string(), Value::Int(2));
+        assert_eq!(
+            CelCompiler::eval("format(\"{carrier}/{tier}\")", &vars).unwrap(),
+            Value::from("ups/2".to_string())
+        );
+        assert_eq!(
+            CelCompiler::extract_variables(expr).unwrap(),
+            vec!["carrier", "tier", "zone"]
+        );
+        assert!(CelCompiler::parse_format("{zone").is_err());
+        assert!(CelCompiler::parse_format("zone}").is_err());
+        assert!(CelCompiler::parse_format("{1st}").is_err());
+    }
+
+    #[test]
+    fn test_conditional() {
+        let expr = "express ? 10.0 : 5.0";
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Rust).unwrap(),
+            "(if express { 10.0 } else { 5.0 })"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Go).unwrap(),
+            "ifElse(express, 10.0, 5.0)"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Python).unwrap(),
+            "(10.0 if express else 5.0)"
+        );
+        assert_eq!(
+            CelCompiler::compile(expr, Target::Java).unwrap(),
+            "(express ? 10.0 : 5.0)"
+        );
+    }
+
+    #[test]
+    fn test_conjunct_count() {
+        assert_eq!(CelCompiler::conjunct_count("a").unwrap(), 1);
+        assert_eq!(CelCompiler::conjunct_count("a && (b && c)").unwrap(), 3);
+        assert_eq!(CelCompiler::conjunct_count("a && (b || c)").unwrap(), 2);
+    }
+
+    #[test]
+    fn test_conjuncts() {
+        assert_eq!(
+            CelCompiler::conjuncts("a && (b && c)").unwrap(),
+            vec!["a", "b", "c"]
+        );
+        assert_eq!(
+            CelCompiler::conjuncts("(a || b) && c > 1").unwrap(),
+            vec!["a || b", "c > 1"]
+        );
+        assert_eq!(
+            CelCompiler::conjuncts("tier == 'a && b' && f(x, y && z)").unwrap(),
+            vec!["tier == 'a && b'", "f(x, y && z)"]
+        );
+        assert_eq!(
+            CelCompiler::conjuncts("(a) && (b)").unwrap(),
+            vec!["a", "b"]
+        );
+        assert!(CelCompiler::conjuncts("a &&").is_err());
+    }
+
+    #[test]
+    fn test_unguarded_references() {
+        let optional = ["member_tier"];
+
+        let guarded = "is_present(member_tier) && coalesce(member_tier, \"\") == \"gold\"";
+        assert!(CelCompiler::unguarded_references(guarded, &optional)
+            .unwrap()
+            .is_empty());
+
+        let bare = "member_tier == \"gold\" && is_present(member_tier)";
+        assert_eq!(
+            CelCompiler::unguarded_references(bare, &optional).unwrap(),
+            vec!["member_tier".to_string()]
+        );
+    }
 }
@@ -0,0 +1,411 @@
+//! Release notes from a spec's history (`imacs changelog`)
+//!
+//! Where [`crate::compat`] tells developers whether a change breaks the
+//! generated API, the changelog tells operations what changed in the
+//! business: which rules were added or dropped, which thresholds moved and
+//! what results change, worded from the rules' own descriptions and
+//! conditions rather than as a diff.
+
+use crate::error::{Error, Result};
+use crate::spec::{ConditionValue, Output, Rule, RuleState, Spec};
+use regex::Regex;
+use std::path::Path;
+use std::process::Command;
+
+/// One version of a spec in its history
+#[derive(Debug, Clone)]
+pub struct Revision {
+    /// Version (`meta.version`), or what identifies the revision otherwise
+    pub label: String,
+    /// Date of the revision, `YYYY-MM-DD`
+    pub date: Option<String>,
+    pub spec: Spec,
+}
+
+impl Revision {
+    /// A revision labelled by the spec's version, or `fallback` without one
+    pub fn new(spec: Spec, fallback: &str, date: Option<String>) -> Self {
+        let label = spec
+            .meta
+            .version
+            .clone()
+            .unwrap_or_else(|| fallback.to_string());
+        Self { label, date, spec }
+    }
+}
+
+/// The revisions of a spec file in git, oldest first
+///
+/// Revisions that do not parse are skipped.
+pub fn git_history(path: &Path) -> Result<Vec<Revision>> {
+    let dir = path
+        .parent()
+        .filter(|d| !d.as_os_str().is_empty())
+        .unwrap_or(Path::new("."));
+    let file = path
+        .file_name()
+        .and_then(|f| f.to_str())
+        .ok_or_else(|| Error::Other(format!("{}: not a file", path.display())))?;
+    let git = |args: &[&str]| -> Result<String> {
+        let output = Command::new("git")
+            .args(args)
+            .current_dir(dir)
+            .output()
+            .map_err(|e| Error::Other(format!("Failed to run git: {}", e)))?;
+        if !output.status.success() {
+            return Err(Error::Other(format!(
+                "git {}: {}",
+                args.join(" "),
+                String::from_utf8_lossy(&output.stderr).trim()
+            )));
+        }
+        Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+    };
+
+    let log = git(&["log", "--reverse", "--format=%H %as", "--", file])?;
+    let mut revisions = Vec::new();
+    for line in log.lines() {
+        let Some((hash, date)) = line.split_once(' ') else {
+            continue;
+        };
+        let Ok(content) = git(&["show", &format!("{}:./{}", hash, file)]) else {
+            continue;
+        };
+        if let Ok(spec) = Spec::from_yaml(&content) {
+            revisions.push(Revision::new(spec, &hash[..7], Some(date.to_string())));
+        }
+    }
+    Ok(revisions)
+}
+
+/// Markdown changelog of revisions given oldest first, newest release on
+/// top
+///
+/// Consecutive revisions sharing a label are one release, described by
+/// the last of them; releases without changes are left out.
+pub fn render(revisions: &[Revision]) -> String {
+    let mut releases: Vec<&Revision> = Vec::new();
+    for revision in revisions {
+        match releases.last_mut() {
+            Some(last) if last.label == revision.label => *last = revision,
+            _ => releases.push(revision),
+        }
+    }
+
+    let title = revisions
+        .last()
+        .map(|r| r.spec.name.clone().unwrap_or_else(|| r.spec.id.clone()))
+        .unwrap_or_default();
+    let mut out = format!("# Changelog: {}\n", title);
+    let mut previous: Option<&Spec> = None;
+    let mut sections = Vec::new();
+    for release in releases {
+        let notes = match previous {
+            Some(old) => changes(old, &release.spec),
+            None => vec![format!(
+                "First version, with {} rule(s)",
+                release.spec.rules.len()
+            )],
+        };
+        previous = Some(&release.spec);
+        if notes.is_empty() {
+            continue;
+        }
+        let mut section = format!("\n## {}", release.label);
+        if let Some(date) = &release.date {
+            section.push_str(&format!(" ({})", date));
+        }
+        section.push_str("\n\n");
+        for note in notes {
+            section.push_str(&format!("- {}\n", note));
+        }
+        sections.push(section);
+    }
+    for section in sections.into_iter().rev() {
+        out.push_str(&section);
+    }
+    out
+}
+
+/// What changed from one version to the next, one sentence each
+pub fn changes(old: &Spec, new: &Spec) -> Vec<String> {
+    let mut notes = Vec::new();
+
+    for change in crate::compat::compare(old, new).api {
+        notes.push(sentence(&change.message));
+    }
+
+    for rule in &old.rules {
+        if new.rules.iter().all(|r| r.id != rule.id) {
+            notes.push(format!(
+                "Rule {} removed (it applied {}: {})",
+                label(rule),
+                when(rule),
+                outcome(new, &rule.then)
+            ));
+        }
+    }
+    for rule in &new.rules {
+        let Some(before) = old.rules.iter().find(|r| r.id == rule.id) else {
+            notes.push(format!(
+                "New rule {}: {}, {}",
+                label(rule),
+                when(rule),
+                outcome(new, &rule.then)
+            ));
+            continue;
+        };
+        if before.as_cel() != rule.as_cel() {
+            match thresholds(
+                &before.as_cel().unwrap_or_default(),
+                &rule.as_cel().unwrap_or_default(),
+            ) {
+                Some(moved) => {
+                    for (subject, from, to) in moved {
+                        let direction = if to > from { "raised" } else { "lowered" };
+                        notes.push(format!(
+                            "Rule {}: {} threshold {} from {} to {}",
+                            label(rule),
+                            subject,
+                            direction,
+                            from,
+                            to
+                        ));
+                    }
+                }
+                None => notes.push(format!(
+                    "Rule {} now applies {} (was: {})",
+                    label(rule),
+                    when(rule),
+                    when(before)
+                )),
+            }
+        }
+        for (name, from, to) in output_changes(new, &before.then, &rule.then) {
+            notes.push(format!(
+                "Rule {}: {} changes from {} to {}",
+                label(rule),
+                name,
+                from,
+                to
+            ));
+        }
+        if before.state != rule.state {
+            notes.push(match rule.state {
+                RuleState::Active => format!("Rule {} is now in effect", label(rule)),
+                RuleState::Draft => format!("Rule {} is back in draft", label(rule)),
+                RuleState::Retired => format!("Rule {} retired", label(rule)),
+            });
+        }
+    }
+
+    let order = |spec: &Spec| -> Vec<String> {
+        spec.evaluation_order()
+            .into_iter()
+            .filter(|r| {
+                old.rules.iter().any(|o| o.id == r.id) && new.rules.iter().any(|n| n.id == r.id)
+            })
+            .map(|r| r.id.clone())
+            .collect()
+    };
+    if order(old) != order(new) {
+        notes.push("Rules are now checked in a different order".to_string());
+    }
+
+    match (&old.default, &new.default) {
+        (Some(from), Some(to)) => {
+            for (name, from, to) in output_changes(new, from, to) {
+                notes.push(format!(
+                    "When no rule applies, {} changes from {} to {}",
+                    name, from, to
+                ));
+            }
+        }
+        (None, Some(to)) => notes.push(format!("When no rule applies, {}", outcome(new, to))),
+        (Some(_), None) => notes.push("Inputs no rule applies to are now rejected".to_string()),
+        (None, None) => {}
+    }
+    notes
+}
+
+/// A rule by id, with its description when it has one
+fn label(rule: &Rule) -> String {
+    match &rule.description {
+        Some(description) => format!("{} ({})", rule.id, description.trim()),
+        None => rule.id.clone(),
+    }
+}
+
+/// When a rule applies, in words
+fn when(rule: &Rule) -> String {
+    match rule.as_cel() {
+        Some(cel) => format!("when {}", words(&cel)),
+        None => "always".to_string(),
+    }
+}
+
+/// A condition with its operators spelled out
+fn words(cel: &str) -> String {
+    let mut text = cel.to_string();
+    for (operator, word) in [
+        ("&&", "and"),
+        ("||", "or"),
+        (">=", "is at least"),
+        ("<=", "is at most"),
+        ("==", "is"),
+        ("!=", "is not"),
+        (">", "is above"),
+        ("<", "is below"),
+    ] {
+        text = text.replace(operator, word);
+    }
+    text.split_whitespace().collect::<Vec<_>>().join(" ")
+}
+
+/// What an output sets, in words
+fn outcome(spec: &Spec, output: &Output) -> String {
+    let values = output_values(spec, output);
+    let parts: Vec<String> = values
+        .iter()
+        .map(|(name, value)| format!("{} is {}", name, value))
+        .collect();
+    parts.join(", ")
+}
+
+/// An output's values by output name
+fn output_values(spec: &Spec, output: &Output) -> Vec<(String, String)> {
+    match output {
+        Output::Single(ConditionValue::Map(map)) | Output::Named(map) => {
+            let mut values: Vec<_> = map
+                .iter()
+                .map(|(k, v)| (k.clone(), v.to_string()))
+                .collect();
+            values.sort();
+            values
+        }
+        Output::Single(value) => {
+            let name = spec
+                .outputs
+                .first()
+                .map_or("result".to_string(), |o| o.name.clone());
+            vec![(name, value.to_string())]
+        }
+    }
+}
+
+/// Outputs whose value differs between two versions of a rule's output
+fn output_changes(spec: &Spec, old: &Output, new: &Output) -> Vec<(String, String, String)> {
+    let before = output_values(spec, old);
+    output_values(spec, new)
+        .into_iter()
+        .filter_map(|(name, to)| {
+            let from = before.iter().find(|(n, _)| *n == name)?.1.clone();
+            (from != to).then_some((name, from, to))
+        })
+        .collect()
+}
+
+/// The numbers that moved between two conditions that are otherwise the
+/// same, with what each is compared to; None when more than numbers changed
+fn thresholds(old: &str, new: &str) -> Option<Vec<(String, f64, f64)>> {
+    let number = Regex::new(r"-?\b\d+(?:\.\d+)?\b").expect("valid regex");
+    if number.replace_all(old, "#") != number.replace_all(new, "#") {
+        return None;
+    }
+    let subject = Regex::new(r"([A-Za-z_][\w.]*)\s*(?:>=|<=|==|!=|>|<)\s*$").expect("valid regex");
+    let moved: Vec<_> = number
+        .find_iter(old)
+        .zip(number.find_iter(new))
+        .filter(|(from, to)| from.as_str() != to.as_str())
+        .filter_map(|(from, to)| {
+            let name = subject
+                .captures(&new[..to.start()])
+                .map_or("a".to_string(), |c| c[1].to_string());
+            Some((name, from.as_str().parse().ok()?, to.as_str().parse().ok()?))
+        })
+        .collect();
+    (!moved.is_empty()).then_some(moved)
+}
+
+/// A message with its first letter capitalized
+fn sentence(message: &str) -> String {
+    let mut chars = message.chars();
+    match chars.next() {
+        Some(first) => first.to_uppercase().chain(chars).collect(),
+        None => String::new(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const V1: &str = r#"
+id: shipping_rate
+meta:
+  version: "1.0.0"
+inputs:
+  - name: weight_kg
+    type: float
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    description: Heavy parcels
+    when: "weight_kg > 10.0"
+    then: 15.0
+  - id: REMOTE
+    when: "zone == 'remote'"
+    then: 30.0
+default: 5.0
+"#;
+
+    fn v2() -> String {
+        V1.replace("1.0.0", "1.1.0")
+            .replace("weight_kg > 10.0", "weight_kg > 20.0")
+            .replace("then: 15.0", "then: 18.0")
+            .replace(
+                "  - id: REMOTE\n    when: \"zone == 'remote'\"\n    then: 30.0\n",
+                "  - id: ISLANDS\n    when: \"zone == 'islands' && weight_kg >= 2.0\"\n    then: 40.0\n",
+            )
+            .replace("default: 5.0", "default: 6.0")
+    }
+
+    #[test]
+    fn test_changes() {
+        let old = Spec::from_yaml(V1).unwrap();
+        let new = Spec::from_yaml(&v2()).unwrap();
+        assert_eq!(
+            changes(&old, &new),
+            vec![
+                "Rule REMOTE removed (it applied when zone is 'remote': rate is 30)",
+                "Rule HEAVY (Heavy parcels): weight_kg threshold raised from 10 to 20",
+                "Rule HEAVY (Heavy parcels): rate changes from 15 to 18",
+                "New rule ISLANDS: when zone is 'islands' and weight_kg is at least 2.0, rate is 40",
+                "When no rule applies, rate changes from 5 to 6",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_render() {
+        let v1 = Spec::from_yaml(V1).unwrap();
+        let v1_fix = Spec::from_yaml(&V1.replace("Heavy parcels", "Heavy parcels (kg)")).unwrap();
+        let v2 = Spec::from_yaml(&v2()).unwrap();
+        let revisions = vec![
+            Revision::new(v1, "a", Some("2026-01-05".into())),
+            Revision::new(v1_fix, "b", Some("2026-01-09".into())),
+            Revision::new(v2, "c", Some("2026-03-02".into())),
+        ];
+
+        let changelog = render(&revisions);
+        assert!(changelog.starts_with("# Changelog: shipping_rate\n\n## 1.1.0 (2026-03-02)\n\n"));
+        assert!(changelog.ends_with("## 1.0.0 (2026-01-09)\n\n- First version, with 2 rule(s)\n"));
+        assert!(
+            changelog.contains("- Rule HEAVY (Heavy parcels (kg)): rate changes from 15 to 18\n")
+        );
+    }
+}
@@ -0,0 +1,679 @@
+//! Columnar evaluation over Arrow record batches (`arrow` feature)
+//!
+//! Backfills push millions of records through a spec; deciding them a row at
+//! a time spends most of its time building and matching single values.
+//! [`Columnar`] decides a whole record batch at once instead: every rule
+//! condition runs as Arrow compute kernels over the input columns, giving a
+//! mask per rule, and each output column is assembled from the rules' values
+//! with `zip`, the first matching rule winning as in
+//! [`crate::runtime::evaluate`]. Only active rules take part.
+//!
+//! Conditions and output expressions may compare, combine, choose between
+//! and do arithmetic on inputs and literals. Specs that normalize inputs,
+//! bind rule variables or call functions are refused when prepared and
+//! evaluate row-wise as before.
+
+use crate::cel::{CelCompiler, CelExpr};
+use crate::error::{Error, Result};
+use crate::render::is_expression;
+use crate::spec::{ConditionValue, Output, RuleState, Spec, VarType};
+use arrow::array::{
+    new_null_array, Array, ArrayRef, AsArray, BooleanArray, Datum, Float64Array, Int64Array,
+    RecordBatch, Scalar, StringArray, UInt32Array,
+};
+use arrow::compute::kernels::{boolean, cmp, numeric, zip::zip};
+use arrow::compute::{cast, take};
+use arrow::datatypes::{DataType, Field, Schema};
+use arrow::error::ArrowError;
+use cel_parser::{ast::operators, ast::Expr, reference::Val};
+use std::collections::BTreeMap;
+use std::sync::Arc;
+
+/// Name of the result column holding the deciding rule
+pub const RULE_COLUMN: &str = "rule";
+
+/// A spec prepared for columnar evaluation
+#[derive(Debug, Clone)]
+pub struct Columnar {
+    inputs: Vec<(String, DataType, Option<ConditionValue>)>,
+    outputs: Vec<(String, DataType)>,
+    rules: Vec<CompiledRule>,
+    default: Option<BTreeMap<String, Value>>,
+    schema: Arc<Schema>,
+}
+
+#[derive(Debug, Clone)]
+struct CompiledRule {
+    id: String,
+    /// `None` when the rule always applies
+    condition: Option<CelExpr>,
+    outputs: BTreeMap<String, Value>,
+}
+
+/// An output value: a literal or an expression over the inputs
+#[derive(Debug, Clone)]
+enum Value {
+    Literal(ConditionValue),
+    Expression(CelExpr),
+}
+
+/// A column, or a literal standing for every row (a one-element array)
+#[derive(Debug, Clone)]
+enum Operand {
+    Column(ArrayRef),
+    Literal(ArrayRef),
+}
+
+impl Columnar {
+    /// Prepare a spec, refusing what has no columnar translation
+    pub fn new(spec: &Spec) -> Result<Self> {
+        let unsupported = |reason: String| {
+            Error::Other(format!(
+                "'{}' cannot be evaluated column-wise: {}",
+                spec.id, reason
+            ))
+        };
+        if !spec.normalize.is_empty() {
+            return Err(unsupported("it normalizes its inputs".into()));
+        }
+        if spec.outputs.is_empty() {
+            return Err(unsupported("it declares no output types".into()));
+        }
+
+        let inputs = spec
+            .inputs
+            .iter()
+            .map(|var| {
+                let typ = data_type(&var.typ).ok_or_else(|| {
+                    unsupported(format!("input '{}' is a list or object", var.name))
+                })?;
+                Ok((var.name.clone(), typ, var.default.clone()))
+            })
+            .collect::<Result<Vec<_>>>()?;
+        let outputs = spec
+            .outputs
+            .iter()
+            .map(|var| {
+                let typ = data_type(&var.typ).ok_or_else(|| {
+                    unsupported(format!("output '{}' is a list or object", var.name))
+                })?;
+                Ok((var.name.clone(), typ))
+            })
+            .collect::<Result<Vec<_>>>()?;
+
+        let values = |output: &Output| -> Result<BTreeMap<String, Value>> {
+            let fields = match output {
+                Output::Single(ConditionValue::Map(map)) | Output::Named(map) => map
+                    .iter()
+                    .map(|(name, value)| (name.clone(), value.clone()))
+                    .collect(),
+                Output::Single(value) => {
+                    BTreeMap::from([(spec.outputs[0].name.clone(), value.clone())])
+                }
+            };
+            fields
+                .into_iter()
+                .map(|(name, value)| {
+                    let value = match value {
+                        ConditionValue::String(s)
+                            if spec.inputs.iter().any(|v| v.name == s) || is_expression(&s) =>
+                        {
+                            Value::Expression(CelCompiler::parse(&s)?)
+                        }
+                        other => Value::Literal(other),
+                    };
+                    Ok((name, value))
+                })
+                .collect()
+        };
+        let mut rules = Vec::new();
+        for rule in spec.evaluation_order() {
+            if !rule.state.is_active() {
+                continue;
+            }
+            if !rule.vars.is_empty() {
+                return Err(unsupported(format!("rule {} binds variables", rule.id)));
+            }
+            rules.push(CompiledRule {
+                id: rule.id.clone(),
+                condition: rule
+                    .as_cel()
+                    .map(|cel| CelCompiler::parse(&cel))
+                    .transpose()?,
+                outputs: values(&rule.then)?,
+            });
+        }
+
+        let mut fields = vec![Field::new(RULE_COLUMN, DataType::Utf8, true)];
+        fields.extend(
+            outputs
+                .iter()
+                .map(|(name, typ)| Field::new(name, typ.clone(), true)),
+        );
+        let columnar = Columnar {
+            inputs,
+            outputs,
+            rules,
+            default: spec.default.as_ref().map(values).transpose()?,
+            schema: Arc::new(Schema::new(fields)),
+        };
+
+        // Deciding no rows translates every condition and output once
+        let empty = Schema::new(
+            columnar
+                .inputs
+                .iter()
+                .map(|(name, typ, _)| Field::new(name, typ.clone(), true))
+                .collect::<Vec<_>>(),
+        );
+        columnar
+            .evaluate(&RecordBatch::new_empty(Arc::new(empty)))
+            .map_err(|e| unsupported(e.to_string()))?;
+        Ok(columnar)
+    }
+
+    /// Schema of the batches [`Columnar::evaluate`] returns
+    pub fn schema(&self) -> Arc<Schema> {
+        self.schema.clone()
+    }
+
+    /// Decide every row of a batch
+    ///
+    /// Input columns are found by name and cast to the declared input
+    /// types; a missing column takes the input's default. Rows no rule
+    /// decides are null when the spec has no default, as are rows where a
+    /// condition reads a null.
+    pub fn evaluate(&self, batch: &RecordBatch) -> Result<RecordBatch> {
+        let rows = Rows {
+            columnar: self,
+            batch,
+        };
+        let masks = self
+            .rules
+            .iter()
+            .map(|rule| match &rule.condition {
+                Some(condition) => rows.mask(condition),
+                None => Ok(BooleanArray::from(vec![true; batch.num_rows()])),
+            })
+            .collect::<Result<Vec<_>>>()?;
+
+        // Later rules first, so the first matching rule is zipped in last
+        let mut rule = new_null_array(&DataType::Utf8, batch.num_rows());
+        for (compiled, mask) in self.rules.iter().zip(&masks).rev() {
+            let id: ArrayRef = Arc::new(StringArray::from(vec![compiled.id.as_str()]));
+            rule = zip(mask, &Scalar::new(id), &rule).map_err(arrow_error)?;
+        }
+        let mut columns = vec![rule];
+        for (name, typ) in &self.outputs {
+            let fallback = match self.default.as_ref().and_then(|d| d.get(name)) {
+                Some(value) => rows.value(value, typ)?,
+                None => Operand::Literal(new_null_array(typ, 1)),
+            };
+            let mut column = rows.column(fallback)?;
+            for (compiled, mask) in self.rules.iter().zip(&masks).rev() {
+                let value = match compiled.outputs.get(name) {
+                    Some(value) => rows.value(value, typ)?,
+                    None => Operand::Literal(new_null_array(typ, 1)),
+                };
+                column = match &value {
+                    Operand::Column(array) => zip(mask, array, &column),
+                    Operand::Literal(array) => zip(mask, &Scalar::new(array.clone()), &column),
+                }
+                .map_err(arrow_error)?;
+            }
+            columns.push(column);
+        }
+        RecordBatch::try_new(self.schema.clone(), columns).map_err(arrow_error)
+    }
+}
+
+/// The rows of one batch being decided
+struct Rows<'a> {
+    columnar: &'a Columnar,
+    batch: &'a RecordBatch,
+}
+
+impl Rows<'_> {
+    /// Rows where a condition holds; null counts as not holding
+    fn mask(&self, condition: &CelExpr) -> Result<BooleanArray> {
+        let mask = self.column(self.operand(condition)?)?;
+        let Some(mask) = mask.as_boolean_opt() else {
+            return Err(Error::Other(format!(
+                "condition is {}, not a bool",
+                mask.data_type()
+            )));
+        };
+        Ok(match mask.null_count() {
+            0 => mask.clone(),
+            _ => mask.iter().map(|v| Some(v == Some(true))).collect(),
+        })
+    }
+
+    /// An output value as the output's type
+    fn value(&self, value: &Value, typ: &DataType) -> Result<Operand> {
+        let operand = match value {
+            Value::Expression(expr) => self.operand(expr)?,
+            Value::Literal(literal) => Operand::Literal(literal_array(literal)?),
+        };
+        Ok(match operand {
+            Operand::Column(array) => Operand::Column(cast(&array, typ).map_err(arrow_error)?),
+            Operand::Literal(array) => Operand::Literal(cast(&array, typ).map_err(arrow_error)?),
+        })
+    }
+
+    /// An operand with a value for every row
+    fn column(&self, operand: Operand) -> Result<ArrayRef> {
+        match operand {
+            Operand::Column(array) => Ok(array),
+            Operand::Literal(array) => {
+                let indices = UInt32Array::from(vec![0; self.batch.num_rows()]);
+                take(&array, &indices, None).map_err(arrow_error)
+            }
+        }
+    }
+
+    fn operand(&self, expr: &CelExpr) -> Result<Operand> {
+        match &expr.expr {
+            Expr::Ident(name) => self.input(name),
+            Expr::Literal(val) => {
+                let array: ArrayRef = match val {
+                    Val::Int(i) => Arc::new(Int64Array::from(vec![*i])),
+                    Val::UInt(u) => Arc::new(Int64Array::from(vec![*u as i64])),
+                    Val::Double(f) => Arc::new(Float64Array::from(vec![*f])),
+                    Val::String(s) => Arc::new(StringArray::from(vec![s.to_string()])),
+                    Val::Boolean(b) => Arc::new(BooleanArray::from(vec![*b])),
+                    Val::Null => new_null_array(&DataType::Null, 1),
+                    Val::Bytes(_) => return Err("bytes literal".into()),
+                };
+                Ok(Operand::Literal(array))
+            }
+            Expr::Call(call) => {
+                let name = call.func_name.as_str();
+                if call.target.is_some() {
+                    return Err(format!("method {}()", name).into());
+                }
+                if name == operators::IN {
+                    let Expr::List(list) = &call.args[1].expr else {
+                        return Err("'in' over a non-literal list".into());
+                    };
+                    let needle = self.operand(&call.args[0])?;
+                    let mut found = Operand::Literal(Arc::new(BooleanArray::from(vec![false])));
+                    for element in &list.elements {
+                        let equal =
+                            self.compare(operators::EQUALS, &needle, &self.operand(element)?)?;
+                        found = self.logical(operators::LOGICAL_OR, found, equal)?;
+                    }
+                    return Ok(found);
+                }
+
+                let args = call
+                    .args
+                    .iter()
+                    .map(|arg| self.operand(arg))
+                    .collect::<Result<Vec<_>>>()?;
+                match (name, args.as_slice()) {
+                    (operators::LOGICAL_AND | operators::LOGICAL_OR, [left, right]) => {
+                        self.logical(name, left.clone(), right.clone())
+                    }
+                    (operators::LOGICAL_NOT, [arg]) => {
+                        let negated = boolean::not(boolean_of(arg)?).map_err(arrow_error)?;
+                        Ok(arg.with(Arc::new(negated)))
+                    }
+                    (operators::NEGATE, [arg]) => {
+                        let negated = numeric::neg(arg.array()).map_err(arrow_error)?;
+                        Ok(arg.with(negated))
+                    }
+                    (
+                        operators::EQUALS
+                        | operators::NOT_EQUALS
+                        | operators::LESS
+                        | operators::LESS_EQUALS
+                        | operators::GREATER
+                        | operators::GREATER_EQUALS,
+                        [left, right],
+                    ) => self.compare(name, left, right),
+                    (
+                        operators::ADD
+                        | operators::SUBSTRACT
+                        | operators::MULTIPLY
+                        | operators::DIVIDE,
+                        [left, right],
+                    ) => {
+                        let (left, right) = unify(left, right)?;
+                        let kernel = match name {
+                            operators::ADD => numeric::add,
+                            operators::SUBSTRACT => numeric::sub,
+                            operators::MULTIPLY => numeric::mul,
+                            _ => numeric::div,
+                        };
+                        binary(&left, &right, |l, r| kernel(l, r))
+                    }
+                    (operators::CONDITIONAL, [condition, then, otherwise]) => {
+                        let mask = self.column(condition.clone())?;
+                        let mask = mask
+                            .as_boolean_opt()
+                            .ok_or("condition of '?:' is not a bool")?;
+                        let (then, otherwise) = unify(then, otherwise)?;
+                        let chosen = zip(mask, then.datum().as_ref(), otherwise.datum().as_ref())
+                            .map_err(arrow_error)?;
+                        Ok(Operand::Column(chosen))
+                    }
+                    _ => Err(format!("function or operator {}", name).into()),
+                }
+            }
+            Expr::Select(_) => Err("field access".into()),
+            _ => Err("list or map expression".into()),
+        }
+    }
+
+    /// The column of an input, or its default when the batch has none
+    fn input(&self, name: &str) -> Result<Operand> {
+        let Some((_, typ, default)) = self.columnar.inputs.iter().find(|(n, _, _)| n == name)
+        else {
+            return Err(format!("unknown name '{}'", name).into());
+        };
+        match (self.batch.column_by_name(name), default) {
+            (Some(column), _) => Ok(Operand::Column(cast(column, typ).map_err(arrow_error)?)),
+            (None, Some(default)) => Ok(Operand::Literal(
+                cast(&literal_array(default)?, typ).map_err(arrow_error)?,
+            )),
+            (None, None) => Err(format!("the batch has no column '{}'", name).into()),
+        }
+    }
+
+    fn compare(&self, op: &str, left: &Operand, right: &Operand) -> Result<Operand> {
+        let (left, right) = unify(left, right)?;
+        let kernel = match op {
+            operators::NOT_EQUALS => cmp::neq,
+            operators::LESS => cmp::lt,
+            operators::LESS_EQUALS => cmp::lt_eq,
+            operators::GREATER => cmp::gt,
+            operators::GREATER_EQUALS => cmp::gt_eq,
+            _ => cmp::eq,
+        };
+        binary(&left, &right, |l, r| {
+            kernel(l, r).map(|mask| Arc::new(mask) as ArrayRef)
+        })
+    }
+
+    fn logical(&self, op: &str, left: Operand, right: Operand) -> Result<Operand> {
+        let literal = matches!((&left, &right), (Operand::Literal(_), Operand::Literal(_)));
+        let (left, right) = if literal {
+            (left.array().clone(), right.array().clone())
+        } else {
+            (self.column(left)?, self.column(right)?)
+        };
+        let (Some(l), Some(r)) = (left.as_boolean_opt(), right.as_boolean_opt()) else {
+            return Err(format!("'{}' over values that are not bools", op).into());
+        };
+        let combined = match op {
+            operators::LOGICAL_AND => boolean::and(l, r),
+            _ => boolean::or(l, r),
+        }
+        .map_err(arrow_error)?;
+        let combined: ArrayRef = Arc::new(combined);
+        Ok(if literal {
+            Operand::Literal(combined)
+        } else {
+            Operand::Column(combined)
+        })
+    }
+}
+
+impl Operand {
+    fn array(&self) -> &ArrayRef {
+        match self {
+            Operand::Column(array) | Operand::Literal(array) => array,
+        }
+    }
+
+    /// The same kind of operand holding another array
+    fn with(&self, array: ArrayRef) -> Operand {
+        match self {
+            Operand::Column(_) => Operand::Column(array),
+            Operand::Literal(_) => Operand::Literal(array),
+        }
+    }
+
+    fn datum(&self) -> Box<dyn Datum> {
+        match self {
+            Operand::Column(array) => Box::new(array.clone()),
+            Operand::Literal(array) => Box::new(Scalar::new(array.clone())),
+        }
+    }
+}
+
+/// Apply a kernel; two literals give a literal
+fn binary(
+    left: &Operand,
+    right: &Operand,
+    kernel: impl Fn(&dyn Datum, &dyn Datum) -> std::result::Result<ArrayRef, ArrowError>,
+) -> Result<Operand> {
+    let result = kernel(left.datum().as_ref(), right.datum().as_ref()).map_err(arrow_error)?;
+    Ok(match (left, right) {
+        (Operand::Literal(_), Operand::Literal(_)) => Operand::Literal(result),
+        _ => Operand::Column(result),
+    })
+}
+
+/// Operands of one type: ints meeting floats become floats, and a null
+/// literal takes the other side's type
+fn unify(left: &Operand, right: &Operand) -> Result<(Operand, Operand)> {
+    let target = match (left.array().data_type(), right.array().data_type()) {
+        (DataType::Int64, DataType::Float64) | (DataType::Float64, DataType::Int64) => {
+            DataType::Float64
+        }
+        (DataType::Null, other) | (other, DataType::Null) => other.clone(),
+        (l, _) => l.clone(),
+    };
+    let cast_to = |operand: &Operand| -> Result<Operand> {
+        if operand.array().data_type() == &target {
+            return Ok(operand.clone());
+        }
+        let array = cast(operand.array(), &target).map_err(arrow_error)?;
+        Ok(operand.with(array))
+    };
+    Ok((cast_to(left)?, cast_to(right)?))
+}
+
+fn boolean_of(operand: &Operand) -> Result<&BooleanArray> {
+    operand
+        .array()
+        .as_boolean_opt()
+        .ok_or_else(|| "'!' over a value that is not a bool".into())
+}
+
+/// A spec literal as a one-element array
+fn literal_array(value: &ConditionValue) -> Result<ArrayRef> {
+    Ok(match value {
+        ConditionValue::Bool(b) => Arc::new(BooleanArray::from(vec![*b])),
+        ConditionValue::Int(i) => Arc::new(Int64Array::from(vec![*i])),
+        ConditionValue::Float(f) => Arc::new(Float64Array::from(vec![*f])),
+        ConditionValue::String(s) => Arc::new(StringArray::from(vec![s.as_str()])),
+        ConditionValue::Null => new_null_array(&DataType::Null, 1),
+        ConditionValue::List(_) | ConditionValue::Map(_) => return Err("list or map value".into()),
+    })
+}
+
+/// Arrow type of a spec type; `None` for lists and objects
+fn data_type(typ: &VarType) -> Option<DataType> {
+    match typ {
+        VarType::Bool => Some(DataType::Boolean),
+        VarType::Int => Some(DataType::Int64),
+        VarType::Float => Some(DataType::Float64),
+        VarType::String | VarType::Enum(_) => Some(DataType::Utf8),
+        VarType::List(_) | VarType::Object => None,
+    }
+}
+
+fn arrow_error(e: ArrowError) -> Error {
+    Error::Other(e.to_string())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::runtime::{evaluate, from_cel_value, to_cel_value, Values};
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+  - name: items
+    type: int
+  - name: zone
+    type: string
+    default: domestic
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: BULK
+    when: "items >= 10 && zone in ['domestic', 'eu']"
+    then: 2.0
+  - id: HEAVY
+    when: "weight_kg > 20.0 || !(zone == 'domestic')"
+    then: "weight_kg * 1.5"
+  - id: FREE
+    when: "items == 0"
+    then: 0.0
+default: 5.0
+"#;
+
+    fn batch() -> RecordBatch {
+        RecordBatch::try_from_iter(vec![
+            (
+                "weight_kg",
+                Arc::new(Float64Array::from(vec![
+                    Some(1.0),
+                    Some(30.0),
+                    Some(2.0),
+                    Some(4.0),
+                    Some(8.0),
+                    None,
+                ])) as ArrayRef,
+            ),
+            (
+                "items",
+                Arc::new(Int64Array::from(vec![12, 1, 1, 0, 3, 1])) as ArrayRef,
+            ),
+            (
+                "zone",
+                Arc::new(StringArray::from(vec![
+                    "eu", "domestic", "intl", "domestic", "domestic", "domestic",
+                ])) as ArrayRef,
+            ),
+        ])
+        .unwrap()
+    }
+
+    #[test]
+    fn test_evaluate_batch() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let columnar = Columnar::new(&spec).unwrap();
+        let result = columnar.evaluate(&batch()).unwrap();
+        assert_eq!(result.schema(), columnar.schema());
+
+        let rules: Vec<Option<&str>> = result.column(0).as_string::<i32>().iter().collect();
+        assert_eq!(
+            rules,
+            vec![
+                Some("BULK"),
+                Some("HEAVY"),
+                Some("HEAVY"),
+                Some("FREE"),
+                None,
+                None
+            ]
+        );
+        let rates = result
+            .column_by_name("rate")
+            .unwrap()
+            .as_primitive::<arrow::datatypes::Float64Type>();
+        assert_eq!(rates.value(0), 2.0);
+        assert_eq!(rates.value(1), 45.0);
+        assert_eq!(rates.value(2), 3.0);
+        assert_eq!(rates.value(3), 0.0);
+        assert_eq!(rates.value(4), 5.0);
+
+        // Row-wise evaluation agrees on every row without a null
+        let input = batch();
+        for row in 0..5 {
+            let values: Values = [
+                (
+                    "weight_kg",
+                    ConditionValue::Float(
+                        input
+                            .column(0)
+                            .as_primitive::<arrow::datatypes::Float64Type>()
+                            .value(row),
+                    ),
+                ),
+                (
+                    "items",
+                    ConditionValue::Int(
+                        input
+                            .column(1)
+                            .as_primitive::<arrow::datatypes::Int64Type>()
+                            .value(row),
+                    ),
+                ),
+                (
+                    "zone",
+                    ConditionValue::String(
+                        input.column(2).as_string::<i32>().value(row).to_string(),
+                    ),
+                ),
+            ]
+            .iter()
+            .map(|(name, value)| (name.to_string(), to_cel_value(value)))
+            .collect();
+            let evaluation = evaluate(&spec, &values).unwrap();
+            assert_eq!(evaluation.rule_id.as_deref(), rules[row]);
+            assert_eq!(
+                from_cel_value(&evaluation.outputs["rate"]),
+                ConditionValue::Float(rates.value(row))
+            );
+        }
+    }
+
+    #[test]
+    fn test_missing_column_takes_default() {
+        let columnar = Columnar::new(&Spec::from_yaml(SPEC).unwrap()).unwrap();
+        let batch = RecordBatch::try_from_iter(vec![
+            (
+                "weight_kg",
+                Arc::new(Float64Array::from(vec![1.0])) as ArrayRef,
+            ),
+            ("items", Arc::new(Int64Array::from(vec![10])) as ArrayRef),
+        ])
+        .unwrap();
+        let result = columnar.evaluate(&batch).unwrap();
+        assert_eq!(result.column(0).as_string::<i32>().value(0), "BULK");
+
+        let err = columnar
+            .evaluate(
+                &RecordBatch::try_from_iter(vec![("items", batch.column(1).clone())]).unwrap(),
+            )
+            .unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("the batch has no column 'weight_kg'"));
+    }
+
+    #[test]
+    fn test_unsupported_specs() {
+        let calls = SPEC.replace("items == 0", "zone.startsWith('d')");
+        let err = Columnar::new(&Spec::from_yaml(&calls).unwrap()).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("'shipping_rate' cannot be evaluated column-wise: method startsWith()"));
+
+        let untyped = SPEC.replace("outputs:\n  - name: rate\n    type: float\n", "");
+        assert!(Columnar::new(&Spec::from_yaml(&untyped).unwrap())
+            .unwrap_err()
+            .to_string()
+            .contains("declares no output types"));
+    }
+}
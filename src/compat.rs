@@ -0,0 +1,526 @@
+//! API compatibility between two versions of a spec
+//!
+//! Separates changes to the generated API (function name, input and output
+//! fields and their types) from changes to the rule logic behind it, so
+//! that releases can be gated on API stability while rules keep evolving.
+//!
+//! An API change is breaking when code written against the old generated
+//! API may stop compiling or start misreading results.
+//!
+//! The changes also decide the smallest version bump (`meta.version`) a new
+//! spec needs: major for breaking API changes, minor for API additions and
+//! patch for behavior changes behind an unchanged API.
+
+use crate::error::{Error, Result};
+use crate::spec::{Spec, VarType, Variable};
+use serde::Serialize;
+use std::collections::HashMap;
+
+/// Semantic version component a change requires bumping
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Bump {
+    None,
+    Patch,
+    Minor,
+    Major,
+}
+
+impl std::fmt::Display for Bump {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Bump::None => write!(f, "none"),
+            Bump::Patch => write!(f, "patch"),
+            Bump::Minor => write!(f, "minor"),
+            Bump::Major => write!(f, "major"),
+        }
+    }
+}
+
+/// Changes between two versions of a spec
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct CompatReport {
+    /// Changes to the generated API
+    pub api: Vec<ApiChange>,
+    /// Changes to rules, the default and rule resolution
+    pub logic: Vec<String>,
+}
+
+/// A change to the generated API
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct ApiChange {
+    pub breaking: bool,
+    pub message: String,
+}
+
+impl CompatReport {
+    /// Whether any API change breaks existing callers
+    pub fn is_breaking(&self) -> bool {
+        self.api.iter().any(|c| c.breaking)
+    }
+
+    /// Smallest version bump the changes call for
+    pub fn required_bump(&self) -> Bump {
+        if self.is_breaking() {
+            Bump::Major
+        } else if !self.api.is_empty() {
+            Bump::Minor
+        } else if !self.logic.is_empty() {
+            Bump::Patch
+        } else {
+            Bump::None
+        }
+    }
+
+    pub fn to_report(&self) -> String {
+        let mut out = String::new();
+        if self.is_breaking() {
+            out.push_str("BREAKING API CHANGES\n");
+        } else {
+            out.push_str("API COMPATIBLE\n");
+        }
+        out.push_str(&format!(
+            "Required version bump: {}\n",
+            self.required_bump()
+        ));
+
+        if !self.api.is_empty() {
+            out.push_str("\nAPI:\n");
+            for change in &self.api {
+                let marker = if change.breaking { "✗" } else { "+" };
+                out.push_str(&format!("  {} {}\n", marker, change.message));
+            }
+        }
+        if !self.logic.is_empty() {
+            out.push_str("\nRule logic:\n");
+            for change in &self.logic {
+                out.push_str(&format!("  ~ {}\n", change));
+            }
+        }
+        out
+    }
+}
+
+/// Compare an old and a new version of a spec
+pub fn compare(old: &Spec, new: &Spec) -> CompatReport {
+    let mut report = CompatReport::default();
+    let mut api =
+        |breaking: bool, message: String| report.api.push(ApiChange { breaking, message });
+
+    if old.id != new.id {
+        api(
+            true,
+            format!("spec renamed from '{}' to '{}'", old.id, new.id),
+        );
+    }
+
+    // Inputs: callers construct them
+    for input in &old.inputs {
+        match new.inputs.iter().find(|i| i.name == input.name) {
+            None => api(true, format!("input '{}' removed", input.name)),
+            Some(changed) => compare_field("input", input, changed, &mut api),
+        }
+    }
+    for input in &new.inputs {
+        if old.inputs.iter().all(|i| i.name != input.name) {
+            let required = !input.optional && input.default.is_none();
+            let message = if required {
+                format!("required input '{}' added", input.name)
+            } else {
+                format!("input '{}' added", input.name)
+            };
+            api(required, message);
+        }
+    }
+
+    // Outputs: callers read them
+    if (old.outputs.len() > 1) != (new.outputs.len() > 1) {
+        api(
+            true,
+            format!(
+                "result changes from {} to {} output(s), which changes the return type",
+                old.outputs.len(),
+                new.outputs.len()
+            ),
+        );
+    }
+    let removed: Vec<&Variable> = old
+        .outputs
+        .iter()
+        .filter(|o| new.outputs.iter().all(|n| n.name != o.name))
+        .collect();
+    let added: Vec<&Variable> = new
+        .outputs
+        .iter()
+        .filter(|n| old.outputs.iter().all(|o| o.name != n.name))
+        .collect();
+    for output in &old.outputs {
+        if let Some(changed) = new.outputs.iter().find(|o| o.name == output.name) {
+            compare_field("output", output, changed, &mut api);
+        }
+    }
+    match (removed.as_slice(), added.as_slice()) {
+        ([old_output], [new_output]) if old_output.typ == new_output.typ => api(
+            true,
+            format!(
+                "output '{}' renamed to '{}'",
+                old_output.name, new_output.name
+            ),
+        ),
+        _ => {
+            for output in &removed {
+                api(true, format!("output '{}' removed", output.name));
+            }
+            for output in &added {
+                api(false, format!("output '{}' added", output.name));
+            }
+        }
+    }
+
+    report.logic = compare_logic(old, new);
+    report
+}
+
+/// Check that the new spec's version was bumped enough for the changes
+///
+/// A spec whose previous version had no (semantic) version only needs to
+/// declare one.
+pub fn check_version(old: &Spec, new: &Spec, report: &CompatReport) -> Result<()> {
+    let bump = report.required_bump();
+    if bump == Bump::None {
+        return Ok(());
+    }
+    let new_version = match &new.meta.version {
+        Some(version) => version,
+        None => {
+            return Err(Error::Other(format!(
+                "{}: {} change, but meta.version is not set",
+                new.id, bump
+            )))
+        }
+    };
+    let required = match old
+        .meta
+        .version
+        .as_deref()
+        .and_then(|v| bump_version(v, bump))
+    {
+        Some(required) => required,
+        None => return Ok(()),
+    };
+    match (parse_version(new_version), parse_version(&required)) {
+        (Some(found), Some(needed)) if found >= needed => Ok(()),
+        _ => Err(Error::Other(format!(
+            "{}: {} change needs version {} or later, found {}",
+            new.id, bump, required, new_version
+        ))),
+    }
+}
+
+/// The next version after `version` for a bump, keeping any `v` prefix
+///
+/// Returns `None` when `version` is not `MAJOR[.MINOR[.PATCH]]`.
+pub fn bump_version(version: &str, bump: Bump) -> Option<String> {
+    let (major, minor, patch) = parse_version(version)?;
+    let (major, minor, patch) = match bump {
+        Bump::None => (major, minor, patch),
+        Bump::Patch => (major, minor, patch + 1),
+        Bump::Minor => (major, minor + 1, 0),
+        Bump::Major => (major + 1, 0, 0),
+    };
+    let prefix = if version.trim().starts_with('v') {
+        "v"
+    } else {
+        ""
+    };
+    Some(format!("{}{}.{}.{}", prefix, major, minor, patch))
+}
+
+fn parse_version(version: &str) -> Option<(u64, u64, u64)> {
+    let parts: Vec<u64> = version
+        .trim()
+        .trim_start_matches('v')
+        .split('.')
+        .map(|p| p.parse().ok())
+        .collect::<Option<_>>()?;
+    match parts.as_slice() {
+        [major] => Some((*major, 0, 0)),
+        [major, minor] => Some((*major, *minor, 0)),
+        [major, minor, patch] => Some((*major, *minor, *patch)),
+        _ => None,
+    }
+}
+
+/// Changes to a field present in both versions
+fn compare_field(kind: &str, old: &Variable, new: &Variable, api: &mut impl FnMut(bool, String)) {
+    match (&old.typ, &new.typ) {
+        (VarType::Enum(old_values), VarType::Enum(new_values)) => {
+            // Inputs may accept more values, outputs may produce fewer
+            let (narrowed, widened): (Vec<&String>, Vec<&String>) = (
+                old_values
+                    .iter()
+                    .filter(|v| !new_values.contains(v))
+                    .collect(),
+                new_values
+                    .iter()
+                    .filter(|v| !old_values.contains(v))
+                    .collect(),
+            );
+            let (breaking, compatible) = if kind == "input" {
+                (narrowed, widened)
+            } else {
+                (widened, narrowed)
+            };
+            for value in breaking {
+                let verb = if kind == "input" {
+                    "no longer accepts"
+                } else {
+                    "can now be"
+                };
+                api(
+                    true,
+                    format!("{} '{}' {} '{}'", kind, old.name, verb, value),
+                );
+            }
+            for value in compatible {
+                let verb = if kind == "input" {
+                    "now accepts"
+                } else {
+                    "is no longer"
+                };
+                api(
+                    false,
+                    format!("{} '{}' {} '{}'", kind, old.name, verb, value),
+                );
+            }
+        }
+        (old_type, new_type) if old_type != new_type => api(
+            true,
+            format!(
+                "{} '{}' changed type from {} to {}",
+                kind,
+                old.name,
+                type_name(old_type),
+                type_name(new_type)
+            ),
+        ),
+        _ => {}
+    }
+
+    if old.optional != new.optional {
+        let now = if new.optional { "optional" } else { "required" };
+        api(true, format!("{} '{}' is now {}", kind, old.name, now));
+    }
+    if old.unit != new.unit {
+        api(
+            true,
+            format!(
+                "{} '{}' changed unit from {} to {}",
+                kind,
+                old.name,
+                old.unit.as_deref().unwrap_or("none"),
+                new.unit.as_deref().unwrap_or("none")
+            ),
+        );
+    }
+    if old.currency != new.currency {
+        api(
+            true,
+            format!(
+                "{} '{}' changed currency from {} to {}",
+                kind,
+                old.name,
+                old.currency.as_deref().unwrap_or("none"),
+                new.currency.as_deref().unwrap_or("none")
+            ),
+        );
+    }
+}
+
+/// Rule-level differences, matched by rule ID
+fn compare_logic(old: &Spec, new: &Spec) -> Vec<String> {
+    let mut changes = Vec::new();
+    let old_rules: HashMap<&str, _> = old.rules.iter().map(|r| (r.id.as_str(), r)).collect();
+    let new_rules: HashMap<&str, _> = new.rules.iter().map(|r| (r.id.as_str(), r)).collect();
+
+    for rule in &old.rules {
+        match new_rules.get(rule.id.as_str()) {
+            None => changes.push(format!("rule {} removed", rule.id)),
+            Some(changed) => {
+                if rule.as_cel() != changed.as_cel() {
+                    changes.push(format!("rule {} condition changed", rule.id));
+                }
+                if rule.then.to_string() != changed.then.to_string() {
+                    changes.push(format!("rule {} output changed", rule.id));
+                }
+                if rule.priority != changed.priority {
+                    changes.push(format!(
+                        "rule {} priority changed from {} to {}",
+                        rule.id, rule.priority, changed.priority
+                    ));
+                }
+                if rule.state != changed.state {
+                    changes.push(format!(
+                        "rule {} state changed from {} to {}",
+                        rule.id, rule.state, changed.state
+                    ));
+                }
+            }
+        }
+    }
+    for rule in &new.rules {
+        if !old_rules.contains_key(rule.id.as_str()) {
+            changes.push(format!("rule {} added", rule.id));
+        }
+    }
+
+    // Only rules in both versions; additions and removals are reported above
+    let shared_order = |spec: &Spec| -> Vec<String> {
+        spec.evaluation_order()
+            .into_iter()
+            .filter(|r| {
+                old_rules.contains_key(r.id.as_str()) && new_rules.contains_key(r.id.as_str())
+            })
+            .map(|r| r.id.clone())
+            .collect()
+    };
+    if shared_order(old) != shared_order(new) {
+        changes.push("rule evaluation order changed".to_string());
+    }
+
+    let default = |spec: &Spec| spec.default.as_ref().map(|d| d.to_string());
+    if default(old) != default(new) {
+        changes.push("default changed".to_string());
+    }
+    changes
+}
+
+pub(crate) fn type_name(typ: &VarType) -> String {
+    match typ {
+        VarType::Bool => "bool".into(),
+        VarType::Int => "int".into(),
+        VarType::Float => "float".into(),
+        VarType::String => "string".into(),
+        VarType::Enum(values) => format!("enum [{}]", values.join(", ")),
+        VarType::List(inner) => format!("list of {}", type_name(inner)),
+        VarType::Object => "object".into(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const OLD: &str = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: !enum [domestic, international]
+  - name: weight
+    type: float
+outputs:
+  - name: rate
+    type: float
+  - name: carrier
+    type: string
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then:
+      rate: 5.0
+      carrier: "'ups'"
+  - id: R2
+    when: "weight > 10.0"
+    then:
+      rate: 20.0
+      carrier: "'fedex'"
+"#;
+
+    #[test]
+    fn test_rule_changes_are_not_api_changes() {
+        let old = Spec::from_yaml(OLD).unwrap();
+        let new = Spec::from_yaml(&OLD.replace("rate: 20.0", "rate: 25.0")).unwrap();
+        let report = compare(&old, &new);
+
+        assert!(report.api.is_empty());
+        assert!(!report.is_breaking());
+        assert_eq!(report.logic, vec!["rule R2 output changed"]);
+    }
+
+    #[test]
+    fn test_breaking_changes() {
+        let old = Spec::from_yaml(OLD).unwrap();
+        let new = Spec::from_yaml(
+            &OLD.replace("[domestic, international]", "[domestic]")
+                .replace("    type: float\noutputs", "    type: int\noutputs")
+                .replace("name: carrier", "name: provider")
+                .replace("carrier: ", "provider: ")
+                + "  - id: R3\n    when: \"weight > 50.0\"\n    then:\n      rate: 99.0\n      provider: \"'dhl'\"\n",
+        )
+        .unwrap();
+        let report = compare(&old, &new);
+
+        assert!(report.is_breaking());
+        let messages: Vec<&str> = report.api.iter().map(|c| c.message.as_str()).collect();
+        assert_eq!(
+            messages,
+            vec![
+                "input 'zone' no longer accepts 'international'",
+                "input 'weight' changed type from float to int",
+                "output 'carrier' renamed to 'provider'",
+            ]
+        );
+        assert!(report.logic.contains(&"rule R3 added".to_string()));
+    }
+
+    #[test]
+    fn test_version_bumps() {
+        assert_eq!(bump_version("1.4.2", Bump::Patch).as_deref(), Some("1.4.3"));
+        assert_eq!(bump_version("v1.4", Bump::Minor).as_deref(), Some("v1.5.0"));
+        assert_eq!(bump_version("1", Bump::Major).as_deref(), Some("2.0.0"));
+        assert_eq!(bump_version("next", Bump::Major), None);
+
+        let versioned = |version: &str, yaml: &str| {
+            Spec::from_yaml(&format!("meta:\n  version: \"{}\"\n{}", version, yaml)).unwrap()
+        };
+        let old = versioned("1.4.2", OLD);
+        let changed = OLD.replace("rate: 20.0", "rate: 25.0");
+        let report = compare(&old, &versioned("1.4.2", &changed));
+        assert_eq!(report.required_bump(), Bump::Patch);
+
+        assert!(check_version(&old, &versioned("1.4.2", &changed), &report).is_err());
+        assert!(check_version(&old, &versioned("1.4.3", &changed), &report).is_ok());
+        assert!(check_version(&old, &versioned("2.0.0", &changed), &report).is_ok());
+
+        let breaking = OLD
+            .replace("name: carrier", "name: provider")
+            .replace("carrier: ", "provider: ");
+        let report = compare(&old, &versioned("1.5.0", &breaking));
+        assert_eq!(report.required_bump(), Bump::Major);
+        let err = check_version(&old, &versioned("1.5.0", &breaking), &report).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("major change needs version 2.0.0 or later"));
+    }
+
+    #[test]
+    fn test_compatible_additions() {
+        let old = Spec::from_yaml(OLD).unwrap();
+        let new = Spec::from_yaml(&OLD.replace(
+            "outputs:",
+            "  - name: express\n    type: bool\n    optional: true\noutputs:",
+        ))
+        .unwrap();
+        let report = compare(&old, &new);
+
+        assert!(!report.is_breaking());
+        assert_eq!(
+            report.api,
+            vec![ApiChange {
+                breaking: false,
+                message: "input 'express' added".into()
+            }]
+        );
+        assert!(report.to_report().starts_with("API COMPATIBLE"));
+    }
+}
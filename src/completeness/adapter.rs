@@ -303,7 +303,7 @@ pub fn minimize_rules(rules: &[Rule], predicate_set: &PredicateSet) -> Vec<Strin
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::spec::{ConditionValue, Output, Rule};
+    use crate::spec::{ConditionValue, Output, Rule, RuleState};
 
     #[test]
     fn test_expression_to_cube() {
@@ -348,6 +348,14 @@ mod tests {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }];
 
         let cover = rules_to_cover(&rules, &pset);
@@ -760,7 +760,7 @@ impl IncompletenessReport {
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable};
+    use crate::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable};
 
     fn make_test_spec() -> Spec {
         Spec {
@@ -773,12 +773,26 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "amount".into(),
                     typ: VarType::Int,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -786,6 +800,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -795,6 +816,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(429)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -803,11 +832,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(200)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -851,12 +902,26 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -864,6 +929,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -873,6 +945,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -881,6 +961,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R3".into(),
@@ -889,6 +977,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(3)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R4".into(),
@@ -897,11 +993,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(4)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -915,12 +1033,26 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -930,6 +1062,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -938,11 +1078,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 1,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -958,12 +1120,26 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -971,6 +1147,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -980,6 +1163,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -988,6 +1179,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R3".into(),
@@ -996,11 +1195,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -1037,18 +1258,39 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "c".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -1056,6 +1298,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -1065,6 +1314,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -1073,6 +1330,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R3".into(),
@@ -1081,6 +1346,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(3)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R4".into(),
@@ -1089,6 +1362,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(4)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R5".into(),
@@ -1097,6 +1378,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(5)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R6".into(),
@@ -1105,6 +1394,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(6)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R7".into(),
@@ -1113,6 +1410,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(7)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R8".into(),
@@ -1121,11 +1426,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(8)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -1157,12 +1484,26 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -1170,6 +1511,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -1179,6 +1527,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -1187,11 +1543,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -1252,17 +1630,45 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -1282,12 +1688,26 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![Rule {
                 id: "R1".into(),
@@ -1300,10 +1720,32 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -1398,24 +1840,52 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "c".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "d".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -1423,6 +1893,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![Rule {
                 id: "R1".into(),
@@ -1431,10 +1908,32 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -1493,6 +1992,13 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             });
         }
 
@@ -1504,6 +2010,14 @@ mod tests {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         });
 
         let spec = Spec {
@@ -1516,11 +2030,32 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules,
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -1547,12 +2082,26 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -1566,6 +2115,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -1578,11 +2135,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let report = analyze_completeness(&spec);
@@ -119,6 +119,13 @@ mod tests {
                     typ: VarType::String,
                     description: None,
                     values: Some(vec!["standard".into()]),
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 }],
             ),
             (
@@ -128,6 +135,13 @@ mod tests {
                     typ: VarType::Int,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 }],
             ),
         ];
@@ -150,6 +164,13 @@ mod tests {
                     typ: VarType::String,
                     description: None,
                     values: Some(vec!["standard".into(), "premium".into()]),
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 }],
             ),
             (
@@ -159,6 +180,13 @@ mod tests {
                     typ: VarType::String,
                     description: None,
                     values: Some(vec!["new".into(), "returning".into()]),
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 }],
             ),
         ];
@@ -0,0 +1,296 @@
+//! Cross-spec consistency of enum-like string fields
+//!
+//! When several specs in a workspace read or write a `zone` field, they
+//! should agree on its values. For each shared string field this collects
+//! the literals every spec uses for it (declared `values`, literals compared
+//! against it in conditions, literals it is set to in outputs) and reports
+//! the field when
+//!
+//! - two specs spell what looks like the same value differently
+//!   (`'north_america'` vs `'na'`, `'EU'` vs `'eu'`), or
+//! - a spec uses a value another spec's declared `values` do not allow.
+
+use super::predicates::{extract_predicates, LiteralValue, Predicate};
+use crate::spec::{ConditionValue, Output, RuleState, Spec, VarType};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet};
+
+/// A string field whose values disagree across specs
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct EnumInconsistency {
+    pub field: String,
+    /// Literals each spec uses for the field, by spec id
+    pub values: BTreeMap<String, Vec<String>>,
+    /// Literals from different specs that likely name the same value
+    pub likely_same: Vec<(String, String)>,
+    /// Literals a spec uses that another spec's declared `values` exclude,
+    /// as (spec using it, literal, declaring spec)
+    pub undeclared: Vec<(String, String, String)>,
+}
+
+impl EnumInconsistency {
+    /// How to make the specs agree
+    pub fn suggestion(&self) -> String {
+        let all: BTreeSet<&String> = self.values.values().flatten().collect();
+        let specs: Vec<&str> = self.values.keys().map(|s| s.as_str()).collect();
+        format!(
+            "declare `{}` once as a shared enum (e.g. in a package) and use it from {}; values in use: {}",
+            self.field,
+            specs.join(", "),
+            all.into_iter()
+                .map(|v| format!("'{}'", v))
+                .collect::<Vec<_>>()
+                .join(", ")
+        )
+    }
+}
+
+impl std::fmt::Display for EnumInconsistency {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let mut reasons: Vec<String> = self
+            .likely_same
+            .iter()
+            .map(|(a, b)| format!("'{}' and '{}' look like the same value", a, b))
+            .collect();
+        reasons.extend(self.undeclared.iter().map(|(spec, value, declaring)| {
+            format!(
+                "{} uses '{}', which {} does not declare",
+                spec, value, declaring
+            )
+        }));
+        write!(
+            f,
+            "field `{}` is inconsistent across specs: {}",
+            self.field,
+            reasons.join("; ")
+        )
+    }
+}
+
+/// String fields shared by several specs whose values disagree
+pub fn enum_inconsistencies(specs: &[(String, &Spec)]) -> Vec<EnumInconsistency> {
+    // field -> spec id -> (literals used, declared values)
+    let mut fields: BTreeMap<String, BTreeMap<String, (BTreeSet<String>, Option<Vec<String>>)>> =
+        BTreeMap::new();
+    for (spec_id, spec) in specs {
+        for var in spec.inputs.iter().chain(&spec.outputs) {
+            if !matches!(var.typ, VarType::String | VarType::Enum(_)) {
+                continue;
+            }
+            let entry = fields
+                .entry(var.name.clone())
+                .or_default()
+                .entry(spec_id.clone())
+                .or_default();
+            if let Some(values) = &var.values {
+                entry.0.extend(values.iter().cloned());
+                entry.1 = Some(values.clone());
+            }
+            if let VarType::Enum(variants) = &var.typ {
+                entry.0.extend(variants.iter().cloned());
+                entry.1 = Some(variants.clone());
+            }
+        }
+        for (field, literal) in spec_literals(spec) {
+            if let Some(entry) = fields.get_mut(&field).and_then(|f| f.get_mut(spec_id)) {
+                entry.0.insert(literal);
+            }
+        }
+    }
+
+    let mut issues = Vec::new();
+    for (field, by_spec) in fields {
+        if by_spec.len() < 2 {
+            continue;
+        }
+        let mut likely_same = Vec::new();
+        let mut undeclared = Vec::new();
+        for (spec_a, (used_a, _)) in &by_spec {
+            for (spec_b, (used_b, declared_b)) in &by_spec {
+                if spec_a == spec_b {
+                    continue;
+                }
+                for a in used_a.difference(used_b) {
+                    if declared_b.is_some() {
+                        undeclared.push((spec_a.clone(), a.clone(), spec_b.clone()));
+                    }
+                    if spec_a < spec_b {
+                        for b in used_b.difference(used_a) {
+                            if same_value(a, b) {
+                                likely_same.push((a.clone(), b.clone()));
+                            }
+                        }
+                    }
+                }
+            }
+        }
+        if likely_same.is_empty() && undeclared.is_empty() {
+            continue;
+        }
+        issues.push(EnumInconsistency {
+            field,
+            values: by_spec
+                .into_iter()
+                .map(|(spec, (used, _))| (spec, used.into_iter().collect()))
+                .collect(),
+            likely_same,
+            undeclared,
+        });
+    }
+    issues
+}
+
+/// (field, string literal) pairs a spec's conditions and outputs use
+fn spec_literals(spec: &Spec) -> Vec<(String, String)> {
+    let mut literals = Vec::new();
+    for rule in &spec.rules {
+        if rule.state == RuleState::Retired {
+            continue;
+        }
+        for conjunct in rule.conjuncts() {
+            for predicate in extract_predicates(&conjunct).unwrap_or_default() {
+                match predicate {
+                    Predicate::Equality {
+                        var,
+                        value: LiteralValue::String(s),
+                        ..
+                    } => literals.push((var, s)),
+                    Predicate::Membership { var, values, .. } => {
+                        for value in values {
+                            if let LiteralValue::String(s) = value {
+                                literals.push((var.clone(), s));
+                            }
+                        }
+                    }
+                    _ => {}
+                }
+            }
+        }
+    }
+
+    let outputs = spec.rules.iter().map(|r| &r.then).chain(&spec.default);
+    for output in outputs {
+        let fields: Vec<(&String, &ConditionValue)> = match output {
+            Output::Single(value) => spec
+                .outputs
+                .first()
+                .map(|o| (&o.name, value))
+                .into_iter()
+                .collect(),
+            Output::Named(fields) => fields.iter().collect(),
+        };
+        for (name, value) in fields {
+            if let ConditionValue::String(s) = value {
+                let computed =
+                    spec.inputs.iter().any(|i| &i.name == s) || crate::render::is_expression(s);
+                if !computed {
+                    literals.push((name.clone(), s.clone()));
+                }
+            }
+        }
+    }
+    literals
+}
+
+/// Whether two literals likely name the same value: they differ only in
+/// case or separators, or one is the initials of the other
+fn same_value(a: &str, b: &str) -> bool {
+    let normalize = |s: &str| {
+        s.chars()
+            .filter(|c| !matches!(c, '_' | '-' | ' ' | '.'))
+            .flat_map(char::to_lowercase)
+            .collect::<String>()
+    };
+    let initials = |s: &str| {
+        let parts: Vec<&str> = s.split(['_', '-', ' ']).filter(|p| !p.is_empty()).collect();
+        (parts.len() > 1).then(|| {
+            parts
+                .iter()
+                .filter_map(|p| p.chars().next())
+                .flat_map(char::to_lowercase)
+                .collect::<String>()
+        })
+    };
+    normalize(a) == normalize(b)
+        || initials(a).is_some_and(|i| i == normalize(b))
+        || initials(b).is_some_and(|i| i == normalize(a))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn spec(yaml: &str) -> Spec {
+        Spec::from_yaml(yaml).unwrap()
+    }
+
+    #[test]
+    fn test_enum_inconsistencies() {
+        let shipping = spec(
+            r#"
+id: shipping
+inputs:
+  - name: zone
+    type: string
+    values: [north_america, europe]
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "zone == 'north_america'"
+    then: 5
+default: 10
+"#,
+        );
+        let tax = spec(
+            r#"
+id: tax
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "zone in ['na', 'europe']"
+    then: 0
+default: 20
+"#,
+        );
+        let issues = enum_inconsistencies(&[("shipping".into(), &shipping), ("tax".into(), &tax)]);
+        assert_eq!(issues.len(), 1);
+        let issue = &issues[0];
+        assert_eq!(issue.field, "zone");
+        assert_eq!(
+            issue.likely_same,
+            vec![("north_america".to_string(), "na".to_string())]
+        );
+        assert_eq!(
+            issue.undeclared,
+            vec![("tax".to_string(), "na".to_string(), "shipping".to_string())]
+        );
+        assert_eq!(
+            issue.to_string(),
+            "field `zone` is inconsistent across specs: 'north_america' and 'na' look like the same value; tax uses 'na', which shipping does not declare"
+        );
+        assert!(issue
+            .suggestion()
+            .contains("'europe', 'na', 'north_america'"));
+    }
+
+    #[test]
+    fn test_consistent_subsets() {
+        let a = spec(
+            "id: a\ninputs:\n  - name: zone\n    type: string\noutputs:\n  - name: r\n    type: int\nrules:\n  - id: R1\n    when: \"zone == 'eu'\"\n    then: 1\n",
+        );
+        let b = spec(
+            "id: b\ninputs:\n  - name: zone\n    type: string\n    values: [eu, us]\noutputs:\n  - name: r\n    type: int\nrules:\n  - id: R1\n    when: \"zone == 'us'\"\n    then: 1\n",
+        );
+        assert!(enum_inconsistencies(&[("a".into(), &a), ("b".into(), &b)]).is_empty());
+        assert!(same_value("North-America", "north_america"));
+        assert!(!same_value("eu", "us"));
+    }
+}
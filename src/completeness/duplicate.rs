@@ -131,7 +131,7 @@ fn check_rule_overlap(cel_a: &str, cel_b: &str, predicate_set: &PredicateSet) ->
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::spec::{ConditionValue, Output, Rule, VarType, Variable};
+    use crate::spec::{ConditionValue, Output, Rule, RuleState, VarType, Variable};
 
     fn make_test_spec(id: &str, rules: Vec<Rule>) -> Spec {
         Spec {
@@ -143,17 +143,45 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules,
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -168,6 +196,14 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }],
         );
         let spec_b = make_test_spec(
@@ -179,6 +215,14 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(2)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }],
         );
 
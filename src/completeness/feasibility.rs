@@ -0,0 +1,553 @@
+//! Feasibility of CEL conditions
+//!
+//! Conditions are split into conjuncts, and each conjunct becomes an atomic
+//! predicate as in the completeness analysis. A set of conditions is
+//! satisfiable when some truth assignment to its predicates makes each hold
+//! (or not) as required, and the values each input must then take can
+//! coexist: `amount > 100 && amount < 50` cannot, nor can
+//! `tier == "gold" && tier == "silver"`, nor a value outside the input's
+//! declared `values`. Conjuncts that are not simple comparisons are treated
+//! as independent unknowns.
+//!
+//! Used by path enumeration and to find rules that can never match or
+//! always match.
+
+use super::predicates::{extract_predicates, ComparisonOp, LiteralValue, Predicate};
+use crate::cel::CelCompiler;
+use crate::spec::{RuleState, Spec, VarType, Variable};
+use cel_parser::ast::{operators, Expr};
+use cel_parser::reference::Val;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// Most distinct predicates on one path that feasibility is decided for;
+/// paths with more are assumed feasible
+const MAX_ATOMS: usize = 16;
+
+/// An atomic condition
+#[derive(Debug, Clone)]
+pub(super) enum Atom {
+    Predicate(Predicate),
+    /// A conjunct with no predicate form, by its CEL text
+    Opaque(String),
+}
+
+/// The atoms of the conditions seen so far; a literal is an atom index and
+/// the value it must have
+#[derive(Debug, Default)]
+pub(super) struct Atoms {
+    atoms: Vec<Atom>,
+    index: HashMap<String, usize>,
+}
+
+impl Atoms {
+    pub(super) fn literals(&mut self, conjuncts: &[String]) -> Vec<(usize, bool)> {
+        conjuncts
+            .iter()
+            .filter_map(|conjunct| {
+                let (atom, holds) = classify(conjunct)?;
+                Some(self.add(atom, holds))
+            })
+            .collect()
+    }
+
+    fn add(&mut self, atom: Atom, holds: bool) -> (usize, bool) {
+        let (key, negated_key) = match &atom {
+            Atom::Predicate(p @ Predicate::BoolVar(_)) => (p.to_cel_string(), None),
+            Atom::Predicate(p) => (p.to_cel_string(), Some(p.negated().to_cel_string())),
+            Atom::Opaque(cel) => (cel.clone(), None),
+        };
+        if let Some(&i) = self.index.get(&key) {
+            return (i, holds);
+        }
+        if let Some(&i) = negated_key.as_ref().and_then(|k| self.index.get(k)) {
+            return (i, !holds);
+        }
+        self.index.insert(key, self.atoms.len());
+        self.atoms.push(atom);
+        (self.atoms.len() - 1, holds)
+    }
+
+    /// CEL for "not all of these conjuncts hold"
+    pub(super) fn negation(&self, conjuncts: &[String], literals: &[(usize, bool)]) -> String {
+        match (conjuncts, literals) {
+            ([], _) => "false".to_string(),
+            ([_], [(i, holds)]) => match (&self.atoms[*i], *holds) {
+                (Atom::Predicate(Predicate::BoolVar(name)), true) => format!("!{}", name),
+                (Atom::Predicate(Predicate::BoolVar(name)), false) => name.clone(),
+                (Atom::Predicate(p), true) => p.negated().to_cel_string(),
+                (Atom::Predicate(p), false) => p.to_cel_string(),
+                (Atom::Opaque(cel), _) => format!("!({})", cel),
+            },
+            _ => format!("!({})", conjuncts.join(" && ")),
+        }
+    }
+
+    /// Whether some assignment makes each literal set hold entirely (or not)
+    /// as required, with the input values it implies able to coexist
+    pub(super) fn satisfiable(
+        &self,
+        requirements: &[(&[(usize, bool)], bool)],
+        domains: &Domains,
+    ) -> bool {
+        let mut used: Vec<usize> = requirements
+            .iter()
+            .flat_map(|(literals, _)| literals.iter().map(|(i, _)| *i))
+            .collect();
+        used.sort_unstable();
+        used.dedup();
+        if used.len() > MAX_ATOMS {
+            return true;
+        }
+
+        let position: HashMap<usize, usize> =
+            used.iter().enumerate().map(|(pos, &i)| (i, pos)).collect();
+        (0..1u64 << used.len()).any(|combo| {
+            let value = |i: usize| (combo >> position[&i]) & 1 == 1;
+            requirements.iter().all(|(literals, holds)| {
+                literals.iter().all(|&(i, want)| value(i) == want) == *holds
+            }) && domains.admit(used.iter().map(|&i| (&self.atoms[i], value(i))))
+        })
+    }
+}
+
+/// The atom a conjunct tests and whether it must hold; `None` for `true`
+fn classify(conjunct: &str) -> Option<(Atom, bool)> {
+    let opaque = || Some((Atom::Opaque(conjunct.trim().to_string()), true));
+    let Ok(ast) = CelCompiler::parse(conjunct) else {
+        return opaque();
+    };
+    let mut expr = &ast;
+    let mut holds = true;
+    while let Expr::Call(call) = &expr.expr {
+        if call.func_name != operators::LOGICAL_NOT || call.args.len() != 1 {
+            break;
+        }
+        expr = &call.args[0];
+        holds = !holds;
+    }
+    match &expr.expr {
+        Expr::Literal(Val::Boolean(b)) if *b == holds => None,
+        Expr::Literal(Val::Boolean(_)) => Some((Atom::Opaque("false".to_string()), true)),
+        Expr::Ident(name) if name.as_str() != "true" && name.as_str() != "false" => {
+            Some((Atom::Predicate(Predicate::BoolVar(name.to_string())), holds))
+        }
+        Expr::Call(call)
+            if matches!(
+                call.func_name.as_str(),
+                operators::EQUALS
+                    | operators::NOT_EQUALS
+                    | operators::LESS
+                    | operators::LESS_EQUALS
+                    | operators::GREATER
+                    | operators::GREATER_EQUALS
+                    | operators::IN
+            ) =>
+        {
+            // extract_predicates folds the negations into the predicate
+            match extract_predicates(conjunct).ok().as_deref() {
+                Some([predicate]) => Some((Atom::Predicate(predicate.clone()), true)),
+                _ => opaque(),
+            }
+        }
+        _ => opaque(),
+    }
+}
+
+/// What is known about the values of the inputs
+#[derive(Debug, Default)]
+pub(super) struct Domains {
+    pub(super) inputs: HashMap<String, Domain>,
+}
+
+#[derive(Debug, Clone, Default)]
+pub(super) struct Domain {
+    integer: bool,
+    /// Declared values, when the input has a closed set
+    values: Option<Vec<LiteralValue>>,
+}
+
+impl Domain {
+    pub(super) fn of(typ: &VarType, values: Option<&Vec<String>>) -> Self {
+        let strings = |values: &Vec<String>| {
+            values
+                .iter()
+                .map(|v| LiteralValue::String(v.clone()))
+                .collect()
+        };
+        Domain {
+            integer: *typ == VarType::Int,
+            values: match typ {
+                VarType::Bool => Some(vec![LiteralValue::Bool(false), LiteralValue::Bool(true)]),
+                VarType::Enum(values) => Some(strings(values)),
+                _ => values.map(strings),
+            },
+        }
+    }
+}
+
+impl Domains {
+    pub(super) fn from_variables(vars: &[Variable]) -> Self {
+        Domains {
+            inputs: vars
+                .iter()
+                .map(|var| (var.name.clone(), Domain::of(&var.typ, var.values.as_ref())))
+                .collect(),
+        }
+    }
+
+    /// Whether the atoms can take these values at once
+    fn admit<'a>(&self, values: impl Iterator<Item = (&'a Atom, bool)>) -> bool {
+        let mut bounds: HashMap<String, Bounds> = HashMap::new();
+        for (atom, holds) in values {
+            let predicate = match atom {
+                Atom::Predicate(predicate) => predicate,
+                Atom::Opaque(cel) if cel == "false" && holds => return false,
+                Atom::Opaque(_) => continue,
+            };
+            let predicate = if holds {
+                predicate.clone()
+            } else {
+                predicate.negated()
+            };
+            match predicate {
+                Predicate::Comparison { var, op, value } => {
+                    if let Some(v) = numeric(&value) {
+                        let b = bounds.entry(var).or_default();
+                        match op {
+                            ComparisonOp::Gt => b.raise(v, true),
+                            ComparisonOp::Ge => b.raise(v, false),
+                            ComparisonOp::Lt => b.lower(v, true),
+                            ComparisonOp::Le => b.lower(v, false),
+                        }
+                    }
+                }
+                Predicate::Equality {
+                    var,
+                    value,
+                    negated,
+                } => {
+                    let b = bounds.entry(var).or_default();
+                    if negated {
+                        b.excluded.push(value);
+                    } else {
+                        b.allow(vec![value]);
+                    }
+                }
+                Predicate::Membership {
+                    var,
+                    values,
+                    negated,
+                } => {
+                    let b = bounds.entry(var).or_default();
+                    if negated {
+                        b.excluded.extend(values);
+                    } else {
+                        b.allow(values);
+                    }
+                }
+                Predicate::BoolVar(_) | Predicate::StringOp { .. } => {}
+            }
+        }
+
+        bounds.into_iter().all(|(var, mut b)| {
+            let domain = self.inputs.get(&var).cloned().unwrap_or_default();
+            if let Some(values) = domain.values {
+                b.allow(values);
+            }
+            b.admits_some(domain.integer)
+        })
+    }
+}
+
+/// Constraints on one input's value
+#[derive(Debug, Default)]
+struct Bounds {
+    /// Lower bound and whether it is strict
+    low: Option<(f64, bool)>,
+    /// Upper bound and whether it is strict
+    high: Option<(f64, bool)>,
+    /// The value is one of these
+    allowed: Option<Vec<LiteralValue>>,
+    /// The value is none of these
+    excluded: Vec<LiteralValue>,
+}
+
+impl Bounds {
+    fn raise(&mut self, v: f64, strict: bool) {
+        if self
+            .low
+            .map_or(true, |(low, s)| v > low || (v == low && strict && !s))
+        {
+            self.low = Some((v, strict));
+        }
+    }
+
+    fn lower(&mut self, v: f64, strict: bool) {
+        if self
+            .high
+            .map_or(true, |(high, s)| v < high || (v == high && strict && !s))
+        {
+            self.high = Some((v, strict));
+        }
+    }
+
+    fn allow(&mut self, values: Vec<LiteralValue>) {
+        self.allowed = Some(match self.allowed.take() {
+            None => values,
+            Some(allowed) => allowed
+                .into_iter()
+                .filter(|a| values.iter().any(|v| same(a, v)))
+                .collect(),
+        });
+    }
+
+    fn contains(&self, v: f64, integer: bool) -> bool {
+        if integer && v.fract() != 0.0 {
+            return false;
+        }
+        self.low
+            .map_or(true, |(low, strict)| v > low || (!strict && v == low))
+            && self
+                .high
+                .map_or(true, |(high, strict)| v < high || (!strict && v == high))
+    }
+
+    fn admits_some(&self, integer: bool) -> bool {
+        if let Some(allowed) = &self.allowed {
+            return allowed.iter().any(|value| {
+                !self.excluded.iter().any(|e| same(e, value))
+                    && numeric(value).map_or(true, |v| self.contains(v, integer))
+            });
+        }
+        match (self.low, self.high) {
+            (Some((low, low_strict)), Some((high, high_strict))) => {
+                if integer {
+                    let low = if low_strict {
+                        low.floor() + 1.0
+                    } else {
+                        low.ceil()
+                    };
+                    let high = if high_strict {
+                        high.ceil() - 1.0
+                    } else {
+                        high.floor()
+                    };
+                    low <= high
+                } else {
+                    low < high || (low == high && !low_strict && !high_strict)
+                }
+            }
+            _ => true,
+        }
+    }
+}
+
+fn numeric(value: &LiteralValue) -> Option<f64> {
+    match value {
+        LiteralValue::Int(i) => Some(*i as f64),
+        LiteralValue::Float(f) => Some(*f),
+        _ => None,
+    }
+}
+
+fn same(a: &LiteralValue, b: &LiteralValue) -> bool {
+    match (numeric(a), numeric(b)) {
+        (Some(x), Some(y)) => x == y,
+        _ => a == b,
+    }
+}
+
+/// Why a rule's condition is suspect
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum ConditionIssueKind {
+    /// No input satisfies the condition, so the rule never matches
+    Unsatisfiable,
+    /// Every input satisfies the condition
+    AlwaysTrue,
+}
+
+/// A rule whose condition never holds, or always does
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct ConditionIssue {
+    pub rule_id: String,
+    pub kind: ConditionIssueKind,
+    /// The conjuncts responsible: a smallest set that cannot hold together,
+    /// or every conjunct of an always-true condition
+    pub conjuncts: Vec<String>,
+}
+
+impl std::fmt::Display for ConditionIssue {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let quoted: Vec<String> = self.conjuncts.iter().map(|c| format!("`{}`", c)).collect();
+        match (self.kind, quoted.as_slice()) {
+            (ConditionIssueKind::Unsatisfiable, [one]) => {
+                write!(
+                    f,
+                    "rule {} never matches: {} is never true",
+                    self.rule_id, one
+                )
+            }
+            (ConditionIssueKind::Unsatisfiable, _) => write!(
+                f,
+                "rule {} never matches: {} contradict each other",
+                self.rule_id,
+                quoted.join(" and ")
+            ),
+            (ConditionIssueKind::AlwaysTrue, [one]) => {
+                write!(
+                    f,
+                    "rule {} always matches: {} is always true",
+                    self.rule_id, one
+                )
+            }
+            (ConditionIssueKind::AlwaysTrue, _) => write!(
+                f,
+                "rule {} always matches: {} are always true",
+                self.rule_id,
+                quoted.join(" and ")
+            ),
+        }
+    }
+}
+
+/// Rules whose conditions can never hold or always hold
+///
+/// Retired rules and rules without a condition (deliberate catch-alls) are
+/// not checked.
+pub fn condition_issues(spec: &Spec) -> Vec<ConditionIssue> {
+    let domains = Domains::from_variables(&spec.inputs);
+    let mut atoms = Atoms::default();
+    let mut issues = Vec::new();
+    for rule in &spec.rules {
+        let conjuncts = rule.conjuncts();
+        if rule.state == RuleState::Retired || conjuncts.is_empty() {
+            continue;
+        }
+        let literals: Vec<Vec<(usize, bool)>> = conjuncts
+            .iter()
+            .map(|c| atoms.literals(std::slice::from_ref(c)))
+            .collect();
+        let holds = |keep: &[usize]| {
+            let all: Vec<(usize, bool)> = keep.iter().flat_map(|&i| literals[i].clone()).collect();
+            atoms.satisfiable(&[(all.as_slice(), true)], &domains)
+        };
+
+        let mut core: Vec<usize> = (0..conjuncts.len()).collect();
+        if !holds(&core) {
+            // Drop every conjunct the contradiction does not need
+            for i in 0..conjuncts.len() {
+                let without: Vec<usize> = core.iter().copied().filter(|&j| j != i).collect();
+                if !holds(&without) {
+                    core = without;
+                }
+            }
+            issues.push(ConditionIssue {
+                rule_id: rule.id.clone(),
+                kind: ConditionIssueKind::Unsatisfiable,
+                conjuncts: core
+                    .iter()
+                    .map(|&i| conjuncts[i].trim().to_string())
+                    .collect(),
+            });
+        } else if literals
+            .iter()
+            .all(|l| !atoms.satisfiable(&[(l.as_slice(), false)], &domains))
+        {
+            issues.push(ConditionIssue {
+                rule_id: rule.id.clone(),
+                kind: ConditionIssueKind::AlwaysTrue,
+                conjuncts: conjuncts.iter().map(|c| c.trim().to_string()).collect(),
+            });
+        }
+    }
+    issues
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_integer_bounds() {
+        let spec = Spec::from_yaml("id: counter\ninputs:\n  - name: n\n    type: int\n").unwrap();
+        let domains = Domains::from_variables(&spec.inputs);
+        let mut atoms = Atoms::default();
+        let between = atoms.literals(&["n > 1".into(), "n < 2".into()]);
+        assert!(!atoms.satisfiable(&[(between.as_slice(), true)], &domains));
+        let equal = atoms.literals(&["n >= 2".into(), "n <= 2".into()]);
+        assert!(atoms.satisfiable(&[(equal.as_slice(), true)], &domains));
+        // A literal and its negated form share one atom
+        let above = atoms.literals(&["n > 1".into()]);
+        let not_above = atoms.literals(&["n <= 1".into()]);
+        assert_eq!(above[0].0, not_above[0].0);
+        assert!(!atoms.satisfiable(
+            &[(above.as_slice(), true), (not_above.as_slice(), true)],
+            &domains
+        ));
+    }
+
+    #[test]
+    fn test_condition_issues() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping
+inputs:
+  - name: zone
+    type: string
+    values: [domestic, international]
+  - name: weight_kg
+    type: float
+  - name: express
+    type: bool
+rules:
+  - id: BOTH_ZONES
+    when: "weight_kg > 1.0 && zone == 'domestic' && express && zone == 'international'"
+    then: 1.0
+  - id: NEGATIVE
+    when: "weight_kg < 0.0 && weight_kg >= 0.0"
+    then: 2.0
+  - id: ANY_ZONE
+    when: "zone in ['domestic', 'international']"
+    then: 3.0
+  - id: UNKNOWN_ZONE
+    when: "zone == 'moon'"
+    then: 4.0
+  - id: FINE
+    when: "zone == 'domestic' && weight_kg > 1.0"
+    then: 5.0
+  - id: CATCH_ALL
+    then: 6.0
+"#,
+        )
+        .unwrap();
+
+        let issues = condition_issues(&spec);
+        assert_eq!(
+            issues
+                .iter()
+                .map(|i| (i.rule_id.as_str(), i.kind))
+                .collect::<Vec<_>>(),
+            vec![
+                ("BOTH_ZONES", ConditionIssueKind::Unsatisfiable),
+                ("NEGATIVE", ConditionIssueKind::Unsatisfiable),
+                ("ANY_ZONE", ConditionIssueKind::AlwaysTrue),
+                ("UNKNOWN_ZONE", ConditionIssueKind::Unsatisfiable),
+            ]
+        );
+        assert_eq!(
+            issues[0].conjuncts,
+            vec!["zone == 'domestic'", "zone == 'international'"]
+        );
+        assert_eq!(
+            issues[0].to_string(),
+            "rule BOTH_ZONES never matches: `zone == 'domestic'` and `zone == 'international'` contradict each other"
+        );
+        assert_eq!(
+            issues[3].to_string(),
+            "rule UNKNOWN_ZONE never matches: `zone == 'moon'` is never true"
+        );
+    }
+}
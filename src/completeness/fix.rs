@@ -1,7 +1,7 @@
 //! Apply fixes to spec files
 //!
 //! This module provides functionality to apply structured fixes to YAML spec files.
-//! It preserves formatting where possible and applies fixes based on FixOperation.
+//! It preserves comments (see [`crate::yaml`]) and applies fixes based on FixOperation.
 
 use crate::completeness::{FixConfidence, FixOperation, SpecFix};
 use crate::error::Error;
@@ -137,7 +137,7 @@ fn apply_fix(spec: &mut Spec, fix: &SpecFix) -> Result<(), Error> {
     }
 }
 
-/// Apply fixes to a YAML file, preserving formatting where possible
+/// Apply fixes to a YAML file, preserving its comments
 pub fn apply_fixes_to_yaml(
     yaml_content: &str,
     fixes: &[SpecFix],
@@ -150,7 +150,7 @@ pub fn apply_fixes_to_yaml(
     let result = apply_fixes(&mut spec, fixes, apply_all);
 
     // Convert back to YAML
-    let new_yaml = spec.to_yaml()?;
+    let new_yaml = spec.to_yaml_preserving(yaml_content)?;
 
     Ok((new_yaml, result))
 }
@@ -158,7 +158,7 @@ pub fn apply_fixes_to_yaml(
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::spec::{ConditionValue, Output, VarType};
+    use crate::spec::{ConditionValue, Output, RuleState, VarType};
 
     fn make_test_spec() -> Spec {
         Spec {
@@ -170,12 +170,26 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![crate::spec::Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 crate::spec::Rule {
@@ -185,6 +199,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 crate::spec::Rule {
                     id: "R2".into(),
@@ -193,11 +215,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -282,4 +326,23 @@ mod tests {
         assert_eq!(spec.rules[0].priority, 1);
         assert_eq!(spec.rules.len(), 2); // R2 not deleted
     }
+
+    #[test]
+    fn test_apply_fixes_to_yaml_keeps_comments() {
+        let yaml = "# Access rules\nid: test\ninputs:\n  - name: a\n    type: bool\noutputs:\n  - name: r\n    type: int\nrules:\n  # Main rule\n  - id: R1\n    when: a\n    then: 1 # one\n";
+        let fixes = vec![SpecFix {
+            issue_code: "V001".into(),
+            confidence: FixConfidence::High,
+            operation: FixOperation::AddPriority {
+                rule_id: "R1".into(),
+                priority: 5,
+            },
+            description: "Add priority".into(),
+        }];
+        let (new_yaml, result) = apply_fixes_to_yaml(yaml, &fixes, false).unwrap();
+        assert_eq!(result.applied.len(), 1);
+        assert!(new_yaml.starts_with("# Access rules\n"));
+        assert!(new_yaml.contains("# Main rule\n- id: R1"));
+        assert!(new_yaml.contains("then: 1 # one"));
+    }
 }
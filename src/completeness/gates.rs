@@ -0,0 +1,327 @@
+//! Gate reachability across composed specs
+//!
+//! A gate such as `check_access.level >= 50` reads the result of a call
+//! step. When the called spec only ever returns literals, its possible
+//! results are known: one per rule that can match (rules that never match
+//! are left out, and nothing after a rule that always matches counts) plus
+//! the default. Evaluating the gate against every combination of results
+//! shows whether it can pass at all, or can never fail.
+//!
+//! Gates that read flow inputs, computed values or specs whose outputs are
+//! expressions are not decided.
+
+use super::feasibility::{condition_issues, ConditionIssueKind};
+use crate::cel::CelCompiler;
+use crate::orchestrate::{ChainStep, Orchestrator};
+use crate::runtime::to_cel_value;
+use crate::spec::{ConditionValue, Output, Spec};
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// Most result combinations evaluated for one gate
+const MAX_COMBINATIONS: usize = 1024;
+
+/// Why a gate is suspicious
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum GateIssueKind {
+    /// No result of the called specs lets the gate pass
+    NeverPasses,
+    /// Every result of the called specs passes the gate
+    AlwaysPasses,
+}
+
+/// A gate whose outcome is fixed by the specs it reads
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct GateIssue {
+    pub gate_id: String,
+    pub condition: String,
+    pub kind: GateIssueKind,
+    /// Possible results of each call step the gate reads, e.g.
+    /// `check_access` → `["{level: 10}", "{level: 20}"]`
+    pub results: Vec<(String, Vec<String>)>,
+}
+
+impl std::fmt::Display for GateIssue {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        let verdict = match self.kind {
+            GateIssueKind::NeverPasses => "never passes",
+            GateIssueKind::AlwaysPasses => "always passes",
+        };
+        let results: Vec<String> = self
+            .results
+            .iter()
+            .map(|(step, values)| format!("{} is one of {}", step, values.join(", ")))
+            .collect();
+        write!(
+            f,
+            "gate '{}' {}: `{}` where {}",
+            self.gate_id,
+            verdict,
+            self.condition,
+            results.join(" and ")
+        )
+    }
+}
+
+/// Gates in a flow that always or never pass given the specs it calls
+pub fn gate_issues(orch: &Orchestrator, specs: &HashMap<String, Spec>) -> Vec<GateIssue> {
+    let mut calls = HashMap::new();
+    let mut gates = Vec::new();
+    collect(&orch.chain, &mut calls, &mut gates);
+
+    let mut issues = Vec::new();
+    for (gate_id, condition) in gates {
+        let Ok(vars) = CelCompiler::extract_variables(condition) else {
+            continue;
+        };
+        let results: Option<Vec<(String, Vec<ConditionValue>)>> = vars
+            .iter()
+            .map(|var| {
+                let spec = specs.get(*calls.get(var.as_str())?)?;
+                Some((var.clone(), spec_results(spec)?))
+            })
+            .collect();
+        let Some(results) = results else {
+            continue;
+        };
+        let combinations: usize = results.iter().map(|(_, values)| values.len()).product();
+        if vars.is_empty() || combinations == 0 || combinations > MAX_COMBINATIONS {
+            continue;
+        }
+
+        let mut outcomes = Vec::with_capacity(combinations);
+        for n in 0..combinations {
+            let mut scope = HashMap::new();
+            let mut rest = n;
+            for (var, values) in &results {
+                scope.insert(var.clone(), to_cel_value(&values[rest % values.len()]));
+                rest /= values.len();
+            }
+            outcomes.push(CelCompiler::eval_bool(condition, &scope));
+        }
+        let kind = if outcomes.iter().all(|o| matches!(o, Ok(false))) {
+            GateIssueKind::NeverPasses
+        } else if outcomes.iter().all(|o| matches!(o, Ok(true))) {
+            GateIssueKind::AlwaysPasses
+        } else {
+            continue;
+        };
+        issues.push(GateIssue {
+            gate_id: gate_id.to_string(),
+            condition: condition.to_string(),
+            kind,
+            results: results
+                .into_iter()
+                .map(|(var, values)| (var, values.iter().map(describe).collect()))
+                .collect(),
+        });
+    }
+    issues
+}
+
+/// Every result a spec can return, in evaluation order, or None when one
+/// is computed rather than a literal
+fn spec_results(spec: &Spec) -> Option<Vec<ConditionValue>> {
+    let spec = spec.for_codegen();
+    let issues = condition_issues(&spec);
+    let has = |rule_id: &str, kind: ConditionIssueKind| {
+        issues
+            .iter()
+            .any(|i| i.rule_id == rule_id && i.kind == kind)
+    };
+
+    let mut results: Vec<ConditionValue> = Vec::new();
+    let mut exhaustive = false;
+    for rule in spec.evaluation_order() {
+        if has(&rule.id, ConditionIssueKind::Unsatisfiable) {
+            continue;
+        }
+        let result = literal_result(&spec, &rule.then)?;
+        if !results.contains(&result) {
+            results.push(result);
+        }
+        if rule.conjuncts().is_empty() || has(&rule.id, ConditionIssueKind::AlwaysTrue) {
+            exhaustive = true;
+            break;
+        }
+    }
+    if !exhaustive {
+        if let Some(default) = &spec.default {
+            let result = literal_result(&spec, default)?;
+            if !results.contains(&result) {
+                results.push(result);
+            }
+        }
+    }
+    Some(results)
+}
+
+/// A rule output as the map later steps read, as in the runtime
+fn literal_result(spec: &Spec, output: &Output) -> Option<ConditionValue> {
+    let fields = match output {
+        Output::Single(value) => {
+            let name = spec
+                .outputs
+                .first()
+                .map(|o| o.name.clone())
+                .unwrap_or_else(|| "result".to_string());
+            HashMap::from([(name, value.clone())])
+        }
+        Output::Named(fields) => fields.clone(),
+    };
+    let computed = fields.values().any(|value| {
+        matches!(value, ConditionValue::String(s)
+            if spec.inputs.iter().any(|i| &i.name == s) || crate::render::is_expression(s))
+    });
+    if computed {
+        None
+    } else {
+        Some(ConditionValue::Map(fields))
+    }
+}
+
+/// A result as `{level: 10, tier: "gold"}`
+fn describe(result: &ConditionValue) -> String {
+    let ConditionValue::Map(fields) = result else {
+        return result.to_string();
+    };
+    let mut fields: Vec<String> = fields
+        .iter()
+        .map(|(name, value)| format!("{}: {}", name, value))
+        .collect();
+    fields.sort();
+    format!("{{{}}}", fields.join(", "))
+}
+
+/// Call steps by id (with the spec they call) and gates, in chain order
+fn collect<'a>(
+    steps: &'a [ChainStep],
+    calls: &mut HashMap<&'a str, &'a str>,
+    gates: &mut Vec<(&'a str, &'a str)>,
+) {
+    for step in steps {
+        match step {
+            ChainStep::Call(call) => {
+                calls.insert(&call.id, &call.spec);
+            }
+            ChainStep::Gate(gate) => gates.push((&gate.id, &gate.condition)),
+            ChainStep::Parallel(par) => collect(&par.steps, calls, gates),
+            ChainStep::Branch(branch) => {
+                let mut cases: Vec<_> = branch.cases.iter().collect();
+                cases.sort_by(|a, b| a.0.cmp(b.0));
+                for (_, steps) in cases {
+                    collect(steps, calls, gates);
+                }
+                if let Some(default) = &branch.default {
+                    collect(default, calls, gates);
+                }
+            }
+            ChainStep::Loop(loop_) => collect(&loop_.steps, calls, gates),
+            ChainStep::ForEach(foreach) => collect(&foreach.steps, calls, gates),
+            ChainStep::Try(try_) => {
+                collect(&try_.try_steps, calls, gates);
+                if let Some(catch) = &try_.catch {
+                    collect(&catch.steps, calls, gates);
+                }
+                if let Some(finally) = &try_.finally {
+                    collect(finally, calls, gates);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn specs() -> HashMap<String, Spec> {
+        let spec = Spec::from_yaml(
+            r#"
+id: access_level
+inputs:
+  - name: role
+    type: string
+    values: [admin, member, guest]
+outputs:
+  - name: level
+    type: int
+rules:
+  - id: R1
+    when: "role == 'admin'"
+    then: 40
+  - id: R2
+    when: "role == 'member' && role == 'guest'"
+    then: 90
+  - id: R3
+    when: "role == 'member'"
+    then: 20
+default: 0
+"#,
+        )
+        .unwrap();
+        HashMap::from([("access_level".to_string(), spec)])
+    }
+
+    fn flow(condition: &str) -> Orchestrator {
+        Orchestrator::from_yaml(&format!(
+            r#"
+id: admin_flow
+inputs:
+  - name: role
+    type: string
+chain:
+  - step: call
+    id: check_access
+    spec: access_level
+    inputs:
+      role: role
+  - step: gate
+    id: require_level
+    condition: "{}"
+"#,
+            condition
+        ))
+        .unwrap()
+    }
+
+    #[test]
+    fn test_gate_never_passes() {
+        let issues = gate_issues(&flow("check_access.level >= 50"), &specs());
+        assert_eq!(issues.len(), 1);
+        assert_eq!(issues[0].kind, GateIssueKind::NeverPasses);
+        // R2 never matches, so its 90 is not a possible result
+        assert_eq!(
+            issues[0].results,
+            vec![(
+                "check_access".to_string(),
+                vec![
+                    "{level: 40}".into(),
+                    "{level: 20}".into(),
+                    "{level: 0}".into()
+                ]
+            )]
+        );
+        assert_eq!(
+            issues[0].to_string(),
+            "gate 'require_level' never passes: `check_access.level >= 50` where check_access is one of {level: 40}, {level: 20}, {level: 0}"
+        );
+    }
+
+    #[test]
+    fn test_gate_always_passes() {
+        let issues = gate_issues(&flow("check_access.level < 50"), &specs());
+        assert_eq!(issues[0].kind, GateIssueKind::AlwaysPasses);
+    }
+
+    #[test]
+    fn test_gate_undecided() {
+        assert!(gate_issues(&flow("check_access.level >= 20"), &specs()).is_empty());
+        // Flow inputs can take any value
+        assert!(gate_issues(&flow("role == 'admin'"), &specs()).is_empty());
+        assert!(gate_issues(&flow("check_access.level >= 50"), &HashMap::new()).is_empty());
+    }
+}
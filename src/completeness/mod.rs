@@ -13,6 +13,10 @@
 //! - `predicates` - CEL → atomic predicate extraction
 //! - `analysis` - Completeness checking and gap detection
 //! - `espresso` - Heuristic Boolean minimization (Espresso algorithm)
+//! - `consistency` - Enum-like fields whose values disagree across specs
+//! - `feasibility` - Whether conditions can hold; contradictory and always-true rules
+//! - `gates` - Flow gates that always or never pass given the specs they read
+//! - `paths` - Feasible condition paths through rule sets and flows
 //!
 //! ## Example
 //!
@@ -47,10 +51,14 @@
 mod adapter;
 mod analysis;
 mod collision;
+mod consistency;
 mod duplicate;
 pub mod espresso;
+mod feasibility;
 mod fix;
+mod gates;
 mod orchestrator_suite;
+mod paths;
 mod predicates;
 mod refactor;
 mod relationship;
@@ -63,6 +71,10 @@ pub use analysis::{
     analyze_completeness, IncompletenessReport, MissingCase, PredicateInfo, PredicateValue,
     RuleOverlap,
 };
+pub use consistency::{enum_inconsistencies, EnumInconsistency};
+pub use feasibility::{condition_issues, ConditionIssue, ConditionIssueKind};
+pub use gates::{gate_issues, GateIssue, GateIssueKind};
+pub use paths::{enumerate_flow_paths, enumerate_spec_paths, ConditionPath, PathReport, MAX_PATHS};
 pub use predicates::{
     extract_predicates, ComparisonOp, LiteralValue, Predicate, PredicateSet, StringOpKind,
 };
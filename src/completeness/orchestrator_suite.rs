@@ -5,6 +5,7 @@
 //! 2. Load and analyze those specs together
 //! 3. Check for issues across the entire orchestrated workflow
 
+use crate::completeness::gates::{gate_issues, GateIssue};
 use crate::completeness::suite::{analyze_suite, SuiteAnalysisResult};
 use crate::orchestrate::Orchestrator;
 use crate::spec::Spec;
@@ -27,6 +28,9 @@ pub struct OrchestratorSuiteResult {
     pub suite_result: SuiteAnalysisResult,
     /// Input/output mapping issues
     pub mapping_issues: Vec<MappingIssue>,
+    /// Gates that always or never pass given the specs they read
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub gate_issues: Vec<GateIssue>,
 }
 
 /// An issue with input/output mapping between orchestrator and specs
@@ -80,6 +84,9 @@ pub fn analyze_orchestrator_suite(
     // 4. Check input/output mappings
     let mapping_issues = check_mappings(orchestrator, available_specs);
 
+    // 5. Check gates against the results of the specs they read
+    let gate_issues = gate_issues(orchestrator, available_specs);
+
     OrchestratorSuiteResult {
         orchestrator_id: orchestrator.id.clone(),
         referenced_spec_ids,
@@ -87,6 +94,7 @@ pub fn analyze_orchestrator_suite(
         missing_specs,
         suite_result,
         mapping_issues,
+        gate_issues,
     }
 }
 
@@ -239,11 +247,14 @@ pub fn analyze_directory_with_orchestrators(
         .collect();
     let suite_result = analyze_suite(&all_specs, full);
 
+    let flows: Vec<Orchestrator> = orchestrators.into_iter().map(|(_, orch)| orch).collect();
+
     Ok(DirectorySuiteResult {
         specs_found: specs.len(),
-        orchestrators_found: orchestrators.len(),
+        orchestrators_found: flows.len(),
         orchestrator_results,
         overall_suite_result: suite_result,
+        flow_cycles: crate::orchestrate::flow_cycles(&flows),
     })
 }
 
@@ -254,6 +265,9 @@ pub struct DirectorySuiteResult {
     pub orchestrators_found: usize,
     pub orchestrator_results: Vec<OrchestratorSuiteResult>,
     pub overall_suite_result: SuiteAnalysisResult,
+    /// Orchestrators that call each other as sub-flows in a cycle
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub flow_cycles: Vec<String>,
 }
 
 #[cfg(test)]
@@ -280,8 +294,24 @@ mod tests {
                 condition: None,
                 timeout: None,
                 retry: None,
+                side_effect: false,
+                rate_limit: None,
+                circuit_breaker: None,
+                weight: None,
+                optional: false,
+                on_error: None,
+                default: Default::default(),
             })],
             scoping: None,
+            trigger: None,
+            durable: false,
+            hooks: false,
+            scenarios: Vec::new(),
+            tracing: false,
+            logging: false,
+            bulkhead: None,
+            deadline_ms: None,
+            injectable: false,
         };
 
         // Create the referenced specs
@@ -298,6 +328,20 @@ mod tests {
                 default: None,
                 meta: Default::default(),
                 scoping: None,
+                codegen: Default::default(),
+                constraints: Vec::new(),
+                postconditions: Vec::new(),
+                resolution: Default::default(),
+                cache: None,
+                normalize: Vec::new(),
+                assertions: Vec::new(),
+                tables: Vec::new(),
+                zones: Vec::new(),
+                constants: Vec::new(),
+                extends: None,
+                extension_points: Vec::new(),
+                functions: Vec::new(),
+                source: None,
             },
         );
 
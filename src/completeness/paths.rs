@@ -0,0 +1,480 @@
+//! Symbolic path enumeration
+//!
+//! Lists every way through a rule set or orchestrator flow together with the
+//! input conditions that lead down it, for test planning and for documenting
+//! decisions to auditors.
+//!
+//! A rule set has one path per rule (the rule matches and no earlier rule
+//! does) plus the default path. A flow forks at every gate (passes or
+//! fails), conditional call or return, branch case, approval (approved or
+//! rejected) and try block (succeeds or fails); loop bodies are walked once.
+//!
+//! A path is feasible when its conditions can all hold together; see
+//! [`super::feasibility`].
+
+use super::feasibility::{Atoms, Domain, Domains};
+use crate::cel::CelCompiler;
+use crate::orchestrate::{ChainStep, Orchestrator};
+use crate::spec::Spec;
+use schemars::JsonSchema;
+use serde::{Deserialize, Serialize};
+
+/// Paths enumerated before the report is truncated
+pub const MAX_PATHS: usize = 1000;
+
+/// One way through a rule set or flow
+#[derive(Debug, Clone, Serialize, Deserialize, JsonSchema)]
+pub struct ConditionPath {
+    /// What happens on this path: the deciding rule, the default, or how
+    /// the flow ends
+    pub outcome: String,
+
+    /// CEL conditions that all hold on this path
+    pub conditions: Vec<String>,
+
+    /// Flow steps taken, in order
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub steps: Vec<String>,
+}
+
+/// All paths through a spec or orchestrator
+#[derive(Debug, Clone, Serialize, Deserialize, JsonSchema)]
+pub struct PathReport {
+    /// Spec or orchestrator ID
+    pub id: String,
+
+    /// Paths some input can take
+    pub paths: Vec<ConditionPath>,
+
+    /// Paths whose conditions can never hold together
+    pub infeasible: Vec<ConditionPath>,
+
+    /// Enumeration stopped after [`MAX_PATHS`] paths
+    pub truncated: bool,
+}
+
+impl PathReport {
+    /// Format as human-readable report
+    pub fn to_report(&self) -> String {
+        let mut out = format!("Paths through {}: {}\n", self.id, self.paths.len());
+        for (i, path) in self.paths.iter().enumerate() {
+            out.push_str(&format!("\n{}. {}\n", i + 1, path.outcome));
+            if !path.steps.is_empty() {
+                out.push_str(&format!("   steps: {}\n", path.steps.join(" → ")));
+            }
+            if path.conditions.is_empty() {
+                out.push_str("   always\n");
+            }
+            for condition in &path.conditions {
+                out.push_str(&format!("   - {}\n", condition));
+            }
+        }
+        if !self.infeasible.is_empty() {
+            out.push_str(&format!("\nInfeasible ({}):\n", self.infeasible.len()));
+            for path in &self.infeasible {
+                out.push_str(&format!(
+                    "  {}: {}\n",
+                    path.outcome,
+                    path.conditions.join(" && ")
+                ));
+            }
+        }
+        if self.truncated {
+            out.push_str(&format!("\nStopped after {} paths\n", MAX_PATHS));
+        }
+        out
+    }
+}
+
+/// Enumerate the paths through a spec's rules, in evaluation order
+///
+/// Draft and retired rules are left out, as in generated code.
+pub fn enumerate_spec_paths(spec: &Spec) -> PathReport {
+    let spec = spec.for_codegen();
+    let domains = Domains::from_variables(&spec.inputs);
+    let mut atoms = Atoms::default();
+    let rules: Vec<_> = spec
+        .evaluation_order()
+        .into_iter()
+        .map(|rule| {
+            let conjuncts = rule.conjuncts();
+            let literals = atoms.literals(&conjuncts);
+            (rule, conjuncts, literals)
+        })
+        .collect();
+
+    let mut report = PathReport {
+        id: spec.id.clone(),
+        paths: Vec::new(),
+        infeasible: Vec::new(),
+        truncated: false,
+    };
+    for (i, (rule, conjuncts, literals)) in rules.iter().enumerate() {
+        let mut requirements = vec![(literals.as_slice(), true)];
+        let mut conditions = conjuncts.clone();
+        for (_, earlier_conjuncts, earlier) in &rules[..i] {
+            requirements.push((earlier.as_slice(), false));
+            // Only earlier rules that could also match narrow this path
+            if atoms.satisfiable(
+                &[(literals.as_slice(), true), (earlier.as_slice(), true)],
+                &domains,
+            ) {
+                conditions.push(atoms.negation(earlier_conjuncts, earlier));
+            }
+        }
+        let path = ConditionPath {
+            outcome: format!("rule {}", rule.id),
+            conditions,
+            steps: Vec::new(),
+        };
+        if atoms.satisfiable(&requirements, &domains) {
+            report.paths.push(path);
+        } else {
+            report.infeasible.push(path);
+        }
+    }
+
+    let requirements: Vec<_> = rules
+        .iter()
+        .map(|(_, _, literals)| (literals.as_slice(), false))
+        .collect();
+    if atoms.satisfiable(&requirements, &domains) {
+        report.paths.push(ConditionPath {
+            outcome: if spec.default.is_some() {
+                "default".to_string()
+            } else {
+                "no rule matches".to_string()
+            },
+            conditions: rules
+                .iter()
+                .map(|(_, conjuncts, literals)| atoms.negation(conjuncts, literals))
+                .collect(),
+            steps: Vec::new(),
+        });
+    }
+    report
+}
+
+/// Enumerate the paths through an orchestrator's chain
+pub fn enumerate_flow_paths(orch: &Orchestrator) -> PathReport {
+    let mut truncated = false;
+    let walked = walk(&orch.chain, vec![Walk::default()], &mut truncated);
+
+    let domains = Domains {
+        inputs: orch
+            .inputs
+            .iter()
+            .map(|input| (input.name.clone(), Domain::of(&input.var_type, None)))
+            .collect(),
+    };
+    let mut atoms = Atoms::default();
+    let mut report = PathReport {
+        id: orch.id.clone(),
+        paths: Vec::new(),
+        infeasible: Vec::new(),
+        truncated,
+    };
+    for taken in walked {
+        let requirements: Vec<_> = taken
+            .conditions
+            .iter()
+            .map(|(cel, holds)| {
+                let conjuncts = CelCompiler::conjuncts(cel).unwrap_or_else(|_| vec![cel.clone()]);
+                let literals = atoms.literals(&conjuncts);
+                (conjuncts, literals, *holds)
+            })
+            .collect();
+        let path = ConditionPath {
+            outcome: taken.outcome.unwrap_or_else(|| "completes".to_string()),
+            conditions: requirements
+                .iter()
+                .map(|(conjuncts, literals, holds)| {
+                    if *holds {
+                        conjuncts.join(" && ")
+                    } else {
+                        atoms.negation(conjuncts, literals)
+                    }
+                })
+                .collect(),
+            steps: taken.steps,
+        };
+        let requirements: Vec<_> = requirements
+            .iter()
+            .map(|(_, literals, holds)| (literals.as_slice(), *holds))
+            .collect();
+        if atoms.satisfiable(&requirements, &domains) {
+            report.paths.push(path);
+        } else {
+            report.infeasible.push(path);
+        }
+    }
+    report
+}
+
+/// A path through a flow while it is being walked
+#[derive(Debug, Clone, Default)]
+struct Walk {
+    /// Conditions with whether they hold
+    conditions: Vec<(String, bool)>,
+    steps: Vec<String>,
+    /// Set once the flow has returned or failed
+    outcome: Option<String>,
+}
+
+impl Walk {
+    fn when(&self, condition: &str, holds: bool) -> Self {
+        let mut next = self.clone();
+        next.conditions.push((condition.to_string(), holds));
+        next
+    }
+
+    fn step(mut self, id: impl Into<String>) -> Self {
+        self.steps.push(id.into());
+        self
+    }
+
+    fn end(mut self, outcome: String) -> Self {
+        self.outcome = Some(outcome);
+        self
+    }
+}
+
+fn walk(steps: &[ChainStep], mut walks: Vec<Walk>, truncated: &mut bool) -> Vec<Walk> {
+    for step in steps {
+        walks = walks
+            .into_iter()
+            .flat_map(|w| match w.outcome {
+                Some(_) => vec![w],
+                None => fork(step, w, truncated),
+            })
+            .collect();
+        if walks.len() > MAX_PATHS {
+            walks.truncate(MAX_PATHS);
+            *truncated = true;
+        }
+    }
+    walks
+}
+
+/// The ways one step can go
+fn fork(step: &ChainStep, w: Walk, truncated: &mut bool) -> Vec<Walk> {
+    match step {
+        ChainStep::Call(call) => match &call.condition {
+            Some(condition) => vec![
+                w.when(condition, true).step(&call.id),
+                w.when(condition, false),
+            ],
+            None => vec![w.step(&call.id)],
+        },
+        ChainStep::Gate(gate) => vec![
+            w.when(&gate.condition, true).step(&gate.id),
+            w.when(&gate.condition, false)
+                .step(&gate.id)
+                .end(format!("fails at gate {}", gate.id)),
+        ],
+        ChainStep::Return(ret) => match &ret.condition {
+            Some(condition) => vec![
+                w.when(condition, true)
+                    .end(format!("returns {}", ret.value)),
+                w.when(condition, false),
+            ],
+            None => vec![w.end(format!("returns {}", ret.value))],
+        },
+        ChainStep::Branch(branch) => {
+            let mut cases: Vec<_> = branch.cases.iter().collect();
+            cases.sort_by(|a, b| a.0.cmp(b.0));
+            let literals: Vec<String> = cases.iter().map(|(case, _)| case_literal(case)).collect();
+            let mut walks = Vec::new();
+            for ((case, steps), literal) in cases.iter().zip(&literals) {
+                let taken = w
+                    .when(&format!("{} == {}", branch.on, literal), true)
+                    .step(format!("{}: {}", branch.id, case));
+                walks.extend(walk(steps, vec![taken], truncated));
+            }
+            let otherwise = w
+                .when(
+                    &format!("{} in [{}]", branch.on, literals.join(", ")),
+                    false,
+                )
+                .step(format!("{}: default", branch.id));
+            walks.extend(walk(
+                branch.default.as_deref().unwrap_or_default(),
+                vec![otherwise],
+                truncated,
+            ));
+            walks
+        }
+        ChainStep::Loop(l) => walk(&l.steps, vec![w.step(&l.id)], truncated),
+        ChainStep::ForEach(f) => walk(&f.steps, vec![w.step(&f.id)], truncated),
+        ChainStep::Parallel(p) => walk(&p.steps, vec![w], truncated),
+        ChainStep::Try(t) => {
+            let mut walks = walk(&t.try_steps, vec![w.clone()], truncated);
+            let failed = w.step(format!("{} fails", t.id));
+            match &t.catch {
+                Some(catch) => walks.extend(walk(&catch.steps, vec![failed], truncated)),
+                None => walks.push(failed.end(format!("fails in {}", t.id))),
+            }
+            match &t.finally {
+                Some(finally) => walk(finally, walks, truncated),
+                None => walks,
+            }
+        }
+        ChainStep::AwaitApproval(approval) => {
+            let approved = format!("{}.approved", approval.id);
+            vec![
+                w.when(&approved, true).step(&approval.id),
+                w.when(&approved, false).step(&approval.id),
+            ]
+        }
+        ChainStep::Dynamic(d) => vec![w.step(&d.id)],
+        ChainStep::Await(a) => vec![w.step(&a.id)],
+        ChainStep::Compute(_) | ChainStep::Set(_) | ChainStep::Emit(_) => vec![w],
+    }
+}
+
+/// A branch case key as a CEL literal
+fn case_literal(case: &str) -> String {
+    if case == "true" || case == "false" || case.parse::<f64>().is_ok() {
+        case.to_string()
+    } else {
+        format!("\"{}\"", case.replace('\\', "\\\\").replace('"', "\\\""))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn outcomes(paths: &[ConditionPath]) -> Vec<&str> {
+        paths.iter().map(|p| p.outcome.as_str()).collect()
+    }
+
+    #[test]
+    fn test_spec_paths() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping
+inputs:
+  - name: weight_kg
+    type: float
+  - name: tier
+    type: string
+    values: [standard, gold]
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+  - id: GOLD
+    when: "tier == 'gold'"
+    then: 0.0
+  - id: LIGHT_HEAVY
+    when: "weight_kg > 30.0 && weight_kg < 10.0"
+    then: 1.0
+  - id: STANDARD
+    when: "tier == 'standard'"
+    then: 10.0
+  - id: OTHER
+    when: "tier == 'platinum'"
+    then: 5.0
+default: 10.0
+"#,
+        )
+        .unwrap();
+
+        let report = enumerate_spec_paths(&spec);
+        assert_eq!(
+            outcomes(&report.paths),
+            vec!["rule HEAVY", "rule GOLD", "rule STANDARD"]
+        );
+        assert_eq!(report.paths[0].conditions, vec!["weight_kg > 20.0"]);
+        assert_eq!(
+            report.paths[1].conditions,
+            vec!["tier == 'gold'", "weight_kg <= 20"]
+        );
+        // A standard parcel never matches GOLD, so only HEAVY narrows it
+        assert_eq!(
+            report.paths[2].conditions,
+            vec!["tier == 'standard'", "weight_kg <= 20"]
+        );
+        // Contradictory bounds and a tier outside `values`; there is no
+        // default path since both tiers are covered
+        assert_eq!(
+            outcomes(&report.infeasible),
+            vec!["rule LIGHT_HEAVY", "rule OTHER"]
+        );
+    }
+
+    #[test]
+    fn test_flow_paths() {
+        let orch = Orchestrator::from_yaml(
+            r#"
+id: checkout
+inputs:
+  - name: amount
+    type: int
+  - name: country
+    type: string
+chain:
+  - step: gate
+    id: positive
+    condition: "amount > 0"
+  - step: branch
+    id: region
+    on: country
+    cases:
+      US:
+        - step: call
+          id: domestic
+          spec: domestic_tax
+      DE:
+        - step: gate
+          id: eu_limit
+          condition: "amount < 0"
+  - step: call
+    id: review
+    spec: manual_review
+    condition: "amount > 10000"
+"#,
+        )
+        .unwrap();
+
+        let report = enumerate_flow_paths(&orch);
+        assert_eq!(
+            outcomes(&report.paths),
+            vec![
+                "fails at gate positive",
+                "fails at gate eu_limit",
+                "completes",
+                "completes",
+                "completes",
+                "completes",
+            ]
+        );
+        assert_eq!(
+            report.paths[1].conditions,
+            vec!["amount > 0", "country == \"DE\"", "amount >= 0"]
+        );
+        assert_eq!(
+            report.paths[2].conditions,
+            vec!["amount > 0", "country == \"US\"", "amount > 10000"]
+        );
+        assert_eq!(
+            report.paths[2].steps,
+            vec!["positive", "region: US", "domestic", "review"]
+        );
+        assert_eq!(
+            report.paths[5].conditions,
+            vec![
+                "amount > 0",
+                "!(country in [\"DE\", \"US\"])",
+                "amount <= 10000"
+            ]
+        );
+        // Passing both `amount > 0` and `amount < 0` is impossible
+        assert_eq!(report.infeasible.len(), 2);
+        assert!(!report.truncated);
+    }
+}
@@ -10,7 +10,7 @@ use super::adapter::{cover_to_cel, rules_to_cover};
 use super::espresso::{espresso, Cover};
 use super::predicates::{extract_predicates, Predicate, PredicateSet};
 use crate::orchestrate::{ChainStep, Orchestrator};
-use crate::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable};
+use crate::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable};
 use serde::{Deserialize, Serialize};
 use std::collections::{HashMap, HashSet};
 
@@ -197,6 +197,14 @@ pub fn minimize(spec: &Spec) -> MinimizedSpec {
                             .collect::<Vec<_>>()
                             .join(", ")
                     )),
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 });
                 rule_id_counter += 1;
             }
@@ -366,6 +374,20 @@ pub fn decompose(spec: &Spec) -> DecompositionResult {
             default: spec.default.clone(),
             meta: spec.meta.clone(),
             scoping: spec.scoping.clone(),
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         proposed_specs.push(sub_spec);
@@ -768,6 +790,14 @@ fn extract_spec_from_branch(step: &ChainStep, orch_id: &str, idx: usize) -> Opti
                 then: Output::Single(ConditionValue::String(format!("case_{}", rule_idx))),
                 priority: 0,
                 description: Some(format!("Branch case: {}", condition)),
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             });
             rule_idx += 1;
         }
@@ -781,6 +811,14 @@ fn extract_spec_from_branch(step: &ChainStep, orch_id: &str, idx: usize) -> Opti
                 then: Output::Single(ConditionValue::String("default".into())),
                 priority: 0,
                 description: Some("Default branch case".into()),
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             });
         }
 
@@ -804,6 +842,13 @@ fn extract_spec_from_branch(step: &ChainStep, orch_id: &str, idx: usize) -> Opti
                                 typ: pred.infer_type(),
                                 description: None,
                                 values: None,
+                                optional: false,
+                                default: None,
+                                unit: None,
+                                currency: None,
+                                aliases: Vec::new(),
+                                pii: false,
+                                redact: None,
                             });
                         }
                     }
@@ -821,11 +866,32 @@ fn extract_spec_from_branch(step: &ChainStep, orch_id: &str, idx: usize) -> Opti
                 typ: VarType::String,
                 description: Some("Branch result".into()),
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules,
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         })
     } else {
         None
@@ -845,6 +911,14 @@ fn extract_spec_from_gates(steps: &[&ChainStep], orch_id: &str) -> Option<Spec>
                 then: Output::Single(ConditionValue::Bool(true)),
                 priority: 0,
                 description: Some(gate.id.clone()),
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             });
         }
     }
@@ -869,6 +943,13 @@ fn extract_spec_from_gates(steps: &[&ChainStep], orch_id: &str) -> Option<Spec>
                             typ: pred.infer_type(),
                             description: None,
                             values: None,
+                            optional: false,
+                            default: None,
+                            unit: None,
+                            currency: None,
+                            aliases: Vec::new(),
+                            pii: false,
+                            redact: None,
                         });
                     }
                 }
@@ -886,11 +967,32 @@ fn extract_spec_from_gates(steps: &[&ChainStep], orch_id: &str) -> Option<Spec>
             typ: VarType::Bool,
             description: Some("Whether the gate condition passed".into()),
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules,
         default: Some(Output::Single(ConditionValue::Bool(false))),
         meta: Default::default(),
         scoping: None,
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
+        zones: Vec::new(),
+        constants: Vec::new(),
+        extends: None,
+        extension_points: Vec::new(),
+        functions: Vec::new(),
+        source: None,
     })
 }
 
@@ -946,12 +1048,26 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -961,6 +1077,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -969,11 +1093,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(0)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -1000,12 +1146,26 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -1013,6 +1173,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![
                 Rule {
@@ -1022,6 +1189,14 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(1)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
                 Rule {
                     id: "R2".into(),
@@ -1030,11 +1205,33 @@ mod tests {
                     then: Output::Single(ConditionValue::Int(2)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 },
             ],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let result = decompose(&spec);
@@ -1057,12 +1254,26 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             outputs: vec![Variable {
@@ -1070,6 +1281,13 @@ mod tests {
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![Rule {
                 id: "R1".into(),
@@ -1078,10 +1296,32 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         };
 
         let result = decompose(&spec);
@@ -143,6 +143,20 @@ mod tests {
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -156,6 +170,13 @@ mod tests {
                 typ: VarType::Float,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         );
         let spec_b = make_test_spec(
@@ -165,6 +186,13 @@ mod tests {
                 typ: VarType::Float,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             vec![],
         );
@@ -188,18 +216,39 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "c".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             vec![],
@@ -212,18 +261,39 @@ mod tests {
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "b".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
                 Variable {
                     name: "d".into(),
                     typ: VarType::Bool,
                     description: None,
                     values: None,
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             ],
             vec![],
@@ -227,6 +227,13 @@ mod tests {
                     typ: VarType::String,
                     description: None,
                     values: Some(vec!["standard".into()]),
+                    optional: false,
+                    default: None,
+                    unit: None,
+                    currency: None,
+                    aliases: Vec::new(),
+                    pii: false,
+                    redact: None,
                 },
             }],
             collision_type: CollisionType::SameNameDifferentValues,
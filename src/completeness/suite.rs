@@ -176,7 +176,7 @@ fn analyze_suite_gaps_exhaustive(_specs: &[(String, &Spec)]) -> Vec<SuiteGap> {
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::spec::{ConditionValue, Output, Rule, VarType, Variable};
+    use crate::spec::{ConditionValue, Output, Rule, RuleState, VarType, Variable};
 
     fn make_test_spec(id: &str) -> Spec {
         Spec {
@@ -188,12 +188,26 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![Rule {
                 id: "R1".into(),
@@ -202,10 +216,32 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
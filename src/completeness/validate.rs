@@ -6,11 +6,12 @@
 //! - Tautology conditions (always match, not marked as default)
 //! - Dead rules (covered by earlier rules)
 //! - Type mismatches (wrong types in comparisons)
+//! - Unit mismatches (kg compared with lb without convert(), USD added to EUR)
 
 use super::adapter::rules_to_cover;
 use super::espresso::Cover;
 use super::predicates::{extract_predicates, PredicateSet};
-use crate::spec::{Rule, Spec};
+use crate::spec::{Resolution, Rule, Spec};
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
 use std::collections::HashMap;
@@ -93,6 +94,7 @@ pub enum IssueType {
     TautologyCondition,
     DeadRule,
     TypeMismatch,
+    UnitMismatch,
 }
 
 /// A concrete fix that can be applied to a spec
@@ -148,6 +150,9 @@ pub fn validate_spec(spec: &Spec, strict: bool) -> ValidationReport {
     // 1. Type mismatch detection
     issues.extend(detect_type_mismatches(spec, &mut code_counter));
 
+    // 1b. Unit-of-measure mismatch detection
+    issues.extend(detect_unit_mismatches(spec, &mut code_counter));
+
     // 2. Unsatisfiable condition detection
     issues.extend(detect_unsatisfiable(spec, &mut code_counter));
 
@@ -233,6 +238,9 @@ fn generate_fixes(issues: &[ValidationIssue], spec: &Spec) -> Vec<SpecFix> {
                     fixes.push(fix);
                 }
             }
+            IssueType::UnitMismatch => {
+                // No automatic fix - the author must pick the conversion
+            }
         }
     }
 
@@ -562,6 +570,31 @@ fn infer_type(
             Val::Boolean(_) => Some(crate::spec::VarType::Bool),
             _ => None,
         },
+        E::Call(call)
+            if call.target.is_none()
+                && crate::cel::MATH_FUNCTIONS
+                    .iter()
+                    .any(|(name, _)| call.func_name == *name) =>
+        {
+            Some(crate::spec::VarType::Float)
+        }
+        E::Call(call)
+            if call.target.is_none()
+                && matches!(call.func_name.as_str(), "region" | "zone" | "format") =>
+        {
+            Some(crate::spec::VarType::String)
+        }
+        E::Call(call)
+            if call.target.is_none()
+                && call.args.len() == 1
+                && crate::cel::AGGREGATE_FUNCTIONS.contains(&call.func_name.as_str()) =>
+        {
+            Some(if call.func_name == "count" {
+                crate::spec::VarType::Int
+            } else {
+                crate::spec::VarType::Float
+            })
+        }
         _ => None,
     }
 }
@@ -588,6 +621,228 @@ fn extract_type_mismatch_info(
     None
 }
 
+/// Detect expressions that mix values with incompatible units
+fn detect_unit_mismatches(spec: &Spec, code_counter: &mut usize) -> Vec<ValidationIssue> {
+    let mut issues = Vec::new();
+    let units = declared_units(&spec.inputs);
+    let output_units = declared_units(&spec.outputs);
+
+    for rule in &spec.rules {
+        // (expression, unit the result must have)
+        let mut exprs: Vec<(String, Option<String>)> =
+            rule.as_cel().into_iter().map(|c| (c, None)).collect();
+        match &rule.then {
+            crate::spec::Output::Single(crate::spec::ConditionValue::String(s)) => {
+                let expected = spec
+                    .outputs
+                    .first()
+                    .and_then(|o| output_units.get(&o.name).cloned());
+                exprs.push((s.clone(), expected))
+            }
+            crate::spec::Output::Named(map) => {
+                for (name, value) in map {
+                    if let crate::spec::ConditionValue::String(s) = value {
+                        exprs.push((s.clone(), output_units.get(name).cloned()));
+                    }
+                }
+            }
+            _ => {}
+        }
+
+        // Bindings take the unit of their expression
+        let mut units = units.clone();
+        for binding in &rule.vars {
+            if let Ok(ast) = cel_parser::Parser::new().parse(&binding.expr) {
+                match infer_unit(&ast, &units) {
+                    Ok(Some(unit)) => {
+                        units.insert(binding.name.clone(), unit);
+                    }
+                    Ok(None) => {}
+                    Err(e) => issues.push(unit_mismatch_issue(
+                        &rule.id,
+                        &binding.expr,
+                        e,
+                        code_counter,
+                    )),
+                }
+            }
+        }
+
+        for (expr, expected) in exprs {
+            let ast = match cel_parser::Parser::new().parse(&expr) {
+                Ok(ast) => ast,
+                Err(_) => continue,
+            };
+            let result = infer_unit(&ast, &units).and_then(|unit| match (unit, expected) {
+                (Some(actual), Some(expected)) if actual != expected => Err(format!(
+                    "result is in {} but the output is declared in {}",
+                    actual, expected
+                )),
+                _ => Ok(()),
+            });
+            if let Err(e) = result {
+                issues.push(unit_mismatch_issue(&rule.id, &expr, e, code_counter));
+            }
+        }
+    }
+
+    issues
+}
+
+fn unit_mismatch_issue(
+    rule_id: &str,
+    expr: &str,
+    error: String,
+    code_counter: &mut usize,
+) -> ValidationIssue {
+    ValidationIssue {
+        code: format!("V{:03}", {
+            let c = *code_counter;
+            *code_counter += 1;
+            c
+        }),
+        severity: Severity::Error,
+        issue_type: IssueType::UnitMismatch,
+        message: format!("Unit mismatch in rule {}: {}", rule_id, error),
+        affected_rules: vec![rule_id.to_string()],
+        explanation: Some(format!(
+            "The expression '{}' combines values measured in different units.",
+            expr
+        )),
+        suggestion: Some("Convert explicitly, e.g. convert(weight, 'lb', 'kg')".into()),
+        fix_example: None,
+        context: Some(IssueContext {
+            cel_expressions: Some(vec![expr.to_string()]),
+            variables: None,
+            type_info: None,
+            example_input: None,
+            current_behavior: None,
+            expected_behavior: None,
+        }),
+    }
+}
+
+/// Canonical unit (or currency code) declared on each variable
+fn declared_units(vars: &[crate::spec::Variable]) -> HashMap<String, String> {
+    vars.iter()
+        .filter_map(|v| {
+            let unit = match (&v.unit, &v.currency) {
+                (Some(unit), _) => crate::units::canonical(unit),
+                (None, Some(currency)) => currency.to_ascii_uppercase(),
+                (None, None) => return None,
+            };
+            Some((v.name.clone(), unit))
+        })
+        .collect()
+}
+
+/// Infer the unit of an expression, failing when incompatible units meet
+fn infer_unit(
+    expr: &cel_parser::Expression,
+    units: &HashMap<String, String>,
+) -> Result<Option<String>, String> {
+    use cel_parser::ast::operators;
+    use cel_parser::ast::Expr as E;
+
+    let call = match &expr.expr {
+        E::Ident(id) => return Ok(units.get(id.as_str()).cloned()),
+        E::Call(call) => call,
+        _ => return Ok(None),
+    };
+
+    let arg_units = call
+        .args
+        .iter()
+        .map(|a| infer_unit(a, units))
+        .collect::<Result<Vec<_>, _>>()?;
+
+    let func = call.func_name.as_str();
+    if func == "convert" && call.args.len() == 3 {
+        let from = crate::cel::CelCompiler::string_literal(&call.args[1]);
+        let to = crate::cel::CelCompiler::string_literal(&call.args[2]);
+        if let (Some(from), Some(to)) = (from, to) {
+            crate::units::conversion_factor(&from, &to).map_err(|e| e.to_string())?;
+            if let Some(actual) = &arg_units[0] {
+                if *actual != crate::units::canonical(&from) {
+                    return Err(format!(
+                        "convert() expects {} but the value is in {}",
+                        from, actual
+                    ));
+                }
+            }
+            return Ok(Some(crate::units::canonical(&to)));
+        }
+        return Ok(None);
+    }
+
+    if func == "convert_currency" && call.args.len() == 3 {
+        let from = crate::cel::CelCompiler::string_literal(&call.args[1]);
+        let to = crate::cel::CelCompiler::string_literal(&call.args[2]);
+        if let (Some(from), Some(to)) = (from, to) {
+            let from = from.to_ascii_uppercase();
+            if let Some(actual) = &arg_units[0] {
+                if *actual != from {
+                    return Err(format!(
+                        "convert_currency() expects {} but the amount is in {}",
+                        from, actual
+                    ));
+                }
+            }
+            return Ok(Some(to.to_ascii_uppercase()));
+        }
+        return Ok(None);
+    }
+
+    let same_unit = |l: &Option<String>, r: &Option<String>| -> Result<(), String> {
+        match (l, r) {
+            (Some(l), Some(r)) if l != r => Err(format!("cannot combine {} with {}", l, r)),
+            _ => Ok(()),
+        }
+    };
+
+    match func {
+        f if f == operators::ADD || f == operators::SUBSTRACT => {
+            same_unit(&arg_units[0], &arg_units[1])?;
+            Ok(arg_units[0].clone().or_else(|| arg_units[1].clone()))
+        }
+        f if f == operators::EQUALS
+            || f == operators::NOT_EQUALS
+            || f == operators::LESS
+            || f == operators::LESS_EQUALS
+            || f == operators::GREATER
+            || f == operators::GREATER_EQUALS =>
+        {
+            same_unit(&arg_units[0], &arg_units[1])?;
+            Ok(None)
+        }
+        f if f == operators::CONDITIONAL => {
+            same_unit(&arg_units[1], &arg_units[2])?;
+            Ok(arg_units[1].clone().or_else(|| arg_units[2].clone()))
+        }
+        // Scaling by a plain number keeps the unit; products of units are not tracked
+        f if f == operators::MULTIPLY => match (&arg_units[0], &arg_units[1]) {
+            (Some(u), None) | (None, Some(u)) => Ok(Some(u.clone())),
+            _ => Ok(None),
+        },
+        f if f == operators::DIVIDE => match (&arg_units[0], &arg_units[1]) {
+            (Some(u), None) => Ok(Some(u.clone())),
+            _ => Ok(None),
+        },
+        "min" | "max" | "clamp" => {
+            let mut unit: Option<String> = None;
+            for arg_unit in &arg_units {
+                same_unit(&unit, arg_unit)?;
+                unit = unit.or_else(|| arg_unit.clone());
+            }
+            Ok(unit)
+        }
+        "round" | "floor" | "ceil" | "abs" if !arg_units.is_empty() => Ok(arg_units[0].clone()),
+        // p percent of x is in x's unit
+        "percentage" if !arg_units.is_empty() => Ok(arg_units[0].clone()),
+        _ => Ok(None),
+    }
+}
+
 /// Detect unsatisfiable conditions (can never be true)
 fn detect_unsatisfiable(spec: &Spec, code_counter: &mut usize) -> Vec<ValidationIssue> {
     let mut issues = Vec::new();
@@ -726,17 +981,18 @@ fn detect_dead_rules(spec: &Spec, code_counter: &mut usize) -> Vec<ValidationIss
         }
     }
 
-    // Track what's been covered so far
+    // Track what's been covered so far, in the order the rules are tried
     let mut covered_so_far = Cover::new(predicate_set.len(), 1);
+    let ordered: Vec<Rule> = spec.evaluation_order().into_iter().cloned().collect();
 
-    for (idx, rule) in spec.rules.iter().enumerate() {
+    for (idx, rule) in ordered.iter().enumerate() {
         if rule.as_cel().is_some() {
             let rule_cover = rules_to_cover(std::slice::from_ref(rule), &predicate_set);
 
             // Check if this rule's cover is a subset of what's already covered
             if is_subset(&rule_cover, &covered_so_far, predicate_set.len()) {
                 // Find which earlier rules cover this
-                let covering_rules = find_covering_rules(&spec.rules[..idx], rule, &predicate_set);
+                let covering_rules = find_covering_rules(&ordered[..idx], rule, &predicate_set);
 
                 issues.push(ValidationIssue {
                     code: format!("V{:03}", {
@@ -858,8 +1114,11 @@ fn detect_contradictions(spec: &Spec, code_counter: &mut usize) -> Vec<Validatio
                 let cover_b = rules_to_cover(std::slice::from_ref(rule_b), &predicate_set);
 
                 if covers_intersect(&cover_a, &cover_b, predicate_set.len()) {
-                    // Check if outputs differ and priorities are same
-                    if rule_a.then != rule_b.then && rule_a.priority == rule_b.priority {
+                    // Check if outputs differ and nothing decides between them
+                    let resolved = spec.resolution == Resolution::MostSpecific
+                        && rule_a.specificity() != rule_b.specificity();
+                    if rule_a.then != rule_b.then && rule_a.priority == rule_b.priority && !resolved
+                    {
                         issues.push(ValidationIssue {
                             code: format!("V{:03}", {
                                 let c = *code_counter;
@@ -873,7 +1132,15 @@ fn detect_contradictions(spec: &Spec, code_counter: &mut usize) -> Vec<Validatio
                                 rule_a.id, rule_b.id
                             ),
                             affected_rules: vec![rule_a.id.clone(), rule_b.id.clone()],
-                            explanation: None,
+                            explanation: Some(format!(
+                                "Both rules have priority {}, so which one wins depends on {}.",
+                                rule_a.priority,
+                                if spec.rules.iter().any(|r| r.priority != rule_a.priority) {
+                                    "their rule IDs"
+                                } else {
+                                    "their order in the file"
+                                }
+                            )),
                             suggestion: Some(
                                 "Set priority on one rule or merge with conditional output".into(),
                             ),
@@ -903,7 +1170,7 @@ fn covers_intersect(cover_a: &Cover, cover_b: &Cover, num_predicates: usize) ->
 #[cfg(test)]
 mod tests {
     use super::*;
-    use crate::spec::{ConditionValue, Output, VarType, WhenClause};
+    use crate::spec::{ConditionValue, Output, RuleState, VarType, WhenClause};
 
     fn make_test_spec() -> Spec {
         Spec {
@@ -915,17 +1182,45 @@ mod tests {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             outputs: vec![crate::spec::Variable {
                 name: "result".into(),
                 typ: VarType::Int,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
             rules: vec![],
             default: None,
             meta: Default::default(),
             scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
         }
     }
 
@@ -947,6 +1242,14 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -955,6 +1258,14 @@ mod tests {
                 then: Output::Single(ConditionValue::Int(2)), // Different output!
                 priority: 0,                                  // Same priority!
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ];
 
@@ -152,12 +152,26 @@ mod tests {
             typ: VarType::String,
             description: None,
             values: Some(vec!["standard".into(), "premium".into()]),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         };
         let var_b = Variable {
             name: "customer_type".into(),
             typ: VarType::String,
             description: None,
             values: Some(vec!["standard".into(), "premium".into()]),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         };
 
         let score = compute_match_score(&var_a, &var_b);
@@ -171,12 +185,26 @@ mod tests {
             typ: VarType::String,
             description: None,
             values: Some(vec!["standard".into(), "premium".into()]),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         };
         let var_b = Variable {
             name: "customer_type".into(),
             typ: VarType::String,
             description: None,
             values: Some(vec!["new".into(), "returning".into()]),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         };
 
         let match_type = classify_match(&var_a, &var_b);
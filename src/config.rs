@@ -5,8 +5,10 @@
 
 use crate::cel::Target;
 use crate::error::{Error, Result};
+use crate::spec::{CustomFunction, Spec};
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
 use std::path::Path;
 
 /// Root project configuration (`.imacs_root`)
@@ -28,6 +30,32 @@ pub struct ImacRoot {
     /// Validation rules
     #[serde(default)]
     pub validation: ValidationConfig,
+
+    /// Spec packages vendored into `packages/`, by name
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub packages: BTreeMap<String, PackageRef>,
+
+    /// Expression functions the project implements itself
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub functions: Vec<CustomFunction>,
+}
+
+/// A pinned dependency on a spec package (see [`crate::pkg`])
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct PackageRef {
+    /// Package registry base URL
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub registry: Option<String>,
+
+    /// Git repository URL; `version` is a tag
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub git: Option<String>,
+
+    /// Pinned version
+    pub version: String,
+
+    /// Hex SHA-256 of the installed bundle
+    pub sha256: String,
 }
 
 /// Project-level configuration
@@ -59,6 +87,10 @@ pub struct DefaultsConfig {
     /// Output directory configuration
     #[serde(default)]
     pub output: Option<OutputConfig>,
+
+    /// Which generated pieces get files of their own
+    #[serde(default)]
+    pub layout: LayoutConfig,
 }
 
 fn default_targets() -> Vec<Target> {
@@ -70,15 +102,92 @@ fn default_true() -> bool {
 }
 
 /// Naming convention for generated files
+///
+/// Patterns take `{spec_id}`, `{spec_pascal}`, `{spec_camel}`, `{lang}`
+/// and `{ext}`; a `/` puts files in subdirectories of the output directory.
 #[derive(Debug, Clone, Serialize, Deserialize, JsonSchema)]
 pub struct NamingConfig {
-    /// Code file pattern: {spec_id}, {lang}, {ext}
+    /// Code file pattern
     #[serde(default = "default_code_naming")]
     pub code: String,
 
-    /// Test file pattern: {spec_id}, {lang}, {ext}
+    /// Test file pattern
     #[serde(default = "default_test_naming")]
     pub tests: String,
+
+    /// Go type declarations file pattern (with `layout.separate_types`),
+    /// naming a file in the code file's directory: Go needs both in one
+    /// package
+    #[serde(default = "default_types_naming")]
+    pub types: String,
+
+    /// Markdown docs file pattern (with `layout.docs`)
+    #[serde(default = "default_docs_naming")]
+    pub docs: String,
+
+    /// Per-language patterns, overriding the ones above
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rust: Option<LanguageNaming>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub typescript: Option<LanguageNaming>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub python: Option<LanguageNaming>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub go: Option<LanguageNaming>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub java: Option<LanguageNaming>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub csharp: Option<LanguageNaming>,
+}
+
+/// File patterns of one language (e.g. `{spec_pascal}Test.{ext}` for Java)
+#[derive(Debug, Clone, Default, Serialize, Deserialize, JsonSchema)]
+pub struct LanguageNaming {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub code: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub tests: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub types: Option<String>,
+}
+
+/// A kind of generated file, named by its own pattern
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FileKind {
+    Code,
+    Tests,
+    Types,
+    Docs,
+}
+
+/// Which generated pieces get files of their own
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct LayoutConfig {
+    /// Move Go type declarations out of the code file into their own file
+    #[serde(default)]
+    pub separate_types: bool,
+
+    /// Where generated tests go
+    #[serde(default)]
+    pub tests: TestLayout,
+
+    /// Write a Markdown page per spec: its inputs, outputs and rules
+    #[serde(default)]
+    pub docs: bool,
+}
+
+/// Where generated tests go
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum TestLayout {
+    /// A test file next to the code file
+    #[default]
+    Separate,
+    /// At the end of the code file; Rust only, as the other languages'
+    /// test runners look for test files
+    Inline,
+    /// No tests
+    Omit,
 }
 
 /// Output directory configuration
@@ -95,6 +204,9 @@ pub struct OutputConfig {
     pub go: Option<String>,
     pub java: Option<String>,
     pub csharp: Option<String>,
+
+    /// Docs directory (with `layout.docs`); falls back to `default`
+    pub docs: Option<String>,
 }
 
 fn default_code_naming() -> String {
@@ -105,11 +217,27 @@ fn default_test_naming() -> String {
     "{spec_id}_test.{ext}".to_string()
 }
 
+fn default_types_naming() -> String {
+    "{spec_id}_types.{ext}".to_string()
+}
+
+fn default_docs_naming() -> String {
+    "{spec_id}.md".to_string()
+}
+
 impl Default for NamingConfig {
     fn default() -> Self {
         Self {
             code: default_code_naming(),
             tests: default_test_naming(),
+            types: default_types_naming(),
+            docs: default_docs_naming(),
+            rust: None,
+            typescript: None,
+            python: None,
+            go: None,
+            java: None,
+            csharp: None,
         }
     }
 }
@@ -132,6 +260,23 @@ pub struct ValidationConfig {
     /// Detect output path conflicts (multiple specs writing to same file)
     #[serde(default = "default_true")]
     pub detect_output_conflicts: bool,
+
+    /// Fail regeneration when a spec changed without the `meta.version`
+    /// bump its changes call for
+    #[serde(default)]
+    pub require_version_bump: bool,
+
+    /// Regex every rule ID must match in full (e.g. `R[0-9]+`)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rule_id_pattern: Option<String>,
+
+    /// Require snake_case input and output names
+    #[serde(default)]
+    pub snake_case_fields: bool,
+
+    /// Maximum `&&` conjuncts in one rule condition
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_conjuncts: Option<usize>,
 }
 
 fn default_max_rules() -> usize {
@@ -145,10 +290,70 @@ impl Default for ValidationConfig {
             require_descriptions: false,
             max_rules_per_spec: 50,
             detect_output_conflicts: true,
+            require_version_bump: false,
+            rule_id_pattern: None,
+            snake_case_fields: false,
+            max_conjuncts: None,
         }
     }
 }
 
+impl ValidationConfig {
+    /// Style rules a spec breaks, checked by `imacs lint`
+    pub fn style_issues(&self, spec: &Spec) -> Result<Vec<String>> {
+        let mut issues = Vec::new();
+        if spec.rules.len() > self.max_rules_per_spec {
+            issues.push(format!(
+                "spec {} has {} rules (max_rules_per_spec is {})",
+                spec.id,
+                spec.rules.len(),
+                self.max_rules_per_spec
+            ));
+        }
+
+        if let Some(pattern) = &self.rule_id_pattern {
+            let re = regex::Regex::new(&format!("^(?:{})$", pattern))
+                .map_err(|e| Error::Other(format!("Invalid rule_id_pattern: {}", e)))?;
+            for rule in spec.rules.iter().filter(|r| !re.is_match(&r.id)) {
+                issues.push(format!(
+                    "rule {} does not match rule_id_pattern `{}`",
+                    rule.id, pattern
+                ));
+            }
+        }
+
+        if self.snake_case_fields {
+            for var in spec.inputs.iter().chain(&spec.outputs) {
+                if !is_snake_case(&var.name) {
+                    issues.push(format!("field {} is not snake_case", var.name));
+                }
+            }
+        }
+
+        if let Some(max) = self.max_conjuncts {
+            for rule in &spec.rules {
+                let count = rule.conjuncts().len();
+                if count > max {
+                    issues.push(format!(
+                        "rule {} has {} conjuncts (max_conjuncts is {})",
+                        rule.id, count, max
+                    ));
+                }
+            }
+        }
+        Ok(issues)
+    }
+}
+
+pub(crate) fn is_snake_case(name: &str) -> bool {
+    name.starts_with(|c: char| c.is_ascii_lowercase())
+        && !name.ends_with('_')
+        && !name.contains("__")
+        && name
+            .chars()
+            .all(|c| c.is_ascii_lowercase() || c.is_ascii_digit() || c == '_')
+}
+
 /// Local configuration in an imacs folder (merges with root defaults)
 #[derive(Debug, Clone, Serialize, Deserialize, JsonSchema)]
 pub struct LocalConfig {
@@ -163,6 +368,9 @@ pub struct LocalConfig {
 
     /// Override output directory configuration
     pub output: Option<OutputConfig>,
+
+    /// Override the file layout
+    pub layout: Option<LayoutConfig>,
 }
 
 /// Merged configuration for a specific imacs folder
@@ -174,6 +382,8 @@ pub struct MergedConfig {
     pub validation: ValidationConfig,
     pub spec_id_prefix: String,
     pub output: OutputConfig,
+    pub layout: LayoutConfig,
+    pub functions: Vec<CustomFunction>,
 }
 
 impl ImacRoot {
@@ -199,6 +409,13 @@ impl ImacRoot {
         Ok(Some(root))
     }
 
+    /// Write `.imacs_root` to a directory
+    pub fn save_to_dir(&self, dir: &Path) -> Result<()> {
+        let content = serde_norway::to_string(self)
+            .map_err(|e| Error::Other(format!("Failed to serialize .imacs_root: {}", e)))?;
+        std::fs::write(dir.join(".imacs_root"), content).map_err(Error::Io)
+    }
+
     /// Merge with local config to produce final config
     pub fn merge(&self, local: Option<&LocalConfig>) -> MergedConfig {
         let local = local.unwrap_or(&LocalConfig {
@@ -206,6 +423,7 @@ impl ImacRoot {
             auto_format: None,
             naming: None,
             output: None,
+            layout: None,
         });
 
         // Merge output config: local overrides root, root defaults to empty
@@ -223,6 +441,7 @@ impl ImacRoot {
                 go: local_output.go.clone().or(root_output.go.clone()),
                 java: local_output.java.clone().or(root_output.java.clone()),
                 csharp: local_output.csharp.clone().or(root_output.csharp.clone()),
+                docs: local_output.docs.clone().or(root_output.docs.clone()),
             }
         } else {
             root_output
@@ -241,6 +460,11 @@ impl ImacRoot {
             validation: self.validation.clone(),
             spec_id_prefix: self.project.spec_id_prefix.clone(),
             output: merged_output,
+            layout: local
+                .layout
+                .clone()
+                .unwrap_or_else(|| self.defaults.layout.clone()),
+            functions: self.functions.clone(),
         }
     }
 }
@@ -264,11 +488,37 @@ impl LocalConfig {
 impl MergedConfig {
     /// Apply naming pattern to generate output filename
     pub fn apply_naming(&self, spec_id: &str, lang: &Target, is_test: bool) -> String {
-        let pattern = if is_test {
-            &self.naming.tests
+        let kind = if is_test {
+            FileKind::Tests
         } else {
-            &self.naming.code
+            FileKind::Code
         };
+        self.file_name(kind, spec_id, lang)
+    }
+
+    /// Output filename of one kind of generated file, relative to the
+    /// output directory
+    pub fn file_name(&self, kind: FileKind, spec_id: &str, lang: &Target) -> String {
+        let naming = match lang {
+            Target::Rust => &self.naming.rust,
+            Target::TypeScript => &self.naming.typescript,
+            Target::Python => &self.naming.python,
+            Target::Go => &self.naming.go,
+            Target::Java => &self.naming.java,
+            Target::CSharp => &self.naming.csharp,
+        };
+        let language = naming.as_ref().and_then(|n| match kind {
+            FileKind::Code => n.code.as_ref(),
+            FileKind::Tests => n.tests.as_ref(),
+            FileKind::Types => n.types.as_ref(),
+            FileKind::Docs => None,
+        });
+        let pattern = language.unwrap_or(match kind {
+            FileKind::Code => &self.naming.code,
+            FileKind::Tests => &self.naming.tests,
+            FileKind::Types => &self.naming.types,
+            FileKind::Docs => &self.naming.docs,
+        });
 
         let ext = match lang {
             Target::Rust => "rs",
@@ -278,11 +528,25 @@ impl MergedConfig {
             Target::Java => "java",
             Target::CSharp => "cs",
         };
+        let ext = if kind == FileKind::Docs { "md" } else { ext };
 
-        pattern
+        let name = pattern
             .replace("{spec_id}", spec_id)
+            .replace("{spec_pascal}", &crate::util::to_pascal_case(spec_id))
+            .replace("{spec_camel}", &crate::util::to_camel_case(spec_id))
             .replace("{lang}", &format!("{:?}", lang).to_lowercase())
-            .replace("{ext}", ext)
+            .replace("{ext}", ext);
+        if kind != FileKind::Types {
+            return name;
+        }
+        // Types go beside the code, in its package
+        match self
+            .file_name(FileKind::Code, spec_id, lang)
+            .rsplit_once('/')
+        {
+            Some((dir, _)) => format!("{}/{}", dir, name),
+            None => name,
+        }
     }
 }
 
@@ -299,6 +563,8 @@ mod tests {
             validation: ValidationConfig::default(),
             spec_id_prefix: "".to_string(),
             output: OutputConfig::default(),
+            layout: LayoutConfig::default(),
+            functions: Vec::new(),
         };
 
         assert_eq!(
@@ -315,6 +581,87 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_file_layout() {
+        let root: ImacRoot = serde_norway::from_str(
+            r#"
+version: 1
+project:
+  name: test
+defaults:
+  targets: [go, java]
+  naming:
+    code: "{spec_id}/{spec_id}.{ext}"
+    java:
+      code: "{spec_pascal}.{ext}"
+      tests: "{spec_pascal}Test.{ext}"
+  layout:
+    separate_types: true
+    tests: inline
+    docs: true
+"#,
+        )
+        .unwrap();
+        let config = root.merge(None);
+        assert_eq!(
+            config.file_name(FileKind::Code, "shipping_rate", &Target::Go),
+            "shipping_rate/shipping_rate.go"
+        );
+        assert_eq!(
+            config.file_name(FileKind::Types, "shipping_rate", &Target::Go),
+            "shipping_rate/shipping_rate_types.go"
+        );
+        assert_eq!(
+            config.apply_naming("shipping_rate", &Target::Java, true),
+            "ShippingRateTest.java"
+        );
+        assert_eq!(
+            config.file_name(FileKind::Docs, "shipping_rate", &Target::Java),
+            "shipping_rate.md"
+        );
+        assert!(config.layout.separate_types && config.layout.docs);
+        assert_eq!(config.layout.tests, TestLayout::Inline);
+
+        let local: LocalConfig = serde_norway::from_str("layout:\n  tests: omit\n").unwrap();
+        let config = root.merge(Some(&local));
+        assert_eq!(
+            config.layout,
+            LayoutConfig {
+                tests: TestLayout::Omit,
+                ..Default::default()
+            }
+        );
+    }
+
+    #[test]
+    fn test_custom_functions() {
+        use crate::spec::VarType;
+
+        let root: ImacRoot = serde_norway::from_str(
+            r#"
+version: 1
+project:
+  name: test
+functions:
+  - name: dimWeight
+    params: [float, float]
+    returns: float
+    go: example.com/shipping/parcel.DimWeight
+"#,
+        )
+        .unwrap();
+        let config = root.merge(None);
+        assert_eq!(config.functions.len(), 1);
+        assert_eq!(config.functions[0].params, vec![VarType::Float; 2]);
+        assert_eq!(
+            config.functions[0].go_call(),
+            Some((
+                "example.com/shipping/parcel",
+                "parcel.DimWeight".to_string()
+            ))
+        );
+    }
+
     #[test]
     fn test_merge_config() {
         let root = ImacRoot {
@@ -329,8 +676,11 @@ mod tests {
                 auto_format: true,
                 naming: NamingConfig::default(),
                 output: None,
+                layout: LayoutConfig::default(),
             },
             validation: ValidationConfig::default(),
+            packages: BTreeMap::new(),
+            functions: Vec::new(),
         };
 
         let local = LocalConfig {
@@ -338,6 +688,7 @@ mod tests {
             auto_format: None,
             naming: None,
             output: None,
+            layout: None,
         };
 
         let merged = root.merge(Some(&local));
@@ -365,8 +716,11 @@ mod tests {
                 auto_format: true,
                 naming: NamingConfig::default(),
                 output: Some(root_output),
+                layout: LayoutConfig::default(),
             },
             validation: ValidationConfig::default(),
+            packages: BTreeMap::new(),
+            functions: Vec::new(),
         };
 
         let local_output = OutputConfig {
@@ -379,6 +733,7 @@ mod tests {
             auto_format: None,
             naming: None,
             output: Some(local_output),
+            layout: None,
         };
 
         let merged = root.merge(Some(&local));
@@ -390,4 +745,56 @@ mod tests {
         // Root default should be present (not overridden)
         assert_eq!(merged.output.default, Some("./generated".to_string()));
     }
+
+    #[test]
+    fn test_style_issues() {
+        let spec = Spec::from_yaml(
+            r#"
+id: pricing
+inputs:
+  - name: orderTotal
+    type: int
+  - name: tier
+    type: string
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "orderTotal > 100 && tier == 'gold' && orderTotal < 1000"
+    then: 1
+  - id: gold_rule
+    when: "tier == 'gold'"
+    then: 2
+"#,
+        )
+        .unwrap();
+        assert!(ValidationConfig::default()
+            .style_issues(&spec)
+            .unwrap()
+            .is_empty());
+
+        let style = ValidationConfig {
+            max_rules_per_spec: 1,
+            rule_id_pattern: Some("R[0-9]+".into()),
+            snake_case_fields: true,
+            max_conjuncts: Some(2),
+            ..Default::default()
+        };
+        assert_eq!(
+            style.style_issues(&spec).unwrap(),
+            vec![
+                "spec pricing has 2 rules (max_rules_per_spec is 1)",
+                "rule gold_rule does not match rule_id_pattern `R[0-9]+`",
+                "field orderTotal is not snake_case",
+                "rule R1 has 3 conjuncts (max_conjuncts is 2)",
+            ]
+        );
+
+        let bad = ValidationConfig {
+            rule_id_pattern: Some("R[".into()),
+            ..Default::default()
+        };
+        assert!(bad.style_issues(&spec).is_err());
+    }
 }
@@ -2,7 +2,8 @@
 //!
 //! Validates `.imacs_root` and `config.yaml` files for correctness.
 
-use crate::config::{ImacRoot, LocalConfig, NamingConfig};
+use crate::cel::Target;
+use crate::config::{ImacRoot, LayoutConfig, LocalConfig, NamingConfig, TestLayout};
 use std::path::Path;
 
 /// Severity level for validation issues
@@ -144,6 +145,12 @@ pub fn validate_imacs_root(path: &Path) -> ConfigValidationResult {
 
     // Validate naming patterns
     validate_naming_pattern(&root.defaults.naming, &file_str, &mut result);
+    validate_layout(
+        &root.defaults.targets,
+        &root.defaults.layout,
+        &file_str,
+        &mut result,
+    );
 
     // Validate imacs_version constraint (if specified)
     if !root.imacs_version.is_empty() {
@@ -203,7 +210,7 @@ pub fn validate_local_config(path: &Path) -> ConfigValidationResult {
     };
 
     // Try to parse YAML
-    let _config: LocalConfig = match serde_norway::from_str(&content) {
+    let config: LocalConfig = match serde_norway::from_str(&content) {
         Ok(c) => c,
         Err(e) => {
             result.issues.push(ConfigIssue::error(
@@ -215,45 +222,122 @@ pub fn validate_local_config(path: &Path) -> ConfigValidationResult {
         }
     };
 
+    // Without targets of its own the folder takes the root's, unknown here
+    if let (Some(targets), Some(layout)) = (&config.targets, &config.layout) {
+        validate_layout(targets, layout, &file_str, &mut result);
+    }
+
     result.local_configs_checked = 1;
     result
 }
 
-/// Validate naming pattern has required placeholders
-fn validate_naming_pattern(naming: &NamingConfig, file: &str, result: &mut ConfigValidationResult) {
-    // Code pattern must have {spec_id} and {ext}
-    if !naming.code.contains("{spec_id}") {
+/// Validate the layout fits the targets: inline tests are Rust only
+fn validate_layout(
+    targets: &[Target],
+    layout: &LayoutConfig,
+    file: &str,
+    result: &mut ConfigValidationResult,
+) {
+    let others: Vec<String> = targets
+        .iter()
+        .filter(|t| **t != Target::Rust)
+        .map(|t| format!("{:?}", t).to_lowercase())
+        .collect();
+    if layout.tests == TestLayout::Inline && !others.is_empty() {
         result.issues.push(ConfigIssue::error(
-            "E006",
-            "Naming pattern 'code' must contain {spec_id} placeholder",
+            "E011",
+            &format!(
+                "layout.tests: inline is Rust only; {} need separate test files",
+                others.join(", ")
+            ),
             file,
         ));
     }
-    if !naming.code.contains("{ext}") {
-        result.issues.push(ConfigIssue::error(
-            "E007",
-            "Naming pattern 'code' must contain {ext} placeholder",
-            file,
-        ));
+}
+
+/// Validate naming pattern has required placeholders
+fn validate_naming_pattern(naming: &NamingConfig, file: &str, result: &mut ConfigValidationResult) {
+    // Code and test patterns, per language too, must name the spec and
+    // have {ext}
+    let mut patterns = vec![
+        ("code".to_string(), &naming.code),
+        ("tests".to_string(), &naming.tests),
+        ("types".to_string(), &naming.types),
+    ];
+    let languages = [
+        ("rust", &naming.rust),
+        ("typescript", &naming.typescript),
+        ("python", &naming.python),
+        ("go", &naming.go),
+        ("java", &naming.java),
+        ("csharp", &naming.csharp),
+    ];
+    for (lang, overrides) in languages {
+        if let Some(overrides) = overrides {
+            for (kind, pattern) in [
+                ("code", &overrides.code),
+                ("tests", &overrides.tests),
+                ("types", &overrides.types),
+            ] {
+                if let Some(pattern) = pattern {
+                    patterns.push((format!("{}.{}", lang, kind), pattern));
+                }
+            }
+        }
     }
 
-    // Test pattern must have {spec_id} and {ext}
-    if !naming.tests.contains("{spec_id}") {
-        result.issues.push(ConfigIssue::error(
-            "E008",
-            "Naming pattern 'tests' must contain {spec_id} placeholder",
-            file,
-        ));
+    for (name, pattern) in patterns {
+        let (spec_code, ext_code) = if name.ends_with("tests") {
+            ("E008", "E009")
+        } else {
+            ("E006", "E007")
+        };
+        if !names_spec(pattern) {
+            result.issues.push(ConfigIssue::error(
+                spec_code,
+                &format!(
+                    "Naming pattern '{}' must contain {{spec_id}}, {{spec_pascal}} or {{spec_camel}} placeholder",
+                    name
+                ),
+                file,
+            ));
+        }
+        if !pattern.contains("{ext}") {
+            result.issues.push(ConfigIssue::error(
+                ext_code,
+                &format!("Naming pattern '{}' must contain {{ext}} placeholder", name),
+                file,
+            ));
+        }
+        // Types share the code file's directory, and so its Go package
+        if name.ends_with("types") && pattern.contains('/') {
+            result.issues.push(ConfigIssue::error(
+                "E010",
+                &format!(
+                    "Naming pattern '{}' names a file in the code file's directory and cannot contain '/'",
+                    name
+                ),
+                file,
+            ));
+        }
     }
-    if !naming.tests.contains("{ext}") {
+
+    // Docs are Markdown whatever the language, so need no {ext}
+    if !names_spec(&naming.docs) {
         result.issues.push(ConfigIssue::error(
-            "E009",
-            "Naming pattern 'tests' must contain {ext} placeholder",
+            "E006",
+            "Naming pattern 'docs' must contain {spec_id}, {spec_pascal} or {spec_camel} placeholder",
             file,
         ));
     }
 }
 
+fn names_spec(pattern: &str) -> bool {
+    ["{spec_id}", "{spec_pascal}", "{spec_camel}"]
+        .iter()
+        .any(|p| pattern.contains(p))
+}
+
 /// Validate version constraint syntax
 fn validate_version_constraint(constraint: &str, file: &str, result: &mut ConfigValidationResult) {
     // Simple validation - just check for common patterns
@@ -419,4 +503,77 @@ defaults:
         assert!(result.issues.iter().any(|i| i.code == "E006"));
         assert!(result.issues.iter().any(|i| i.code == "E007"));
     }
+
+    #[test]
+    fn test_validate_language_naming_pattern() {
+        let dir = TempDir::new().unwrap();
+        let root_file = dir.path().join(".imacs_root");
+
+        let content = r#"
+version: 1
+imacs_version: ">=0.1.0"
+project:
+  name: test
+defaults:
+  targets: [java]
+  naming:
+    java:
+      code: "{spec_pascal}.{ext}"
+      tests: "{spec_pascal}Test.java"
+"#;
+        std::fs::write(&root_file, content).unwrap();
+
+        let result = validate_imacs_root(&root_file);
+        let errors: Vec<_> = result
+            .issues
+            .iter()
+            .filter(|i| i.code.starts_with('E'))
+            .collect();
+        assert_eq!(errors.len(), 1);
+        assert_eq!(errors[0].code, "E009");
+        assert!(errors[0].message.contains("'java.tests'"));
+    }
+
+    #[test]
+    fn test_validate_layout() {
+        let dir = TempDir::new().unwrap();
+        let root_file = dir.path().join(".imacs_root");
+
+        let content = r#"
+version: 1
+project:
+  name: test
+defaults:
+  targets: [rust, go]
+  naming:
+    types: "types/{spec_id}.{ext}"
+  layout:
+    tests: inline
+"#;
+        std::fs::write(&root_file, content).unwrap();
+
+        let result = validate_imacs_root(&root_file);
+        let errors: Vec<_> = result
+            .issues
+            .iter()
+            .filter(|i| i.code.starts_with('E'))
+            .collect();
+        assert_eq!(errors.len(), 2);
+        assert_eq!(errors[0].code, "E010");
+        assert_eq!(errors[1].code, "E011");
+        assert!(errors[1].message.contains("go need separate test files"));
+
+        let config_file = dir.path().join("config.yaml");
+        std::fs::write(&config_file, "targets: [rust]\nlayout:\n  tests: inline\n").unwrap();
+        assert!(!validate_local_config(&config_file).has_errors());
+        std::fs::write(
+            &config_file,
+            "targets: [python]\nlayout:\n  tests: inline\n",
+        )
+        .unwrap();
+        assert!(validate_local_config(&config_file)
+            .issues
+            .iter()
+            .any(|i| i.code == "E011"));
+    }
 }
@@ -0,0 +1,259 @@
+//! Spec constants and their per-environment overrides
+//!
+//! A spec names the values its rules share under `constants:` and refers
+//! to them as `${name}`, wholly (`then: ${heavy_rate}`) or inside an
+//! expression (`when: "weight_kg > ${heavy_kg}"`):
+//!
+//! ```yaml
+//! constants:
+//!   - name: heavy_kg
+//!     type: float
+//!     value: 20.0
+//! ```
+//!
+//! An environment replaces values, never rules: with `IMACS_ENV=staging`,
+//! [`crate::Spec::from_file`] reads `overrides/staging.yaml` next to the
+//! spec, which maps spec ids to the constants it changes:
+//!
+//! ```yaml
+//! shipping_rate:
+//!   heavy_kg: 2.0
+//! ```
+//!
+//! Overrides must name specs in the same directory and their declared
+//! constants, and match the constants' types.
+
+use crate::error::{Error, Result};
+use crate::spec::{ConditionValue, Constant, Spec};
+use serde_norway::Value;
+use std::collections::BTreeMap;
+use std::path::Path;
+
+/// Environment variable naming the environment whose overrides apply
+pub const ENV_VAR: &str = "IMACS_ENV";
+
+/// Whether a spec's YAML declares constants
+pub fn declares(yaml: &str) -> bool {
+    yaml.starts_with("constants:") || yaml.contains("\nconstants:")
+}
+
+/// The spec with its constants' `${name}` references replaced by their
+/// values, `overrides` taking precedence over the declared ones
+pub fn resolve(yaml: &str, overrides: &BTreeMap<String, ConditionValue>) -> Result<Spec> {
+    let mut body: Value =
+        serde_norway::from_str(yaml).map_err(|e| Error::SpecParse(e.to_string()))?;
+    let mut constants: Vec<Constant> =
+        match body.as_mapping_mut().and_then(|m| m.remove("constants")) {
+            Some(constants) => {
+                serde_norway::from_value(constants).map_err(|e| Error::SpecParse(e.to_string()))?
+            }
+            None => Vec::new(),
+        };
+
+    let mut errors = Vec::new();
+    for (name, value) in overrides {
+        match constants.iter_mut().find(|c| &c.name == name) {
+            Some(constant) => constant.value = value.clone(),
+            None => errors.push(format!("override '{}' is not a declared constant", name)),
+        }
+    }
+    for constant in &constants {
+        if !constant.value.is_valid_for(&constant.typ) {
+            errors.push(format!(
+                "constant '{}' is a {}, not {}",
+                constant.name,
+                crate::compat::type_name(&constant.typ),
+                constant.value
+            ));
+        }
+    }
+    if errors.is_empty() {
+        let values = constants
+            .iter()
+            .map(|c| (c.name.as_str(), c.value.clone()))
+            .collect();
+        crate::instantiate::substitute(&mut body, &values, &mut errors);
+    }
+    if !errors.is_empty() {
+        errors.sort();
+        errors.dedup();
+        return Err(Error::SpecParse(format!(
+            "Constants: {}",
+            errors.join("; ")
+        )));
+    }
+
+    let mut spec: Spec =
+        serde_norway::from_value(body).map_err(|e| Error::SpecParse(e.to_string()))?;
+    spec.constants = constants;
+    Ok(spec)
+}
+
+/// The overrides `overrides/{env}.yaml` in `dir` holds for the spec in
+/// `yaml`, for the environment [`ENV_VAR`] names; empty when no
+/// environment is set, the file does not exist or the spec declares no
+/// constants
+pub fn env_overrides(dir: &Path, yaml: &str) -> Result<BTreeMap<String, ConditionValue>> {
+    match std::env::var(ENV_VAR) {
+        Ok(env) if !env.is_empty() && declares(yaml) => overrides(dir, &env, yaml),
+        _ => Ok(BTreeMap::new()),
+    }
+}
+
+/// The overrides `overrides/{env}.yaml` in `dir` holds for the spec in
+/// `yaml`; every spec id the file names must be one of the specs in `dir`
+pub fn overrides(dir: &Path, env: &str, yaml: &str) -> Result<BTreeMap<String, ConditionValue>> {
+    let path = dir.join("overrides").join(format!("{}.yaml", env));
+    if !path.exists() {
+        return Ok(BTreeMap::new());
+    }
+    let mut by_spec: BTreeMap<String, BTreeMap<String, ConditionValue>> =
+        serde_norway::from_str(&std::fs::read_to_string(&path)?)
+            .map_err(|e| Error::SpecParse(format!("{}: {}", path.display(), e)))?;
+    let body: Value = serde_norway::from_str(yaml).map_err(|e| Error::SpecParse(e.to_string()))?;
+    let id = body.get("id").and_then(Value::as_str).unwrap_or_default();
+    let mut ids = spec_ids(dir)?;
+    ids.push(id.to_string());
+    let unknown: Vec<&str> = by_spec
+        .keys()
+        .filter(|key| !ids.contains(*key))
+        .map(String::as_str)
+        .collect();
+    if !unknown.is_empty() {
+        return Err(Error::SpecParse(format!(
+            "{}: no spec in {} has the id {}",
+            path.display(),
+            dir.display(),
+            unknown
+                .iter()
+                .map(|key| format!("'{}'", key))
+                .collect::<Vec<_>>()
+                .join(", ")
+        )));
+    }
+    Ok(by_spec.remove(id).unwrap_or_default())
+}
+
+/// Ids of the spec files directly in `dir`; files without one are skipped
+fn spec_ids(dir: &Path) -> Result<Vec<String>> {
+    let dir = if dir.as_os_str().is_empty() {
+        Path::new(".")
+    } else {
+        dir
+    };
+    let mut ids = Vec::new();
+    for entry in std::fs::read_dir(dir).map_err(Error::Io)? {
+        let path = entry.map_err(Error::Io)?.path();
+        let yaml = matches!(
+            path.extension().and_then(|e| e.to_str()),
+            Some("yaml" | "yml")
+        );
+        if !yaml || !path.is_file() {
+            continue;
+        }
+        let body: Option<Value> = std::fs::read_to_string(&path)
+            .ok()
+            .and_then(|text| serde_norway::from_str(&text).ok());
+        if let Some(id) = body
+            .as_ref()
+            .and_then(|b| b.get("id"))
+            .and_then(Value::as_str)
+        {
+            ids.push(id.to_string());
+        }
+    }
+    Ok(ids)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+constants:
+  - name: heavy_kg
+    type: float
+    value: 20.0
+  - name: heavy_rate
+    type: float
+    value: 25.0
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > ${heavy_kg}"
+    then: ${heavy_rate}
+default: 5.0
+"#;
+
+    #[test]
+    fn test_constants() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        assert_eq!(spec.rules[0].as_cel().as_deref(), Some("weight_kg > 20.0"));
+        assert_eq!(
+            spec.rules[0].then,
+            crate::spec::Output::Single(ConditionValue::Float(25.0))
+        );
+        assert_eq!(spec.rules[0].line, Some(17));
+        assert!(spec.validate().is_empty());
+
+        let overrides = BTreeMap::from([("heavy_kg".to_string(), ConditionValue::Float(2.0))]);
+        let staging = Spec::from_yaml_overriding(SPEC, &overrides).unwrap();
+        assert_eq!(
+            staging.rules[0].as_cel().as_deref(),
+            Some("weight_kg > 2.0")
+        );
+        assert_eq!(staging.constants[0].value, ConditionValue::Float(2.0));
+
+        let bad = BTreeMap::from([
+            (
+                "heavy_rate".to_string(),
+                ConditionValue::String("cheap".into()),
+            ),
+            ("rules".to_string(), ConditionValue::List(Vec::new())),
+        ]);
+        let err = Spec::from_yaml_overriding(SPEC, &bad)
+            .unwrap_err()
+            .to_string();
+        assert_eq!(
+            err,
+            "Spec parse error: Constants: constant 'heavy_rate' is a float, not \"cheap\"; \
+             override 'rules' is not a declared constant"
+        );
+    }
+
+    #[test]
+    fn test_overrides_file() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::create_dir(dir.path().join("overrides")).unwrap();
+        std::fs::write(
+            dir.path().join("overrides/staging.yaml"),
+            "shipping_rate:\n  heavy_kg: 2.0\nother_spec:\n  limit: 1\n",
+        )
+        .unwrap();
+        std::fs::write(dir.path().join("other_spec.yaml"), "id: other_spec\n").unwrap();
+
+        let staging = overrides(dir.path(), "staging", SPEC).unwrap();
+        assert_eq!(staging.len(), 1);
+        assert_eq!(staging["heavy_kg"], ConditionValue::Float(2.0));
+        assert!(overrides(dir.path(), "production", SPEC)
+            .unwrap()
+            .is_empty());
+
+        // A misspelled spec id would otherwise silently override nothing
+        std::fs::write(
+            dir.path().join("overrides/staging.yaml"),
+            "shiping_rate:\n  heavy_kg: 2.0\n",
+        )
+        .unwrap();
+        let err = overrides(dir.path(), "staging", SPEC)
+            .unwrap_err()
+            .to_string();
+        assert!(err.ends_with("has the id 'shiping_rate'"));
+    }
+}
@@ -0,0 +1,364 @@
+//! Rule coverage of generated Go code (`imacs coverage`)
+//!
+//! `imacs regen` writes a rule map next to each generated Go file
+//! (`<id>.rulemap.json`) giving, for every rule, the file and lines of the
+//! branch that decides it. [`rule_coverage`] lays a Go coverage profile
+//! (`go test -coverprofile`) over those maps, so a test run reports which
+//! business rules it exercised rather than which lines.
+//!
+//! Profile entries name files by import path; a mapped file matches the
+//! entries whose path ends with its name.
+
+use crate::error::{Error, Result};
+use crate::spec::Spec;
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+
+/// File name suffix of rule maps
+pub const RULE_MAP_SUFFIX: &str = ".rulemap.json";
+
+/// Where each rule of a spec lives in its generated code
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct RuleMap {
+    pub spec: String,
+    pub spec_hash: String,
+    pub rules: Vec<RuleLines>,
+}
+
+/// The lines (1-based, inclusive) of the branch deciding a rule
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct RuleLines {
+    /// Rule ID, or `default` for the fallback
+    pub rule: String,
+    pub file: String,
+    pub start: usize,
+    pub end: usize,
+}
+
+impl RuleMap {
+    /// Map the deployed rules of `spec` in its generated files, given as
+    /// `(file name, code)`
+    pub fn build(spec: &Spec, files: &[(String, &str)]) -> Self {
+        let spec_hash = spec.hash();
+        let spec = spec.for_codegen();
+        let mut ids: Vec<&str> = spec.rules.iter().map(|r| r.id.as_str()).collect();
+        ids.push("default");
+        let mut rules: Vec<RuleLines> = files
+            .iter()
+            .flat_map(|(file, code)| {
+                rule_lines(code, &ids)
+                    .into_iter()
+                    .map(|(rule, start, end)| RuleLines {
+                        rule,
+                        file: file.clone(),
+                        start,
+                        end,
+                    })
+            })
+            .collect();
+        rules.sort_by_key(|r| ids.iter().position(|id| *id == r.rule));
+        RuleMap {
+            spec: spec.id.clone(),
+            spec_hash,
+            rules,
+        }
+    }
+}
+
+/// Lines of the branches marked with a `// <rule>` comment: from the
+/// comment to the end of the enclosing block
+pub fn rule_lines(code: &str, rules: &[&str]) -> Vec<(String, usize, usize)> {
+    let lines: Vec<&str> = code.lines().collect();
+    let indent = |line: &str| line.len() - line.trim_start().len();
+    let mut found = Vec::new();
+    for (i, line) in lines.iter().enumerate() {
+        let Some(rule) = line.trim().strip_prefix("// ") else {
+            continue;
+        };
+        if !rules.contains(&rule) {
+            continue;
+        }
+        let depth = indent(line);
+        let last = lines[i + 1..]
+            .iter()
+            .position(|l| !l.trim().is_empty() && indent(l) < depth)
+            .map_or(lines.len(), |n| i + 1 + n);
+        let end = (i + 1..last)
+            .rev()
+            .find(|&n| !lines[n].trim().is_empty())
+            .unwrap_or(i);
+        found.push((rule.to_string(), i + 1, end + 1));
+    }
+    found
+}
+
+/// One block of a Go coverage profile
+#[derive(Debug, Clone, PartialEq)]
+pub struct ProfileBlock {
+    pub file: String,
+    pub start_line: usize,
+    pub end_line: usize,
+    pub statements: usize,
+    pub count: u64,
+}
+
+/// Parse a Go coverage profile (`mode:` line, then
+/// `file:line.col,line.col statements count` per block)
+pub fn parse_profile(text: &str) -> Result<Vec<ProfileBlock>> {
+    let mut blocks = Vec::new();
+    for (n, line) in text.lines().enumerate() {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with("mode:") {
+            continue;
+        }
+        let invalid = || Error::Other(format!("coverage profile line {}: '{}'", n + 1, line));
+        let mut fields = line.rsplitn(3, ' ');
+        let count = fields.next().and_then(|c| c.parse().ok());
+        let statements = fields.next().and_then(|s| s.parse().ok());
+        let (file, range) = fields
+            .next()
+            .and_then(|f| f.rsplit_once(':'))
+            .ok_or_else(invalid)?;
+        let line_of = |pos: &str| pos.split('.').next().and_then(|l| l.parse().ok());
+        let (start, end) = range.split_once(',').ok_or_else(invalid)?;
+        blocks.push(ProfileBlock {
+            file: file.to_string(),
+            start_line: line_of(start).ok_or_else(invalid)?,
+            end_line: line_of(end).ok_or_else(invalid)?,
+            statements: statements.ok_or_else(invalid)?,
+            count: count.ok_or_else(invalid)?,
+        });
+    }
+    Ok(blocks)
+}
+
+/// How often a rule's branch ran
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleHit {
+    pub rule: String,
+    pub file: String,
+    pub start: usize,
+    pub end: usize,
+    /// Executions (1 for a `set` mode profile); None when the file is not
+    /// in the profile
+    pub hits: Option<u64>,
+}
+
+/// Coverage of the rules of one spec
+#[derive(Debug, Clone, Serialize)]
+pub struct SpecCoverage {
+    pub spec: String,
+    pub rules: Vec<RuleHit>,
+}
+
+impl SpecCoverage {
+    /// Rules hit, rules located in the profile
+    pub fn covered(&self) -> (usize, usize) {
+        let located: Vec<u64> = self.rules.iter().filter_map(|r| r.hits).collect();
+        (located.iter().filter(|&&h| h > 0).count(), located.len())
+    }
+}
+
+/// Rule coverage of a test run
+#[derive(Debug, Clone, Serialize)]
+pub struct CoverageReport {
+    pub specs: Vec<SpecCoverage>,
+}
+
+impl CoverageReport {
+    /// Percentage of located rules hit, over every spec
+    pub fn percent(&self) -> f64 {
+        let (hit, located) = self
+            .specs
+            .iter()
+            .map(SpecCoverage::covered)
+            .fold((0, 0), |(h, l), (hit, located)| (h + hit, l + located));
+        if located == 0 {
+            0.0
+        } else {
+            hit as f64 * 100.0 / located as f64
+        }
+    }
+
+    pub fn to_report(&self) -> String {
+        let mut out = String::new();
+        for spec in &self.specs {
+            let (hit, located) = spec.covered();
+            out.push_str(&format!(
+                "{}: {}/{} rules covered\n",
+                spec.spec, hit, located
+            ));
+            for rule in &spec.rules {
+                let status = match rule.hits {
+                    Some(0) => "NOT COVERED".to_string(),
+                    Some(hits) => format!("{} hit(s)", hits),
+                    None => "not in profile".to_string(),
+                };
+                out.push_str(&format!(
+                    "  {:<12} {}:{}-{}  {}\n",
+                    rule.rule, rule.file, rule.start, rule.end, status
+                ));
+            }
+        }
+        out.push_str(&format!("Rule coverage: {:.1}%\n", self.percent()));
+        out
+    }
+}
+
+/// Lay a coverage profile over rule maps
+///
+/// A rule's hit count is the highest count of the profile blocks with
+/// statements overlapping its lines. Blocks repeated across profiles
+/// (several packages instrumenting the same file) are added up.
+pub fn rule_coverage(maps: &[RuleMap], profile: &[ProfileBlock]) -> CoverageReport {
+    let mut merged: HashMap<(&str, usize, usize), u64> = HashMap::new();
+    for block in profile.iter().filter(|b| b.statements > 0) {
+        *merged
+            .entry((block.file.as_str(), block.start_line, block.end_line))
+            .or_default() += block.count;
+    }
+    let specs = maps
+        .iter()
+        .map(|map| SpecCoverage {
+            spec: map.spec.clone(),
+            rules: map
+                .rules
+                .iter()
+                .map(|lines| {
+                    let blocks: Vec<_> = merged
+                        .iter()
+                        .filter(|((path, _, _), _)| same_file(path, lines.file.as_str()))
+                        .collect();
+                    let hits = (!blocks.is_empty()).then(|| {
+                        blocks
+                            .iter()
+                            .filter(|((_, start, end), _)| {
+                                *start <= lines.end && *end >= lines.start
+                            })
+                            .map(|(_, count)| **count)
+                            .max()
+                            .unwrap_or(0)
+                    });
+                    RuleHit {
+                        rule: lines.rule.clone(),
+                        file: lines.file.clone(),
+                        start: lines.start,
+                        end: lines.end,
+                        hits,
+                    }
+                })
+                .collect(),
+        })
+        .collect();
+    CoverageReport { specs }
+}
+
+/// Whether a profile path (an import path) names a mapped file
+fn same_file(path: &str, file: &str) -> bool {
+    path == file || path.ends_with(&format!("/{}", file.trim_start_matches("./")))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::cel::Target;
+    use crate::render::render;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: express
+    type: bool
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "zone == 'domestic' && express"
+    then: 900
+  - id: R2
+    when: "zone == 'domestic'"
+    then: 500
+default: 4000
+"#;
+
+    #[test]
+    fn test_rule_lines() {
+        let code = "func F(input In) int {\n\tif a {\n\t\t// R1\n\t\treturn 1\n\t} else if b {\n\t\t// R2\n\n\t\tx := 2\n\t\treturn x\n\t} else {\n\t\t// default\n\t\treturn 0\n\t}\n}\n";
+        assert_eq!(
+            rule_lines(code, &["R1", "R2", "default"]),
+            vec![
+                ("R1".to_string(), 3, 4),
+                ("R2".to_string(), 6, 9),
+                ("default".to_string(), 11, 12)
+            ]
+        );
+    }
+
+    #[test]
+    fn test_generated_go_is_mapped() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let code = render(&spec, Target::Go);
+        let map = RuleMap::build(&spec, &[("shipping_rate.go".to_string(), &code)]);
+        let rules: Vec<&str> = map.rules.iter().map(|r| r.rule.as_str()).collect();
+        assert_eq!(rules, vec!["R1", "R2", "default"]);
+        let lines: Vec<&str> = code.lines().collect();
+        for rule in &map.rules {
+            assert_eq!(lines[rule.start - 1].trim(), format!("// {}", rule.rule));
+            assert!(lines[rule.end - 1].trim().starts_with("return"));
+        }
+    }
+
+    #[test]
+    fn test_profile_to_rule_coverage() {
+        let map = RuleMap {
+            spec: "shipping_rate".into(),
+            spec_hash: String::new(),
+            rules: vec![
+                RuleLines {
+                    rule: "R1".into(),
+                    file: "shipping_rate.go".into(),
+                    start: 3,
+                    end: 4,
+                },
+                RuleLines {
+                    rule: "R2".into(),
+                    file: "shipping_rate.go".into(),
+                    start: 6,
+                    end: 9,
+                },
+                RuleLines {
+                    rule: "default".into(),
+                    file: "other.go".into(),
+                    start: 11,
+                    end: 12,
+                },
+            ],
+        };
+        let profile = parse_profile(
+            "mode: count
+example.com/pricing/gen/shipping_rate.go:1.23,2.7 1 5
+example.com/pricing/gen/shipping_rate.go:2.7,5.3 1 2
+example.com/pricing/gen/shipping_rate.go:5.14,10.3 2 0
+example.com/pricing/gen/shipping_rate.go:2.7,5.3 1 1
+",
+        )
+        .unwrap();
+        assert_eq!(profile.len(), 4);
+        assert_eq!(profile[1].start_line, 2);
+        assert_eq!(profile[1].end_line, 5);
+
+        let report = rule_coverage(&[map], &profile);
+        let hits: Vec<Option<u64>> = report.specs[0].rules.iter().map(|r| r.hits).collect();
+        assert_eq!(hits, vec![Some(3), Some(0), None]);
+        assert_eq!(report.specs[0].covered(), (1, 2));
+        assert_eq!(report.percent(), 50.0);
+        let text = report.to_report();
+        assert!(text.contains("shipping_rate: 1/2 rules covered"));
+        assert!(text.contains("NOT COVERED"));
+
+        assert!(parse_profile("mode: set\nshipping_rate.go 1 1\n").is_err());
+    }
+}
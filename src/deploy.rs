@@ -0,0 +1,443 @@
+//! Plan and apply spec deployments (`imacs plan`, `imacs apply`)
+//!
+//! Rule deployments get the rigor of infrastructure changes. [`plan`]
+//! compares a spec with the version deployed from a registry directory
+//! (laid out as [`crate::registry`] describes): the API and rule changes,
+//! the version bump they need and, given recorded decisions, how many of
+//! those the new rules decide differently. It ends in an approval token
+//! derived from both the new spec and the deployed one.
+//!
+//! [`apply`] plans again and proceeds only with the token of the reviewed
+//! plan, so a spec edited after review, or another deployment in between,
+//! is refused rather than applied blind. It then publishes the version and
+//! points `{name}/deployed` at it. Applying an already published version
+//! only moves the pointer, which is how a deployment is rolled back.
+
+use crate::compat::{self, CompatReport};
+use crate::error::{Error, Result};
+use crate::registry::sha256_hex;
+use crate::spec::Spec;
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::fmt::Write;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// File in a spec's registry directory naming the deployed version
+pub const DEPLOYED_FILE: &str = "deployed";
+
+/// A published spec version
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Release {
+    pub version: String,
+    /// Hex SHA-256 of the spec file
+    pub digest: String,
+}
+
+/// Recorded decisions the new rules decide differently
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct Impact {
+    pub replayed: usize,
+    pub changed: usize,
+    /// Changed decisions by the rule now deciding them (`default` for the
+    /// default)
+    pub by_rule: BTreeMap<String, usize>,
+    /// Records that could not be replayed
+    pub failed: usize,
+}
+
+/// What applying a spec would change
+#[derive(Debug, Clone, Serialize)]
+pub struct Plan {
+    pub spec: String,
+    pub release: Release,
+    /// Version deployed now, `None` for a first deployment
+    pub deployed: Option<Release>,
+    /// Whether the version is published already (a rollback or re-apply)
+    pub published: bool,
+    pub compat: CompatReport,
+    /// The changes as changelog sentences
+    pub changes: Vec<String>,
+    /// Why the version is not bumped enough for the changes, if it is not
+    pub version_error: Option<String>,
+    pub impact: Option<Impact>,
+    /// Token `apply` needs to deploy exactly this plan
+    pub token: String,
+}
+
+impl Plan {
+    /// Whether applying the plan changes what is deployed
+    pub fn has_changes(&self) -> bool {
+        self.deployed.as_ref() != Some(&self.release)
+    }
+
+    pub fn to_report(&self) -> String {
+        let mut out = match &self.deployed {
+            Some(deployed) => format!(
+                "Plan: {} {} -> {}\n",
+                self.spec, deployed.version, self.release.version
+            ),
+            None => format!(
+                "Plan: {} {} (first deployment)\n",
+                self.spec, self.release.version
+            ),
+        };
+        if !self.has_changes() {
+            out.push_str("\nNo changes: this version is deployed.\n");
+            return out;
+        }
+        if self.published {
+            out.push_str("\nVersion already published; apply only redeploys it.\n");
+        }
+
+        if self.deployed.is_some() {
+            out.push('\n');
+            out.push_str(&self.compat.to_report());
+            if !self.changes.is_empty() {
+                out.push_str("\nChanges:\n");
+                for change in &self.changes {
+                    let _ = writeln!(out, "  - {}", change);
+                }
+            }
+        }
+        if let Some(error) = &self.version_error {
+            let _ = writeln!(out, "\n✗ {}", error);
+        }
+        if let Some(impact) = &self.impact {
+            let _ = writeln!(
+                out,
+                "\nImpact: {} of {} recorded decision(s) change",
+                impact.changed, impact.replayed
+            );
+            for (rule, count) in &impact.by_rule {
+                let _ = writeln!(out, "  {}: {}", rule, count);
+            }
+            if impact.failed > 0 {
+                let _ = writeln!(out, "  {} record(s) could not be replayed", impact.failed);
+            }
+        }
+
+        let _ = writeln!(
+            out,
+            "\nTo deploy this plan: imacs apply <spec.yaml> --registry <dir> --approve {}",
+            self.token
+        );
+        out
+    }
+}
+
+/// Plan deploying the spec in `yaml` to the registry in `registry_dir`
+///
+/// `records` is a replay recording (JSONL) of deployed decisions; it adds
+/// the impact to the plan.
+pub fn plan(yaml: &str, registry_dir: &Path, records: Option<&str>) -> Result<Plan> {
+    let spec = Spec::from_yaml(yaml)?;
+    let errors: Vec<String> = spec
+        .validate()
+        .into_iter()
+        .filter(|e| !e.starts_with("Warning"))
+        .collect();
+    if !errors.is_empty() {
+        return Err(Error::Other(format!(
+            "Spec {} is invalid: {}",
+            spec.id,
+            errors.join("; ")
+        )));
+    }
+    let version = spec.meta.version.clone().ok_or_else(|| {
+        Error::Other(format!(
+            "{}: meta.version is not set; a deployment needs one",
+            spec.id
+        ))
+    })?;
+    for part in [spec.id.as_str(), version.as_str()] {
+        if part.is_empty() || part.contains('/') || part.contains("..") {
+            return Err(Error::Registry(format!(
+                "Invalid spec name or version: '{}'",
+                part
+            )));
+        }
+    }
+    let release = Release {
+        version,
+        digest: sha256_hex(yaml.as_bytes()),
+    };
+
+    let dir = registry_dir.join(&spec.id);
+    let published = match published_digest(&dir, &release.version)? {
+        Some(digest) if digest != release.digest => {
+            return Err(Error::Registry(format!(
+                "{}@{} is already published with other content; bump meta.version",
+                spec.id, release.version
+            )))
+        }
+        Some(_) => true,
+        None => false,
+    };
+
+    let deployed = match read(&dir.join(DEPLOYED_FILE))? {
+        Some(version) => {
+            let version = version.trim().to_string();
+            let old = load(&dir, &spec.id, &version)?;
+            Some((old, version))
+        }
+        None => None,
+    };
+
+    let (compat, changes, version_error, deployed) = match deployed {
+        Some(((old, digest), version)) => {
+            let compat = compat::compare(&old, &spec);
+            // A published version was checked when it was first applied
+            let version_error = if published {
+                None
+            } else {
+                compat::check_version(&old, &spec, &compat)
+                    .err()
+                    .map(|e| e.to_string())
+            };
+            (
+                compat,
+                crate::changelog::changes(&old, &spec),
+                version_error,
+                Some(Release { version, digest }),
+            )
+        }
+        None => (CompatReport::default(), Vec::new(), None, None),
+    };
+
+    let impact = records.map(|jsonl| {
+        let report = crate::replay::replay(&spec, jsonl, &[]);
+        let mut by_rule = BTreeMap::new();
+        for change in report.regressions.iter().chain(&report.accepted) {
+            let rule = change.rule_id.clone().unwrap_or_else(|| "default".into());
+            *by_rule.entry(rule).or_insert(0) += 1;
+        }
+        Impact {
+            replayed: report.replayed,
+            changed: report.regressions.len() + report.accepted.len(),
+            by_rule,
+            failed: report.failures.len(),
+        }
+    });
+
+    let deployed_key = deployed.as_ref().map_or("-\n-".to_string(), |d| {
+        format!("{}\n{}", d.version, d.digest)
+    });
+    let token = sha256_hex(
+        format!(
+            "{}\n{}\n{}\n{}",
+            spec.id, release.version, release.digest, deployed_key
+        )
+        .as_bytes(),
+    )[..16]
+        .to_string();
+
+    Ok(Plan {
+        spec: spec.id,
+        release,
+        deployed,
+        published,
+        compat,
+        changes,
+        version_error,
+        impact,
+        token,
+    })
+}
+
+/// Deploy the spec in `yaml` if `token` approves its current plan
+///
+/// Returns the plan applied. The version's files are written before the
+/// `deployed` pointer moves, and the pointer is replaced atomically, so
+/// readers never see a version that is not there.
+pub fn apply(yaml: &str, registry_dir: &Path, token: &str) -> Result<Plan> {
+    let plan = plan(yaml, registry_dir, None)?;
+    if token != plan.token {
+        return Err(Error::Other(format!(
+            "Approval token {} does not match the current plan ({}): the spec or the deployment changed since it was reviewed; run imacs plan again",
+            token, plan.token
+        )));
+    }
+    if let Some(error) = &plan.version_error {
+        return Err(Error::Other(error.clone()));
+    }
+    if !plan.has_changes() {
+        return Ok(plan);
+    }
+
+    let dir = registry_dir.join(&plan.spec);
+    fs::create_dir_all(&dir).map_err(Error::Io)?;
+    if !plan.published {
+        let path = spec_path(&dir, &plan.release.version);
+        fs::write(&path, yaml).map_err(Error::Io)?;
+        let mut sidecar = path.into_os_string();
+        sidecar.push(".sha256");
+        fs::write(sidecar, format!("{}\n", plan.release.digest)).map_err(Error::Io)?;
+    }
+    let pending = dir.join(format!("{}.pending", DEPLOYED_FILE));
+    fs::write(&pending, format!("{}\n", plan.release.version)).map_err(Error::Io)?;
+    fs::rename(&pending, dir.join(DEPLOYED_FILE)).map_err(Error::Io)?;
+    Ok(plan)
+}
+
+fn spec_path(dir: &Path, version: &str) -> PathBuf {
+    dir.join(format!("{}.yaml", version))
+}
+
+/// Contents of a file, `None` if it does not exist
+fn read(path: &Path) -> Result<Option<String>> {
+    match fs::read_to_string(path) {
+        Ok(text) => Ok(Some(text)),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(None),
+        Err(e) => Err(Error::Io(e)),
+    }
+}
+
+/// Published digest of a version, `None` if it is not published
+fn published_digest(dir: &Path, version: &str) -> Result<Option<String>> {
+    let mut sidecar = spec_path(dir, version).into_os_string();
+    sidecar.push(".sha256");
+    Ok(read(Path::new(&sidecar))?.map(|text| {
+        text.split_whitespace()
+            .next()
+            .unwrap_or_default()
+            .to_string()
+    }))
+}
+
+/// A published version and its digest, verified against the sidecar
+fn load(dir: &Path, name: &str, version: &str) -> Result<(Spec, String)> {
+    let path = spec_path(dir, version);
+    let yaml = read(&path)?.ok_or_else(|| {
+        Error::Registry(format!(
+            "{}@{} is deployed but {} is missing",
+            name,
+            version,
+            path.display()
+        ))
+    })?;
+    let digest = sha256_hex(yaml.as_bytes());
+    if published_digest(dir, version)?.is_some_and(|published| published != digest) {
+        return Err(Error::Registry(format!(
+            "{}@{}: digest mismatch with the published sha256",
+            name, version
+        )));
+    }
+    Ok((Spec::from_yaml(&yaml)?, digest))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const V1: &str = r#"
+id: shipping_rate
+meta:
+  version: 1.0.0
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+default: 5.0
+"#;
+
+    fn v2() -> String {
+        V1.replace("1.0.0", "1.0.1")
+            .replace("weight_kg > 20.0", "weight_kg > 18.0")
+    }
+
+    #[test]
+    fn test_first_deployment() {
+        let registry = tempfile::tempdir().unwrap();
+        let plan = plan(V1, registry.path(), None).unwrap();
+        assert!(plan.deployed.is_none());
+        assert!(plan.has_changes());
+        assert!(plan
+            .to_report()
+            .contains("Plan: shipping_rate 1.0.0 (first deployment)"));
+
+        apply(V1, registry.path(), &plan.token).unwrap();
+        let dir = registry.path().join("shipping_rate");
+        assert_eq!(fs::read_to_string(dir.join("1.0.0.yaml")).unwrap(), V1);
+        assert_eq!(
+            fs::read_to_string(dir.join(DEPLOYED_FILE)).unwrap(),
+            "1.0.0\n"
+        );
+
+        let again = super::plan(V1, registry.path(), None).unwrap();
+        assert!(!again.has_changes());
+        assert!(again.to_report().contains("No changes"));
+    }
+
+    #[test]
+    fn test_plan_against_deployed() {
+        let registry = tempfile::tempdir().unwrap();
+        apply(
+            V1,
+            registry.path(),
+            &plan(V1, registry.path(), None).unwrap().token,
+        )
+        .unwrap();
+
+        let records = r#"{"spec":"shipping_rate","input":{"weight_kg":19.0},"output":5.0}
+{"spec":"shipping_rate","input":{"weight_kg":2.0},"output":5.0}
+"#;
+        let plan = plan(&v2(), registry.path(), Some(records)).unwrap();
+        assert_eq!(plan.deployed.as_ref().unwrap().version, "1.0.0");
+        assert!(plan.version_error.is_none());
+        assert!(!plan.compat.logic.is_empty());
+        let impact = plan.impact.as_ref().unwrap();
+        assert_eq!((impact.replayed, impact.changed), (2, 1));
+        assert_eq!(impact.by_rule["HEAVY"], 1);
+
+        let report = plan.to_report();
+        assert!(report.starts_with("Plan: shipping_rate 1.0.0 -> 1.0.1\n"));
+        assert!(report.contains("Impact: 1 of 2 recorded decision(s) change"));
+        assert!(report.contains(&format!("--approve {}", plan.token)));
+    }
+
+    #[test]
+    fn test_apply_needs_current_token() {
+        let registry = tempfile::tempdir().unwrap();
+        let first = plan(V1, registry.path(), None).unwrap();
+        let stale = plan(&v2(), registry.path(), None).unwrap();
+        apply(V1, registry.path(), &first.token).unwrap();
+
+        // v1 was deployed after v2 was planned
+        let err = apply(&v2(), registry.path(), &stale.token).unwrap_err();
+        assert!(err.to_string().contains("does not match the current plan"));
+
+        let current = plan(&v2(), registry.path(), None).unwrap();
+        assert_ne!(current.token, stale.token);
+        apply(&v2(), registry.path(), &current.token).unwrap();
+
+        // Rolling back only moves the pointer
+        let rollback = plan(V1, registry.path(), None).unwrap();
+        assert!(rollback.published);
+        apply(V1, registry.path(), &rollback.token).unwrap();
+        let deployed = registry.path().join("shipping_rate").join(DEPLOYED_FILE);
+        assert_eq!(fs::read_to_string(deployed).unwrap(), "1.0.0\n");
+    }
+
+    #[test]
+    fn test_published_versions_are_immutable() {
+        let registry = tempfile::tempdir().unwrap();
+        apply(
+            V1,
+            registry.path(),
+            &plan(V1, registry.path(), None).unwrap().token,
+        )
+        .unwrap();
+        let edited = V1.replace("then: 25.0", "then: 30.0");
+        let err = plan(&edited, registry.path(), None).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("already published with other content"));
+    }
+}
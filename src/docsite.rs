@@ -0,0 +1,549 @@
+//! Interactive HTML documentation (`imacs doc --format html`)
+//!
+//! One self-contained page per spec, for the people who own the rules
+//! rather than the code: inputs and outputs, a rule table that filters as
+//! you type, the evaluation order as a flow diagram, an example input for
+//! every rule with the decision it gets, and a "try it" form.
+//!
+//! The form decides in the browser with the rules compiled to JavaScript
+//! through the TypeScript target, so it answers exactly as the generated
+//! TypeScript does. Specs whose rules need lookup tables, exchange rates or
+//! custom functions get the page without the form.
+
+use crate::cel::Target;
+use crate::runtime::{self, Values};
+use crate::spec::{ConditionValue, Rule, Spec, VarType};
+use crate::templates::context::{OutputValueView, SpecContext};
+use crate::util::to_camel_case;
+use regex::Regex;
+use std::collections::BTreeMap;
+use std::fmt::Write;
+
+/// The documentation page of a spec
+pub fn site(spec: &Spec) -> String {
+    let title = spec.name.as_deref().unwrap_or(&spec.id);
+    let mut out = String::new();
+    let _ = writeln!(
+        out,
+        "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>{}</title>\n<style>{}</style>\n</head>\n<body>",
+        escape(title),
+        STYLE
+    );
+    let _ = writeln!(out, "<h1>{}</h1>", escape(title));
+    if let Some(description) = &spec.description {
+        let _ = writeln!(out, "<p>{}</p>", escape(description.trim()));
+    }
+    let _ = write!(
+        out,
+        "<p class=\"meta\">Spec <code>{}</code>, hash <code>{}</code>",
+        escape(&spec.id),
+        spec.hash()
+    );
+    if let Some(version) = &spec.meta.version {
+        let _ = write!(out, ", version {}", escape(version));
+    }
+    out.push_str("</p>\n");
+
+    let generated = spec.for_codegen();
+    let spec = generated.as_ref();
+    let rules = spec.evaluation_order();
+
+    for (title, vars) in [("Inputs", &spec.inputs), ("Outputs", &spec.outputs)] {
+        let _ = writeln!(
+            out,
+            "<h2>{}</h2>\n<table>\n<thead><tr><th>Name</th><th>Type</th><th>Description</th></tr></thead>\n<tbody>",
+            title
+        );
+        for var in vars {
+            let _ = writeln!(
+                out,
+                "<tr><td><code>{}</code></td><td>{}</td><td>{}</td></tr>",
+                escape(&var.name),
+                escape(&crate::compat::type_name(&var.typ)),
+                escape(var.description.as_deref().unwrap_or_default())
+            );
+        }
+        out.push_str("</tbody>\n</table>\n");
+    }
+
+    // The ownership column only appears once some rule has an owner
+    let owned = rules.iter().any(|r| r.ownership().is_some());
+    let _ = writeln!(
+        out,
+        "<h2>Rules</h2>\n<input id=\"search\" type=\"search\" placeholder=\"Filter rules\">\n\
+         <table id=\"rules\">\n<thead><tr><th>Rule</th><th>When</th><th>Then</th><th>Description</th>{}</tr></thead>\n<tbody>",
+        if owned { "<th>Ownership</th>" } else { "" }
+    );
+    for rule in &rules {
+        let ownership = match rule.ownership() {
+            Some(text) => format!("<td>{}</td>", escape(&text)),
+            None if owned => "<td></td>".to_string(),
+            None => String::new(),
+        };
+        let _ = writeln!(
+            out,
+            "<tr id=\"rule-{0}\"><td>{0}</td><td><code>{1}</code></td><td>{2}</td><td>{3}</td>{4}</tr>",
+            escape(&rule.id),
+            escape(&condition(rule)),
+            escape(&rule.then.to_string()),
+            escape(rule.description.as_deref().unwrap_or_default()),
+            ownership
+        );
+    }
+    out.push_str("</tbody>\n</table>\n");
+    if let Some(default) = &spec.default {
+        let _ = writeln!(
+            out,
+            "<p>When no rule matches: {}</p>",
+            escape(&default.to_string())
+        );
+    }
+
+    let _ = writeln!(out, "<h2>Flow</h2>\n{}", flow(spec, &rules));
+
+    out.push_str(
+        "<h2>Examples</h2>\n<table>\n<thead><tr><th>Input</th><th>Decided by</th><th>Result</th></tr></thead>\n<tbody>\n",
+    );
+    for rule in &rules {
+        let input = example(spec, rule);
+        let (decided, result) = match runtime::evaluate(spec, &values(&input)) {
+            Ok(evaluation) => (
+                evaluation
+                    .rule_id
+                    .map_or("default".to_string(), |id| format!("rule {}", id)),
+                show(&evaluation.outputs),
+            ),
+            Err(e) => ("error".to_string(), e.to_string()),
+        };
+        let _ = writeln!(
+            out,
+            "<tr><td><code>{}</code></td><td>{}</td><td>{}</td></tr>",
+            escape(&show_input(&input)),
+            escape(&decided),
+            escape(&result)
+        );
+    }
+    out.push_str("</tbody>\n</table>\n");
+
+    out.push_str("<h2>Try it</h2>\n");
+    match decide_js(spec) {
+        Some(decide) => {
+            let first = rules.first().map(|rule| example(spec, rule));
+            out.push_str("<form id=\"try\">\n");
+            for var in &spec.inputs {
+                let value = first.as_ref().and_then(|input| input.get(&var.name));
+                let _ = writeln!(
+                    out,
+                    "<label>{}{}</label>",
+                    escape(&var.name),
+                    field(&var.name, &to_camel_case(&var.name), &var.typ, value)
+                );
+            }
+            let _ = writeln!(
+                out,
+                "<button type=\"submit\">Decide</button>\n</form>\n<p id=\"result\"></p>\n<script>\n{}\n{}</script>",
+                decide.replace("</", "<\\/"),
+                TRY_SCRIPT
+            );
+        }
+        None => out.push_str(
+            "<p>Not available: the rules use lookup tables, exchange rates or custom functions, \
+             which only the generated code provides.</p>\n",
+        ),
+    }
+
+    let _ = writeln!(
+        out,
+        "<script>\n{}</script>\n</body>\n</html>",
+        SEARCH_SCRIPT
+    );
+    out
+}
+
+/// A rule's condition as written, `true` for a rule that always applies
+fn condition(rule: &Rule) -> String {
+    rule.as_cel().unwrap_or_else(|| "true".into())
+}
+
+/// The evaluation order as an SVG: each rule either decides or hands over
+/// to the next, down to the default
+fn flow(spec: &Spec, rules: &[&Rule]) -> String {
+    const STEP: usize = 60;
+    let height = STEP * (rules.len() + 1) + 10;
+    let mut svg = format!(
+        "<svg class=\"flow\" width=\"640\" height=\"{}\" viewBox=\"0 0 640 {}\" xmlns=\"http://www.w3.org/2000/svg\">\n",
+        height, height
+    );
+    for (i, rule) in rules.iter().enumerate() {
+        let y = 10 + i * STEP;
+        let when = format!("{}: {}", rule.id, condition(rule));
+        let then = rule.then.to_string();
+        let _ = writeln!(
+            svg,
+            "<g><title>{0}</title><rect x=\"10\" y=\"{1}\" width=\"360\" height=\"36\" rx=\"6\"/><text x=\"20\" y=\"{2}\">{3}</text></g>\n\
+             <line x1=\"370\" y1=\"{4}\" x2=\"420\" y2=\"{4}\"/><text class=\"edge\" x=\"380\" y=\"{5}\">yes</text>\n\
+             <g><title>{6}</title><rect class=\"out\" x=\"420\" y=\"{1}\" width=\"210\" height=\"36\" rx=\"6\"/><text x=\"430\" y=\"{2}\">{7}</text></g>\n\
+             <line x1=\"190\" y1=\"{8}\" x2=\"190\" y2=\"{9}\"/><text class=\"edge\" x=\"196\" y=\"{10}\">no</text>",
+            escape(&when),
+            y,
+            y + 23,
+            escape(&clip(&when, 48)),
+            y + 18,
+            y + 13,
+            escape(&then),
+            escape(&clip(&then, 26)),
+            y + 36,
+            y + STEP,
+            y + 50
+        );
+    }
+    let y = 10 + rules.len() * STEP;
+    let fallback = match &spec.default {
+        Some(default) => format!("Default: {}", default),
+        None => "No rule matched (error)".to_string(),
+    };
+    let _ = writeln!(
+        svg,
+        "<g><title>{0}</title><rect class=\"out\" x=\"10\" y=\"{1}\" width=\"360\" height=\"36\" rx=\"6\"/><text x=\"20\" y=\"{2}\">{3}</text></g>\n</svg>",
+        escape(&fallback),
+        y,
+        y + 23,
+        escape(&clip(&fallback, 48))
+    );
+    svg
+}
+
+/// `text` cut to `max` characters, marked with an ellipsis when cut
+fn clip(text: &str, max: usize) -> String {
+    if text.chars().count() <= max {
+        return text.to_string();
+    }
+    let mut clipped: String = text.chars().take(max - 1).collect();
+    clipped.push('…');
+    clipped
+}
+
+/// An input meant to reach `rule`, built from the values its condition
+/// compares against (inputs it does not mention take their type's zero)
+fn example(spec: &Spec, rule: &Rule) -> BTreeMap<String, ConditionValue> {
+    let mut literals = crate::testgen::extract_test_values(rule, &spec.inputs);
+    // Step over the bounds of numeric comparisons (`weight_kg > 20.0`)
+    let bound =
+        Regex::new(r"\b([A-Za-z_]\w*)\s*(>=|<=|>|<)\s*(-?\d+(?:\.\d+)?)").expect("valid regex");
+    for caps in bound.captures_iter(&condition(rule)) {
+        let Some(input) = spec.inputs.iter().find(|i| i.name == caps[1]) else {
+            continue;
+        };
+        let step = match &caps[2] {
+            ">" => 1.0,
+            "<" => -1.0,
+            _ => 0.0,
+        };
+        let Ok(at) = caps[3].parse::<f64>() else {
+            continue;
+        };
+        let literal = match input.typ {
+            VarType::Int => format!("{}", (at + step) as i64),
+            _ => format!("{:?}", at + step),
+        };
+        literals.insert(input.name.clone(), literal);
+    }
+    literals
+        .into_iter()
+        .filter_map(|(name, literal)| {
+            let value = serde_norway::from_str::<ConditionValue>(&literal).ok()?;
+            let typ = spec.inputs.iter().find(|i| i.name == name).map(|i| &i.typ);
+            match (value, typ) {
+                (ConditionValue::Null, _) => None,
+                (ConditionValue::Int(i), Some(VarType::Float)) => {
+                    Some((name, ConditionValue::Float(i as f64)))
+                }
+                (value, _) => Some((name, value)),
+            }
+        })
+        .collect()
+}
+
+fn values(input: &BTreeMap<String, ConditionValue>) -> Values {
+    input
+        .iter()
+        .map(|(name, value)| (name.clone(), runtime::to_cel_value(value)))
+        .collect()
+}
+
+/// An input as `name=value, ...`
+fn show_input(input: &BTreeMap<String, ConditionValue>) -> String {
+    input
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, value))
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+/// Outputs as `value` for one, `name=value, ...` (sorted) for several
+fn show(outputs: &Values) -> String {
+    let outputs: BTreeMap<_, _> = outputs
+        .iter()
+        .map(|(name, value)| (name, runtime::from_cel_value(value)))
+        .collect();
+    if outputs.len() == 1 {
+        return outputs.values().map(|v| v.to_string()).collect();
+    }
+    outputs
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, value))
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+/// The form field of an input, prefilled with `value`
+fn field(name: &str, key: &str, typ: &VarType, value: Option<&ConditionValue>) -> String {
+    let attrs = format!(
+        "name=\"{}\" data-key=\"{}\" data-type=\"{}\"",
+        escape(name),
+        escape(key),
+        match typ {
+            VarType::String | VarType::Enum(_) => "string",
+            _ => "json",
+        }
+    );
+    match (typ, value) {
+        (VarType::Bool, value) => format!(
+            "<input type=\"checkbox\" {}{}>",
+            attrs,
+            if value == Some(&ConditionValue::Bool(true)) {
+                " checked"
+            } else {
+                ""
+            }
+        ),
+        (VarType::Enum(variants), value) => {
+            let mut select = format!("<select {}>", attrs);
+            for variant in variants {
+                let selected = value == Some(&ConditionValue::String(variant.clone()));
+                let _ = write!(
+                    select,
+                    "<option{}>{}</option>",
+                    if selected { " selected" } else { "" },
+                    escape(variant)
+                );
+            }
+            select.push_str("</select>");
+            select
+        }
+        (_, value) => {
+            let text = match value {
+                Some(ConditionValue::String(s)) => s.clone(),
+                Some(ConditionValue::List(_) | ConditionValue::Map(_)) => {
+                    serde_json::to_string(value).unwrap_or_default()
+                }
+                Some(scalar) => scalar.to_string(),
+                None => String::new(),
+            };
+            format!("<input {} value=\"{}\">", attrs, escape(&text))
+        }
+    }
+}
+
+/// `decide(input)`, returning the deciding rule (null for the default) and
+/// the output, as JavaScript; `None` when the rules need helpers the page
+/// cannot provide
+fn decide_js(spec: &Spec) -> Option<String> {
+    let ctx = SpecContext::from_spec(spec, Target::TypeScript, false);
+    if ctx.uses_rates || !ctx.tables.is_empty() || !spec.functions.is_empty() {
+        return None;
+    }
+    // Aggregates cast list items for the type checker, which JavaScript
+    // does without
+    let js = |ts: &str| ts.replace(" as number)", ")");
+
+    let mut out = format!(
+        "function decide(input) {{\n    const {{ {} }} = input;\n",
+        ctx.inputs
+            .iter()
+            .map(|input| match &input.default {
+                Some(default) => format!("{} = {}", input.name_camel, js(&default.ts)),
+                None => input.name_camel.clone(),
+            })
+            .collect::<Vec<_>>()
+            .join(", ")
+    );
+    for rule in &ctx.rules {
+        let _ = writeln!(out, "    if ({}) {{", js(&rule.condition_ts));
+        for var in &rule.vars {
+            let _ = writeln!(
+                out,
+                "        const {} = {};",
+                var.name_camel,
+                js(&var.value.ts)
+            );
+        }
+        let _ = writeln!(
+            out,
+            "        return [{:?}, {}];\n    }}",
+            rule.id,
+            output_js(&rule.output, &js)
+        );
+    }
+    match &ctx.default {
+        Some(default) => {
+            let _ = writeln!(out, "    return [null, {}];", output_js(default, &js));
+        }
+        None => out.push_str("    throw new Error(\"No rule matched\");\n"),
+    }
+    out.push_str("}\n");
+    Some(out)
+}
+
+fn output_js(output: &OutputValueView, js: &dyn Fn(&str) -> String) -> String {
+    match &output.named {
+        Some(named) => {
+            let sorted: BTreeMap<_, _> = named.iter().collect();
+            format!(
+                "{{ {} }}",
+                sorted
+                    .iter()
+                    .map(|(name, value)| format!("{:?}: {}", name, js(&value.ts)))
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            )
+        }
+        None => js(&output.ts),
+    }
+}
+
+/// Text safe inside HTML elements and attribute values
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
+
+const STYLE: &str = "
+body { font-family: system-ui, sans-serif; max-width: 960px; margin: 2em auto; padding: 0 1em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.meta { color: #666; }
+#search { width: 100%; padding: 6px; margin-bottom: 8px; }
+.flow rect { fill: #eef3fb; stroke: #5b7db1; }
+.flow rect.out { fill: #eefaf0; stroke: #4f9a5d; }
+.flow line { stroke: #888; }
+.flow text { font-size: 12px; font-family: monospace; }
+.flow text.edge { fill: #888; }
+#try label { display: block; margin-bottom: 6px; }
+#try input, #try select { margin-left: 8px; }
+#result { font-weight: bold; }
+";
+
+const SEARCH_SCRIPT: &str = r##"document.getElementById("search").addEventListener("input", function (event) {
+    const query = event.target.value.toLowerCase();
+    for (const row of document.querySelectorAll("#rules tbody tr")) {
+        row.hidden = query !== "" && !row.textContent.toLowerCase().includes(query);
+    }
+});
+"##;
+
+const TRY_SCRIPT: &str = r#"document.getElementById("try").addEventListener("submit", function (event) {
+    event.preventDefault();
+    const input = {};
+    for (const field of event.target.elements) {
+        if (!field.name) continue;
+        if (field.type === "checkbox") {
+            input[field.dataset.key] = field.checked;
+        } else if (field.value === "") {
+            continue;
+        } else if (field.dataset.type === "string") {
+            input[field.dataset.key] = field.value;
+        } else {
+            try {
+                input[field.dataset.key] = JSON.parse(field.value);
+            } catch (err) {
+                input[field.dataset.key] = field.value;
+            }
+        }
+    }
+    const result = document.getElementById("result");
+    try {
+        const [rule, output] = decide(input);
+        result.textContent = (rule === null ? "Default" : "Rule " + rule) + ": " + JSON.stringify(output);
+    } catch (err) {
+        result.textContent = "Error: " + err.message;
+    }
+});
+"#;
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+name: Shipping rate
+inputs:
+  - name: weight_kg
+    type: float
+    description: Parcel weight
+  - name: zone
+    type: !enum [domestic, eu]
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+    description: Heavy <bulky> parcels
+  - id: EU
+    when: "zone == 'eu'"
+    then: 9.5
+default: 5.0
+"#;
+
+    #[test]
+    fn test_site() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let html = site(&spec);
+        assert!(html.starts_with("<!DOCTYPE html>"));
+        assert!(html.contains("<h1>Shipping rate</h1>"));
+        assert!(html.contains(
+            "<tr id=\"rule-HEAVY\"><td>HEAVY</td><td><code>weight_kg &gt; 20.0</code></td>"
+        ));
+        assert!(html.contains("Heavy &lt;bulky&gt; parcels"));
+        assert!(!html.contains("<th>Ownership</th>"));
+
+        let mut owned = spec.clone();
+        owned.rules[0].owner = Some("pricing <team>".into());
+        let html = site(&owned);
+        assert!(html.contains("<th>Description</th><th>Ownership</th></tr>"));
+        assert!(html.contains("<td>owner pricing &lt;team&gt;</td></tr>"));
+        assert!(html.contains("<td></td></tr>"));
+        assert!(html.contains("<svg class=\"flow\""));
+        assert!(html.contains("Default: 5"));
+        assert!(html.contains(
+            "<tr><td><code>weight_kg=21, zone=&quot;domestic&quot;</code></td><td>rule HEAVY</td><td>25</td></tr>"
+        ));
+        assert!(html.contains("<td>rule EU</td><td>9.5</td>"));
+        assert!(html.contains("value=\"21\""));
+        assert!(html.contains("<option selected>domestic</option>"));
+        assert!(html.contains("function decide(input) {"));
+        assert!(html.contains("return [\"HEAVY\", 25.0];"));
+        assert!(html.contains("return [null, 5.0];"));
+    }
+
+    #[test]
+    fn test_site_without_try_it() {
+        let mut spec = Spec::from_yaml(SPEC).unwrap();
+        spec.functions = vec![crate::spec::CustomFunction {
+            name: "risk".into(),
+            params: vec![VarType::Float],
+            returns: VarType::Float,
+            go: "example.com/risk.Score".into(),
+        }];
+        let html = site(&spec);
+        assert!(!html.contains("function decide"));
+        assert!(html.contains("<p>Not available:"));
+        assert!(html.contains("id=\"search\""));
+    }
+}
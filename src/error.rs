@@ -38,6 +38,12 @@ pub enum Error {
     #[error("JSON error: {0}")]
     Json(#[from] serde_json::Error),
 
+    #[error("Registry error: {0}")]
+    Registry(String),
+
+    #[error("Signature error: {0}")]
+    Signature(String),
+
     #[error("{0}")]
     Other(String),
 }
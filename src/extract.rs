@@ -88,6 +88,20 @@ impl Extractor {
                     default: None,
                     meta: SpecMeta::default(),
                     scoping: None,
+                    codegen: Default::default(),
+                    constraints: Vec::new(),
+                    postconditions: Vec::new(),
+                    resolution: Default::default(),
+                    cache: None,
+                    normalize: Vec::new(),
+                    assertions: Vec::new(),
+                    tables: Vec::new(),
+                    zones: Vec::new(),
+                    constants: Vec::new(),
+                    extends: None,
+                    extension_points: Vec::new(),
+                    functions: Vec::new(),
+                    source: None,
                 },
                 confidence: Confidence {
                     overall: 0.0,
@@ -118,6 +132,13 @@ impl Extractor {
                 typ: self.infer_type(&p.typ),
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             })
             .collect();
 
@@ -140,6 +161,13 @@ impl Extractor {
             typ: output_type,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }];
 
         // Generate questions
@@ -175,6 +203,20 @@ impl Extractor {
                 default: None,
                 meta: SpecMeta::default(),
                 scoping: None,
+                codegen: Default::default(),
+                constraints: Vec::new(),
+                postconditions: Vec::new(),
+                resolution: Default::default(),
+                cache: None,
+                normalize: Vec::new(),
+                assertions: Vec::new(),
+                tables: Vec::new(),
+                zones: Vec::new(),
+                constants: Vec::new(),
+                extends: None,
+                extension_points: Vec::new(),
+                functions: Vec::new(),
+                source: None,
             },
             confidence: Confidence {
                 overall: overall_confidence,
@@ -219,6 +261,14 @@ impl Extractor {
                                 then: Output::Single(output),
                                 priority: *counter as i32,
                                 description: Some("Default case".into()),
+                                vars: Vec::new(),
+                                tags: Vec::new(),
+                                line: None,
+                                reason_code: None,
+                                message_key: None,
+                                state: RuleState::Active,
+                                owner: None,
+                                approved_by: None,
                             });
                             confidences.push(RuleConfidence {
                                 rule_id,
@@ -240,6 +290,14 @@ impl Extractor {
                             then: Output::Single(output),
                             priority: *counter as i32,
                             description: None,
+                            vars: Vec::new(),
+                            tags: Vec::new(),
+                            line: None,
+                            reason_code: None,
+                            message_key: None,
+                            state: RuleState::Active,
+                            owner: None,
+                            approved_by: None,
                         });
                         confidences.push(RuleConfidence {
                             rule_id,
@@ -274,6 +332,14 @@ impl Extractor {
                         then: Output::Single(output),
                         priority: *counter as i32,
                         description: None,
+                        vars: Vec::new(),
+                        tags: Vec::new(),
+                        line: None,
+                        reason_code: None,
+                        message_key: None,
+                        state: RuleState::Active,
+                        owner: None,
+                        approved_by: None,
                     });
                     confidences.push(RuleConfidence {
                         rule_id,
@@ -340,6 +406,14 @@ impl Extractor {
                             then: Output::Single(output),
                             priority: *counter as i32,
                             description: None,
+                            vars: Vec::new(),
+                            tags: Vec::new(),
+                            line: None,
+                            reason_code: None,
+                            message_key: None,
+                            state: RuleState::Active,
+                            owner: None,
+                            approved_by: None,
                         });
                         confidences.push(RuleConfidence {
                             rule_id,
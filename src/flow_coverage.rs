@@ -0,0 +1,725 @@
+//! Coverage-driven flow scenarios (`imacs scenarios`)
+//!
+//! Generates flow scenarios meeting declared coverage criteria: every gate
+//! passes and fails at least once, every branch case (and the default) is
+//! taken. Each target is reached along one of the paths of
+//! [`crate::completeness::enumerate_flow_paths`], whose conditions go to
+//! the SMT solver of [`crate::prove`] with the flow inputs and the outputs
+//! of the called specs as unknowns. The called specs are mocked with the
+//! solved outputs, as in declared scenarios, so the generated tests
+//! exercise the flow wiring. Targets no scenario reaches are reported with
+//! the reason: contradicting conditions, a condition with no SMT
+//! translation (computed values, approvals) or a solver that gave up.
+
+use crate::cel::CelCompiler;
+use crate::completeness::{enumerate_flow_paths, ConditionPath, MAX_PATHS};
+use crate::error::Result;
+use crate::orchestrate::{CallStep, ChainStep, Orchestrator, Scenario};
+use crate::prove::{
+    condition, first_line, one_of, run_solver, solver_command, symbol, Env, Problem, Sort,
+};
+use crate::runtime::{to_cel_value, Values};
+use crate::spec::{ConditionValue, Spec, VarType};
+use crate::synthesize::read_model;
+use serde::Serialize;
+use std::collections::{BTreeMap, HashMap};
+use std::fmt::Write;
+
+/// A coverage criterion
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+pub enum Criterion {
+    /// Every gate passes and fails at least once
+    Gates,
+    /// Every branch case, and the default, is taken
+    Branches,
+}
+
+impl std::fmt::Display for Criterion {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Criterion::Gates => write!(f, "gates"),
+            Criterion::Branches => write!(f, "branches"),
+        }
+    }
+}
+
+/// Something a criterion requires some scenario to do
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+#[serde(tag = "kind", rename_all = "snake_case")]
+pub enum Target {
+    GatePasses {
+        gate: String,
+    },
+    GateFails {
+        gate: String,
+    },
+    /// Take a branch case, `default` for the default
+    Branch {
+        branch: String,
+        case: String,
+    },
+}
+
+impl std::fmt::Display for Target {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Target::GatePasses { gate } => write!(f, "gate {} passes", gate),
+            Target::GateFails { gate } => write!(f, "gate {} fails", gate),
+            Target::Branch { branch, case } => write!(f, "branch {} takes {}", branch, case),
+        }
+    }
+}
+
+impl Target {
+    /// Whether a flow path does what the target requires
+    fn reached_by(&self, path: &ConditionPath) -> bool {
+        match self {
+            Target::GatePasses { gate } => {
+                path.steps.contains(gate) && path.outcome != format!("fails at gate {}", gate)
+            }
+            Target::GateFails { gate } => path.outcome == format!("fails at gate {}", gate),
+            Target::Branch { branch, case } => {
+                path.steps.contains(&format!("{}: {}", branch, case))
+            }
+        }
+    }
+
+    /// Name of the scenario generated for the target, usable in test names
+    fn scenario_name(&self) -> String {
+        let name = match self {
+            Target::GatePasses { gate } => format!("{} passes", gate),
+            Target::GateFails { gate } => format!("{} fails", gate),
+            Target::Branch { branch, case } => format!("{} {}", branch, case),
+        };
+        name.chars()
+            .map(|c| {
+                if c.is_ascii_alphanumeric() || c == ' ' || c == '_' {
+                    c
+                } else {
+                    '_'
+                }
+            })
+            .collect()
+    }
+}
+
+/// A target and the scenario reaching it
+#[derive(Debug, Clone, Serialize)]
+pub struct Covered {
+    pub target: Target,
+    pub scenario: String,
+}
+
+/// A target no scenario reaches, and why
+#[derive(Debug, Clone, Serialize)]
+pub struct Uncovered {
+    pub target: Target,
+    pub reason: String,
+}
+
+/// Scenarios generated for a flow and the targets they reach
+#[derive(Debug, Clone, Serialize)]
+pub struct FlowCoverage {
+    pub flow: String,
+    pub criteria: Vec<Criterion>,
+    pub scenarios: Vec<Scenario>,
+    pub covered: Vec<Covered>,
+    pub uncovered: Vec<Uncovered>,
+}
+
+impl FlowCoverage {
+    /// Format as human-readable report
+    pub fn to_report(&self) -> String {
+        let criteria: Vec<String> = self.criteria.iter().map(|c| c.to_string()).collect();
+        let mut out = format!(
+            "Flow coverage of {} ({}): {} of {} targets in {} scenario(s)\n",
+            self.flow,
+            criteria.join(", "),
+            self.covered.len(),
+            self.covered.len() + self.uncovered.len(),
+            self.scenarios.len()
+        );
+        for covered in &self.covered {
+            let _ = writeln!(out, "  ✓ {} ({})", covered.target, covered.scenario);
+        }
+        for uncovered in &self.uncovered {
+            let _ = writeln!(out, "  ✗ {}: {}", uncovered.target, uncovered.reason);
+        }
+        out
+    }
+}
+
+/// The targets of `criteria` in a flow, in chain order
+pub fn targets(orch: &Orchestrator, criteria: &[Criterion]) -> Vec<Target> {
+    let mut targets = Vec::new();
+    collect_targets(&orch.chain, criteria, &mut targets);
+    targets
+}
+
+fn collect_targets(steps: &[ChainStep], criteria: &[Criterion], targets: &mut Vec<Target>) {
+    for step in steps {
+        match step {
+            ChainStep::Gate(gate) if criteria.contains(&Criterion::Gates) => {
+                targets.push(Target::GatePasses {
+                    gate: gate.id.clone(),
+                });
+                targets.push(Target::GateFails {
+                    gate: gate.id.clone(),
+                });
+            }
+            ChainStep::Branch(branch) => {
+                let mut cases: Vec<_> = branch.cases.iter().collect();
+                cases.sort_by(|a, b| a.0.cmp(b.0));
+                let default = ("default".to_string(), branch.default.as_deref());
+                let arms = cases
+                    .into_iter()
+                    .map(|(case, steps)| (case.clone(), Some(steps.as_slice())))
+                    .chain(std::iter::once(default));
+                for (case, steps) in arms {
+                    if criteria.contains(&Criterion::Branches) {
+                        targets.push(Target::Branch {
+                            branch: branch.id.clone(),
+                            case,
+                        });
+                    }
+                    collect_targets(steps.unwrap_or_default(), criteria, targets);
+                }
+            }
+            ChainStep::Parallel(p) => collect_targets(&p.steps, criteria, targets),
+            ChainStep::Loop(l) => collect_targets(&l.steps, criteria, targets),
+            ChainStep::ForEach(f) => collect_targets(&f.steps, criteria, targets),
+            ChainStep::Try(t) => {
+                collect_targets(&t.try_steps, criteria, targets);
+                if let Some(catch) = &t.catch {
+                    collect_targets(&catch.steps, criteria, targets);
+                }
+                if let Some(finally) = &t.finally {
+                    collect_targets(finally, criteria, targets);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+/// Generate scenarios reaching the targets of `criteria` with the
+/// configured solver; `specs` holds the called specs by ID
+pub fn cover(
+    orch: &Orchestrator,
+    specs: &HashMap<String, Spec>,
+    criteria: &[Criterion],
+) -> Result<FlowCoverage> {
+    let command = solver_command();
+    cover_with(orch, specs, criteria, &mut |script| {
+        run_solver(&command, script)
+    })
+}
+
+/// Generate scenarios reaching the targets of `criteria`, passing each
+/// SMT-LIB script to `solve` and reading back the solver's output
+pub fn cover_with(
+    orch: &Orchestrator,
+    specs: &HashMap<String, Spec>,
+    criteria: &[Criterion],
+    solve: &mut dyn FnMut(&str) -> Result<String>,
+) -> Result<FlowCoverage> {
+    let report = enumerate_flow_paths(orch);
+    let unknowns = Unknowns::of(orch, specs);
+    let gates: Vec<String> = targets(orch, &[Criterion::Gates])
+        .into_iter()
+        .filter_map(|target| match target {
+            Target::GatePasses { gate } => Some(gate),
+            _ => None,
+        })
+        .collect();
+
+    let targets = targets(orch, criteria);
+    let mut attempts: HashMap<usize, std::result::Result<Solution, String>> = HashMap::new();
+    let mut coverage = FlowCoverage {
+        flow: orch.id.clone(),
+        criteria: criteria.to_vec(),
+        scenarios: Vec::new(),
+        covered: Vec::new(),
+        uncovered: Vec::new(),
+    };
+    for target in &targets {
+        if coverage.covered.iter().any(|c| &c.target == target) {
+            continue;
+        }
+        let mut reasons = Vec::new();
+        for (i, path) in report.paths.iter().enumerate() {
+            if !target.reached_by(path) {
+                continue;
+            }
+            if !attempts.contains_key(&i) {
+                let attempt = solve_path(path, &unknowns, solve)?;
+                attempts.insert(i, attempt);
+            }
+            let solution = match &attempts[&i] {
+                Ok(solution) => solution,
+                Err(reason) => {
+                    reasons.push(reason.clone());
+                    continue;
+                }
+            };
+
+            let name = target.scenario_name();
+            let reached: Vec<&Target> = targets
+                .iter()
+                .filter(|t| t.reached_by(path))
+                .filter(|t| !coverage.covered.iter().any(|c| &c.target == *t))
+                .collect();
+            coverage.scenarios.push(Scenario {
+                name: name.clone(),
+                description: Some(format!(
+                    "Covers {}",
+                    reached
+                        .iter()
+                        .map(|t| t.to_string())
+                        .collect::<Vec<_>>()
+                        .join(", ")
+                )),
+                inputs: solution.inputs.clone(),
+                steps: solution
+                    .steps
+                    .iter()
+                    .filter(|(step, _)| path.steps.contains(step))
+                    .map(|(step, outputs)| (step.clone(), outputs.clone()))
+                    .collect(),
+                gates: gates
+                    .iter()
+                    .filter(|gate| path.steps.contains(gate))
+                    .map(|gate| {
+                        let fails = path.outcome == format!("fails at gate {}", gate);
+                        (gate.clone(), !fails)
+                    })
+                    .collect(),
+                outputs: BTreeMap::new(),
+            });
+            for target in reached {
+                coverage.covered.push(Covered {
+                    target: target.clone(),
+                    scenario: name.clone(),
+                });
+            }
+            break;
+        }
+        if coverage.covered.iter().any(|c| &c.target == target) {
+            continue;
+        }
+
+        let reason = match reasons.len() {
+            0 if report.infeasible.iter().any(|p| target.reached_by(p)) => {
+                "every path to it has contradicting conditions".to_string()
+            }
+            0 if report.truncated => format!("no path within the first {} reaches it", MAX_PATHS),
+            0 => "no path reaches it".to_string(),
+            1 => reasons.remove(0),
+            n => format!("{} (and {} other path(s))", reasons[0], n - 1),
+        };
+        coverage.uncovered.push(Uncovered {
+            target: target.clone(),
+            reason,
+        });
+    }
+    Ok(coverage)
+}
+
+/// Values solved for a path
+#[derive(Debug, Clone)]
+struct Solution {
+    inputs: BTreeMap<String, ConditionValue>,
+    /// Outputs of the called specs, by step ID
+    steps: BTreeMap<String, BTreeMap<String, ConditionValue>>,
+}
+
+/// The unknowns of a flow: its inputs and the outputs of the steps calling
+/// a known spec (`step.output`)
+struct Unknowns {
+    lines: Vec<String>,
+    env: Env,
+    sorts: HashMap<String, Sort>,
+    symbols: Vec<String>,
+}
+
+impl Unknowns {
+    fn of(orch: &Orchestrator, specs: &HashMap<String, Spec>) -> Self {
+        let mut unknowns = Unknowns {
+            lines: vec!["(set-logic ALL)".to_string()],
+            env: Env::new(),
+            sorts: HashMap::new(),
+            symbols: Vec::new(),
+        };
+        for input in &orch.inputs {
+            unknowns.declare(&input.name, &input.var_type, None);
+        }
+        let mut calls = Vec::new();
+        collect_calls(&orch.chain, &mut calls);
+        for call in calls {
+            let Some(spec) = specs.get(&call.spec) else {
+                continue;
+            };
+            for output in &spec.outputs {
+                let symbol = format!("{}.{}", call.id, output.name);
+                unknowns.declare(&symbol, &output.typ, output.values.as_deref());
+            }
+        }
+        unknowns
+    }
+
+    /// Declare a name, limited to its enum or listed values; lists and
+    /// objects stay undeclared, so conditions on them have no translation
+    fn declare(&mut self, name: &str, typ: &VarType, values: Option<&[String]>) {
+        let Some(sort) = Sort::of(typ) else {
+            return;
+        };
+        let symbol = symbol(name);
+        self.lines
+            .push(format!("(declare-const {} {})", symbol, sort.name()));
+        let values = match typ {
+            VarType::Enum(values) => Some(values.as_slice()),
+            _ => values,
+        };
+        if let Some(values) = values {
+            self.lines
+                .push(format!("(assert {})", one_of(&symbol, values)));
+        }
+        self.env.insert(name.to_string(), (symbol.clone(), sort));
+        self.sorts.insert(name.to_string(), sort);
+        self.symbols.push(symbol);
+    }
+}
+
+fn collect_calls<'a>(steps: &'a [ChainStep], calls: &mut Vec<&'a CallStep>) {
+    for step in steps {
+        match step {
+            ChainStep::Call(call) => calls.push(call),
+            ChainStep::Branch(branch) => {
+                for steps in branch.cases.values() {
+                    collect_calls(steps, calls);
+                }
+                if let Some(default) = &branch.default {
+                    collect_calls(default, calls);
+                }
+            }
+            ChainStep::Parallel(p) => collect_calls(&p.steps, calls),
+            ChainStep::Loop(l) => collect_calls(&l.steps, calls),
+            ChainStep::ForEach(f) => collect_calls(&f.steps, calls),
+            ChainStep::Try(t) => {
+                collect_calls(&t.try_steps, calls);
+                if let Some(catch) = &t.catch {
+                    collect_calls(&catch.steps, calls);
+                }
+                if let Some(finally) = &t.finally {
+                    collect_calls(finally, calls);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+/// Solve the conditions of a path; `Err` explains why no scenario follows
+/// it
+fn solve_path(
+    path: &ConditionPath,
+    unknowns: &Unknowns,
+    solve: &mut dyn FnMut(&str) -> Result<String>,
+) -> Result<std::result::Result<Solution, String>> {
+    let mut lines = unknowns.lines.clone();
+    for cel in &path.conditions {
+        match condition(cel, &unknowns.env) {
+            Ok(term) => lines.push(format!("(assert {})", term)),
+            Err(reason) => return Ok(Err(format!("`{}` has no SMT translation: {}", cel, reason))),
+        }
+    }
+    let problem = Problem {
+        script: lines.iter().map(|line| format!("{}\n", line)).collect(),
+        symbols: unknowns.symbols.clone(),
+    };
+    let script = if problem.symbols.is_empty() {
+        problem.check()
+    } else {
+        problem.model()
+    };
+
+    let output = solve(&script)?;
+    let conditions = if path.conditions.is_empty() {
+        "true".to_string()
+    } else {
+        path.conditions.join(" && ")
+    };
+    let values = match first_line(&output) {
+        "sat" => read_model(
+            output.trim_start().trim_start_matches("sat"),
+            &unknowns.sorts,
+        )?,
+        "unsat" => return Ok(Err(format!("no valid input satisfies `{}`", conditions))),
+        "unknown" => return Ok(Err(format!("the solver gave up on `{}`", conditions))),
+        other => {
+            return Err(format!(
+                "Unexpected SMT solver output for `{}`: {}",
+                conditions, other
+            )
+            .into())
+        }
+    };
+
+    let mut solution = Solution {
+        inputs: BTreeMap::new(),
+        steps: BTreeMap::new(),
+    };
+    for (symbol, value) in values {
+        match symbol.split_once('.') {
+            Some((step, output)) => {
+                solution
+                    .steps
+                    .entry(step.to_string())
+                    .or_default()
+                    .insert(output.to_string(), value);
+            }
+            None => {
+                solution.inputs.insert(symbol, value);
+            }
+        }
+    }
+
+    // Evaluate the conditions on the solution, so a gap between the SMT
+    // translation and CEL leaves the target uncovered rather than
+    // generating a wrong scenario
+    let mut scope: Values = solution
+        .inputs
+        .iter()
+        .map(|(name, value)| (name.clone(), to_cel_value(value)))
+        .collect();
+    for (step, outputs) in &solution.steps {
+        let outputs = outputs
+            .iter()
+            .map(|(name, value)| (name.clone(), value.clone()))
+            .collect();
+        scope.insert(step.clone(), to_cel_value(&ConditionValue::Map(outputs)));
+    }
+    for cel in &path.conditions {
+        if !CelCompiler::eval_bool(cel, &scope).unwrap_or(false) {
+            return Ok(Err(format!(
+                "the solved values do not satisfy `{}` when evaluated",
+                cel
+            )));
+        }
+    }
+    Ok(Ok(solution))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const FLOW: &str = r#"
+id: checkout
+inputs:
+  - name: amount
+    type: int
+  - name: country
+    type: string
+uses:
+  - risk_score
+chain:
+  - step: call
+    id: risk
+    spec: risk_score
+    inputs:
+      amount: amount
+  - step: gate
+    id: low_risk
+    condition: "risk.score < 50"
+  - step: branch
+    id: region
+    on: country
+    cases:
+      US:
+        - step: gate
+          id: us_limit
+          condition: "amount <= 1000"
+      DE:
+        - step: compute
+          id: vat
+          name: vat
+          expr: "amount * 0.19"
+        - step: gate
+          id: vat_cap
+          condition: "vat < 100.0"
+"#;
+
+    const RISK: &str = r#"
+id: risk_score
+inputs:
+  - name: amount
+    type: int
+outputs:
+  - name: score
+    type: int
+rules:
+  - id: LARGE
+    when: "amount > 5000"
+    then: 80
+default: 10
+"#;
+
+    fn flow() -> Orchestrator {
+        Orchestrator::from_yaml(FLOW).unwrap()
+    }
+
+    fn specs() -> HashMap<String, Spec> {
+        let spec = Spec::from_yaml(RISK).unwrap();
+        HashMap::from([(spec.id.clone(), spec)])
+    }
+
+    /// Answers the checkout paths as a solver would
+    fn solver(script: &str) -> Result<String> {
+        let risky =
+            script.contains("(>= |risk.score| 50)") || script.contains("(not (< |risk.score| 50))");
+        let large =
+            script.contains("(> |amount| 1000)") || script.contains("(not (<= |amount| 1000))");
+        let country = if script.contains("(assert (= |country| \"US\"))") {
+            "US"
+        } else {
+            "FR"
+        };
+        Ok(format!(
+            "sat\n((|amount| {}) (|country| \"{}\") (|risk.score| {}))\n",
+            if large { 2000 } else { 5 },
+            country,
+            if risky { 60 } else { 10 }
+        ))
+    }
+
+    #[test]
+    fn test_targets() {
+        let all = targets(&flow(), &[Criterion::Gates, Criterion::Branches]);
+        let names: Vec<String> = all.iter().map(|t| t.to_string()).collect();
+        assert_eq!(
+            names,
+            vec![
+                "gate low_risk passes",
+                "gate low_risk fails",
+                "branch region takes DE",
+                "gate vat_cap passes",
+                "gate vat_cap fails",
+                "branch region takes US",
+                "gate us_limit passes",
+                "gate us_limit fails",
+                "branch region takes default",
+            ]
+        );
+        assert_eq!(targets(&flow(), &[Criterion::Branches]).len(), 3);
+    }
+
+    #[test]
+    fn test_cover() {
+        let coverage = cover_with(
+            &flow(),
+            &specs(),
+            &[Criterion::Gates, Criterion::Branches],
+            &mut solver,
+        )
+        .unwrap();
+        let names: Vec<&str> = coverage.scenarios.iter().map(|s| s.name.as_str()).collect();
+        assert_eq!(
+            names,
+            vec![
+                "low_risk passes",
+                "low_risk fails",
+                "us_limit fails",
+                "region default"
+            ]
+        );
+
+        let passes = &coverage.scenarios[0];
+        assert_eq!(
+            passes.inputs["country"],
+            ConditionValue::String("US".into())
+        );
+        assert_eq!(passes.steps["risk"]["score"], ConditionValue::Int(10));
+        assert_eq!(
+            passes.gates,
+            BTreeMap::from([
+                ("low_risk".to_string(), true),
+                ("us_limit".to_string(), true)
+            ])
+        );
+        let fails = &coverage.scenarios[1];
+        assert_eq!(fails.steps["risk"]["score"], ConditionValue::Int(60));
+        assert_eq!(
+            fails.gates,
+            BTreeMap::from([("low_risk".to_string(), false)])
+        );
+        assert_eq!(
+            coverage.scenarios[2].inputs["amount"],
+            ConditionValue::Int(2000)
+        );
+
+        // The DE arm gates on a computed value, which the solver cannot see
+        assert_eq!(coverage.covered.len(), 6);
+        let uncovered: Vec<String> = coverage
+            .uncovered
+            .iter()
+            .map(|u| u.target.to_string())
+            .collect();
+        assert_eq!(
+            uncovered,
+            vec![
+                "branch region takes DE",
+                "gate vat_cap passes",
+                "gate vat_cap fails"
+            ]
+        );
+        assert!(coverage.uncovered[0]
+            .reason
+            .contains("has no SMT translation: unknown name 'vat'"));
+
+        let report = coverage.to_report();
+        assert!(report.starts_with(
+            "Flow coverage of checkout (gates, branches): 6 of 9 targets in 4 scenario(s)\n"
+        ));
+        assert!(report.contains("  ✓ branch region takes US (low_risk passes)\n"));
+    }
+
+    #[test]
+    fn test_uncovered_reasons() {
+        // Without the called spec, gates on its output have no translation
+        let coverage =
+            cover_with(&flow(), &HashMap::new(), &[Criterion::Gates], &mut solver).unwrap();
+        assert!(coverage.scenarios.is_empty());
+        assert!(coverage.uncovered[1]
+            .reason
+            .contains("unknown name 'risk.score'"));
+
+        let unsat = cover_with(&flow(), &specs(), &[Criterion::Gates], &mut |_| {
+            Ok("unsat\n".into())
+        })
+        .unwrap();
+        assert!(unsat.uncovered[1]
+            .reason
+            .starts_with("no valid input satisfies `risk.score"));
+    }
+
+    #[test]
+    fn test_cover_with_z3() {
+        if std::process::Command::new("z3")
+            .arg("-version")
+            .output()
+            .is_err()
+        {
+            return;
+        }
+        let coverage = cover(&flow(), &specs(), &[Criterion::Gates]).unwrap();
+        assert_eq!(coverage.covered.len(), 4);
+        let fails = coverage
+            .scenarios
+            .iter()
+            .find(|s| s.gates.get("us_limit") == Some(&false))
+            .unwrap();
+        assert_eq!(fails.inputs["country"], ConditionValue::String("US".into()));
+    }
+}
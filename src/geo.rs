@@ -0,0 +1,346 @@
+//! Countries and regions for shipping specs
+//!
+//! `region(country)` maps an ISO 3166-1 alpha-2 code to its continent, and
+//! `zone(country)` maps it to one of the spec's own `zones:`, whose members
+//! are codes or region names (`eu`, `europe`). Generated code embeds both
+//! as lookup tables, so specs stop spelling out `country in [...]` lists.
+
+/// Continent of every ISO 3166-1 alpha-2 code. The Americas split at
+/// Panama, with Central America and the Caribbean in `north_america`;
+/// Cyprus counts as Europe.
+const COUNTRIES: &[(&str, &str)] = &[
+    // Africa
+    ("AO", "africa"),
+    ("BF", "africa"),
+    ("BI", "africa"),
+    ("BJ", "africa"),
+    ("BW", "africa"),
+    ("CD", "africa"),
+    ("CF", "africa"),
+    ("CG", "africa"),
+    ("CI", "africa"),
+    ("CM", "africa"),
+    ("CV", "africa"),
+    ("DJ", "africa"),
+    ("DZ", "africa"),
+    ("EG", "africa"),
+    ("EH", "africa"),
+    ("ER", "africa"),
+    ("ET", "africa"),
+    ("GA", "africa"),
+    ("GH", "africa"),
+    ("GM", "africa"),
+    ("GN", "africa"),
+    ("GQ", "africa"),
+    ("GW", "africa"),
+    ("KE", "africa"),
+    ("KM", "africa"),
+    ("LR", "africa"),
+    ("LS", "africa"),
+    ("LY", "africa"),
+    ("MA", "africa"),
+    ("MG", "africa"),
+    ("ML", "africa"),
+    ("MR", "africa"),
+    ("MU", "africa"),
+    ("MW", "africa"),
+    ("MZ", "africa"),
+    ("NA", "africa"),
+    ("NE", "africa"),
+    ("NG", "africa"),
+    ("RE", "africa"),
+    ("RW", "africa"),
+    ("SC", "africa"),
+    ("SD", "africa"),
+    ("SH", "africa"),
+    ("SL", "africa"),
+    ("SN", "africa"),
+    ("SO", "africa"),
+    ("SS", "africa"),
+    ("ST", "africa"),
+    ("SZ", "africa"),
+    ("TD", "africa"),
+    ("TG", "africa"),
+    ("TN", "africa"),
+    ("TZ", "africa"),
+    ("UG", "africa"),
+    ("YT", "africa"),
+    ("ZA", "africa"),
+    ("ZM", "africa"),
+    ("ZW", "africa"),
+    // Antarctica
+    ("AQ", "antarctica"),
+    ("BV", "antarctica"),
+    ("GS", "antarctica"),
+    ("HM", "antarctica"),
+    ("TF", "antarctica"),
+    // Asia
+    ("AE", "asia"),
+    ("AF", "asia"),
+    ("AM", "asia"),
+    ("AZ", "asia"),
+    ("BD", "asia"),
+    ("BH", "asia"),
+    ("BN", "asia"),
+    ("BT", "asia"),
+    ("CN", "asia"),
+    ("GE", "asia"),
+    ("HK", "asia"),
+    ("ID", "asia"),
+    ("IL", "asia"),
+    ("IN", "asia"),
+    ("IO", "asia"),
+    ("IQ", "asia"),
+    ("IR", "asia"),
+    ("JO", "asia"),
+    ("JP", "asia"),
+    ("KG", "asia"),
+    ("KH", "asia"),
+    ("KP", "asia"),
+    ("KR", "asia"),
+    ("KW", "asia"),
+    ("KZ", "asia"),
+    ("LA", "asia"),
+    ("LB", "asia"),
+    ("LK", "asia"),
+    ("MM", "asia"),
+    ("MN", "asia"),
+    ("MO", "asia"),
+    ("MV", "asia"),
+    ("MY", "asia"),
+    ("NP", "asia"),
+    ("OM", "asia"),
+    ("PH", "asia"),
+    ("PK", "asia"),
+    ("PS", "asia"),
+    ("QA", "asia"),
+    ("SA", "asia"),
+    ("SG", "asia"),
+    ("SY", "asia"),
+    ("TH", "asia"),
+    ("TJ", "asia"),
+    ("TL", "asia"),
+    ("TM", "asia"),
+    ("TR", "asia"),
+    ("TW", "asia"),
+    ("UZ", "asia"),
+    ("VN", "asia"),
+    ("YE", "asia"),
+    // Europe
+    ("AD", "europe"),
+    ("AL", "europe"),
+    ("AT", "europe"),
+    ("AX", "europe"),
+    ("BA", "europe"),
+    ("BE", "europe"),
+    ("BG", "europe"),
+    ("BY", "europe"),
+    ("CH", "europe"),
+    ("CY", "europe"),
+    ("CZ", "europe"),
+    ("DE", "europe"),
+    ("DK", "europe"),
+    ("EE", "europe"),
+    ("ES", "europe"),
+    ("FI", "europe"),
+    ("FO", "europe"),
+    ("FR", "europe"),
+    ("GB", "europe"),
+    ("GG", "europe"),
+    ("GI", "europe"),
+    ("GR", "europe"),
+    ("HR", "europe"),
+    ("HU", "europe"),
+    ("IE", "europe"),
+    ("IM", "europe"),
+    ("IS", "europe"),
+    ("IT", "europe"),
+    ("JE", "europe"),
+    ("LI", "europe"),
+    ("LT", "europe"),
+    ("LU", "europe"),
+    ("LV", "europe"),
+    ("MC", "europe"),
+    ("MD", "europe"),
+    ("ME", "europe"),
+    ("MK", "europe"),
+    ("MT", "europe"),
+    ("NL", "europe"),
+    ("NO", "europe"),
+    ("PL", "europe"),
+    ("PT", "europe"),
+    ("RO", "europe"),
+    ("RS", "europe"),
+    ("RU", "europe"),
+    ("SE", "europe"),
+    ("SI", "europe"),
+    ("SJ", "europe"),
+    ("SK", "europe"),
+    ("SM", "europe"),
+    ("UA", "europe"),
+    ("VA", "europe"),
+    // North America, Central America and the Caribbean
+    ("AG", "north_america"),
+    ("AI", "north_america"),
+    ("AW", "north_america"),
+    ("BB", "north_america"),
+    ("BL", "north_america"),
+    ("BM", "north_america"),
+    ("BQ", "north_america"),
+    ("BS", "north_america"),
+    ("BZ", "north_america"),
+    ("CA", "north_america"),
+    ("CR", "north_america"),
+    ("CU", "north_america"),
+    ("CW", "north_america"),
+    ("DM", "north_america"),
+    ("DO", "north_america"),
+    ("GD", "north_america"),
+    ("GL", "north_america"),
+    ("GP", "north_america"),
+    ("GT", "north_america"),
+    ("HN", "north_america"),
+    ("HT", "north_america"),
+    ("JM", "north_america"),
+    ("KN", "north_america"),
+    ("KY", "north_america"),
+    ("LC", "north_america"),
+    ("MF", "north_america"),
+    ("MQ", "north_america"),
+    ("MS", "north_america"),
+    ("MX", "north_america"),
+    ("NI", "north_america"),
+    ("PA", "north_america"),
+    ("PM", "north_america"),
+    ("PR", "north_america"),
+    ("SV", "north_america"),
+    ("SX", "north_america"),
+    ("TC", "north_america"),
+    ("TT", "north_america"),
+    ("UM", "north_america"),
+    ("US", "north_america"),
+    ("VC", "north_america"),
+    ("VG", "north_america"),
+    ("VI", "north_america"),
+    // Oceania
+    ("AS", "oceania"),
+    ("AU", "oceania"),
+    ("CC", "oceania"),
+    ("CK", "oceania"),
+    ("CX", "oceania"),
+    ("FJ", "oceania"),
+    ("FM", "oceania"),
+    ("GU", "oceania"),
+    ("KI", "oceania"),
+    ("MH", "oceania"),
+    ("MP", "oceania"),
+    ("NC", "oceania"),
+    ("NF", "oceania"),
+    ("NR", "oceania"),
+    ("NU", "oceania"),
+    ("NZ", "oceania"),
+    ("PF", "oceania"),
+    ("PG", "oceania"),
+    ("PN", "oceania"),
+    ("PW", "oceania"),
+    ("SB", "oceania"),
+    ("TK", "oceania"),
+    ("TO", "oceania"),
+    ("TV", "oceania"),
+    ("VU", "oceania"),
+    ("WF", "oceania"),
+    ("WS", "oceania"),
+    // South America
+    ("AR", "south_america"),
+    ("BO", "south_america"),
+    ("BR", "south_america"),
+    ("CL", "south_america"),
+    ("CO", "south_america"),
+    ("EC", "south_america"),
+    ("FK", "south_america"),
+    ("GF", "south_america"),
+    ("GY", "south_america"),
+    ("PE", "south_america"),
+    ("PY", "south_america"),
+    ("SR", "south_america"),
+    ("UY", "south_america"),
+    ("VE", "south_america"),
+];
+
+/// Member states of the European Union
+const EU: &[&str] = &[
+    "AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IE", "IT",
+    "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK",
+];
+
+/// Region names zones may list: the continents `region()` returns, and
+/// `eu`
+pub const REGIONS: &[&str] = &[
+    "africa",
+    "antarctica",
+    "asia",
+    "europe",
+    "north_america",
+    "oceania",
+    "south_america",
+    "eu",
+];
+
+/// Continent of an ISO 3166-1 alpha-2 code (upper case)
+pub fn region(code: &str) -> Option<&'static str> {
+    COUNTRIES
+        .iter()
+        .find(|(c, _)| *c == code)
+        .map(|(_, region)| *region)
+}
+
+/// Every country code with its continent, in code order within each
+/// continent
+pub fn countries() -> impl Iterator<Item = (&'static str, &'static str)> {
+    COUNTRIES.iter().copied()
+}
+
+/// The country codes a zone member stands for: itself when it is a code,
+/// every member of a region, or None when it is neither
+pub fn expand(member: &str) -> Option<Vec<&'static str>> {
+    if member == "eu" {
+        return Some(EU.to_vec());
+    }
+    if REGIONS.contains(&member) {
+        return Some(
+            COUNTRIES
+                .iter()
+                .filter(|(_, region)| *region == member)
+                .map(|(code, _)| *code)
+                .collect(),
+        );
+    }
+    COUNTRIES
+        .iter()
+        .find(|(code, _)| *code == member)
+        .map(|(code, _)| vec![*code])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_regions() {
+        assert_eq!(region("DE"), Some("europe"));
+        assert_eq!(region("MX"), Some("north_america"));
+        assert_eq!(region("de"), None);
+
+        let mut codes: Vec<_> = countries().map(|(code, _)| code).collect();
+        let total = codes.len();
+        codes.sort();
+        codes.dedup();
+        assert_eq!(codes.len(), total);
+        assert!(EU.iter().all(|code| region(code) == Some("europe")));
+
+        assert_eq!(expand("eu").unwrap().len(), 27);
+        assert_eq!(expand("FR"), Some(vec!["FR"]));
+        assert!(expand("south_america").unwrap().contains(&"BR"));
+        assert_eq!(expand("atlantis"), None);
+    }
+}
@@ -0,0 +1,391 @@
+//! Decision coverage heatmaps (`imacs heatmap`)
+//!
+//! Runs recorded or simulated decisions (the [`crate::replay`] format)
+//! through a spec and draws, as one HTML page, how often each rule decides
+//! and how the values a rule compares against a threshold are spread
+//! around it. Rules that never fire stand out, and so do thresholds placed
+//! where the traffic is thickest, where moving them a little moves many
+//! decisions.
+
+use crate::replay::records;
+use crate::runtime::evaluate;
+use crate::spec::{ConditionValue, Spec, VarType};
+use regex::Regex;
+use serde::Serialize;
+use std::collections::HashMap;
+use std::fmt::Write;
+
+/// Bins of a threshold's histogram
+pub const BINS: usize = 20;
+
+/// How often one rule decided
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleHeat {
+    /// Rule ID, `default` for the fallback
+    pub rule: String,
+    pub hits: usize,
+    /// Share of the decisions, in percent
+    pub share: f64,
+}
+
+/// The recorded values of an input a rule compares against a number
+#[derive(Debug, Clone, Serialize)]
+pub struct Threshold {
+    pub rule: String,
+    pub input: String,
+    /// The comparison as written, `weight_kg > 20.0`
+    pub comparison: String,
+    pub at: f64,
+    /// Range of the histogram: the recorded values and the threshold
+    pub min: f64,
+    pub max: f64,
+    /// Recorded values in each of [`BINS`] equal bins from `min` to `max`
+    pub bins: Vec<usize>,
+    /// Share of the recorded values in the threshold's bin, in percent
+    pub near: f64,
+    /// Whether the threshold's bin holds at least twice its even share
+    pub dense: bool,
+}
+
+/// Rule hits and threshold neighbourhoods of a spec over a recording
+#[derive(Debug, Clone, Serialize)]
+pub struct Heatmap {
+    pub spec: String,
+    pub decisions: usize,
+    /// Every deployed rule in evaluation order, then the default
+    pub rules: Vec<RuleHeat>,
+    pub thresholds: Vec<Threshold>,
+    /// Lines that are not decision records or fail to evaluate
+    pub invalid: usize,
+}
+
+/// Decide every record of `spec` in a JSONL recording and collect rule
+/// hits and the values compared against thresholds
+pub fn heatmap(spec: &Spec, jsonl: &str) -> Heatmap {
+    let mut hits: HashMap<String, usize> = HashMap::new();
+    let mut numbers: HashMap<String, Vec<f64>> = HashMap::new();
+    let (mut decisions, mut invalid) = (0, 0);
+    for (_, record) in records(jsonl) {
+        let Ok(record) = record else {
+            invalid += 1;
+            continue;
+        };
+        if record.spec.as_ref().is_some_and(|id| *id != spec.id) {
+            continue;
+        }
+        let Ok(evaluation) = evaluate(spec, &record.input_values(spec)) else {
+            invalid += 1;
+            continue;
+        };
+        decisions += 1;
+        *hits
+            .entry(evaluation.rule_id.unwrap_or_else(|| "default".into()))
+            .or_default() += 1;
+        for (name, value) in &record.input {
+            let number = match value {
+                ConditionValue::Int(i) => *i as f64,
+                ConditionValue::Float(f) => *f,
+                _ => continue,
+            };
+            numbers.entry(name.clone()).or_default().push(number);
+        }
+    }
+
+    let share = |n: usize| {
+        if decisions == 0 {
+            0.0
+        } else {
+            n as f64 * 100.0 / decisions as f64
+        }
+    };
+    let deployed: Vec<_> = spec
+        .evaluation_order()
+        .into_iter()
+        .filter(|r| r.state.is_active())
+        .collect();
+    let rules: Vec<RuleHeat> = deployed
+        .iter()
+        .map(|r| r.id.as_str())
+        .chain(spec.default.as_ref().map(|_| "default"))
+        .map(|rule| {
+            let n = hits.get(rule).copied().unwrap_or(0);
+            RuleHeat {
+                rule: rule.to_string(),
+                hits: n,
+                share: share(n),
+            }
+        })
+        .collect();
+
+    let comparison =
+        Regex::new(r"\b([A-Za-z_]\w*)\s*(>=|<=|>|<)\s*(-?\d+(?:\.\d+)?)").expect("valid regex");
+    let mut thresholds = Vec::new();
+    for rule in &deployed {
+        let Some(cel) = rule.as_cel() else {
+            continue;
+        };
+        for caps in comparison.captures_iter(&cel) {
+            let numeric = spec
+                .inputs
+                .iter()
+                .any(|i| i.name == caps[1] && matches!(i.typ, VarType::Int | VarType::Float));
+            let (true, Ok(at)) = (numeric, caps[3].parse::<f64>()) else {
+                continue;
+            };
+            let values = numbers.get(&caps[1]).map(Vec::as_slice).unwrap_or_default();
+            thresholds.push(threshold(
+                &rule.id,
+                &caps[1],
+                caps[0].to_string(),
+                at,
+                values,
+            ));
+        }
+    }
+
+    Heatmap {
+        spec: spec.id.clone(),
+        decisions,
+        rules,
+        thresholds,
+        invalid,
+    }
+}
+
+fn threshold(rule: &str, input: &str, comparison: String, at: f64, values: &[f64]) -> Threshold {
+    let min = values.iter().copied().fold(at, f64::min);
+    let mut max = values.iter().copied().fold(at, f64::max);
+    if max == min {
+        max = min + 1.0;
+    }
+    let bin = |v: f64| (((v - min) / (max - min) * BINS as f64) as usize).min(BINS - 1);
+    let mut bins = vec![0; BINS];
+    for v in values {
+        bins[bin(*v)] += 1;
+    }
+    let at_bin = bins[bin(at)];
+    let near = if values.is_empty() {
+        0.0
+    } else {
+        at_bin as f64 * 100.0 / values.len() as f64
+    };
+    Threshold {
+        rule: rule.to_string(),
+        input: input.to_string(),
+        comparison,
+        at,
+        min,
+        max,
+        bins,
+        near,
+        dense: !values.is_empty() && at_bin as f64 >= 2.0 * values.len() as f64 / BINS as f64,
+    }
+}
+
+impl Heatmap {
+    /// Rules (and the default) that decided nothing
+    pub fn never_fired(&self) -> Vec<&str> {
+        self.rules
+            .iter()
+            .filter(|r| r.hits == 0)
+            .map(|r| r.rule.as_str())
+            .collect()
+    }
+
+    /// The heatmap as a standalone HTML page
+    pub fn to_html(&self) -> String {
+        let mut out = format!(
+            "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n\
+             <title>Rule heatmap: {0}</title>\n<style>{1}</style>\n</head>\n<body>\n\
+             <h1>Rule heatmap: {0}</h1>\n<p>{2} decision(s)",
+            escape(&self.spec),
+            STYLE,
+            self.decisions
+        );
+        if self.invalid > 0 {
+            let _ = write!(
+                out,
+                ", {} line(s) skipped as not decision records or not decidable",
+                self.invalid
+            );
+        }
+        out.push_str("</p>\n");
+
+        out.push_str(
+            "<h2>Rule hits</h2>\n<table>\n<thead><tr><th>Rule</th><th>Hits</th><th>Share</th></tr></thead>\n<tbody>\n",
+        );
+        let hottest = self.rules.iter().map(|r| r.share).fold(0.0, f64::max);
+        for rule in &self.rules {
+            let heat = if hottest > 0.0 {
+                rule.share / hottest
+            } else {
+                0.0
+            };
+            let _ = writeln!(
+                out,
+                "<tr{}><td>{}</td><td>{}</td><td style=\"background: hsl(12, 85%, {:.0}%)\">{:.1}%{}</td></tr>",
+                if rule.hits == 0 { " class=\"never\"" } else { "" },
+                escape(&rule.rule),
+                rule.hits,
+                96.0 - heat * 46.0,
+                rule.share,
+                if rule.hits == 0 { " (never fires)" } else { "" }
+            );
+        }
+        out.push_str("</tbody>\n</table>\n");
+
+        if !self.thresholds.is_empty() {
+            out.push_str("<h2>Thresholds</h2>\n");
+        }
+        for threshold in &self.thresholds {
+            let _ = writeln!(
+                out,
+                "<figure>\n{}\n<figcaption><code>{}</code> (rule {}): {:.1}% of the values in the threshold's bin{}</figcaption>\n</figure>",
+                histogram(threshold),
+                escape(&threshold.comparison),
+                escape(&threshold.rule),
+                threshold.near,
+                if threshold.dense {
+                    ", <strong>a dense region</strong>"
+                } else {
+                    ""
+                }
+            );
+        }
+        out.push_str("</body>\n</html>\n");
+        out
+    }
+}
+
+/// A threshold's histogram as SVG, the threshold drawn as a line
+fn histogram(threshold: &Threshold) -> String {
+    const WIDTH: f64 = 400.0;
+    const HEIGHT: f64 = 100.0;
+    let bar = WIDTH / BINS as f64;
+    let tallest = threshold.bins.iter().copied().max().unwrap_or(0).max(1) as f64;
+    let mut svg = format!(
+        "<svg class=\"histogram\" width=\"{0}\" height=\"{1}\" viewBox=\"0 0 {0} {1}\" xmlns=\"http://www.w3.org/2000/svg\">",
+        WIDTH,
+        HEIGHT + 20.0
+    );
+    for (i, count) in threshold.bins.iter().enumerate() {
+        let height = *count as f64 / tallest * HEIGHT;
+        let _ = write!(
+            svg,
+            "<rect x=\"{:.1}\" y=\"{:.1}\" width=\"{:.1}\" height=\"{:.1}\"><title>{}</title></rect>",
+            i as f64 * bar + 1.0,
+            HEIGHT - height,
+            bar - 2.0,
+            height,
+            count
+        );
+    }
+    let x = (threshold.at - threshold.min) / (threshold.max - threshold.min) * WIDTH;
+    let _ = write!(
+        svg,
+        "<line x1=\"{0:.1}\" y1=\"0\" x2=\"{0:.1}\" y2=\"{1}\"/>\
+         <text x=\"0\" y=\"{2}\">{3}</text><text x=\"{4}\" y=\"{2}\" text-anchor=\"end\">{5}</text></svg>",
+        x,
+        HEIGHT,
+        HEIGHT + 15.0,
+        threshold.min,
+        WIDTH,
+        threshold.max
+    );
+    svg
+}
+
+/// Text safe inside HTML elements and attribute values
+fn escape(text: &str) -> String {
+    text.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
+
+const STYLE: &str = "
+body { font-family: system-ui, sans-serif; max-width: 960px; margin: 2em auto; padding: 0 1em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 12px; text-align: left; }
+tr.never td { color: #b00; }
+.histogram rect { fill: #5b7db1; }
+.histogram line { stroke: #d33; stroke-width: 2; }
+.histogram text { font-size: 11px; fill: #666; }
+figure { margin: 1em 0; }
+";
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+  - name: express
+    type: bool
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: EXPRESS
+    when: "express && weight_kg < -10.0"
+    then: 40.0
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+default: 5.0
+"#;
+
+    fn recording() -> String {
+        let mut jsonl = String::new();
+        for kg in [0, 2, 3, 19, 20, 20, 21, 21, 22, 40] {
+            jsonl.push_str(&format!(
+                "{{\"spec\": \"shipping_rate\", \"input\": {{\"weight_kg\": {}, \"express\": false}}, \"output\": 5.0}}\n",
+                kg
+            ));
+        }
+        jsonl.push_str("{\"spec\": \"other\", \"input\": {}, \"output\": 1}\n");
+        jsonl.push_str("not json\n");
+        jsonl
+    }
+
+    #[test]
+    fn test_heatmap() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let heatmap = heatmap(&spec, &recording());
+        assert_eq!(heatmap.decisions, 10);
+        assert_eq!(heatmap.invalid, 1);
+
+        let hits: Vec<_> = heatmap
+            .rules
+            .iter()
+            .map(|r| (r.rule.as_str(), r.hits))
+            .collect();
+        assert_eq!(hits, vec![("EXPRESS", 0), ("HEAVY", 4), ("default", 6)]);
+        assert_eq!(heatmap.rules[1].share, 40.0);
+        assert_eq!(heatmap.never_fired(), vec!["EXPRESS"]);
+
+        // 0..40 in 20 bins of 2: 20.0 falls in [20, 22) with 20, 20, 21, 21
+        let heavy = &heatmap.thresholds[1];
+        assert_eq!(heavy.comparison, "weight_kg > 20.0");
+        assert_eq!((heavy.min, heavy.max), (0.0, 40.0));
+        assert_eq!(heavy.bins[10], 4);
+        assert_eq!(heavy.near, 40.0);
+        assert!(heavy.dense);
+        assert!(!heatmap.thresholds[0].dense);
+    }
+
+    #[test]
+    fn test_heatmap_html() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let html = heatmap(&spec, &recording()).to_html();
+        assert!(html.contains("<h1>Rule heatmap: shipping_rate</h1>"));
+        assert!(html.contains("<tr class=\"never\"><td>EXPRESS</td><td>0</td>"));
+        assert!(html.contains("0.0% (never fires)"));
+        assert!(html.contains("<code>weight_kg &gt; 20.0</code> (rule HEAVY): 40.0%"));
+        assert!(html.contains("<strong>a dense region</strong>"));
+        assert_eq!(html.matches("<svg class=\"histogram\"").count(), 2);
+    }
+}
@@ -0,0 +1,624 @@
+//! Draft specs from hand-written Go decision functions (`imacs import-go`)
+//!
+//! Legacy pricing and eligibility code is often a single function that
+//! walks an if/else chain or a `switch` and returns a value. Such a function
+//! is turned into a spec by following each path to its `return`: the
+//! conditions along the path become the rule's `when`, the returned value
+//! its `then`, and an unconditional return the default. Rules keep the
+//! order of the code, so first-match evaluation decides as the function did.
+//!
+//! Only that restricted shape is understood. Parameters must be scalars
+//! (or structs read through field selectors); local variables, loops and
+//! calls other than `len` and the `strings` prefix/suffix/contains helpers
+//! are reported with their line and left out of the draft, which then needs
+//! finishing by hand. `(value, error)` results keep the value; paths
+//! returning an error are skipped.
+
+use crate::ast::{AstNode, BinaryOp, Function, LiteralValue, Pattern, UnaryOp};
+use crate::error::{Error, Result};
+use crate::parse::parse_go;
+use crate::scaffold::{fields_yaml, title, Field};
+use crate::spec::{Spec, VarType};
+use crate::util::to_snake_case;
+use serde::Serialize;
+
+/// A drafted spec and what could not be carried over
+#[derive(Debug, Clone, Serialize)]
+pub struct GoImport {
+    /// Function the spec was drafted from
+    pub function: String,
+    pub yaml: String,
+    pub rules: usize,
+    /// Parts of the function left out of the draft, with their line
+    pub warnings: Vec<String>,
+}
+
+impl GoImport {
+    pub fn to_report(&self) -> String {
+        let mut out = format!(
+            "Imported {} rule(s) from func {}\n",
+            self.rules, self.function
+        );
+        if self.warnings.is_empty() {
+            out.push_str("Every path was carried over\n");
+        }
+        for w in &self.warnings {
+            out.push_str(&format!("  {}\n", w));
+        }
+        out
+    }
+}
+
+/// Draft a spec from the Go function `function` in `source` (the only
+/// function in the file when None)
+pub fn import_go(source: &str, function: Option<&str>, id: Option<&str>) -> Result<GoImport> {
+    let code = parse_go(source)?;
+    let func = match function {
+        Some(name) => code
+            .functions
+            .iter()
+            .find(|f| f.name == name)
+            .ok_or_else(|| Error::Other(format!("no func {} in the source", name)))?,
+        None => match code.functions.as_slice() {
+            [func] => func,
+            [] => return Err(Error::Other("no functions in the source".into())),
+            funcs => {
+                let names: Vec<&str> = funcs.iter().map(|f| f.name.as_str()).collect();
+                return Err(Error::Other(format!(
+                    "several functions ({}); pick one with --func",
+                    names.join(", ")
+                )));
+            }
+        },
+    };
+
+    let mut importer = Importer::new(func);
+    importer.walk(&func.body, &[]);
+    if importer.rules.is_empty() && importer.default.is_none() {
+        return Err(Error::Other(format!(
+            "func {} has no returns that could be carried over",
+            func.name
+        )));
+    }
+    if importer.default.is_none() {
+        importer
+            .warnings
+            .push("no unconditional return; add a default".into());
+    }
+
+    let id = id
+        .map(str::to_string)
+        .unwrap_or_else(|| to_snake_case(&func.name));
+    let yaml = importer.to_yaml(&id, func);
+    Spec::from_yaml(&yaml)?;
+    Ok(GoImport {
+        function: func.name.clone(),
+        rules: importer.rules.len(),
+        yaml,
+        warnings: importer.warnings,
+    })
+}
+
+/// A rule drafted from one path through the function
+struct Drafted {
+    line: usize,
+    when: Vec<String>,
+    then: String,
+}
+
+struct Importer {
+    params: Vec<String>,
+    rules: Vec<Drafted>,
+    default: Option<Drafted>,
+    warnings: Vec<String>,
+    /// Identifiers already reported as not being parameters
+    unknown: Vec<String>,
+}
+
+impl Importer {
+    fn new(func: &Function) -> Self {
+        Self {
+            params: func.params.iter().map(|p| p.name.clone()).collect(),
+            rules: Vec::new(),
+            default: None,
+            warnings: Vec::new(),
+            unknown: Vec::new(),
+        }
+    }
+
+    fn skip(&mut self, line: usize, what: &str) {
+        self.warnings.push(format!("line {}: {}", line, what));
+    }
+
+    /// Draft rules for `node` reached under `path`; true when every way
+    /// through it returns
+    fn walk(&mut self, node: &AstNode, path: &[String]) -> bool {
+        if self.default.is_some() {
+            return true;
+        }
+        match node {
+            AstNode::Block {
+                statements, result, ..
+            } => {
+                for statement in statements {
+                    let returns = match statement {
+                        AstNode::Return {
+                            value: Some(value),
+                            span,
+                        } => self.returned(value, span.start_line, path),
+                        AstNode::If { .. } | AstNode::Match { .. } | AstNode::Block { .. } => {
+                            self.walk(statement, path)
+                        }
+                        AstNode::Unknown { kind, span } => {
+                            self.skip(span.start_line, &format!("skipped {}", kind));
+                            false
+                        }
+                        other => {
+                            self.skip(other.span().start_line, "skipped a statement");
+                            false
+                        }
+                    };
+                    if returns {
+                        return true;
+                    }
+                }
+                match result.as_deref() {
+                    Some(inner @ (AstNode::If { .. } | AstNode::Match { .. })) => {
+                        self.walk(inner, path)
+                    }
+                    Some(value) => self.returned(value, value.span().start_line, path),
+                    None => false,
+                }
+            }
+            AstNode::If {
+                condition,
+                then_branch,
+                else_branch,
+                span,
+            } => {
+                let Some(cond) = self.cel(condition) else {
+                    self.skip(
+                        span.start_line,
+                        "condition has no CEL equivalent; if skipped",
+                    );
+                    return false;
+                };
+                let then_returns = self.walk(then_branch, &with(path, cond.clone()));
+                let Some(else_branch) = else_branch else {
+                    return false;
+                };
+                // Rules keep code order, so after a branch that always
+                // returns the else needs no negated condition
+                let else_path = if then_returns {
+                    path.to_vec()
+                } else {
+                    with(path, negate(&cond))
+                };
+                let else_returns = self.walk(else_branch, &else_path);
+                then_returns && else_returns
+            }
+            AstNode::Match {
+                scrutinee, arms, ..
+            } => {
+                let tag = self.cel(scrutinee);
+                let mut all_return = true;
+                let mut default_arm = None;
+                for arm in arms {
+                    let cond = match (&arm.pattern, &arm.guard) {
+                        (Pattern::Wildcard, None) => {
+                            // Go takes `default` last wherever it is written
+                            default_arm = Some(arm);
+                            continue;
+                        }
+                        (Pattern::Wildcard, Some(guard)) => self.cel(guard),
+                        (Pattern::Literal(value), None) => {
+                            tag.as_ref().map(|t| format!("{} == {}", t, literal(value)))
+                        }
+                        (Pattern::Or(patterns), None) => {
+                            let values: Option<Vec<String>> = patterns
+                                .iter()
+                                .map(|p| match p {
+                                    Pattern::Literal(value) => Some(literal(value)),
+                                    _ => None,
+                                })
+                                .collect();
+                            tag.as_ref()
+                                .zip(values)
+                                .map(|(t, values)| format!("{} in [{}]", t, values.join(", ")))
+                        }
+                        _ => None,
+                    };
+                    match cond {
+                        Some(cond) => all_return &= self.walk(&arm.body, &with(path, cond)),
+                        None => {
+                            self.skip(arm.span.start_line, "case has no CEL equivalent; skipped");
+                            all_return = false;
+                        }
+                    }
+                }
+                match default_arm {
+                    Some(arm) => all_return && self.walk(&arm.body, path),
+                    None => false,
+                }
+            }
+            AstNode::Return {
+                value: Some(value),
+                span,
+            } => self.returned(value, span.start_line, path),
+            AstNode::Unknown { kind, span } => {
+                self.skip(span.start_line, &format!("skipped {}", kind));
+                false
+            }
+            other => self.returned(other, other.span().start_line, path),
+        }
+    }
+
+    /// Record a return of `value` under `path`
+    fn returned(&mut self, value: &AstNode, line: usize, path: &[String]) -> bool {
+        let value = match value {
+            AstNode::Tuple { elements, .. } => {
+                let errors = elements[1..].iter().any(|e| {
+                    !matches!(
+                        e,
+                        AstNode::Literal {
+                            value: LiteralValue::Unit,
+                            ..
+                        }
+                    )
+                });
+                if errors {
+                    self.skip(line, "returns an error; path skipped");
+                    return true;
+                }
+                &elements[0]
+            }
+            value => value,
+        };
+        let then = match value {
+            AstNode::Literal { value, .. } => yaml_literal(value),
+            expr => match self.cel(expr) {
+                // Output strings that read as expressions are evaluated
+                Some(cel) => serde_json::to_string(&cel).unwrap_or_default(),
+                None => {
+                    self.skip(line, "returned value has no CEL equivalent; path skipped");
+                    return true;
+                }
+            },
+        };
+        let rule = Drafted {
+            line,
+            when: path.to_vec(),
+            then,
+        };
+        if path.is_empty() {
+            self.default = Some(rule);
+        } else {
+            self.rules.push(rule);
+        }
+        true
+    }
+
+    /// The CEL form of a Go expression
+    fn cel(&mut self, node: &AstNode) -> Option<String> {
+        match node {
+            AstNode::Literal { value, .. } => Some(literal(value)),
+            AstNode::Var { name, span } => {
+                if !self.params.contains(name) && !self.unknown.contains(name) {
+                    self.unknown.push(name.clone());
+                    self.skip(
+                        span.start_line,
+                        &format!(
+                            "`{}` is not a parameter; replace it with its value or add an input",
+                            name
+                        ),
+                    );
+                }
+                Some(to_snake_case(name))
+            }
+            AstNode::Field { object, field, .. } => {
+                Some(format!("{}.{}", self.cel(object)?, field))
+            }
+            AstNode::Binary {
+                op, left, right, ..
+            } => {
+                let prec = precedence(*op)?;
+                let left_text = self.cel(left)?;
+                let right_text = self.cel(right)?;
+                let wrap = |node: &AstNode, text: String, tighter: bool| match node {
+                    AstNode::Binary { op, .. }
+                        if precedence(*op).is_some_and(|p| p < prec || (tighter && p == prec)) =>
+                    {
+                        format!("({})", text)
+                    }
+                    _ => text,
+                };
+                Some(format!(
+                    "{} {} {}",
+                    wrap(left, left_text, false),
+                    op,
+                    wrap(right, right_text, true)
+                ))
+            }
+            AstNode::Unary {
+                op: UnaryOp::Not,
+                operand,
+                ..
+            } => Some(negate(&self.cel(operand)?)),
+            AstNode::Unary {
+                op: UnaryOp::Neg,
+                operand,
+                ..
+            } => {
+                let operand = self.cel(operand)?;
+                Some(if is_simple(&operand) {
+                    format!("-{}", operand)
+                } else {
+                    format!("-({})", operand)
+                })
+            }
+            AstNode::Call { function, args, .. } => {
+                let args: Vec<String> = args.iter().map(|a| self.cel(a)).collect::<Option<_>>()?;
+                let method = match function.as_str() {
+                    "len" if args.len() == 1 => return Some(format!("size({})", args[0])),
+                    "strings.HasPrefix" => "startsWith",
+                    "strings.HasSuffix" => "endsWith",
+                    "strings.Contains" => "contains",
+                    _ => return None,
+                };
+                match args.as_slice() {
+                    [target, arg] => Some(format!("{}.{}({})", target, method, arg)),
+                    _ => None,
+                }
+            }
+            _ => None,
+        }
+    }
+
+    fn to_yaml(&self, id: &str, func: &Function) -> String {
+        let inputs: Vec<Field> = func
+            .params
+            .iter()
+            .map(|p| (to_snake_case(&p.name), go_type(&p.typ)))
+            .collect();
+        let outputs = vec![self.output(func)];
+
+        let mut yaml = format!("# Drafted from Go func {}; review before use\n", func.name);
+        if !self.warnings.is_empty() {
+            yaml.push_str("# Not carried over:\n");
+            for w in &self.warnings {
+                yaml.push_str(&format!("#   - {}\n", w));
+            }
+        }
+        yaml.push_str(&format!(
+            "id: {}\nname: \"{}\"\ndescription: \"Imported from Go func {}\"\n\n",
+            id,
+            title(id),
+            func.name
+        ));
+        yaml.push_str(&fields_yaml("inputs", &inputs));
+        yaml.push_str(&fields_yaml("outputs", &outputs));
+        if self.rules.is_empty() {
+            yaml.push_str("rules: []\n");
+        } else {
+            yaml.push_str("rules:\n");
+        }
+        for (i, rule) in self.rules.iter().enumerate() {
+            let when = serde_json::to_string(&rule.when.join(" && ")).unwrap_or_default();
+            yaml.push_str(&format!(
+                "  # line {}\n  - id: R{}\n    when: {}\n    then: {}\n",
+                rule.line,
+                i + 1,
+                when,
+                rule.then
+            ));
+        }
+        if let Some(default) = &self.default {
+            yaml.push_str(&format!(
+                "\n# line {}\ndefault: {}\n",
+                default.line, default.then
+            ));
+        }
+        yaml
+    }
+
+    /// The output, named and typed after the function's (first) result
+    fn output(&self, func: &Function) -> Field {
+        let result = func.return_type.as_deref().unwrap_or("");
+        let first = result
+            .trim_matches(|c| c == '(' || c == ')')
+            .split(',')
+            .next()
+            .unwrap_or("")
+            .trim();
+        let (name, typ) = match first.split_once(' ') {
+            Some((name, typ)) => (to_snake_case(name), typ.trim()),
+            None => ("result".to_string(), first),
+        };
+        let typ = if typ.is_empty() {
+            self.rules
+                .iter()
+                .chain(&self.default)
+                .find_map(|r| value_type(&r.then))
+                .unwrap_or(VarType::String)
+        } else {
+            go_type(typ)
+        };
+        (name, typ)
+    }
+}
+
+fn with(path: &[String], cond: String) -> Vec<String> {
+    let mut path = path.to_vec();
+    path.push(cond);
+    path
+}
+
+fn negate(cond: &str) -> String {
+    if is_simple(cond) {
+        format!("!{}", cond)
+    } else {
+        format!("!({})", cond)
+    }
+}
+
+/// An identifier, field path or call, which needs no parentheses
+fn is_simple(cel: &str) -> bool {
+    !cel.contains(' ')
+}
+
+/// Binding strength of the operators CEL shares with Go
+fn precedence(op: BinaryOp) -> Option<u8> {
+    match op {
+        BinaryOp::Or => Some(1),
+        BinaryOp::And => Some(2),
+        BinaryOp::Eq | BinaryOp::Ne | BinaryOp::Lt | BinaryOp::Le | BinaryOp::Gt | BinaryOp::Ge => {
+            Some(3)
+        }
+        BinaryOp::Add | BinaryOp::Sub => Some(4),
+        BinaryOp::Mul | BinaryOp::Div | BinaryOp::Mod => Some(5),
+        _ => None,
+    }
+}
+
+/// A literal as CEL
+fn literal(value: &LiteralValue) -> String {
+    match value {
+        LiteralValue::Bool(b) => b.to_string(),
+        LiteralValue::Int(i) => i.to_string(),
+        LiteralValue::Float(f) => format!("{:?}", f),
+        LiteralValue::String(s) => format!("'{}'", s.replace('\\', "\\\\").replace('\'', "\\'")),
+        LiteralValue::Char(c) => format!("'{}'", c),
+        LiteralValue::Unit => "null".into(),
+    }
+}
+
+/// A literal as a YAML scalar
+fn yaml_literal(value: &LiteralValue) -> String {
+    match value {
+        LiteralValue::String(s) => serde_json::to_string(s).unwrap_or_default(),
+        LiteralValue::Char(c) => serde_json::to_string(&c.to_string()).unwrap_or_default(),
+        other => literal(other),
+    }
+}
+
+/// Type of a drafted output value, None for expressions
+fn value_type(yaml: &str) -> Option<VarType> {
+    if yaml == "true" || yaml == "false" {
+        Some(VarType::Bool)
+    } else if yaml.parse::<i64>().is_ok() {
+        Some(VarType::Int)
+    } else if yaml.parse::<f64>().is_ok() {
+        Some(VarType::Float)
+    } else {
+        None
+    }
+}
+
+fn go_type(typ: &str) -> VarType {
+    match typ {
+        "bool" => VarType::Bool,
+        "int" | "int8" | "int16" | "int32" | "int64" | "uint" | "uint8" | "uint16" | "uint32"
+        | "uint64" | "byte" | "rune" => VarType::Int,
+        "float32" | "float64" => VarType::Float,
+        "string" => VarType::String,
+        _ => match typ.strip_prefix("[]").map(go_type) {
+            Some(inner @ (VarType::Bool | VarType::Int | VarType::Float | VarType::String)) => {
+                VarType::List(Box::new(inner))
+            }
+            _ => VarType::Object,
+        },
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::cel::CelValue;
+    use crate::runtime::evaluate;
+    use std::collections::HashMap;
+
+    const RATES: &str = r#"
+package shipping
+
+// ShippingRate is the legacy rate table
+func ShippingRate(zone string, weightKg float64, express bool) (int, error) {
+	if weightKg <= 0 {
+		return 0, errors.New("bad weight")
+	}
+	switch zone {
+	case "domestic", "local":
+		if express {
+			return 900, nil
+		}
+		return 500, nil
+	case "eu":
+		if weightKg > 20.0 && !express {
+			return 2500, nil
+		}
+		return 1500, nil
+	}
+	return 4000, nil
+}
+"#;
+
+    #[test]
+    fn test_import_if_else_and_switch() {
+        let import = import_go(RATES, None, None).unwrap();
+        assert_eq!(import.function, "ShippingRate");
+        assert_eq!(import.rules, 4);
+        assert!(import.warnings[0].starts_with("line 7: returns an error"));
+
+        let spec = Spec::from_yaml(&import.yaml).unwrap();
+        assert_eq!(spec.id, "shipping_rate");
+        let names: Vec<&str> = spec.inputs.iter().map(|i| i.name.as_str()).collect();
+        assert_eq!(names, vec!["zone", "weight_kg", "express"]);
+        assert_eq!(spec.inputs[1].typ, VarType::Float);
+        assert_eq!(spec.outputs[0].typ, VarType::Int);
+        assert!(import
+            .yaml
+            .contains("when: \"zone in ['domestic', 'local'] && express\""));
+        assert!(import
+            .yaml
+            .contains("when: \"zone == 'eu' && weight_kg > 20.0 && !express\""));
+
+        let rate = |zone: &str, weight_kg: f64, express: bool| {
+            let input = HashMap::from([
+                ("zone".to_string(), zone.into()),
+                ("weight_kg".to_string(), weight_kg.into()),
+                ("express".to_string(), express.into()),
+            ]);
+            evaluate(&spec, &input).unwrap().outputs["result"].clone()
+        };
+        assert_eq!(rate("local", 3.0, true), CelValue::Int(900));
+        assert_eq!(rate("domestic", 3.0, false), CelValue::Int(500));
+        assert_eq!(rate("eu", 30.0, false), CelValue::Int(2500));
+        assert_eq!(rate("eu", 30.0, true), CelValue::Int(1500));
+        assert_eq!(rate("world", 1.0, false), CelValue::Int(4000));
+    }
+
+    #[test]
+    fn test_unsupported_statements_are_reported() {
+        let source = r#"
+package pricing
+
+func Discount(total int, member bool) int {
+	rate := 5
+	if member && total > MinTotal {
+		return total / 10
+	}
+	return rate
+}
+"#;
+        let import = import_go(source, Some("Discount"), Some("discount")).unwrap();
+        assert_eq!(import.rules, 1);
+        assert!(import.yaml.contains("then: \"total / 10\""));
+        assert!(import
+            .warnings
+            .iter()
+            .any(|w| w.starts_with("line 5: skipped short_var_declaration")));
+        assert!(import.warnings.iter().any(|w| w.contains("`MinTotal`")));
+        assert!(import.warnings.iter().any(|w| w.contains("`rate`")));
+
+        assert!(import_go(source, Some("Missing"), None).is_err());
+    }
+}
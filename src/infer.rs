@@ -0,0 +1,639 @@
+//! Draft specs from example decisions (`imacs infer`)
+//!
+//! Fits a decision tree to historical input/output rows (a CSV with a
+//! header; the output is the last column unless named) and writes each
+//! leaf as a rule: thresholds (`weight_kg <= 10.0`) on numeric columns,
+//! equality (`zone == 'eu'`) on the others. Leaves deciding the most common
+//! output become the default.
+//!
+//! The tree is kept shallow so the draft stays readable, which means some
+//! rows may not be reproduced. Every row is run through the drafted spec
+//! and those whose output differs are reported, so the draft can be
+//! refined by hand.
+
+use crate::assertions::literal;
+use crate::cel::CelValue;
+use crate::error::{Error, Result};
+use crate::runtime::{evaluate, Values};
+use crate::spec::Spec;
+use serde::Serialize;
+use std::collections::{BTreeMap, BTreeSet};
+
+/// String columns with at most this many distinct values declare them
+const MAX_DECLARED_VALUES: usize = 12;
+
+/// How to fit the draft
+#[derive(Debug, Clone)]
+pub struct InferOptions {
+    /// Output column; the last column when None
+    pub target: Option<String>,
+    /// Most conditions on one rule
+    pub max_depth: usize,
+    /// Fewest rows a rule is fitted to
+    pub min_rows: usize,
+}
+
+impl Default for InferOptions {
+    fn default() -> Self {
+        Self {
+            target: None,
+            max_depth: 4,
+            min_rows: 2,
+        }
+    }
+}
+
+/// A drafted spec and how well it reproduces the data
+#[derive(Debug, Clone, Serialize)]
+pub struct Inference {
+    pub yaml: String,
+    pub rows: usize,
+    pub rules: usize,
+    /// Rows the draft decides differently
+    pub mismatches: Vec<Mismatch>,
+}
+
+/// A row the drafted spec does not reproduce
+#[derive(Debug, Clone, Serialize)]
+pub struct Mismatch {
+    /// Line in the CSV
+    pub line: usize,
+    pub input: String,
+    pub expected: String,
+    pub actual: String,
+}
+
+impl Inference {
+    pub fn to_report(&self) -> String {
+        let mut out = format!(
+            "Inferred {} rule(s) from {} row(s)\n",
+            self.rules, self.rows
+        );
+        if self.mismatches.is_empty() {
+            out.push_str("Every row matches the draft\n");
+            return out;
+        }
+        out.push_str(&format!(
+            "{} row(s) do not match the draft:\n",
+            self.mismatches.len()
+        ));
+        for m in &self.mismatches {
+            out.push_str(&format!(
+                "  line {}: {} expected {}, draft gives {}\n",
+                m.line, m.input, m.expected, m.actual
+            ));
+        }
+        out
+    }
+}
+
+/// Type of a CSV column, from the values in it
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Kind {
+    Bool,
+    Int,
+    Float,
+    String,
+}
+
+impl Kind {
+    fn of<'a>(cells: impl Iterator<Item = &'a str> + Clone) -> Kind {
+        if cells.clone().all(|c| parse_bool(c).is_some()) {
+            Kind::Bool
+        } else if cells.clone().all(|c| c.parse::<i64>().is_ok()) {
+            Kind::Int
+        } else if cells.clone().all(|c| c.parse::<f64>().is_ok()) {
+            Kind::Float
+        } else {
+            Kind::String
+        }
+    }
+
+    fn numeric(self) -> bool {
+        matches!(self, Kind::Int | Kind::Float)
+    }
+
+    fn value(self, cell: &str) -> CelValue {
+        match self {
+            Kind::Bool => parse_bool(cell).unwrap_or_default().into(),
+            Kind::Int => cell.parse::<i64>().unwrap_or_default().into(),
+            Kind::Float => cell.parse::<f64>().unwrap_or_default().into(),
+            Kind::String => cell.to_string().into(),
+        }
+    }
+
+    /// A cell as a literal in CEL or YAML
+    fn literal(self, cell: &str) -> String {
+        match self {
+            Kind::Bool => parse_bool(cell).unwrap_or_default().to_string(),
+            Kind::Int => cell.parse::<i64>().unwrap_or_default().to_string(),
+            Kind::Float => format!("{:?}", cell.parse::<f64>().unwrap_or_default()),
+            Kind::String => format!("'{}'", cell.replace('\'', "\\'")),
+        }
+    }
+
+    fn type_name(self) -> &'static str {
+        match self {
+            Kind::Bool => "bool",
+            Kind::Int => "int",
+            Kind::Float => "float",
+            Kind::String => "string",
+        }
+    }
+}
+
+fn parse_bool(cell: &str) -> Option<bool> {
+    match cell.to_ascii_lowercase().as_str() {
+        "true" => Some(true),
+        "false" => Some(false),
+        _ => None,
+    }
+}
+
+struct Column {
+    name: String,
+    kind: Kind,
+}
+
+/// A test splitting rows: `column <= threshold` or `column == value`
+#[derive(Debug, Clone)]
+enum Test {
+    AtMost(usize, f64),
+    Is(usize, String),
+}
+
+enum Node {
+    /// Output, rows reaching the leaf, rows among them with that output
+    Leaf(String, usize, usize),
+    Split(Test, Box<Node>, Box<Node>),
+}
+
+/// A leaf of the fitted tree, as a rule
+struct Leaf<'a> {
+    condition: String,
+    output: &'a str,
+    rows: usize,
+    agree: usize,
+}
+
+struct Data {
+    columns: Vec<Column>,
+    rows: Vec<Vec<String>>,
+    /// Index of the output column
+    target: usize,
+}
+
+impl Data {
+    fn number(&self, row: usize, column: usize) -> f64 {
+        self.rows[row][column].parse().unwrap_or_default()
+    }
+
+    fn passes(&self, row: usize, test: &Test) -> bool {
+        match test {
+            Test::AtMost(column, threshold) => self.number(row, *column) <= *threshold,
+            Test::Is(column, value) => {
+                let cell = &self.rows[row][*column];
+                match self.columns[*column].kind {
+                    Kind::Bool => parse_bool(cell) == parse_bool(value),
+                    _ => cell == value,
+                }
+            }
+        }
+    }
+
+    fn leaf(&self, rows: &[usize]) -> Node {
+        let output = self.majority(rows);
+        let agree = rows
+            .iter()
+            .filter(|&&r| self.rows[r][self.target] == output)
+            .count();
+        Node::Leaf(output, rows.len(), agree)
+    }
+
+    /// Most common output among rows; ties go to the value sorting first
+    fn majority(&self, rows: &[usize]) -> String {
+        let mut counts: BTreeMap<&str, usize> = BTreeMap::new();
+        for &row in rows {
+            *counts.entry(&self.rows[row][self.target]).or_default() += 1;
+        }
+        let mut best = ("", 0);
+        for (value, count) in counts {
+            if count > best.1 {
+                best = (value, count);
+            }
+        }
+        best.0.to_string()
+    }
+
+    fn gini(&self, rows: &[usize]) -> f64 {
+        let mut counts: BTreeMap<&str, usize> = BTreeMap::new();
+        for &row in rows {
+            *counts.entry(&self.rows[row][self.target]).or_default() += 1;
+        }
+        let n = rows.len() as f64;
+        1.0 - counts
+            .values()
+            .map(|&c| (c as f64 / n).powi(2))
+            .sum::<f64>()
+    }
+
+    fn candidates(&self, rows: &[usize]) -> Vec<Test> {
+        let mut tests = Vec::new();
+        for (i, column) in self.columns.iter().enumerate() {
+            if i == self.target {
+                continue;
+            }
+            if column.kind.numeric() {
+                let mut values: Vec<f64> = rows.iter().map(|&r| self.number(r, i)).collect();
+                values.sort_by(|a, b| a.total_cmp(b));
+                values.dedup();
+                values.pop();
+                tests.extend(values.into_iter().map(|v| Test::AtMost(i, v)));
+            } else {
+                let values: BTreeSet<&str> =
+                    rows.iter().map(|&r| self.rows[r][i].as_str()).collect();
+                if values.len() > 1 {
+                    tests.extend(values.into_iter().map(|v| Test::Is(i, v.to_string())));
+                }
+            }
+        }
+        tests
+    }
+
+    fn grow(&self, rows: Vec<usize>, depth: usize, options: &InferOptions) -> Node {
+        let impurity = self.gini(&rows);
+        if impurity == 0.0 || depth == options.max_depth {
+            return self.leaf(&rows);
+        }
+        let mut best: Option<(f64, Test, Vec<usize>, Vec<usize>)> = None;
+        for test in self.candidates(&rows) {
+            let (yes, no): (Vec<usize>, Vec<usize>) =
+                rows.iter().partition(|&&r| self.passes(r, &test));
+            if yes.len() < options.min_rows || no.len() < options.min_rows {
+                continue;
+            }
+            let score = (yes.len() as f64 * self.gini(&yes) + no.len() as f64 * self.gini(&no))
+                / rows.len() as f64;
+            if best
+                .as_ref()
+                .map_or(score < impurity - 1e-12, |b| score < b.0 - 1e-12)
+            {
+                best = Some((score, test, yes, no));
+            }
+        }
+        match best {
+            Some((_, test, yes, no)) => Node::Split(
+                test,
+                Box::new(self.grow(yes, depth + 1, options)),
+                Box::new(self.grow(no, depth + 1, options)),
+            ),
+            None => self.leaf(&rows),
+        }
+    }
+
+    /// Leaves with the condition of their path, yes branches first
+    fn leaves<'a>(&self, node: &'a Node, path: &mut Vec<(Test, bool)>, out: &mut Vec<Leaf<'a>>) {
+        match node {
+            Node::Leaf(output, rows, agree) => out.push(Leaf {
+                condition: self.condition(path),
+                output,
+                rows: *rows,
+                agree: *agree,
+            }),
+            Node::Split(test, yes, no) => {
+                path.push((test.clone(), true));
+                self.leaves(yes, path, out);
+                path.last_mut().unwrap().1 = false;
+                self.leaves(no, path, out);
+                path.pop();
+            }
+        }
+    }
+
+    /// CEL for a path, keeping only the tightest bound per column
+    fn condition(&self, path: &[(Test, bool)]) -> String {
+        let mut parts = Vec::new();
+        for (i, column) in self.columns.iter().enumerate() {
+            let name = &column.name;
+            let kind = column.kind;
+            if kind.numeric() {
+                let at_most = path
+                    .iter()
+                    .filter_map(|(t, holds)| match t {
+                        Test::AtMost(c, v) if *c == i && *holds => Some(*v),
+                        _ => None,
+                    })
+                    .reduce(f64::min);
+                let above = path
+                    .iter()
+                    .filter_map(|(t, holds)| match t {
+                        Test::AtMost(c, v) if *c == i && !*holds => Some(*v),
+                        _ => None,
+                    })
+                    .reduce(f64::max);
+                let number = |v: f64| kind.literal(&v.to_string());
+                if let Some(v) = above {
+                    parts.push(format!("{} > {}", name, number(v)));
+                }
+                if let Some(v) = at_most {
+                    parts.push(format!("{} <= {}", name, number(v)));
+                }
+                continue;
+            }
+            let is = path.iter().find_map(|(t, holds)| match t {
+                Test::Is(c, v) if *c == i && *holds => Some(v),
+                _ => None,
+            });
+            match (is, kind) {
+                (Some(v), Kind::Bool) if parse_bool(v) == Some(true) => parts.push(name.clone()),
+                (Some(_), Kind::Bool) => parts.push(format!("!{}", name)),
+                (Some(v), _) => parts.push(format!("{} == {}", name, kind.literal(v))),
+                (None, _) => {
+                    for (t, holds) in path {
+                        if let Test::Is(c, v) = t {
+                            if *c == i && !*holds {
+                                parts.push(match kind {
+                                    Kind::Bool if parse_bool(v) == Some(true) => {
+                                        format!("!{}", name)
+                                    }
+                                    Kind::Bool => name.clone(),
+                                    _ => format!("{} != {}", name, kind.literal(v)),
+                                });
+                            }
+                        }
+                    }
+                }
+            }
+        }
+        parts.join(" && ")
+    }
+}
+
+/// Draft a spec from CSV rows of inputs and the output they led to
+pub fn infer(id: &str, csv: &str, options: &InferOptions) -> Result<Inference> {
+    let mut lines = parse_csv(csv).into_iter();
+    let (_, header) = lines
+        .next()
+        .ok_or_else(|| Error::Other("no header row".into()))?;
+    let records: Vec<(usize, Vec<String>)> = lines.collect();
+    if records.is_empty() {
+        return Err(Error::Other("no data rows".into()));
+    }
+    for (line, record) in &records {
+        if record.len() != header.len() {
+            return Err(Error::Other(format!(
+                "line {}: expected {} fields, found {}",
+                line,
+                header.len(),
+                record.len()
+            )));
+        }
+    }
+    let names: Vec<String> = header.iter().map(|h| field_name(h)).collect();
+    let target = match &options.target {
+        Some(target) => names
+            .iter()
+            .position(|n| n == target || n == &field_name(target))
+            .ok_or_else(|| Error::Other(format!("no column '{}'", target)))?,
+        None => names.len() - 1,
+    };
+    let columns: Vec<Column> = names
+        .into_iter()
+        .enumerate()
+        .map(|(i, name)| Column {
+            name,
+            kind: Kind::of(records.iter().map(move |(_, r)| r[i].as_str())),
+        })
+        .collect();
+    let data = Data {
+        columns,
+        rows: records.iter().map(|(_, r)| r.clone()).collect(),
+        target,
+    };
+
+    let all: Vec<usize> = (0..data.rows.len()).collect();
+    let default = data.majority(&all);
+    let tree = data.grow(all, 0, options);
+    let mut leaves = Vec::new();
+    data.leaves(&tree, &mut Vec::new(), &mut leaves);
+    let rules: Vec<&Leaf> = leaves.iter().filter(|l| l.output != default).collect();
+
+    let yaml = draft_yaml(id, &data, &rules, &default);
+    let spec = Spec::from_yaml(&yaml)?;
+
+    let output = &data.columns[target];
+    let mut mismatches = Vec::new();
+    for (row, (line, record)) in records.iter().enumerate() {
+        let input: Values = data
+            .columns
+            .iter()
+            .enumerate()
+            .filter(|(i, _)| *i != target)
+            .map(|(i, c)| (c.name.clone(), c.kind.value(&record[i])))
+            .collect();
+        let expected = output.kind.value(&data.rows[row][target]);
+        let actual = match evaluate(&spec, &input) {
+            Ok(evaluation) => evaluation.outputs.get(&output.name).map(literal),
+            Err(e) => Some(format!("error ({})", e)),
+        };
+        if actual.as_deref() != Some(literal(&expected).as_str()) {
+            let mut fields: Vec<String> = input
+                .iter()
+                .map(|(name, value)| format!("{}: {}", name, literal(value)))
+                .collect();
+            fields.sort();
+            mismatches.push(Mismatch {
+                line: *line,
+                input: format!("{{{}}}", fields.join(", ")),
+                expected: literal(&expected),
+                actual: actual.unwrap_or_else(|| "nothing".into()),
+            });
+        }
+    }
+
+    Ok(Inference {
+        yaml,
+        rows: records.len(),
+        rules: rules.len(),
+        mismatches,
+    })
+}
+
+fn draft_yaml(id: &str, data: &Data, rules: &[&Leaf], default: &str) -> String {
+    let output = &data.columns[data.target];
+    let value = |cell: &str| match output.kind {
+        Kind::String => serde_json::to_string(cell).unwrap_or_default(),
+        kind => kind.literal(cell),
+    };
+
+    let mut yaml = format!(
+        "# Drafted by `imacs infer` from {} example(s); review before use\nid: {}\n\ninputs:\n",
+        data.rows.len(),
+        id
+    );
+    for (i, column) in data.columns.iter().enumerate() {
+        if i == data.target {
+            continue;
+        }
+        yaml.push_str(&format!(
+            "  - name: {}\n    type: {}\n",
+            column.name,
+            column.kind.type_name()
+        ));
+        if column.kind == Kind::String {
+            let values: BTreeSet<&str> = data.rows.iter().map(|r| r[i].as_str()).collect();
+            if values.len() <= MAX_DECLARED_VALUES {
+                let values: Vec<String> = values
+                    .into_iter()
+                    .map(|v| serde_json::to_string(v).unwrap_or_default())
+                    .collect();
+                yaml.push_str(&format!("    values: [{}]\n", values.join(", ")));
+            }
+        }
+    }
+    yaml.push_str(&format!(
+        "\noutputs:\n  - name: {}\n    type: {}\n\nrules:{}\n",
+        output.name,
+        output.kind.type_name(),
+        if rules.is_empty() { " []" } else { "" }
+    ));
+    for (n, rule) in rules.iter().enumerate() {
+        yaml.push_str(&format!(
+            "  - id: R{}\n    when: {}\n    then: {}\n    description: \"Fits {} of the {} matching examples\"\n",
+            n + 1,
+            serde_json::to_string(&rule.condition).unwrap_or_default(),
+            value(rule.output),
+            rule.agree,
+            rule.rows
+        ));
+    }
+    yaml.push_str(&format!("\ndefault: {}\n", value(default)));
+    yaml
+}
+
+/// A CSV header as a field name: `Weight (kg)` → `weight_kg`,
+/// `MemberTier` → `member_tier`
+fn field_name(header: &str) -> String {
+    let words: Vec<String> = header
+        .split(|c: char| !c.is_ascii_alphanumeric())
+        .filter(|w| !w.is_empty())
+        .map(|w| {
+            if w.chars().any(|c| c.is_ascii_lowercase()) {
+                crate::util::to_snake_case(w)
+            } else {
+                w.to_ascii_lowercase()
+            }
+        })
+        .collect();
+    let name = words.join("_");
+    if name.starts_with(|c: char| c.is_ascii_digit()) {
+        format!("f_{}", name)
+    } else {
+        name
+    }
+}
+
+/// Records of a CSV (RFC 4180 quoting) with the line each starts on;
+/// blank lines are skipped
+fn parse_csv(text: &str) -> Vec<(usize, Vec<String>)> {
+    let mut records = Vec::new();
+    let mut record = Vec::new();
+    let mut field = String::new();
+    let mut quoted = false;
+    let mut line = 1;
+    let mut start = 1;
+    let mut chars = text.chars().peekable();
+    while let Some(c) = chars.next() {
+        if c == '\n' {
+            line += 1;
+        }
+        match c {
+            '"' if quoted && chars.peek() == Some(&'"') => {
+                field.push('"');
+                chars.next();
+            }
+            '"' => quoted = !quoted,
+            _ if quoted => field.push(c),
+            ',' => record.push(std::mem::take(&mut field).trim().to_string()),
+            '\n' => {
+                record.push(std::mem::take(&mut field).trim().to_string());
+                if record.iter().any(|f| !f.is_empty()) {
+                    records.push((start, std::mem::take(&mut record)));
+                }
+                record.clear();
+                start = line;
+            }
+            '\r' => {}
+            _ => field.push(c),
+        }
+    }
+    record.push(field.trim().to_string());
+    if record.iter().any(|f| !f.is_empty()) {
+        records.push((start, record));
+    }
+    records
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const DECISIONS: &str = "Weight (kg),zone,express,rate
+1.5,domestic,false,0
+3.0,domestic,false,0
+2.0,domestic,true,300
+4.0,domestic,true,300
+1.0,eu,false,500
+8.0,eu,false,500
+12.0,eu,false,2000
+15.0,domestic,false,2000
+20.0,eu,true,2000
+";
+
+    #[test]
+    fn test_infer_decision_table() {
+        let inference = infer("shipping_rate", DECISIONS, &InferOptions::default()).unwrap();
+        assert!(inference.mismatches.is_empty(), "{}", inference.to_report());
+        let spec = Spec::from_yaml(&inference.yaml).unwrap();
+        let names: Vec<&str> = spec.inputs.iter().map(|i| i.name.as_str()).collect();
+        assert_eq!(names, vec!["weight_kg", "zone", "express"]);
+        assert_eq!(spec.outputs[0].name, "rate");
+        assert!(inference
+            .yaml
+            .contains("when: \"weight_kg <= 8.0 && zone == 'domestic' && !express\""));
+        assert!(inference.yaml.contains("zone != 'domestic'"));
+        assert!(inference.yaml.contains("default: 2000"));
+        assert_eq!(inference.rows, 9);
+    }
+
+    #[test]
+    fn test_infer_reports_residual_mismatches() {
+        let options = InferOptions {
+            max_depth: 1,
+            ..InferOptions::default()
+        };
+        let inference = infer("shipping_rate", DECISIONS, &options).unwrap();
+        assert!(!inference.mismatches.is_empty());
+        let first = &inference.mismatches[0];
+        assert_eq!(first.line, 4);
+        assert!(inference
+            .to_report()
+            .contains("line 4: {express: true, weight_kg: 2.0, zone: \"domestic\"} expected 300"));
+    }
+
+    #[test]
+    fn test_parse_csv() {
+        let records = parse_csv("a,b\n\n\"x, \"\"y\"\"\",2\r\n");
+        assert_eq!(
+            records,
+            vec![
+                (1, vec!["a".to_string(), "b".to_string()]),
+                (3, vec!["x, \"y\"".to_string(), "2".to_string()]),
+            ]
+        );
+        assert_eq!(field_name("Weight (kg)"), "weight_kg");
+        assert_eq!(field_name("MemberTier"), "member_tier");
+        assert_eq!(field_name("ZONE"), "zone");
+        assert!(infer("x", "a,b\n1\n", &InferOptions::default()).is_err());
+    }
+}
@@ -0,0 +1,272 @@
+//! Spec templates (`imacs instantiate`)
+//!
+//! A template is a spec whose constants are parameters, so regional or
+//! per-deployment variants share their rules and differ only in values:
+//!
+//! ```yaml
+//! id: shipping_rate_${region}
+//! params:
+//!   - name: region
+//!     type: string
+//!   - name: base_rate
+//!     type: float
+//!   - name: heavy_rate
+//!     type: float
+//!     default: 25.0
+//! rules:
+//!   - id: HEAVY
+//!     when: "weight_kg > 20.0"
+//!     then: ${heavy_rate}
+//! default: ${base_rate}
+//! ```
+//!
+//! A value that is exactly `${name}` becomes the parameter itself, typed;
+//! inside longer text (ids, expressions) the parameter is spliced in as
+//! text, so string parameters in expressions need their own quotes
+//! (`"zone == '${zone}'"`). Quotes and backslashes in a spliced string are
+//! escaped, so its value cannot end the literal and add to the expression.
+
+use crate::error::{Error, Result};
+use crate::spec::{ConditionValue, Spec, Variable};
+use regex::Regex;
+use serde_norway::Value;
+use std::collections::BTreeMap;
+
+/// Parameter values by name, as read from a params file
+pub type Params = BTreeMap<String, ConditionValue>;
+
+/// Parameters a template declares
+pub fn declared(template: &str) -> Result<Vec<Variable>> {
+    let (_, params) = split(template)?;
+    Ok(params)
+}
+
+/// The spec a template stands for with `values` filled in
+///
+/// Every declared parameter needs a value of its type or a default, and
+/// every `${name}` must be declared.
+pub fn instantiate(template: &str, values: &Params) -> Result<Spec> {
+    let (mut body, params) = split(template)?;
+
+    let mut errors = Vec::new();
+    for name in values.keys() {
+        if !params.iter().any(|p| &p.name == name) {
+            errors.push(format!("'{}' is not a parameter of the template", name));
+        }
+    }
+    let mut resolved = BTreeMap::new();
+    for param in &params {
+        match values.get(&param.name).or(param.default.as_ref()) {
+            Some(value) if value.is_valid_for(&param.typ) => {
+                resolved.insert(param.name.as_str(), value.clone());
+            }
+            Some(value) => errors.push(format!(
+                "parameter '{}' takes a {}, not {}",
+                param.name,
+                crate::compat::type_name(&param.typ),
+                value
+            )),
+            None => errors.push(format!("parameter '{}' has no value", param.name)),
+        }
+    }
+    if errors.is_empty() {
+        substitute(&mut body, &resolved, &mut errors);
+    }
+    if !errors.is_empty() {
+        errors.sort();
+        errors.dedup();
+        return Err(Error::SpecParse(format!("Template: {}", errors.join("; "))));
+    }
+
+    let spec: Spec = serde_norway::from_value(body).map_err(|e| Error::SpecParse(e.to_string()))?;
+    Spec::from_yaml(&spec.to_yaml()?)
+}
+
+/// The template without its `params:`, and the parameters it declares
+fn split(template: &str) -> Result<(Value, Vec<Variable>)> {
+    let mut body: Value =
+        serde_norway::from_str(template).map_err(|e| Error::SpecParse(e.to_string()))?;
+    let params = match body.as_mapping_mut().and_then(|m| m.remove("params")) {
+        Some(params) => {
+            serde_norway::from_value(params).map_err(|e| Error::SpecParse(e.to_string()))?
+        }
+        None => Vec::new(),
+    };
+    Ok((body, params))
+}
+
+/// Replace `${name}` references in every string of `value`
+pub(crate) fn substitute(
+    value: &mut Value,
+    params: &BTreeMap<&str, ConditionValue>,
+    errors: &mut Vec<String>,
+) {
+    let reference = Regex::new(r"\$\{\s*([A-Za-z_]\w*)\s*\}").expect("valid regex");
+    match value {
+        Value::String(text) => {
+            let whole = reference
+                .captures(text)
+                .filter(|caps| caps[0].len() == text.len())
+                .map(|caps| caps[1].to_string());
+            if let Some(name) = whole {
+                match params.get(name.as_str()) {
+                    Some(param) => {
+                        *value = serde_norway::to_value(param).unwrap_or(Value::Null);
+                    }
+                    None => errors.push(format!("'{}' is not a declared parameter", name)),
+                }
+                return;
+            }
+            let spliced =
+                reference.replace_all(text, |caps: &regex::Captures| match params.get(&caps[1]) {
+                    Some(ConditionValue::String(s)) => escape(s),
+                    Some(ConditionValue::Float(f)) => format!("{:?}", f),
+                    Some(scalar @ (ConditionValue::Int(_) | ConditionValue::Bool(_))) => {
+                        scalar.to_string()
+                    }
+                    Some(_) => {
+                        errors.push(format!(
+                            "parameter '{}' is a list or map, which cannot be part of a text",
+                            &caps[1]
+                        ));
+                        caps[0].to_string()
+                    }
+                    None => {
+                        errors.push(format!("'{}' is not a declared parameter", &caps[1]));
+                        caps[0].to_string()
+                    }
+                });
+            *text = spliced.into_owned();
+        }
+        Value::Sequence(items) => {
+            for item in items {
+                substitute(item, params, errors);
+            }
+        }
+        Value::Mapping(map) => {
+            for (_, item) in map.iter_mut() {
+                substitute(item, params, errors);
+            }
+        }
+        Value::Tagged(tagged) => substitute(&mut tagged.value, params, errors),
+        _ => {}
+    }
+}
+
+/// `text` escaped to stay inside a CEL string literal, whichever quote
+/// opened it
+fn escape(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '\\' | '\'' | '"' => {
+                escaped.push('\\');
+                escaped.push(c);
+            }
+            '\n' => escaped.push_str("\\n"),
+            '\r' => escaped.push_str("\\r"),
+            _ => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const TEMPLATE: &str = r#"
+id: shipping_rate_${region}
+params:
+  - name: region
+    type: string
+  - name: base_rate
+    type: float
+  - name: heavy_kg
+    type: float
+    default: 20.0
+  - name: heavy_rate
+    type: float
+    default: 25.0
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > ${heavy_kg}"
+    then: ${heavy_rate}
+default: ${base_rate}
+"#;
+
+    fn params(yaml: &str) -> Params {
+        serde_norway::from_str(yaml).unwrap()
+    }
+
+    #[test]
+    fn test_instantiate() {
+        let spec = instantiate(TEMPLATE, &params("region: eu\nbase_rate: 5.0\n")).unwrap();
+        assert_eq!(spec.id, "shipping_rate_eu");
+        assert_eq!(spec.rules[0].as_cel().as_deref(), Some("weight_kg > 20.0"));
+        assert_eq!(
+            spec.rules[0].then,
+            crate::spec::Output::Single(ConditionValue::Float(25.0))
+        );
+        assert_eq!(
+            spec.default,
+            Some(crate::spec::Output::Single(ConditionValue::Float(5.0)))
+        );
+        assert!(spec.validate().is_empty());
+        assert!(!spec.to_yaml().unwrap().contains("params"));
+
+        let names: Vec<_> = declared(TEMPLATE)
+            .unwrap()
+            .into_iter()
+            .map(|p| p.name)
+            .collect();
+        assert_eq!(names, vec!["region", "base_rate", "heavy_kg", "heavy_rate"]);
+    }
+
+    #[test]
+    fn test_instantiate_escapes_spliced_strings() {
+        let template = TEMPLATE.replace(
+            "weight_kg > ${heavy_kg}",
+            "weight_kg > ${heavy_kg} && region == '${region}'",
+        );
+        let spec = instantiate(
+            &template,
+            &params("region: \"eu' || true || '\"\nbase_rate: 5.0\n"),
+        )
+        .unwrap();
+        assert_eq!(
+            spec.rules[0].as_cel().as_deref(),
+            Some(r"weight_kg > 20.0 && region == 'eu\' || true || \''")
+        );
+    }
+
+    #[test]
+    fn test_instantiate_errors() {
+        let err = instantiate(
+            &format!("{}description: ${{tier}}\n", TEMPLATE),
+            &params("region: eu\nheavy_rate: cheap\nzone: 3\n"),
+        )
+        .unwrap_err()
+        .to_string();
+        assert_eq!(
+            err,
+            "Spec parse error: Template: 'zone' is not a parameter of the template; \
+             parameter 'base_rate' has no value; \
+             parameter 'heavy_rate' takes a float, not \"cheap\""
+        );
+
+        let err = instantiate(
+            &format!("{}description: ${{tier}}\n", TEMPLATE),
+            &params("region: eu\nbase_rate: 5\n"),
+        )
+        .unwrap_err()
+        .to_string();
+        assert!(err.contains("'tier' is not a declared parameter"));
+    }
+}
@@ -0,0 +1,291 @@
+//! Layout of generated files (`layout:` in `.imacs_root` or `config.yaml`)
+//!
+//! Generated code comes out of the templates as one file per spec. These
+//! functions cut it to the layout a project asks for: Go type declarations
+//! in a file of their own, tests inlined into Rust code, and a Markdown
+//! page documenting each spec.
+
+use crate::spec::{Spec, VarType};
+use regex::Regex;
+
+/// Split generated Go into the code and its type declarations
+///
+/// Top-level `type` declarations move, with their doc comments, to a
+/// second file of the same package; each file keeps the imports it uses.
+pub fn split_go_types(code: &str) -> (String, String) {
+    let lines: Vec<&str> = code.lines().collect();
+    let Some(package) = lines.iter().position(|l| l.starts_with("package ")) else {
+        return (code.to_string(), String::new());
+    };
+    let header = &lines[..=package];
+
+    let mut imports: Vec<&str> = Vec::new();
+    let mut body_start = package + 1;
+    if let Some(start) = (package + 1..lines.len()).find(|&i| !lines[i].trim().is_empty()) {
+        if lines[start] == "import (" {
+            let end = (start..lines.len())
+                .find(|&i| lines[i] == ")")
+                .unwrap_or(lines.len() - 1);
+            imports.extend(
+                lines[start + 1..end]
+                    .iter()
+                    .filter(|l| !l.trim().is_empty()),
+            );
+            body_start = end + 1;
+        } else if lines[start].starts_with("import ") {
+            imports.push(lines[start].trim_start_matches("import "));
+            body_start = start + 1;
+        }
+    }
+
+    let (mut rest, mut types): (Vec<&str>, Vec<&str>) = (Vec::new(), Vec::new());
+    let mut i = body_start;
+    while i < lines.len() {
+        let line = lines[i];
+        if !line.starts_with("type ") {
+            rest.push(line);
+            i += 1;
+            continue;
+        }
+        // Doc comment lines directly above belong to the declaration
+        let comments = rest
+            .iter()
+            .rev()
+            .take_while(|l| l.starts_with("//") && !l.starts_with("//go:"))
+            .count();
+        types.extend(rest.drain(rest.len() - comments..));
+        let close = if line.ends_with('{') {
+            Some("}")
+        } else if line.ends_with('(') {
+            Some(")")
+        } else {
+            None
+        };
+        let end = close
+            .and_then(|close| (i..lines.len()).find(|&n| lines[n] == close))
+            .unwrap_or(i);
+        types.extend(&lines[i..=end]);
+        types.push("");
+        i = end + 1;
+    }
+    if types.is_empty() {
+        return (code.to_string(), String::new());
+    }
+
+    let file = |body: &[&str]| {
+        let body = collapse_blank_lines(body);
+        let used: Vec<&str> = imports
+            .iter()
+            .copied()
+            .filter(|import| {
+                let name = import_name(import);
+                name == "_"
+                    || Regex::new(&format!(r"\b{}\.", regex::escape(&name)))
+                        .map(|re| re.is_match(&body))
+                        .unwrap_or(true)
+            })
+            .collect();
+        let mut out = header.join("\n");
+        out.push_str("\n\n");
+        if !used.is_empty() {
+            out.push_str("import (\n");
+            for import in used {
+                out.push_str(import);
+                out.push('\n');
+            }
+            out.push_str(")\n\n");
+        }
+        out.push_str(body.trim());
+        out.push('\n');
+        out
+    };
+    (file(&rest), file(&types))
+}
+
+/// Name a Go import is referenced by: its alias, or the last element of
+/// its path (before a major version suffix)
+pub(crate) fn import_name(import: &str) -> String {
+    let import = import.trim();
+    let (alias, path) = match import.split_once(' ') {
+        Some((alias, path)) => (Some(alias), path),
+        None => (None, import),
+    };
+    if let Some(alias) = alias {
+        return alias.to_string();
+    }
+    let mut elements = path.trim_matches('"').rsplit('/');
+    let last = elements.next().unwrap_or_default();
+    let versioned =
+        last.len() > 1 && last.starts_with('v') && last[1..].chars().all(|c| c.is_ascii_digit());
+    if versioned {
+        elements.next().unwrap_or(last).to_string()
+    } else {
+        last.to_string()
+    }
+}
+
+fn collapse_blank_lines(lines: &[&str]) -> String {
+    let mut out = String::new();
+    let mut blank = false;
+    for line in lines {
+        if line.trim().is_empty() {
+            if !blank && !out.is_empty() {
+                out.push('\n');
+            }
+            blank = true;
+            continue;
+        }
+        blank = false;
+        out.push_str(line);
+        out.push('\n');
+    }
+    out
+}
+
+/// Append generated Rust tests to the code they test; they are a
+/// `#[cfg(test)]` module using `super::*`, so they work inline
+pub fn inline_tests(code: &str, tests: &str) -> String {
+    format!("{}\n{}", code.trim_end(), tests)
+}
+
+/// Markdown page documenting a spec: its inputs, outputs and rules
+pub fn spec_docs(spec: &Spec) -> String {
+    let mut out = format!("# {}\n\n", spec.name.as_deref().unwrap_or(&spec.id));
+    if let Some(description) = &spec.description {
+        out.push_str(&format!("{}\n\n", description.trim()));
+    }
+    out.push_str(&format!("Spec `{}`, hash `{}`", spec.id, spec.hash()));
+    if let Some(version) = &spec.meta.version {
+        out.push_str(&format!(", version {}", version));
+    }
+    out.push_str("\n\n");
+
+    for (title, vars) in [("Inputs", &spec.inputs), ("Outputs", &spec.outputs)] {
+        out.push_str(&format!(
+            "## {}\n\n| Name | Type | Description |\n|---|---|---|\n",
+            title
+        ));
+        for var in vars {
+            out.push_str(&format!(
+                "| `{}` | {} | {} |\n",
+                var.name,
+                type_name(&var.typ),
+                cell(var.description.as_deref().unwrap_or_default())
+            ));
+        }
+        out.push('\n');
+    }
+
+    // The ownership column only appears once some rule has an owner
+    let owned = spec.rules.iter().any(|r| r.ownership().is_some());
+    if owned {
+        out.push_str(
+            "## Rules\n\n| Rule | When | Then | Description | Ownership |\n|---|---|---|---|---|\n",
+        );
+    } else {
+        out.push_str("## Rules\n\n| Rule | When | Then | Description |\n|---|---|---|---|\n");
+    }
+    for rule in &spec.rules {
+        out.push_str(&format!(
+            "| {} | `{}` | {} | {} |",
+            rule.id,
+            cell(&rule.as_cel().unwrap_or_else(|| "true".into())),
+            cell(&rule.then.to_string()),
+            cell(rule.description.as_deref().unwrap_or_default())
+        ));
+        if owned {
+            out.push_str(&format!(
+                " {} |",
+                cell(&rule.ownership().unwrap_or_default())
+            ));
+        }
+        out.push('\n');
+    }
+    if let Some(default) = &spec.default {
+        out.push_str(&format!(
+            "\nWhen no rule matches: {}\n",
+            cell(&default.to_string())
+        ));
+    }
+    out
+}
+
+fn type_name(typ: &VarType) -> String {
+    match typ {
+        VarType::Bool => "bool".into(),
+        VarType::Int => "int".into(),
+        VarType::Float => "float".into(),
+        VarType::String => "string".into(),
+        VarType::Enum(values) => format!("enum ({})", values.join(", ")),
+        VarType::List(item) => format!("list of {}", type_name(item)),
+        VarType::Object => "object".into(),
+    }
+}
+
+/// Text safe inside a Markdown table cell
+fn cell(text: &str) -> String {
+    text.replace('|', "\\|").replace('\n', " ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_split_go_types() {
+        let code = "// GENERATED FROM: pricing.yaml\n\npackage pricing\n\nimport (\n\t\"database/sql\"\n\t\"fmt\"\n)\n\n// PricingInput is the input\ntype PricingInput struct {\n\tTier sql.Null[string]\n}\n\ntype PricingRuleID string\n\nfunc Pricing(input PricingInput) int {\n\treturn 1\n}\n\nfunc (id PricingRuleID) String() string {\n\treturn fmt.Sprint(string(id))\n}\n";
+        let (code, types) = split_go_types(code);
+        assert_eq!(
+            types,
+            "// GENERATED FROM: pricing.yaml\n\npackage pricing\n\nimport (\n\t\"database/sql\"\n)\n\n// PricingInput is the input\ntype PricingInput struct {\n\tTier sql.Null[string]\n}\n\ntype PricingRuleID string\n"
+        );
+        assert!(code.starts_with("// GENERATED FROM: pricing.yaml\n\npackage pricing\n\nimport (\n\t\"fmt\"\n)\n\nfunc Pricing("));
+        assert!(!code.contains("type "));
+
+        let plain = "package pricing\n\nfunc Pricing() int {\n\treturn 1\n}\n";
+        assert_eq!(split_go_types(plain), (plain.to_string(), String::new()));
+        assert_eq!(import_name("\"math/rand/v2\""), "rand");
+        assert_eq!(import_name("_ \"embed\""), "_");
+    }
+
+    #[test]
+    fn test_spec_docs() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+name: Shipping rate
+inputs:
+  - name: zone
+    type: string
+    description: Destination zone
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "zone == 'domestic' || zone == 'eu'"
+    then: 500
+default: 4000
+"#,
+        )
+        .unwrap();
+        let docs = spec_docs(&spec);
+        assert!(docs.starts_with("# Shipping rate\n\n"));
+        assert!(docs.contains("| `zone` | string | Destination zone |\n"));
+        assert!(docs.contains("| R1 | `zone == 'domestic' \\|\\| zone == 'eu'` | 500 |  |\n"));
+        assert!(docs.contains("When no rule matches: 4000\n"));
+        assert!(!docs.contains("Ownership"));
+
+        let mut owned = spec.clone();
+        owned.rules[0].owner = Some("pricing-team".into());
+        owned.rules[0].approved_by = Some("j.doe".into());
+        assert!(spec_docs(&owned).contains(
+            "| R1 | `zone == 'domestic' \\|\\| zone == 'eu'` | 500 |  | owner pricing-team, approved by j.doe |\n"
+        ));
+
+        assert_eq!(
+            inline_tests("fn f() {}\n\n", "#[cfg(test)]\nmod t {}\n"),
+            "fn f() {}\n#[cfg(test)]\nmod t {}\n"
+        );
+    }
+}
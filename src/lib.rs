@@ -175,23 +175,60 @@ pub mod ast;
 pub mod cel;
 pub mod config;
 pub mod config_validate;
+pub mod constants;
 pub mod error;
+pub mod geo;
 pub mod meta;
 pub mod project;
 pub mod spec;
+pub mod units;
 pub mod util;
+pub mod yaml;
 
 // Operations (Layer 0: hand-crafted)
+pub mod agent;
 pub mod analyze;
+pub mod assertions;
+pub mod canary;
+pub mod changelog;
+#[cfg(feature = "arrow")]
+pub mod columnar;
+pub mod compat;
+pub mod coverage;
+pub mod deploy;
+pub mod docsite;
 pub mod drift;
 pub mod extract;
+pub mod flow_coverage;
 pub mod format;
+pub mod heatmap;
+pub mod import_go;
+pub mod infer;
+pub mod instantiate;
+pub mod layout;
+pub mod lint;
+pub mod merge;
+pub mod oci;
 pub mod orchestrate;
+pub mod overlay;
 pub mod parse;
+pub mod pkg;
+pub mod proto;
+pub mod prove;
+pub mod registry;
 pub mod render;
+pub mod replay;
+pub mod runtime;
+pub mod scaffold;
+pub mod sensitivity;
+pub mod signing;
+pub mod synthesize;
 pub mod templates;
+pub mod tenants;
 pub mod testgen;
 pub mod testgen_orchestrate;
+pub mod tui;
+pub mod udf;
 pub mod verify;
 
 // Completeness analysis (Phase 5)
@@ -209,12 +246,19 @@ pub use ast::{
 };
 pub use cel::Target;
 pub use cel::{CelCompiler, CelExpr};
+pub use compat::{ApiChange, Bump, CompatReport};
 pub use drift::{compare, Difference, DriftDetector, DriftReport, DriftStatus};
 pub use error::{Error, Result};
 pub use extract::{extract, Confidence, ExtractedSpec, Extractor};
 pub use parse::parse_rust;
+pub use proto::{render_proto, FieldNumbers};
+pub use registry::RegistryClient;
 pub use render::{render, Renderer};
-pub use spec::{Condition, ConditionOp, ConditionValue, Output, Rule, Spec, VarType, Variable};
+pub use runtime::{evaluate, Divergence, Engine, Evaluation, Limits, Shadow, Stats};
+pub use spec::{
+    Condition, ConditionOp, ConditionValue, LocalBinding, Output, Rule, Spec, VarType, Variable,
+};
+pub use tenants::{SpecKey, Tenants};
 pub use testgen::{generate_tests, TestConfig, TestGenerator, TestMode};
 pub use verify::{verify, Coverage, CoverageGap, VerificationResult, Verifier};
 
@@ -276,20 +320,23 @@ pub use completeness::LiteralValue as PredicateLiteralValue;
 // Orchestration
 pub use orchestrate::{
     calculate_complexity, count_steps, render_orchestrator, ChainStep, ComplexityReport,
-    Orchestrator, OrchestratorInput, OrchestratorOutput,
+    Orchestrator, OrchestratorInput, OrchestratorOutput, Scenario, Trigger,
 };
 
 // Template-based code generation
 pub use templates::{
     engine as template_engine, render_orchestrator as render_orchestrator_template,
-    render_spec as render_spec_template, TemplateError,
+    render_spec as render_spec_template, render_spec_parts, render_temporal, TemplateError,
 };
 pub use testgen_orchestrate::{
     generate_orchestrator_tests, verify_orchestrator, OrchestratorTests, OrchestratorVerification,
 };
 
 // Project management
-pub use config::{ImacRoot, LocalConfig, MergedConfig, ProjectConfig, ValidationConfig};
+pub use config::{
+    FileKind, ImacRoot, LayoutConfig, LocalConfig, MergedConfig, PackageRef, ProjectConfig,
+    TestLayout, ValidationConfig,
+};
 pub use meta::{create_meta, find_stale_specs, ImacMeta};
 pub use project::{
     detect_output_conflicts, discover_all_imacs, discover_generated_dir, discover_specs_dir,
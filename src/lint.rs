@@ -0,0 +1,564 @@
+//! Mechanical fixes for `imacs lint --fix`
+//!
+//! Fixes rewrite the spec YAML line by line rather than re-serializing it,
+//! so comments, key order and quoting survive:
+//!
+//! - rules shadowed by an earlier, more general rule are moved above it
+//! - comparison and logical operators in conditions get one space on each
+//!   side (`amount>100&&vip` → `amount > 100 && vip`)
+//! - with `snake_case_fields`, inputs and outputs are renamed to snake_case
+//!   along with every condition, output key and value expression, rule
+//!   binding and assertion that uses them
+//!
+//! A fix whose result does not validate is refused rather than written.
+
+use crate::config::{is_snake_case, ValidationConfig};
+use crate::error::{Error, Result};
+use crate::render::is_expression;
+use crate::spec::Spec;
+use crate::util::to_snake_case;
+use crate::yaml::split_comment;
+use std::collections::{BTreeMap, BTreeSet};
+
+/// Keys whose values are CEL expressions
+const CEL_KEYS: &[&str] = &["when", "given", "expect", "require"];
+
+/// Keys whose values are outputs, literal or computed: a value, or a map of
+/// values by output name
+const VALUE_KEYS: &[&str] = &["then", "default"];
+
+/// Operators normalized to one space on each side, longest first
+const OPERATORS: &[&str] = &["==", "!=", "<=", ">=", "&&", "||", "<", ">"];
+
+/// The fixed YAML and what was changed
+#[derive(Debug, Clone, PartialEq)]
+pub struct LintFix {
+    pub yaml: String,
+    pub applied: Vec<String>,
+}
+
+/// Rules an earlier rule always matches first, with the rule shadowing them
+///
+/// A rule is shadowed when an earlier rule at the same priority needs only
+/// a subset of its conditions and returns something else.
+pub fn shadowed_rules(spec: &Spec) -> Vec<(String, String)> {
+    let conjuncts = |i: usize| -> BTreeSet<String> {
+        spec.rules[i]
+            .conjuncts()
+            .iter()
+            .map(|c| c.split_whitespace().collect())
+            .collect()
+    };
+    let mut shadowed = Vec::new();
+    for j in 0..spec.rules.len() {
+        let later = &spec.rules[j];
+        if !later.state.is_active() {
+            continue;
+        }
+        let later_conjuncts = conjuncts(j);
+        let by = (0..j).find(|&i| {
+            let earlier = &spec.rules[i];
+            let earlier_conjuncts = conjuncts(i);
+            earlier.state.is_active()
+                && earlier.priority == later.priority
+                && earlier.then != later.then
+                && !earlier_conjuncts.is_empty()
+                && earlier_conjuncts.is_subset(&later_conjuncts)
+        });
+        if let Some(i) = by {
+            shadowed.push((later.id.clone(), spec.rules[i].id.clone()));
+        }
+    }
+    shadowed
+}
+
+/// Apply every mechanical fix to a spec's YAML
+pub fn fix(yaml: &str, style: &ValidationConfig) -> Result<LintFix> {
+    let spec = Spec::from_yaml(yaml)?;
+    let mut applied = Vec::new();
+
+    let mut renames = BTreeMap::new();
+    if style.snake_case_fields {
+        let names: Vec<&str> = spec
+            .inputs
+            .iter()
+            .chain(&spec.outputs)
+            .map(|v| v.name.as_str())
+            .collect();
+        for name in &names {
+            let renamed = to_snake_case(&name.replace('-', "_"));
+            if !is_snake_case(name) && is_snake_case(&renamed) && !names.contains(&renamed.as_str())
+            {
+                applied.push(format!("renamed field {} to {}", name, renamed));
+                renames.insert(name.to_string(), renamed);
+            }
+        }
+    }
+
+    let mut normalized = 0;
+    let mut blocks = Blocks::default();
+    let mut lines: Vec<String> = Vec::new();
+    for line in yaml.lines() {
+        let (fixed, changed) = fix_line(line, &renames, &mut blocks);
+        normalized += changed as usize;
+        lines.push(fixed);
+    }
+    if normalized > 0 {
+        applied.push(format!(
+            "normalized operator spacing in {} condition(s)",
+            normalized
+        ));
+    }
+
+    // Move each shadowed rule above the rule that shadows it, one at a time,
+    // since a move can expose or resolve others
+    for _ in 0..spec.rules.len() {
+        let current = Spec::from_yaml(&lines.join("\n"))?;
+        let Some((rule, by)) = shadowed_rules(&current).into_iter().next() else {
+            break;
+        };
+        let Some(moved) = move_rule(&lines, &current, &rule, &by) else {
+            break;
+        };
+        lines = moved;
+        applied.push(format!(
+            "moved rule {} above {}, which shadowed it",
+            rule, by
+        ));
+    }
+
+    let mut fixed = lines.join("\n");
+    if yaml.ends_with('\n') {
+        fixed.push('\n');
+    }
+    let result = Spec::from_yaml(&fixed)
+        .map_err(|e| Error::Other(format!("lint --fix produced an invalid spec: {}", e)))?;
+    let errors: Vec<String> = result
+        .validate()
+        .into_iter()
+        .filter(|m| !m.starts_with("Warning"))
+        .collect();
+    if !errors.is_empty() {
+        return Err(Error::Other(format!(
+            "lint --fix produced an invalid spec: {}",
+            errors.join("; ")
+        )));
+    }
+    Ok(LintFix {
+        yaml: fixed,
+        applied,
+    })
+}
+
+/// Indents of the open blocks whose nested lines hold expressions
+#[derive(Debug, Default)]
+struct Blocks {
+    /// A `when:` list of conditions
+    when_list: Option<usize>,
+    /// A `then:` or `default:` map of output values
+    values: Option<usize>,
+}
+
+/// Fix one line; the flag is set when operator spacing changed
+fn fix_line(line: &str, renames: &BTreeMap<String, String>, blocks: &mut Blocks) -> (String, bool) {
+    let indent = line.len() - line.trim_start().len();
+    let rest = &line[indent..];
+    if rest.is_empty() || rest.starts_with('#') {
+        return (line.to_string(), false);
+    }
+    let (dash, body) = match rest.strip_prefix("- ") {
+        Some(body) => ("- ", body),
+        None => ("", rest),
+    };
+    let (content, comment) = split_comment(body);
+    let prefix = format!("{}{}", &line[..indent], dash);
+
+    // Items of a `when:` list are conditions
+    if let Some(list_indent) = blocks.when_list {
+        if indent > list_indent && !dash.is_empty() {
+            let (cel, changed) = fix_cel(content, renames, true);
+            return (format!("{}{}{}", prefix, cel, comment), changed);
+        }
+        blocks.when_list = None;
+    }
+    let in_values = match blocks.values {
+        Some(block_indent) if indent > block_indent => true,
+        _ => {
+            blocks.values = None;
+            false
+        }
+    };
+
+    let Some((key, value)) = split_key(content) else {
+        return (line.to_string(), false);
+    };
+    let key_out = renames.get(key).map(|k| k.as_str()).unwrap_or(key);
+    // `default:` is an output value only at the top level; inputs declare
+    // literal defaults
+    let value_key = VALUE_KEYS.contains(&key) && (key != "default" || indent == 0);
+    let (value_out, changed) = if CEL_KEYS.contains(&key) {
+        if value.trim().is_empty() && key == "when" {
+            blocks.when_list = Some(indent);
+        }
+        fix_cel(value, renames, true)
+    } else if key == "expr" {
+        // Rule binding
+        fix_cel(value, renames, false)
+    } else if value_key || in_values {
+        if value_key && value.trim().is_empty() {
+            blocks.values = Some(indent);
+        }
+        // Only values evaluated as expressions refer to fields; the spacing
+        // is left alone, since it decides what counts as one
+        let bare = value.trim().trim_matches(|c| c == '"' || c == '\'');
+        if renames.contains_key(bare) || is_expression(bare) {
+            fix_cel(value, renames, false)
+        } else {
+            (value.to_string(), false)
+        }
+    } else if matches!(key, "name" | "input") {
+        let bare = value.trim().trim_matches(|c| c == '"' || c == '\'');
+        let (name, optional) = match bare.strip_suffix('?') {
+            Some(name) => (name, "?"),
+            None => (bare, ""),
+        };
+        match renames.get(name) {
+            Some(renamed) => (format!(" {}{}", renamed, optional), false),
+            None => (value.to_string(), false),
+        }
+    } else {
+        (value.to_string(), false)
+    };
+    (
+        format!("{}{}:{}{}", prefix, key_out, value_out, comment),
+        changed,
+    )
+}
+
+/// Split `key: value` into the key and everything after the colon
+fn split_key(content: &str) -> Option<(&str, &str)> {
+    let colon = content.find(':')?;
+    let key = &content[..colon];
+    let value = &content[colon + 1..];
+    let is_key = !key.is_empty()
+        && key
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '-')
+        && (value.is_empty() || value.starts_with(' '));
+    is_key.then_some((key, value))
+}
+
+/// Rename fields and, with `respace`, normalize operator spacing in a
+/// (possibly quoted) CEL value; the flag is set when operator spacing changed
+fn fix_cel(value: &str, renames: &BTreeMap<String, String>, respace: bool) -> (String, bool) {
+    let lead = &value[..value.len() - value.trim_start().len()];
+    let trimmed = value.trim();
+    let (open, expr, close) = match trimmed.chars().next() {
+        Some(q @ ('"' | '\'')) if trimmed.len() > 1 && trimmed.ends_with(q) => {
+            (&trimmed[..1], &trimmed[1..trimmed.len() - 1], &trimmed[..1])
+        }
+        _ => ("", trimmed, ""),
+    };
+
+    let mut out = String::new();
+    let mut spaced = String::new();
+    let chars: Vec<(usize, char)> = expr.char_indices().collect();
+    let mut i = 0;
+    while i < chars.len() {
+        let (pos, c) = chars[i];
+        if c == '\'' || c == '"' {
+            // String literal, copied as is
+            let mut j = i + 1;
+            while j < chars.len() && chars[j].1 != c {
+                j += if chars[j].1 == '\\' { 2 } else { 1 };
+            }
+            let end = chars.get(j + 1).map(|&(p, _)| p).unwrap_or(expr.len());
+            out.push_str(&expr[pos..end]);
+            spaced.push_str(&expr[pos..end]);
+            i = j + 1;
+        } else if c.is_ascii_alphabetic() || c == '_' {
+            let mut j = i;
+            while j < chars.len() && (chars[j].1.is_ascii_alphanumeric() || chars[j].1 == '_') {
+                j += 1;
+            }
+            let end = chars.get(j).map(|&(p, _)| p).unwrap_or(expr.len());
+            let word = &expr[pos..end];
+            // Fields of other values keep their names, except the outputs
+            // compared in `left.<output>` / `right.<output>`
+            let member = out.trim_end().ends_with('.')
+                && !(out.ends_with("left.") || out.ends_with("right."));
+            let word = match renames.get(word) {
+                Some(renamed) if !member => renamed.as_str(),
+                _ => word,
+            };
+            out.push_str(word);
+            spaced.push_str(word);
+            i = j;
+        } else if let Some(op) = OPERATORS.iter().find(|op| expr[pos..].starts_with(**op)) {
+            i += op.len();
+            while i < chars.len() && chars[i].1.is_whitespace() {
+                i += 1;
+            }
+            let end = chars.get(i).map(|&(p, _)| p).unwrap_or(expr.len());
+            out.push_str(&expr[pos..end]);
+            let trimmed_len = spaced.trim_end().len();
+            spaced.truncate(trimmed_len);
+            spaced.push_str(&format!(" {} ", op));
+        } else {
+            out.push(c);
+            spaced.push(c);
+            i += 1;
+        }
+    }
+    if !respace {
+        return (format!("{}{}{}{}", lead, open, out.trim(), close), false);
+    }
+    let changed = spaced.trim() != out.trim();
+    (
+        format!("{}{}{}{}", lead, open, spaced.trim(), close),
+        changed,
+    )
+}
+
+/// Move the YAML block of `rule` to just before the block of `before`
+fn move_rule(lines: &[String], spec: &Spec, rule: &str, before: &str) -> Option<Vec<String>> {
+    let start = lines.iter().position(|l| l.trim_end() == "rules:")? + 1;
+    let item_indent = lines[start..]
+        .iter()
+        .find(|l| !l.trim().is_empty() && !l.trim_start().starts_with('#'))
+        .map(|l| l.len() - l.trim_start().len())?;
+    let is_item =
+        |l: &str| l.len() - l.trim_start().len() == item_indent && l.trim_start().starts_with("- ");
+
+    let mut end = lines.len();
+    let mut items = Vec::new();
+    for (i, line) in lines.iter().enumerate().skip(start) {
+        let trimmed = line.trim_start();
+        if trimmed.is_empty() || trimmed.starts_with('#') {
+            continue;
+        }
+        let indent = line.len() - trimmed.len();
+        if is_item(line) {
+            items.push(i);
+        } else if indent < item_indent || (indent == item_indent && !trimmed.starts_with('-')) {
+            end = i;
+            break;
+        }
+    }
+    if items.len() != spec.rules.len() {
+        return None;
+    }
+
+    // Comments directly above a rule belong to it
+    let block_start = |mut i: usize| {
+        while i > start && lines[i - 1].trim_start().starts_with('#') {
+            i -= 1;
+        }
+        i
+    };
+    let mut block_end = end;
+    while block_end > start && {
+        let t = lines[block_end - 1].trim();
+        t.is_empty() || t.starts_with('#')
+    } {
+        block_end -= 1;
+    }
+    let bounds: Vec<(usize, usize)> = (0..items.len())
+        .map(|k| {
+            let from = block_start(items[k]);
+            let to = items
+                .get(k + 1)
+                .map(|&n| block_start(n))
+                .unwrap_or(block_end);
+            (from, to)
+        })
+        .collect();
+
+    let index = |id: &str| spec.rules.iter().position(|r| r.id == id);
+    let (moved, target) = (index(rule)?, index(before)?);
+    if target >= moved {
+        return None;
+    }
+    let (from, to) = bounds[moved];
+    let at = bounds[target].0;
+    let mut out: Vec<String> = lines[..at].to_vec();
+    out.extend_from_slice(&lines[from..to]);
+    out.extend_from_slice(&lines[at..from]);
+    out.extend_from_slice(&lines[to..]);
+    Some(out)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"id: discount
+inputs:
+  - name: orderTotal # in cents
+    type: int
+  - name: vip
+    type: bool
+outputs:
+  - name: rate
+    type: int
+rules:
+  # General VIP discount
+  - id: R1
+    when: "vip"
+    then: 10
+  # Big VIP orders get more
+  - id: R2
+    when:
+      - "vip"
+      - "orderTotal>1000"
+    then: 20
+  - id: R3
+    when: "orderTotal>=500&&!vip"
+    then: 5
+default: 0
+"#;
+
+    #[test]
+    fn test_shadowed_rules() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        assert_eq!(
+            shadowed_rules(&spec),
+            vec![("R2".to_string(), "R1".to_string())]
+        );
+    }
+
+    #[test]
+    fn test_fix() {
+        let style = ValidationConfig {
+            snake_case_fields: true,
+            ..Default::default()
+        };
+        let result = fix(SPEC, &style).unwrap();
+        assert_eq!(
+            result.applied,
+            vec![
+                "renamed field orderTotal to order_total",
+                "normalized operator spacing in 2 condition(s)",
+                "moved rule R2 above R1, which shadowed it",
+            ]
+        );
+        assert_eq!(
+            result.yaml,
+            r#"id: discount
+inputs:
+  - name: order_total # in cents
+    type: int
+  - name: vip
+    type: bool
+outputs:
+  - name: rate
+    type: int
+rules:
+  # Big VIP orders get more
+  - id: R2
+    when:
+      - "vip"
+      - "order_total > 1000"
+    then: 20
+  # General VIP discount
+  - id: R1
+    when: "vip"
+    then: 10
+  - id: R3
+    when: "order_total >= 500 && !vip"
+    then: 5
+default: 0
+"#
+        );
+        assert!(shadowed_rules(&Spec::from_yaml(&result.yaml).unwrap()).is_empty());
+
+        // Already clean specs are left alone
+        let again = fix(&result.yaml, &style).unwrap();
+        assert!(again.applied.is_empty());
+        assert_eq!(again.yaml, result.yaml);
+    }
+
+    const FEE: &str = r#"id: fee
+inputs:
+  - name: orderTotal
+    type: float
+  - name: zone
+    type: string
+    default: domestic
+outputs:
+  - name: feeAmount
+    type: float
+  - name: note
+    type: string
+rules:
+  - id: R1
+    when: "zone == 'eu'"
+    vars:
+      - name: base
+        expr: "orderTotal * 0.1"
+    then:
+      feeAmount: "base + orderTotal * 0.01"
+      note: eu
+default:
+  feeAmount: orderTotal
+  note: "standard"
+"#;
+
+    #[test]
+    fn test_fix_renames_output_expressions() {
+        let style = ValidationConfig {
+            snake_case_fields: true,
+            ..Default::default()
+        };
+        let result = fix(FEE, &style).unwrap();
+        assert_eq!(
+            result.yaml,
+            r#"id: fee
+inputs:
+  - name: order_total
+    type: float
+  - name: zone
+    type: string
+    default: domestic
+outputs:
+  - name: fee_amount
+    type: float
+  - name: note
+    type: string
+rules:
+  - id: R1
+    when: "zone == 'eu'"
+    vars:
+      - name: base
+        expr: "order_total * 0.1"
+    then:
+      fee_amount: "base + order_total * 0.01"
+      note: eu
+default:
+  fee_amount: order_total
+  note: "standard"
+"#
+        );
+
+        // A single output's expression
+        let single = SPEC.replace("then: 5\n", "then: \"orderTotal / 100\"\n");
+        let result = fix(&single, &style).unwrap();
+        assert!(result.yaml.contains("    then: \"order_total / 100\"\n"));
+    }
+
+    #[test]
+    fn test_fix_refuses_invalid_result() {
+        let style = ValidationConfig {
+            snake_case_fields: true,
+            ..Default::default()
+        };
+        // Renamed, the input would be shadowed by the rule's binding
+        let yaml = FEE
+            .replace("name: base\n", "name: order_total\n")
+            .replace("\"base + ", "\"order_total + ");
+        let err = fix(&yaml, &style).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("Rule R1 binding 'order_total' shadows an input"));
+    }
+}
@@ -7,13 +7,19 @@
 //!   analyze  - Analyze code complexity
 //!   extract  - Extract spec from code
 //!   drift    - Compare implementations
+//!   sign     - Sign a spec with an ed25519 key
+//!   pkg      - Publish and vendor shared spec packages
+//!   oci      - Package specs as OCI artifacts and pull them
+//!   paths    - List feasible condition paths through rules or flows
+//!   prove    - Prove spec assertions with an SMT solver
+//!   scenarios - Generate flow scenarios meeting gate and branch coverage
 //!   update   - Update to latest version
 
 mod update;
 
 use imacs::*;
 use std::fs;
-use std::path::PathBuf;
+use std::path::{Path, PathBuf};
 use std::process::ExitCode;
 
 fn main() -> ExitCode {
@@ -31,14 +37,47 @@ fn main() -> ExitCode {
         "verify" => cmd_verify(&args[2..]),
         "render" => cmd_render(&args[2..]),
         "test" => cmd_test(&args[2..]),
+        "proto" => cmd_proto(&args[2..]),
+        "catalog" => cmd_catalog(&args[2..]),
+        "temporal" => cmd_temporal(&args[2..]),
+        "udf" => cmd_udf(&args[2..]),
         "analyze" => cmd_analyze(&args[2..]),
         "extract" => cmd_extract(&args[2..]),
+        "infer" => cmd_infer(&args[2..]),
+        "import-go" => cmd_import_go(&args[2..]),
         "drift" => cmd_drift(&args[2..]),
+        "compat" => cmd_compat(&args[2..]),
+        "merge" => cmd_merge(&args[2..]),
+        "overlay" => cmd_overlay(&args[2..]),
+        "instantiate" => cmd_instantiate(&args[2..]),
+        "replay" => cmd_replay(&args[2..]),
+        "canary" => cmd_canary(&args[2..]),
+        "changelog" => cmd_changelog(&args[2..]),
+        "doc" => cmd_doc(&args[2..]),
+        "heatmap" => cmd_heatmap(&args[2..]),
+        "sensitivity" => cmd_sensitivity(&args[2..]),
+        "tui" => cmd_tui(&args[2..]),
+        "keygen" => cmd_keygen(&args[2..]),
+        "sign" => cmd_sign(&args[2..]),
+        "verify-signature" => cmd_verify_signature(&args[2..]),
         "completeness" => cmd_completeness(&args[2..]),
+        "coverage" => cmd_coverage(&args[2..]),
+        "paths" => cmd_paths(&args[2..]),
         "validate" => cmd_validate(&args[2..]),
+        "lint" => cmd_lint(&args[2..]),
+        "assert" => cmd_assert(&args[2..]),
+        "prove" => cmd_prove(&args[2..]),
+        "synthesize" => cmd_synthesize(&args[2..]),
+        "scenarios" => cmd_scenarios(&args[2..]),
+        "plan" => cmd_plan(&args[2..]),
+        "apply" => cmd_apply(&args[2..]),
+        "agent" => cmd_agent(&args[2..]),
+        "pkg" => cmd_pkg(&args[2..]),
+        "oci" => cmd_oci(&args[2..]),
         "config" => cmd_config(&args[2..]),
         "schema" => cmd_schema(&args[2..]),
         "init" => cmd_init(&args[2..]),
+        "new" => cmd_new(&args[2..]),
         "regen" => cmd_regen(),
         "status" => cmd_status(&args[2..]),
         "selfcheck" => cmd_selfcheck(),
@@ -79,16 +118,132 @@ COMMANDS:
     verify <spec.yaml> <code.rs>     Check code implements spec
     render <spec.yaml> [--lang]      Generate code from spec
     test <spec.yaml> [--lang]        Generate tests from spec
+    proto <spec.yaml> [--fields <file>]
+                                      Generate protobuf messages; field numbers persist in
+                                      <spec>.proto-fields.json (or --fields)
+    catalog <spec.yaml>              Write the message catalog (message key -> text) as JSON
+    temporal <flow.yaml>              Generate Temporal workflow and activities (Go) for a flow
+    udf <spec.yaml> [--engine spark|beam]
+                                      Export the spec as a self-contained Python UDF for
+                                      Spark (default) or Beam, with a JSON codec
     analyze <code.rs>                Analyze code complexity
     extract <code.rs>                 Extract spec from code
+    infer --data <decisions.csv> [--target <column>] [--max-depth <n>] [-o <spec.yaml>]
+                                      Draft a spec (thresholds and equality splits) from
+                                      example inputs and outputs, reporting the rows the
+                                      draft does not reproduce
+    import-go <file.go> [--func <name>] [--id <id>] [-o <spec.yaml>]
+                                      Draft a spec from a hand-written Go if/else or
+                                      switch decision function, listing what it could
+                                      not carry over
     drift <code_a.rs> <code_b.rs>    Compare implementations
+    compat <old.yaml> <new.yaml>     Check a spec change for breaking API changes
+                                      (--check-version: require the matching version bump)
+    merge <base.yaml> <ours.yaml> <theirs.yaml> [-o <out.yaml>] [--ours|--theirs]
+                                      Three-way merge matching rules by ID; fails on
+                                      rules changed differently on both sides unless
+                                      told which side wins (as a git merge driver:
+                                      imacs merge %O %A %B -o %A)
+    overlay <overlay.yaml> [-o <out.yaml>]
+                                      Write the spec an overlay stands for: its base with
+                                      the overlay's rules applied at the base's extension
+                                      points
+    instantiate <template.yaml> --params <params.yaml> [-o <out.yaml>]
+                                      Write the spec a template stands for with the
+                                      parameter values of <params.yaml>
+    replay <spec.yaml> <decisions.jsonl> [--accept <rule,...>] [--json]
+                                      Re-decide recorded decisions (input, output, spec
+                                      hash per line) with the spec and fail on changed
+                                      outputs not decided by an accepted rule
+    canary <decisions.jsonl>... --baseline <sel> --canary <sel> [--spec <id>]
+           [--max-shift <points>] [--json]
+                                      Compare rule hit shares of two slices of recorded
+                                      decisions (sel: sha256:<hash>, version:<v> or a
+                                      <from>..<to> time window)
+    changelog <spec.yaml>... [-o <CHANGELOG.md>]
+                                      Release notes for operations: rule additions,
+                                      removals, threshold and result changes across the
+                                      spec's git history, or across the given versions
+                                      (oldest first)
+    heatmap <spec.yaml> <decisions.jsonl>... [-o <heatmap.html>] [--json]
+                                      Decide recorded or simulated inputs with the spec
+                                      and chart how often each rule fires and how inputs
+                                      spread around the thresholds rules compare against
+    sensitivity <spec.yaml> --vary <name> --from <n> --to <n> [--steps <n>]
+                [--input <input.yaml>] [--json]
+                                      Sweep one numeric input or constant across a range
+                                      (other inputs from <input.yaml>) and report the
+                                      outputs and where another rule takes over
+    doc <spec.yaml> [--format markdown|html] [-o <file>]
+                                      Document a spec; html is a standalone page with
+                                      searchable rules, a flow diagram, example decisions
+                                      and a form to try inputs in the browser
+    keygen <name>                    Write an ed25519 key pair to <name>.key and <name>.pub
+    sign <spec.yaml> --key <file>    Sign a spec (embedded; --sidecar writes <spec.yaml>.sig)
+    verify-signature <spec.yaml> --pubkey <file>
+                                      Check a spec's embedded or sidecar signature
+    tui <spec.yaml>                  Browse the rules and try inputs interactively,
+                                      showing the matching rule and outputs live
     completeness <spec.yaml|dir>     Analyze spec(s) for missing cases
                                       Use directory for suite analysis
+    coverage <cover.out> --map <file.rulemap.json|dir>... [--min <percent>] [--json]
+                                      Per-rule coverage from a Go coverage profile, using
+                                      the rule maps regen writes next to generated Go
+    paths <spec.yaml|orchestrator.yaml> [--json]
+                                      List the feasible condition paths through
+                                      the rules or flow (gates, branches, calls)
     validate <spec.yaml> [--strict]  Validate spec for impossible situations
+    lint <spec.yaml>... [--strict] [--fix]
+                                      Check every rule has an owner and approved_by
+                                      (--strict fails instead of warning) and fail on
+                                      contradictory or always-true rule conditions;
+                                      flags shared fields whose values disagree
+                                      across the given specs ('na' vs 'north_america')
+                                      and the style rules under `validation` in
+                                      .imacs_root (rule_id_pattern, snake_case_fields,
+                                      max_conjuncts, max_rules_per_spec); --fix
+                                      reorders shadowed rules, normalizes operator
+                                      spacing and renames fields, keeping comments
+    assert <spec.yaml>...            Check the spec assertions on sampled inputs
+                                      (regen refuses specs that violate them)
+    prove <spec.yaml>...             Prove the spec assertions with an SMT solver
+                                      (z3, or the command in IMACS_SMT_SOLVER)
+    synthesize <spec.yaml> (--rule <id> | --output <predicate>) [--json]
+                                      Find an input the rule decides, or whose outputs
+                                      satisfy the predicate, with the same solver
+    scenarios <orchestrator.yaml> [spec.yaml...] [--criteria gates,branches] [-o file] [--json]
+                                      Generate flow scenarios in which every gate passes and
+                                      fails and every branch is taken, reporting targets
+                                      the solver could not reach and why
+    plan <spec.yaml> --registry <dir> [--records <decisions.jsonl>] [--json]
+                                      Show the API and rule changes against the deployed
+                                      version, the decisions they change, and the token
+                                      that approves the plan
+    apply <spec.yaml> --registry <dir> --approve <token>
+                                      Publish the spec and deploy it, if the plan the
+                                      token approves is still current
+    agent --repo <url> [--branch main] [--path <dir>] [--checkout <dir>] [--interval <secs>] [--listen <addr>]
+                                      Keep runtime engines in step with a branch of specs,
+                                      swapping in each commit's valid specs at once and serving
+                                      GET /status and /healthz
+    pkg publish <dir> --name <name> --version <v> --registry <dir>
+                                      Bundle the specs in <dir> into a package registry
+    pkg add <name> --version <v> --registry <url>|--git <url>
+                                      Vendor a spec package into imacs/packages/ and pin it
+                                      in .imacs_root (for git, <v> is a tag)
+    pkg install                      Reinstall every pinned package, checking its digest
+    oci package <spec.yaml> --output <dir> [--tag <tag>]
+                                      Package a valid spec as an OCI artifact in an image
+                                      layout (tag defaults to meta.version, else latest)
+    oci pull <registry/repo:tag> [--output <file>]
+                                      Pull a packaged spec and write it as YAML
     config check [--json]            Validate .imacs_root and config.yaml files
     config schema [name]             Print JSON schema for config type
     schema [name]                     Print JSON schema for output type
     init [--root]                    Initialize imacs/ folder (--root for project root)
+    new <rule|flow> <id> [--inputs weight_kg:float,zone:enum(eu,us)] [--outputs rate:int]
+        [--specs a,b] [-o <file>]    Write a spec (or flow calling the given specs) skeleton
+                                      with an example rule to <id>.yaml
     regen [--all] [--force] [--clean] Regenerate code from specs (--clean removes orphaned files)
     status [--json]                  Show project status and stale specs
     selfcheck                        Verify IMACS internal generated code (from imacs/) matches
@@ -101,6 +256,10 @@ OPTIONS:
     --full                            Full exhaustive analysis for completeness suite mode
     --strict                          Strict mode: treat warnings as errors (validate command)
 
+ENVIRONMENT:
+    IMACS_ENV=<env>                   Apply the constant overrides in overrides/<env>.yaml
+                                      next to each spec
+
 EXAMPLES:
     imacs verify login.yaml src/login.rs
     imacs render checkout.yaml --lang typescript
@@ -121,10 +280,9 @@ fn cmd_verify(args: &[String]) -> Result<()> {
     let code_path = &args[1];
     let json_output = args.contains(&"--json".to_string());
 
-    let spec_content = fs::read_to_string(spec_path).map_err(Error::Io)?;
     let code_content = fs::read_to_string(code_path).map_err(Error::Io)?;
 
-    let spec = Spec::from_yaml(&spec_content)?;
+    let spec = Spec::from_file(Path::new(spec_path))?;
     let code = parse_rust(&code_content)?;
 
     let result = verify(&spec, &code);
@@ -161,7 +319,17 @@ fn cmd_render(args: &[String]) -> Result<()> {
         orchestrate::render_orchestrator(&orch, &specs, target)
     } else {
         // It's a regular decision table spec
-        let spec = Spec::from_yaml(&spec_content)?;
+        let spec = Spec::from_file(Path::new(spec_path))?;
+        // go:embed reads the spec YAML from next to the code
+        if target == Target::Go && spec.codegen.embed_spec {
+            let dir = output
+                .as_deref()
+                .and_then(Path::parent)
+                .unwrap_or(Path::new(""));
+            let embed_path = dir.join(spec.embed_file_name());
+            fs::write(&embed_path, spec.embed_source()?).map_err(Error::Io)?;
+            eprintln!("Written to: {}", embed_path.display());
+        }
         render(&spec, target)
     };
 
@@ -178,8 +346,7 @@ fn cmd_test(args: &[String]) -> Result<()> {
     let target = parse_target_arg(args);
     let output = parse_output_arg(args);
 
-    let spec_content = fs::read_to_string(spec_path).map_err(Error::Io)?;
-    let spec = Spec::from_yaml(&spec_content)?;
+    let spec = Spec::from_file(Path::new(spec_path))?;
 
     let tests = generate_tests(&spec, target);
 
@@ -187,6 +354,74 @@ fn cmd_test(args: &[String]) -> Result<()> {
     Ok(())
 }
 
+fn cmd_proto(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs proto <spec.yaml> [--fields <file>] [--output <file>]".into());
+    }
+
+    let spec_path = PathBuf::from(&args[0]);
+    let fields_path = parse_value_arg(args, "--fields")
+        .map(PathBuf::from)
+        .unwrap_or_else(|| imacs::proto::fields_path(&spec_path));
+    let output = parse_output_arg(args);
+
+    let spec_content = fs::read_to_string(&spec_path).map_err(Error::Io)?;
+    let spec = Spec::from_yaml(&spec_content)?;
+
+    let mut numbers = FieldNumbers::load(&fields_path)?;
+    let proto = render_proto(&spec, &mut numbers);
+    numbers.save(&fields_path)?;
+
+    write_output(&output, &proto)?;
+    Ok(())
+}
+
+fn cmd_catalog(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs catalog <spec.yaml> [--output <file>]".into());
+    }
+
+    let output = parse_output_arg(args);
+    let spec_content = fs::read_to_string(&args[0]).map_err(Error::Io)?;
+    let spec = Spec::from_yaml(&spec_content)?;
+    let json = serde_json::to_string_pretty(&spec.message_catalog())?;
+
+    write_output(&output, &json)?;
+    Ok(())
+}
+
+fn cmd_temporal(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs temporal <flow.yaml> [--output <file>]".into());
+    }
+
+    let output = parse_output_arg(args);
+    let content = fs::read_to_string(&args[0]).map_err(Error::Io)?;
+    let orch = orchestrate::Orchestrator::from_yaml(&content)?;
+    let code = render_temporal(&orch, &std::collections::HashMap::new(), true)
+        .map_err(|e| Error::Other(e.to_string()))?;
+
+    write_output(&output, &code)?;
+    Ok(())
+}
+
+fn cmd_udf(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs udf <spec.yaml> [--engine spark|beam] [--output <file>]".into());
+    }
+
+    let engine = match parse_value_arg(args, "--engine") {
+        Some(name) => name.parse()?,
+        None => imacs::udf::Engine::Spark,
+    };
+    let output = parse_output_arg(args);
+    let spec = Spec::from_file(Path::new(&args[0]))?;
+    let code = imacs::udf::render_udf(&spec, engine)?;
+
+    write_output(&output, &code)?;
+    Ok(())
+}
+
 fn cmd_analyze(args: &[String]) -> Result<()> {
     if args.is_empty() {
         return Err("Usage: imacs analyze <code.rs>".into());
@@ -232,6 +467,348 @@ fn cmd_extract(args: &[String]) -> Result<()> {
     Ok(())
 }
 
+fn cmd_infer(args: &[String]) -> Result<()> {
+    let data = parse_value_arg(args, "--data").ok_or(
+        "Usage: imacs infer --data <decisions.csv> [--target <column>] [--id <id>] [--max-depth <n>] [-o <spec.yaml>] [--json]",
+    )?;
+    let json_output = args.contains(&"--json".to_string());
+    let mut options = imacs::infer::InferOptions {
+        target: parse_value_arg(args, "--target").cloned(),
+        ..Default::default()
+    };
+    if let Some(depth) = parse_value_arg(args, "--max-depth") {
+        options.max_depth = depth
+            .parse()
+            .map_err(|_| format!("--max-depth: '{}' is not a number", depth))?;
+    }
+    let id = match parse_value_arg(args, "--id") {
+        Some(id) => id.clone(),
+        None => std::path::Path::new(data)
+            .file_stem()
+            .map(|s| s.to_string_lossy().into_owned())
+            .unwrap_or_else(|| "inferred".to_string()),
+    };
+
+    let csv = fs::read_to_string(data).map_err(Error::Io)?;
+    let inference = imacs::infer::infer(&id, &csv, &options)?;
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&inference)?);
+    } else {
+        write_output(&parse_output_arg(args), &inference.yaml)?;
+        eprint!("{}", inference.to_report());
+    }
+    Ok(())
+}
+
+fn cmd_import_go(args: &[String]) -> Result<()> {
+    let path = args.first().filter(|a| !a.starts_with('-')).ok_or(
+        "Usage: imacs import-go <file.go> [--func <name>] [--id <id>] [-o <spec.yaml>] [--json]",
+    )?;
+    let json_output = args.contains(&"--json".to_string());
+
+    let source = fs::read_to_string(path).map_err(Error::Io)?;
+    let import = imacs::import_go::import_go(
+        &source,
+        parse_value_arg(args, "--func").map(String::as_str),
+        parse_value_arg(args, "--id").map(String::as_str),
+    )?;
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&import)?);
+    } else {
+        write_output(&parse_output_arg(args), &import.yaml)?;
+        eprint!("{}", import.to_report());
+    }
+    Ok(())
+}
+
+fn cmd_coverage(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs coverage <cover.out> --map <file.rulemap.json|dir>... [--min <percent>] [--json]";
+    let profile_path = args.first().filter(|a| !a.starts_with('-')).ok_or(usage)?;
+    let json_output = args.contains(&"--json".to_string());
+
+    // --map may repeat; a directory contributes every rule map in it
+    let mut maps = Vec::new();
+    for (flag, value) in args.iter().zip(args.iter().skip(1)) {
+        if flag != "--map" {
+            continue;
+        }
+        let path = std::path::Path::new(value);
+        let mut files = Vec::new();
+        if path.is_dir() {
+            for entry in fs::read_dir(path).map_err(Error::Io)? {
+                let file = entry.map_err(Error::Io)?.path();
+                if file
+                    .to_string_lossy()
+                    .ends_with(imacs::coverage::RULE_MAP_SUFFIX)
+                {
+                    files.push(file);
+                }
+            }
+            files.sort();
+        } else {
+            files.push(path.to_path_buf());
+        }
+        for file in files {
+            let json = fs::read_to_string(&file).map_err(Error::Io)?;
+            maps.push(serde_json::from_str::<imacs::coverage::RuleMap>(&json)?);
+        }
+    }
+    if maps.is_empty() {
+        return Err(usage.into());
+    }
+
+    let profile = fs::read_to_string(profile_path).map_err(Error::Io)?;
+    let blocks = imacs::coverage::parse_profile(&profile)?;
+    let report = imacs::coverage::rule_coverage(&maps, &blocks);
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        print!("{}", report.to_report());
+    }
+
+    if let Some(min) = parse_value_arg(args, "--min") {
+        let min: f64 = min
+            .parse()
+            .map_err(|_| format!("--min: '{}' is not a number", min))?;
+        if report.percent() < min {
+            return Err(format!("rule coverage {:.1}% is below {}%", report.percent(), min).into());
+        }
+    }
+    Ok(())
+}
+
+fn cmd_plan(args: &[String]) -> Result<()> {
+    let usage =
+        "Usage: imacs plan <spec.yaml> --registry <dir> [--records <decisions.jsonl>] [--json]";
+    let (Some(spec_path), Some(registry)) = (
+        args.first().filter(|a| !a.starts_with('-')),
+        parse_value_arg(args, "--registry"),
+    ) else {
+        return Err(usage.into());
+    };
+    let yaml = fs::read_to_string(spec_path).map_err(Error::Io)?;
+    let records = parse_value_arg(args, "--records")
+        .map(fs::read_to_string)
+        .transpose()
+        .map_err(Error::Io)?;
+
+    let plan = imacs::deploy::plan(&yaml, Path::new(registry), records.as_deref())?;
+    if args.contains(&"--json".to_string()) {
+        println!("{}", serde_json::to_string_pretty(&plan)?);
+    } else {
+        print!("{}", plan.to_report());
+    }
+    Ok(())
+}
+
+fn cmd_apply(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs apply <spec.yaml> --registry <dir> --approve <token>";
+    let (Some(spec_path), Some(registry), Some(token)) = (
+        args.first().filter(|a| !a.starts_with('-')),
+        parse_value_arg(args, "--registry"),
+        parse_value_arg(args, "--approve"),
+    ) else {
+        return Err(usage.into());
+    };
+    let yaml = fs::read_to_string(spec_path).map_err(Error::Io)?;
+
+    let plan = imacs::deploy::apply(&yaml, Path::new(registry), token)?;
+    if plan.has_changes() {
+        println!(
+            "Deployed {} {} ({})",
+            plan.spec, plan.release.version, plan.release.digest
+        );
+    } else {
+        println!(
+            "No changes: {} {} is deployed",
+            plan.spec, plan.release.version
+        );
+    }
+    Ok(())
+}
+
+fn cmd_agent(args: &[String]) -> Result<()> {
+    let Some(repo) = parse_value_arg(args, "--repo") else {
+        return Err(
+            "Usage: imacs agent --repo <url> [--branch main] [--path <dir>] \
+                    [--checkout <dir>] [--interval <secs>] [--listen <addr>]"
+                .into(),
+        );
+    };
+    let branch = parse_value_arg(args, "--branch").map_or("main", |b| b.as_str());
+    // Without --checkout the working copy is a fresh private directory, so
+    // no one else can have prepared it
+    let scratch;
+    let checkout = match parse_value_arg(args, "--checkout") {
+        Some(dir) => PathBuf::from(dir),
+        None => {
+            scratch = tempfile::Builder::new()
+                .prefix("imacs-agent-")
+                .tempdir()
+                .map_err(Error::Io)?;
+            scratch.path().to_path_buf()
+        }
+    };
+    let interval = match parse_value_arg(args, "--interval") {
+        Some(secs) => secs
+            .parse()
+            .map_err(|_| format!("Invalid --interval: {}", secs))?,
+        None => 30,
+    };
+    let listen = parse_value_arg(args, "--listen").map_or("127.0.0.1:8787", |a| a.as_str());
+
+    let mut agent = imacs::agent::Agent::new(repo.as_str(), branch, checkout);
+    if let Some(path) = parse_value_arg(args, "--path") {
+        agent = agent.with_path(path);
+    }
+    let agent = std::sync::Arc::new(agent);
+    let listener = std::net::TcpListener::bind(listen).map_err(Error::Io)?;
+    imacs::agent::serve_status(std::sync::Arc::clone(&agent), listener);
+    eprintln!(
+        "Watching {} ({}), status on http://{}/status",
+        repo, branch, listen
+    );
+    agent.run(
+        std::time::Duration::from_secs(interval),
+        |result| match result {
+            Ok(true) => eprintln!(
+                "✓ applied {}",
+                agent.status().last_applied.unwrap_or_default()
+            ),
+            Ok(false) => {}
+            Err(e) => eprintln!("✗ {}", e),
+        },
+    )
+}
+
+fn cmd_replay(args: &[String]) -> Result<()> {
+    if args.len() < 2 || args[..2].iter().any(|a| a.starts_with('-')) {
+        return Err(
+            "Usage: imacs replay <spec.yaml> <decisions.jsonl> [--accept <rule,...>] [--json]"
+                .into(),
+        );
+    }
+    let json_output = args.contains(&"--json".to_string());
+    let accepted: Vec<String> = parse_value_arg(args, "--accept")
+        .map(|list| {
+            list.split(',')
+                .map(|r| r.trim().to_string())
+                .filter(|r| !r.is_empty())
+                .collect()
+        })
+        .unwrap_or_default();
+
+    let spec = Spec::from_file(Path::new(&args[0]))?;
+    let recording = fs::read_to_string(&args[1]).map_err(Error::Io)?;
+    let report = imacs::replay::replay(&spec, &recording, &accepted);
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        print!("{}", report.to_report(20));
+    }
+
+    if report.passed() {
+        Ok(())
+    } else {
+        Err(format!(
+            "{} regression(s), {} failure(s)",
+            report.regressions.len(),
+            report.failures.len()
+        )
+        .into())
+    }
+}
+
+fn cmd_changelog(args: &[String]) -> Result<()> {
+    let output = parse_output_arg(args);
+    let mut paths = Vec::new();
+    let mut rest = args.iter();
+    while let Some(arg) = rest.next() {
+        if arg == "--output" || arg == "-o" {
+            rest.next();
+        } else {
+            paths.push(arg);
+        }
+    }
+    let revisions = match paths.as_slice() {
+        [] => return Err("Usage: imacs changelog <spec.yaml>... [--output <file>]".into()),
+        [path] => imacs::changelog::git_history(Path::new(path))?,
+        paths => paths
+            .iter()
+            .map(|path| {
+                let spec = Spec::from_yaml(&fs::read_to_string(path).map_err(Error::Io)?)?;
+                Ok(imacs::changelog::Revision::new(spec, path, None))
+            })
+            .collect::<Result<Vec<_>>>()?,
+    };
+    write_output(&output, &imacs::changelog::render(&revisions))
+}
+
+fn cmd_canary(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs canary <decisions.jsonl>... --baseline <selector> --canary <selector> [--spec <id>] [--max-shift <points>] [--json]";
+    let files: Vec<&String> = args.iter().take_while(|a| !a.starts_with('-')).collect();
+    let (Some(baseline), Some(canary)) = (
+        parse_value_arg(args, "--baseline"),
+        parse_value_arg(args, "--canary"),
+    ) else {
+        return Err(usage.into());
+    };
+    if files.is_empty() {
+        return Err(usage.into());
+    }
+    let baseline = imacs::canary::Selector::parse(baseline)?;
+    let canary = imacs::canary::Selector::parse(canary)?;
+    let max_shift = parse_value_arg(args, "--max-shift")
+        .map(|m| {
+            m.parse::<f64>()
+                .map_err(|_| format!("--max-shift: '{}' is not a number", m))
+        })
+        .transpose()?;
+    let json_output = args.contains(&"--json".to_string());
+
+    let mut recording = String::new();
+    for file in files {
+        recording.push_str(&fs::read_to_string(file).map_err(Error::Io)?);
+        if !recording.ends_with('\n') {
+            recording.push('\n');
+        }
+    }
+    let shift = imacs::canary::hit_shift(
+        &recording,
+        parse_value_arg(args, "--spec").map(String::as_str),
+        &baseline,
+        &canary,
+    );
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&shift)?);
+    } else {
+        print!("{}", shift.to_report(max_shift.unwrap_or(5.0)));
+    }
+
+    if shift.baseline == 0 || shift.canary == 0 {
+        return Err(format!(
+            "no decisions in the {} slice",
+            if shift.baseline == 0 {
+                "baseline"
+            } else {
+                "canary"
+            }
+        )
+        .into());
+    }
+    if let Some(max) = max_shift {
+        if shift.max_shift() > max {
+            return Err(format!(
+                "rule hit share shifted {:.1} points (max {})",
+                shift.max_shift(),
+                max
+            )
+            .into());
+        }
+    }
+    Ok(())
+}
+
 fn cmd_drift(args: &[String]) -> Result<()> {
     if args.len() < 2 {
         return Err("Usage: imacs drift <code_a.rs> <code_b.rs>".into());
@@ -262,6 +839,258 @@ fn cmd_drift(args: &[String]) -> Result<()> {
     }
 }
 
+fn cmd_compat(args: &[String]) -> Result<()> {
+    if args.len() < 2 {
+        return Err("Usage: imacs compat <old.yaml> <new.yaml> [--json] [--check-version]".into());
+    }
+
+    let json_output = args.contains(&"--json".to_string());
+    let check_version = args.contains(&"--check-version".to_string());
+    let old = Spec::from_yaml(&fs::read_to_string(&args[0]).map_err(Error::Io)?)?;
+    let new = Spec::from_yaml(&fs::read_to_string(&args[1]).map_err(Error::Io)?)?;
+
+    let report = imacs::compat::compare(&old, &new);
+
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        println!("{}", report.to_report());
+        let suggested = old
+            .meta
+            .version
+            .as_deref()
+            .and_then(|v| imacs::compat::bump_version(v, report.required_bump()));
+        if let Some(suggested) = suggested {
+            println!("Suggested version: {}", suggested);
+        }
+    }
+
+    if check_version {
+        imacs::compat::check_version(&old, &new, &report)?;
+    } else if report.is_breaking() {
+        Err("Breaking API changes".into())
+    } else {
+        Ok(())
+    }
+}
+
+fn cmd_merge(args: &[String]) -> Result<()> {
+    if args.len() < 3 {
+        return Err(
+            "Usage: imacs merge <base.yaml> <ours.yaml> <theirs.yaml> [-o <out.yaml>] [--ours|--theirs]"
+                .into(),
+        );
+    }
+
+    use imacs::merge::{merge, Side};
+    let output = parse_output_arg(args);
+    let prefer = if args.contains(&"--theirs".to_string()) {
+        Side::Theirs
+    } else {
+        Side::Ours
+    };
+    let resolved = args.contains(&"--ours".to_string()) || prefer == Side::Theirs;
+    let contents = args[..3]
+        .iter()
+        .map(|path| fs::read_to_string(path).map_err(Error::Io))
+        .collect::<Result<Vec<_>>>()?;
+    let base = Spec::from_yaml(&contents[0])?;
+    let ours = Spec::from_yaml(&contents[1])?;
+    let theirs = Spec::from_yaml(&contents[2])?;
+
+    let merged = merge(&base, &ours, &theirs, prefer)?;
+    for conflict in &merged.conflicts {
+        eprintln!("CONFLICT {}", conflict);
+    }
+    // Comments come from our side
+    write_output(&output, &merged.spec.to_yaml_preserving(&contents[1])?)?;
+
+    if merged.conflicts.is_empty() || resolved {
+        Ok(())
+    } else {
+        Err(format!(
+            "{} merge conflict(s), resolved with our side; rerun with --ours or --theirs to accept",
+            merged.conflicts.len()
+        )
+        .into())
+    }
+}
+
+fn cmd_overlay(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs overlay <overlay.yaml> [--output <file>]".into());
+    }
+    let spec = Spec::from_file(Path::new(&args[0]))?;
+    write_output(&parse_output_arg(args), &spec.to_yaml()?)
+}
+
+fn cmd_instantiate(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs instantiate <template.yaml> --params <params.yaml> [--output <file>]";
+    let template = args.first().ok_or(usage)?;
+    let params_path = parse_value_arg(args, "--params").ok_or(usage)?;
+    let params: imacs::instantiate::Params =
+        serde_norway::from_str(&fs::read_to_string(&params_path).map_err(Error::Io)?)?;
+    let spec = imacs::instantiate::instantiate(
+        &fs::read_to_string(template).map_err(Error::Io)?,
+        &params,
+    )?;
+    write_output(&parse_output_arg(args), &spec.to_yaml()?)
+}
+
+fn cmd_heatmap(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs heatmap <spec.yaml> <decisions.jsonl>... [--output <file>] [--json]";
+    let output = parse_output_arg(args);
+    let mut paths = Vec::new();
+    let mut rest = args.iter();
+    while let Some(arg) = rest.next() {
+        if arg == "--output" || arg == "-o" {
+            rest.next();
+        } else if !arg.starts_with('-') {
+            paths.push(arg);
+        }
+    }
+    let [spec_path, files @ ..] = paths.as_slice() else {
+        return Err(usage.into());
+    };
+    if files.is_empty() {
+        return Err(usage.into());
+    }
+    let spec = Spec::from_file(Path::new(spec_path))?;
+    let mut recording = String::new();
+    for file in files {
+        recording.push_str(&fs::read_to_string(file).map_err(Error::Io)?);
+        if !recording.ends_with('\n') {
+            recording.push('\n');
+        }
+    }
+
+    let heatmap = imacs::heatmap::heatmap(&spec, &recording);
+    if args.contains(&"--json".to_string()) {
+        write_output(&output, &serde_json::to_string_pretty(&heatmap)?)?;
+    } else {
+        write_output(&output, &heatmap.to_html())?;
+    }
+    for rule in heatmap.never_fired() {
+        eprintln!(
+            "⚠ {} never fired in {} decision(s)",
+            rule, heatmap.decisions
+        );
+    }
+    for threshold in heatmap.thresholds.iter().filter(|t| t.dense) {
+        eprintln!(
+            "⚠ {} (rule {}) sits in a dense region: {:.1}% of the values fall in its bin",
+            threshold.comparison, threshold.rule, threshold.near
+        );
+    }
+    Ok(())
+}
+
+fn cmd_sensitivity(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs sensitivity <spec.yaml> --vary <name> --from <n> --to <n> [--steps <n>] [--input <input.yaml>] [--json]";
+    let path = args.first().filter(|a| !a.starts_with('-')).ok_or(usage)?;
+    let name = parse_value_arg(args, "--vary").ok_or(usage)?;
+    let number = |flag: &str| -> Result<f64> {
+        let value = parse_value_arg(args, flag).ok_or(usage)?;
+        value
+            .parse()
+            .map_err(|_| format!("{}: '{}' is not a number", flag, value).into())
+    };
+    let (from, to) = (number("--from")?, number("--to")?);
+    let steps = match parse_value_arg(args, "--steps") {
+        Some(steps) => steps
+            .parse()
+            .map_err(|_| format!("--steps: '{}' is not a count", steps))?,
+        None => 10,
+    };
+    let input = match parse_value_arg(args, "--input") {
+        Some(file) => serde_norway::from_str(&fs::read_to_string(file).map_err(Error::Io)?)?,
+        None => Default::default(),
+    };
+
+    let sweep = imacs::sensitivity::sweep(Path::new(path), &input, name, from, to, steps)?;
+    if args.contains(&"--json".to_string()) {
+        println!("{}", serde_json::to_string_pretty(&sweep)?);
+    } else {
+        print!("{}", sweep.to_report());
+    }
+    Ok(())
+}
+
+fn cmd_doc(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs doc <spec.yaml> [--format markdown|html] [--output <file>]";
+    let path = args.first().filter(|a| !a.starts_with('-')).ok_or(usage)?;
+    let spec = Spec::from_file(Path::new(path))?;
+    let docs = match parse_value_arg(args, "--format").map(String::as_str) {
+        None | Some("markdown") => imacs::layout::spec_docs(&spec),
+        Some("html") => imacs::docsite::site(&spec),
+        Some(other) => {
+            return Err(format!("--format: '{}' is not markdown or html", other).into());
+        }
+    };
+    write_output(&parse_output_arg(args), &docs)
+}
+
+fn cmd_tui(args: &[String]) -> Result<()> {
+    let path = args.first().ok_or("Usage: imacs tui <spec.yaml>")?;
+    let spec = Spec::from_file(Path::new(path))?;
+    imacs::tui::run(&spec, std::io::stdin().lock(), std::io::stdout().lock())
+}
+
+fn cmd_keygen(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs keygen <name>".into());
+    }
+
+    let name = &args[0];
+    let keys = imacs::signing::generate_keypair()?;
+    fs::write(format!("{}.key", name), format!("{}\n", keys.secret)).map_err(Error::Io)?;
+    fs::write(format!("{}.pub", name), format!("{}\n", keys.public)).map_err(Error::Io)?;
+    eprintln!("Written to: {}.key (keep secret), {}.pub", name, name);
+    Ok(())
+}
+
+fn cmd_sign(args: &[String]) -> Result<()> {
+    let (spec_path, key_path) = match (args.first(), parse_value_arg(args, "--key")) {
+        (Some(spec), Some(key)) => (spec, key),
+        _ => return Err("Usage: imacs sign <spec.yaml> --key <file.key> [--sidecar]".into()),
+    };
+    let sidecar = args.contains(&"--sidecar".to_string());
+
+    let mut content = fs::read_to_string(spec_path).map_err(Error::Io)?;
+    // An embedded signature goes on a line of its own after the content
+    if !sidecar && !content.is_empty() && !content.ends_with('\n') {
+        content.push('\n');
+    }
+    let secret = fs::read_to_string(key_path).map_err(Error::Io)?;
+    let signature = imacs::signing::sign(&content, &secret)?;
+
+    if sidecar {
+        let path = imacs::signing::sidecar_path(spec_path.as_ref());
+        fs::write(&path, format!("{}\n", signature)).map_err(Error::Io)?;
+        eprintln!("Written to: {}", path.display());
+    } else {
+        fs::write(
+            spec_path,
+            imacs::signing::embed_signature(&content, &signature)?,
+        )
+        .map_err(Error::Io)?;
+        eprintln!("Signed: {}", spec_path);
+    }
+    Ok(())
+}
+
+fn cmd_verify_signature(args: &[String]) -> Result<()> {
+    let (spec_path, key_path) = match (args.first(), parse_value_arg(args, "--pubkey")) {
+        (Some(spec), Some(key)) => (spec, key),
+        _ => return Err("Usage: imacs verify-signature <spec.yaml> --pubkey <file.pub>".into()),
+    };
+
+    let public_key = fs::read_to_string(key_path).map_err(Error::Io)?;
+    imacs::signing::verify_file(spec_path.as_ref(), &public_key)?;
+    println!("✓ {}: signature valid", spec_path);
+    Ok(())
+}
+
 fn cmd_completeness(args: &[String]) -> Result<()> {
     // Find the first non-flag argument as the path
     let path = args
@@ -280,8 +1109,7 @@ fn cmd_completeness(args: &[String]) -> Result<()> {
         cmd_completeness_suite(path, json_output, full_mode)
     } else {
         // Single spec mode
-        let spec_content = fs::read_to_string(path).map_err(Error::Io)?;
-        let spec = Spec::from_yaml(&spec_content)?;
+        let spec = Spec::from_file(Path::new(path))?;
         let report = imacs::completeness::analyze_completeness(&spec);
 
         if json_output {
@@ -299,6 +1127,28 @@ fn cmd_completeness(args: &[String]) -> Result<()> {
     }
 }
 
+fn cmd_paths(args: &[String]) -> Result<()> {
+    let path = args
+        .iter()
+        .find(|arg| !arg.starts_with('-'))
+        .ok_or("Usage: imacs paths <spec.yaml|orchestrator.yaml> [--json]")?;
+    let json_output = args.contains(&"--json".to_string());
+
+    let content = fs::read_to_string(path).map_err(Error::Io)?;
+    let report = if content.contains("\nchain:") || content.contains("\nuses:") {
+        imacs::completeness::enumerate_flow_paths(&orchestrate::Orchestrator::from_yaml(&content)?)
+    } else {
+        imacs::completeness::enumerate_spec_paths(&Spec::from_yaml(&content)?)
+    };
+
+    if json_output {
+        println!("{}", serde_json::to_string_pretty(&report)?);
+    } else {
+        print!("{}", report.to_report());
+    }
+    Ok(())
+}
+
 fn cmd_completeness_suite(dir_path: &str, json_output: bool, full_mode: bool) -> Result<()> {
     // Check if directory contains orchestrators
     let dir_result = imacs::completeness::analyze_directory_with_orchestrators(dir_path, full_mode);
@@ -343,7 +1193,7 @@ fn cmd_completeness_suite(dir_path: &str, json_output: bool, full_mode: bool) ->
                     if spec_content.contains("\nchain:") || spec_content.contains("\nuses:") {
                         continue;
                     }
-                    if let Ok(spec) = Spec::from_yaml(&spec_content) {
+                    if let Ok(spec) = Spec::from_file(&path) {
                         let spec_id = path
                             .file_stem()
                             .and_then(|s| s.to_str())
@@ -381,6 +1231,14 @@ fn print_orchestrator_suite_report(
         result.specs_found, result.orchestrators_found
     );
 
+    if !result.flow_cycles.is_empty() {
+        println!("FLOW CYCLES:");
+        for cycle in &result.flow_cycles {
+            println!("  ✗ {}", cycle);
+        }
+        println!();
+    }
+
     // Print orchestrator-specific results
     for orch_result in &result.orchestrator_results {
         println!("ORCHESTRATOR: {}\n", orch_result.orchestrator_id);
@@ -404,6 +1262,13 @@ fn print_orchestrator_suite_report(
             }
         }
 
+        if !orch_result.gate_issues.is_empty() {
+            println!("\n  GATE ISSUES:");
+            for issue in &orch_result.gate_issues {
+                println!("    ⚠ {}", issue);
+            }
+        }
+
         // Print suite analysis for this orchestrator's specs
         if !orch_result.found_specs.is_empty() {
             println!("\n  Suite analysis for referenced specs:");
@@ -717,7 +1582,7 @@ fn cmd_validate(args: &[String]) -> Result<()> {
     let apply_all = args.contains(&"--all".to_string());
 
     let spec_content = fs::read_to_string(spec_path).map_err(Error::Io)?;
-    let mut spec = Spec::from_yaml(&spec_content)?;
+    let mut spec = Spec::from_file(Path::new(spec_path))?;
     let report = imacs::completeness::validate_spec(&spec, strict);
 
     // Apply fixes if requested
@@ -782,8 +1647,8 @@ fn cmd_validate(args: &[String]) -> Result<()> {
                 }
             }
 
-            // Write back to file
-            let new_yaml = spec.to_yaml()?;
+            // Write back to file, keeping the author's comments
+            let new_yaml = spec.to_yaml_preserving(&spec_content)?;
             fs::write(spec_path, new_yaml).map_err(Error::Io)?;
             println!("\n✓ Updated {}", spec_path);
         }
@@ -867,13 +1732,410 @@ fn print_validation_report(report: &imacs::completeness::ValidationReport, spec_
     );
 }
 
+fn cmd_lint(args: &[String]) -> Result<()> {
+    let strict = args.contains(&"--strict".to_string());
+    let apply_fixes = args.contains(&"--fix".to_string());
+    let spec_paths: Vec<&String> = args.iter().filter(|a| !a.starts_with("--")).collect();
+    if spec_paths.is_empty() {
+        return Err("Usage: imacs lint <spec.yaml>... [--strict] [--fix]".into());
+    }
+
+    // Style rules come from the project's .imacs_root, if there is one
+    let current_dir = std::env::current_dir().map_err(Error::Io)?;
+    let style = match imacs::project::find_root(&current_dir)? {
+        Some(dir) => ImacRoot::load_from_dir(&dir)?
+            .map(|root| root.validation)
+            .unwrap_or_default(),
+        None => imacs::config::ValidationConfig::default(),
+    };
+
+    let mut issue_count = 0;
+    let mut style_count = 0;
+    let mut condition_count = 0;
+    let mut specs = Vec::new();
+    for spec_path in spec_paths {
+        let mut content = fs::read_to_string(spec_path).map_err(Error::Io)?;
+        if apply_fixes {
+            let fixed = imacs::lint::fix(&content, &style)?;
+            if fixed.yaml != content {
+                fs::write(spec_path, &fixed.yaml).map_err(Error::Io)?;
+                for change in &fixed.applied {
+                    println!("✓ {}: {}", spec_path, change);
+                }
+                content = fixed.yaml;
+            }
+        }
+        let spec = Spec::from_yaml(&content)?;
+        // Contradictory and always-true conditions are errors even without --strict
+        for issue in imacs::completeness::condition_issues(&spec) {
+            eprintln!("{}: error: {}", spec_path, issue);
+            condition_count += 1;
+        }
+        for issue in spec.ownership_issues() {
+            let level = if strict { "error" } else { "warning" };
+            eprintln!("{}: {}: {}", spec_path, level, issue);
+            issue_count += 1;
+        }
+        for issue in style.style_issues(&spec)? {
+            let level = if strict { "error" } else { "warning" };
+            eprintln!("{}: {}: {}", spec_path, level, issue);
+            style_count += 1;
+        }
+        for (rule, by) in imacs::lint::shadowed_rules(&spec) {
+            let level = if strict { "error" } else { "warning" };
+            eprintln!(
+                "{}: {}: rule {} is shadowed by {}, which matches first (--fix reorders them)",
+                spec_path, level, rule, by
+            );
+            style_count += 1;
+        }
+        specs.push((spec.id.clone(), spec));
+    }
+
+    // Values of fields shared across the linted specs should agree
+    let spec_refs: Vec<(String, &Spec)> = specs.iter().map(|(id, s)| (id.clone(), s)).collect();
+    let mut enum_count = 0;
+    for issue in imacs::completeness::enum_inconsistencies(&spec_refs) {
+        let level = if strict { "error" } else { "warning" };
+        eprintln!("{}: {}", level, issue);
+        eprintln!("  help: {}", issue.suggestion());
+        enum_count += 1;
+    }
+
+    if condition_count > 0 {
+        return Err(format!("{} rule condition issue(s)", condition_count).into());
+    }
+    if strict && issue_count > 0 {
+        return Err(format!("{} rule ownership issue(s)", issue_count).into());
+    }
+    if strict && style_count > 0 {
+        return Err(format!("{} style issue(s)", style_count).into());
+    }
+    if strict && enum_count > 0 {
+        return Err(format!("{} inconsistent shared field(s)", enum_count).into());
+    }
+    if issue_count == 0 {
+        println!("✓ All rules have an owner and approver");
+    }
+    Ok(())
+}
+
+fn cmd_pkg(args: &[String]) -> Result<()> {
+    use imacs::pkg::{self, Bundle};
+
+    match args.first().map(|s| s.as_str()) {
+        Some("publish") => {
+            let usage =
+                "Usage: imacs pkg publish <dir> --name <name> --version <v> --registry <dir>";
+            let (dir, name, version, registry) = match (
+                args.get(1).filter(|a| !a.starts_with("--")),
+                parse_value_arg(args, "--name"),
+                parse_value_arg(args, "--version"),
+                parse_value_arg(args, "--registry"),
+            ) {
+                (Some(dir), Some(name), Some(version), Some(registry)) => {
+                    (dir, name, version, registry)
+                }
+                _ => return Err(usage.into()),
+            };
+
+            let bundle = Bundle::from_dir(name, version, dir.as_ref())?;
+            let digest = pkg::publish(&bundle, registry.as_ref())?;
+            println!(
+                "✓ Published {}@{} ({} specs)",
+                name,
+                version,
+                bundle.specs.len()
+            );
+            println!("  sha256: {}", digest);
+            Ok(())
+        }
+        Some("add") => {
+            let usage = "Usage: imacs pkg add <name> --version <v> --registry <url>|--git <url>";
+            let (name, version) = match (
+                args.get(1).filter(|a| !a.starts_with("--")),
+                parse_value_arg(args, "--version"),
+            ) {
+                (Some(name), Some(version)) => (name, version),
+                _ => return Err(usage.into()),
+            };
+            let package = PackageRef {
+                registry: parse_value_arg(args, "--registry").cloned(),
+                git: parse_value_arg(args, "--git").cloned(),
+                version: version.clone(),
+                sha256: String::new(),
+            };
+            if package.registry.is_some() == package.git.is_some() {
+                return Err(usage.into());
+            }
+            install_package(name, package)
+        }
+        Some("install") => {
+            let (_, root) = load_root()?;
+            if root.packages.is_empty() {
+                println!("No packages pinned in .imacs_root");
+            }
+            for (name, package) in root.packages {
+                install_package(&name, package)?;
+            }
+            Ok(())
+        }
+        _ => Err("Usage: imacs pkg <publish|add|install> [options]".into()),
+    }
+}
+
+fn cmd_oci(args: &[String]) -> Result<()> {
+    use imacs::oci::{self, Artifact};
+
+    match args.first().map(|s| s.as_str()) {
+        Some("package") => {
+            let (spec_path, output) = match (
+                args.get(1).filter(|a| !a.starts_with("--")),
+                parse_output_arg(args),
+            ) {
+                (Some(spec), Some(output)) => (spec, output),
+                _ => {
+                    return Err(
+                        "Usage: imacs oci package <spec.yaml> --output <dir> [--tag <tag>]".into(),
+                    )
+                }
+            };
+            let content = fs::read_to_string(spec_path).map_err(Error::Io)?;
+            let dir = Path::new(spec_path).parent().unwrap_or(Path::new("."));
+            let artifact = Artifact::from_spec(&content, dir)?;
+            let tag = match parse_value_arg(args, "--tag") {
+                Some(tag) => tag.clone(),
+                None => Spec::from_yaml(&content)?
+                    .meta
+                    .version
+                    .unwrap_or_else(|| "latest".to_string()),
+            };
+            artifact.write_layout(&output, &tag)?;
+            println!("✓ Packaged {} as {}:{}", spec_path, output.display(), tag);
+            println!("  digest: {}", artifact.digest());
+            Ok(())
+        }
+        Some("pull") => {
+            let reference = match args.get(1).filter(|a| !a.starts_with("--")) {
+                Some(reference) => reference,
+                None => {
+                    return Err(
+                        "Usage: imacs oci pull <registry/repo:tag> [--output <file>]".into(),
+                    )
+                }
+            };
+            let yaml = oci::pull(reference)?.to_yaml()?;
+            match parse_output_arg(args) {
+                Some(path) => {
+                    fs::write(&path, yaml).map_err(Error::Io)?;
+                    eprintln!("Written to: {}", path.display());
+                }
+                None => print!("{}", yaml),
+            }
+            Ok(())
+        }
+        _ => Err("Usage: imacs oci <package|pull> [options]".into()),
+    }
+}
+
+/// The project's imacs folder and its `.imacs_root`
+fn load_root() -> Result<(PathBuf, ImacRoot)> {
+    let current_dir = std::env::current_dir().map_err(Error::Io)?;
+    let imacs_dir = imacs::project::find_root(&current_dir)?
+        .ok_or("No .imacs_root found (run `imacs init --root`)")?;
+    let root = ImacRoot::load_from_dir(&imacs_dir)?
+        .ok_or("No .imacs_root found (run `imacs init --root`)")?;
+    Ok((imacs_dir, root))
+}
+
+/// Fetch and vendor a package, then pin it in `.imacs_root`
+///
+/// A package already pinned with a digest must match it.
+fn install_package(name: &str, mut package: PackageRef) -> Result<()> {
+    use imacs::pkg;
+    use imacs::registry::HttpTransport;
+
+    let (imacs_dir, mut root) = load_root()?;
+    let pinned = (!package.sha256.is_empty()).then(|| package.sha256.clone());
+    let bundle = match (&package.registry, &package.git) {
+        (Some(registry), _) => pkg::fetch(
+            &HttpTransport,
+            registry,
+            name,
+            &package.version,
+            pinned.as_deref(),
+        )?,
+        (None, Some(git)) => pkg::fetch_git(git, name, &package.version)?,
+        (None, None) => {
+            return Err(format!("Package {} has neither a registry nor a git source", name).into())
+        }
+    };
+    let digest = bundle.digest()?;
+    if let Some(pinned) = pinned {
+        if pinned != digest {
+            return Err(format!(
+                "{}@{}: digest mismatch (expected {}, got {})",
+                name, package.version, pinned, digest
+            )
+            .into());
+        }
+    }
+
+    let path = pkg::install(&bundle, &imacs_dir)?;
+    package.sha256 = digest;
+    root.packages.insert(name.to_string(), package);
+    root.save_to_dir(&imacs_dir)?;
+    println!(
+        "✓ Installed {}@{} to {}",
+        name,
+        bundle.version,
+        path.display()
+    );
+    Ok(())
+}
+
+fn cmd_assert(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs assert <spec.yaml>...".into());
+    }
+
+    let mut failed = 0;
+    for spec_path in args {
+        let spec = Spec::from_file(Path::new(spec_path))?;
+        for result in imacs::assertions::check(&spec)? {
+            match &result.counterexample {
+                None => println!("✓ {}: {} ({} inputs)", spec_path, result.id, result.checked),
+                Some(reason) => {
+                    println!("✗ {}: {} {}", spec_path, result.id, reason);
+                    failed += 1;
+                }
+            }
+        }
+    }
+
+    if failed > 0 {
+        return Err(format!("{} assertion(s) failed", failed).into());
+    }
+    Ok(())
+}
+
+fn cmd_prove(args: &[String]) -> Result<()> {
+    if args.is_empty() {
+        return Err("Usage: imacs prove <spec.yaml>...".into());
+    }
+    use imacs::prove::Verdict;
+
+    let mut failed = 0;
+    for spec_path in args {
+        let spec = Spec::from_file(Path::new(spec_path))?;
+        for proof in imacs::prove::prove(&spec)? {
+            match &proof.verdict {
+                Verdict::Proved => println!("✓ {}: {} proved", spec_path, proof.id),
+                Verdict::Counterexample(reason) => {
+                    println!("✗ {}: {} {}", spec_path, proof.id, reason);
+                    failed += 1;
+                }
+                Verdict::Unknown => println!("? {}: {} unknown to the solver", spec_path, proof.id),
+                Verdict::Unsupported(reason) => println!(
+                    "- {}: {} not provable ({}); checked by `imacs assert` only",
+                    spec_path, proof.id, reason
+                ),
+            }
+        }
+    }
+
+    if failed > 0 {
+        return Err(format!("{} assertion(s) disproved", failed).into());
+    }
+    Ok(())
+}
+
+fn cmd_synthesize(args: &[String]) -> Result<()> {
+    use imacs::synthesize::{Goal, Synthesis};
+
+    let usage = "Usage: imacs synthesize <spec.yaml> (--rule <id> | --output <predicate>) [--json]";
+    let path = args.first().filter(|a| !a.starts_with('-')).ok_or(usage)?;
+    let goal = match (
+        parse_value_arg(args, "--rule"),
+        parse_value_arg(args, "--output"),
+    ) {
+        (Some(rule), None) => Goal::Rule(rule.clone()),
+        (None, Some(predicate)) => Goal::Output(predicate.clone()),
+        _ => return Err(usage.into()),
+    };
+    let spec = Spec::from_file(Path::new(path))?;
+    match imacs::synthesize::synthesize(&spec, &goal)? {
+        Synthesis::Found(input) => {
+            if args.contains(&"--json".to_string()) {
+                println!("{}", serde_json::to_string_pretty(&input)?);
+            } else {
+                print!("{}", serde_norway::to_string(&input)?);
+            }
+            Ok(())
+        }
+        Synthesis::Infeasible => Err(format!("no valid input reaches {}", goal).into()),
+        Synthesis::Unknown => Err(format!("the solver gave up on {}", goal).into()),
+        Synthesis::Unsupported(reason) => {
+            Err(format!("cannot synthesize {}: {}", goal, reason).into())
+        }
+    }
+}
+
+fn cmd_scenarios(args: &[String]) -> Result<()> {
+    use imacs::flow_coverage::Criterion;
+
+    let usage = "Usage: imacs scenarios <orchestrator.yaml> [spec.yaml...] [--criteria gates,branches] [--output <file>] [--json]";
+    let output = parse_output_arg(args);
+    let mut paths = Vec::new();
+    let mut rest = args.iter();
+    while let Some(arg) = rest.next() {
+        if arg == "--output" || arg == "-o" || arg == "--criteria" {
+            rest.next();
+        } else if !arg.starts_with('-') {
+            paths.push(arg);
+        }
+    }
+    let [flow_path, spec_paths @ ..] = paths.as_slice() else {
+        return Err(usage.into());
+    };
+    let criteria = match parse_value_arg(args, "--criteria") {
+        Some(list) => list
+            .split(',')
+            .map(|name| match name.trim() {
+                "gates" => Ok(Criterion::Gates),
+                "branches" => Ok(Criterion::Branches),
+                other => Err(format!("Unknown coverage criterion: {}", other)),
+            })
+            .collect::<std::result::Result<Vec<_>, _>>()?,
+        None => vec![Criterion::Gates, Criterion::Branches],
+    };
+
+    let content = fs::read_to_string(flow_path).map_err(Error::Io)?;
+    let orch = orchestrate::Orchestrator::from_yaml(&content)?;
+    let mut specs = std::collections::HashMap::new();
+    for path in spec_paths {
+        let spec = Spec::from_file(Path::new(path))?;
+        specs.insert(spec.id.clone(), spec);
+    }
+
+    let coverage = imacs::flow_coverage::cover(&orch, &specs, &criteria)?;
+    if args.contains(&"--json".to_string()) {
+        write_output(&output, &serde_json::to_string_pretty(&coverage)?)?;
+    } else {
+        let scenarios = std::collections::BTreeMap::from([("scenarios", &coverage.scenarios)]);
+        write_output(&output, &serde_norway::to_string(&scenarios)?)?;
+        eprint!("{}", coverage.to_report());
+    }
+    Ok(())
+}
+
 fn cmd_schema(args: &[String]) -> Result<()> {
     let schema_name = args.first().map(|s| s.as_str()).unwrap_or("list");
 
     match schema_name {
         "list" => {
             println!(
-                "Available schemas: spec, verify, analyze, extract, drift, completeness, validate"
+                "Available schemas: spec, verify, analyze, extract, drift, completeness, validate, paths"
             );
             Ok(())
         }
@@ -884,6 +2146,7 @@ fn cmd_schema(args: &[String]) -> Result<()> {
         "drift" => print_schema::<DriftReport>(),
         "completeness" => print_schema::<IncompletenessReport>(),
         "validate" => print_schema::<imacs::completeness::ValidationReport>(),
+        "paths" => print_schema::<imacs::completeness::PathReport>(),
         _ => Err(format!("Unknown schema: {}", schema_name).into()),
     }
 }
@@ -913,6 +2176,12 @@ fn parse_target_arg(args: &[String]) -> Target {
     Target::Rust
 }
 
+fn parse_value_arg<'a>(args: &'a [String], flag: &str) -> Option<&'a String> {
+    args.iter()
+        .position(|a| a == flag)
+        .and_then(|i| args.get(i + 1))
+}
+
 fn parse_output_arg(args: &[String]) -> Option<PathBuf> {
     for (i, arg) in args.iter().enumerate() {
         if arg == "--output" || arg == "-o" {
@@ -937,6 +2206,52 @@ fn write_output(path: &Option<PathBuf>, content: &str) -> Result<()> {
     Ok(())
 }
 
+fn cmd_new(args: &[String]) -> Result<()> {
+    let usage = "Usage: imacs new <rule|flow> <id> [--inputs name:type,...] [--outputs name:type,...] [--specs a,b] [-o <file>]";
+    let (kind, id) = match args {
+        [kind, id, ..] if !id.starts_with('-') => (kind.as_str(), id),
+        _ => return Err(usage.into()),
+    };
+
+    use imacs::scaffold::{flow_skeleton, parse_fields, spec_skeleton};
+    let fields = |flag: &str| -> Result<Vec<_>> {
+        parse_value_arg(args, flag).map_or(Ok(Vec::new()), |list| parse_fields(list))
+    };
+    let (inputs, outputs) = (fields("--inputs")?, fields("--outputs")?);
+    let path = parse_output_arg(args).unwrap_or_else(|| PathBuf::from(format!("{}.yaml", id)));
+    if path.exists() {
+        return Err(format!("{} already exists", path.display()).into());
+    }
+
+    let yaml = match kind {
+        "rule" | "spec" => spec_skeleton(id, &inputs, &outputs)?,
+        "flow" => {
+            // Specs next to the new flow are read so their inputs can be wired
+            let dir = path.parent().unwrap_or(std::path::Path::new(""));
+            let specs = parse_value_arg(args, "--specs")
+                .map(|list| {
+                    list.split(',')
+                        .map(str::trim)
+                        .filter(|s| !s.is_empty())
+                        .map(|name| {
+                            let spec = fs::read_to_string(dir.join(format!("{}.yaml", name)))
+                                .ok()
+                                .and_then(|content| Spec::from_yaml(&content).ok());
+                            (name.to_string(), spec)
+                        })
+                        .collect::<Vec<_>>()
+                })
+                .unwrap_or_default();
+            flow_skeleton(id, &inputs, &outputs, &specs)?
+        }
+        _ => return Err(usage.into()),
+    };
+
+    fs::write(&path, yaml).map_err(Error::Io)?;
+    println!("✓ Created: {}", path.display());
+    Ok(())
+}
+
 fn cmd_init(args: &[String]) -> Result<()> {
     let is_root = args.contains(&"--root".to_string());
     let current_dir = std::env::current_dir().map_err(Error::Io)?;
@@ -995,11 +2310,20 @@ defaults:
   naming:
     code: "{spec_id}.{ext}"
     tests: "{spec_id}_test.{ext}"
+  # Which generated pieces get files of their own
+  # layout:
+  #   separate_types: true   # Go types in {spec_id}_types.go (naming.types)
+  #   tests: separate        # separate | inline (Rust) | omit
+  #   docs: true             # a Markdown page per spec (naming.docs)
 
 validation:
   require_unique_ids: true
   require_descriptions: false
   max_rules_per_spec: 50
+  # Style rules checked by `imacs lint`
+  # rule_id_pattern: "R[0-9]+"
+  # snake_case_fields: true
+  # max_conjuncts: 4
 "#;
         let root_file = imacs_dir.join(".imacs_root");
         fs::write(&root_file, root_config).map_err(Error::Io)?;
@@ -1327,6 +2651,7 @@ fn regenerate_folder(
                     if (ext == "yaml" || ext == "yml")
                         && path.file_name().and_then(|n| n.to_str()) != Some("config.yaml")
                         && path.file_name().and_then(|n| n.to_str()) != Some(".imacs_root")
+                        && !is_template(&path)
                     {
                         specs.push(path);
                     }
@@ -1351,7 +2676,7 @@ fn regenerate_folder(
         .collect();
 
     // Find stale specs (need to check all possible output directories)
-    let specs_to_regenerate = if force {
+    let mut specs_to_regenerate = if force {
         all_specs.clone()
     } else {
         // Check staleness - need to check all output directories
@@ -1360,6 +2685,57 @@ fn regenerate_folder(
         imacs::find_stale_specs(&folder.path, &default_dir)?
     };
 
+    // Specs of the folder by ID, for the flows calling them
+    let workspace_specs: std::collections::HashMap<String, Spec> = all_specs
+        .iter()
+        .filter_map(|p| Spec::from_file(p).ok())
+        .map(|spec| (spec.id.clone(), spec))
+        .collect();
+
+    // A flow embeds the hashes of the specs it calls, so it is regenerated
+    // along with them
+    let regenerated_ids: Vec<String> = specs_to_regenerate
+        .iter()
+        .filter_map(|p| Spec::from_file(p).ok())
+        .map(|spec| spec.id)
+        .collect();
+    for path in &all_specs {
+        if specs_to_regenerate.contains(path) {
+            continue;
+        }
+        let Ok(content) = fs::read_to_string(path) else {
+            continue;
+        };
+        if !(content.contains("\nchain:") || content.contains("\nuses:")) {
+            continue;
+        }
+        if let Ok(orch) = orchestrate::Orchestrator::from_yaml(&content) {
+            if orch
+                .referenced_specs()
+                .iter()
+                .any(|id| regenerated_ids.contains(id))
+            {
+                specs_to_regenerate.push(path.clone());
+            }
+        }
+    }
+
+    // An overlay is regenerated along with its base
+    for path in &all_specs {
+        if specs_to_regenerate.contains(path) {
+            continue;
+        }
+        let base = fs::read_to_string(path)
+            .ok()
+            .and_then(|content| Spec::from_yaml(&content).ok())
+            .and_then(|spec| spec.extends);
+        if let Some(base) = base {
+            if specs_to_regenerate.contains(&folder.path.join(base)) {
+                specs_to_regenerate.push(path.clone());
+            }
+        }
+    }
+
     let mut cleaned = 0;
 
     // Clean orphaned files if requested
@@ -1414,7 +2790,8 @@ fn regenerate_folder(
                 orch.id.clone()
             }
         } else {
-            let spec = Spec::from_yaml(&spec_content)?;
+            let spec = Spec::from_file(spec_path)?;
+            imacs::assertions::enforce(&spec)?;
             if !folder.config.spec_id_prefix.is_empty() {
                 format!("{}{}", folder.config.spec_id_prefix, spec.id)
             } else {
@@ -1435,18 +2812,57 @@ fn regenerate_folder(
             fs::create_dir_all(&output_dir).map_err(Error::Io)?;
 
             // Generate code based on type
-            let (code, tests) = if is_orchestrator {
+            let (mut code, mut tests, parts) = if is_orchestrator {
                 let orch = orchestrate::Orchestrator::from_yaml(&spec_content)?;
-                let specs_map = std::collections::HashMap::new();
+                for id in orch.referenced_specs() {
+                    if !workspace_specs.contains_key(&id) {
+                        eprintln!(
+                            "Warning: {} calls spec '{}', which is not in {}; its version is not checked",
+                            orch.id,
+                            id,
+                            folder.path.display()
+                        );
+                    }
+                }
                 (
-                    orchestrate::render_orchestrator(&orch, &specs_map, *target),
+                    orchestrate::render_orchestrator(&orch, &workspace_specs, *target),
                     testgen::orchestrator::generate_orchestrator_tests(&orch, *target),
+                    Vec::new(),
                 )
             } else {
-                let spec = Spec::from_yaml(&spec_content)?;
-                (render(&spec, *target), generate_tests(&spec, *target))
+                let mut spec = Spec::from_file(spec_path)?;
+                spec.functions = folder.config.functions.clone();
+                if folder.config.validation.require_version_bump {
+                    if let Some(previous) = meta.snapshot(spec_path, &folder.path) {
+                        let previous = Spec::from_yaml(previous)?;
+                        let report = imacs::compat::compare(&previous, &spec);
+                        imacs::compat::check_version(&previous, &spec, &report)?;
+                    }
+                }
+                let parts = render_spec_parts(&spec, *target, true)
+                    .map_err(|e| Error::Other(e.to_string()))?;
+                (
+                    render(&spec, *target),
+                    generate_tests(&spec, *target),
+                    parts,
+                )
             };
 
+            // Cut the generated files to the configured layout
+            let layout = &folder.config.layout;
+            let mut types = String::new();
+            if layout.separate_types && *target == Target::Go && !is_orchestrator {
+                (code, types) = imacs::layout::split_go_types(&code);
+            }
+            match layout.tests {
+                TestLayout::Inline if *target == Target::Rust => {
+                    code = imacs::layout::inline_tests(&code, &tests);
+                    tests.clear();
+                }
+                TestLayout::Omit => tests.clear(),
+                _ => {}
+            }
+
             // Apply naming convention
             let code_filename = folder.config.apply_naming(&spec_id, target, false);
             let test_filename = folder.config.apply_naming(&spec_id, target, true);
@@ -1455,14 +2871,116 @@ fn regenerate_folder(
             let test_path = output_dir.join(&test_filename);
 
             // Write code
-            fs::write(&code_path, &code).map_err(Error::Io)?;
+            write_generated(&code_path, &code)?;
 
             // Track generated files for --clean support
             meta.track_generated_file(&spec_id, &code_filename);
 
+            // Write the parts of a split rule chain
+            for (part, part_code) in &parts {
+                let part_filename =
+                    folder
+                        .config
+                        .apply_naming(&format!("{}_part{}", spec_id, part), target, false);
+                write_generated(&output_dir.join(&part_filename), part_code)?;
+                meta.track_generated_file(&spec_id, &part_filename);
+            }
+
+            // Write the type declarations split off the code
+            if !types.is_empty() {
+                let types_filename = folder.config.file_name(FileKind::Types, &spec_id, target);
+                write_generated(&output_dir.join(&types_filename), &types)?;
+                meta.track_generated_file(&spec_id, &types_filename);
+            }
+
+            // The lean variant, for the builds `codegen.lean_build` names
+            if *target == Target::Go && !is_orchestrator {
+                let mut spec = Spec::from_file(spec_path)?;
+                spec.functions = folder.config.functions.clone();
+                if let Some(lean) = spec.lean_variant() {
+                    let lean_id = format!("{}_lean", spec_id);
+                    let mut files = vec![(
+                        folder.config.apply_naming(&lean_id, target, false),
+                        render(&lean, *target),
+                    )];
+                    if layout.separate_types {
+                        let (lean_code, lean_types) = imacs::layout::split_go_types(&files[0].1);
+                        files[0].1 = lean_code;
+                        if !lean_types.is_empty() {
+                            files.push((
+                                folder.config.file_name(FileKind::Types, &lean_id, target),
+                                lean_types,
+                            ));
+                        }
+                    }
+                    for (part, part_code) in render_spec_parts(&lean, *target, true)
+                        .map_err(|e| Error::Other(e.to_string()))?
+                    {
+                        files.push((
+                            folder.config.apply_naming(
+                                &format!("{}_part{}", lean_id, part),
+                                target,
+                                false,
+                            ),
+                            part_code,
+                        ));
+                    }
+                    for (filename, content) in files {
+                        write_generated(&output_dir.join(&filename), &content)?;
+                        meta.track_generated_file(&spec_id, &filename);
+                    }
+                }
+            }
+
+            // Map rules to their Go lines for `imacs coverage`
+            if *target == Target::Go && !is_orchestrator {
+                let spec = Spec::from_file(spec_path)?;
+                let mut files = vec![(code_filename.clone(), code.as_str())];
+                for (part, part_code) in &parts {
+                    files.push((
+                        folder.config.apply_naming(
+                            &format!("{}_part{}", spec_id, part),
+                            target,
+                            false,
+                        ),
+                        part_code.as_str(),
+                    ));
+                }
+                let map = imacs::coverage::RuleMap::build(&spec, &files);
+                let map_filename = format!("{}{}", spec_id, imacs::coverage::RULE_MAP_SUFFIX);
+                fs::write(
+                    output_dir.join(&map_filename),
+                    serde_json::to_string_pretty(&map)?,
+                )
+                .map_err(Error::Io)?;
+                meta.track_generated_file(&spec_id, &map_filename);
+
+                // The spec YAML that `codegen.embed_spec` code embeds, next
+                // to the code file as go:embed requires
+                if spec.codegen.embed_spec {
+                    let embed_filename = Path::new(&code_filename)
+                        .with_file_name(spec.embed_file_name())
+                        .to_string_lossy()
+                        .into_owned();
+                    fs::write(output_dir.join(&embed_filename), spec.embed_source()?)
+                        .map_err(Error::Io)?;
+                    meta.track_generated_file(&spec_id, &embed_filename);
+                }
+            }
+
+            // The spec an overlay stands for, to review what it changes
+            if !is_orchestrator && Spec::from_yaml(&spec_content)?.extends.is_some() {
+                let merged_filename = format!("{}.merged.yaml", spec_id);
+                write_generated(
+                    &output_dir.join(&merged_filename),
+                    &Spec::from_file(spec_path)?.to_yaml()?,
+                )?;
+                meta.track_generated_file(&spec_id, &merged_filename);
+            }
+
             // Write tests (if any)
             if !tests.trim().is_empty() {
-                fs::write(&test_path, &tests).map_err(Error::Io)?;
+                write_generated(&test_path, &tests)?;
                 meta.track_generated_file(&spec_id, &test_filename);
             }
 
@@ -1474,6 +2992,9 @@ fn regenerate_folder(
 
             // Update metadata hash
             meta.update_hash(spec_path, &folder.path)?;
+            if folder.config.validation.require_version_bump && !is_orchestrator {
+                meta.update_snapshot(spec_path, &folder.path)?;
+            }
 
             // Save metadata for this output directory
             meta.save_to_dir(&output_dir)?;
@@ -1485,12 +3006,47 @@ fn regenerate_folder(
             );
         }
 
+        // Document the spec once, whatever the targets
+        if folder.config.layout.docs && !is_orchestrator {
+            if let Some(target) = folder.config.targets.first() {
+                let spec = Spec::from_file(spec_path)?;
+                let docs_dir = imacs::project::get_docs_dir(&folder.path, &folder.config);
+                let docs_filename = folder.config.file_name(FileKind::Docs, &spec_id, target);
+                write_generated(
+                    &docs_dir.join(&docs_filename),
+                    &imacs::layout::spec_docs(&spec),
+                )?;
+                let mut meta =
+                    imacs::ImacMeta::load_from_dir(&docs_dir)?.unwrap_or_else(imacs::create_meta);
+                meta.track_generated_file(&spec_id, &docs_filename);
+                meta.save_to_dir(&docs_dir)?;
+            }
+        }
+
         regenerated += 1;
     }
 
     Ok((regenerated, cleaned))
 }
 
+/// Whether a YAML file is a spec template, generated only once
+/// instantiated
+fn is_template(path: &Path) -> bool {
+    fs::read_to_string(path)
+        .ok()
+        .and_then(|content| imacs::instantiate::declared(&content).ok())
+        .is_some_and(|params| !params.is_empty())
+}
+
+/// Write a generated file, creating the subdirectories its naming
+/// pattern puts it in
+fn write_generated(path: &Path, content: &str) -> Result<()> {
+    if let Some(dir) = path.parent() {
+        fs::create_dir_all(dir).map_err(Error::Io)?;
+    }
+    fs::write(path, content).map_err(Error::Io)
+}
+
 fn cmd_update() -> Result<()> {
     match update::run_update() {
         Ok(()) => Ok(()),
@@ -1578,3 +3134,38 @@ fn cmd_selfcheck() -> Result<()> {
         Ok(())
     }
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_render_writes_embedded_spec() {
+        let dir = tempfile::tempdir().unwrap();
+        // Written by hand: the embedded file keeps the comment and layout
+        let yaml = "# Reviewed in #412\nid: loan_decision\ncodegen: {embed_spec: true}\ninputs:\n  - {name: score, type: int}\noutputs:\n  - {name: approved, type: bool}\nrules:\n  - id: R1\n    when: \"score >= 600\"\n    then: true\ndefault: false\n";
+        let spec_path = dir.path().join("loan_decision.yaml");
+        fs::write(&spec_path, yaml).unwrap();
+        let out = dir.path().join("gen").join("loan_decision.go");
+        fs::create_dir(out.parent().unwrap()).unwrap();
+
+        let args: Vec<String> = [
+            spec_path.to_str().unwrap(),
+            "--lang",
+            "go",
+            "--output",
+            out.to_str().unwrap(),
+        ]
+        .map(String::from)
+        .to_vec();
+        cmd_render(&args).unwrap();
+
+        let embedded = fs::read_to_string(dir.path().join("gen/loan_decision.spec.yaml")).unwrap();
+        assert_eq!(embedded, yaml);
+        let go = fs::read_to_string(&out).unwrap();
+        assert!(go.contains("//go:embed loan_decision.spec.yaml\n"));
+        let spec = Spec::from_file(&spec_path).unwrap();
+        assert!(go.contains(&format!("hash != \"{}\"", spec.embed_hash())));
+        assert_ne!(spec.embed_hash(), spec.hash());
+    }
+}
@@ -0,0 +1,384 @@
+//! Rule-aware three-way merge of spec versions
+//!
+//! Line-based merges of rule tables conflict on unrelated edits to
+//! neighbouring rules, and silently combine edits that contradict each
+//! other. [`merge`] instead matches rules by ID (and inputs and outputs by
+//! name) and merges each as a unit: a rule changed on one side takes that
+//! change, a rule changed on both sides to different results is a
+//! conflict. Everything else in the spec (default, meta, ...) merges per
+//! top-level field the same way.
+//!
+//! Rules added on both sides under different IDs with the same condition
+//! but different outputs are conflicts as well, since only one of them can
+//! ever decide.
+//!
+//! Conflicts resolve to the preferred side, so the merged spec is always
+//! valid; callers decide whether a conflict fails the merge.
+
+use crate::error::{Error, Result};
+use crate::spec::Spec;
+use serde::Serialize;
+use serde_json::{Map, Value};
+use std::collections::BTreeSet;
+
+/// Lists merged item by item: (field, key of an item, item kind)
+const KEYED_LISTS: [(&str, &str, &str); 3] = [
+    ("rules", "id", "rule"),
+    ("inputs", "name", "input"),
+    ("outputs", "name", "output"),
+];
+
+/// Side of a merge
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Side {
+    Ours,
+    Theirs,
+}
+
+/// An item the two sides changed incompatibly
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct MergeConflict {
+    /// What conflicts, e.g. `rule R2` or `default`
+    pub item: String,
+    pub reason: String,
+    /// Our version of the item, None when we removed it
+    pub ours: Option<Value>,
+    /// Their version of the item, None when they removed it
+    pub theirs: Option<Value>,
+}
+
+impl std::fmt::Display for MergeConflict {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{} {}", self.item, self.reason)?;
+        let (Some(ours), Some(theirs)) = (&self.ours, &self.theirs) else {
+            return Ok(());
+        };
+        let differences: Vec<String> = match (ours, theirs) {
+            (Value::Object(o), Value::Object(t)) => {
+                let fields: BTreeSet<&String> = o.keys().chain(t.keys()).collect();
+                fields
+                    .into_iter()
+                    .filter(|field| o.get(*field) != t.get(*field))
+                    .map(|field| {
+                        format!(
+                            "{} is `{}` in ours, `{}` in theirs",
+                            field,
+                            show(o.get(field)),
+                            show(t.get(field))
+                        )
+                    })
+                    .collect()
+            }
+            _ => vec![format!(
+                "`{}` in ours, `{}` in theirs",
+                show(Some(ours)),
+                show(Some(theirs))
+            )],
+        };
+        write!(f, ": {}", differences.join("; "))
+    }
+}
+
+/// A merged spec and the conflicts resolved on the way
+#[derive(Debug, Clone)]
+pub struct Merge {
+    pub spec: Spec,
+    pub conflicts: Vec<MergeConflict>,
+}
+
+/// Merge the changes `ours` and `theirs` made to `base`, resolving
+/// conflicts to `prefer`
+pub fn merge(base: &Spec, ours: &Spec, theirs: &Spec, prefer: Side) -> Result<Merge> {
+    let [base_map, ours_map, theirs_map] = [base, ours, theirs].map(to_map);
+    let (base_map, ours_map, theirs_map) = (base_map?, ours_map?, theirs_map?);
+
+    let mut conflicts = Vec::new();
+    let mut merged = Map::new();
+    let fields: BTreeSet<&String> = base_map
+        .keys()
+        .chain(ours_map.keys())
+        .chain(theirs_map.keys())
+        .collect();
+    for field in fields {
+        let sides = (
+            base_map.get(field),
+            ours_map.get(field),
+            theirs_map.get(field),
+        );
+        let value = match KEYED_LISTS.iter().find(|(name, _, _)| name == field) {
+            Some(&(_, key, kind)) => {
+                let items = |v: Option<&Value>| v.and_then(Value::as_array).cloned();
+                let items = (
+                    items(sides.0).unwrap_or_default(),
+                    items(sides.1).unwrap_or_default(),
+                    items(sides.2).unwrap_or_default(),
+                );
+                Some(Value::Array(merge_list(
+                    items,
+                    key,
+                    kind,
+                    prefer,
+                    &mut conflicts,
+                )))
+            }
+            None => resolve(field.clone(), sides, prefer, &mut conflicts),
+        };
+        if let Some(value) = value {
+            merged.insert(field.clone(), value);
+        }
+    }
+
+    let spec: Spec = serde_json::from_value(Value::Object(merged))
+        .map_err(|e| Error::SpecParse(e.to_string()))?;
+    conflicts.extend(duplicate_additions(base, ours, theirs, &spec)?);
+    Ok(Merge { spec, conflicts })
+}
+
+fn to_map(spec: &Spec) -> Result<Map<String, Value>> {
+    match serde_json::to_value(spec)? {
+        Value::Object(map) => Ok(map),
+        _ => Err(Error::SpecParse(format!("{}: not a mapping", spec.id))),
+    }
+}
+
+/// Three-way merge of one item; None when it ends up removed
+fn resolve(
+    item: String,
+    (base, ours, theirs): (Option<&Value>, Option<&Value>, Option<&Value>),
+    prefer: Side,
+    conflicts: &mut Vec<MergeConflict>,
+) -> Option<Value> {
+    if ours == theirs || theirs == base {
+        return ours.cloned();
+    }
+    if ours == base {
+        return theirs.cloned();
+    }
+    let reason = match (base, ours, theirs) {
+        (None, _, _) => "added on both sides with different content",
+        (Some(_), None, _) => "removed on our side but changed on theirs",
+        (Some(_), _, None) => "changed on our side but removed on theirs",
+        _ => "changed differently on both sides",
+    };
+    conflicts.push(MergeConflict {
+        item,
+        reason: reason.to_string(),
+        ours: ours.cloned(),
+        theirs: theirs.cloned(),
+    });
+    match prefer {
+        Side::Ours => ours.cloned(),
+        Side::Theirs => theirs.cloned(),
+    }
+}
+
+/// Merge a list item by item, in our order with their additions placed
+/// after the item they follow on their side
+fn merge_list(
+    (base, ours, theirs): (Vec<Value>, Vec<Value>, Vec<Value>),
+    key: &str,
+    kind: &str,
+    prefer: Side,
+    conflicts: &mut Vec<MergeConflict>,
+) -> Vec<Value> {
+    let id = |item: &Value| {
+        item.get(key)
+            .and_then(Value::as_str)
+            .unwrap_or_default()
+            .to_string()
+    };
+    let find = |list: &[Value], wanted: &str| list.iter().position(|item| id(item) == wanted);
+
+    let mut order: Vec<String> = ours.iter().map(id).collect();
+    for (i, item) in theirs.iter().enumerate() {
+        let item_id = id(item);
+        if order.contains(&item_id) {
+            continue;
+        }
+        let at = theirs[..i]
+            .iter()
+            .rev()
+            .find_map(|prev| order.iter().position(|o| *o == id(prev)))
+            .map_or(0, |pos| pos + 1);
+        order.insert(at, item_id);
+    }
+
+    order
+        .into_iter()
+        .filter_map(|item_id| {
+            let sides = (
+                find(&base, &item_id).map(|i| &base[i]),
+                find(&ours, &item_id).map(|i| &ours[i]),
+                find(&theirs, &item_id).map(|i| &theirs[i]),
+            );
+            resolve(format!("{} {}", kind, item_id), sides, prefer, conflicts)
+        })
+        .collect()
+}
+
+/// Rules each side added under a different ID with the same condition but
+/// a different output, when both made it into the merged spec
+fn duplicate_additions(
+    base: &Spec,
+    ours: &Spec,
+    theirs: &Spec,
+    merged: &Spec,
+) -> Result<Vec<MergeConflict>> {
+    let added = |spec: &Spec| -> Vec<&crate::spec::Rule> {
+        spec.rules
+            .iter()
+            .filter(|r| base.rules.iter().all(|b| b.id != r.id))
+            .filter(|r| merged.rules.iter().any(|m| m.id == r.id))
+            .collect()
+    };
+    let mut conflicts = Vec::new();
+    for rule in added(ours) {
+        for other in added(theirs) {
+            if rule.id == other.id || rule.as_cel() != other.as_cel() {
+                continue;
+            }
+            let (then, other_then) = (
+                serde_json::to_value(&rule.then)?,
+                serde_json::to_value(&other.then)?,
+            );
+            if then != other_then {
+                conflicts.push(MergeConflict {
+                    item: format!("rules {} and {}", rule.id, other.id),
+                    reason: "added on each side with the same condition but different outputs"
+                        .to_string(),
+                    ours: Some(then),
+                    theirs: Some(other_then),
+                });
+            }
+        }
+    }
+    Ok(conflicts)
+}
+
+fn show(value: Option<&Value>) -> String {
+    match value {
+        None => "(unset)".to_string(),
+        Some(Value::String(s)) => s.clone(),
+        Some(other) => other.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const BASE: &str = r#"
+id: pricing
+inputs:
+  - name: tier
+    type: string
+outputs:
+  - name: discount
+    type: int
+rules:
+  - id: R1
+    when: "tier == 'gold'"
+    then: 20
+  - id: R2
+    when: "tier == 'silver'"
+    then: 10
+  - id: R3
+    when: "tier == 'bronze'"
+    then: 5
+default: 0
+"#;
+
+    fn edit(replacements: &[(&str, &str)]) -> Spec {
+        let mut yaml = BASE.to_string();
+        for (from, to) in replacements {
+            assert!(yaml.contains(from), "{}", from);
+            yaml = yaml.replace(from, to);
+        }
+        Spec::from_yaml(&yaml).unwrap()
+    }
+
+    fn ids(spec: &Spec) -> Vec<&str> {
+        spec.rules.iter().map(|r| r.id.as_str()).collect()
+    }
+
+    #[test]
+    fn test_merge_independent_rule_changes() {
+        let base = edit(&[]);
+        let ours = edit(&[
+            ("then: 20", "then: 25"),
+            (
+                "default: 0",
+                "  - id: R4\n    when: \"tier == 'staff'\"\n    then: 50\ndefault: 0",
+            ),
+        ]);
+        let theirs = edit(&[
+            ("tier == 'silver'", "tier in ['silver', 'platinum']"),
+            (
+                "  - id: R3\n    when: \"tier == 'bronze'\"\n    then: 5\n",
+                "  - id: R5\n    when: \"tier == 'trial'\"\n    then: 1\n",
+            ),
+        ]);
+
+        let merged = merge(&base, &ours, &theirs, Side::Ours).unwrap();
+        assert!(merged.conflicts.is_empty(), "{:?}", merged.conflicts);
+        // R3 removed by them, R5 placed after R2 as on their side
+        assert_eq!(ids(&merged.spec), vec!["R1", "R2", "R5", "R4"]);
+        assert_eq!(merged.spec.rules[0].then.to_string(), "25");
+        assert_eq!(
+            merged.spec.rules[1].as_cel().unwrap(),
+            "tier in ['silver', 'platinum']"
+        );
+    }
+
+    #[test]
+    fn test_merge_conflicts() {
+        let base = edit(&[]);
+        let ours = edit(&[("then: 20", "then: 25"), ("default: 0", "default: 1")]);
+        let theirs = edit(&[("then: 20", "then: 30"), ("default: 0", "default: 1")]);
+
+        let merged = merge(&base, &ours, &theirs, Side::Ours).unwrap();
+        assert_eq!(merged.conflicts.len(), 1);
+        assert_eq!(
+            merged.conflicts[0].to_string(),
+            "rule R1 changed differently on both sides: then is `25` in ours, `30` in theirs"
+        );
+        assert_eq!(merged.spec.rules[0].then.to_string(), "25");
+        assert_eq!(merged.spec.default.as_ref().unwrap().to_string(), "1");
+
+        let merged = merge(&base, &ours, &theirs, Side::Theirs).unwrap();
+        assert_eq!(merged.spec.rules[0].then.to_string(), "30");
+
+        // Removed on one side, changed on the other
+        let removed = edit(&[(
+            "  - id: R1\n    when: \"tier == 'gold'\"\n    then: 20\n",
+            "",
+        )]);
+        let merged = merge(&base, &removed, &theirs, Side::Ours).unwrap();
+        assert_eq!(
+            merged.conflicts[0].reason,
+            "removed on our side but changed on theirs"
+        );
+        assert_eq!(ids(&merged.spec), vec!["R2", "R3"]);
+    }
+
+    #[test]
+    fn test_merge_flags_same_condition_added_twice() {
+        let base = edit(&[]);
+        let add = |id: &str, then: &str| {
+            edit(&[(
+                "default: 0",
+                &format!(
+                    "  - id: {}\n    when: \"tier == 'staff'\"\n    then: {}\ndefault: 0",
+                    id, then
+                ),
+            )])
+        };
+        let merged = merge(&base, &add("R4", "50"), &add("R9", "40"), Side::Ours).unwrap();
+        assert_eq!(merged.conflicts.len(), 1);
+        assert_eq!(merged.conflicts[0].item, "rules R4 and R9");
+
+        let merged = merge(&base, &add("R4", "50"), &add("R9", "50"), Side::Ours).unwrap();
+        assert!(merged.conflicts.is_empty());
+    }
+}
@@ -22,6 +22,11 @@ pub struct ImacMeta {
     #[serde(default)]
     pub generated_files: HashMap<String, Vec<String>>,
 
+    /// Spec contents at last generation (relative path -> YAML), kept when
+    /// version bumps are enforced
+    #[serde(default, skip_serializing_if = "HashMap::is_empty")]
+    pub spec_snapshots: HashMap<String, String>,
+
     /// When this metadata was generated (ISO 8601 string)
     #[serde(with = "chrono::serde::ts_seconds")]
     #[schemars(with = "String")]
@@ -99,6 +104,21 @@ impl ImacMeta {
         Ok(())
     }
 
+    /// Spec contents as of the last generation, if a snapshot was kept
+    pub fn snapshot(&self, spec_path: &Path, imacs_dir: &Path) -> Option<&str> {
+        let relative_path = relative_spec_path(spec_path, imacs_dir)?;
+        self.spec_snapshots.get(&relative_path).map(|s| s.as_str())
+    }
+
+    /// Keep a spec's contents for comparison on the next generation
+    pub fn update_snapshot(&mut self, spec_path: &Path, imacs_dir: &Path) -> Result<()> {
+        let relative_path = relative_spec_path(spec_path, imacs_dir)
+            .ok_or_else(|| Error::Other("Cannot compute relative path".to_string()))?;
+        let content = std::fs::read_to_string(spec_path).map_err(Error::Io)?;
+        self.spec_snapshots.insert(relative_path, content);
+        Ok(())
+    }
+
     /// Track a generated file for a spec
     pub fn track_generated_file(&mut self, spec_id: &str, file_path: &str) {
         let files = self.generated_files.entry(spec_id.to_string()).or_default();
@@ -178,6 +198,15 @@ impl ImacMeta {
     }
 }
 
+/// Spec path relative to its imacs folder, with `/` separators
+fn relative_spec_path(spec_path: &Path, imacs_dir: &Path) -> Option<String> {
+    spec_path
+        .strip_prefix(imacs_dir)
+        .ok()
+        .and_then(|p| p.to_str())
+        .map(|s| s.replace('\\', "/"))
+}
+
 /// Compute SHA256 hash of a file
 fn compute_file_hash(path: &Path) -> Result<String> {
     let content = std::fs::read(path).map_err(Error::Io)?;
@@ -192,6 +221,7 @@ pub fn create_meta() -> ImacMeta {
     ImacMeta {
         spec_hashes: HashMap::new(),
         generated_files: HashMap::new(),
+        spec_snapshots: HashMap::new(),
         generated_at: Utc::now(),
         tool_version: crate::VERSION.to_string(),
     }
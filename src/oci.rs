@@ -0,0 +1,463 @@
+//! OCI artifact packaging of specs
+//!
+//! A validated spec is packaged as an OCI artifact, so deployment systems can
+//! pull rules like images and [`crate::runtime`] can load them straight from a
+//! registry reference. The artifact holds:
+//!
+//! - a config blob with the spec's id, version and hash
+//! - the spec YAML, as written
+//! - the spec as JSON, the form the interpreter loads
+//! - the rows of its lookup tables, when it has any
+//!
+//! [`Artifact::write_layout`] writes an OCI image layout directory, which
+//! standard tools push to a registry (`oras cp --from-oci-layout`,
+//! `skopeo copy oci:...`). [`pull`] fetches the JSON and table layers back,
+//! checks every digest along the way, and the spec's hash against the
+//! manifest.
+
+use crate::error::{Error, Result};
+use crate::registry::{sha256_hex, HttpTransport, Transport};
+use crate::spec::{ConditionValue, Spec};
+use serde_json::{json, Value};
+use std::collections::BTreeMap;
+use std::path::Path;
+
+/// Artifact type of packaged specs
+pub const ARTIFACT_TYPE: &str = "application/vnd.imacs.spec.v1";
+/// Media type of the config blob
+pub const CONFIG_MEDIA_TYPE: &str = "application/vnd.imacs.spec.config.v1+json";
+/// Media type of the spec YAML layer
+pub const YAML_MEDIA_TYPE: &str = "application/vnd.imacs.spec.v1+yaml";
+/// Media type of the spec JSON layer
+pub const JSON_MEDIA_TYPE: &str = "application/vnd.imacs.spec.v1+json";
+/// Media type of the layer holding the lookup table rows, by table and key
+pub const TABLES_MEDIA_TYPE: &str = "application/vnd.imacs.spec.tables.v1+json";
+
+const MANIFEST_MEDIA_TYPE: &str = "application/vnd.oci.image.manifest.v1+json";
+const HASH_ANNOTATION: &str = "dev.imacs.spec.hash";
+
+/// A spec packaged as an OCI manifest and its blobs
+#[derive(Debug, Clone)]
+pub struct Artifact {
+    /// Serialized image manifest
+    pub manifest: Vec<u8>,
+    /// Config blob followed by the layers
+    pub blobs: Vec<Vec<u8>>,
+}
+
+impl Artifact {
+    /// Package a spec, refusing one that does not validate
+    ///
+    /// Lookup table files are read relative to `dir`, the spec's directory.
+    pub fn from_spec(yaml: &str, dir: &Path) -> Result<Self> {
+        let mut spec = Spec::from_yaml(yaml)?;
+        spec.load_tables(dir)?;
+        let errors = spec.validate();
+        if !errors.is_empty() {
+            return Err(Error::Other(format!(
+                "Spec {} is invalid: {}",
+                spec.id,
+                errors.join("; ")
+            )));
+        }
+
+        let hash = spec.hash();
+        let version = spec.meta.version.clone().unwrap_or_default();
+        let config = serde_json::to_vec(&json!({
+            "id": spec.id,
+            "version": version,
+            "hash": hash,
+        }))?;
+        let yaml = yaml.as_bytes().to_vec();
+        let ir = spec.to_json()?.into_bytes();
+        let mut blobs = vec![config, yaml, ir];
+        if !spec.tables.is_empty() {
+            let rows: BTreeMap<&str, &BTreeMap<String, ConditionValue>> = spec
+                .tables
+                .iter()
+                .map(|table| (table.name.as_str(), &table.rows))
+                .collect();
+            blobs.push(serde_json::to_vec(&rows)?);
+        }
+        let media_types = [YAML_MEDIA_TYPE, JSON_MEDIA_TYPE, TABLES_MEDIA_TYPE];
+        let layers: Vec<Value> = media_types
+            .iter()
+            .zip(&blobs[1..])
+            .map(|(media_type, blob)| descriptor(media_type, blob))
+            .collect();
+
+        let manifest = serde_json::to_vec_pretty(&json!({
+            "schemaVersion": 2,
+            "mediaType": MANIFEST_MEDIA_TYPE,
+            "artifactType": ARTIFACT_TYPE,
+            "config": descriptor(CONFIG_MEDIA_TYPE, &blobs[0]),
+            "layers": layers,
+            "annotations": {
+                "org.opencontainers.image.title": spec.id,
+                "org.opencontainers.image.version": version,
+                HASH_ANNOTATION: hash,
+            },
+        }))?;
+
+        Ok(Self { manifest, blobs })
+    }
+
+    /// Digest of the manifest, the artifact's immutable reference
+    pub fn digest(&self) -> String {
+        format!("sha256:{}", sha256_hex(&self.manifest))
+    }
+
+    /// Write the artifact into an OCI image layout directory under `tag`,
+    /// keeping other tags already in the layout
+    pub fn write_layout(&self, dir: &Path, tag: &str) -> Result<()> {
+        let blobs_dir = dir.join("blobs").join("sha256");
+        std::fs::create_dir_all(&blobs_dir).map_err(Error::Io)?;
+        for blob in self.blobs.iter().chain([&self.manifest]) {
+            std::fs::write(blobs_dir.join(sha256_hex(blob)), blob).map_err(Error::Io)?;
+        }
+        std::fs::write(dir.join("oci-layout"), r#"{"imageLayoutVersion":"1.0.0"}"#)
+            .map_err(Error::Io)?;
+
+        let index_path = dir.join("index.json");
+        let mut manifests: Vec<Value> = match std::fs::read_to_string(&index_path) {
+            Ok(content) => {
+                let index: Value = serde_json::from_str(&content)?;
+                index["manifests"].as_array().cloned().unwrap_or_default()
+            }
+            Err(_) => Vec::new(),
+        };
+        manifests.retain(|m| m["annotations"]["org.opencontainers.image.ref.name"] != tag);
+        let mut entry = descriptor(MANIFEST_MEDIA_TYPE, &self.manifest);
+        entry["artifactType"] = json!(ARTIFACT_TYPE);
+        entry["annotations"] = json!({ "org.opencontainers.image.ref.name": tag });
+        manifests.push(entry);
+
+        let index = json!({
+            "schemaVersion": 2,
+            "mediaType": "application/vnd.oci.image.index.v1+json",
+            "manifests": manifests,
+        });
+        std::fs::write(index_path, serde_json::to_vec_pretty(&index)?).map_err(Error::Io)
+    }
+}
+
+/// A parsed `registry/repository:tag` or `registry/repository@sha256:...`
+#[derive(Debug, Clone, PartialEq)]
+pub struct Reference {
+    pub registry: String,
+    pub repository: String,
+    /// Tag or manifest digest
+    pub reference: String,
+}
+
+impl Reference {
+    pub fn parse(reference: &str) -> Result<Self> {
+        let invalid = || Error::Registry(format!("Invalid OCI reference: '{}'", reference));
+        let (registry, rest) = reference.split_once('/').ok_or_else(invalid)?;
+        let (repository, tag) = match rest.split_once('@') {
+            Some((repository, digest)) => (repository, digest),
+            None => match rest.rsplit_once(':') {
+                Some((repository, tag)) if !tag.contains('/') => (repository, tag),
+                _ => (rest, "latest"),
+            },
+        };
+        if registry.is_empty() || repository.is_empty() || tag.is_empty() {
+            return Err(invalid());
+        }
+        Ok(Self {
+            registry: registry.to_string(),
+            repository: repository.to_string(),
+            reference: tag.to_string(),
+        })
+    }
+
+    fn url(&self, kind: &str, reference: &str) -> String {
+        format!(
+            "https://{}/v2/{}/{}/{}",
+            self.registry, self.repository, kind, reference
+        )
+    }
+}
+
+/// Pull a packaged spec from a registry over HTTPS (anonymous pulls)
+pub fn pull(reference: &str) -> Result<Spec> {
+    pull_with(&HttpTransport, reference)
+}
+
+/// Pull a packaged spec through a custom transport (credentials, tests)
+pub fn pull_with(transport: &dyn Transport, reference: &str) -> Result<Spec> {
+    let reference = Reference::parse(reference)?;
+    let manifest_bytes = transport.get_accepting(
+        &reference.url("manifests", &reference.reference),
+        MANIFEST_MEDIA_TYPE,
+    )?;
+    if reference.reference.starts_with("sha256:") {
+        check_digest(&reference.reference, &manifest_bytes)?;
+    }
+
+    let manifest: Value = serde_json::from_slice(&manifest_bytes)?;
+    let layer = |media_type: &str| -> Result<Option<Vec<u8>>> {
+        let Some(layer) = manifest["layers"]
+            .as_array()
+            .and_then(|layers| layers.iter().find(|l| l["mediaType"] == media_type))
+        else {
+            return Ok(None);
+        };
+        let digest = layer["digest"].as_str().unwrap_or_default();
+        let blob = transport.get(&reference.url("blobs", digest))?;
+        check_digest(digest, &blob)?;
+        Ok(Some(blob))
+    };
+
+    let blob = layer(JSON_MEDIA_TYPE)?.ok_or_else(|| {
+        Error::Registry(format!(
+            "{}/{} is not an imacs spec artifact",
+            reference.registry, reference.repository
+        ))
+    })?;
+    let json = String::from_utf8(blob).map_err(|e| Error::Registry(e.to_string()))?;
+    let mut spec = Spec::from_json(&json)?;
+    if !spec.tables.is_empty() {
+        let mut rows: BTreeMap<String, BTreeMap<String, ConditionValue>> =
+            match layer(TABLES_MEDIA_TYPE)? {
+                Some(blob) => serde_json::from_slice(&blob)?,
+                None => BTreeMap::new(),
+            };
+        for table in &mut spec.tables {
+            table.rows = rows.remove(&table.name).ok_or_else(|| {
+                Error::Registry(format!(
+                    "{}: the artifact has no rows for table '{}'",
+                    spec.id, table.name
+                ))
+            })?;
+        }
+    }
+
+    // The hash was taken with the table rows loaded, so compare only now
+    if let Some(expected) = manifest["annotations"][HASH_ANNOTATION].as_str() {
+        if spec.hash() != expected {
+            return Err(Error::Registry(format!(
+                "{}: spec hash mismatch (expected {}, got {})",
+                spec.id,
+                expected,
+                spec.hash()
+            )));
+        }
+    }
+    Ok(spec)
+}
+
+fn descriptor(media_type: &str, blob: &[u8]) -> Value {
+    json!({
+        "mediaType": media_type,
+        "digest": format!("sha256:{}", sha256_hex(blob)),
+        "size": blob.len(),
+    })
+}
+
+fn check_digest(expected: &str, blob: &[u8]) -> Result<()> {
+    let actual = format!("sha256:{}", sha256_hex(blob));
+    if actual != expected {
+        return Err(Error::Registry(format!(
+            "digest mismatch (expected {}, got {})",
+            expected, actual
+        )));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+meta:
+  version: "1.2.0"
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 10.0
+"#;
+
+    struct MemoryRegistry(HashMap<String, Vec<u8>>);
+
+    impl MemoryRegistry {
+        fn serving(artifact: &Artifact, tag: &str) -> Self {
+            let base = "https://ghcr.io/v2/acme/rules/shipping_rate";
+            let mut files = HashMap::new();
+            for blob in &artifact.blobs {
+                files.insert(
+                    format!("{}/blobs/sha256:{}", base, sha256_hex(blob)),
+                    blob.clone(),
+                );
+            }
+            for reference in [tag.to_string(), artifact.digest()] {
+                files.insert(
+                    format!("{}/manifests/{}", base, reference),
+                    artifact.manifest.clone(),
+                );
+            }
+            Self(files)
+        }
+    }
+
+    impl Transport for MemoryRegistry {
+        fn get(&self, url: &str) -> Result<Vec<u8>> {
+            self.0
+                .get(url)
+                .cloned()
+                .ok_or_else(|| Error::Registry(format!("GET {}: 404", url)))
+        }
+    }
+
+    #[test]
+    fn test_reference_parse() {
+        let reference = Reference::parse("ghcr.io/acme/rules/shipping_rate:1.2.0").unwrap();
+        assert_eq!(reference.registry, "ghcr.io");
+        assert_eq!(reference.repository, "acme/rules/shipping_rate");
+        assert_eq!(reference.reference, "1.2.0");
+
+        let reference = Reference::parse("localhost:5000/shipping_rate").unwrap();
+        assert_eq!(reference.registry, "localhost:5000");
+        assert_eq!(reference.reference, "latest");
+
+        let reference = Reference::parse("ghcr.io/acme/rate@sha256:abcd").unwrap();
+        assert_eq!(reference.reference, "sha256:abcd");
+        assert!(Reference::parse("shipping_rate").is_err());
+    }
+
+    #[test]
+    fn test_package_and_pull() {
+        let artifact = Artifact::from_spec(SPEC, Path::new(".")).unwrap();
+        let manifest: Value = serde_json::from_slice(&artifact.manifest).unwrap();
+        assert_eq!(manifest["artifactType"], ARTIFACT_TYPE);
+        assert_eq!(
+            manifest["annotations"]["org.opencontainers.image.version"],
+            "1.2.0"
+        );
+
+        let registry = MemoryRegistry::serving(&artifact, "1.2.0");
+        let spec = pull_with(&registry, "ghcr.io/acme/rules/shipping_rate:1.2.0").unwrap();
+        assert_eq!(spec.hash(), Spec::from_yaml(SPEC).unwrap().hash());
+        let by_digest = format!("ghcr.io/acme/rules/shipping_rate@{}", artifact.digest());
+        assert!(pull_with(&registry, &by_digest).is_ok());
+    }
+
+    #[test]
+    fn test_package_and_pull_tables() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(
+            dir.path().join("zone_rates.csv"),
+            "key,value\neu,4.5\nus,3.0\n",
+        )
+        .unwrap();
+        let yaml = SPEC
+            .replace(
+                "outputs:\n  - name: rate\n    type: float\n",
+                "outputs:\n  - name: rate\n    type: float\n  - name: carrier\n    type: string\n  - name: tier\n    type: string\n",
+            )
+            .replace(
+                "rules:\n",
+                "tables:\n  - name: zone_rates\n    file: zone_rates.csv\n    type: float\nrules:\n",
+            )
+            .replace(
+                "then: 5.0",
+                "then: {rate: 5.0, carrier: post, tier: standard}",
+            )
+            .replace(
+                "default: 10.0",
+                "default: {rate: \"lookup('zone_rates', zone, 10.0)\", carrier: freight, tier: bulk}",
+            );
+        let artifact = Artifact::from_spec(&yaml, dir.path()).unwrap();
+        let manifest: Value = serde_json::from_slice(&artifact.manifest).unwrap();
+        assert_eq!(manifest["layers"][2]["mediaType"], TABLES_MEDIA_TYPE);
+
+        let registry = MemoryRegistry::serving(&artifact, "1.2.0");
+        let pulled = pull_with(&registry, "ghcr.io/acme/rules/shipping_rate:1.2.0").unwrap();
+        let mut local = Spec::from_yaml(&yaml).unwrap();
+        local.load_tables(dir.path()).unwrap();
+        assert_eq!(pulled.tables[0].rows, local.tables[0].rows);
+        assert_eq!(pulled.hash(), local.hash());
+
+        let input = HashMap::from([("zone".to_string(), "eu".into())]);
+        let decided = crate::runtime::evaluate(&pulled, &input).unwrap();
+        assert_eq!(decided.outputs["rate"], crate::cel::CelValue::Float(4.5));
+        assert_eq!(decided, crate::runtime::evaluate(&local, &input).unwrap());
+
+        // Without its rows layer the pulled spec would decide differently
+        let mut stripped = MemoryRegistry::serving(&artifact, "1.2.0");
+        let rows_url = format!(
+            "https://ghcr.io/v2/acme/rules/shipping_rate/blobs/sha256:{}",
+            sha256_hex(&artifact.blobs[3])
+        );
+        stripped.0.remove(&rows_url);
+        assert!(pull_with(&stripped, "ghcr.io/acme/rules/shipping_rate:1.2.0").is_err());
+    }
+
+    #[test]
+    fn test_pull_rejects_tampered_layer() {
+        let artifact = Artifact::from_spec(SPEC, Path::new(".")).unwrap();
+        let mut registry = MemoryRegistry::serving(&artifact, "1.2.0");
+        let ir = &artifact.blobs[2];
+        let url = format!(
+            "https://ghcr.io/v2/acme/rules/shipping_rate/blobs/sha256:{}",
+            sha256_hex(ir)
+        );
+        let tampered = String::from_utf8(ir.clone()).unwrap().replace("5.0", "0.0");
+        registry.0.insert(url, tampered.into_bytes());
+        let err = pull_with(&registry, "ghcr.io/acme/rules/shipping_rate:1.2.0").unwrap_err();
+        assert!(err.to_string().contains("digest mismatch"));
+    }
+
+    #[test]
+    fn test_pull_rejects_hash_mismatch() {
+        let artifact = Artifact::from_spec(SPEC, Path::new(".")).unwrap();
+        let mut registry = MemoryRegistry::serving(&artifact, "1.2.0");
+        let mut manifest: Value = serde_json::from_slice(&artifact.manifest).unwrap();
+        manifest["annotations"][HASH_ANNOTATION] = json!("0000000000000000");
+        registry.0.insert(
+            "https://ghcr.io/v2/acme/rules/shipping_rate/manifests/1.2.0".into(),
+            serde_json::to_vec(&manifest).unwrap(),
+        );
+        let err = pull_with(&registry, "ghcr.io/acme/rules/shipping_rate:1.2.0").unwrap_err();
+        assert!(err.to_string().contains("spec hash mismatch"));
+    }
+
+    #[test]
+    fn test_invalid_spec_is_not_packaged() {
+        let invalid = format!(
+            "{}  - id: R1\n    when: \"zone == 'intl'\"\n    then: 1.0\n",
+            SPEC
+        );
+        assert!(Artifact::from_spec(&invalid, Path::new(".")).is_err());
+    }
+
+    #[test]
+    fn test_write_layout() {
+        let dir = std::env::temp_dir().join(format!("imacs-oci-test-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        let artifact = Artifact::from_spec(SPEC, Path::new(".")).unwrap();
+        artifact.write_layout(&dir, "1.2.0").unwrap();
+        artifact.write_layout(&dir, "latest").unwrap();
+        artifact.write_layout(&dir, "latest").unwrap();
+
+        let index: Value =
+            serde_json::from_str(&std::fs::read_to_string(dir.join("index.json")).unwrap())
+                .unwrap();
+        assert_eq!(index["manifests"].as_array().unwrap().len(), 2);
+        let manifest_path = dir
+            .join("blobs/sha256")
+            .join(artifact.digest().trim_start_matches("sha256:"));
+        assert_eq!(std::fs::read(manifest_path).unwrap(), artifact.manifest);
+        std::fs::remove_dir_all(dir).unwrap();
+    }
+}
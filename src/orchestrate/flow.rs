@@ -0,0 +1,616 @@
+//! Flow graph checks
+//!
+//! Structural problems a flow can have once it branches and calls other
+//! flows, each reported with the location of the offending step
+//! (`chain[2].cases.US[0] (notify)`):
+//!
+//! - cycles between flows that call each other as sub-flows
+//! - steps that can never execute: after an unconditional return or an
+//!   always-failing gate, behind a `false` condition, or in a loop body
+//!   with `max_iterations: 0`
+//! - context values read before any step has produced them
+//!
+//! Only names the flow produces somewhere (step results, computed and set
+//! values, loop and catch variables) count as context values; other
+//! identifiers are left to the target language, as in code generation.
+
+use super::{ChainStep, Orchestrator};
+use crate::cel::CelCompiler;
+use std::collections::{BTreeMap, HashMap, HashSet, VecDeque};
+
+impl Orchestrator {
+    /// Cycles, unreachable steps and reads before writes in this flow
+    pub fn flow_issues(&self) -> Vec<String> {
+        let mut producible = HashSet::new();
+        visit(&self.chain, "chain", &mut |_, step| {
+            producible.extend(produced_by(step));
+            match step {
+                ChainStep::Loop(l) => {
+                    producible.insert(l.counter.clone());
+                }
+                ChainStep::ForEach(f) => {
+                    producible.insert(f.item.clone());
+                    producible.insert(f.index.clone());
+                }
+                ChainStep::Try(t) => producible.extend(t.catch.iter().map(|c| c.error.clone())),
+                _ => {}
+            }
+        });
+        for input in &self.inputs {
+            producible.remove(&input.name);
+        }
+
+        let mut walk = Walk {
+            producible,
+            issues: Vec::new(),
+        };
+        let mut scope: HashSet<String> = self.inputs.iter().map(|i| i.name.clone()).collect();
+        walk.steps(&self.chain, "chain", &mut scope);
+
+        let mut issues = flow_cycles(std::slice::from_ref(self));
+        issues.extend(walk.issues);
+        issues
+    }
+}
+
+/// Cycles between flows that call each other as sub-flows
+///
+/// A call step (or a dynamic step's allowed spec) naming another flow's id
+/// runs that flow; a cycle would recurse forever. Flows caught in several
+/// cycles with each other are reported once, by the shortest cycle through
+/// the first of them.
+pub fn flow_cycles(flows: &[Orchestrator]) -> Vec<String> {
+    let ids: HashSet<&str> = flows.iter().map(|f| f.id.as_str()).collect();
+    // flow -> (callee, location of the first call)
+    let mut edges: CallGraph = BTreeMap::new();
+    for flow in flows {
+        let calls = edges.entry(flow.id.as_str()).or_default();
+        visit(&flow.chain, "chain", &mut |location, step| {
+            let callees = match step {
+                ChainStep::Call(c) => vec![c.spec.clone()],
+                ChainStep::Dynamic(d) => d.allowed.clone(),
+                _ => Vec::new(),
+            };
+            for callee in callees {
+                if ids.contains(callee.as_str()) && !calls.iter().any(|(c, _)| *c == callee) {
+                    calls.push((callee, location.to_string()));
+                }
+            }
+        });
+    }
+
+    // One report per strongly connected component: listing every cycle
+    // would take time exponential in the number of flows
+    let mut components = components(&edges);
+    components.retain(|component| {
+        component.len() > 1 || edges[component[0]].iter().any(|(c, _)| c == component[0])
+    });
+    components.iter_mut().for_each(|component| component.sort());
+    components.sort();
+
+    let mut cycles = Vec::new();
+    for component in components {
+        let cycle = shortest_cycle(&edges, &component);
+        let calls: Vec<String> = (0..cycle.len())
+            .map(|i| {
+                let (from, to) = (cycle[i], cycle[(i + 1) % cycle.len()]);
+                let (_, location) = edges[from].iter().find(|(c, _)| c == to).unwrap();
+                format!("{} calls {} at {}", from, to, location)
+            })
+            .collect();
+        let mut path = cycle.clone();
+        path.push(cycle[0]);
+        cycles.push(format!(
+            "Flow cycle {}: {}",
+            path.join(" → "),
+            calls.join(", ")
+        ));
+    }
+    cycles
+}
+
+type CallGraph<'a> = BTreeMap<&'a str, Vec<(String, String)>>;
+
+/// The callee's node in the graph; every callee is one of the flows
+fn node<'a>(edges: &CallGraph<'a>, callee: &str) -> &'a str {
+    edges.get_key_value(callee).map(|(id, _)| *id).unwrap()
+}
+
+/// Strongly connected components of the call graph (Tarjan's algorithm)
+fn components<'a>(edges: &CallGraph<'a>) -> Vec<Vec<&'a str>> {
+    struct Tarjan<'a, 'g> {
+        edges: &'g CallGraph<'a>,
+        index: HashMap<&'a str, usize>,
+        low: HashMap<&'a str, usize>,
+        stack: Vec<&'a str>,
+        on_stack: HashSet<&'a str>,
+        components: Vec<Vec<&'a str>>,
+    }
+
+    impl<'a> Tarjan<'a, '_> {
+        fn connect(&mut self, flow: &'a str) {
+            let index = self.index.len();
+            self.index.insert(flow, index);
+            self.low.insert(flow, index);
+            self.stack.push(flow);
+            self.on_stack.insert(flow);
+
+            let edges = self.edges;
+            for (callee, _) in &edges[flow] {
+                let callee = node(edges, callee);
+                if !self.index.contains_key(callee) {
+                    self.connect(callee);
+                    let low = self.low[flow].min(self.low[callee]);
+                    self.low.insert(flow, low);
+                } else if self.on_stack.contains(callee) {
+                    let low = self.low[flow].min(self.index[callee]);
+                    self.low.insert(flow, low);
+                }
+            }
+
+            if self.low[flow] == index {
+                let mut component = Vec::new();
+                while let Some(member) = self.stack.pop() {
+                    self.on_stack.remove(member);
+                    component.push(member);
+                    if member == flow {
+                        break;
+                    }
+                }
+                self.components.push(component);
+            }
+        }
+    }
+
+    let mut tarjan = Tarjan {
+        edges,
+        index: HashMap::new(),
+        low: HashMap::new(),
+        stack: Vec::new(),
+        on_stack: HashSet::new(),
+        components: Vec::new(),
+    };
+    for &flow in edges.keys() {
+        if !tarjan.index.contains_key(flow) {
+            tarjan.connect(flow);
+        }
+    }
+    tarjan.components
+}
+
+/// The shortest cycle through the first flow of a sorted component, found
+/// breadth first without leaving the component
+fn shortest_cycle<'a>(edges: &CallGraph<'a>, component: &[&'a str]) -> Vec<&'a str> {
+    let start = component[0];
+    let mut parent: HashMap<&str, &'a str> = HashMap::new();
+    let mut queue = VecDeque::from([start]);
+    let mut last = start;
+    'search: while let Some(flow) = queue.pop_front() {
+        for (callee, _) in &edges[flow] {
+            let callee = node(edges, callee);
+            if callee == start {
+                last = flow;
+                break 'search;
+            }
+            if component.contains(&callee) && !parent.contains_key(callee) {
+                parent.insert(callee, flow);
+                queue.push_back(callee);
+            }
+        }
+    }
+
+    let mut cycle = vec![last];
+    while let Some(&previous) = parent.get(cycle[cycle.len() - 1]) {
+        cycle.push(previous);
+    }
+    cycle.reverse();
+    cycle
+}
+
+struct Walk {
+    /// Context names some step in the flow produces
+    producible: HashSet<String>,
+    issues: Vec<String>,
+}
+
+impl Walk {
+    /// Check a step sequence, adding what it produces to `scope`
+    ///
+    /// Returns the location of the step that ends the sequence, if one
+    /// always does.
+    fn steps(
+        &mut self,
+        steps: &[ChainStep],
+        path: &str,
+        scope: &mut HashSet<String>,
+    ) -> Option<String> {
+        let mut ended: Option<String> = None;
+        for (i, step) in steps.iter().enumerate() {
+            let location = locate(&format!("{}[{}]", path, i), step);
+            if let Some(end) = &ended {
+                self.issues.push(format!(
+                    "{}: unreachable, the flow always ends at {}",
+                    location, end
+                ));
+                break;
+            }
+            if self.step(step, &format!("{}[{}]", path, i), &location, scope) {
+                ended = Some(location);
+            }
+        }
+        ended
+    }
+
+    /// Check one step; returns true if the flow always ends at it
+    fn step(
+        &mut self,
+        step: &ChainStep,
+        path: &str,
+        location: &str,
+        scope: &mut HashSet<String>,
+    ) -> bool {
+        let mut ends = false;
+        match step {
+            ChainStep::Call(c) => {
+                self.read(location, c.condition.iter().chain(c.inputs.values()), scope);
+                if c.condition.as_deref().map(str::trim) == Some("false") {
+                    self.never(location, "its condition is always false");
+                }
+            }
+            ChainStep::Parallel(p) => {
+                // Parallel steps cannot see each other's results
+                let entry = scope.clone();
+                for (i, inner) in p.steps.iter().enumerate() {
+                    let inner_path = format!("{}.steps[{}]", path, i);
+                    let mut inner_scope = entry.clone();
+                    self.step(
+                        inner,
+                        &inner_path,
+                        &locate(&inner_path, inner),
+                        &mut inner_scope,
+                    );
+                    scope.extend(inner_scope);
+                }
+            }
+            ChainStep::Branch(b) => {
+                self.read(location, [&b.on], scope);
+                let entry = scope.clone();
+                let mut arms: Vec<(String, &Vec<ChainStep>)> = b
+                    .cases
+                    .iter()
+                    .map(|(case, steps)| (format!("{}.cases.{}", path, case), steps))
+                    .collect();
+                arms.sort_by(|a, b| a.0.cmp(&b.0));
+                arms.extend(b.default.iter().map(|d| (format!("{}.default", path), d)));
+                let mut all_end = b.default.is_some();
+                for (arm_path, steps) in arms {
+                    let mut arm_scope = entry.clone();
+                    all_end &= self.steps(steps, &arm_path, &mut arm_scope).is_some();
+                    scope.extend(arm_scope);
+                }
+                ends = all_end;
+            }
+            ChainStep::Loop(l) => {
+                if l.max_iterations == 0 && !l.steps.is_empty() {
+                    self.issues.push(format!(
+                        "{}: body never executes, max_iterations is 0",
+                        location
+                    ));
+                }
+                let mut body = scope.clone();
+                body.insert(l.counter.clone());
+                let body_ends = self
+                    .steps(&l.steps, &format!("{}.steps", path), &mut body)
+                    .is_some();
+                self.read(location, l.until.iter(), &body);
+                body.remove(&l.counter);
+                scope.extend(body);
+                ends = body_ends && l.max_iterations > 0;
+            }
+            ChainStep::ForEach(f) => {
+                self.read(location, [&f.collection], scope);
+                let mut body = scope.clone();
+                body.insert(f.item.clone());
+                body.insert(f.index.clone());
+                self.steps(&f.steps, &format!("{}.steps", path), &mut body);
+                body.remove(&f.item);
+                body.remove(&f.index);
+                scope.extend(body);
+            }
+            ChainStep::Gate(g) => {
+                self.read(location, [&g.condition], scope);
+                ends = g.condition.trim() == "false";
+            }
+            ChainStep::Return(r) => {
+                self.read(location, r.condition.iter().chain([&r.value]), scope);
+                match r.condition.as_deref().map(str::trim) {
+                    None | Some("true") => ends = true,
+                    Some("false") => self.never(location, "its condition is always false"),
+                    Some(_) => {}
+                }
+            }
+            ChainStep::Compute(c) => self.read(location, [&c.expr], scope),
+            ChainStep::Set(s) => self.read(location, [&s.value], scope),
+            ChainStep::Try(t) => {
+                let entry = scope.clone();
+                let try_ends = self
+                    .steps(&t.try_steps, &format!("{}.try", path), scope)
+                    .is_some();
+                let mut catch_ends = true;
+                if let Some(catch) = &t.catch {
+                    // The catch block may run after any step of the try block
+                    let mut catch_scope = entry.clone();
+                    catch_scope.insert(catch.error.clone());
+                    catch_ends = self
+                        .steps(&catch.steps, &format!("{}.catch", path), &mut catch_scope)
+                        .is_some();
+                    catch_scope.remove(&catch.error);
+                    scope.extend(catch_scope);
+                }
+                let mut finally_ends = false;
+                if let Some(finally) = &t.finally {
+                    let mut finally_scope = entry;
+                    finally_ends = self
+                        .steps(finally, &format!("{}.finally", path), &mut finally_scope)
+                        .is_some();
+                    scope.extend(finally_scope);
+                }
+                ends = finally_ends || (try_ends && catch_ends);
+            }
+            ChainStep::Dynamic(d) => self.read(
+                location,
+                [&d.spec].into_iter().chain(d.inputs.values()),
+                scope,
+            ),
+            ChainStep::Await(a) => self.read(location, [&a.expr], scope),
+            ChainStep::Emit(e) => self.read(location, [&e.data], scope),
+            ChainStep::AwaitApproval(_) => {}
+        }
+        scope.extend(produced_by(step));
+        ends
+    }
+
+    /// Report context names an expression reads that are not yet produced
+    fn read<'a>(
+        &mut self,
+        location: &str,
+        exprs: impl IntoIterator<Item = &'a String>,
+        scope: &HashSet<String>,
+    ) {
+        for expr in exprs {
+            // Expressions that are not CEL are left to code generation
+            let Ok(vars) = CelCompiler::extract_variables(expr) else {
+                continue;
+            };
+            for var in vars {
+                if self.producible.contains(&var) && !scope.contains(&var) {
+                    self.issues.push(format!(
+                        "{}: reads '{}' before any step produces it",
+                        location, var
+                    ));
+                }
+            }
+        }
+    }
+
+    fn never(&mut self, location: &str, reason: &str) {
+        self.issues
+            .push(format!("{}: never executes, {}", location, reason));
+    }
+}
+
+/// Context names a step produces once it has run (not counting nested steps)
+fn produced_by(step: &ChainStep) -> Vec<String> {
+    match step {
+        ChainStep::Call(c) => vec![c.id.clone()],
+        ChainStep::Compute(c) => vec![c.id.clone(), c.name.clone()],
+        ChainStep::Set(s) => vec![s.name.clone()],
+        ChainStep::Dynamic(d) => vec![d.id.clone()],
+        ChainStep::Await(a) => vec![a.id.clone()],
+        ChainStep::AwaitApproval(a) => vec![a.id.clone()],
+        _ => Vec::new(),
+    }
+}
+
+/// A step's location with its id, e.g. `chain[2].cases.US[0] (notify)`
+fn locate(path: &str, step: &ChainStep) -> String {
+    let id = match step {
+        ChainStep::Call(s) => &s.id,
+        ChainStep::Parallel(s) => &s.id,
+        ChainStep::Branch(s) => &s.id,
+        ChainStep::Loop(s) => &s.id,
+        ChainStep::ForEach(s) => &s.id,
+        ChainStep::Gate(s) => &s.id,
+        ChainStep::Compute(s) => &s.id,
+        ChainStep::Try(s) => &s.id,
+        ChainStep::Dynamic(s) => &s.id,
+        ChainStep::Await(s) => &s.id,
+        ChainStep::AwaitApproval(s) => &s.id,
+        ChainStep::Return(_) => return format!("{} (return)", path),
+        ChainStep::Set(s) => return format!("{} (set {})", path, s.name),
+        ChainStep::Emit(s) => return format!("{} (emit {})", path, s.event),
+    };
+    format!("{} ({})", path, id)
+}
+
+/// Visit every step in a chain, nested steps included, with its location
+pub(super) fn visit(steps: &[ChainStep], path: &str, f: &mut dyn FnMut(&str, &ChainStep)) {
+    for (i, step) in steps.iter().enumerate() {
+        let path = format!("{}[{}]", path, i);
+        f(&locate(&path, step), step);
+        match step {
+            ChainStep::Parallel(p) => visit(&p.steps, &format!("{}.steps", path), f),
+            ChainStep::Branch(b) => {
+                let mut cases: Vec<_> = b.cases.iter().collect();
+                cases.sort_by(|a, b| a.0.cmp(b.0));
+                for (case, steps) in cases {
+                    visit(steps, &format!("{}.cases.{}", path, case), f);
+                }
+                if let Some(d) = &b.default {
+                    visit(d, &format!("{}.default", path), f);
+                }
+            }
+            ChainStep::Loop(l) => visit(&l.steps, &format!("{}.steps", path), f),
+            ChainStep::ForEach(e) => visit(&e.steps, &format!("{}.steps", path), f),
+            ChainStep::Try(t) => {
+                visit(&t.try_steps, &format!("{}.try", path), f);
+                if let Some(c) = &t.catch {
+                    visit(&c.steps, &format!("{}.catch", path), f);
+                }
+                if let Some(finally) = &t.finally {
+                    visit(finally, &format!("{}.finally", path), f);
+                }
+            }
+            _ => {}
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_flow_issues() {
+        let orch = Orchestrator::from_yaml(
+            r#"
+id: checkout
+inputs:
+  - name: country
+    type: string
+chain:
+  - step: gate
+    id: require_quote
+    condition: "quote.total > 0"
+  - step: call
+    id: quote
+    spec: pricing
+    inputs:
+      country: country
+  - step: branch
+    id: route
+    on: country
+    cases:
+      US:
+        - step: return
+          value: "quote.total"
+        - step: emit
+          event: shipped
+          data: quote
+    default:
+      - step: call
+        id: tax
+        spec: vat
+        condition: "false"
+  - step: loop
+    id: retry
+    max_iterations: 0
+    steps:
+      - step: call
+        id: charge
+        spec: payments
+"#,
+        )
+        .unwrap();
+        assert_eq!(
+            orch.flow_issues(),
+            vec![
+                "chain[0] (require_quote): reads 'quote' before any step produces it",
+                "chain[2].cases.US[1] (emit shipped): unreachable, the flow always ends at chain[2].cases.US[0] (return)",
+                "chain[2].default[0] (tax): never executes, its condition is always false",
+                "chain[3] (retry): body never executes, max_iterations is 0",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_branch_ending_every_arm() {
+        let orch = Orchestrator::from_yaml(
+            r#"
+id: route
+chain:
+  - step: compute
+    id: c
+    name: tier
+    expr: "1"
+  - step: branch
+    id: by_tier
+    on: tier
+    cases:
+      "1":
+        - step: return
+          value: "'gold'"
+    default:
+      - step: gate
+        id: never
+        condition: "false"
+  - step: set
+    name: done
+    value: "true"
+"#,
+        )
+        .unwrap();
+        assert_eq!(
+            orch.flow_issues(),
+            vec!["chain[2] (set done): unreachable, the flow always ends at chain[1] (by_tier)"]
+        );
+    }
+
+    #[test]
+    fn test_flow_cycles() {
+        let flow = |id: &str, calls: &str| {
+            Orchestrator::from_yaml(&format!(
+                "id: {}\nchain:\n  - step: call\n    id: sub\n    spec: {}\n",
+                id, calls
+            ))
+            .unwrap()
+        };
+        let flows = vec![
+            flow("checkout", "payment"),
+            flow("payment", "checkout"),
+            flow("refund", "payment"),
+        ];
+        assert_eq!(
+            flow_cycles(&flows),
+            vec![
+                "Flow cycle checkout → payment → checkout: checkout calls payment at chain[0] (sub), payment calls checkout at chain[0] (sub)"
+            ]
+        );
+        assert!(flow_cycles(&flows[2..]).is_empty());
+        assert_eq!(flow("loop", "loop").flow_issues().len(), 1);
+    }
+
+    #[test]
+    fn test_flow_cycles_per_component() {
+        let flow = |id: &str, calls: &[&str]| {
+            let steps: String = calls
+                .iter()
+                .enumerate()
+                .map(|(i, callee)| {
+                    format!("  - step: call\n    id: sub{}\n    spec: {}\n", i, callee)
+                })
+                .collect();
+            Orchestrator::from_yaml(&format!("id: {}\nchain:\n{}", id, steps)).unwrap()
+        };
+        // Two cycles through b and c form one component
+        let flows = vec![flow("a", &["b"]), flow("b", &["c"]), flow("c", &["a", "b"])];
+        assert_eq!(
+            flow_cycles(&flows),
+            vec!["Flow cycle a → b → c → a: a calls b at chain[0] (sub0), b calls c at chain[0] (sub0), c calls a at chain[0] (sub0)"]
+        );
+
+        // Every flow calling every other one: too many cycles to list
+        let ids: Vec<String> = (0..24).map(|i| format!("f{:02}", i)).collect();
+        let flows: Vec<Orchestrator> = ids
+            .iter()
+            .map(|id| {
+                let others: Vec<&str> =
+                    ids.iter().map(String::as_str).filter(|o| o != id).collect();
+                flow(id, &others)
+            })
+            .collect();
+        let cycles = flow_cycles(&flows);
+        assert_eq!(cycles.len(), 1);
+        assert!(cycles[0].starts_with("Flow cycle f00 → f01 → f00: "));
+    }
+}
@@ -8,13 +8,20 @@
 //! - Gates and guards (fail fast if condition not met)
 //! - Error handling (try/catch/finally)
 //!
+//! [`Orchestrator::validate`] also checks the flow graph: sub-flow cycles,
+//! unreachable steps and context values read before they are produced.
+//!
 //! Code generation uses MiniJinja templates for properly formatted output.
 
 use crate::cel::Target;
-use crate::spec::{Spec, VarType};
+use crate::spec::{ConditionValue, Redact, Spec, VarType};
 use crate::templates;
 use serde::{Deserialize, Serialize};
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
+
+mod flow;
+
+pub use flow::flow_cycles;
 
 /// Render an orchestrator to target language using templates
 ///
@@ -55,6 +62,91 @@ pub struct Orchestrator {
     /// Namespace/scoping configuration for code generation
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub scoping: Option<crate::render::ScopingConfig>,
+    /// Message topic that triggers the orchestrator; generates a consumer loop
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub trigger: Option<Trigger>,
+    /// Generate a runner that checkpoints state after every step, so an
+    /// interrupted run resumes where it left off
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub durable: bool,
+    /// Generate callbacks for step, gate and flow transitions
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub hooks: bool,
+    /// Take the trace (correlation) ID from a `context.Context` and stamp it
+    /// on errors, events and checkpoints
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub tracing: bool,
+    /// Log step durations and gate outcomes through a generated, injectable
+    /// `log/slog` logger (Go)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub logging: bool,
+    /// Cap concurrent runs; generates a semaphore-guarded `Execute` variant
+    /// with queue and rejection counters (Go)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub bulkhead: Option<Bulkhead>,
+    /// Time budget of a run (ms), divided across call steps by weight
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub deadline_ms: Option<u64>,
+    /// Generate a constructor taking the flow's dependencies (call steps,
+    /// interceptors, logger, clock) as one struct, for DI containers and
+    /// stubbing (Go)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub injectable: bool,
+    /// Named end-to-end runs; each generates a flow test
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub scenarios: Vec<Scenario>,
+}
+
+/// A named run of the flow with its expected outcome
+///
+/// Call steps listed under `steps` are mocked with the given outputs in the
+/// generated test, so scenarios exercise the flow wiring without the
+/// called specs.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Scenario {
+    pub name: String,
+    #[serde(default)]
+    pub description: Option<String>,
+    /// Flow inputs; omitted inputs take their zero value
+    #[serde(default)]
+    pub inputs: BTreeMap<String, ConditionValue>,
+    /// Outputs returned by call steps, by step ID
+    #[serde(default)]
+    pub steps: BTreeMap<String, BTreeMap<String, ConditionValue>>,
+    /// Expected gate results, by gate ID
+    #[serde(default)]
+    pub gates: BTreeMap<String, bool>,
+    /// Expected flow outputs
+    #[serde(default)]
+    pub outputs: BTreeMap<String, ConditionValue>,
+}
+
+/// Event-driven trigger: consume inputs from a topic, publish results
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Trigger {
+    /// Topic the input events arrive on
+    pub topic: String,
+    /// Topic outputs are published to
+    pub result_topic: String,
+    /// Topic failures are published to (defaults to `result_topic`)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub error_topic: Option<String>,
+}
+
+/// Concurrency limit of a flow
+///
+/// Runs beyond `max_concurrent` wait for a slot; they are rejected when
+/// `max_queued` runs are already waiting or after waiting `wait_ms`.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Bulkhead {
+    /// Runs in progress at once
+    pub max_concurrent: u32,
+    /// Runs waiting for a slot at once (unbounded when absent)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_queued: Option<u32>,
+    /// Longest wait for a slot (ms); waits until cancelled when absent
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub wait_ms: Option<u64>,
 }
 
 impl Orchestrator {
@@ -129,9 +221,95 @@ impl Orchestrator {
         // Check inputs are provided for all call steps
         self.validate_chain(&self.chain, specs, &mut errors);
 
+        // Generated code guards, budgets and degrades top-level call steps only
+        for step in self
+            .chain
+            .iter()
+            .filter(|s| !matches!(s, ChainStep::Call(_)))
+        {
+            flow::visit(std::slice::from_ref(step), "chain", &mut |_, nested| {
+                if let ChainStep::Call(call) = nested {
+                    let features = call.features();
+                    if !features.is_empty() {
+                        errors.push(format!(
+                            "Step '{}' is nested; only top-level call steps support {}",
+                            call.id,
+                            features.join(", ")
+                        ));
+                    }
+                }
+            });
+        }
+
+        self.validate_scenarios(&ids, &mut errors);
+
+        if self
+            .bulkhead
+            .as_ref()
+            .is_some_and(|b| b.max_concurrent == 0)
+        {
+            errors.push("Bulkhead max_concurrent must be at least 1".to_string());
+        }
+
+        errors.extend(self.flow_issues());
+
         errors
     }
 
+    fn validate_scenarios(&self, step_ids: &[String], errors: &mut Vec<String>) {
+        let mut names = std::collections::HashSet::new();
+        for scenario in &self.scenarios {
+            if !names.insert(scenario.name.as_str()) {
+                errors.push(format!("Duplicate scenario: {}", scenario.name));
+            }
+            for input in scenario.inputs.keys() {
+                if !self.inputs.iter().any(|i| &i.name == input) {
+                    errors.push(format!(
+                        "Scenario '{}' sets unknown input '{}'",
+                        scenario.name, input
+                    ));
+                }
+            }
+            for step in scenario.steps.keys().chain(scenario.gates.keys()) {
+                if !step_ids.contains(step) {
+                    errors.push(format!(
+                        "Scenario '{}' references unknown step '{}'",
+                        scenario.name, step
+                    ));
+                }
+            }
+            for output in scenario.outputs.keys() {
+                if !self.outputs.iter().any(|o| &o.name == output) {
+                    errors.push(format!(
+                        "Scenario '{}' expects unknown output '{}'",
+                        scenario.name, output
+                    ));
+                }
+            }
+        }
+    }
+
+    /// Features of this flow that only the Go template implements, each with
+    /// the step using it
+    pub fn go_only_features(&self) -> Vec<String> {
+        let mut features = Vec::new();
+        if self.deadline_ms.is_some() {
+            features.push("deadline_ms".to_string());
+        }
+        flow::visit(&self.chain, "chain", &mut |_, step| match step {
+            ChainStep::AwaitApproval(approval) => {
+                features.push(format!("await_approval (step '{}')", approval.id))
+            }
+            ChainStep::Call(call) => features.extend(
+                call.features()
+                    .into_iter()
+                    .map(|feature| format!("{} (step '{}')", feature, call.id)),
+            ),
+            _ => {}
+        });
+        features
+    }
+
     fn validate_chain(
         &self,
         steps: &[ChainStep],
@@ -151,6 +329,47 @@ impl Orchestrator {
                             }
                         }
                     }
+                    if let Some(limit) = &call.rate_limit {
+                        if limit.per_second <= 0.0 || limit.burst == 0 {
+                            errors.push(format!(
+                                "Step '{}' rate_limit needs a positive per_second and burst",
+                                call.id
+                            ));
+                        }
+                    }
+                    let degrades = call.on_error == Some(OnError::Default);
+                    if degrades && !call.optional {
+                        errors.push(format!(
+                            "Step '{}' can only fall back to defaults when optional",
+                            call.id
+                        ));
+                    }
+                    if !call.default.is_empty() && !degrades {
+                        errors.push(format!(
+                            "Step '{}' declares defaults but not on_error: default",
+                            call.id
+                        ));
+                    }
+                    if let Some(spec) = specs.get(&call.spec) {
+                        for output in call.default.keys() {
+                            if !spec.outputs.iter().any(|o| &o.name == output) {
+                                errors.push(format!(
+                                    "Step '{}' default sets unknown output '{}' of spec '{}'",
+                                    call.id, output, call.spec
+                                ));
+                            }
+                        }
+                    }
+                    if call
+                        .circuit_breaker
+                        .as_ref()
+                        .is_some_and(|b| b.failures == 0)
+                    {
+                        errors.push(format!(
+                            "Step '{}' circuit_breaker needs at least 1 failure to open",
+                            call.id
+                        ));
+                    }
                 }
                 ChainStep::Parallel(par) => self.validate_chain(&par.steps, specs, errors),
                 ChainStep::Branch(branch) => {
@@ -186,6 +405,19 @@ pub struct OrchestratorInput {
     pub var_type: VarType,
     #[serde(default)]
     pub description: Option<String>,
+    /// Personal data: masked in gate failures (same as `redact: mask`)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub pii: bool,
+    /// How the value is hidden in gate failures
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub redact: Option<Redact>,
+}
+
+impl OrchestratorInput {
+    /// How the value must be hidden, if at all
+    pub fn redaction(&self) -> Option<Redact> {
+        self.redact.or(self.pii.then_some(Redact::Mask))
+    }
 }
 
 /// Output from an orchestrator
@@ -228,6 +460,9 @@ pub enum ChainStep {
     Await(AwaitStep),
     /// Emit an event
     Emit(EmitStep),
+    /// Pause for a human decision; the flow resumes with the decision
+    #[serde(rename = "await_approval")]
+    AwaitApproval(AwaitApprovalStep),
 }
 
 /// Call a spec with mapped inputs
@@ -252,6 +487,58 @@ pub struct CallStep {
     /// Retry configuration
     #[serde(default)]
     pub retry: Option<RetryConfig>,
+    /// The called spec acts on the outside world (charges, emails, writes);
+    /// dry runs record it as an intent instead of calling it
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub side_effect: bool,
+    /// Limit the rate of calls; calls over the limit fail fast
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rate_limit: Option<RateLimit>,
+    /// Stop calling after repeated failures until a cooldown has passed
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub circuit_breaker: Option<CircuitBreaker>,
+    /// Share of the flow deadline relative to the other call steps (1 when
+    /// absent)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub weight: Option<u32>,
+    /// The flow can do without this step; it is skipped when the remaining
+    /// deadline does not cover its share
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub optional: bool,
+    /// What a failed call does to the flow (fails it when absent)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub on_error: Option<OnError>,
+    /// Outputs an optional step falls back to with `on_error: default`
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub default: BTreeMap<String, ConditionValue>,
+}
+
+impl CallStep {
+    /// Call policies the step declares (rate limiting, circuit breaking,
+    /// deadline sharing, degradation)
+    fn features(&self) -> Vec<&'static str> {
+        [
+            (self.rate_limit.is_some(), "rate_limit"),
+            (self.circuit_breaker.is_some(), "circuit_breaker"),
+            (self.weight.is_some(), "weight"),
+            (self.optional, "optional"),
+            (self.on_error == Some(OnError::Default), "on_error: default"),
+        ]
+        .into_iter()
+        .filter_map(|(declared, feature)| declared.then_some(feature))
+        .collect()
+    }
+}
+
+/// How a call step's failure affects the flow
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize)]
+#[serde(rename_all = "snake_case")]
+pub enum OnError {
+    /// Fail the flow
+    Fail,
+    /// Continue with the step's declared default outputs and record the
+    /// degradation
+    Default,
 }
 
 /// Execute steps in parallel
@@ -435,6 +722,18 @@ pub struct EmitStep {
     pub data: String,
 }
 
+/// Pause the flow until a reviewer approves or rejects it
+///
+/// The decision is available to later steps as `{id}.approved`,
+/// `{id}.reviewer` and `{id}.comment`.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct AwaitApprovalStep {
+    pub id: String,
+    /// What the reviewer is asked to decide
+    #[serde(default)]
+    pub prompt: Option<String>,
+}
+
 /// Retry configuration
 #[derive(Debug, Clone, Serialize, Deserialize)]
 pub struct RetryConfig {
@@ -457,6 +756,43 @@ fn default_delay() -> u64 {
     1000
 }
 
+/// Token bucket rate limit of a call step
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct RateLimit {
+    /// Sustained calls per second
+    pub per_second: f64,
+    /// Calls allowed at once after an idle period
+    #[serde(default = "default_burst")]
+    pub burst: u32,
+}
+
+fn default_burst() -> u32 {
+    1
+}
+
+/// Circuit breaker of a call step
+///
+/// The circuit opens after `failures` consecutive failed calls; once
+/// `cooldown_ms` has passed a single trial call is let through, which
+/// closes it again on success.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct CircuitBreaker {
+    /// Consecutive failures that open the circuit
+    #[serde(default = "default_breaker_failures")]
+    pub failures: u32,
+    /// Time the circuit stays open (ms)
+    #[serde(default = "default_cooldown")]
+    pub cooldown_ms: u64,
+}
+
+fn default_breaker_failures() -> u32 {
+    5
+}
+
+fn default_cooldown() -> u64 {
+    30_000
+}
+
 // ============================================================================
 // Utility functions
 // ============================================================================
@@ -525,6 +861,7 @@ pub fn collect_step_ids(steps: &[ChainStep]) -> Vec<String> {
             }
             ChainStep::Dynamic(d) => ids.push(d.id.clone()),
             ChainStep::Await(a) => ids.push(a.id.clone()),
+            ChainStep::AwaitApproval(a) => ids.push(a.id.clone()),
             _ => {}
         }
     }
@@ -1012,4 +1349,163 @@ chain:
         // Should NOT warn about this branch since it has a spec call
         assert!(!report.warnings.iter().any(|w| w.contains("my_branch")));
     }
+
+    #[test]
+    fn test_validate_scenarios() {
+        let yaml = r#"
+id: order_flow
+inputs:
+  - name: role
+    type: string
+chain:
+  - step: gate
+    id: require_role
+    condition: "role != ''"
+scenarios:
+  - name: guest
+    inputs:
+      role: guest
+      tier: gold
+    gates:
+      require_role: true
+      require_access: false
+  - name: guest
+"#;
+        let orch = Orchestrator::from_yaml(yaml).unwrap();
+        let errors = orch.validate(&HashMap::new());
+        assert!(errors.contains(&"Duplicate scenario: guest".to_string()));
+        assert!(errors.contains(&"Scenario 'guest' sets unknown input 'tier'".to_string()));
+        assert!(errors
+            .contains(&"Scenario 'guest' references unknown step 'require_access'".to_string()));
+        assert_eq!(errors.len(), 3);
+    }
+
+    #[test]
+    fn test_rate_limit_and_circuit_breaker() {
+        let yaml = r#"
+id: checkout
+chain:
+  - step: call
+    id: charge
+    spec: charge_card
+    rate_limit:
+      per_second: 20
+    circuit_breaker:
+      failures: 0
+  - step: call
+    id: notify
+    spec: send_receipt
+    rate_limit:
+      per_second: 0
+    circuit_breaker: {}
+  - step: parallel
+    id: fan_out
+    steps:
+      - step: call
+        id: audit
+        spec: audit_log
+        circuit_breaker: {}
+"#;
+        let orch = Orchestrator::from_yaml(yaml).unwrap();
+        let ChainStep::Call(notify) = &orch.chain[1] else {
+            panic!("expected a call step");
+        };
+        assert_eq!(
+            notify.circuit_breaker,
+            Some(CircuitBreaker {
+                failures: 5,
+                cooldown_ms: 30_000
+            })
+        );
+        let ChainStep::Call(charge) = &orch.chain[0] else {
+            panic!("expected a call step");
+        };
+        assert_eq!(charge.rate_limit.as_ref().unwrap().burst, 1);
+
+        let errors = orch.validate(&HashMap::new());
+        assert!(errors.contains(
+            &"Step 'charge' circuit_breaker needs at least 1 failure to open".to_string()
+        ));
+        assert!(errors.contains(
+            &"Step 'notify' rate_limit needs a positive per_second and burst".to_string()
+        ));
+        assert!(errors.contains(
+            &"Step 'audit' is nested; only top-level call steps support circuit_breaker"
+                .to_string()
+        ));
+        assert!(!errors
+            .iter()
+            .any(|e| e.starts_with("Step 'charge' is nested")));
+    }
+
+    #[test]
+    fn test_bulkhead() {
+        let yaml = r#"
+id: checkout
+bulkhead:
+  max_concurrent: 0
+  wait_ms: 200
+"#;
+        let orch = Orchestrator::from_yaml(yaml).unwrap();
+        assert_eq!(
+            orch.bulkhead,
+            Some(Bulkhead {
+                max_concurrent: 0,
+                max_queued: None,
+                wait_ms: Some(200)
+            })
+        );
+        assert!(orch
+            .validate(&HashMap::new())
+            .contains(&"Bulkhead max_concurrent must be at least 1".to_string()));
+    }
+
+    #[test]
+    fn test_degrading_steps() {
+        let yaml = r#"
+id: checkout
+chain:
+  - step: call
+    id: loyalty
+    spec: loyalty_points
+    optional: true
+    on_error: default
+    default:
+      points: 0
+      tier: none
+  - step: call
+    id: quote
+    spec: price_order
+    on_error: default
+  - step: call
+    id: notify
+    spec: send_receipt
+    default:
+      sent: false
+"#;
+        let orch = Orchestrator::from_yaml(yaml).unwrap();
+        let ChainStep::Call(loyalty) = &orch.chain[0] else {
+            panic!("expected a call step");
+        };
+        assert_eq!(loyalty.on_error, Some(OnError::Default));
+        assert_eq!(loyalty.default.get("points"), Some(&ConditionValue::Int(0)));
+
+        let mut specs = HashMap::new();
+        specs.insert(
+            "loyalty_points".to_string(),
+            Spec::from_yaml(
+                "id: loyalty_points\noutputs:\n  - name: points\n    type: int\nrules: []\n",
+            )
+            .unwrap(),
+        );
+        let errors = orch.validate(&specs);
+        assert!(errors.contains(
+            &"Step 'loyalty' default sets unknown output 'tier' of spec 'loyalty_points'"
+                .to_string()
+        ));
+        assert!(errors
+            .contains(&"Step 'quote' can only fall back to defaults when optional".to_string()));
+        assert!(errors
+            .contains(&"Step 'notify' declares defaults but not on_error: default".to_string()));
+    }
 }
@@ -0,0 +1,226 @@
+//! Overlay specs
+//!
+//! An overlay adapts a base spec, such as a country's pricing on top of
+//! the global rules, without copying it:
+//!
+//! ```yaml
+//! id: shipping_rate_de
+//! extends: shipping_rate.yaml
+//! rules:
+//!   - id: SURCHARGE          # replaces the base rule SURCHARGE
+//!     when: "weight_kg > 20.0"
+//!     then: 12.0
+//!   - id: DE_ISLANDS         # new: evaluated just before SURCHARGE
+//!     when: "postcode.startsWith('18')"
+//!     then: 9.0
+//! ```
+//!
+//! The base decides what overlays may touch through its
+//! `extension_points`: rules an overlay may replace, and `default` when it
+//! may replace the default. New rules go in front of the base's first
+//! extension point, so every rule the base keeps to itself still decides
+//! first. Inputs, outputs and everything else come from the base.
+
+use crate::error::{Error, Result};
+use crate::spec::Spec;
+
+/// The point of the base holding its default
+pub const DEFAULT_POINT: &str = "default";
+
+/// The spec an overlay stands for: the base with the overlay's rules and
+/// default applied, under the overlay's id
+///
+/// Errors list every place the overlay reaches outside the base's
+/// extension points.
+pub fn apply(base: &Spec, overlay: &Spec) -> Result<Spec> {
+    let mut errors = Vec::new();
+    for (field, declared) in [
+        ("inputs", !overlay.inputs.is_empty()),
+        ("outputs", !overlay.outputs.is_empty()),
+        ("tables", !overlay.tables.is_empty()),
+    ] {
+        if declared {
+            errors.push(format!(
+                "declares {}, which it inherits from '{}'",
+                field, base.id
+            ));
+        }
+    }
+    if overlay.default.is_some() && !base.extension_points.iter().any(|p| p == DEFAULT_POINT) {
+        errors.push(format!(
+            "replaces the default, which '{}' does not declare as an extension point",
+            base.id
+        ));
+    }
+
+    let mut merged = base.clone();
+    let first_point = base
+        .rules
+        .iter()
+        .position(|r| base.extension_points.contains(&r.id));
+    let mut added = Vec::new();
+    for rule in &overlay.rules {
+        match merged.rules.iter_mut().find(|r| r.id == rule.id) {
+            Some(existing) if base.extension_points.contains(&rule.id) => {
+                *existing = rule.clone();
+            }
+            Some(_) => errors.push(format!(
+                "rule {} replaces a rule of '{}' that is not an extension point",
+                rule.id, base.id
+            )),
+            None if first_point.is_none() => errors.push(format!(
+                "rule {} is added to '{}', which declares no rule extension points",
+                rule.id, base.id
+            )),
+            None => added.push(rule.clone()),
+        }
+    }
+    if !errors.is_empty() {
+        return Err(Error::SpecParse(format!(
+            "Overlay '{}': {}",
+            overlay.id,
+            errors.join("; ")
+        )));
+    }
+    if let Some(at) = first_point {
+        merged.rules.splice(at..at, added);
+    }
+
+    merged.id = overlay.id.clone();
+    if overlay.name.is_some() {
+        merged.name = overlay.name.clone();
+    }
+    if overlay.description.is_some() {
+        merged.description = overlay.description.clone();
+    }
+    if !overlay.meta.is_empty() {
+        merged.meta = overlay.meta.clone();
+    }
+    if overlay.default.is_some() {
+        merged.default = overlay.default.clone();
+    }
+    merged.extends = None;
+    Ok(merged)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const BASE: &str = r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+  - name: express
+    type: bool
+outputs:
+  - name: rate
+    type: float
+extension_points: [SURCHARGE, default]
+rules:
+  - id: EXPRESS
+    when: "express"
+    then: 40.0
+  - id: SURCHARGE
+    when: "weight_kg > 30.0"
+    then: 25.0
+  - id: HEAVY
+    when: "weight_kg > 10.0"
+    then: 15.0
+default: 5.0
+"#;
+
+    #[test]
+    fn test_apply_overlay() {
+        let base = Spec::from_yaml(BASE).unwrap();
+        let overlay = Spec::from_yaml(
+            r#"
+id: shipping_rate_de
+extends: shipping_rate.yaml
+rules:
+  - id: SURCHARGE
+    when: "weight_kg > 20.0"
+    then: 12.0
+  - id: DE_BULKY
+    when: "weight_kg > 50.0"
+    then: 60.0
+default: 4.5
+"#,
+        )
+        .unwrap();
+
+        let merged = apply(&base, &overlay).unwrap();
+        assert_eq!(merged.id, "shipping_rate_de");
+        assert_eq!(merged.extends, None);
+        assert_eq!(merged.inputs.len(), 2);
+        let ids: Vec<_> = merged.rules.iter().map(|r| r.id.as_str()).collect();
+        assert_eq!(ids, vec!["EXPRESS", "DE_BULKY", "SURCHARGE", "HEAVY"]);
+        assert_eq!(
+            merged.rules[2].as_cel().as_deref(),
+            Some("weight_kg > 20.0")
+        );
+        assert!(merged.validate().iter().all(|e| e.starts_with("Warning")));
+        assert!(merged.to_yaml().unwrap().contains("default: 4.5"));
+    }
+
+    #[test]
+    fn test_overlay_outside_extension_points() {
+        let base = Spec::from_yaml(&BASE.replace("[SURCHARGE, default]", "[]")).unwrap();
+        let overlay = Spec::from_yaml(
+            r#"
+id: shipping_rate_de
+extends: shipping_rate.yaml
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: EXPRESS
+    when: "express"
+    then: 30.0
+  - id: DE_BULKY
+    when: "weight_kg > 50.0"
+    then: 60.0
+default: 4.5
+"#,
+        )
+        .unwrap();
+
+        let typo = Spec::from_yaml(&BASE.replace("[SURCHARGE, default]", "[SURCHARGES]")).unwrap();
+        assert!(typo.validate().contains(
+            &"Extension point 'SURCHARGES' is neither a rule nor the default".to_string()
+        ));
+
+        let err = apply(&base, &overlay).unwrap_err().to_string();
+        assert_eq!(
+            err,
+            "Spec parse error: Overlay 'shipping_rate_de': \
+             declares outputs, which it inherits from 'shipping_rate'; \
+             replaces the default, which 'shipping_rate' does not declare as an extension point; \
+             rule EXPRESS replaces a rule of 'shipping_rate' that is not an extension point; \
+             rule DE_BULKY is added to 'shipping_rate', which declares no rule extension points"
+        );
+    }
+
+    #[test]
+    fn test_overlay_from_file() {
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(dir.path().join("shipping_rate.yaml"), BASE).unwrap();
+        let path = dir.path().join("shipping_rate_de.yaml");
+        std::fs::write(
+            &path,
+            "id: shipping_rate_de\nextends: shipping_rate.yaml\nrules: []\n",
+        )
+        .unwrap();
+        let merged = Spec::from_file(&path).unwrap();
+        assert_eq!(merged.rules.len(), 3);
+
+        std::fs::write(
+            dir.path().join("shipping_rate.yaml"),
+            format!("extends: shipping_rate_de.yaml\n{}", BASE),
+        )
+        .unwrap();
+        let err = Spec::from_file(&path).unwrap_err().to_string();
+        assert!(err.contains("overlays extend each other in a cycle"));
+    }
+}
@@ -2265,6 +2265,7 @@ fn parse_go_function(node: Node, source: &str) -> Option<Function> {
     let mut params = Vec::new();
     let mut return_type = None;
     let mut body = None;
+    let result = node.child_by_field_name("result").map(|r| r.id());
 
     let mut cursor = node.walk();
     for child in node.children(&mut cursor) {
@@ -2272,10 +2273,11 @@ fn parse_go_function(node: Node, source: &str) -> Option<Function> {
             "identifier" if name.is_empty() => {
                 name = child.utf8_text(source.as_bytes()).unwrap_or("").to_string();
             }
-            "parameter_list" => {
+            // A parenthesized result list is a parameter_list too
+            "parameter_list" if Some(child.id()) != result => {
                 params = parse_go_parameters(child, source);
             }
-            "type_identifier" | "qualified_type" => {
+            "type_identifier" | "qualified_type" | "parameter_list" => {
                 return_type = Some(child.utf8_text(source.as_bytes()).unwrap_or("").to_string());
             }
             "block" => {
@@ -2308,17 +2310,20 @@ fn parse_go_parameters(node: Node, source: &str) -> Vec<Parameter> {
 
     for child in node.children(&mut cursor) {
         if child.kind() == "parameter_declaration" {
-            let mut name = String::new();
+            // `a, b int` declares both names with one type
+            let mut names = Vec::new();
             let mut typ = String::new();
 
             let mut param_cursor = child.walk();
             for param_child in child.children(&mut param_cursor) {
                 match param_child.kind() {
                     "identifier" => {
-                        name = param_child
-                            .utf8_text(source.as_bytes())
-                            .unwrap_or("")
-                            .to_string();
+                        names.push(
+                            param_child
+                                .utf8_text(source.as_bytes())
+                                .unwrap_or("")
+                                .to_string(),
+                        );
                     }
                     "type_identifier" | "qualified_type" | "pointer_type" | "slice_type" => {
                         typ = param_child
@@ -2330,8 +2335,11 @@ fn parse_go_parameters(node: Node, source: &str) -> Vec<Parameter> {
                 }
             }
 
-            if !name.is_empty() {
-                params.push(Parameter { name, typ });
+            for name in names {
+                params.push(Parameter {
+                    name,
+                    typ: typ.clone(),
+                });
             }
         }
     }
@@ -2340,20 +2348,35 @@ fn parse_go_parameters(node: Node, source: &str) -> Vec<Parameter> {
 }
 
 fn parse_go_block(node: Node, source: &str) -> AstNode {
-    let mut statements = Vec::new();
-    let mut result = None;
+    go_statements(&go_statement_nodes(node), node, source)
+}
+
+/// Statement nodes of a block or switch case, looking through the
+/// `statement_list` some grammar versions wrap them in
+fn go_statement_nodes(node: Node) -> Vec<Node> {
+    let value = node.child_by_field_name("value").map(|v| v.id());
     let mut cursor = node.walk();
+    node.named_children(&mut cursor)
+        .flat_map(|child| {
+            if child.kind() == "statement_list" {
+                let mut list_cursor = child.walk();
+                child.named_children(&mut list_cursor).collect()
+            } else {
+                vec![child]
+            }
+        })
+        .filter(|child| child.kind() != "comment" && Some(child.id()) != value)
+        .collect()
+}
 
-    let children: Vec<_> = node.children(&mut cursor).collect();
-    let last_stmt_idx = children
-        .iter()
-        .rposition(|c| c.kind() != "{" && c.kind() != "}");
+fn go_statements(children: &[Node], node: Node, source: &str) -> AstNode {
+    let mut statements = Vec::new();
+    let mut result = None;
 
     for (i, child) in children.iter().enumerate() {
-        let is_last = Some(i) == last_stmt_idx;
+        let is_last = i + 1 == children.len();
 
         match child.kind() {
-            "{" | "}" => continue,
             "return_statement" => {
                 let mut ret_cursor = child.walk();
                 for ret_child in child.children(&mut ret_cursor) {
@@ -2371,8 +2394,12 @@ fn parse_go_block(node: Node, source: &str) -> AstNode {
                     }
                 }
             }
-            "if_statement" => {
-                let expr = parse_go_if(*child, source);
+            "if_statement" | "expression_switch_statement" => {
+                let expr = if child.kind() == "if_statement" {
+                    parse_go_if(*child, source)
+                } else {
+                    parse_go_switch(*child, source)
+                };
                 if is_last {
                     result = Some(Box::new(expr));
                 } else {
@@ -2384,7 +2411,10 @@ fn parse_go_block(node: Node, source: &str) -> AstNode {
                     statements.push(parse_go_expr(expr_child, source));
                 }
             }
-            _ => {}
+            kind => statements.push(AstNode::Unknown {
+                kind: kind.to_string(),
+                span: node_span(*child),
+            }),
         }
     }
 
@@ -2395,6 +2425,82 @@ fn parse_go_block(node: Node, source: &str) -> AstNode {
     }
 }
 
+/// A switch as a match: tagged cases with literal values become literal
+/// patterns, everything else a wildcard with the case as guard
+fn parse_go_switch(node: Node, source: &str) -> AstNode {
+    let scrutinee = node
+        .child_by_field_name("value")
+        .map(|value| parse_go_expr(value, source));
+    let mut arms = Vec::new();
+
+    let mut cursor = node.walk();
+    for case in node.children(&mut cursor) {
+        if !matches!(case.kind(), "expression_case" | "default_case") {
+            continue;
+        }
+        let body = go_statements(&go_statement_nodes(case), case, source);
+        let (pattern, guard) = match case.kind() {
+            "default_case" => (Pattern::Wildcard, None),
+            _ => {
+                let mut values = Vec::new();
+                if let Some(list) = case.child_by_field_name("value") {
+                    let mut list_cursor = list.walk();
+                    for value in list.named_children(&mut list_cursor) {
+                        values.push(parse_go_expr(value, source));
+                    }
+                }
+                let literals: Option<Vec<Pattern>> = values
+                    .iter()
+                    .map(|v| match v {
+                        AstNode::Literal { value, .. } if scrutinee.is_some() => {
+                            Some(Pattern::Literal(value.clone()))
+                        }
+                        _ => None,
+                    })
+                    .collect();
+                match literals {
+                    Some(mut patterns) if patterns.len() == 1 => (patterns.remove(0), None),
+                    Some(patterns) => (Pattern::Or(patterns), None),
+                    None => {
+                        let span = node_span(case);
+                        let tests = values.into_iter().map(|value| match &scrutinee {
+                            Some(tag) => AstNode::Binary {
+                                op: BinaryOp::Eq,
+                                left: Box::new(tag.clone()),
+                                right: Box::new(value),
+                                span,
+                            },
+                            None => value,
+                        });
+                        let guard = tests.reduce(|left, right| AstNode::Binary {
+                            op: BinaryOp::Or,
+                            left: Box::new(left),
+                            right: Box::new(right),
+                            span,
+                        });
+                        (Pattern::Wildcard, guard)
+                    }
+                }
+            }
+        };
+        arms.push(MatchArm {
+            pattern,
+            guard,
+            body,
+            span: node_span(case),
+        });
+    }
+
+    AstNode::Match {
+        scrutinee: Box::new(scrutinee.unwrap_or(AstNode::Literal {
+            value: LiteralValue::Bool(true),
+            span: node_span(node),
+        })),
+        arms,
+        span: node_span(node),
+    }
+}
+
 fn parse_go_if(node: Node, source: &str) -> AstNode {
     let mut condition = None;
     let mut then_branch = None;
@@ -2513,6 +2619,71 @@ fn parse_go_expr(node: Node, source: &str) -> AstNode {
                 }
             }
         }
+        "unary_expression" => {
+            let operator = node
+                .child_by_field_name("operator")
+                .and_then(|n| n.utf8_text(source.as_bytes()).ok());
+            let op = match operator {
+                Some("!") => UnaryOp::Not,
+                Some("-") => UnaryOp::Neg,
+                Some("^") => UnaryOp::BitNot,
+                other => {
+                    return AstNode::Unknown {
+                        kind: format!("unknown_op:{}", other.unwrap_or("")),
+                        span: node_span(node),
+                    }
+                }
+            };
+            match node.child_by_field_name("operand") {
+                Some(operand) => AstNode::Unary {
+                    op,
+                    operand: Box::new(parse_go_expr(operand, source)),
+                    span: node_span(node),
+                },
+                None => AstNode::Unknown {
+                    kind: "unary_incomplete".into(),
+                    span: node_span(node),
+                },
+            }
+        }
+        "call_expression" => {
+            let function = node
+                .child_by_field_name("function")
+                .and_then(|n| n.utf8_text(source.as_bytes()).ok())
+                .unwrap_or("")
+                .to_string();
+            let mut args = Vec::new();
+            if let Some(list) = node.child_by_field_name("arguments") {
+                let mut cursor = list.walk();
+                for arg in list.named_children(&mut cursor) {
+                    args.push(parse_go_expr(arg, source));
+                }
+            }
+            AstNode::Call {
+                function,
+                args,
+                span: node_span(node),
+            }
+        }
+        "nil" => AstNode::Literal {
+            value: LiteralValue::Unit,
+            span: node_span(node),
+        },
+        "expression_list" => {
+            let mut cursor = node.walk();
+            let mut elements: Vec<AstNode> = node
+                .named_children(&mut cursor)
+                .map(|child| parse_go_expr(child, source))
+                .collect();
+            if elements.len() == 1 {
+                elements.remove(0)
+            } else {
+                AstNode::Tuple {
+                    elements,
+                    span: node_span(node),
+                }
+            }
+        }
         "parenthesized_expression" => {
             let mut cursor = node.walk();
             for child in node.children(&mut cursor) {
@@ -3590,4 +3761,58 @@ fn check(x: i32) -> bool {
             assert!(matches!(result.as_deref(), Some(AstNode::If { .. })));
         }
     }
+
+    #[test]
+    fn test_parse_go_switch() {
+        let code = r#"
+package rates
+
+func Rate(zone string, weight, limit int) int {
+	switch zone {
+	case "domestic", "local":
+		return 0
+	case "eu":
+		if weight > limit {
+			return 900
+		}
+		return 500
+	default:
+		return -1
+	}
+}
+"#;
+        let ast = parse_go(code).unwrap();
+        let func = &ast.functions[0];
+        let names: Vec<&str> = func.params.iter().map(|p| p.name.as_str()).collect();
+        assert_eq!(names, vec!["zone", "weight", "limit"]);
+        assert_eq!(func.params[1].typ, "int");
+
+        let AstNode::Block { result, .. } = &func.body else {
+            panic!("expected a block");
+        };
+        let Some(AstNode::Match { arms, .. }) = result.as_deref() else {
+            panic!("expected the switch as result, got {:?}", result);
+        };
+        assert_eq!(arms.len(), 3);
+        assert!(matches!(&arms[0].pattern, Pattern::Or(values) if values.len() == 2));
+        assert!(matches!(arms[2].pattern, Pattern::Wildcard));
+        let AstNode::Block {
+            statements, result, ..
+        } = &arms[1].body
+        else {
+            panic!("expected a case body");
+        };
+        assert!(matches!(statements[0], AstNode::If { .. }));
+        assert!(matches!(result.as_deref(), Some(AstNode::Literal { .. })));
+        let AstNode::Block { result, .. } = &arms[2].body else {
+            panic!("expected a case body");
+        };
+        assert!(matches!(
+            result.as_deref(),
+            Some(AstNode::Unary {
+                op: UnaryOp::Neg,
+                ..
+            })
+        ));
+    }
 }
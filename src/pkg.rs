@@ -0,0 +1,328 @@
+//! Spec packages
+//!
+//! Shared spec libraries (a "zones" type library, a standard tax-rule pack)
+//! are published as versioned bundles and vendored into the projects that
+//! depend on them.
+//!
+//! A package registry follows the [`crate::registry`] layout, with one bundle
+//! per version:
+//!
+//! ```text
+//! {base}/{name}/{version}.bundle.yaml          the bundle
+//! {base}/{name}/{version}.bundle.yaml.sha256   hex SHA-256 of the bundle
+//! ```
+//!
+//! A package can also come from a tag of a git repository, whose specs are
+//! the YAML files under its `imacs/` folder (or the repository root when it
+//! has none).
+//!
+//! Installed packages are written to `packages/{name}/` in the project's
+//! imacs folder and pinned (source, version, digest) in `.imacs_root`.
+
+use crate::error::{Error, Result};
+use crate::project::list_specs;
+use crate::registry::{sha256_hex, Transport};
+use crate::spec::Spec;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::path::{Path, PathBuf};
+use std::process::Command;
+
+/// A versioned set of spec files
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Bundle {
+    /// Package name
+    pub name: String,
+
+    /// Package version
+    pub version: String,
+
+    /// Spec file contents by path relative to the package root
+    pub specs: BTreeMap<String, String>,
+}
+
+impl Bundle {
+    /// Bundle every spec under `dir`
+    ///
+    /// Fails if a file is not a valid spec, so a broken package is never
+    /// published.
+    pub fn from_dir(name: &str, version: &str, dir: &Path) -> Result<Self> {
+        check_name(name, version)?;
+        let mut specs = BTreeMap::new();
+        for path in list_specs(dir)? {
+            let content = std::fs::read_to_string(&path).map_err(Error::Io)?;
+            let relative = path
+                .strip_prefix(dir)
+                .unwrap_or(&path)
+                .to_string_lossy()
+                .replace('\\', "/");
+            Spec::from_yaml(&content).map_err(|e| Error::Other(format!("{}: {}", relative, e)))?;
+            specs.insert(relative, content);
+        }
+        if specs.is_empty() {
+            return Err(Error::Other(format!("No specs found in {}", dir.display())));
+        }
+        Ok(Self {
+            name: name.to_string(),
+            version: version.to_string(),
+            specs,
+        })
+    }
+
+    pub fn from_yaml(yaml: &str) -> Result<Self> {
+        serde_norway::from_str(yaml)
+            .map_err(|e| Error::Other(format!("Failed to parse bundle: {}", e)))
+    }
+
+    pub fn to_yaml(&self) -> Result<String> {
+        serde_norway::to_string(self)
+            .map_err(|e| Error::Other(format!("Failed to serialize bundle: {}", e)))
+    }
+
+    /// Hex SHA-256 of the serialized bundle
+    pub fn digest(&self) -> Result<String> {
+        Ok(sha256_hex(self.to_yaml()?.as_bytes()))
+    }
+}
+
+/// Path of a bundle relative to the registry base
+pub fn bundle_path(name: &str, version: &str) -> String {
+    format!("{}/{}.bundle.yaml", name, version)
+}
+
+/// Publish a bundle to a registry directory (served over HTTP or synced to
+/// a bucket), returning the bundle's digest
+///
+/// Published versions are immutable: an existing version is never replaced.
+pub fn publish(bundle: &Bundle, registry_dir: &Path) -> Result<String> {
+    let path = registry_dir.join(bundle_path(&bundle.name, &bundle.version));
+    if path.exists() {
+        return Err(Error::Registry(format!(
+            "{}@{} is already published",
+            bundle.name, bundle.version
+        )));
+    }
+    let yaml = bundle.to_yaml()?;
+    let digest = sha256_hex(yaml.as_bytes());
+    std::fs::create_dir_all(path.parent().unwrap()).map_err(Error::Io)?;
+    std::fs::write(&path, &yaml).map_err(Error::Io)?;
+    let mut sidecar = path.into_os_string();
+    sidecar.push(".sha256");
+    std::fs::write(sidecar, format!("{}\n", digest)).map_err(Error::Io)?;
+    Ok(digest)
+}
+
+/// Fetch a bundle from a registry, verified against the published digest or
+/// against `pinned` when given
+pub fn fetch(
+    transport: &dyn Transport,
+    base_url: &str,
+    name: &str,
+    version: &str,
+    pinned: Option<&str>,
+) -> Result<Bundle> {
+    check_name(name, version)?;
+    let url = format!(
+        "{}/{}",
+        base_url.trim_end_matches('/'),
+        bundle_path(name, version)
+    );
+    let expected = match pinned {
+        Some(digest) => digest.to_string(),
+        None => String::from_utf8_lossy(&transport.get(&format!("{}.sha256", url))?)
+            .split_whitespace()
+            .next()
+            .unwrap_or_default()
+            .to_string(),
+    };
+
+    let body = transport.get(&url)?;
+    let actual = sha256_hex(&body);
+    if actual != expected.trim_start_matches("sha256:").to_ascii_lowercase() {
+        return Err(Error::Registry(format!(
+            "{}@{}: digest mismatch (expected {}, got {})",
+            name, version, expected, actual
+        )));
+    }
+    let yaml = String::from_utf8(body)
+        .map_err(|e| Error::Registry(format!("{}@{}: {}", name, version, e)))?;
+    let bundle = Bundle::from_yaml(&yaml)?;
+    if bundle.name != name || bundle.version != version {
+        return Err(Error::Registry(format!(
+            "{}@{}: registry returned {}@{}",
+            name, version, bundle.name, bundle.version
+        )));
+    }
+    Ok(bundle)
+}
+
+/// Fetch a bundle from a tag of a git repository
+pub fn fetch_git(url: &str, name: &str, tag: &str) -> Result<Bundle> {
+    check_name(name, tag)?;
+    // Neither may be read by git as an option
+    for part in [url, tag] {
+        if part.starts_with('-') {
+            return Err(Error::Registry(format!(
+                "Invalid git url or tag: '{}'",
+                part
+            )));
+        }
+    }
+    // Removed when dropped, whichever way this returns
+    let tmp = tempfile::tempdir().map_err(Error::Io)?;
+    let checkout = tmp.path().join(name);
+
+    let status = Command::new("git")
+        .args([
+            "clone", "--quiet", "--depth", "1", "--branch", tag, "--", url,
+        ])
+        .arg(&checkout)
+        .status()
+        .map_err(|e| Error::Other(format!("Failed to run git: {}", e)))?;
+    if !status.success() {
+        return Err(Error::Registry(format!("git clone {}@{} failed", url, tag)));
+    }
+
+    let _ = std::fs::remove_dir_all(checkout.join(".git"));
+    let specs_dir = checkout.join("imacs");
+    let dir = if specs_dir.is_dir() {
+        specs_dir
+    } else {
+        checkout
+    };
+    Bundle::from_dir(name, tag, &dir)
+}
+
+/// Write a bundle's specs to `packages/{name}/` in an imacs folder,
+/// replacing any previously installed version
+pub fn install(bundle: &Bundle, imacs_dir: &Path) -> Result<PathBuf> {
+    let target = imacs_dir.join("packages").join(&bundle.name);
+    for relative in bundle.specs.keys() {
+        if relative.starts_with('/') || relative.split('/').any(|part| part == "..") {
+            return Err(Error::Registry(format!(
+                "{}@{}: invalid spec path '{}'",
+                bundle.name, bundle.version, relative
+            )));
+        }
+    }
+
+    if target.exists() {
+        std::fs::remove_dir_all(&target).map_err(Error::Io)?;
+    }
+    for (relative, content) in &bundle.specs {
+        let path = target.join(relative);
+        std::fs::create_dir_all(path.parent().unwrap()).map_err(Error::Io)?;
+        std::fs::write(&path, content).map_err(Error::Io)?;
+    }
+    Ok(target)
+}
+
+fn check_name(name: &str, version: &str) -> Result<()> {
+    for part in [name, version] {
+        if part.is_empty() || part.contains('/') || part.contains("..") {
+            return Err(Error::Registry(format!(
+                "Invalid package name or version: '{}'",
+                part
+            )));
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: zones
+inputs:
+  - name: country
+    type: string
+outputs:
+  - name: zone
+    type: string
+rules:
+  - id: R1
+    when: "country == 'US'"
+    then: "domestic"
+default: "intl"
+"#;
+
+    struct DirTransport(PathBuf);
+
+    impl Transport for DirTransport {
+        fn get(&self, url: &str) -> Result<Vec<u8>> {
+            let relative = url.trim_start_matches("https://rules.example.com/");
+            std::fs::read(self.0.join(relative))
+                .map_err(|e| Error::Registry(format!("GET {}: {}", url, e)))
+        }
+    }
+
+    fn scratch(name: &str) -> PathBuf {
+        let dir =
+            std::env::temp_dir().join(format!("imacs-pkg-test-{}-{}", name, std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(&dir).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_publish_fetch_install() {
+        let source = scratch("source");
+        std::fs::create_dir_all(source.join("geo")).unwrap();
+        std::fs::write(source.join("geo/zones.yaml"), SPEC).unwrap();
+        let registry = scratch("registry");
+
+        let bundle = Bundle::from_dir("zones", "1.0.0", &source).unwrap();
+        assert_eq!(
+            bundle.specs.keys().collect::<Vec<_>>(),
+            vec!["geo/zones.yaml"]
+        );
+        let digest = publish(&bundle, &registry).unwrap();
+        assert_eq!(digest, bundle.digest().unwrap());
+        // Versions are immutable
+        assert!(publish(&bundle, &registry).is_err());
+
+        let transport = DirTransport(registry.clone());
+        let base = "https://rules.example.com";
+        let fetched = fetch(&transport, base, "zones", "1.0.0", None).unwrap();
+        assert_eq!(fetched, bundle);
+        assert!(fetch(&transport, base, "zones", "1.0.0", Some(&digest)).is_ok());
+        assert!(fetch(&transport, base, "zones", "1.0.0", Some("0000")).is_err());
+        assert!(fetch(&transport, base, "../zones", "1.0.0", None).is_err());
+
+        let imacs_dir = scratch("project");
+        let installed = install(&fetched, &imacs_dir).unwrap();
+        assert_eq!(
+            std::fs::read_to_string(installed.join("geo/zones.yaml")).unwrap(),
+            SPEC
+        );
+
+        for dir in [source, registry, imacs_dir] {
+            std::fs::remove_dir_all(dir).unwrap();
+        }
+    }
+
+    #[test]
+    fn test_install_rejects_escaping_paths() {
+        let bundle = Bundle {
+            name: "zones".into(),
+            version: "1.0.0".into(),
+            specs: BTreeMap::from([("../../evil.yaml".to_string(), SPEC.to_string())]),
+        };
+        let dir = scratch("escape");
+        assert!(install(&bundle, &dir).is_err());
+        std::fs::remove_dir_all(dir).unwrap();
+    }
+    #[test]
+    fn test_fetch_git_rejects_options() {
+        assert!(
+            matches!(fetch_git("--upload-pack=touch /tmp/x", "zones", "v1"),
+            Err(Error::Registry(m)) if m.starts_with("Invalid git url or tag"))
+        );
+        assert!(
+            matches!(fetch_git("https://example.com/zones.git", "zones", "-v1"),
+            Err(Error::Registry(m)) if m.starts_with("Invalid git url or tag"))
+        );
+    }
+}
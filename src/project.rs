@@ -372,6 +372,23 @@ pub fn get_output_dir(
     base.join("generated")
 }
 
+/// Get the directory spec docs are written to (with `layout.docs`)
+///
+/// Falls back like [`get_output_dir`]: `output.docs`, then
+/// `output.default`, then `./generated`.
+pub fn get_docs_dir(imacs_dir: &Path, config: &crate::config::MergedConfig) -> PathBuf {
+    let base = imacs_dir.parent().unwrap_or(imacs_dir);
+    match config
+        .output
+        .docs
+        .as_ref()
+        .or(config.output.default.as_ref())
+    {
+        Some(path) => base.join(path),
+        None => base.join("generated"),
+    }
+}
+
 /// Legacy function for backward compatibility
 /// Use get_output_dir() instead
 pub fn get_generated_dir(imacs_dir: &Path) -> PathBuf {
@@ -483,7 +500,9 @@ mod tests {
     #[test]
     fn test_get_output_dir_default() {
         use crate::cel::Target;
-        use crate::config::{MergedConfig, NamingConfig, OutputConfig, ValidationConfig};
+        use crate::config::{
+            LayoutConfig, MergedConfig, NamingConfig, OutputConfig, ValidationConfig,
+        };
 
         let temp = TempDir::new().unwrap();
         let imacs_dir = temp.path().join("imacs");
@@ -496,6 +515,8 @@ mod tests {
             validation: ValidationConfig::default(),
             spec_id_prefix: "".to_string(),
             output: OutputConfig::default(),
+            layout: LayoutConfig::default(),
+            functions: Vec::new(),
         };
 
         let output_dir = get_output_dir(&imacs_dir, &config, Target::Rust);
@@ -505,7 +526,9 @@ mod tests {
     #[test]
     fn test_get_output_dir_per_language() {
         use crate::cel::Target;
-        use crate::config::{MergedConfig, NamingConfig, OutputConfig, ValidationConfig};
+        use crate::config::{
+            LayoutConfig, MergedConfig, NamingConfig, OutputConfig, ValidationConfig,
+        };
 
         let temp = TempDir::new().unwrap();
         let imacs_dir = temp.path().join("imacs");
@@ -525,6 +548,8 @@ mod tests {
             validation: ValidationConfig::default(),
             spec_id_prefix: "".to_string(),
             output,
+            layout: LayoutConfig::default(),
+            functions: Vec::new(),
         };
 
         let rust_dir = get_output_dir(&imacs_dir, &config, Target::Rust);
@@ -539,7 +564,9 @@ mod tests {
     #[test]
     fn test_get_output_dir_default_override() {
         use crate::cel::Target;
-        use crate::config::{MergedConfig, NamingConfig, OutputConfig, ValidationConfig};
+        use crate::config::{
+            LayoutConfig, MergedConfig, NamingConfig, OutputConfig, ValidationConfig,
+        };
 
         let temp = TempDir::new().unwrap();
         let imacs_dir = temp.path().join("imacs");
@@ -558,6 +585,8 @@ mod tests {
             validation: ValidationConfig::default(),
             spec_id_prefix: "".to_string(),
             output,
+            layout: LayoutConfig::default(),
+            functions: Vec::new(),
         };
 
         let output_dir = get_output_dir(&imacs_dir, &config, Target::Rust);
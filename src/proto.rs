@@ -0,0 +1,243 @@
+//! Protobuf message generation
+//!
+//! Emits proto3 `{Spec}Input` and `{Spec}Output` messages matching a spec's
+//! inputs and outputs. Field numbers are kept in a mapping file next to the
+//! spec (`checkout.proto-fields.json`), so regenerating after fields are
+//! added, removed or reordered never renumbers existing ones. Numbers and
+//! names of removed fields are reserved.
+
+use crate::error::{Error, Result};
+use crate::spec::{Spec, VarType, Variable};
+use crate::util::to_pascal_case;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+/// Field numbers by message and field name
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct FieldNumbers {
+    #[serde(flatten)]
+    messages: BTreeMap<String, BTreeMap<String, u32>>,
+}
+
+impl FieldNumbers {
+    /// Load a mapping file, or start empty if it does not exist yet
+    pub fn load(path: &Path) -> Result<Self> {
+        if !path.exists() {
+            return Ok(Self::default());
+        }
+        serde_json::from_str(&fs::read_to_string(path)?)
+            .map_err(|e| Error::Other(format!("{}: {}", path.display(), e)))
+    }
+
+    pub fn save(&self, path: &Path) -> Result<()> {
+        let json = serde_json::to_string_pretty(self)
+            .map_err(|e| Error::Other(format!("{}: {}", path.display(), e)))?;
+        fs::write(path, format!("{}\n", json))?;
+        Ok(())
+    }
+
+    /// Number of a field, assigning the next free one on first sight
+    pub fn number(&mut self, message: &str, field: &str) -> u32 {
+        let fields = self.messages.entry(message.to_string()).or_default();
+        let next = fields.values().max().map_or(1, |n| n + 1);
+        *fields.entry(field.to_string()).or_insert(next)
+    }
+
+    /// Known fields of a message that are not in `current`
+    fn removed<'a>(&'a self, message: &str, current: &[&str]) -> Vec<(&'a str, u32)> {
+        self.messages
+            .get(message)
+            .into_iter()
+            .flatten()
+            .filter(|(name, _)| !current.contains(&name.as_str()))
+            .map(|(name, number)| (name.as_str(), *number))
+            .collect()
+    }
+}
+
+/// Mapping file kept next to a spec
+pub fn fields_path(spec_path: &Path) -> PathBuf {
+    spec_path.with_extension("proto-fields.json")
+}
+
+/// Render the proto3 messages for a spec, assigning numbers to new fields
+pub fn render_proto(spec: &Spec, numbers: &mut FieldNumbers) -> String {
+    let name = to_pascal_case(&spec.id);
+    let result = Variable {
+        name: "result".into(),
+        typ: VarType::String,
+        description: None,
+        values: None,
+        optional: false,
+        default: None,
+        unit: None,
+        currency: None,
+        aliases: Vec::new(),
+        pii: false,
+        redact: None,
+    };
+    let outputs: Vec<&Variable> = if spec.outputs.is_empty() {
+        vec![&result]
+    } else {
+        spec.outputs.iter().collect()
+    };
+    let inputs: Vec<&Variable> = spec.inputs.iter().collect();
+
+    let mut messages = Vec::new();
+    for (message, fields) in [
+        (format!("{}Input", name), inputs),
+        (format!("{}Output", name), outputs),
+    ] {
+        messages.push(render_message(&message, &fields, numbers));
+    }
+
+    let well_known = spec
+        .inputs
+        .iter()
+        .chain(&spec.outputs)
+        .any(|v| needs_struct_proto(&v.typ));
+
+    let mut out = format!(
+        "// GENERATED FROM: {}.yaml\n// DO NOT EDIT - regenerate from spec\n\nsyntax = \"proto3\";\n\npackage {};\n\n",
+        spec.id, spec.id
+    );
+    if well_known {
+        out.push_str("import \"google/protobuf/struct.proto\";\n\n");
+    }
+    out.push_str(&messages.join("\n"));
+    out
+}
+
+fn render_message(message: &str, fields: &[&Variable], numbers: &mut FieldNumbers) -> String {
+    let mut out = format!("message {} {{\n", message);
+    for field in fields {
+        if let Some(description) = &field.description {
+            out.push_str(&format!("  // {}\n", description));
+        }
+        if let VarType::Enum(values) = &field.typ {
+            out.push_str(&format!("  // One of: {}\n", values.join(", ")));
+        }
+        let label = match (&field.typ, field.optional) {
+            (VarType::List(_), _) => "repeated ",
+            (VarType::Object, _) => "",
+            (_, true) => "optional ",
+            (_, false) => "",
+        };
+        out.push_str(&format!(
+            "  {}{} {} = {};\n",
+            label,
+            proto_type(&field.typ),
+            field.name,
+            numbers.number(message, &field.name)
+        ));
+    }
+
+    let current: Vec<&str> = fields.iter().map(|f| f.name.as_str()).collect();
+    let removed = numbers.removed(message, &current);
+    if !removed.is_empty() {
+        let reserved_numbers: Vec<String> = removed.iter().map(|(_, n)| n.to_string()).collect();
+        let reserved_names: Vec<String> = removed
+            .iter()
+            .map(|(name, _)| format!("\"{}\"", name))
+            .collect();
+        out.push_str(&format!("  reserved {};\n", reserved_numbers.join(", ")));
+        out.push_str(&format!("  reserved {};\n", reserved_names.join(", ")));
+    }
+    out.push_str("}\n");
+    out
+}
+
+/// Proto type of a field (element type for lists)
+fn proto_type(typ: &VarType) -> &'static str {
+    match typ {
+        VarType::Bool => "bool",
+        VarType::Int => "int64",
+        VarType::Float => "double",
+        VarType::String | VarType::Enum(_) => "string",
+        VarType::Object => "google.protobuf.Struct",
+        VarType::List(inner) => match inner.as_ref() {
+            VarType::List(_) => "google.protobuf.ListValue",
+            other => proto_type(other),
+        },
+    }
+}
+
+fn needs_struct_proto(typ: &VarType) -> bool {
+    match typ {
+        VarType::Object => true,
+        VarType::List(inner) => matches!(inner.as_ref(), VarType::Object | VarType::List(_)),
+        _ => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: weight
+    type: float
+  - name: express
+    type: bool
+    optional: true
+outputs:
+  - name: rate
+    type: float
+  - name: carrier
+    type: !enum [ups, fedex]
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then:
+      rate: 5.0
+      carrier: ups
+"#;
+
+    #[test]
+    fn test_render_proto() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let mut numbers = FieldNumbers::default();
+        let proto = render_proto(&spec, &mut numbers);
+
+        assert!(proto.contains("package shipping_rate;"));
+        assert!(proto.contains("message ShippingRateInput {\n  string zone = 1;\n  double weight = 2;\n  optional bool express = 3;\n}"));
+        assert!(proto.contains("  // One of: ups, fedex\n  string carrier = 2;"));
+        assert!(!proto.contains("struct.proto"));
+
+        let nested = VarType::List(Box::new(VarType::List(Box::new(VarType::Int))));
+        assert_eq!(proto_type(&nested), "google.protobuf.ListValue");
+        assert!(needs_struct_proto(&nested));
+    }
+
+    #[test]
+    fn test_field_numbers_are_stable() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let mut numbers = FieldNumbers::default();
+        render_proto(&spec, &mut numbers);
+
+        // Drop `weight`, insert `carrier` before `zone`
+        let changed = Spec::from_yaml(
+            &SPEC
+                .replace("  - name: weight\n    type: float\n", "")
+                .replace(
+                    "inputs:\n",
+                    "inputs:\n  - name: carrier\n    type: string\n",
+                ),
+        )
+        .unwrap();
+        let proto = render_proto(&changed, &mut numbers);
+        assert!(proto.contains("  string carrier = 4;\n  string zone = 1;\n  optional bool express = 3;\n  reserved 2;\n  reserved \"weight\";\n"));
+
+        let dir = tempfile::tempdir().unwrap();
+        let path = fields_path(&dir.path().join("shipping_rate.yaml"));
+        assert!(path.ends_with("shipping_rate.proto-fields.json"));
+        numbers.save(&path).unwrap();
+        assert_eq!(FieldNumbers::load(&path).unwrap(), numbers);
+    }
+}
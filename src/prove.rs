@@ -0,0 +1,838 @@
+//! Proving spec assertions with an SMT solver
+//!
+//! [`crate::assertions`] checks assertions on sampled inputs, which can miss
+//! the one input a property fails for. This module translates the spec and
+//! each assertion to SMT-LIB 2 and asks a solver whether any valid input
+//! violates it: `unsat` proves the assertion for every input, `sat` comes
+//! with a counterexample.
+//!
+//! The solver runs as an external process reading the problem on stdin:
+//! `z3 -in` by default, or the command in `IMACS_SMT_SOLVER` (for example
+//! `cvc5 --lang smt2 --produce-models`).
+//!
+//! Only part of CEL has a direct SMT meaning — boolean logic, comparisons,
+//! arithmetic on ints and doubles, `in` over list literals, the ternary
+//! operator and the basic string functions. Assertions over specs that use
+//! anything else (integer division, regexes, list or object inputs, input
+//! normalizations) are reported as unsupported and left to the sampler.
+
+use crate::cel::{CelCompiler, CelExpr};
+use crate::error::{Error, Result};
+use crate::render::is_expression;
+use crate::spec::{Assertion, ConditionValue, Output, Spec, VarType};
+use cel_parser::{ast::operators, ast::Expr, reference::Val};
+use std::collections::HashMap;
+use std::io::Write;
+use std::process::{Command, Stdio};
+
+/// Solver command used when `IMACS_SMT_SOLVER` is not set
+pub const DEFAULT_SOLVER: &str = "z3 -in";
+
+/// Outcome of proving one assertion
+#[derive(Debug, Clone, PartialEq)]
+pub enum Verdict {
+    /// Holds for every valid input
+    Proved,
+    /// Fails for the input (and outputs) described
+    Counterexample(String),
+    /// The solver gave up
+    Unknown,
+    /// The spec or assertion uses something with no SMT translation
+    Unsupported(String),
+}
+
+/// The verdict for one assertion
+#[derive(Debug, Clone, PartialEq)]
+pub struct Proof {
+    /// Assertion ID
+    pub id: String,
+    pub verdict: Verdict,
+}
+
+/// An assertion translated to SMT-LIB
+#[derive(Debug, Clone, PartialEq)]
+pub struct Problem {
+    /// Declarations and assertions; satisfiable exactly when the spec
+    /// assertion can be violated
+    pub script: String,
+    /// Symbols reported for a counterexample
+    pub symbols: Vec<String>,
+}
+
+impl Problem {
+    /// The script asking whether the assertion can be violated
+    pub fn check(&self) -> String {
+        format!("{}(check-sat)\n", self.script)
+    }
+
+    /// The script asking for a violating input
+    pub fn model(&self) -> String {
+        format!(
+            "{}(check-sat)\n(get-value ({}))\n",
+            self.script,
+            self.symbols.join(" ")
+        )
+    }
+}
+
+/// Prove every assertion of a spec with the configured solver
+pub fn prove(spec: &Spec) -> Result<Vec<Proof>> {
+    let command = solver_command();
+    prove_with(spec, &mut |script| run_solver(&command, script))
+}
+
+/// The configured solver command
+pub(crate) fn solver_command() -> String {
+    std::env::var("IMACS_SMT_SOLVER").unwrap_or_else(|_| DEFAULT_SOLVER.to_string())
+}
+
+/// Prove every assertion of a spec, passing each SMT-LIB script to `solve`
+/// and reading back the solver's output
+pub fn prove_with(
+    spec: &Spec,
+    solve: &mut dyn FnMut(&str) -> Result<String>,
+) -> Result<Vec<Proof>> {
+    spec.assertions
+        .iter()
+        .map(|assertion| {
+            let verdict = match problem(spec, assertion) {
+                Err(reason) => Verdict::Unsupported(reason),
+                Ok(problem) => {
+                    let output = solve(&problem.check())?;
+                    match first_line(&output) {
+                        "unsat" => Verdict::Proved,
+                        "unknown" => Verdict::Unknown,
+                        "sat" => {
+                            let output = solve(&problem.model())?;
+                            let mut model = output
+                                .trim_start()
+                                .trim_start_matches("sat")
+                                .split_whitespace()
+                                .collect::<Vec<_>>()
+                                .join(" ");
+                            // Report spec names, not the quoted symbols
+                            for quoted in &problem.symbols {
+                                model = model.replace(quoted, quoted.trim_matches('|'));
+                            }
+                            Verdict::Counterexample(format!("fails for {}", model))
+                        }
+                        other => {
+                            return Err(Error::Other(format!(
+                                "Unexpected SMT solver output for {}: {}",
+                                assertion.id, other
+                            )))
+                        }
+                    }
+                }
+            };
+            Ok(Proof {
+                id: assertion.id.clone(),
+                verdict,
+            })
+        })
+        .collect()
+}
+
+pub(crate) fn first_line(output: &str) -> &str {
+    output
+        .trim_start()
+        .lines()
+        .next()
+        .unwrap_or_default()
+        .trim()
+}
+
+pub(crate) fn run_solver(command: &str, script: &str) -> Result<String> {
+    let mut parts = command.split_whitespace();
+    let program = parts
+        .next()
+        .ok_or_else(|| Error::Other("IMACS_SMT_SOLVER is empty".into()))?;
+    let mut child = Command::new(program)
+        .args(parts)
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .map_err(|e| {
+            Error::Other(format!(
+                "Failed to run SMT solver '{}' (install z3 or set IMACS_SMT_SOLVER): {}",
+                program, e
+            ))
+        })?;
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin.write_all(script.as_bytes()).map_err(Error::Io)?;
+    }
+    let output = child.wait_with_output().map_err(Error::Io)?;
+    Ok(String::from_utf8_lossy(&output.stdout).into_owned())
+}
+
+/// The SMT symbol for a spec name, quoted so that names which are SMT-LIB
+/// reserved words or built-in functions (`assert`, `abs`, `div`) stay plain
+/// constants
+pub(crate) fn symbol(name: &str) -> String {
+    format!("|{}|", name)
+}
+
+/// SMT sorts of spec values
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum Sort {
+    Bool,
+    Int,
+    Real,
+    String,
+}
+
+impl Sort {
+    pub(crate) fn of(typ: &VarType) -> Option<Self> {
+        match typ {
+            VarType::Bool => Some(Sort::Bool),
+            VarType::Int => Some(Sort::Int),
+            VarType::Float => Some(Sort::Real),
+            VarType::String | VarType::Enum(_) => Some(Sort::String),
+            VarType::List(_) | VarType::Object => None,
+        }
+    }
+
+    pub(crate) fn name(self) -> &'static str {
+        match self {
+            Sort::Bool => "Bool",
+            Sort::Int => "Int",
+            Sort::Real => "Real",
+            Sort::String => "String",
+        }
+    }
+}
+
+/// An SMT term and its sort
+pub(crate) type Term = (String, Sort);
+
+/// Terms names resolve to
+pub(crate) type Env = HashMap<String, Term>;
+
+/// Translate an assertion over a spec; `Err` explains what has no SMT
+/// translation
+pub fn problem(spec: &Spec, assertion: &Assertion) -> std::result::Result<Problem, String> {
+    if !spec.normalize.is_empty() {
+        return Err("the spec normalizes its inputs".into());
+    }
+    if spec.outputs.is_empty() {
+        return Err("the spec declares no output types".into());
+    }
+
+    let varied = assertion.compare.as_ref().map(|c| c.input.as_str());
+    let mut lines = vec!["(set-logic ALL)".to_string()];
+    let mut symbols = Vec::new();
+    let env = declare_inputs(spec, varied, &mut lines, &mut symbols)?;
+
+    let goal = match &assertion.compare {
+        None => {
+            assume_constraints(spec, &env, &mut lines)?;
+            let (outputs, matched) = define_outputs(spec, &env, "", &mut lines, &mut symbols)?;
+            if let Some(given) = &assertion.given {
+                lines.push(format!("(assert {})", condition(given, &env)?));
+            }
+            let mut scope = env.clone();
+            scope.extend(outputs);
+            format!(
+                "(and {} {})",
+                matched,
+                condition(&assertion.expect, &scope)?
+            )
+        }
+        Some(compare) => {
+            let var = spec
+                .inputs
+                .iter()
+                .find(|v| v.name == compare.input)
+                .ok_or_else(|| format!("unknown input '{}'", compare.input))?;
+            let sort = Sort::of(&var.typ).unwrap_or(Sort::String);
+            let mut scope = env.clone();
+            let mut matched = Vec::new();
+            for (side, value) in [("left", &compare.left), ("right", &compare.right)] {
+                let mut side_env = env.clone();
+                side_env.insert(var.name.clone(), coerce(value_literal(value)?, sort)?);
+                assume_constraints(spec, &side_env, &mut lines)?;
+                // `given` is checked on the left-hand input
+                if side == "left" {
+                    if let Some(given) = &assertion.given {
+                        lines.push(format!("(assert {})", condition(given, &side_env)?));
+                    }
+                }
+                let prefix = format!("{}_", side);
+                let (outputs, side_matched) =
+                    define_outputs(spec, &side_env, &prefix, &mut lines, &mut symbols)?;
+                for (name, term) in outputs {
+                    scope.insert(format!("{}.{}", side, name), term);
+                }
+                matched.push(side_matched);
+            }
+            format!(
+                "(and {} {} {})",
+                matched[0],
+                matched[1],
+                condition(&assertion.expect, &scope)?
+            )
+        }
+    };
+    lines.push(format!("(assert (not {}))", goal));
+
+    Ok(Problem {
+        script: lines.iter().map(|line| format!("{}\n", line)).collect(),
+        symbols,
+    })
+}
+
+/// Declare every input but `skip`, limited to its enum or listed values,
+/// returning the terms their names resolve to
+pub(crate) fn declare_inputs(
+    spec: &Spec,
+    skip: Option<&str>,
+    lines: &mut Vec<String>,
+    symbols: &mut Vec<String>,
+) -> std::result::Result<Env, String> {
+    let mut env = Env::new();
+    for var in &spec.inputs {
+        let sort = Sort::of(&var.typ)
+            .ok_or_else(|| format!("input '{}' is a list or object", var.name))?;
+        if var.optional && var.default.is_none() {
+            return Err(format!("input '{}' is optional", var.name));
+        }
+        if Some(var.name.as_str()) == skip {
+            continue;
+        }
+        let name = symbol(&var.name);
+        lines.push(format!("(declare-const {} {})", name, sort.name()));
+        let values = match &var.typ {
+            VarType::Enum(values) => Some(values),
+            _ => var.values.as_ref(),
+        };
+        if let Some(values) = values {
+            lines.push(format!("(assert {})", one_of(&name, values)));
+        }
+        env.insert(var.name.clone(), (name.clone(), sort));
+        symbols.push(name);
+    }
+    Ok(env)
+}
+
+/// A symbol equal to one of the listed string values
+pub(crate) fn one_of(symbol: &str, values: &[String]) -> String {
+    let options: Vec<String> = values
+        .iter()
+        .map(|v| format!("(= {} {})", symbol, string_literal(v)))
+        .collect();
+    format!("(or {})", options.join(" "))
+}
+
+/// Assert the spec's constraints, so only valid inputs are considered
+pub(crate) fn assume_constraints(
+    spec: &Spec,
+    env: &Env,
+    lines: &mut Vec<String>,
+) -> std::result::Result<(), String> {
+    for constraint in &spec.constraints {
+        lines.push(format!(
+            "(assert {})",
+            condition(&constraint.to_cel(), env)?
+        ));
+    }
+    Ok(())
+}
+
+/// Define each output as the first matching rule's value, returning the
+/// output terms and whether any rule (or the default) applies
+pub(crate) fn define_outputs(
+    spec: &Spec,
+    env: &Env,
+    prefix: &str,
+    lines: &mut Vec<String>,
+    symbols: &mut Vec<String>,
+) -> std::result::Result<(Env, String), String> {
+    let rules: Vec<_> = spec
+        .evaluation_order()
+        .into_iter()
+        .filter(|r| r.state.is_active())
+        .collect();
+
+    let mut conditions = Vec::new();
+    let mut rule_envs = Vec::new();
+    for rule in &rules {
+        let mut rule_env = env.clone();
+        for binding in &rule.vars {
+            let term = expression(&binding.expr, &rule_env)?;
+            rule_env.insert(binding.name.clone(), term);
+        }
+        conditions.push(match rule.as_cel() {
+            Some(cel) => condition(&cel, env)?,
+            None => "true".to_string(),
+        });
+        rule_envs.push(rule_env);
+    }
+
+    let mut outputs = Env::new();
+    for output in &spec.outputs {
+        let sort = Sort::of(&output.typ)
+            .ok_or_else(|| format!("output '{}' is a list or object", output.name))?;
+        let name = format!("{}{}", prefix, output.name);
+        let symbol = symbol(&name);
+        let mut term = match &spec.default {
+            Some(default) => coerce(output_term(spec, default, &output.name, env)?, sort)?.0,
+            None => {
+                // Unconstrained: only reachable when no rule matches, which
+                // is itself a violation
+                let unmatched = self::symbol(&format!("{}__unmatched", name));
+                lines.push(format!("(declare-const {} {})", unmatched, sort.name()));
+                unmatched
+            }
+        };
+        for ((rule, rule_env), cond) in rules.iter().zip(&rule_envs).zip(&conditions).rev() {
+            let value = coerce(output_term(spec, &rule.then, &output.name, rule_env)?, sort)
+                .map_err(|e| format!("rule {}: {}", rule.id, e))?;
+            term = format!("(ite {} {} {})", cond, value.0, term);
+        }
+        lines.push(format!(
+            "(define-fun {} () {} {})",
+            symbol,
+            sort.name(),
+            term
+        ));
+        outputs.insert(output.name.clone(), (symbol.clone(), sort));
+        symbols.push(symbol);
+    }
+
+    let matched = if spec.default.is_some() {
+        "true".to_string()
+    } else {
+        let symbol = symbol(&format!("{}matched", prefix));
+        let any = match conditions.len() {
+            0 => "false".to_string(),
+            1 => conditions[0].clone(),
+            _ => format!("(or {})", conditions.join(" ")),
+        };
+        lines.push(format!("(define-fun {} () Bool {})", symbol, any));
+        symbols.push(symbol.clone());
+        symbol
+    };
+    Ok((outputs, matched))
+}
+
+/// The term a rule (or the default) gives one output
+fn output_term(
+    spec: &Spec,
+    then: &Output,
+    name: &str,
+    env: &Env,
+) -> std::result::Result<Term, String> {
+    let value = match then {
+        Output::Single(value) if spec.outputs.len() == 1 => value,
+        Output::Single(_) => return Err("a single value for several outputs".into()),
+        Output::Named(fields) => fields
+            .get(name)
+            .ok_or_else(|| format!("no value for output '{}'", name))?,
+    };
+    match value {
+        ConditionValue::String(s) if env.contains_key(s) || is_expression(s) => expression(s, env),
+        other => value_literal(other),
+    }
+}
+
+pub(crate) fn condition(cel: &str, env: &Env) -> std::result::Result<String, String> {
+    let (term, sort) = expression(cel, env)?;
+    if sort != Sort::Bool {
+        return Err(format!("'{}' is not a condition", cel));
+    }
+    Ok(term)
+}
+
+fn expression(cel: &str, env: &Env) -> std::result::Result<Term, String> {
+    let expr = CelCompiler::parse(cel).map_err(|e| e.to_string())?;
+    term(&expr, env)
+}
+
+fn term(expr: &CelExpr, env: &Env) -> std::result::Result<Term, String> {
+    match &expr.expr {
+        Expr::Ident(name) => env
+            .get(name.as_str())
+            .cloned()
+            .ok_or_else(|| format!("unknown name '{}'", name)),
+        Expr::Select(select) => match &select.operand.expr {
+            Expr::Ident(base) => {
+                let path = format!("{}.{}", base, select.field);
+                env.get(&path)
+                    .cloned()
+                    .ok_or_else(|| format!("unknown name '{}'", path))
+            }
+            _ => Err("nested field access".into()),
+        },
+        Expr::Literal(val) => match val {
+            Val::Int(i) => Ok((int_literal(*i), Sort::Int)),
+            Val::UInt(u) => Ok((u.to_string(), Sort::Int)),
+            Val::Double(f) => Ok((real_literal(*f)?, Sort::Real)),
+            Val::String(s) => Ok((string_literal(s), Sort::String)),
+            Val::Boolean(b) => Ok((b.to_string(), Sort::Bool)),
+            Val::Bytes(_) | Val::Null => Err("bytes or null literal".into()),
+        },
+        Expr::Call(call) => {
+            let name = call.func_name.as_str();
+            if name == operators::IN {
+                let needle = term(&call.args[0], env)?;
+                let Expr::List(list) = &call.args[1].expr else {
+                    return Err("'in' over a non-literal list".into());
+                };
+                let options = list
+                    .elements
+                    .iter()
+                    .map(|e| {
+                        let (a, b, _) = unify(needle.clone(), term(e, env)?)?;
+                        Ok(format!("(= {} {})", a, b))
+                    })
+                    .collect::<std::result::Result<Vec<_>, String>>()?;
+                return Ok(match options.len() {
+                    0 => ("false".into(), Sort::Bool),
+                    _ => (format!("(or {})", options.join(" ")), Sort::Bool),
+                });
+            }
+
+            let mut args = Vec::new();
+            if let Some(target) = &call.target {
+                args.push(term(target, env)?);
+            }
+            for arg in &call.args {
+                args.push(term(arg, env)?);
+            }
+            apply(name, args)
+        }
+        _ => Err("list or map expression".into()),
+    }
+}
+
+/// Apply an operator or function to translated arguments
+fn apply(name: &str, mut args: Vec<Term>) -> std::result::Result<Term, String> {
+    let sorts: Vec<Sort> = args.iter().map(|(_, sort)| *sort).collect();
+    match (name, sorts.as_slice()) {
+        (operators::LOGICAL_AND, [Sort::Bool, Sort::Bool]) => {
+            Ok((format!("(and {} {})", args[0].0, args[1].0), Sort::Bool))
+        }
+        (operators::LOGICAL_OR, [Sort::Bool, Sort::Bool]) => {
+            Ok((format!("(or {} {})", args[0].0, args[1].0), Sort::Bool))
+        }
+        (operators::LOGICAL_NOT, [Sort::Bool]) => Ok((format!("(not {})", args[0].0), Sort::Bool)),
+        (operators::NEGATE, [Sort::Int | Sort::Real]) => {
+            Ok((format!("(- {})", args[0].0), sorts[0]))
+        }
+        (operators::EQUALS | operators::NOT_EQUALS, [_, _]) => {
+            let right = args.pop().unwrap();
+            let (a, b, _) = unify(args.pop().unwrap(), right)?;
+            let equal = format!("(= {} {})", a, b);
+            Ok(match name {
+                operators::EQUALS => (equal, Sort::Bool),
+                _ => (format!("(not {})", equal), Sort::Bool),
+            })
+        }
+        (
+            operators::LESS
+            | operators::LESS_EQUALS
+            | operators::GREATER
+            | operators::GREATER_EQUALS,
+            [Sort::Int | Sort::Real, Sort::Int | Sort::Real],
+        ) => {
+            let right = args.pop().unwrap();
+            let (a, b, _) = unify(args.pop().unwrap(), right)?;
+            let op = match name {
+                operators::LESS => "<",
+                operators::LESS_EQUALS => "<=",
+                operators::GREATER => ">",
+                _ => ">=",
+            };
+            Ok((format!("({} {} {})", op, a, b), Sort::Bool))
+        }
+        (operators::ADD, [Sort::String, Sort::String]) => Ok((
+            format!("(str.++ {} {})", args[0].0, args[1].0),
+            Sort::String,
+        )),
+        (
+            operators::ADD | operators::SUBSTRACT | operators::MULTIPLY,
+            [Sort::Int | Sort::Real, Sort::Int | Sort::Real],
+        ) => {
+            let right = args.pop().unwrap();
+            let (a, b, sort) = unify(args.pop().unwrap(), right)?;
+            let op = match name {
+                operators::ADD => "+",
+                operators::SUBSTRACT => "-",
+                _ => "*",
+            };
+            Ok((format!("({} {} {})", op, a, b), sort))
+        }
+        (operators::DIVIDE, [Sort::Real, Sort::Int | Sort::Real])
+        | (operators::DIVIDE, [Sort::Int, Sort::Real]) => {
+            let right = args.pop().unwrap();
+            let (a, b, _) = unify(args.pop().unwrap(), right)?;
+            Ok((format!("(/ {} {})", a, b), Sort::Real))
+        }
+        (operators::CONDITIONAL, [Sort::Bool, _, _]) => {
+            let otherwise = args.pop().unwrap();
+            let (a, b, sort) = unify(args.pop().unwrap(), otherwise)?;
+            Ok((format!("(ite {} {} {})", args[0].0, a, b), sort))
+        }
+        ("size", [Sort::String]) => Ok((format!("(str.len {})", args[0].0), Sort::Int)),
+        ("startsWith", [Sort::String, Sort::String]) => Ok((
+            format!("(str.prefixof {} {})", args[1].0, args[0].0),
+            Sort::Bool,
+        )),
+        ("endsWith", [Sort::String, Sort::String]) => Ok((
+            format!("(str.suffixof {} {})", args[1].0, args[0].0),
+            Sort::Bool,
+        )),
+        ("contains", [Sort::String, Sort::String]) => Ok((
+            format!("(str.contains {} {})", args[0].0, args[1].0),
+            Sort::Bool,
+        )),
+        // CEL integer division truncates, SMT `div` and `mod` round down
+        (operators::DIVIDE | operators::MODULO, [Sort::Int, Sort::Int]) => {
+            Err("integer division".into())
+        }
+        _ => Err(format!("'{}' on {:?}", name, sorts)),
+    }
+}
+
+/// Bring two terms to a common sort, promoting ints to reals
+fn unify(a: Term, b: Term) -> std::result::Result<(String, String, Sort), String> {
+    match (a.1, b.1) {
+        (x, y) if x == y => Ok((a.0, b.0, x)),
+        (Sort::Int, Sort::Real) => Ok((format!("(to_real {})", a.0), b.0, Sort::Real)),
+        (Sort::Real, Sort::Int) => Ok((a.0, format!("(to_real {})", b.0), Sort::Real)),
+        (x, y) => Err(format!("{} compared with {}", x.name(), y.name())),
+    }
+}
+
+fn coerce(term: Term, sort: Sort) -> std::result::Result<Term, String> {
+    match (term.1, sort) {
+        (x, y) if x == y => Ok(term),
+        (Sort::Int, Sort::Real) => Ok((format!("(to_real {})", term.0), Sort::Real)),
+        (x, y) => Err(format!("{} value where {} is expected", x.name(), y.name())),
+    }
+}
+
+fn value_literal(value: &ConditionValue) -> std::result::Result<Term, String> {
+    match value {
+        ConditionValue::Bool(b) => Ok((b.to_string(), Sort::Bool)),
+        ConditionValue::Int(i) => Ok((int_literal(*i), Sort::Int)),
+        ConditionValue::Float(f) => Ok((real_literal(*f)?, Sort::Real)),
+        ConditionValue::String(s) => Ok((string_literal(s), Sort::String)),
+        other => Err(format!("value {}", other)),
+    }
+}
+
+fn int_literal(i: i64) -> String {
+    if i < 0 {
+        format!("(- {})", i.unsigned_abs())
+    } else {
+        i.to_string()
+    }
+}
+
+fn real_literal(f: f64) -> std::result::Result<String, String> {
+    if !f.is_finite() {
+        return Err(format!("non-finite number {}", f));
+    }
+    // Display never uses exponent notation
+    let mut digits = f.abs().to_string();
+    if !digits.contains('.') {
+        digits.push_str(".0");
+    }
+    Ok(if f < 0.0 {
+        format!("(- {})", digits)
+    } else {
+        digits
+    })
+}
+
+/// SMT-LIB 2.6 string literal: `"` doubled, everything outside printable
+/// ASCII (and backslash) as `\u{..}`
+fn string_literal(s: &str) -> String {
+    let mut out = String::from("\"");
+    for c in s.chars() {
+        match c {
+            '"' => out.push_str("\"\""),
+            ' '..='~' if c != '\\' => out.push(c),
+            _ => out.push_str(&format!("\\u{{{:x}}}", c as u32)),
+        }
+    }
+    out.push('"');
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping
+inputs:
+  - name: weight_kg
+    type: float
+  - name: tier
+    type: string
+    values: [standard, gold]
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+  - id: GOLD
+    when: "tier == 'gold'"
+    then: 0
+default: 10.0
+constraints:
+  - id: positive_weight
+    require: "weight_kg > 0.0"
+assertions:
+  - id: bounded
+    expect: "rate <= 25.0"
+  - id: gold_free
+    given: "tier == 'gold'"
+    expect: "rate == 0.0"
+  - id: monotonic
+    compare:
+      input: weight_kg
+      left: 10.0
+      right: 30.0
+    expect: "left.rate <= right.rate"
+"#;
+
+    fn spec() -> Spec {
+        Spec::from_yaml(SPEC).unwrap()
+    }
+
+    #[test]
+    fn test_problem_script() {
+        let spec = spec();
+        let problem = problem(&spec, &spec.assertions[0]).unwrap();
+        assert_eq!(
+            problem.script,
+            "(set-logic ALL)\n\
+             (declare-const |weight_kg| Real)\n\
+             (declare-const |tier| String)\n\
+             (assert (or (= |tier| \"standard\") (= |tier| \"gold\")))\n\
+             (assert (> |weight_kg| 0.0))\n\
+             (define-fun |rate| () Real (ite (> |weight_kg| 20.0) 25.0 (ite (= |tier| \"gold\") (to_real 0) 10.0)))\n\
+             (assert (not (and true (<= |rate| 25.0))))\n"
+        );
+        assert_eq!(problem.symbols, vec!["|weight_kg|", "|tier|", "|rate|"]);
+        assert!(problem
+            .model()
+            .ends_with("(get-value (|weight_kg| |tier| |rate|))\n"));
+    }
+
+    #[test]
+    fn test_problem_compare() {
+        let spec = spec();
+        let problem = problem(&spec, &spec.assertions[2]).unwrap();
+        assert!(!problem.script.contains("declare-const |weight_kg|"));
+        assert!(problem
+            .script
+            .contains("(define-fun |left_rate| () Real (ite (> 10.0 20.0) 25.0"));
+        assert!(problem
+            .script
+            .contains("(define-fun |right_rate| () Real (ite (> 30.0 20.0) 25.0"));
+        assert!(problem
+            .script
+            .contains("(assert (not (and true true (<= |left_rate| |right_rate|))))"));
+    }
+
+    #[test]
+    fn test_problem_without_default() {
+        let mut spec = spec();
+        spec.default = None;
+        let problem = problem(&spec, &spec.assertions[0]).unwrap();
+        assert!(problem
+            .script
+            .contains("(declare-const |rate__unmatched| Real)"));
+        assert!(problem.script.contains(
+            "(define-fun |matched| () Bool (or (> |weight_kg| 20.0) (= |tier| \"gold\")))"
+        ));
+        assert!(problem
+            .script
+            .contains("(assert (not (and |matched| (<= |rate| 25.0))))"));
+    }
+
+    #[test]
+    fn test_problem_skips_inactive_rules() {
+        let mut spec = spec();
+        spec.rules[1].state = crate::spec::RuleState::Draft;
+        let problem = problem(&spec, &spec.assertions[0]).unwrap();
+        assert!(problem
+            .script
+            .contains("(define-fun |rate| () Real (ite (> |weight_kg| 20.0) 25.0 10.0))"));
+    }
+
+    #[test]
+    fn test_problem_quotes_reserved_names() {
+        let yaml = SPEC.replace("tier", "assert");
+        let spec = Spec::from_yaml(&yaml).unwrap();
+        let problem = problem(&spec, &spec.assertions[0]).unwrap();
+        assert!(problem.script.contains("(declare-const |assert| String)\n"));
+    }
+
+    #[test]
+    fn test_unsupported() {
+        let mut spec = spec();
+        spec.assertions[0].expect = "string(rate).matches('^2')".into();
+        assert!(problem(&spec, &spec.assertions[0]).is_err());
+        spec.assertions[0].expect = "size(tier) / 2 == 1".into();
+        assert!(problem(&spec, &spec.assertions[0]).is_err());
+
+        let proofs = prove_with(&spec, &mut |_| Ok("unsat\n".into())).unwrap();
+        assert!(matches!(proofs[0].verdict, Verdict::Unsupported(_)));
+        assert_eq!(proofs[1].verdict, Verdict::Proved);
+    }
+
+    #[test]
+    fn test_prove_with_solver_output() {
+        let spec = spec();
+        let mut calls = 0;
+        let proofs = prove_with(&spec, &mut |script| {
+            calls += 1;
+            Ok(if script.contains("get-value") {
+                "sat\n((|weight_kg| 25.0)\n (|tier| \"gold\")\n (|rate| 25.0))\n".into()
+            } else if script.contains("(= |rate| 0.0)") {
+                "sat\n".into()
+            } else {
+                "unsat\n".into()
+            })
+        })
+        .unwrap();
+        assert_eq!(calls, 4);
+        assert_eq!(proofs[0].verdict, Verdict::Proved);
+        assert_eq!(
+            proofs[1].verdict,
+            Verdict::Counterexample(
+                "fails for ((weight_kg 25.0) (tier \"gold\") (rate 25.0))".into()
+            )
+        );
+        assert_eq!(proofs[2].verdict, Verdict::Proved);
+    }
+
+    #[test]
+    fn test_literals() {
+        assert_eq!(int_literal(-3), "(- 3)");
+        assert_eq!(real_literal(2.0).unwrap(), "2.0");
+        assert_eq!(real_literal(-0.5).unwrap(), "(- 0.5)");
+        assert_eq!(real_literal(1e-7).unwrap(), "0.0000001");
+        assert_eq!(string_literal("a\"b\\é"), "\"a\"\"b\\u{5c}\\u{e9}\"");
+    }
+
+    #[test]
+    fn test_prove_with_z3() {
+        if Command::new("z3").arg("-version").output().is_err() {
+            return;
+        }
+        let proofs = prove(&spec()).unwrap();
+        assert_eq!(proofs[0].verdict, Verdict::Proved);
+        // A heavy gold parcel pays the heavy rate
+        assert!(matches!(proofs[1].verdict, Verdict::Counterexample(_)));
+        assert_eq!(proofs[2].verdict, Verdict::Proved);
+    }
+}
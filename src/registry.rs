@@ -0,0 +1,370 @@
+//! Spec registry client
+//!
+//! Fetches specs published to a central registry, so that many services can
+//! evaluate the same centrally managed rules with [`crate::runtime`].
+//!
+//! A registry is any HTTP(S) location (an S3 bucket works) laid out as
+//!
+//! ```text
+//! {base}/{name}/{version}.yaml          the spec
+//! {base}/{name}/{version}.yaml.sha256   hex SHA-256 of the spec file
+//! {base}/{name}/deployed                version deployed now (`imacs apply`)
+//! ```
+//!
+//! Every fetched spec is checked against the published digest, or against a
+//! digest pinned by the caller, before it is parsed. A client created with
+//! [`RegistryClient::require_signature`] also refuses specs without a valid
+//! signature (embedded, or at `{version}.yaml.sig`); see [`crate::signing`].
+
+use crate::error::{Error, Result};
+use crate::runtime::{self, Evaluation, Values};
+use crate::signing;
+use crate::spec::Spec;
+use sha2::{Digest, Sha256};
+use std::collections::HashMap;
+use std::sync::Mutex;
+
+/// Retrieves raw bytes from a registry URL
+pub trait Transport: Send + Sync {
+    fn get(&self, url: &str) -> Result<Vec<u8>>;
+
+    /// GET with an `Accept` header, for servers that negotiate the content
+    /// type (OCI manifests); transports that cannot send one fall back to `get`
+    fn get_accepting(&self, url: &str, accept: &str) -> Result<Vec<u8>> {
+        let _ = accept;
+        self.get(url)
+    }
+}
+
+/// Plain HTTP(S) transport
+pub struct HttpTransport;
+
+impl Transport for HttpTransport {
+    fn get(&self, url: &str) -> Result<Vec<u8>> {
+        let mut response = ureq::get(url)
+            .call()
+            .map_err(|e| Error::Registry(format!("GET {}: {}", url, e)))?;
+        response
+            .body_mut()
+            .read_to_vec()
+            .map_err(|e| Error::Registry(format!("GET {}: {}", url, e)))
+    }
+
+    fn get_accepting(&self, url: &str, accept: &str) -> Result<Vec<u8>> {
+        let mut response = ureq::get(url)
+            .header("Accept", accept)
+            .call()
+            .map_err(|e| Error::Registry(format!("GET {}: {}", url, e)))?;
+        response
+            .body_mut()
+            .read_to_vec()
+            .map_err(|e| Error::Registry(format!("GET {}: {}", url, e)))
+    }
+}
+
+/// Client for a spec registry
+///
+/// Published versions are immutable, so verified specs are cached for the
+/// lifetime of the client.
+pub struct RegistryClient {
+    base_url: String,
+    transport: Box<dyn Transport>,
+    /// Public key every spec must be signed with (strict mode)
+    public_key: Option<String>,
+    /// Verified specs and their digests by (name, version)
+    cache: Mutex<HashMap<(String, String), (String, Spec)>>,
+}
+
+impl RegistryClient {
+    /// Client for the registry at `base_url`, fetching over HTTP(S)
+    pub fn new(base_url: impl Into<String>) -> Self {
+        Self::with_transport(base_url, HttpTransport)
+    }
+
+    /// Client using a custom transport (authenticated S3, tests)
+    pub fn with_transport(
+        base_url: impl Into<String>,
+        transport: impl Transport + 'static,
+    ) -> Self {
+        Self {
+            base_url: base_url.into().trim_end_matches('/').to_string(),
+            transport: Box::new(transport),
+            public_key: None,
+            cache: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// Strict mode: refuse specs not signed with `public_key`
+    pub fn require_signature(mut self, public_key: impl Into<String>) -> Self {
+        self.public_key = Some(public_key.into());
+        self
+    }
+
+    /// URL of a spec version
+    pub fn spec_url(&self, name: &str, version: &str) -> String {
+        format!("{}/{}/{}.yaml", self.base_url, name, version)
+    }
+
+    /// Fetch a spec version, verified against the registry's published digest
+    pub fn fetch(&self, name: &str, version: &str) -> Result<Spec> {
+        self.fetch_verified(name, version, None)
+    }
+
+    /// Fetch a spec version, verified against a digest the caller trusts
+    ///
+    /// `sha256` is hex, optionally prefixed with `sha256:`.
+    pub fn fetch_pinned(&self, name: &str, version: &str, sha256: &str) -> Result<Spec> {
+        self.fetch_verified(name, version, Some(sha256))
+    }
+
+    /// Fetch the version `imacs apply` last deployed, and its version
+    ///
+    /// The pointer is read on every call, so a client follows deployments
+    /// and rollbacks; the versions it points at are cached as usual.
+    pub fn fetch_deployed(&self, name: &str) -> Result<(String, Spec)> {
+        let url = format!(
+            "{}/{}/{}",
+            self.base_url,
+            name,
+            crate::deploy::DEPLOYED_FILE
+        );
+        let version = String::from_utf8_lossy(&self.transport.get(&url)?)
+            .trim()
+            .to_string();
+        let spec = self.fetch(name, &version)?;
+        Ok((version, spec))
+    }
+
+    /// Fetch a spec version and evaluate it against an input
+    pub fn evaluate(&self, name: &str, version: &str, input: &Values) -> Result<Evaluation> {
+        runtime::evaluate(&self.fetch(name, version)?, input)
+    }
+
+    fn fetch_verified(&self, name: &str, version: &str, pinned: Option<&str>) -> Result<Spec> {
+        for part in [name, version] {
+            if part.is_empty() || part.contains('/') || part.contains("..") {
+                return Err(Error::Registry(format!(
+                    "Invalid spec name or version: '{}'",
+                    part
+                )));
+            }
+        }
+
+        let mismatch = |expected: &str, actual: &str| {
+            Error::Registry(format!(
+                "{}@{}: digest mismatch (expected {}, got {})",
+                name, version, expected, actual
+            ))
+        };
+        let pinned = pinned.map(normalize_digest);
+
+        let key = (name.to_string(), version.to_string());
+        if let Some((digest, spec)) = self.cache.lock().unwrap().get(&key) {
+            return match &pinned {
+                Some(expected) if expected != digest => Err(mismatch(expected, digest)),
+                _ => Ok(spec.clone()),
+            };
+        }
+
+        let url = self.spec_url(name, version);
+        let expected = match pinned {
+            Some(digest) => digest,
+            None => {
+                let published = self.transport.get(&format!("{}.sha256", url))?;
+                // Accept `sha256sum` output: the digest is the first field
+                normalize_digest(
+                    String::from_utf8_lossy(&published)
+                        .split_whitespace()
+                        .next()
+                        .unwrap_or_default(),
+                )
+            }
+        };
+
+        let body = self.transport.get(&url)?;
+        let actual = sha256_hex(&body);
+        if actual != expected {
+            return Err(mismatch(&expected, &actual));
+        }
+
+        let yaml = String::from_utf8(body)
+            .map_err(|e| Error::Registry(format!("{}@{}: {}", name, version, e)))?;
+        if let Some(public_key) = &self.public_key {
+            let signature = match signing::embedded_signature(&yaml) {
+                Some(signature) => signature,
+                None => {
+                    let sidecar = self.transport.get(&format!("{}.sig", url)).map_err(|_| {
+                        Error::Signature(format!("{}@{} is not signed", name, version))
+                    })?;
+                    String::from_utf8_lossy(&sidecar).into_owned()
+                }
+            };
+            signing::verify(&yaml, &signature, public_key)?;
+        }
+        let spec = Spec::from_yaml(&yaml)?;
+        if spec.id != name {
+            return Err(Error::Registry(format!(
+                "{}@{}: registry returned spec '{}'",
+                name, version, spec.id
+            )));
+        }
+
+        self.cache
+            .lock()
+            .unwrap()
+            .insert(key, (actual, spec.clone()));
+        Ok(spec)
+    }
+}
+
+/// Lowercase hex without the optional `sha256:` prefix
+fn normalize_digest(digest: &str) -> String {
+    digest
+        .trim()
+        .trim_start_matches("sha256:")
+        .to_ascii_lowercase()
+}
+
+/// Hex SHA-256 of a spec file, as published next to it in a registry
+pub fn sha256_hex(bytes: &[u8]) -> String {
+    hex::encode(Sha256::digest(bytes))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::atomic::{AtomicUsize, Ordering};
+    use std::sync::Arc;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 10.0
+"#;
+
+    struct MemoryTransport {
+        files: HashMap<String, Vec<u8>>,
+        requests: Arc<AtomicUsize>,
+    }
+
+    impl Transport for MemoryTransport {
+        fn get(&self, url: &str) -> Result<Vec<u8>> {
+            self.requests.fetch_add(1, Ordering::SeqCst);
+            self.files
+                .get(url)
+                .cloned()
+                .ok_or_else(|| Error::Registry(format!("GET {}: 404", url)))
+        }
+    }
+
+    fn client(spec: &str, digest: &str) -> (RegistryClient, Arc<AtomicUsize>) {
+        let requests = Arc::new(AtomicUsize::new(0));
+        let files = HashMap::from([
+            (
+                "https://rules.example.com/shipping_rate/v3.yaml".to_string(),
+                spec.as_bytes().to_vec(),
+            ),
+            (
+                "https://rules.example.com/shipping_rate/v3.yaml.sha256".to_string(),
+                format!("{}  v3.yaml\n", digest).into_bytes(),
+            ),
+            (
+                "https://rules.example.com/shipping_rate/deployed".to_string(),
+                b"v3\n".to_vec(),
+            ),
+        ]);
+        let transport = MemoryTransport {
+            files,
+            requests: Arc::clone(&requests),
+        };
+        (
+            RegistryClient::with_transport("https://rules.example.com/", transport),
+            requests,
+        )
+    }
+
+    #[test]
+    fn test_fetch_verifies_published_digest() {
+        let (registry, requests) = client(SPEC, &sha256_hex(SPEC.as_bytes()));
+
+        let spec = registry.fetch("shipping_rate", "v3").unwrap();
+        assert_eq!(spec.id, "shipping_rate");
+        assert_eq!(requests.load(Ordering::SeqCst), 2);
+
+        // Cached after the first fetch
+        registry.fetch("shipping_rate", "v3").unwrap();
+        assert_eq!(requests.load(Ordering::SeqCst), 2);
+
+        let input = HashMap::from([("zone".to_string(), "domestic".into())]);
+        let result = registry.evaluate("shipping_rate", "v3", &input).unwrap();
+        assert_eq!(result.rule_id.as_deref(), Some("R1"));
+    }
+
+    #[test]
+    fn test_fetch_rejects_tampered_spec() {
+        let tampered = SPEC.replace("then: 5.0", "then: 0.0");
+        let (registry, _) = client(&tampered, &sha256_hex(SPEC.as_bytes()));
+
+        let err = registry.fetch("shipping_rate", "v3").unwrap_err();
+        assert!(err.to_string().contains("digest mismatch"));
+    }
+
+    #[test]
+    fn test_strict_mode_requires_signature() {
+        let keys = signing::generate_keypair().unwrap();
+        let (registry, _) = client(SPEC, &sha256_hex(SPEC.as_bytes()));
+        let registry = registry.require_signature(keys.public.clone());
+        assert!(registry.fetch("shipping_rate", "v3").is_err());
+
+        let signed =
+            signing::embed_signature(SPEC, &signing::sign(SPEC, &keys.secret).unwrap()).unwrap();
+        let (registry, _) = client(&signed, &sha256_hex(signed.as_bytes()));
+        let registry = registry.require_signature(keys.public);
+        assert!(registry.fetch("shipping_rate", "v3").is_ok());
+    }
+
+    #[test]
+    fn test_fetch_pinned() {
+        let (registry, requests) = client(SPEC, "0000");
+        let digest = format!("sha256:{}", sha256_hex(SPEC.as_bytes()));
+
+        // The pinned digest wins over the (wrong) published one
+        registry
+            .fetch_pinned("shipping_rate", "v3", &digest)
+            .unwrap();
+        assert_eq!(requests.load(Ordering::SeqCst), 1);
+
+        assert!(registry
+            .fetch_pinned("shipping_rate", "v4", &digest)
+            .is_err());
+        // A cached version is still checked against a different pin
+        assert!(registry
+            .fetch_pinned("shipping_rate", "v3", "sha256:0000")
+            .is_err());
+        assert!(registry.fetch("../secrets", "v3").is_err());
+    }
+
+    #[test]
+    fn test_fetch_deployed() {
+        let (registry, requests) = client(SPEC, &sha256_hex(SPEC.as_bytes()));
+        let (version, spec) = registry.fetch_deployed("shipping_rate").unwrap();
+        assert_eq!(
+            (version.as_str(), spec.id.as_str()),
+            ("v3", "shipping_rate")
+        );
+
+        // The pointer is read again; the version comes from the cache
+        registry.fetch_deployed("shipping_rate").unwrap();
+        assert_eq!(requests.load(Ordering::SeqCst), 4);
+        assert!(registry.fetch_deployed("checkout").is_err());
+    }
+}
@@ -88,6 +88,11 @@ impl Renderer {
         // Resolve namespace from spec's scoping configuration
         let config = self.resolve_config(spec);
 
+        // Emit branches in evaluation (priority) order, active rules only
+        let mut ordered = spec.for_codegen().into_owned();
+        ordered.rules = ordered.evaluation_order().into_iter().cloned().collect();
+        let spec = &ordered;
+
         match self.target {
             Target::Rust => rust::render(spec, &config),
             Target::TypeScript => typescript::render(spec, &config),
@@ -153,6 +158,13 @@ pub(crate) fn translate_vars(expr: &str, input_names: &[String], mode: VarTransl
 /// Check if a string looks like a CEL expression (contains operators/variables)
 /// vs a simple literal string
 pub(crate) fn is_expression(s: &str) -> bool {
+    is_expression_calling(s, &[])
+}
+
+/// [`is_expression`], where a bare call is an expression only when it names
+/// a function: one of [`crate::cel::FUNCTIONS`] or of the project's
+/// `functions`. "Thanks(you)" stays text.
+pub(crate) fn is_expression_calling(s: &str, functions: &[CustomFunction]) -> bool {
     // Check for arithmetic/comparison operators that indicate an expression
     let has_operator = s.contains(" + ")
         || s.contains(" - ")
@@ -173,6 +185,16 @@ pub(crate) fn is_expression(s: &str) -> bool {
         return true;
     }
 
+    // Function call such as "max(base, 5.0)" or "format('{carrier}-{zone}')"
+    if let Some(open) = s.find('(') {
+        let name = &s[..open];
+        let known =
+            crate::cel::FUNCTIONS.contains(&name) || functions.iter().any(|f| f.name == name);
+        if known && s.ends_with(')') && crate::cel::CelCompiler::is_valid(s) {
+            return true;
+        }
+    }
+
     // Check if it looks like a variable reference (identifier pattern)
     if !s.contains(' ')
         && s.chars()
@@ -189,6 +211,27 @@ pub(crate) fn is_expression(s: &str) -> bool {
 mod tests {
     use super::*;
 
+    #[test]
+    fn test_is_expression() {
+        assert!(is_expression("a + b"));
+        assert!(is_expression("x == 5"));
+        assert!(is_expression("foo_bar"));
+        assert!(!is_expression("hello"));
+        assert!(!is_expression("OK"));
+        assert!(is_expression("max(base, 5.0)"));
+        assert!(!is_expression("Call us (24/7)"));
+        assert!(!is_expression("Thanks(you)"));
+
+        let functions = vec![CustomFunction {
+            name: "dimWeight".into(),
+            params: vec![VarType::Float, VarType::Float],
+            returns: VarType::Float,
+            go: "example.com/shipping/parcel.DimWeight".into(),
+        }];
+        assert!(!is_expression("dimWeight(l, w)"));
+        assert!(is_expression_calling("dimWeight(l, w)", &functions));
+    }
+
     fn sample_spec() -> Spec {
         Spec::from_yaml(
             r#"
@@ -0,0 +1,509 @@
+//! Replay recorded decisions against a spec (`imacs replay`)
+//!
+//! Production decisions are recorded one JSON object per line: the
+//! `input`, the `output` the deployed rules gave and, as the generated
+//! `Decide` functions stamp them, the `spec`, `version` and `hash` that
+//! decided. [`replay`] evaluates every recorded input with a new version of
+//! the spec and reports the decisions that would change, so a rule change
+//! is checked against real traffic before it ships.
+//!
+//! Changes decided by rules named as accepted (the rules the change is
+//! meant to affect) are listed separately and are not regressions.
+//!
+//! [`crate::Engine::with_audit`] produces records in this format; pass it a
+//! [`jsonl_sink`] to keep a recording of live decisions.
+
+use crate::assertions::literal;
+use crate::cel::CelValue;
+use crate::runtime::{evaluate, from_cel_value, to_cel_value, Evaluation, Values};
+use crate::spec::{ConditionValue, Spec, VarType, Variable};
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, HashMap};
+use std::io::Write;
+use std::sync::Mutex;
+
+/// A recorded decision
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Record {
+    /// Spec that decided; records of other specs are skipped
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub spec: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub version: Option<String>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub hash: Option<String>,
+    /// When the decision was made (RFC 3339)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub at: Option<String>,
+    pub input: BTreeMap<String, ConditionValue>,
+    /// The value for a single output, an object of outputs otherwise
+    pub output: ConditionValue,
+    /// Rule that decided; absent for the default
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub rule_id: Option<String>,
+}
+
+impl Record {
+    /// Record a decision `spec` (whose hash is `hash`) made just now,
+    /// redacting the inputs and outputs the spec marks as personal
+    pub fn new(spec: &Spec, hash: &str, input: &Values, evaluation: &Evaluation) -> Self {
+        let output = if spec.outputs.len() <= 1 && evaluation.outputs.len() == 1 {
+            evaluation
+                .outputs
+                .values()
+                .map(|value| redacted(spec.outputs.first(), from_cel_value(value)))
+                .next()
+                .unwrap_or(ConditionValue::Null)
+        } else {
+            ConditionValue::Map(
+                evaluation
+                    .outputs
+                    .iter()
+                    .map(|(name, value)| {
+                        let var = spec.outputs.iter().find(|v| &v.name == name);
+                        (name.clone(), redacted(var, from_cel_value(value)))
+                    })
+                    .collect(),
+            )
+        };
+        Record {
+            spec: Some(spec.id.clone()),
+            version: spec.meta.version.clone(),
+            hash: Some(hash.to_string()),
+            at: Some(chrono::Utc::now().to_rfc3339()),
+            input: input
+                .iter()
+                .map(|(name, value)| {
+                    let var = spec.inputs.iter().find(|v| &v.name == name);
+                    (name.clone(), redacted(var, from_cel_value(value)))
+                })
+                .collect(),
+            output,
+            rule_id: evaluation.rule_id.clone(),
+        }
+    }
+
+    /// The recorded input, ready to evaluate with `spec`
+    pub fn input_values(&self, spec: &Spec) -> Values {
+        self.input
+            .iter()
+            .map(|(name, value)| (name.clone(), typed(value, input_type(spec, name))))
+            .collect()
+    }
+}
+
+/// A value as recorded for the variable it belongs to
+fn redacted(var: Option<&Variable>, value: ConditionValue) -> ConditionValue {
+    match var.and_then(Variable::redaction) {
+        Some(redact) => {
+            let text = match &value {
+                ConditionValue::String(s) => s.clone(),
+                other => other.to_string(),
+            };
+            ConditionValue::String(redact.apply(&text))
+        }
+        None => value,
+    }
+}
+
+/// An audit sink appending each record to `out` as a JSON line
+///
+/// Write errors are dropped: recording must not fail the decision.
+pub fn jsonl_sink(out: impl Write + Send + 'static) -> impl Fn(&Record) + Send + Sync {
+    let out = Mutex::new(out);
+    move |record| {
+        if let Ok(line) = serde_json::to_string(record) {
+            let mut out = out.lock().unwrap();
+            let _ = writeln!(out, "{}", line);
+        }
+    }
+}
+
+/// The records of a JSONL recording with their line, skipping blank lines
+pub fn records(jsonl: &str) -> impl Iterator<Item = (usize, serde_json::Result<Record>)> + '_ {
+    jsonl
+        .lines()
+        .enumerate()
+        .filter(|(_, text)| !text.trim().is_empty())
+        .map(|(n, text)| (n + 1, serde_json::from_str(text)))
+}
+
+/// A decision the spec now makes differently
+#[derive(Debug, Clone, Serialize)]
+pub struct Change {
+    /// Line in the recording
+    pub line: usize,
+    pub input: String,
+    pub recorded: String,
+    pub replayed: String,
+    /// Rule deciding now, None for the default
+    pub rule_id: Option<String>,
+}
+
+/// A record that could not be replayed
+#[derive(Debug, Clone, Serialize)]
+pub struct Failure {
+    pub line: usize,
+    pub error: String,
+}
+
+/// Outcome of replaying a recording
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct ReplayReport {
+    pub spec: String,
+    pub spec_hash: String,
+    pub replayed: usize,
+    pub unchanged: usize,
+    /// Records of other specs
+    pub skipped: usize,
+    /// Records per recorded spec hash (`unknown` when not stamped)
+    pub recorded_hashes: BTreeMap<String, usize>,
+    pub regressions: Vec<Change>,
+    /// Changes decided by accepted rules
+    pub accepted: Vec<Change>,
+    pub failures: Vec<Failure>,
+}
+
+impl ReplayReport {
+    pub fn passed(&self) -> bool {
+        self.regressions.is_empty() && self.failures.is_empty()
+    }
+
+    /// Summary, listing at most `limit` entries per section
+    pub fn to_report(&self, limit: usize) -> String {
+        let mut out = format!(
+            "Replayed {} decision(s) against {} ({})\n",
+            self.replayed, self.spec, self.spec_hash
+        );
+        if !self.recorded_hashes.is_empty() {
+            let hashes: Vec<String> = self
+                .recorded_hashes
+                .iter()
+                .map(|(hash, n)| {
+                    let current = if *hash == self.spec_hash {
+                        ", this version"
+                    } else {
+                        ""
+                    };
+                    format!("{} ({}{})", hash, n, current)
+                })
+                .collect();
+            out.push_str(&format!("  recorded by: {}\n", hashes.join(", ")));
+        }
+        out.push_str(&format!(
+            "  {} unchanged, {} regression(s), {} accepted change(s), {} failure(s), {} skipped\n",
+            self.unchanged,
+            self.regressions.len(),
+            self.accepted.len(),
+            self.failures.len(),
+            self.skipped
+        ));
+
+        let sections = [
+            ("Regressions", &self.regressions),
+            ("Accepted changes", &self.accepted),
+        ];
+        for (title, changes) in sections {
+            if changes.is_empty() {
+                continue;
+            }
+            out.push_str(&format!("{}:\n", title));
+            for c in changes.iter().take(limit) {
+                out.push_str(&format!(
+                    "  line {}: {} recorded {}, now {} ({})\n",
+                    c.line,
+                    c.input,
+                    c.recorded,
+                    c.replayed,
+                    c.rule_id.as_deref().unwrap_or("default")
+                ));
+            }
+            if changes.len() > limit {
+                out.push_str(&format!("  ... and {} more\n", changes.len() - limit));
+            }
+        }
+        if !self.failures.is_empty() {
+            out.push_str("Failures:\n");
+            for f in self.failures.iter().take(limit) {
+                out.push_str(&format!("  line {}: {}\n", f.line, f.error));
+            }
+            if self.failures.len() > limit {
+                out.push_str(&format!("  ... and {} more\n", self.failures.len() - limit));
+            }
+        }
+        out
+    }
+}
+
+/// Replay a JSONL recording against `spec`; changes decided by the
+/// `accepted` rules (`default` for the fallback) are not regressions
+pub fn replay(spec: &Spec, jsonl: &str, accepted: &[String]) -> ReplayReport {
+    let mut report = ReplayReport {
+        spec: spec.id.clone(),
+        spec_hash: spec.hash(),
+        ..Default::default()
+    };
+    for (line, record) in records(jsonl) {
+        let record = match record {
+            Ok(record) => record,
+            Err(e) => {
+                report.failures.push(Failure {
+                    line,
+                    error: format!("not a decision record: {}", e),
+                });
+                continue;
+            }
+        };
+        if record.spec.as_ref().is_some_and(|id| *id != spec.id) {
+            report.skipped += 1;
+            continue;
+        }
+        report.replayed += 1;
+        *report
+            .recorded_hashes
+            .entry(record.hash.clone().unwrap_or_else(|| "unknown".into()))
+            .or_default() += 1;
+
+        let evaluation = match evaluate(spec, &record.input_values(spec)) {
+            Ok(evaluation) => evaluation,
+            Err(e) => {
+                report.failures.push(Failure {
+                    line,
+                    error: e.to_string(),
+                });
+                continue;
+            }
+        };
+        let recorded = recorded_outputs(spec, &record.output);
+        let same = recorded.len() == evaluation.outputs.len()
+            && recorded.iter().all(|(name, value)| {
+                evaluation
+                    .outputs
+                    .get(name)
+                    .is_some_and(|now| same_value(value, now))
+            });
+        if same {
+            report.unchanged += 1;
+            continue;
+        }
+
+        let change = Change {
+            line,
+            input: serde_json::to_string(&record.input).unwrap_or_default(),
+            recorded: show(&recorded),
+            replayed: show(&evaluation.outputs),
+            rule_id: evaluation.rule_id.clone(),
+        };
+        let rule = evaluation.rule_id.as_deref().unwrap_or("default");
+        if accepted.iter().any(|a| a == rule) {
+            report.accepted.push(change);
+        } else {
+            report.regressions.push(change);
+        }
+    }
+    report
+}
+
+fn input_type<'a>(spec: &'a Spec, name: &str) -> Option<&'a VarType> {
+    spec.inputs
+        .iter()
+        .find(|v| v.name == name || v.aliases.iter().any(|a| a == name))
+        .map(|v| &v.typ)
+}
+
+/// A recorded value as CEL, with whole numbers recorded for float
+/// fields read back as floats (JSON does not tell `500` from `500.0`)
+fn typed(value: &ConditionValue, typ: Option<&VarType>) -> CelValue {
+    match (value, typ) {
+        (ConditionValue::Int(i), Some(VarType::Float)) => (*i as f64).into(),
+        (value, _) => to_cel_value(value),
+    }
+}
+
+/// The recorded output by output name
+fn recorded_outputs(spec: &Spec, output: &ConditionValue) -> Values {
+    let output_type = |name: &str| spec.outputs.iter().find(|o| o.name == name).map(|o| &o.typ);
+    match output {
+        ConditionValue::Map(fields) if spec.outputs.len() > 1 => fields
+            .iter()
+            .map(|(name, value)| (name.clone(), typed(value, output_type(name))))
+            .collect(),
+        value => {
+            let name = spec
+                .outputs
+                .first()
+                .map(|o| o.name.clone())
+                .unwrap_or_else(|| "result".to_string());
+            let typ = output_type(&name);
+            HashMap::from([(name, typed(value, typ))])
+        }
+    }
+}
+
+fn same_value(recorded: &CelValue, now: &CelValue) -> bool {
+    match (recorded, now) {
+        (CelValue::Float(a), CelValue::Float(b)) => (a - b).abs() <= 1e-9 * a.abs().max(1.0),
+        (CelValue::Int(a), CelValue::Float(b)) | (CelValue::Float(b), CelValue::Int(a)) => {
+            (*a as f64 - b).abs() <= 1e-9 * b.abs().max(1.0)
+        }
+        (a, b) => a == b,
+    }
+}
+
+/// Outputs as `value` for one, `name=value, ...` (sorted) for several
+fn show(outputs: &Values) -> String {
+    if outputs.len() == 1 {
+        return outputs.values().map(literal).collect();
+    }
+    let sorted: BTreeMap<_, _> = outputs.iter().collect();
+    sorted
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, literal(value)))
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic' && weight_kg <= 5.0"
+    then: 5.0
+  - id: R2
+    when: "zone == 'domestic'"
+    then: 9.5
+  - id: R3
+    when: "zone == 'eu'"
+    then: 12.0
+default: 20.0
+"#;
+
+    const RECORDING: &str = r#"{"spec": "shipping_rate", "hash": "sha256:old", "input": {"zone": "domestic", "weight_kg": 3}, "output": 5}
+{"spec": "shipping_rate", "hash": "sha256:old", "input": {"zone": "domestic", "weight_kg": 8.5}, "output": 9.5}
+{"spec": "shipping_rate", "hash": "sha256:old", "input": {"zone": "eu", "weight_kg": 2}, "output": 11.0}
+
+{"spec": "tax", "input": {"zone": "eu"}, "output": 0.2}
+{"input": {"zone": "world", "weight_kg": 1}, "output": 18.0}
+not json
+"#;
+
+    #[test]
+    fn test_replay_reports_changes() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let report = replay(&spec, RECORDING, &[]);
+        assert_eq!(report.replayed, 4);
+        assert_eq!(report.unchanged, 2);
+        assert_eq!(report.skipped, 1);
+        assert_eq!(report.recorded_hashes["sha256:old"], 3);
+        assert_eq!(report.recorded_hashes["unknown"], 1);
+
+        let lines: Vec<usize> = report.regressions.iter().map(|c| c.line).collect();
+        assert_eq!(lines, vec![3, 6]);
+        let eu = &report.regressions[0];
+        assert_eq!(eu.recorded, "11.0");
+        assert_eq!(eu.replayed, "12.0");
+        assert_eq!(eu.rule_id.as_deref(), Some("R3"));
+        assert_eq!(report.failures.len(), 1);
+        assert_eq!(report.failures[0].line, 7);
+        assert!(!report.passed());
+
+        let text = report.to_report(10);
+        assert!(text.contains("2 unchanged, 2 regression(s)"));
+        assert!(
+            text.contains("line 3: {\"weight_kg\":2,\"zone\":\"eu\"} recorded 11.0, now 12.0 (R3)")
+        );
+    }
+
+    #[test]
+    fn test_accepted_rules_are_not_regressions() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let recording: String = RECORDING.lines().take(6).collect::<Vec<_>>().join("\n");
+        let report = replay(
+            &spec,
+            &recording,
+            &["R3".to_string(), "default".to_string()],
+        );
+        assert!(report.regressions.is_empty());
+        assert_eq!(report.accepted.len(), 2);
+        assert!(report.passed());
+    }
+
+    #[test]
+    fn test_audited_decisions_replay() {
+        #[derive(Clone, Default)]
+        struct Shared(std::sync::Arc<Mutex<Vec<u8>>>);
+        impl Write for Shared {
+            fn write(&mut self, buf: &[u8]) -> std::io::Result<usize> {
+                self.0.lock().unwrap().write(buf)
+            }
+            fn flush(&mut self) -> std::io::Result<()> {
+                Ok(())
+            }
+        }
+
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let recording = Shared::default();
+        let engine = crate::Engine::new(spec.clone()).with_audit(jsonl_sink(recording.clone()));
+        for (zone, weight_kg) in [("domestic", 3.0), ("domestic", 7.0), ("world", 1.0)] {
+            let input = HashMap::from([
+                ("zone".to_string(), zone.into()),
+                ("weight_kg".to_string(), weight_kg.into()),
+            ]);
+            engine.evaluate(&input).unwrap();
+        }
+
+        let jsonl = String::from_utf8(recording.0.lock().unwrap().clone()).unwrap();
+        let recorded: Vec<Record> = records(&jsonl).map(|(_, r)| r.unwrap()).collect();
+        assert_eq!(recorded.len(), 3);
+        assert_eq!(recorded[1].rule_id.as_deref(), Some("R2"));
+        assert_eq!(recorded[2].rule_id, None);
+        assert_eq!(recorded[0].hash.as_deref(), Some(spec.hash().as_str()));
+        assert!(recorded[0].at.is_some());
+
+        let report = replay(&spec, &jsonl, &[]);
+        assert_eq!(report.unchanged, 3);
+        assert!(report.passed());
+    }
+
+    #[test]
+    fn test_records_redact_personal_values() {
+        let yaml = SPEC
+            .replace(
+                "  - name: zone\n    type: string\n",
+                "  - name: zone\n    type: string\n    redact: hash\n",
+            )
+            .replace(
+                "  - name: weight_kg\n    type: float\n",
+                "  - name: weight_kg\n    type: float\n    pii: true\n",
+            );
+        let spec = Spec::from_yaml(&yaml).unwrap();
+        let input = HashMap::from([
+            ("zone".to_string(), "domestic".into()),
+            ("weight_kg".to_string(), 3.0.into()),
+        ]);
+        let evaluation = evaluate(&spec, &input).unwrap();
+        let record = Record::new(&spec, &spec.hash(), &input, &evaluation);
+        assert_eq!(
+            record.input["weight_kg"],
+            ConditionValue::String("***".into())
+        );
+        // Hashed with IMACS_REDACT_KEY when set, masked otherwise
+        let zone = record.input["zone"].to_string();
+        assert!(zone == "\"***\"" || zone.starts_with("\"hmac-sha256:"));
+        assert!(!zone.contains("domestic"));
+        assert_eq!(record.output, ConditionValue::Float(5.0));
+        assert_eq!(record.rule_id.as_deref(), Some("R1"));
+    }
+}
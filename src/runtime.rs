@@ -0,0 +1,968 @@
+//! Runtime evaluation of specs
+//!
+//! Evaluates a spec's rules directly against input values, without
+//! generating code. [`Engine`] memoizes results for specs that declare a
+//! `cache` and evaluates batches of inputs in parallel, and can hand every
+//! decision to an audit sink; given [`Limits`], it refuses specs and inputs
+//! too large to evaluate safely. [`Shadow`] runs a candidate rule set next to the current one and
+//! reports the inputs on which they disagree, so new rules can be tried on
+//! live traffic before cutover.
+
+use crate::cel::{CelCompiler, CelValue, Tables};
+use crate::error::{Error, Result};
+use crate::spec::{CacheConfig, ConditionValue, CustomFunction, Output, RuleState, Spec};
+use std::collections::{BTreeMap, HashMap};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::time::{Duration, Instant};
+
+/// Input or output values by name
+pub type Values = HashMap<String, CelValue>;
+
+/// Result of evaluating a spec against one input
+#[derive(Debug, Clone, PartialEq)]
+pub struct Evaluation {
+    /// Rule that matched, or `None` when the default applied
+    pub rule_id: Option<String>,
+    /// Output values by name (a single output is keyed by the declared
+    /// output name, or `result` when the spec declares none)
+    pub outputs: Values,
+}
+
+/// Evaluate a spec against an input
+///
+/// Omitted inputs take their declared default and string inputs are
+/// normalized as the spec declares. Rules are tried in evaluation order; the
+/// first match wins. Only active rules take part, as in generated code.
+pub fn evaluate(spec: &Spec, input: &Values) -> Result<Evaluation> {
+    evaluate_rules(spec, &table_rows(spec), input, false)
+}
+
+/// [`evaluate`] with draft rules taking part, to simulate them before
+/// activation (`imacs tui`, `imacs sensitivity`, [`Shadow`] candidates)
+pub fn evaluate_with_drafts(spec: &Spec, input: &Values) -> Result<Evaluation> {
+    evaluate_rules(spec, &table_rows(spec), input, true)
+}
+
+/// Evaluate with the spec's table rows built beforehand (see [`table_rows`])
+fn evaluate_rules(
+    spec: &Spec,
+    tables: &Arc<Tables>,
+    input: &Values,
+    drafts: bool,
+) -> Result<Evaluation> {
+    let scope = canonical_input(spec, input);
+    let rules = spec.evaluation_order();
+    let takes_part = |state: RuleState| state.is_active() || (drafts && state == RuleState::Draft);
+    for rule in rules.into_iter().filter(|r| takes_part(r.state)) {
+        if let Some(cel) = rule.as_cel() {
+            if !CelCompiler::eval_bool_with_tables(&cel, &scope, tables)? {
+                continue;
+            }
+        }
+        let mut locals = scope.clone();
+        for binding in &rule.vars {
+            let value = CelCompiler::eval_with_tables(&binding.expr, &locals, tables)?;
+            locals.insert(binding.name.clone(), value);
+        }
+        return Ok(Evaluation {
+            rule_id: Some(rule.id.clone()),
+            outputs: output_values(spec, &rule.then, &locals, tables)?,
+        });
+    }
+
+    match &spec.default {
+        Some(default) => Ok(Evaluation {
+            rule_id: None,
+            outputs: output_values(spec, default, &scope, tables)?,
+        }),
+        None => Err(Error::CelEval(format!("{}: no rule matched", spec.id))),
+    }
+}
+
+/// Receives a record of every decision an [`Engine`] makes
+pub type AuditSink = Box<dyn Fn(&crate::replay::Record) + Send + Sync>;
+
+/// Evaluates one spec, memoizing results when the spec declares a `cache`
+pub struct Engine {
+    spec: Spec,
+    /// Rows of the spec's tables, built once for every evaluation
+    tables: Arc<Tables>,
+    cache: Option<Mutex<ResultCache>>,
+    /// Sink and the spec hash stamped into its records
+    audit: Option<(AuditSink, String)>,
+    limits: Option<Limits>,
+    stats: Counters,
+}
+
+/// Counts of what an [`Engine`] has done since it was created
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub struct Stats {
+    /// Calls to [`Engine::evaluate`], failed ones included
+    pub evaluations: u64,
+    /// Evaluations that returned an error
+    pub errors: u64,
+    /// Evaluations served from the cache
+    pub cache_hits: u64,
+}
+
+#[derive(Default)]
+struct Counters {
+    evaluations: AtomicU64,
+    errors: AtomicU64,
+    cache_hits: AtomicU64,
+}
+
+impl Engine {
+    pub fn new(spec: Spec) -> Self {
+        let cache = spec
+            .cache
+            .map(|config| Mutex::new(ResultCache::new(config)));
+        Self {
+            tables: table_rows(&spec),
+            spec,
+            cache,
+            audit: None,
+            limits: None,
+            stats: Counters::default(),
+        }
+    }
+
+    /// Evaluate within `limits`, for specs from authors the host does not
+    /// trust; errors when the spec's expressions exceed them, and
+    /// [`Engine::evaluate`] errors on inputs that do
+    pub fn with_limits(mut self, limits: Limits) -> Result<Self> {
+        limits.check_spec(&self.spec)?;
+        self.limits = Some(limits);
+        Ok(self)
+    }
+
+    /// Send a record of each successful decision (cached ones included) to
+    /// `sink`, in the format `imacs replay` reads; see
+    /// [`crate::replay::jsonl_sink`]
+    pub fn with_audit(
+        mut self,
+        sink: impl Fn(&crate::replay::Record) + Send + Sync + 'static,
+    ) -> Self {
+        let hash = self.spec.hash();
+        self.audit = Some((Box::new(sink), hash));
+        self
+    }
+
+    /// Engine for a spec pulled from an OCI registry reference such as
+    /// `ghcr.io/acme/rules/shipping_rate:1.2.0` (see [`crate::oci`])
+    pub fn from_oci(reference: &str) -> Result<Self> {
+        Ok(Self::new(crate::oci::pull(reference)?))
+    }
+
+    pub fn spec(&self) -> &Spec {
+        &self.spec
+    }
+
+    pub fn stats(&self) -> Stats {
+        Stats {
+            evaluations: self.stats.evaluations.load(Ordering::Relaxed),
+            errors: self.stats.errors.load(Ordering::Relaxed),
+            cache_hits: self.stats.cache_hits.load(Ordering::Relaxed),
+        }
+    }
+
+    /// Evaluate an input, serving repeated inputs from the cache
+    ///
+    /// Inputs are canonicalized (defaults applied, normalized, names sorted)
+    /// before lookup, so an omitted input and its explicit default share an
+    /// entry.
+    /// Errors are not cached.
+    pub fn evaluate(&self, input: &Values) -> Result<Evaluation> {
+        self.stats.evaluations.fetch_add(1, Ordering::Relaxed);
+        let result = self.decide(input).inspect_err(|_| {
+            self.stats.errors.fetch_add(1, Ordering::Relaxed);
+        })?;
+        if let Some((sink, hash)) = &self.audit {
+            sink(&crate::replay::Record::new(
+                &self.spec, hash, input, &result,
+            ));
+        }
+        Ok(result)
+    }
+
+    fn decide(&self, input: &Values) -> Result<Evaluation> {
+        if let Some(limits) = &self.limits {
+            limits.check_input(input)?;
+        }
+        // Refuse inputs the generated Validate() would reject
+        let violations = self
+            .spec
+            .constraint_violations(&canonical_input(&self.spec, input))?;
+        if !violations.is_empty() {
+            return Err(Error::CelEval(format!(
+                "{}: {}",
+                self.spec.id,
+                violations.join("; ")
+            )));
+        }
+        let cache = match &self.cache {
+            Some(cache) => cache,
+            None => return evaluate_rules(&self.spec, &self.tables, input, false),
+        };
+        let key = canonical_key(&canonical_input(&self.spec, input));
+        if let Some(hit) = cache.lock().unwrap().get(&key) {
+            self.stats.cache_hits.fetch_add(1, Ordering::Relaxed);
+            return Ok(hit);
+        }
+        let result = evaluate_rules(&self.spec, &self.tables, input, false)?;
+        cache.lock().unwrap().insert(key, result.clone());
+        Ok(result)
+    }
+
+    /// Evaluate many inputs across worker threads
+    ///
+    /// Inputs are split into one contiguous chunk per available core;
+    /// `results[i]` belongs to `inputs[i]`.
+    pub fn evaluate_batch(&self, inputs: &[Values]) -> Vec<Result<Evaluation>> {
+        let workers = std::thread::available_parallelism().map_or(1, |n| n.get());
+        let chunk = inputs.len().div_ceil(workers).max(1);
+        std::thread::scope(|scope| {
+            let handles: Vec<_> = inputs
+                .chunks(chunk)
+                .map(|chunk| {
+                    scope.spawn(move || {
+                        chunk
+                            .iter()
+                            .map(|input| self.evaluate(input))
+                            .collect::<Vec<_>>()
+                    })
+                })
+                .collect();
+            handles
+                .into_iter()
+                .flat_map(|handle| handle.join().expect("batch worker panicked"))
+                .collect()
+        })
+    }
+}
+
+/// Bounds on the work one evaluation may do
+///
+/// Expressions cannot recurse or call anything but the functions in
+/// [`crate::cel::FUNCTIONS`] and the spec's own, so their cost follows
+/// from their size, their
+/// nesting and the lists they loop over. The defaults admit any spec a
+/// person would write by hand.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Limits {
+    /// Longest expression, in bytes
+    pub max_expr_len: usize,
+    /// Deepest nesting of an expression, in syntax tree levels
+    pub max_depth: usize,
+    /// Most `map`/`filter`/`all`/`exists` loops inside one another
+    pub max_loop_nesting: usize,
+    /// Most items of a list or map input, at any depth
+    pub max_list_len: usize,
+}
+
+impl Default for Limits {
+    fn default() -> Self {
+        Self {
+            max_expr_len: 4096,
+            max_depth: 32,
+            max_loop_nesting: 2,
+            max_list_len: 1000,
+        }
+    }
+}
+
+impl Limits {
+    /// Check every expression of a spec
+    pub fn check_spec(&self, spec: &Spec) -> Result<()> {
+        for expr in spec.expressions() {
+            self.check_expr(&expr, &spec.functions)
+                .map_err(|reason| Error::CelEval(format!("{}: `{}` {}", spec.id, expr, reason)))?;
+        }
+        Ok(())
+    }
+
+    fn check_expr(
+        &self,
+        expr: &str,
+        functions: &[CustomFunction],
+    ) -> std::result::Result<(), String> {
+        if expr.len() > self.max_expr_len {
+            return Err(format!(
+                "is {} bytes long, limit is {}",
+                expr.len(),
+                self.max_expr_len
+            ));
+        }
+        let shape = CelCompiler::shape(expr).map_err(|e| e.to_string())?;
+        if shape.depth > self.max_depth {
+            return Err(format!(
+                "nests {} levels deep, limit is {}",
+                shape.depth, self.max_depth
+            ));
+        }
+        if shape.loops > self.max_loop_nesting {
+            return Err(format!(
+                "nests {} loops, limit is {}",
+                shape.loops, self.max_loop_nesting
+            ));
+        }
+        if let Some(call) = shape.calls.iter().find(|call| {
+            !crate::cel::FUNCTIONS.contains(&call.as_str())
+                && !functions.iter().any(|f| &f.name == *call)
+        }) {
+            return Err(format!("calls {}(), which is not allowed", call));
+        }
+        Ok(())
+    }
+
+    /// Check the sizes of an input's lists and maps
+    pub fn check_input(&self, input: &Values) -> Result<()> {
+        for (name, value) in input {
+            if let Some(len) = self.oversized(value) {
+                return Err(Error::CelEval(format!(
+                    "input '{}' holds {} items, limit is {}",
+                    name, len, self.max_list_len
+                )));
+            }
+        }
+        Ok(())
+    }
+
+    /// Length of the first list or map in `value` over the limit
+    fn oversized(&self, value: &CelValue) -> Option<usize> {
+        let items: Vec<&CelValue> = match value {
+            CelValue::List(items) => items.iter().collect(),
+            CelValue::Map(map) => map.map.values().collect(),
+            _ => return None,
+        };
+        if items.len() > self.max_list_len {
+            return Some(items.len());
+        }
+        items.into_iter().find_map(|item| self.oversized(item))
+    }
+}
+
+/// LRU cache of evaluations keyed by canonical input
+struct ResultCache {
+    config: CacheConfig,
+    /// Result, insertion time and recency stamp by key
+    entries: HashMap<String, (Evaluation, Instant, u64)>,
+    /// Keys by recency stamp, least recently used first
+    recency: BTreeMap<u64, String>,
+    next_stamp: u64,
+}
+
+impl ResultCache {
+    fn new(config: CacheConfig) -> Self {
+        Self {
+            config,
+            entries: HashMap::new(),
+            recency: BTreeMap::new(),
+            next_stamp: 0,
+        }
+    }
+
+    fn get(&mut self, key: &str) -> Option<Evaluation> {
+        let ttl = self.config.ttl_secs.map(Duration::from_secs);
+        let (_, inserted, stamp) = self.entries.get(key)?;
+        let stamp = *stamp;
+        if ttl.is_some_and(|ttl| inserted.elapsed() >= ttl) {
+            self.entries.remove(key);
+            self.recency.remove(&stamp);
+            return None;
+        }
+        self.recency.remove(&stamp);
+        let stamp = self.bump(key);
+        let entry = self.entries.get_mut(key)?;
+        entry.2 = stamp;
+        Some(entry.0.clone())
+    }
+
+    fn insert(&mut self, key: String, result: Evaluation) {
+        if let Some((_, _, stamp)) = self.entries.remove(&key) {
+            self.recency.remove(&stamp);
+        }
+        let stamp = self.bump(&key);
+        self.entries.insert(key, (result, Instant::now(), stamp));
+        while self.entries.len() > self.config.size.max(1) {
+            match self.recency.pop_first() {
+                Some((_, oldest)) => self.entries.remove(&oldest),
+                None => break,
+            };
+        }
+    }
+
+    /// Record `key` as the most recently used
+    fn bump(&mut self, key: &str) -> u64 {
+        let stamp = self.next_stamp;
+        self.next_stamp += 1;
+        self.recency.insert(stamp, key.to_string());
+        stamp
+    }
+}
+
+/// A candidate result that differs from the primary one
+#[derive(Debug, Clone)]
+pub struct Divergence {
+    /// Input both rule sets were evaluated against
+    pub input: Values,
+    /// Result that was returned to the caller
+    pub primary: Evaluation,
+    /// Candidate result, or the error the candidate raised
+    pub candidate: std::result::Result<Evaluation, String>,
+}
+
+/// Evaluates a primary and a candidate spec, serving the primary result
+pub struct Shadow {
+    primary: Spec,
+    candidate: Spec,
+    /// Table rows of the primary and the candidate
+    tables: (Arc<Tables>, Arc<Tables>),
+    on_divergence: Box<dyn Fn(&Divergence) + Send + Sync>,
+}
+
+impl Shadow {
+    /// Shadow `primary` with `candidate`, calling `on_divergence` whenever
+    /// their outputs differ
+    pub fn new(
+        primary: Spec,
+        candidate: Spec,
+        on_divergence: impl Fn(&Divergence) + Send + Sync + 'static,
+    ) -> Self {
+        Self {
+            tables: (table_rows(&primary), table_rows(&candidate)),
+            primary,
+            candidate,
+            on_divergence: Box::new(on_divergence),
+        }
+    }
+
+    /// Evaluate both specs and return the primary result
+    ///
+    /// Only outputs are compared: a different rule producing the same
+    /// result is not a divergence. Candidate errors are reported, never
+    /// returned.
+    pub fn evaluate(&self, input: &Values) -> Result<Evaluation> {
+        let primary = evaluate_rules(&self.primary, &self.tables.0, input, false)?;
+        let candidate =
+            evaluate_rules(&self.candidate, &self.tables.1, input, true).map_err(|e| e.to_string());
+        let diverged = match &candidate {
+            Ok(candidate) => candidate.outputs != primary.outputs,
+            Err(_) => true,
+        };
+        if diverged {
+            (self.on_divergence)(&Divergence {
+                input: input.clone(),
+                primary: primary.clone(),
+                candidate,
+            });
+        }
+        Ok(primary)
+    }
+}
+
+/// The input with legacy alias keys renamed, declared defaults filled in for
+/// omitted values and the spec's normalizations applied
+pub(crate) fn canonical_input(spec: &Spec, input: &Values) -> Values {
+    let mut scope = input.clone();
+    for var in &spec.inputs {
+        for alias in &var.aliases {
+            if let Some(value) = scope.remove(alias) {
+                scope.entry(var.name.clone()).or_insert(value);
+            }
+        }
+    }
+    for var in &spec.inputs {
+        if let Some(default) = &var.default {
+            scope
+                .entry(var.name.clone())
+                .or_insert_with(|| to_cel_value(default));
+        }
+    }
+    for normalization in &spec.normalize {
+        if let Some(CelValue::String(value)) = scope.get(&normalization.input) {
+            let normalized = normalization.apply(value);
+            scope.insert(normalization.input.clone(), normalized.into());
+        }
+    }
+    scope
+}
+
+/// Rows of the spec's lookup tables, as `lookup()` reads them, and of the
+/// tables behind `zone()`
+fn table_rows(spec: &Spec) -> Arc<Tables> {
+    Arc::new(
+        spec.tables
+            .iter()
+            .chain(&spec.geo_tables())
+            .map(|table| {
+                let rows = table
+                    .rows
+                    .iter()
+                    .map(|(key, value)| (key.clone(), to_cel_value(value)))
+                    .collect();
+                (table.name.clone(), rows)
+            })
+            .collect(),
+    )
+}
+
+/// Order-independent cache key for an input: names, and the keys of map
+/// values at any depth, are sorted
+fn canonical_key(input: &Values) -> String {
+    let sorted: BTreeMap<_, _> = input.iter().collect();
+    sorted
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, canonical_value(value)))
+        .collect::<Vec<_>>()
+        .join(";")
+}
+
+fn canonical_value(value: &CelValue) -> String {
+    match value {
+        CelValue::List(items) => format!(
+            "[{}]",
+            items
+                .iter()
+                .map(canonical_value)
+                .collect::<Vec<_>>()
+                .join(",")
+        ),
+        CelValue::Map(map) => {
+            let sorted: BTreeMap<String, String> = map
+                .map
+                .iter()
+                .map(|(key, value)| (format!("{:?}", key), canonical_value(value)))
+                .collect();
+            format!(
+                "{{{}}}",
+                sorted
+                    .iter()
+                    .map(|(key, value)| format!("{}:{}", key, value))
+                    .collect::<Vec<_>>()
+                    .join(",")
+            )
+        }
+        other => format!("{:?}", other),
+    }
+}
+
+/// Evaluate a rule's (or the default's) output values
+fn output_values(
+    spec: &Spec,
+    output: &Output,
+    scope: &Values,
+    tables: &Arc<Tables>,
+) -> Result<Values> {
+    match output {
+        Output::Single(ConditionValue::Map(map)) | Output::Named(map) => map
+            .iter()
+            .map(|(name, value)| Ok((name.clone(), output_value(value, scope, tables)?)))
+            .collect(),
+        Output::Single(value) => {
+            let name = spec
+                .outputs
+                .first()
+                .map(|o| o.name.clone())
+                .unwrap_or_else(|| "result".to_string());
+            Ok(HashMap::from([(name, output_value(value, scope, tables)?)]))
+        }
+    }
+}
+
+/// Strings naming a variable or written as an expression are evaluated;
+/// everything else is a literal
+fn output_value(value: &ConditionValue, scope: &Values, tables: &Arc<Tables>) -> Result<CelValue> {
+    match value {
+        ConditionValue::String(s) if scope.contains_key(s) || crate::render::is_expression(s) => {
+            CelCompiler::eval_with_tables(s, scope, tables)
+        }
+        other => Ok(to_cel_value(other)),
+    }
+}
+
+/// Convert a spec literal to a CEL value
+pub fn to_cel_value(value: &ConditionValue) -> CelValue {
+    match value {
+        ConditionValue::Bool(b) => (*b).into(),
+        ConditionValue::Int(i) => (*i).into(),
+        ConditionValue::Float(f) => (*f).into(),
+        ConditionValue::String(s) => s.clone().into(),
+        ConditionValue::List(items) => items.iter().map(to_cel_value).collect::<Vec<_>>().into(),
+        ConditionValue::Map(map) => map
+            .iter()
+            .map(|(k, v)| (k.clone(), to_cel_value(v)))
+            .collect::<HashMap<_, _>>()
+            .into(),
+        ConditionValue::Null => CelValue::Null,
+    }
+}
+
+/// Convert a CEL value back to a spec literal; values without a literal
+/// form (maps, timestamps, ...) become their debug text
+pub fn from_cel_value(value: &CelValue) -> ConditionValue {
+    match value {
+        CelValue::Bool(b) => ConditionValue::Bool(*b),
+        CelValue::Int(i) => ConditionValue::Int(*i),
+        CelValue::UInt(u) => ConditionValue::Int(*u as i64),
+        CelValue::Float(f) => ConditionValue::Float(*f),
+        CelValue::String(s) => ConditionValue::String(s.to_string()),
+        CelValue::List(items) => ConditionValue::List(items.iter().map(from_cel_value).collect()),
+        CelValue::Null => ConditionValue::Null,
+        other => ConditionValue::String(format!("{:?}", other)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::{Arc, Mutex};
+
+    const CURRENT: &str = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: weight_kg
+    type: float
+    default: 1.0
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: "weight_kg * 2.0"
+default: 10.0
+"#;
+
+    fn input(zone: &str, weight_kg: f64) -> Values {
+        HashMap::from([
+            ("zone".to_string(), zone.into()),
+            ("weight_kg".to_string(), weight_kg.into()),
+        ])
+    }
+
+    #[test]
+    fn test_evaluate() {
+        let spec = Spec::from_yaml(CURRENT).unwrap();
+
+        let result = evaluate(&spec, &input("domestic", 3.0)).unwrap();
+        assert_eq!(result.rule_id.as_deref(), Some("R1"));
+        assert_eq!(result.outputs["rate"], CelValue::Float(6.0));
+
+        let result = evaluate(&spec, &input("international", 3.0)).unwrap();
+        assert_eq!(result.rule_id, None);
+        assert_eq!(result.outputs["rate"], CelValue::Float(10.0));
+
+        // weight_kg falls back to its declared default
+        let omitted = HashMap::from([("zone".to_string(), "domestic".into())]);
+        let result = evaluate(&spec, &omitted).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(2.0));
+    }
+
+    #[test]
+    fn test_evaluate_rule_states() {
+        let spec = Spec::from_yaml(&CURRENT.replace(
+            "rules:\n",
+            "rules:\n  - id: R0\n    when: \"weight_kg > 100.0\"\n    then: 0.0\n    state: retired\n  - id: R9\n    when: \"zone == 'island'\"\n    then: 50.0\n    state: draft\n",
+        ))
+        .unwrap();
+
+        // Drafts only take part when simulated; retired rules never do
+        let result = evaluate(&spec, &input("island", 1.0)).unwrap();
+        assert_eq!(result.rule_id, None);
+        let result = evaluate_with_drafts(&spec, &input("island", 1.0)).unwrap();
+        assert_eq!(result.rule_id.as_deref(), Some("R9"));
+        let result = evaluate_with_drafts(&spec, &input("domestic", 200.0)).unwrap();
+        assert_eq!(result.rule_id.as_deref(), Some("R1"));
+    }
+
+    #[test]
+    fn test_evaluate_normalized_input() {
+        let spec = Spec::from_yaml(&format!(
+            "{}normalize:\n  - input: zone\n    trim: true\n    lowercase: true\n",
+            CURRENT
+        ))
+        .unwrap();
+
+        let result = evaluate(&spec, &input(" Domestic ", 3.0)).unwrap();
+        assert_eq!(result.rule_id.as_deref(), Some("R1"));
+        assert_eq!(result.outputs["rate"], CelValue::Float(6.0));
+    }
+
+    #[test]
+    fn test_evaluate_aliased_input() {
+        let spec = Spec::from_yaml(&CURRENT.replace(
+            "    default: 1.0\n",
+            "    default: 1.0\n    aliases: [weightKg]\n",
+        ))
+        .unwrap();
+
+        let legacy = HashMap::from([
+            ("zone".to_string(), "domestic".into()),
+            ("weightKg".to_string(), 4.0_f64.into()),
+        ]);
+        let result = evaluate(&spec, &legacy).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(8.0));
+
+        // The canonical name wins when both are present
+        let mut both = input("domestic", 3.0);
+        both.insert("weightKg".to_string(), 4.0_f64.into());
+        let result = evaluate(&spec, &both).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(6.0));
+    }
+
+    #[test]
+    fn test_evaluate_lookup_table() {
+        let mut spec = Spec::from_yaml(
+            &CURRENT
+                .replace(
+                    "rules:\n",
+                    "tables:\n  - name: zone_rates\n    file: zone_rates.csv\n    type: float\nrules:\n",
+                )
+                .replace(
+                    "default: 10.0\n",
+                    "default: \"lookup('zone_rates', zone, 10.0) * weight_kg\"\n",
+                ),
+        )
+        .unwrap();
+        spec.tables[0].rows = BTreeMap::from([("eu".to_string(), ConditionValue::Float(4.5))]);
+
+        let result = evaluate(&spec, &input("eu", 2.0)).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(9.0));
+        let result = evaluate(&spec, &input("mars", 2.0)).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(20.0));
+
+        // Engines build the rows once, when created
+        let engine = Engine::new(spec);
+        for _ in 0..2 {
+            let result = engine.evaluate(&input("eu", 2.0)).unwrap();
+            assert_eq!(result.outputs["rate"], CelValue::Float(9.0));
+        }
+    }
+
+    #[test]
+    fn test_evaluate_geo_helpers() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_zone
+inputs:
+  - name: country
+    type: string
+outputs:
+  - name: rate
+    type: float
+zones:
+  - name: domestic
+    countries: [US]
+  - name: europe
+    countries: [eu, CH, NO]
+rules:
+  - id: R1
+    when: "zone(country) == 'domestic'"
+    then: 5.0
+  - id: R2
+    when: "zone(country) == 'europe'"
+    then: 12.0
+  - id: R3
+    when: "region(country) == 'asia'"
+    then: 20.0
+default: 30.0
+"#,
+        )
+        .unwrap();
+        assert!(spec.validate().is_empty());
+
+        let rate = |country: &str| {
+            let input = HashMap::from([("country".to_string(), country.into())]);
+            evaluate(&spec, &input).unwrap().outputs["rate"].clone()
+        };
+        assert_eq!(rate("US"), CelValue::Float(5.0));
+        assert_eq!(rate("DE"), CelValue::Float(12.0));
+        assert_eq!(rate("NO"), CelValue::Float(12.0));
+        assert_eq!(rate("JP"), CelValue::Float(20.0));
+        assert_eq!(rate("BR"), CelValue::Float(30.0));
+    }
+
+    #[test]
+    fn test_engine_cache() {
+        let spec = Spec::from_yaml(&format!("{}cache:\n  size: 2\n", CURRENT)).unwrap();
+        let engine = Engine::new(spec);
+
+        let first = engine.evaluate(&input("domestic", 1.0)).unwrap();
+        // Explicit default and omitted input share one entry
+        let omitted = HashMap::from([("zone".to_string(), "domestic".into())]);
+        assert_eq!(engine.evaluate(&omitted).unwrap(), first);
+
+        engine.evaluate(&input("domestic", 2.0)).unwrap();
+        engine.evaluate(&input("domestic", 3.0)).unwrap();
+        let cache = engine.cache.as_ref().unwrap().lock().unwrap();
+        assert_eq!(cache.entries.len(), 2);
+        // weight 1.0 was least recently used and has been evicted
+        let evicted = canonical_key(&canonical_input(engine.spec(), &omitted));
+        assert!(!cache.entries.contains_key(&evicted));
+        drop(cache);
+        assert_eq!(
+            engine.stats(),
+            Stats {
+                evaluations: 4,
+                errors: 0,
+                cache_hits: 1
+            }
+        );
+    }
+
+    #[test]
+    fn test_canonical_key_sorts_nested_maps() {
+        let names = [
+            "fuel",
+            "toll",
+            "handling",
+            "customs",
+            "insurance",
+            "storage",
+        ];
+        let forward: HashMap<String, CelValue> = names
+            .iter()
+            .enumerate()
+            .map(|(i, name)| (name.to_string(), (i as f64).into()))
+            .collect();
+        let backward: HashMap<String, CelValue> = names
+            .iter()
+            .enumerate()
+            .rev()
+            .map(|(i, name)| (name.to_string(), (i as f64).into()))
+            .collect();
+        let nested = |surcharges: HashMap<String, CelValue>| {
+            HashMap::from([
+                ("zone".to_string(), "domestic".into()),
+                (
+                    "surcharges".to_string(),
+                    HashMap::from([("by_kind".to_string(), CelValue::from(surcharges))]).into(),
+                ),
+            ])
+        };
+        assert_eq!(
+            canonical_key(&nested(forward.clone())),
+            canonical_key(&nested(backward))
+        );
+        let mut changed = forward.clone();
+        changed.insert("fuel".to_string(), 9.0.into());
+        assert_ne!(
+            canonical_key(&nested(changed)),
+            canonical_key(&nested(forward))
+        );
+    }
+
+    #[test]
+    fn test_engine_evaluate_batch() {
+        let engine = Engine::new(Spec::from_yaml(CURRENT).unwrap());
+        let inputs: Vec<Values> = (0..100)
+            .map(|i| input(if i % 2 == 0 { "domestic" } else { "other" }, i as f64))
+            .collect();
+
+        let results = engine.evaluate_batch(&inputs);
+        assert_eq!(results.len(), 100);
+        for (i, result) in results.iter().enumerate() {
+            let expected = if i % 2 == 0 { i as f64 * 2.0 } else { 10.0 };
+            assert_eq!(
+                result.as_ref().unwrap().outputs["rate"],
+                CelValue::Float(expected)
+            );
+        }
+        assert!(engine.evaluate_batch(&[]).is_empty());
+    }
+
+    #[test]
+    fn test_engine_constraints() {
+        let spec = Spec::from_yaml(&format!(
+            "{}constraints:\n  - id: C1\n    when: \"zone == 'domestic'\"\n    require: \"weight_kg > 0.0\"\n",
+            CURRENT
+        ))
+        .unwrap();
+        let engine = Engine::new(spec);
+        assert!(engine.evaluate(&input("domestic", 2.0)).is_ok());
+        assert!(engine.evaluate(&input("other", 0.0)).is_ok());
+        let err = engine
+            .evaluate(&input("domestic", 0.0))
+            .unwrap_err()
+            .to_string();
+        assert!(err.contains("shipping_rate: C1: zone == 'domestic' requires weight_kg > 0.0"));
+        assert_eq!(engine.stats().errors, 1);
+    }
+
+    #[test]
+    fn test_engine_limits() {
+        let engine = Engine::new(Spec::from_yaml(CURRENT).unwrap())
+            .with_limits(Limits::default())
+            .unwrap();
+        assert!(engine.evaluate(&input("domestic", 2.0)).is_ok());
+        let mut oversized = input("domestic", 2.0);
+        oversized.insert("tags".into(), vec![CelValue::Int(1); 1001].into());
+        let err = engine.evaluate(&oversized).unwrap_err().to_string();
+        assert!(err.contains("input 'tags' holds 1001 items, limit is 1000"));
+
+        let refused = |then: &str| {
+            let spec = Spec::from_yaml(&CURRENT.replace("\"weight_kg * 2.0\"", then)).unwrap();
+            match Engine::new(spec).with_limits(Limits::default()) {
+                Ok(_) => String::new(),
+                Err(e) => e.to_string(),
+            }
+        };
+        let deep = format!("\"{}weight_kg{}\"", "abs(".repeat(40), ")".repeat(40));
+        assert!(refused(&deep).contains("nests 41 levels deep, limit is 32"));
+        let long = format!("\"{}\"", vec!["weight_kg"; 500].join(" + "));
+        assert!(refused(&long).contains("bytes long, limit is 4096"));
+        let chain = format!("\"{}\"", vec!["weight_kg"; 40].join(" + "));
+        assert!(refused(&chain).contains("nests 40 levels deep, limit is 32"));
+        let loops = "\"[1, 2].map(a, [3].map(b, [4].map(c, c)).size()).size() * 1.0\"";
+        assert!(refused(loops).contains("nests 3 loops, limit is 2"));
+        assert!(refused("\"dimWeight(weight_kg) * 2.0\"").contains("calls dimWeight()"));
+        assert_eq!(refused("\"round(weight_kg) * 2.0\""), "");
+        // Names inside strings are not calls
+        assert_eq!(refused("\"size('now()') * weight_kg\""), "");
+    }
+
+    #[test]
+    fn test_cache_ttl() {
+        let mut cache = ResultCache::new(CacheConfig {
+            size: 10,
+            ttl_secs: Some(0),
+        });
+        let result = Evaluation {
+            rule_id: None,
+            outputs: HashMap::new(),
+        };
+        cache.insert("k".into(), result);
+        assert!(cache.get("k").is_none());
+        assert!(cache.entries.is_empty());
+    }
+
+    #[test]
+    fn test_shadow_reports_divergences() {
+        let primary = Spec::from_yaml(CURRENT).unwrap();
+        let candidate =
+            Spec::from_yaml(&CURRENT.replace("then: \"weight_kg * 2.0\"", "then: 5.0")).unwrap();
+
+        let seen = Arc::new(Mutex::new(Vec::new()));
+        let sink = Arc::clone(&seen);
+        let shadow = Shadow::new(primary, candidate, move |d: &Divergence| {
+            sink.lock().unwrap().push(d.clone());
+        });
+
+        // Same output from both rule sets: nothing reported
+        let result = shadow.evaluate(&input("domestic", 2.5)).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(5.0));
+        assert!(seen.lock().unwrap().is_empty());
+
+        // Candidate differs: the primary result is still served
+        let result = shadow.evaluate(&input("domestic", 4.0)).unwrap();
+        assert_eq!(result.outputs["rate"], CelValue::Float(8.0));
+        let seen = seen.lock().unwrap();
+        assert_eq!(seen.len(), 1);
+        let candidate = seen[0].candidate.as_ref().unwrap();
+        assert_eq!(candidate.outputs["rate"], CelValue::Float(5.0));
+    }
+}
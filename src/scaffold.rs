@@ -0,0 +1,347 @@
+//! Spec and flow skeletons (`imacs new`)
+//!
+//! Fields are given as `name:type` lists, e.g.
+//! `weight_kg:float,zone:enum(domestic,eu)`. Types are `bool`, `int`,
+//! `float` (or `money`), `string`, `enum(a,b)` (values separated by `,` or
+//! `|`), `list(<scalar type>)` and `object`.
+//!
+//! The skeleton has an example rule built from the inputs and comments on
+//! how rules are written; it is checked to parse before it is returned.
+
+use crate::error::{Error, Result};
+use crate::orchestrate::Orchestrator;
+use crate::spec::{Spec, VarType};
+
+/// A field as given on the command line
+pub type Field = (String, VarType);
+
+/// Parse `name:type,name:type` (commas inside `enum(...)` belong to it)
+pub fn parse_fields(list: &str) -> Result<Vec<Field>> {
+    let mut fields = Vec::new();
+    let mut depth = 0;
+    let mut start = 0;
+    for (i, c) in list.char_indices().chain([(list.len(), ',')]) {
+        match c {
+            '(' => depth += 1,
+            ')' => depth -= 1,
+            ',' if depth == 0 => {
+                let part = list[start..i].trim();
+                start = i + 1;
+                if part.is_empty() {
+                    continue;
+                }
+                let (name, typ) = part
+                    .split_once(':')
+                    .ok_or_else(|| Error::Other(format!("'{}': expected name:type", part)))?;
+                let name = name.trim();
+                let valid = name.starts_with(|c: char| c.is_ascii_alphabetic() || c == '_')
+                    && name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_');
+                if !valid {
+                    return Err(Error::Other(format!(
+                        "'{}' is not a valid field name",
+                        name
+                    )));
+                }
+                fields.push((name.to_string(), parse_type(typ.trim())?));
+            }
+            _ => {}
+        }
+    }
+    Ok(fields)
+}
+
+fn parse_type(typ: &str) -> Result<VarType> {
+    let (kind, args) = match typ.split_once(['(', '<']) {
+        Some((kind, rest)) => (kind, Some(rest.trim_end_matches([')', '>']))),
+        None => (typ, None),
+    };
+    let scalar = match (kind, args) {
+        ("bool", None) => Some(VarType::Bool),
+        ("int", None) => Some(VarType::Int),
+        ("float" | "money", None) => Some(VarType::Float),
+        ("string", None) => Some(VarType::String),
+        ("object", None) => Some(VarType::Object),
+        _ => None,
+    };
+    match (kind, args, scalar) {
+        (_, _, Some(scalar)) => Ok(scalar),
+        ("enum", Some(values), _) => {
+            let values: Vec<String> = values
+                .split([',', '|'])
+                .map(|v| v.trim().to_string())
+                .filter(|v| !v.is_empty())
+                .collect();
+            if values.is_empty() {
+                return Err(Error::Other(format!("'{}' has no values", typ)));
+            }
+            Ok(VarType::Enum(values))
+        }
+        ("list", Some(inner), _) => match parse_type(inner)? {
+            inner @ (VarType::Bool | VarType::Int | VarType::Float | VarType::String) => {
+                Ok(VarType::List(Box::new(inner)))
+            }
+            _ => Err(Error::Other(format!(
+                "'{}': lists hold bool, int, float or string",
+                typ
+            ))),
+        },
+        _ => Err(Error::Other(format!(
+            "unknown type '{}' (bool, int, float, string, enum(a,b), list(int), object)",
+            typ
+        ))),
+    }
+}
+
+/// A spec skeleton with one example rule and a default
+pub fn spec_skeleton(id: &str, inputs: &[Field], outputs: &[Field]) -> Result<String> {
+    let default_output = [("result".to_string(), VarType::Int)];
+    let outputs = if outputs.is_empty() {
+        &default_output[..]
+    } else {
+        outputs
+    };
+    let condition: Vec<String> = inputs
+        .iter()
+        .filter_map(|(name, typ)| example_condition(name, typ))
+        .take(2)
+        .collect();
+    let condition = if condition.is_empty() {
+        "true".to_string()
+    } else {
+        condition.join(" && ")
+    };
+
+    let mut yaml = format!(
+        "id: {}\nname: \"{}\"\ndescription: \"TODO: what {} decides\"\n\n",
+        id,
+        title(id),
+        id
+    );
+    yaml.push_str(&fields_yaml("inputs", inputs));
+    yaml.push_str(&fields_yaml("outputs", outputs));
+    yaml.push_str(&format!(
+        "# Rules are tried top to bottom; the first whose `when` holds decides.
+# Conditions are CEL over the inputs, e.g. \"{}\".
+rules:
+  - id: R1
+    when: \"{}\"
+    then:{}
+    description: \"TODO: why this rule applies\"
+
+# Result when no rule matches
+default:{}
+",
+        condition,
+        condition,
+        output_yaml(outputs, true),
+        output_yaml(outputs, false)
+    ));
+
+    Spec::from_yaml(&yaml)?;
+    Ok(yaml)
+}
+
+/// A flow skeleton calling each spec in turn
+///
+/// Specs given with their definition have their inputs wired to flow inputs
+/// of the same name (added to the flow when missing); the others get an
+/// empty mapping to fill in.
+pub fn flow_skeleton(
+    id: &str,
+    inputs: &[Field],
+    outputs: &[Field],
+    specs: &[(String, Option<Spec>)],
+) -> Result<String> {
+    let mut inputs = inputs.to_vec();
+    for spec in specs.iter().filter_map(|(_, spec)| spec.as_ref()) {
+        for var in &spec.inputs {
+            if inputs.iter().all(|(name, _)| *name != var.name) {
+                inputs.push((var.name.clone(), var.typ.clone()));
+            }
+        }
+    }
+
+    let mut yaml = format!(
+        "id: {}\nname: \"{}\"\ndescription: \"TODO: what {} does\"\n\n",
+        id,
+        title(id),
+        id
+    );
+    if !specs.is_empty() {
+        yaml.push_str("uses:\n");
+        for (name, _) in specs {
+            yaml.push_str(&format!("  - {}\n", name));
+        }
+        yaml.push('\n');
+    }
+    yaml.push_str(&fields_yaml("inputs", &inputs));
+    yaml.push_str(&fields_yaml("outputs", outputs));
+    yaml.push_str(
+        "# Steps run in order; later steps read a call's outputs as <step id>.<output>\nchain:",
+    );
+    if specs.is_empty() {
+        yaml.push_str(" []\n");
+    } else {
+        yaml.push('\n');
+    }
+    for (name, spec) in specs {
+        yaml.push_str(&format!(
+            "  - step: call\n    id: {}\n    spec: {}\n",
+            name, name
+        ));
+        match spec {
+            Some(spec) if !spec.inputs.is_empty() => {
+                yaml.push_str("    inputs:\n");
+                for var in &spec.inputs {
+                    yaml.push_str(&format!("      {}: \"{}\"\n", var.name, var.name));
+                }
+            }
+            _ => yaml.push_str(
+                "    # Map the spec's inputs, e.g. weight_kg: \"weight_kg\"\n    inputs: {}\n",
+            ),
+        }
+    }
+
+    Orchestrator::from_yaml(&yaml).map_err(|e| Error::SpecParse(e.to_string()))?;
+    Ok(yaml)
+}
+
+/// `shipping_rate` as `Shipping Rate`
+pub(crate) fn title(id: &str) -> String {
+    id.split(['_', '-'])
+        .filter(|w| !w.is_empty())
+        .map(crate::util::to_pascal_case)
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+pub(crate) fn fields_yaml(section: &str, fields: &[Field]) -> String {
+    if fields.is_empty() {
+        return format!("{}: []\n\n", section);
+    }
+    let mut yaml = format!("{}:\n", section);
+    for (name, typ) in fields {
+        yaml.push_str(&format!(
+            "  - name: {}\n    type: {}\n",
+            name,
+            type_yaml(typ)
+        ));
+    }
+    yaml.push('\n');
+    yaml
+}
+
+fn type_yaml(typ: &VarType) -> String {
+    match typ {
+        VarType::Bool => "bool".into(),
+        VarType::Int => "int".into(),
+        VarType::Float => "float".into(),
+        VarType::String => "string".into(),
+        VarType::Object => "object".into(),
+        VarType::Enum(values) => format!("!enum [{}]", values.join(", ")),
+        VarType::List(inner) => format!("!list {}", type_yaml(inner)),
+    }
+}
+
+/// A condition on an input showing how it is tested
+fn example_condition(name: &str, typ: &VarType) -> Option<String> {
+    match typ {
+        VarType::Bool => Some(name.to_string()),
+        VarType::Int => Some(format!("{} > 0", name)),
+        VarType::Float => Some(format!("{} > 0.0", name)),
+        VarType::String => Some(format!("{} != ''", name)),
+        VarType::Enum(values) => Some(format!("{} == '{}'", name, values[0])),
+        VarType::List(_) => Some(format!("size({}) > 0", name)),
+        VarType::Object => None,
+    }
+}
+
+/// The `then` (or `default`) value, inline for one output and a mapping
+/// for several; rule and default examples differ where the type allows
+fn output_yaml(outputs: &[Field], rule: bool) -> String {
+    let value = |typ: &VarType| match typ {
+        VarType::Bool => rule.to_string(),
+        VarType::Int => (if rule { "1" } else { "0" }).to_string(),
+        VarType::Float => (if rule { "1.0" } else { "0.0" }).to_string(),
+        VarType::String => "\"todo\"".to_string(),
+        VarType::Enum(values) => {
+            let value = if rule { values.first() } else { values.last() };
+            format!("\"{}\"", value.unwrap())
+        }
+        VarType::List(_) => "[]".to_string(),
+        VarType::Object => "{}".to_string(),
+    };
+    let indent = if rule { "      " } else { "  " };
+    match outputs {
+        [(_, typ)] => format!(" {}", value(typ)),
+        _ => outputs
+            .iter()
+            .map(|(name, typ)| format!("\n{}{}: {}", indent, name, value(typ)))
+            .collect(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_fields() {
+        let fields =
+            parse_fields("weight_kg:float, zone:enum(domestic,eu|world),tags:list(string)")
+                .unwrap();
+        assert_eq!(
+            fields,
+            vec![
+                ("weight_kg".to_string(), VarType::Float),
+                (
+                    "zone".to_string(),
+                    VarType::Enum(vec!["domestic".into(), "eu".into(), "world".into()])
+                ),
+                ("tags".to_string(), VarType::List(Box::new(VarType::String))),
+            ]
+        );
+        assert!(parse_fields("zone").is_err());
+        assert!(parse_fields("zone:decimal").is_err());
+        assert!(parse_fields("2fast:int").is_err());
+    }
+
+    #[test]
+    fn test_spec_skeleton_parses() {
+        let inputs = parse_fields("weight_kg:float,zone:enum(domestic,eu)").unwrap();
+        let yaml = spec_skeleton("shipping_rate", &inputs, &[]).unwrap();
+        assert!(yaml.contains("name: \"Shipping Rate\""));
+        assert!(yaml.contains("when: \"weight_kg > 0.0 && zone == 'domestic'\""));
+        let spec = Spec::from_yaml(&yaml).unwrap();
+        assert_eq!(spec.inputs.len(), 2);
+        assert_eq!(spec.outputs[0].name, "result");
+        assert!(spec.default.is_some());
+
+        let outputs = parse_fields("rate:float,carrier:string").unwrap();
+        let spec = Spec::from_yaml(&spec_skeleton("rate", &[], &outputs).unwrap()).unwrap();
+        assert!(spec.rules[0].then.to_string().contains("carrier: todo"));
+    }
+
+    #[test]
+    fn test_flow_skeleton_wires_known_specs() {
+        let inputs = parse_fields("weight_kg:float,zone:string").unwrap();
+        let known =
+            Spec::from_yaml(&spec_skeleton("shipping_rate", &inputs, &[]).unwrap()).unwrap();
+        let yaml = flow_skeleton(
+            "checkout",
+            &parse_fields("member:bool").unwrap(),
+            &[],
+            &[
+                ("shipping_rate".to_string(), Some(known)),
+                ("tax".to_string(), None),
+            ],
+        )
+        .unwrap();
+        let flow = Orchestrator::from_yaml(&yaml).unwrap();
+        let names: Vec<&str> = flow.inputs.iter().map(|i| i.name.as_str()).collect();
+        assert_eq!(names, vec!["member", "weight_kg", "zone"]);
+        assert_eq!(flow.chain.len(), 2);
+        assert_eq!(flow.uses, vec!["shipping_rate", "tax"]);
+        assert!(yaml.contains("      zone: \"zone\"\n"));
+    }
+}
@@ -0,0 +1,388 @@
+//! What-if sensitivity analysis (`imacs sensitivity`)
+//!
+//! Sweeps one numeric input, or one of the spec's constants, across a
+//! range with everything else held at a base input, and reports the
+//! outputs at each step and the exact values where another rule takes
+//! over. A pricing owner proposing `heavy_kg: 20.0 -> 18.0` sees which
+//! parcels change price and by how much before the change is made.
+
+use crate::cel::CelValue;
+use crate::error::{Error, Result};
+use crate::runtime::{evaluate_with_drafts, from_cel_value, to_cel_value, Values};
+use crate::spec::{ConditionValue, Spec, VarType};
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::fmt::Write;
+use std::path::Path;
+
+/// Halvings of a step when locating a rule boundary
+const BISECTIONS: usize = 30;
+
+/// The decision at one value of the swept input or constant
+#[derive(Debug, Clone, Serialize)]
+pub struct Point {
+    pub value: f64,
+    /// Rule that decided, `None` for the default
+    pub rule: Option<String>,
+    pub outputs: BTreeMap<String, ConditionValue>,
+}
+
+/// A value where the deciding rule changes
+#[derive(Debug, Clone, Serialize)]
+pub struct Boundary {
+    /// First value (to the bisection's precision) decided by `to`
+    pub at: f64,
+    pub from: Option<String>,
+    pub to: Option<String>,
+}
+
+/// Decisions across a range of one input or constant
+#[derive(Debug, Clone, Serialize)]
+pub struct Sweep {
+    pub spec: String,
+    pub name: String,
+    /// Whether `name` is a constant rather than an input
+    pub constant: bool,
+    /// The decision at the base input's value or the constant's declared
+    /// value, when there is one
+    pub baseline: Option<Point>,
+    pub points: Vec<Point>,
+    pub boundaries: Vec<Boundary>,
+}
+
+/// Decide `input` with `name` set to `steps + 1` evenly spaced values from
+/// `from` to `to`
+///
+/// `name` is an input of the spec at `path` or one of its constants; a
+/// constant is overridden as an environment would, so every rule using it
+/// moves together. Int inputs and constants take whole values only.
+pub fn sweep(
+    path: &Path,
+    input: &BTreeMap<String, ConditionValue>,
+    name: &str,
+    from: f64,
+    to: f64,
+    steps: usize,
+) -> Result<Sweep> {
+    if steps == 0 || from >= to {
+        return Err(Error::Other(format!(
+            "Sweep {} from {} to {} in {} step(s): needs from < to and at least one step",
+            name, from, to, steps
+        )));
+    }
+    let spec = Spec::from_file(path)?;
+    let (typ, constant, base) = if let Some(var) = spec.inputs.iter().find(|v| v.name == name) {
+        (&var.typ, false, input.get(name))
+    } else if let Some(c) = spec.constants.iter().find(|c| c.name == name) {
+        (&c.typ, true, Some(&c.value))
+    } else {
+        return Err(Error::Other(format!(
+            "'{}' is neither an input nor a constant of '{}'",
+            name, spec.id
+        )));
+    };
+    let whole = match typ {
+        VarType::Int => true,
+        VarType::Float => false,
+        other => {
+            return Err(Error::Other(format!(
+                "'{}' is a {}, not a number",
+                name,
+                crate::compat::type_name(other)
+            )))
+        }
+    };
+    let base = base.and_then(|value| match value {
+        ConditionValue::Int(i) => Some(*i as f64),
+        ConditionValue::Float(f) => Some(*f),
+        _ => None,
+    });
+
+    let values: Values = input
+        .iter()
+        .map(|(k, v)| {
+            let typed = match (v, spec.inputs.iter().find(|i| &i.name == k)) {
+                (ConditionValue::Int(i), Some(var)) if var.typ == VarType::Float => {
+                    CelValue::Float(*i as f64)
+                }
+                (v, _) => to_cel_value(v),
+            };
+            (k.clone(), typed)
+        })
+        .collect();
+    let decide = |value: f64| -> Result<Point> {
+        let literal = if whole {
+            ConditionValue::Int(value.round() as i64)
+        } else {
+            ConditionValue::Float(value)
+        };
+        let evaluation = if constant {
+            let overrides = BTreeMap::from([(name.to_string(), literal.clone())]);
+            evaluate_with_drafts(&Spec::from_file_overriding(path, &overrides)?, &values)?
+        } else {
+            let mut values = values.clone();
+            values.insert(name.to_string(), to_cel_value(&literal));
+            evaluate_with_drafts(&spec, &values)?
+        };
+        Ok(Point {
+            value: if whole { value.round() } else { value },
+            rule: evaluation.rule_id,
+            outputs: evaluation
+                .outputs
+                .iter()
+                .map(|(k, v)| (k.clone(), from_cel_value(v)))
+                .collect(),
+        })
+    };
+
+    let mut points: Vec<Point> = Vec::new();
+    for i in 0..=steps {
+        let point = decide(from + (to - from) * i as f64 / steps as f64)?;
+        if points.last().is_none_or(|last| last.value != point.value) {
+            points.push(point);
+        }
+    }
+
+    let mut boundaries = Vec::new();
+    for pair in points.windows(2) {
+        let (lower, upper) = (&pair[0], &pair[1]);
+        if lower.rule == upper.rule {
+            continue;
+        }
+        let (mut lo, mut hi) = (lower.value, upper.value);
+        for _ in 0..BISECTIONS {
+            if whole && hi - lo <= 1.0 {
+                break;
+            }
+            let mid = if whole {
+                ((lo + hi) / 2.0).floor()
+            } else {
+                (lo + hi) / 2.0
+            };
+            if decide(mid)?.rule == lower.rule {
+                lo = mid;
+            } else {
+                hi = mid;
+            }
+        }
+        boundaries.push(Boundary {
+            at: hi,
+            from: lower.rule.clone(),
+            to: decide(hi)?.rule,
+        });
+    }
+
+    let baseline = base.map(decide).transpose()?;
+    Ok(Sweep {
+        spec: spec.id.clone(),
+        name: name.to_string(),
+        constant,
+        baseline,
+        points,
+        boundaries,
+    })
+}
+
+impl Sweep {
+    /// The sweep as a table, with a bar per step when the spec has one
+    /// numeric output, followed by the rule boundaries
+    pub fn to_report(&self) -> String {
+        let mut out = format!(
+            "Sensitivity of {} to {} ({})\n",
+            self.spec,
+            self.name,
+            if self.constant { "constant" } else { "input" }
+        );
+        let numeric: Vec<f64> = self
+            .points
+            .iter()
+            .filter_map(
+                |p| match p.outputs.values().collect::<Vec<_>>().as_slice() {
+                    [ConditionValue::Int(i)] => Some(*i as f64),
+                    [ConditionValue::Float(f)] => Some(*f),
+                    _ => None,
+                },
+            )
+            .collect();
+        let bars = numeric.len() == self.points.len();
+        let (low, high) = numeric
+            .iter()
+            .fold((f64::INFINITY, f64::NEG_INFINITY), |(lo, hi), v| {
+                (lo.min(*v), hi.max(*v))
+            });
+
+        let _ = writeln!(out, "  {:>12}  {:<12}  outputs", self.name, "rule");
+        for (i, point) in self.points.iter().enumerate() {
+            let mut line = format!(
+                "  {:>12}  {:<12}  {}",
+                number(point.value),
+                point.rule.as_deref().unwrap_or("default"),
+                show(&point.outputs)
+            );
+            if bars {
+                let width = if high > low {
+                    1 + ((numeric[i] - low) / (high - low) * 29.0).round() as usize
+                } else {
+                    1
+                };
+                let _ = write!(line, "  {}", "█".repeat(width));
+            }
+            let _ = writeln!(out, "{}", line);
+        }
+
+        if self.boundaries.is_empty() {
+            out.push_str("No rule boundary in the range\n");
+        } else {
+            out.push_str("Rule boundaries:\n");
+            for boundary in &self.boundaries {
+                let _ = writeln!(
+                    out,
+                    "  at {} = {}: {} -> {}",
+                    self.name,
+                    number(boundary.at),
+                    boundary.from.as_deref().unwrap_or("default"),
+                    boundary.to.as_deref().unwrap_or("default")
+                );
+            }
+        }
+        if let Some(baseline) = &self.baseline {
+            let _ = writeln!(
+                out,
+                "Baseline {} = {}: {} ({})",
+                self.name,
+                number(baseline.value),
+                show(&baseline.outputs),
+                baseline.rule.as_deref().unwrap_or("default")
+            );
+        }
+        out
+    }
+}
+
+/// Outputs as `value` for one, `name=value, ...` for several
+fn show(outputs: &BTreeMap<String, ConditionValue>) -> String {
+    if outputs.len() == 1 {
+        return outputs.values().map(|v| v.to_string()).collect();
+    }
+    outputs
+        .iter()
+        .map(|(name, value)| format!("{}={}", name, value))
+        .collect::<Vec<_>>()
+        .join(", ")
+}
+
+/// A number with at most four decimals, trailing zeros dropped
+fn number(value: f64) -> String {
+    let text = format!("{:.4}", value);
+    text.trim_end_matches('0').trim_end_matches('.').to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+constants:
+  - name: heavy_kg
+    type: float
+    value: 20.0
+inputs:
+  - name: weight_kg
+    type: float
+  - name: items
+    type: int
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: BULK
+    when: "items >= 10"
+    then: 2.0
+  - id: HEAVY
+    when: "weight_kg > ${heavy_kg}"
+    then: 25.0
+default: 5.0
+"#;
+
+    /// SPEC written to a file, in a directory kept for the test
+    fn spec_file() -> (tempfile::TempDir, std::path::PathBuf) {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("shipping_rate.yaml");
+        std::fs::write(&path, SPEC).unwrap();
+        (dir, path)
+    }
+
+    fn input(yaml: &str) -> BTreeMap<String, ConditionValue> {
+        serde_norway::from_str(yaml).unwrap()
+    }
+
+    #[test]
+    fn test_sweep_input() {
+        let (_dir, spec) = spec_file();
+        let sweep = sweep(
+            &spec,
+            &input("weight_kg: 12\nitems: 1\n"),
+            "weight_kg",
+            0.0,
+            40.0,
+            4,
+        )
+        .unwrap();
+        let values: Vec<_> = sweep.points.iter().map(|p| p.value).collect();
+        assert_eq!(values, vec![0.0, 10.0, 20.0, 30.0, 40.0]);
+        assert_eq!(sweep.points[2].rule, None);
+        assert_eq!(sweep.points[3].rule.as_deref(), Some("HEAVY"));
+        assert_eq!(sweep.boundaries.len(), 1);
+        assert!((sweep.boundaries[0].at - 20.0).abs() < 1e-4);
+        assert_eq!(sweep.boundaries[0].to.as_deref(), Some("HEAVY"));
+        assert_eq!(sweep.baseline.as_ref().unwrap().value, 12.0);
+
+        let report = sweep.to_report();
+        assert!(report.starts_with("Sensitivity of shipping_rate to weight_kg (input)\n"));
+        assert!(report.contains("at weight_kg = 20: default -> HEAVY"));
+        assert!(report.contains("Baseline weight_kg = 12: 5 (default)"));
+        assert!(report.contains(&"█".repeat(30)));
+    }
+
+    #[test]
+    fn test_sweep_int_input() {
+        let (_dir, spec) = spec_file();
+        let sweep = sweep(&spec, &input("weight_kg: 1\n"), "items", 0.0, 15.0, 4).unwrap();
+        let values: Vec<_> = sweep.points.iter().map(|p| p.value).collect();
+        assert_eq!(values, vec![0.0, 4.0, 8.0, 11.0, 15.0]);
+        assert_eq!(sweep.boundaries[0].at, 10.0);
+        assert_eq!(sweep.boundaries[0].to.as_deref(), Some("BULK"));
+        assert!(sweep.baseline.is_none());
+    }
+
+    #[test]
+    fn test_sweep_constant() {
+        let (_dir, spec) = spec_file();
+        let sweep = sweep(
+            &spec,
+            &input("weight_kg: 15\nitems: 1\n"),
+            "heavy_kg",
+            10.0,
+            20.0,
+            2,
+        )
+        .unwrap();
+        assert!(sweep.constant);
+        assert_eq!(sweep.points[0].rule.as_deref(), Some("HEAVY"));
+        assert_eq!(sweep.points[2].rule, None);
+        // From a 15 kg threshold on, the 15 kg parcel is no longer heavy
+        assert!((sweep.boundaries[0].at - 15.0).abs() < 1e-4);
+        assert_eq!(sweep.baseline.as_ref().unwrap().value, 20.0);
+    }
+
+    #[test]
+    fn test_sweep_errors() {
+        let (_dir, spec) = spec_file();
+        let err = sweep(&spec, &BTreeMap::new(), "zone", 0.0, 1.0, 1).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("'zone' is neither an input nor a constant"));
+        assert!(sweep(&spec, &BTreeMap::new(), "weight_kg", 1.0, 1.0, 1).is_err());
+    }
+}
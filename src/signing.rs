@@ -0,0 +1,191 @@
+//! Spec signing and verification
+//!
+//! Specs are signed with ed25519. Keys and signatures are hex encoded. A
+//! signature either travels inside the spec as a trailing comment
+//!
+//! ```text
+//! # imacs-signature: 3b1f...
+//! ```
+//!
+//! or in a sidecar file next to it (`checkout.yaml.sig`). An embedded
+//! signature must be the last line. The signature covers every byte before
+//! that line, so re-signing an embedded spec replaces the old signature and
+//! nothing else in the file can change unnoticed.
+
+use crate::error::{Error, Result};
+use crate::spec::Spec;
+use ed25519_dalek::{Signature, Signer, SigningKey, Verifier, VerifyingKey};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+const SIGNATURE_PREFIX: &str = "# imacs-signature:";
+
+/// A hex-encoded ed25519 key pair
+#[derive(Debug, Clone)]
+pub struct KeyPair {
+    /// Secret key (32-byte seed); keep out of version control
+    pub secret: String,
+    /// Public key, distributed to whoever verifies
+    pub public: String,
+}
+
+/// Generate a new key pair from the OS random source
+pub fn generate_keypair() -> Result<KeyPair> {
+    let mut seed = [0u8; 32];
+    getrandom::fill(&mut seed).map_err(|e| Error::Signature(e.to_string()))?;
+    let key = SigningKey::from_bytes(&seed);
+    Ok(KeyPair {
+        secret: hex::encode(key.to_bytes()),
+        public: hex::encode(key.verifying_key().to_bytes()),
+    })
+}
+
+/// Sign spec content, returning the hex signature
+pub fn sign(content: &str, secret_key: &str) -> Result<String> {
+    let key = SigningKey::from_bytes(&decode_key(secret_key)?);
+    let signature = key.sign(unsigned_content(content).as_bytes());
+    Ok(hex::encode(signature.to_bytes()))
+}
+
+/// Check a hex signature against spec content
+pub fn verify(content: &str, signature: &str, public_key: &str) -> Result<()> {
+    let key = VerifyingKey::from_bytes(&decode_key(public_key)?)
+        .map_err(|e| Error::Signature(format!("Invalid public key: {}", e)))?;
+    let bytes: [u8; 64] = hex::decode(signature.trim())
+        .ok()
+        .and_then(|b| b.try_into().ok())
+        .ok_or_else(|| Error::Signature("Malformed signature".into()))?;
+    key.verify(
+        unsigned_content(content).as_bytes(),
+        &Signature::from_bytes(&bytes),
+    )
+    .map_err(|_| Error::Signature("Signature does not match".into()))
+}
+
+/// Replace any embedded signature with `signature`
+///
+/// The signed content must end with a newline so the signature can follow
+/// it on a line of its own.
+pub fn embed_signature(content: &str, signature: &str) -> Result<String> {
+    let unsigned = unsigned_content(content);
+    if !unsigned.is_empty() && !unsigned.ends_with('\n') {
+        return Err(Error::Signature(
+            "Content must end with a newline to embed a signature".into(),
+        ));
+    }
+    Ok(format!("{}{} {}\n", unsigned, SIGNATURE_PREFIX, signature))
+}
+
+/// The signature embedded in spec content, if any
+pub fn embedded_signature(content: &str) -> Option<String> {
+    signature_line(content)
+        .map(|start| content[start + SIGNATURE_PREFIX.len()..].trim().to_string())
+}
+
+/// Sidecar signature file for a spec
+pub fn sidecar_path(spec_path: &Path) -> PathBuf {
+    let mut name = spec_path.as_os_str().to_owned();
+    name.push(".sig");
+    PathBuf::from(name)
+}
+
+/// Verify a spec file's embedded or sidecar signature
+pub fn verify_file(spec_path: &Path, public_key: &str) -> Result<()> {
+    let content = fs::read_to_string(spec_path)?;
+    let signature = match embedded_signature(&content) {
+        Some(signature) => signature,
+        None => fs::read_to_string(sidecar_path(spec_path))
+            .map_err(|_| Error::Signature(format!("{} is not signed", spec_path.display())))?,
+    };
+    verify(&content, &signature, public_key)
+}
+
+/// Load a spec, refusing it unless it carries a valid signature when a
+/// public key is given (strict mode)
+pub fn load_spec(spec_path: &Path, public_key: Option<&str>) -> Result<Spec> {
+    if let Some(public_key) = public_key {
+        verify_file(spec_path, public_key)?;
+    }
+    Spec::from_yaml(&fs::read_to_string(spec_path)?)
+}
+
+/// Content covered by the signature: every byte before a trailing
+/// signature line
+fn unsigned_content(content: &str) -> &str {
+    match signature_line(content) {
+        Some(start) => &content[..start],
+        None => content,
+    }
+}
+
+/// Byte offset of the last line when it is a signature comment
+fn signature_line(content: &str) -> Option<usize> {
+    let body = content.strip_suffix('\n').unwrap_or(content);
+    let start = body.rfind('\n').map_or(0, |i| i + 1);
+    body[start..].starts_with(SIGNATURE_PREFIX).then_some(start)
+}
+
+fn decode_key(key: &str) -> Result<[u8; 32]> {
+    hex::decode(key.trim())
+        .ok()
+        .and_then(|b| b.try_into().ok())
+        .ok_or_else(|| Error::Signature("Keys must be 32 bytes, hex encoded".into()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = "id: checkout\nrules: []\n";
+
+    #[test]
+    fn test_sign_and_verify() {
+        let keys = generate_keypair().unwrap();
+        let signature = sign(SPEC, &keys.secret).unwrap();
+        verify(SPEC, &signature, &keys.public).unwrap();
+
+        let tampered = SPEC.replace("checkout", "refund");
+        assert!(verify(&tampered, &signature, &keys.public).is_err());
+
+        let other = generate_keypair().unwrap();
+        assert!(verify(SPEC, &signature, &other.public).is_err());
+    }
+
+    #[test]
+    fn test_embedded_signature() {
+        let keys = generate_keypair().unwrap();
+        let signed = embed_signature(SPEC, &sign(SPEC, &keys.secret).unwrap()).unwrap();
+        assert!(signed.starts_with(SPEC));
+
+        let signature = embedded_signature(&signed).unwrap();
+        verify(&signed, &signature, &keys.public).unwrap();
+
+        // Re-signing replaces the previous signature
+        let resigned = embed_signature(&signed, &sign(&signed, &keys.secret).unwrap()).unwrap();
+        assert_eq!(resigned.matches(SIGNATURE_PREFIX).count(), 1);
+        assert!(embedded_signature(SPEC).is_none());
+
+        // Only the last line is the signature; a signature-like comment
+        // elsewhere is signed content like any other line
+        let inserted = signed.replacen("rules:", &format!("{} 00\nrules:", SIGNATURE_PREFIX), 1);
+        assert!(verify(&inserted, &signature, &keys.public).is_err());
+        let indented = signed.replace(SIGNATURE_PREFIX, &format!("  {}", SIGNATURE_PREFIX));
+        assert!(embedded_signature(&indented).is_none());
+
+        assert!(embed_signature("id: checkout", &signature).is_err());
+    }
+
+    #[test]
+    fn test_load_spec_strict() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("checkout.yaml");
+        let keys = generate_keypair().unwrap();
+
+        fs::write(&path, SPEC).unwrap();
+        assert!(load_spec(&path, None).is_ok());
+        assert!(load_spec(&path, Some(&keys.public)).is_err());
+
+        fs::write(sidecar_path(&path), sign(SPEC, &keys.secret).unwrap()).unwrap();
+        assert_eq!(load_spec(&path, Some(&keys.public)).unwrap().id, "checkout");
+    }
+}
@@ -39,7 +39,7 @@ use crate::error::{Error, Result};
 use crate::render::ScopingConfig;
 use schemars::JsonSchema;
 use serde::{Deserialize, Serialize};
-use std::collections::HashMap;
+use std::collections::{BTreeMap, HashMap};
 
 /// A complete specification
 #[derive(Debug, Clone, Default, Serialize, Deserialize, JsonSchema)]
@@ -68,6 +68,15 @@ pub struct Spec {
     #[serde(default)]
     pub rules: Vec<Rule>,
 
+    /// Cross-field constraints every valid input must satisfy
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub constraints: Vec<Constraint>,
+
+    /// Checks every result must satisfy, over inputs and outputs
+    /// (asserted by generated code in debug/staging builds)
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub postconditions: Vec<Constraint>,
+
     /// Default output if no rules match
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub default: Option<Output>,
@@ -80,6 +89,331 @@ pub struct Spec {
     /// Required for rendering - validates at render time
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub scoping: Option<ScopingConfig>,
+
+    /// Code generation options
+    #[serde(default, skip_serializing_if = "CodegenOptions::is_empty")]
+    pub codegen: CodegenOptions,
+
+    /// How to choose between several matching rules
+    #[serde(default, skip_serializing_if = "Resolution::is_first_match")]
+    pub resolution: Resolution,
+
+    /// Memoize results for repeated inputs (runtime engine and generated
+    /// `*_cached` functions)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub cache: Option<CacheConfig>,
+
+    /// Rewrites applied to string inputs before any rule is evaluated (runtime
+    /// engine and generated Go; other targets refuse to render them)
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub normalize: Vec<Normalization>,
+
+    /// Behavioral properties checked at generation time (see
+    /// [`crate::assertions`])
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub assertions: Vec<Assertion>,
+
+    /// Lookup tables read from data files, used as
+    /// `lookup('<table>', key, fallback)`
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub tables: Vec<Table>,
+
+    /// Named groups of countries that `zone(country)` maps ISO codes to
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub zones: Vec<Zone>,
+
+    /// Named values rules refer to as `${name}`, which environments may
+    /// override; see [`crate::constants`]
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub constants: Vec<Constant>,
+
+    /// Base spec this one overlays, as a path relative to this file; see
+    /// [`crate::overlay`]
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub extends: Option<String>,
+
+    /// Rules overlays may replace and add rules in front of, and `default`
+    /// when they may replace the default
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub extension_points: Vec<String>,
+
+    /// Project functions expressions may call (`functions:` in
+    /// `.imacs_root`), attached when the spec is generated in a project
+    #[serde(skip)]
+    #[schemars(skip)]
+    pub functions: Vec<CustomFunction>,
+
+    /// YAML the spec was parsed from, as written, unless constants were
+    /// overridden (see [`Spec::embed_source`])
+    #[serde(skip)]
+    #[schemars(skip)]
+    pub source: Option<String>,
+}
+
+/// An expression function a project implements itself, declared in
+/// `.imacs_root` so specs can call domain helpers (`dim_weight(l, w, h)`)
+/// the expression language does not have
+///
+/// Generated Go calls the implementation named by `go` and imports its
+/// package; other targets call a function of the same name, which the
+/// project provides. The runtime engine cannot evaluate these calls.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct CustomFunction {
+    /// Name expressions call it by
+    pub name: String,
+    /// Parameter types, in order
+    #[serde(default)]
+    pub params: Vec<VarType>,
+    /// Type of the result
+    pub returns: VarType,
+    /// Go implementation: import path and exported function,
+    /// `example.com/shipping/parcel.DimWeight`
+    pub go: String,
+}
+
+impl CustomFunction {
+    /// Import path of the Go implementation and the call that reaches it
+    /// through that import (`parcel.DimWeight`); None when `go` is not an
+    /// import path followed by a function name
+    pub fn go_call(&self) -> Option<(&str, String)> {
+        let slash = self.go.rfind('/').map_or(0, |i| i + 1);
+        let dot = slash + self.go[slash..].rfind('.')?;
+        let (path, function) = (&self.go[..dot], &self.go[dot + 1..]);
+        if path.is_empty() || !function.starts_with(|c: char| c.is_ascii_uppercase()) {
+            return None;
+        }
+        let package = crate::layout::import_name(&format!("\"{}\"", path));
+        Some((path, format!("{}.{}", package, function)))
+    }
+}
+
+/// A named value of a spec, replaced per environment by
+/// `overrides/{env}.yaml`
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct Constant {
+    pub name: String,
+    #[serde(rename = "type")]
+    pub typ: VarType,
+    pub value: ConditionValue,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+}
+
+/// A shipping zone: the countries `zone(country)` maps to its name
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct Zone {
+    pub name: String,
+    /// ISO 3166-1 alpha-2 codes, or regions standing for all their
+    /// countries (`eu`, `europe`, see [`crate::geo::REGIONS`])
+    pub countries: Vec<String>,
+}
+
+/// A key → value table kept in a CSV or JSON file next to the spec and
+/// embedded into the generated code, for data (zone → base rate) that
+/// would otherwise take a rule per row
+///
+/// CSV files start with a header row naming the columns. JSON files hold
+/// an object of key → value, or an array of objects with the key and value
+/// columns. Keys are strings.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct Table {
+    pub name: String,
+    /// Data file, relative to the spec and inside its directory
+    pub file: String,
+    /// Key column
+    #[serde(default = "Table::default_key")]
+    pub key: String,
+    /// Value column
+    #[serde(default = "Table::default_value")]
+    pub value: String,
+    /// Type of the values
+    #[serde(rename = "type")]
+    pub typ: VarType,
+    /// The rows by key, once loaded with [`Spec::load_tables`]
+    #[serde(skip)]
+    #[schemars(skip)]
+    pub rows: BTreeMap<String, ConditionValue>,
+}
+
+impl Table {
+    fn default_key() -> String {
+        "key".into()
+    }
+
+    fn default_value() -> String {
+        "value".into()
+    }
+
+    /// Parse the table's data file, checking every value against its type
+    pub fn parse(&self, content: &str) -> Result<BTreeMap<String, ConditionValue>> {
+        let invalid = |msg: String| Error::SpecParse(format!("Table '{}': {}", self.name, msg));
+        let pairs: Vec<(String, ConditionValue)> = if self.file.ends_with(".json") {
+            let json: serde_json::Value =
+                serde_json::from_str(content).map_err(|e| invalid(e.to_string()))?;
+            let value = |v: &serde_json::Value| {
+                serde_json::from_value::<ConditionValue>(v.clone())
+                    .map_err(|e| invalid(e.to_string()))
+            };
+            match &json {
+                serde_json::Value::Object(map) => map
+                    .iter()
+                    .map(|(k, v)| Ok((k.clone(), value(v)?)))
+                    .collect::<Result<_>>()?,
+                serde_json::Value::Array(rows) => rows
+                    .iter()
+                    .map(|row| {
+                        let key = match row.get(&self.key) {
+                            Some(serde_json::Value::String(k)) => k.clone(),
+                            Some(other) => other.to_string(),
+                            None => return Err(invalid(format!("a row has no '{}'", self.key))),
+                        };
+                        let v = row.get(&self.value).ok_or_else(|| {
+                            invalid(format!("row '{}' has no '{}'", key, self.value))
+                        })?;
+                        Ok((key, value(v)?))
+                    })
+                    .collect::<Result<_>>()?,
+                _ => return Err(invalid("expected an object or an array of rows".into())),
+            }
+        } else {
+            let mut lines = content.lines().filter(|l| !l.trim().is_empty());
+            let header = csv_fields(lines.next().unwrap_or_default());
+            let column = |name: &str| {
+                header
+                    .iter()
+                    .position(|h| h == name)
+                    .ok_or_else(|| invalid(format!("no column '{}'", name)))
+            };
+            let (key, value) = (column(&self.key)?, column(&self.value)?);
+            lines
+                .map(|line| {
+                    let fields = csv_fields(line);
+                    let (Some(k), Some(v)) = (fields.get(key), fields.get(value)) else {
+                        return Err(invalid(format!("row '{}' is missing columns", line)));
+                    };
+                    let parsed = match &self.typ {
+                        VarType::Int => v.parse().ok().map(ConditionValue::Int),
+                        VarType::Float => v.parse().ok().map(ConditionValue::Float),
+                        VarType::Bool => v.parse().ok().map(ConditionValue::Bool),
+                        _ => Some(ConditionValue::String(v.clone())),
+                    };
+                    let parsed = parsed.ok_or_else(|| {
+                        invalid(format!("'{}' of row '{}' is not a {:?}", v, k, self.typ))
+                    })?;
+                    Ok((k.clone(), parsed))
+                })
+                .collect::<Result<_>>()?
+        };
+
+        let mut rows = BTreeMap::new();
+        for (key, value) in pairs {
+            let value = match (value, &self.typ) {
+                (ConditionValue::Int(i), VarType::Float) => ConditionValue::Float(i as f64),
+                (value, _) => value,
+            };
+            if !value.is_valid_for(&self.typ) {
+                return Err(invalid(format!(
+                    "{} of row '{}' is not a {:?}",
+                    value, key, self.typ
+                )));
+            }
+            if rows.insert(key.clone(), value).is_some() {
+                return Err(invalid(format!("duplicate key '{}'", key)));
+            }
+        }
+        Ok(rows)
+    }
+}
+
+/// Fields of a CSV line; double quotes enclose fields with commas, and a
+/// doubled quote inside them stands for one
+fn csv_fields(line: &str) -> Vec<String> {
+    let mut fields = vec![String::new()];
+    let mut quoted = false;
+    let mut chars = line.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '"' if quoted && chars.peek() == Some(&'"') => {
+                chars.next();
+                fields.last_mut().unwrap().push('"');
+            }
+            '"' => quoted = !quoted,
+            ',' if !quoted => fields.push(String::new()),
+            _ => fields.last_mut().unwrap().push(c),
+        }
+    }
+    fields.iter().map(|f| f.trim().to_string()).collect()
+}
+
+/// A rewrite of one string input, applied before evaluation so rules can
+/// compare against canonical values
+///
+/// Steps run in order: trim, then case, then `map`.
+#[derive(Debug, Clone, Default, Serialize, Deserialize, PartialEq, JsonSchema)]
+pub struct Normalization {
+    /// Input to rewrite
+    pub input: String,
+
+    /// Strip leading and trailing whitespace
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub trim: bool,
+
+    /// Convert to lower case
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub lowercase: bool,
+
+    /// Convert to upper case
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub uppercase: bool,
+
+    /// Whole-value replacements, e.g. legacy names to current ones
+    #[serde(default, skip_serializing_if = "BTreeMap::is_empty")]
+    pub map: BTreeMap<String, String>,
+}
+
+impl Normalization {
+    /// Normalize one value
+    pub fn apply(&self, value: &str) -> String {
+        let mut value = if self.trim { value.trim() } else { value }.to_string();
+        if self.lowercase {
+            value = value.to_lowercase();
+        } else if self.uppercase {
+            value = value.to_uppercase();
+        }
+        match self.map.get(&value) {
+            Some(mapped) => mapped.clone(),
+            None => value,
+        }
+    }
+}
+
+/// Conflict resolution strategy when several rules match
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Resolution {
+    /// The first rule in evaluation order wins
+    #[default]
+    FirstMatch,
+    /// The matching rule with the most conjuncts wins (gold+domestic beats
+    /// domestic); ties fall back to evaluation order
+    MostSpecific,
+}
+
+impl Resolution {
+    pub fn is_first_match(&self) -> bool {
+        *self == Resolution::FirstMatch
+    }
+}
+
+/// LRU result cache settings
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+pub struct CacheConfig {
+    /// Maximum number of cached inputs; the least recently used is evicted
+    pub size: usize,
+
+    /// Seconds an entry stays valid (no expiry when absent)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub ttl_secs: Option<u64>,
 }
 
 /// A variable (input or output)
@@ -99,6 +433,90 @@ pub struct Variable {
     /// For enums: valid values
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub values: Option<Vec<String>>,
+
+    /// Optional input: absence is distinct from the zero value.
+    /// Also accepted as a trailing `?` on the name (`member_tier?`).
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub optional: bool,
+
+    /// Value applied when the input is omitted
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub default: Option<ConditionValue>,
+
+    /// Unit of measure for numeric values (e.g. "kg", "lb")
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub unit: Option<String>,
+
+    /// ISO 4217 currency code; marks the value as a money amount
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub currency: Option<String>,
+
+    /// Legacy names still accepted in input payloads during a migration
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub aliases: Vec<String>,
+
+    /// Personal data: masked wherever decisions are logged, audited or
+    /// explained (same as `redact: mask`)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub pii: bool,
+
+    /// How the value is hidden in logs, audit records and explanations
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub redact: Option<Redact>,
+}
+
+impl Variable {
+    /// How the value must be hidden, if at all
+    pub fn redaction(&self) -> Option<Redact> {
+        self.redact.or(self.pii.then_some(Redact::Mask))
+    }
+}
+
+/// Redaction of a personal value
+///
+/// Explanation messages always mask: they are read by people, who have no
+/// use for a hash. Logged values and audit records keep the hash, so equal
+/// values can still be correlated without being revealed. The hash is an
+/// HMAC keyed by [`REDACT_KEY_ENV`]: a plain hash of a guessable value (an
+/// email, a postcode) is undone by hashing the guesses.
+#[derive(Debug, Clone, Copy, Serialize, Deserialize, PartialEq, Eq, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum Redact {
+    /// Replace the value with `***`
+    Mask,
+    /// Replace the value with an HMAC-SHA256 of its text; masked while no
+    /// key is configured
+    Hash,
+}
+
+/// Text substituted for masked values
+pub const MASK: &str = "***";
+
+/// Environment variable holding the key of hashed redactions
+pub const REDACT_KEY_ENV: &str = "IMACS_REDACT_KEY";
+
+impl Redact {
+    /// Redacted form of a value's text (strings unquoted), hashed with the
+    /// key in [`REDACT_KEY_ENV`]
+    pub fn apply(&self, text: &str) -> String {
+        let key = std::env::var(REDACT_KEY_ENV).unwrap_or_default();
+        self.apply_keyed(text, key.as_bytes())
+    }
+
+    /// Redacted form of a value's text, hashed with `key`; an empty key
+    /// masks instead
+    pub fn apply_keyed(&self, text: &str, key: &[u8]) -> String {
+        use hmac::{Hmac, Mac};
+        match self {
+            Redact::Hash if !key.is_empty() => {
+                let mut mac = Hmac::<sha2::Sha256>::new_from_slice(key)
+                    .expect("HMAC accepts keys of any length");
+                mac.update(text.as_bytes());
+                format!("hmac-sha256:{}", hex::encode(mac.finalize().into_bytes()))
+            }
+            Redact::Mask | Redact::Hash => MASK.to_string(),
+        }
+    }
 }
 
 /// Variable types
@@ -108,6 +526,8 @@ pub struct Variable {
 pub enum VarType {
     Bool,
     Int,
+    /// `money` is accepted as an alias; pair it with `currency`
+    #[serde(alias = "money")]
     Float,
     #[default]
     String,
@@ -117,6 +537,206 @@ pub enum VarType {
     Object,
 }
 
+/// Spec-level code generation options
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize, JsonSchema)]
+pub struct CodegenOptions {
+    /// How optional inputs are represented in generated Go
+    #[serde(default)]
+    pub nullable: NullableStyle,
+
+    /// Emit a rule registry (ID, condition, output, tags, line) for introspection
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub registry: bool,
+
+    /// Count how often each rule fires (expvar in Go, a stats function elsewhere)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub counters: bool,
+
+    /// Emit an explain function reporting which conjuncts of a rule failed
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub explain: bool,
+
+    /// Emit a wrapper that runs a candidate implementation in the shadow of
+    /// this one and reports divergences
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub shadow: bool,
+
+    /// Emit a batch function that evaluates many inputs in parallel
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub batch: bool,
+
+    /// Emit a Go `EvalStream` that decides inputs received on a channel with
+    /// a worker pool, sending results on a bounded channel
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub stream: Option<StreamOptions>,
+
+    /// Go hot-path mode: evaluate through a pointer to the input, with no
+    /// interface{} values or heap allocation, and benchmark it in the
+    /// generated tests
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub zero_alloc: bool,
+
+    /// Split the Go rule chain of specs with more rules than this into
+    /// helper functions of this many rules, one file each
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub split_rules: Option<usize>,
+
+    /// Emit String/MarshalJSON methods for Go outputs, a rule-ID type and a
+    /// typed constraint error
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub marshalers: bool,
+
+    /// Emit a decide function whose result carries the spec name, version
+    /// and hash alongside the output
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub versioned: bool,
+
+    /// Log rule matches at debug level through a generated, injectable
+    /// `log/slog` logger (Go)
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub logging: bool,
+
+    /// Emit a `Safe` variant of the Go function that recovers panics into
+    /// an error carrying the input
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub safe: bool,
+
+    /// Emit a Go decoder from URL query parameters or a parsed form
+    /// (`url.Values`) into the input struct, for GET-style endpoints
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub form_decoding: bool,
+
+    /// Serve the Go function over HTTP through a generated `net/http`
+    /// handler, and emit a typed client for calling it
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub service: bool,
+
+    /// Embed the spec YAML in the generated Go package (`go:embed`) and
+    /// expose it with its hash, so binaries can report the rules they run
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub embed_spec: bool,
+
+    /// Emit a Go interface the generated rules implement, with an adapter
+    /// for functions, so callers can decorate, mock or swap them
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub interface: bool,
+
+    /// Emit the Go rules as scalar SQL functions over `database/sql/driver`
+    /// values, with a registrar that hands them to a driver (SQLite, DuckDB)
+    /// so queries can call them
+    #[serde(default, skip_serializing_if = "std::ops::Not::not")]
+    pub sql_functions: bool,
+
+    /// Go build constraint on every generated file (e.g. `linux && amd64`)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub build_tags: Option<String>,
+
+    /// Builds (a Go build constraint, e.g. `tinygo || wasm`) that get a lean
+    /// variant of the Go code: no counters, logging, HTTP service, batch or
+    /// stream workers. The full code is constrained to the other builds.
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub lean_build: Option<String>,
+
+    /// Set on the variant [`Spec::lean_variant`] returns; not part of specs
+    #[serde(skip)]
+    #[schemars(skip)]
+    pub lean: bool,
+}
+
+/// Sizing of the streaming evaluator
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+pub struct StreamOptions {
+    /// Workers deciding inputs (GOMAXPROCS when absent)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub workers: Option<usize>,
+
+    /// Results buffered before the workers block (one per worker when
+    /// absent)
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub buffer: Option<usize>,
+}
+
+impl CodegenOptions {
+    pub fn is_empty(&self) -> bool {
+        *self == Self::default()
+    }
+
+    /// The `//go:build` constraint of the generated Go: `build_tags`,
+    /// narrowed to the lean or the other builds when there is a lean variant
+    pub fn go_build(&self) -> Option<String> {
+        let lean = self.lean_build.as_deref().map(|lean| {
+            if self.lean {
+                group_constraint(lean)
+            } else {
+                format!("!{}", group_constraint(lean))
+            }
+        });
+        match (self.build_tags.as_deref(), lean) {
+            (Some(tags), Some(lean)) => Some(format!("{} && {}", group_constraint(tags), lean)),
+            (Some(tags), None) => Some(tags.to_string()),
+            (None, lean) => lean,
+        }
+    }
+}
+
+/// A build constraint as an operand: bare tags as they are, expressions
+/// in parentheses
+fn group_constraint(expr: &str) -> String {
+    let expr = expr.trim();
+    if expr
+        .chars()
+        .all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '.')
+    {
+        expr.to_string()
+    } else {
+        format!("({})", expr)
+    }
+}
+
+/// Whether text is a Go build constraint expression: tags joined by
+/// `&&`, `||`, `!` and balanced parentheses
+fn is_build_constraint(expr: &str) -> bool {
+    let mut depth = 0i32;
+    let mut expect_operand = true;
+    let mut chars = expr.trim().chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            ' ' => {}
+            '!' if expect_operand => {}
+            '(' if expect_operand => depth += 1,
+            ')' if !expect_operand => {
+                depth -= 1;
+                if depth < 0 {
+                    return false;
+                }
+            }
+            '&' | '|' if !expect_operand && chars.next() == Some(c) => expect_operand = true,
+            c if expect_operand && (c.is_ascii_alphanumeric() || c == '_' || c == '.') => {
+                while chars
+                    .peek()
+                    .is_some_and(|c| c.is_ascii_alphanumeric() || *c == '_' || *c == '.')
+                {
+                    chars.next();
+                }
+                expect_operand = false;
+            }
+            _ => return false,
+        }
+    }
+    depth == 0 && !expect_operand
+}
+
+/// Go representation for optional inputs
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "snake_case")]
+pub enum NullableStyle {
+    /// `*T`, nil when absent
+    #[default]
+    Pointer,
+    /// `sql.Null[T]` in a JSON-aware wrapper, `Valid == false` when absent
+    SqlNull,
+}
+
 /// Condition clause - can be a single CEL expression or an array (AND'd together)
 ///
 /// # Examples
@@ -193,13 +813,172 @@ pub struct Rule {
     #[serde(rename = "then")]
     pub then: Output,
 
-    /// Priority (lower = higher priority)
+    /// Priority (lower = higher priority); see [`Spec::evaluation_order`]
     #[serde(default)]
     pub priority: i32,
 
     /// Description
     #[serde(default, skip_serializing_if = "Option::is_none")]
     pub description: Option<String>,
+
+    /// Free-form labels, exposed through the generated rule registry
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub tags: Vec<String>,
+
+    /// 1-based line of the rule in its YAML source (set by `Spec::from_yaml`)
+    #[serde(skip)]
+    pub line: Option<usize>,
+
+    /// Local bindings evaluated in order when the rule matches; later
+    /// bindings and the output expressions may refer to earlier ones
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub vars: Vec<LocalBinding>,
+
+    /// Stable code for why this rule decided, reported alongside the output
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub reason_code: Option<String>,
+
+    /// Key of the localized explanation in the message catalog
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message_key: Option<String>,
+
+    /// Lifecycle state; only active rules reach generated code
+    #[serde(default, skip_serializing_if = "RuleState::is_active")]
+    pub state: RuleState,
+
+    /// Who is accountable for the rule
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub owner: Option<String>,
+
+    /// Who signed off on the rule
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub approved_by: Option<String>,
+}
+
+/// Lifecycle state of a rule
+///
+/// Draft rules are documented and can be simulated
+/// ([`crate::runtime::evaluate_with_drafts`], shadow candidates), but are
+/// neither generated nor evaluated in production. Retired rules stay in the
+/// spec as history and are never evaluated.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq, Serialize, Deserialize, JsonSchema)]
+#[serde(rename_all = "lowercase")]
+pub enum RuleState {
+    Draft,
+    #[default]
+    Active,
+    Retired,
+}
+
+impl RuleState {
+    pub fn is_active(&self) -> bool {
+        *self == RuleState::Active
+    }
+}
+
+impl std::fmt::Display for RuleState {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            RuleState::Draft => write!(f, "draft"),
+            RuleState::Active => write!(f, "active"),
+            RuleState::Retired => write!(f, "retired"),
+        }
+    }
+}
+
+/// A named sub-expression local to one rule
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq, JsonSchema)]
+pub struct LocalBinding {
+    /// Local name
+    pub name: String,
+
+    /// CEL expression over the inputs and earlier bindings
+    pub expr: String,
+}
+
+/// A constraint relating several input fields
+///
+/// `require` must hold for every input; with `when`, only for inputs where
+/// `when` holds (`priority implies zone != ''` is `when: priority`,
+/// `require: zone != ''`).
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq, JsonSchema)]
+pub struct Constraint {
+    /// Constraint identifier
+    pub id: String,
+
+    /// CEL guard; the constraint only applies when this holds
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub when: Option<String>,
+
+    /// CEL expression that must hold
+    pub require: String,
+
+    /// Message reported when the constraint is violated
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub message: Option<String>,
+}
+
+/// A property the spec's behavior must have, checked by evaluating it on
+/// sampled inputs
+///
+/// ```yaml
+/// assertions:
+///   - id: gold_domestic_free
+///     given: "tier == 'gold' && zone == 'domestic'"
+///     expect: "rate == 0.0"
+///   - id: intl_at_least_domestic
+///     compare: { input: zone, left: intl, right: domestic }
+///     expect: "left.rate >= right.rate"
+/// ```
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq, JsonSchema)]
+pub struct Assertion {
+    /// Assertion identifier
+    pub id: String,
+
+    /// What the property means, for reports
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub description: Option<String>,
+
+    /// CEL guard over the inputs; the property only applies where it holds
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub given: Option<String>,
+
+    /// Evaluate twice, differing only in one input
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub compare: Option<Comparison>,
+
+    /// CEL expression that must hold, over the inputs and outputs (or over
+    /// `left.<output>` and `right.<output>` with `compare`)
+    pub expect: String,
+}
+
+/// The input an [`Assertion`] varies, and the two values it compares
+#[derive(Debug, Clone, Serialize, Deserialize, PartialEq, JsonSchema)]
+pub struct Comparison {
+    pub input: String,
+    pub left: ConditionValue,
+    pub right: ConditionValue,
+}
+
+impl Constraint {
+    /// The constraint as a single CEL expression
+    pub fn to_cel(&self) -> String {
+        match &self.when {
+            Some(when) => format!("!({}) || ({})", when, self.require),
+            None => self.require.clone(),
+        }
+    }
+
+    /// Message reported when the constraint is violated
+    pub fn violation_message(&self) -> String {
+        match &self.message {
+            Some(message) => format!("{}: {}", self.id, message),
+            None => match &self.when {
+                Some(when) => format!("{}: {} requires {}", self.id, when, self.require),
+                None => format!("{}: {} must hold", self.id, self.require),
+            },
+        }
+    }
 }
 
 /// A structured condition
@@ -268,10 +1047,27 @@ pub enum ConditionValue {
     Float(f64),
     String(String),
     List(Vec<ConditionValue>),
-    Map(HashMap<String, ConditionValue>),
+    Map(#[serde(serialize_with = "serialize_sorted")] HashMap<String, ConditionValue>),
     Null,
 }
 
+/// Truncated SHA-256 of `content`, as spec hashes are written
+fn short_sha256(content: &str) -> String {
+    use sha2::{Digest, Sha256};
+    let mut hasher = Sha256::new();
+    hasher.update(content.as_bytes());
+    format!("sha256:{}", hex::encode(&hasher.finalize()[..8]))
+}
+
+/// Serialize a map with its keys in order, so a spec's YAML, and with it
+/// [`Spec::hash`], is the same on every run
+fn serialize_sorted<S: serde::Serializer>(
+    map: &HashMap<String, ConditionValue>,
+    serializer: S,
+) -> std::result::Result<S::Ok, S::Error> {
+    serializer.collect_map(map.iter().collect::<BTreeMap<_, _>>())
+}
+
 impl std::fmt::Display for ConditionValue {
     fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
         match self {
@@ -289,6 +1085,24 @@ impl std::fmt::Display for ConditionValue {
     }
 }
 
+impl ConditionValue {
+    /// Whether this literal is a valid value of the given type
+    pub fn is_valid_for(&self, typ: &VarType) -> bool {
+        match (self, typ) {
+            (ConditionValue::Bool(_), VarType::Bool) => true,
+            (ConditionValue::Int(_), VarType::Int | VarType::Float) => true,
+            (ConditionValue::Float(_), VarType::Float) => true,
+            (ConditionValue::String(_), VarType::String) => true,
+            (ConditionValue::String(s), VarType::Enum(variants)) => variants.contains(s),
+            (ConditionValue::List(items), VarType::List(inner)) => {
+                items.iter().all(|i| i.is_valid_for(inner))
+            }
+            (ConditionValue::Map(_), VarType::Object) => true,
+            _ => false,
+        }
+    }
+}
+
 /// Rule output
 #[derive(Debug, Clone, Serialize, Deserialize, PartialEq, JsonSchema)]
 #[serde(untagged)]
@@ -296,7 +1110,7 @@ pub enum Output {
     /// Single value
     Single(ConditionValue),
     /// Named fields
-    Named(HashMap<String, ConditionValue>),
+    Named(#[serde(serialize_with = "serialize_sorted")] HashMap<String, ConditionValue>),
 }
 
 impl std::fmt::Display for Output {
@@ -343,37 +1157,264 @@ impl SpecMeta {
 impl Spec {
     /// Parse spec from YAML string
     pub fn from_yaml(yaml: &str) -> Result<Self> {
-        serde_norway::from_str(yaml).map_err(|e| Error::SpecParse(e.to_string()))
+        Self::from_yaml_overriding(yaml, &BTreeMap::new())
     }
 
-    /// Serialize spec to YAML string
-    pub fn to_yaml(&self) -> Result<String> {
-        serde_norway::to_string(self).map_err(|e| Error::SpecParse(e.to_string()))
+    /// Parse a spec from YAML, its constants taking the values in
+    /// `overrides` instead of their declared ones
+    pub fn from_yaml_overriding(
+        yaml: &str,
+        overrides: &BTreeMap<String, ConditionValue>,
+    ) -> Result<Self> {
+        let spec: Self = if crate::constants::declares(yaml) {
+            crate::constants::resolve(yaml, overrides)?
+        } else {
+            serde_norway::from_str(yaml).map_err(|e| Error::SpecParse(e.to_string()))?
+        };
+        let mut spec = spec.resolve_optional_markers().locate_rules(yaml);
+        // Overridden constants make the file no longer the spec as decided
+        spec.source = overrides.is_empty().then(|| yaml.to_string());
+        Ok(spec)
     }
 
-    /// Parse spec from JSON string
-    pub fn from_json(json: &str) -> Result<Self> {
-        serde_json::from_str(json).map_err(|e| Error::SpecParse(e.to_string()))
+    /// Read a spec file, loading its lookup tables from the spec's
+    /// directory, overriding its constants for the environment `IMACS_ENV`
+    /// names, and applying it to its base when it is an overlay
+    pub fn from_file(path: &std::path::Path) -> Result<Self> {
+        Self::from_file_overriding(path, &BTreeMap::new())
     }
 
-    /// Serialize spec to JSON string
-    pub fn to_json(&self) -> Result<String> {
-        serde_json::to_string_pretty(self).map_err(|e| Error::SpecParse(e.to_string()))
+    /// [`Spec::from_file`], the file's constants then taking the values in
+    /// `overrides`
+    pub fn from_file_overriding(
+        path: &std::path::Path,
+        overrides: &BTreeMap<String, ConditionValue>,
+    ) -> Result<Self> {
+        Self::from_file_within(path, overrides, &mut Vec::new())
     }
 
-    /// Get a rule by ID
-    pub fn get_rule(&self, id: &str) -> Option<&Rule> {
-        self.rules.iter().find(|r| r.id == id)
+    /// [`Spec::from_file_overriding`], `chain` holding the overlays being
+    /// resolved
+    fn from_file_within(
+        path: &std::path::Path,
+        overrides: &BTreeMap<String, ConditionValue>,
+        chain: &mut Vec<std::path::PathBuf>,
+    ) -> Result<Self> {
+        let dir = path.parent().unwrap_or(std::path::Path::new(""));
+        let yaml = std::fs::read_to_string(path)?;
+        let mut env = crate::constants::env_overrides(dir, &yaml)?;
+        env.extend(overrides.clone());
+        let mut spec = Self::from_yaml_overriding(&yaml, &env)?;
+        spec.load_tables(dir)?;
+        let Some(base) = &spec.extends else {
+            return Ok(spec);
+        };
+        let canonical = path.canonicalize()?;
+        if chain.contains(&canonical) {
+            return Err(Error::SpecParse(format!(
+                "{}: overlays extend each other in a cycle",
+                path.display()
+            )));
+        }
+        chain.push(canonical);
+        let base = Self::from_file_within(&dir.join(base), &BTreeMap::new(), chain)?;
+        let mut merged = crate::overlay::apply(&base, &spec)?;
+        // No one file holds the merged spec
+        merged.source = None;
+        Ok(merged)
     }
 
-    /// Compute hash of spec for change detection
-    pub fn hash(&self) -> String {
-        use sha2::{Digest, Sha256};
-        let content = self.to_yaml().unwrap_or_default();
-        let mut hasher = Sha256::new();
-        hasher.update(content.as_bytes());
-        format!("sha256:{}", hex::encode(&hasher.finalize()[..8]))
-    }
+    /// Tables behind the geo helpers, embedded like lookup tables: `region`
+    /// (country code → continent) when an expression calls `region()`, and
+    /// `zone` (country code → zone name) when the spec declares zones
+    pub fn geo_tables(&self) -> Vec<Table> {
+        let table = |name: &str, file: &str, rows| Table {
+            name: name.into(),
+            file: file.into(),
+            key: Table::default_key(),
+            value: Table::default_value(),
+            typ: VarType::String,
+            rows,
+        };
+        let mut tables = Vec::new();
+        let calls_region = self.expressions().iter().any(|expr| {
+            crate::cel::CelCompiler::geo_calls(expr)
+                .unwrap_or_default()
+                .iter()
+                .any(|(name, _)| *name == "region")
+        });
+        if calls_region {
+            let rows = crate::geo::countries()
+                .map(|(code, region)| (code.to_string(), ConditionValue::String(region.into())))
+                .collect();
+            tables.push(table("region", "ISO 3166 country regions (built in)", rows));
+        }
+        if !self.zones.is_empty() {
+            let rows = self
+                .zones
+                .iter()
+                .flat_map(|zone| {
+                    zone.countries
+                        .iter()
+                        .filter_map(|member| crate::geo::expand(member))
+                        .flatten()
+                        .map(|code| (code.to_string(), ConditionValue::String(zone.name.clone())))
+                })
+                .collect();
+            tables.push(table("zone", "the spec's zones", rows));
+        }
+        tables
+    }
+
+    /// Conditions, bindings and output expressions of the rules and default
+    pub(crate) fn expressions(&self) -> Vec<String> {
+        let mut exprs = Vec::new();
+        for rule in &self.rules {
+            exprs.extend(rule.as_cel());
+            exprs.extend(rule.vars.iter().map(|b| b.expr.clone()));
+        }
+        for output in self.rules.iter().map(|r| &r.then).chain(&self.default) {
+            let values: Vec<&ConditionValue> = match output {
+                Output::Single(ConditionValue::Map(map)) | Output::Named(map) => {
+                    map.values().collect()
+                }
+                Output::Single(value) => vec![value],
+            };
+            exprs.extend(values.into_iter().filter_map(|value| match value {
+                ConditionValue::String(s)
+                    if crate::render::is_expression_calling(s, &self.functions) =>
+                {
+                    Some(s.clone())
+                }
+                _ => None,
+            }));
+        }
+        exprs
+    }
+
+    /// Load the rows of every lookup table from files relative to `dir`
+    pub fn load_tables(&mut self, dir: &std::path::Path) -> Result<()> {
+        use std::path::Component;
+        for table in &mut self.tables {
+            let file = std::path::Path::new(&table.file);
+            if file
+                .components()
+                .any(|c| !matches!(c, Component::Normal(_) | Component::CurDir))
+            {
+                return Err(Error::SpecParse(format!(
+                    "Table '{}': {} must be a relative path inside the spec's directory",
+                    table.name, table.file
+                )));
+            }
+            let content = std::fs::read_to_string(dir.join(file)).map_err(|e| {
+                Error::SpecParse(format!("Table '{}': {}: {}", table.name, table.file, e))
+            })?;
+            table.rows = table.parse(&content)?;
+        }
+        Ok(())
+    }
+
+    /// Serialize spec to YAML string
+    pub fn to_yaml(&self) -> Result<String> {
+        serde_norway::to_string(self).map_err(|e| Error::SpecParse(e.to_string()))
+    }
+
+    /// Serialize spec to YAML, keeping the comments of the YAML it was
+    /// read from (see [`crate::yaml::carry_comments`])
+    pub fn to_yaml_preserving(&self, original: &str) -> Result<String> {
+        Ok(crate::yaml::carry_comments(original, &self.to_yaml()?))
+    }
+
+    /// Parse spec from JSON string
+    pub fn from_json(json: &str) -> Result<Self> {
+        let spec: Self = serde_json::from_str(json).map_err(|e| Error::SpecParse(e.to_string()))?;
+        Ok(spec.resolve_optional_markers())
+    }
+
+    /// Turn the `name?` shorthand into `optional: true`
+    fn resolve_optional_markers(mut self) -> Self {
+        for var in &mut self.inputs {
+            if let Some(name) = var.name.strip_suffix('?') {
+                var.name = name.to_string();
+                var.optional = true;
+            }
+        }
+        self
+    }
+
+    /// Record the source line of each rule's `id:` key
+    fn locate_rules(mut self, yaml: &str) -> Self {
+        let lines: Vec<&str> = yaml.lines().collect();
+        let mut from = lines
+            .iter()
+            .position(|l| l.trim_end() == "rules:")
+            .unwrap_or(0);
+        for rule in &mut self.rules {
+            let found = lines.iter().enumerate().skip(from).find(|(_, line)| {
+                let line = line.trim_start().trim_start_matches('-').trim_start();
+                line.strip_prefix("id:").is_some_and(|value| {
+                    value.trim().trim_matches(|c| c == '"' || c == '\'') == rule.id
+                })
+            });
+            if let Some((idx, _)) = found {
+                rule.line = Some(idx + 1);
+                from = idx + 1;
+            }
+        }
+        self
+    }
+
+    /// Serialize spec to JSON string
+    pub fn to_json(&self) -> Result<String> {
+        serde_json::to_string_pretty(self).map_err(|e| Error::SpecParse(e.to_string()))
+    }
+
+    /// Get a rule by ID
+    pub fn get_rule(&self, id: &str) -> Option<&Rule> {
+        self.rules.iter().find(|r| r.id == id)
+    }
+
+    /// Compute hash of spec for change detection
+    pub fn hash(&self) -> String {
+        short_sha256(&self.to_yaml().unwrap_or_default())
+    }
+
+    /// File that `codegen.embed_spec` Go code embeds, holding
+    /// [`Spec::embed_source`]
+    pub fn embed_file_name(&self) -> String {
+        format!("{}.spec.yaml", self.id)
+    }
+
+    /// YAML that `codegen.embed_spec` Go code embeds: the file the spec was
+    /// read from, byte for byte, or its serialization when it was built or
+    /// merged from several files, had its constants overridden, or loaded
+    /// lookup tables, whose rows it then holds
+    pub fn embed_source(&self) -> Result<String> {
+        let loaded = self.tables.iter().any(|t| !t.rows.is_empty());
+        match &self.source {
+            Some(source) if !loaded => Ok(source.clone()),
+            _ => {
+                let invalid = |e: serde_norway::Error| Error::SpecParse(e.to_string());
+                let mut yaml = serde_norway::to_value(self).map_err(invalid)?;
+                let tables = yaml.get_mut("tables").and_then(|t| t.as_sequence_mut());
+                for (value, table) in tables.into_iter().flatten().zip(&self.tables) {
+                    if let Some(map) = value.as_mapping_mut() {
+                        map.insert(
+                            "rows".into(),
+                            serde_norway::to_value(&table.rows).map_err(invalid)?,
+                        );
+                    }
+                }
+                serde_norway::to_string(&yaml).map_err(invalid)
+            }
+        }
+    }
+
+    /// Hash of [`Spec::embed_source`], which generated `VerifySpec` checks
+    /// the embedded file against
+    pub fn embed_hash(&self) -> String {
+        short_sha256(&self.embed_source().unwrap_or_default())
+    }
 
     /// Validate spec for completeness
     pub fn validate(&self) -> Vec<String> {
@@ -416,219 +1457,2238 @@ impl Spec {
             }
         }
 
-        // PY-2: Warn if no default rule (exhaustiveness not guaranteed)
-        if self.default.is_none() && !self.rules.is_empty() {
-            errors.push("Warning: No default rule - exhaustiveness not guaranteed".into());
+        // Rule-local bindings need unique names that do not shadow inputs
+        for rule in &self.rules {
+            let mut bound = std::collections::HashSet::new();
+            for binding in &rule.vars {
+                if input_names.contains(binding.name.as_str()) {
+                    errors.push(format!(
+                        "Rule {} binding '{}' shadows an input",
+                        rule.id, binding.name
+                    ));
+                }
+                if !bound.insert(binding.name.as_str()) {
+                    errors.push(format!(
+                        "Rule {} binds '{}' more than once",
+                        rule.id, binding.name
+                    ));
+                }
+                if let Err(e) = crate::cel::CelCompiler::parse(&binding.expr) {
+                    errors.push(format!(
+                        "Rule {} binding '{}' is not a valid expression: {}",
+                        rule.id, binding.name, e
+                    ));
+                }
+            }
+            // Bindings are for the output; an unused one is a compile error in Go
+            let mut referenced: Vec<String> = Vec::new();
+            let outputs: Vec<&ConditionValue> = match &rule.then {
+                Output::Single(ConditionValue::Map(map)) | Output::Named(map) => {
+                    map.values().collect()
+                }
+                Output::Single(value) => vec![value],
+            };
+            let output_exprs = outputs.into_iter().filter_map(|v| match v {
+                ConditionValue::String(s) => Some(s.as_str()),
+                _ => None,
+            });
+            for expr in rule
+                .vars
+                .iter()
+                .map(|b| b.expr.as_str())
+                .chain(output_exprs)
+            {
+                referenced
+                    .extend(crate::cel::CelCompiler::extract_variables(expr).unwrap_or_default());
+            }
+            for binding in &rule.vars {
+                if !referenced.contains(&binding.name) {
+                    errors.push(format!(
+                        "Rule {} binding '{}' is never used",
+                        rule.id, binding.name
+                    ));
+                }
+            }
+            if let Some(cel) = rule.as_cel() {
+                let used = crate::cel::CelCompiler::extract_variables(&cel).unwrap_or_default();
+                for binding in &rule.vars {
+                    if used.contains(&binding.name) {
+                        errors.push(format!(
+                            "Rule {} condition cannot use binding '{}'; bindings are only visible in the output",
+                            rule.id, binding.name
+                        ));
+                    }
+                }
+            }
         }
 
-        errors
-    }
-}
+        // Constraints may only relate declared inputs
+        let input_list: Vec<&str> = self.inputs.iter().map(|i| i.name.as_str()).collect();
+        let mut constraint_ids = std::collections::HashSet::new();
+        for constraint in &self.constraints {
+            if !constraint_ids.insert(&constraint.id) {
+                errors.push(format!("Duplicate constraint ID: {}", constraint.id));
+            }
+            for expr in constraint
+                .when
+                .iter()
+                .chain(std::iter::once(&constraint.require))
+            {
+                if let Err(e) = crate::cel::CelCompiler::validate_variables(expr, &input_list) {
+                    errors.push(format!("Constraint {}: {}", constraint.id, e));
+                }
+            }
+        }
 
-impl Rule {
-    /// Get condition as CEL expression
-    pub fn as_cel(&self) -> Option<String> {
-        if let Some(when_clause) = &self.when {
-            return Some(when_clause.to_cel());
+        // Postconditions may relate inputs and declared outputs
+        let io_names: Vec<&str> = self
+            .inputs
+            .iter()
+            .chain(self.outputs.iter())
+            .map(|v| v.name.as_str())
+            .collect();
+        if !self.postconditions.is_empty() && self.outputs.is_empty() {
+            errors.push("Postconditions require declared outputs".into());
+        }
+        let mut postcondition_ids = std::collections::HashSet::new();
+        for postcondition in &self.postconditions {
+            if !postcondition_ids.insert(&postcondition.id) {
+                errors.push(format!("Duplicate postcondition ID: {}", postcondition.id));
+            }
+            for expr in postcondition
+                .when
+                .iter()
+                .chain(std::iter::once(&postcondition.require))
+            {
+                if let Err(e) = crate::cel::CelCompiler::validate_variables(expr, &io_names) {
+                    errors.push(format!("Postcondition {}: {}", postcondition.id, e));
+                }
+            }
         }
 
-        // Convert structured conditions to CEL
-        if let Some(conditions) = &self.conditions {
-            if conditions.is_empty() {
-                return None;
+        if let Some(cache) = &self.cache {
+            if cache.size == 0 {
+                errors.push("Cache size must be at least 1".into());
             }
-            let parts: Vec<String> = conditions.iter().map(|c| c.to_cel()).collect();
-            return Some(parts.join(" && "));
         }
 
-        None
-    }
-}
+        // An alias must not be mistaken for another input or alias
+        let mut payload_names = input_names.clone();
+        for input in &self.inputs {
+            for alias in &input.aliases {
+                if !payload_names.insert(alias.as_str()) {
+                    errors.push(format!(
+                        "Alias '{}' of input '{}' is already an input name or alias",
+                        alias, input.name
+                    ));
+                }
+            }
+        }
 
-impl Condition {
-    /// Convert to CEL expression fragment
-    pub fn to_cel(&self) -> String {
-        let val_str = match &self.value {
-            ConditionValue::Bool(b) => b.to_string(),
-            ConditionValue::Int(i) => i.to_string(),
-            ConditionValue::Float(f) => f.to_string(),
-            ConditionValue::String(s) => format!("\"{}\"", s),
-            ConditionValue::List(items) => {
-                let inner: Vec<_> = items
-                    .iter()
-                    .map(|v| match v {
-                        ConditionValue::String(s) => format!("\"{}\"", s),
-                        ConditionValue::Int(i) => i.to_string(),
-                        _ => "?".to_string(),
-                    })
-                    .collect();
-                format!("[{}]", inner.join(", "))
+        // Assertions need unique IDs and compare declared inputs
+        let mut assertion_ids = std::collections::HashSet::new();
+        for assertion in &self.assertions {
+            if !assertion_ids.insert(assertion.id.as_str()) {
+                errors.push(format!("Duplicate assertion ID: {}", assertion.id));
             }
-            _ => "?".to_string(),
-        };
+            if let Some(compare) = &assertion.compare {
+                if !input_names.contains(compare.input.as_str()) {
+                    errors.push(format!(
+                        "Assertion {} compares unknown input '{}'",
+                        assertion.id, compare.input
+                    ));
+                }
+            }
+        }
 
-        match self.op {
-            ConditionOp::Eq => format!("{} == {}", self.var, val_str),
-            ConditionOp::Ne => format!("{} != {}", self.var, val_str),
-            ConditionOp::Lt => format!("{} < {}", self.var, val_str),
-            ConditionOp::Le => format!("{} <= {}", self.var, val_str),
-            ConditionOp::Gt => format!("{} > {}", self.var, val_str),
-            ConditionOp::Ge => format!("{} >= {}", self.var, val_str),
-            ConditionOp::In => format!("{} in {}", self.var, val_str),
-            ConditionOp::Contains => format!("{}.contains({})", self.var, val_str),
-            ConditionOp::StartsWith => format!("{}.startsWith({})", self.var, val_str),
-            ConditionOp::EndsWith => format!("{}.endsWith({})", self.var, val_str),
-            ConditionOp::Matches => format!("{}.matches({})", self.var, val_str),
+        // Normalizations rewrite required string inputs
+        for normalization in &self.normalize {
+            match self.inputs.iter().find(|i| i.name == normalization.input) {
+                None => errors.push(format!(
+                    "Normalization of unknown input '{}'",
+                    normalization.input
+                )),
+                Some(input) if !matches!(input.typ, VarType::String | VarType::Enum(_)) => errors
+                    .push(format!(
+                        "Input '{}' is not a string and cannot be normalized",
+                        input.name
+                    )),
+                Some(input) if input.optional => errors.push(format!(
+                    "Input '{}' is optional and cannot be normalized",
+                    input.name
+                )),
+                Some(_) => {}
+            }
+            if normalization.lowercase && normalization.uppercase {
+                errors.push(format!(
+                    "Normalization of '{}' cannot be both lowercase and uppercase",
+                    normalization.input
+                ));
+            }
         }
-    }
-}
 
-#[cfg(test)]
-mod tests {
-    use super::*;
+        if self.codegen.split_rules == Some(0) {
+            errors.push("split_rules must be at least 1".into());
+        }
+        if self.codegen.stream.is_some_and(|s| s.workers == Some(0)) {
+            errors.push("stream workers must be at least 1".into());
+        }
+        for (option, expr) in [
+            ("build_tags", &self.codegen.build_tags),
+            ("lean_build", &self.codegen.lean_build),
+        ] {
+            if let Some(expr) = expr.as_deref().filter(|e| !is_build_constraint(e)) {
+                errors.push(format!(
+                    "{} '{}' is not a Go build constraint",
+                    option, expr
+                ));
+            }
+        }
 
-    #[test]
-    fn test_parse_yaml() {
-        let yaml = r#"
-id: test_spec
-inputs:
-  - name: x
-    type: bool
-outputs:
-  - name: result
-    type: int
-rules:
-  - id: R1
-    when: "x"
-    then: 1
-  - id: R2
-    when: "!x"
-    then: 0
-"#;
-        let spec = Spec::from_yaml(yaml).unwrap();
-        assert_eq!(spec.id, "test_spec");
-        assert_eq!(spec.inputs.len(), 1);
-        assert_eq!(spec.rules.len(), 2);
-    }
+        // Object and list values are interface{}-typed in generated Go
+        if self.codegen.zero_alloc {
+            for var in self.inputs.iter().chain(&self.outputs) {
+                if matches!(var.typ, VarType::Object | VarType::List(_)) {
+                    errors.push(format!(
+                        "'{}' is an object or list, which zero_alloc code cannot represent",
+                        var.name
+                    ));
+                }
+            }
+        }
 
-    #[test]
-    fn test_validate() {
-        let spec = Spec {
-            id: "".into(),
-            name: None,
-            description: None,
-            inputs: vec![],
-            outputs: vec![],
-            rules: vec![],
-            default: None,
-            meta: SpecMeta::default(),
-            scoping: None,
-        };
+        // URL values carry only scalars
+        if self.codegen.form_decoding {
+            for input in &self.inputs {
+                if matches!(input.typ, VarType::Object | VarType::List(_)) {
+                    errors.push(format!(
+                        "Input '{}' is an object or list, which form_decoding cannot parse",
+                        input.name
+                    ));
+                }
+            }
+        }
 
-        let errors = spec.validate();
-        assert!(errors.iter().any(|e| e.contains("ID")));
-        assert!(errors.iter().any(|e| e.contains("input")));
-        assert!(errors.iter().any(|e| e.contains("rule")));
-    }
+        // SQL arguments and results are scalars
+        if self.codegen.sql_functions {
+            for var in self.inputs.iter().chain(self.outputs.iter()) {
+                if matches!(var.typ, VarType::Object | VarType::List(_)) {
+                    errors.push(format!(
+                        "'{}' is an object or list, which sql_functions cannot pass",
+                        var.name
+                    ));
+                }
+            }
+        }
 
-    #[test]
-    fn test_condition_to_cel() {
-        let cond = Condition {
-            var: "amount".into(),
-            op: ConditionOp::Gt,
-            value: ConditionValue::Int(1000),
-        };
-        assert_eq!(cond.to_cel(), "amount > 1000");
+        // Input defaults must match the declared type
+        for input in &self.inputs {
+            if let Some(default) = &input.default {
+                if input.optional {
+                    errors.push(format!(
+                        "Input '{}' is optional and cannot also declare a default",
+                        input.name
+                    ));
+                }
+                let in_values = match (default, &input.values) {
+                    (ConditionValue::String(s), Some(values)) => values.contains(s),
+                    _ => true,
+                };
+                if !default.is_valid_for(&input.typ) || !in_values {
+                    errors.push(format!(
+                        "Input '{}' default {} is not a valid {:?} value",
+                        input.name, default, input.typ
+                    ));
+                }
+            }
+        }
 
-        let cond2 = Condition {
-            var: "status".into(),
-            op: ConditionOp::In,
-            value: ConditionValue::List(vec![
-                ConditionValue::String("active".into()),
-                ConditionValue::String("pending".into()),
-            ]),
-        };
-        assert_eq!(cond2.to_cel(), "status in [\"active\", \"pending\"]");
-    }
+        // Money fields need a well-formed currency on a numeric type
+        for var in self.inputs.iter().chain(self.outputs.iter()) {
+            if let Some(currency) = &var.currency {
+                if currency.len() != 3 || !currency.chars().all(|c| c.is_ascii_uppercase()) {
+                    errors.push(format!(
+                        "Variable '{}' has invalid currency code '{}' (expected ISO 4217, e.g. USD)",
+                        var.name, currency
+                    ));
+                }
+                if !matches!(var.typ, VarType::Int | VarType::Float) {
+                    errors.push(format!(
+                        "Variable '{}' declares a currency but is not numeric",
+                        var.name
+                    ));
+                }
+                if var.unit.is_some() {
+                    errors.push(format!(
+                        "Variable '{}' cannot declare both a unit and a currency",
+                        var.name
+                    ));
+                }
+            }
+        }
 
-    #[test]
-    fn test_when_clause_single() {
-        let clause = WhenClause::Single("role == 'admin'".into());
-        assert_eq!(clause.to_cel(), "role == 'admin'");
-    }
+        // Optional inputs must be read through is_present()/coalesce()
+        let optional: Vec<&str> = self
+            .inputs
+            .iter()
+            .filter(|i| i.optional)
+            .map(|i| i.name.as_str())
+            .collect();
+        if !optional.is_empty() {
+            for rule in &self.rules {
+                let exprs = rule
+                    .as_cel()
+                    .into_iter()
+                    .chain(rule.vars.iter().map(|b| b.expr.clone()));
+                for cel in exprs {
+                    let unguarded = crate::cel::CelCompiler::unguarded_references(&cel, &optional)
+                        .unwrap_or_default();
+                    for name in unguarded {
+                        errors.push(format!(
+                            "Rule {} reads optional input '{}' directly; use is_present({}) or coalesce({}, ...)",
+                            rule.id, name, name, name
+                        ));
+                    }
+                }
+            }
+        }
 
-    #[test]
-    fn test_when_clause_multiple() {
-        let clause = WhenClause::Multiple(vec!["role == 'member'".into(), "verified".into()]);
-        assert_eq!(clause.to_cel(), "(role == 'member') && (verified)");
-    }
+        // bucket() hashes the key's text, which string and int inputs spell
+        // the same in every target
+        for rule in &self.rules {
+            let exprs = rule
+                .as_cel()
+                .into_iter()
+                .chain(rule.vars.iter().map(|b| b.expr.clone()));
+            for cel in exprs {
+                let calls = crate::cel::CelCompiler::bucket_calls(&cel).unwrap_or_default();
+                for (key, n) in calls {
+                    if !n.is_some_and(|n| n > 0) {
+                        errors.push(format!(
+                            "Rule {} bucket() takes a key and a positive integer bucket count",
+                            rule.id
+                        ));
+                    }
+                    let input = key.and_then(|k| self.inputs.iter().find(|i| i.name == k));
+                    if !input.is_some_and(|i| {
+                        matches!(i.typ, VarType::String | VarType::Int | VarType::Enum(_))
+                    }) {
+                        errors.push(format!(
+                            "Rule {} must bucket on a string or int input",
+                            rule.id
+                        ));
+                    }
+                }
+            }
+        }
 
-    #[test]
-    fn test_when_clause_multiple_single_item() {
-        let clause = WhenClause::Multiple(vec!["role == 'admin'".into()]);
-        assert_eq!(clause.to_cel(), "role == 'admin'");
+        // Lookup tables hold scalars under unique names; lookups name one
+        let mut table_names = std::collections::HashSet::new();
+        for table in &self.tables {
+            if !table_names.insert(table.name.as_str()) {
+                errors.push(format!("Duplicate table: {}", table.name));
+            }
+            if !matches!(
+                table.typ,
+                VarType::Bool | VarType::Int | VarType::Float | VarType::String
+            ) {
+                errors.push(format!(
+                    "Table '{}' must hold bool, int, float or string values",
+                    table.name
+                ));
+            }
+        }
+        let mut exprs: Vec<(String, String)> = Vec::new();
+        let mut output_exprs = |owner: &str, output: &Output| {
+            let values: Vec<&ConditionValue> = match output {
+                Output::Single(ConditionValue::Map(map)) | Output::Named(map) => {
+                    map.values().collect()
+                }
+                Output::Single(value) => vec![value],
+            };
+            for value in values {
+                if let ConditionValue::String(s) = value {
+                    if crate::render::is_expression_calling(s, &self.functions) {
+                        exprs.push((owner.to_string(), s.clone()));
+                    }
+                }
+            }
+        };
+        for rule in &self.rules {
+            output_exprs(&format!("Rule {}", rule.id), &rule.then);
+        }
+        if let Some(default) = &self.default {
+            output_exprs("Default", default);
+        }
+        for rule in &self.rules {
+            let owner = format!("Rule {}", rule.id);
+            for cel in rule
+                .as_cel()
+                .into_iter()
+                .chain(rule.vars.iter().map(|b| b.expr.clone()))
+            {
+                exprs.push((owner.clone(), cel));
+            }
+        }
+        for (owner, expr) in &exprs {
+            let calls = crate::cel::CelCompiler::lookup_calls(expr).unwrap_or_default();
+            for (table, key, arity) in calls {
+                let table = table.and_then(|t| self.tables.iter().find(|x| x.name == t));
+                let Some(table) = table.filter(|_| arity == 3) else {
+                    errors.push(format!(
+                        "{} lookup() takes a declared table's name, a key and a fallback",
+                        owner
+                    ));
+                    continue;
+                };
+                let Some(input) = key.and_then(|k| self.inputs.iter().find(|i| i.name == k)) else {
+                    continue;
+                };
+                match &input.typ {
+                    VarType::String => {}
+                    // Rows already loaded must cover every value of an enum key
+                    VarType::Enum(variants) if !table.rows.is_empty() => {
+                        for variant in variants {
+                            if !table.rows.contains_key(variant) {
+                                errors.push(format!(
+                                    "Warning: Table '{}' has no row for '{}' of input '{}'",
+                                    table.name, variant, input.name
+                                ));
+                            }
+                        }
+                    }
+                    VarType::Enum(_) => {}
+                    _ => errors.push(format!(
+                        "{} looks up '{}' in '{}', but keys are strings",
+                        owner, input.name, table.name
+                    )),
+                }
+            }
+        }
+
+        // Math functions compute on floats (Go's math package, Rust's f64
+        // methods), so inputs passed to them must be floats
+        for (owner, expr) in &exprs {
+            let calls = crate::cel::CelCompiler::math_calls(expr).unwrap_or_default();
+            for (name, arity, idents) in calls {
+                let expected = crate::cel::MATH_FUNCTIONS
+                    .iter()
+                    .find(|(f, _)| *f == name)
+                    .map_or(0, |(_, n)| *n);
+                if arity != expected {
+                    errors.push(format!(
+                        "{} {}() takes {} argument(s), not {}",
+                        owner, name, expected, arity
+                    ));
+                }
+                for ident in idents {
+                    let Some(input) = self.inputs.iter().find(|i| i.name == ident) else {
+                        continue;
+                    };
+                    if input.typ != VarType::Float {
+                        errors.push(format!(
+                            "{} {}() takes floats, but input '{}' is not a float",
+                            owner, name, input.name
+                        ));
+                    }
+                }
+            }
+        }
+
+        // Aggregates loop over a list input, reading its items as numbers
+        // or one field of each when the items are objects
+        for (owner, expr) in &exprs {
+            let calls = crate::cel::CelCompiler::aggregate_calls(expr).unwrap_or_default();
+            for (name, arity, list) in calls {
+                if arity != 1 {
+                    errors.push(format!(
+                        "{} {}() takes 1 argument, not {}",
+                        owner, name, arity
+                    ));
+                    continue;
+                }
+                let Some((list, field)) = list else {
+                    errors.push(format!(
+                        "{} {}() takes a list input or a field of one (items.weight)",
+                        owner, name
+                    ));
+                    continue;
+                };
+                let item = self
+                    .inputs
+                    .iter()
+                    .find(|i| i.name == list)
+                    .and_then(|i| match &i.typ {
+                        VarType::List(item) => Some(item.as_ref()),
+                        _ => None,
+                    });
+                match (item, field) {
+                    (None, _) => errors.push(format!(
+                        "{} {}() takes a list input, but '{}' is not one",
+                        owner, name, list
+                    )),
+                    (Some(VarType::Object), None) if name != "count" => errors.push(format!(
+                        "{} {}() over '{}' needs a field of its items ({}.<field>)",
+                        owner, name, list, list
+                    )),
+                    (Some(VarType::String | VarType::Bool | VarType::Enum(_)), None)
+                        if name != "count" =>
+                    {
+                        errors.push(format!(
+                            "{} {}() takes numbers, but the items of '{}' are not numbers",
+                            owner, name, list
+                        ))
+                    }
+                    (Some(item), Some(field)) if *item != VarType::Object => errors.push(format!(
+                        "{} {}() reads '{}' of the items of '{}', which are not objects",
+                        owner, name, field, list
+                    )),
+                    _ => {}
+                }
+            }
+        }
+
+        // format() builds a string from the scalar inputs and bindings its
+        // placeholders name; it and project functions must return the type
+        // of the output they compute
+        let bindings: Vec<&str> = self
+            .rules
+            .iter()
+            .flat_map(|r| r.vars.iter().map(|b| b.name.as_str()))
+            .collect();
+        for (owner, expr) in &exprs {
+            let calls = crate::cel::CelCompiler::format_calls(expr).unwrap_or_default();
+            for (template, arity) in calls {
+                let Some(template) = template.filter(|_| arity == 1) else {
+                    errors.push(format!("{} format() takes one string template", owner));
+                    continue;
+                };
+                let parts = match crate::cel::CelCompiler::parse_format(&template) {
+                    Ok(parts) => parts,
+                    Err(crate::error::Error::CelParse(reason)) => {
+                        errors.push(format!("{} format() template {}", owner, reason));
+                        continue;
+                    }
+                    Err(e) => {
+                        errors.push(format!("{} format(): {}", owner, e));
+                        continue;
+                    }
+                };
+                for part in parts {
+                    let crate::cel::FormatPart::Placeholder(name) = part else {
+                        continue;
+                    };
+                    match self.inputs.iter().find(|i| i.name == name) {
+                        Some(input) if matches!(input.typ, VarType::Object | VarType::List(_)) => {
+                            errors.push(format!(
+                                "{} format() cannot format '{}', which is an object or list",
+                                owner, name
+                            ))
+                        }
+                        Some(_) => {}
+                        None if bindings.contains(&name.as_str()) => {}
+                        None => errors.push(format!(
+                            "{} format() placeholder '{{{}}}' is not an input",
+                            owner, name
+                        )),
+                    }
+                }
+            }
+        }
+        let mut check_returns = |owner: &str, output: &Output| {
+            let values: Vec<(Option<&str>, &ConditionValue)> = match output {
+                Output::Single(ConditionValue::Map(map)) | Output::Named(map) => {
+                    map.iter().map(|(k, v)| (Some(k.as_str()), v)).collect()
+                }
+                Output::Single(value) => vec![(None, value)],
+            };
+            for (name, value) in values {
+                let ConditionValue::String(s) = value else {
+                    continue;
+                };
+                let output = match name {
+                    Some(name) => self.outputs.iter().find(|o| o.name == name),
+                    None => self.outputs.first(),
+                };
+                let Some(output) = output else {
+                    continue;
+                };
+                let call = s.trim_start();
+                if call.starts_with("format(") && output.typ != VarType::String {
+                    errors.push(format!(
+                        "{} format() builds a string, but output '{}' is not a string",
+                        owner, output.name
+                    ));
+                }
+                for function in &self.functions {
+                    let widens = function.returns == VarType::Int && output.typ == VarType::Float;
+                    if call.starts_with(&format!("{}(", function.name))
+                        && output.typ != function.returns
+                        && !widens
+                    {
+                        errors.push(format!(
+                            "{} {}() returns {}, but output '{}' is {}",
+                            owner,
+                            function.name,
+                            crate::compat::type_name(&function.returns),
+                            output.name,
+                            crate::compat::type_name(&output.typ)
+                        ));
+                    }
+                }
+            }
+        };
+        for rule in &self.rules {
+            check_returns(&format!("Rule {}", rule.id), &rule.then);
+        }
+        if let Some(default) = &self.default {
+            check_returns("Default", default);
+        }
+
+        // Zones list known countries or regions, and share no country
+        let mut zone_names = std::collections::HashSet::new();
+        let mut zone_of: HashMap<&str, &str> = HashMap::new();
+        for zone in &self.zones {
+            if !zone_names.insert(zone.name.as_str()) {
+                errors.push(format!("Duplicate zone: {}", zone.name));
+            }
+            for member in &zone.countries {
+                let Some(codes) = crate::geo::expand(member) else {
+                    errors.push(format!(
+                        "Zone '{}' lists '{}', which is neither an ISO country code nor a region",
+                        zone.name, member
+                    ));
+                    continue;
+                };
+                for code in codes {
+                    match zone_of.insert(code, zone.name.as_str()) {
+                        Some(other) if other != zone.name => errors.push(format!(
+                            "Country '{}' is in zones '{}' and '{}'",
+                            code, other, zone.name
+                        )),
+                        _ => {}
+                    }
+                }
+            }
+        }
+        for (owner, expr) in &exprs {
+            let calls = crate::cel::CelCompiler::geo_calls(expr).unwrap_or_default();
+            for (name, arity) in calls {
+                if arity != 1 {
+                    errors.push(format!("{} {}() takes a country code", owner, name));
+                } else if name == "zone" && self.zones.is_empty() {
+                    errors.push(format!("{} zone() needs zones declared in the spec", owner));
+                }
+            }
+        }
+        let geo_tables = self.geo_tables();
+        for table in &self.tables {
+            if geo_tables.iter().any(|t| t.name == table.name) {
+                errors.push(format!(
+                    "Table '{}' clashes with the built-in table of {}()",
+                    table.name, table.name
+                ));
+            }
+        }
+
+        // Project functions are called with their declared parameters
+        for function in &self.functions {
+            if function.go_call().is_none() {
+                errors.push(format!(
+                    "Function '{}' go '{}' is not an import path and exported function",
+                    function.name, function.go
+                ));
+            }
+            for (owner, expr) in &exprs {
+                let calls = crate::cel::CelCompiler::function_calls(expr, &function.name)
+                    .unwrap_or_default();
+                for args in calls {
+                    if args.len() != function.params.len() {
+                        errors.push(format!(
+                            "{} {}() takes {} argument(s), not {}",
+                            owner,
+                            function.name,
+                            function.params.len(),
+                            args.len()
+                        ));
+                        continue;
+                    }
+                    for (i, (arg, param)) in args.iter().zip(&function.params).enumerate() {
+                        let input = arg
+                            .as_ref()
+                            .and_then(|a| self.inputs.iter().find(|input| &input.name == a));
+                        let Some(input) = input else {
+                            continue;
+                        };
+                        let widens = input.typ == VarType::Int && *param == VarType::Float;
+                        if input.typ != *param && !widens {
+                            errors.push(format!(
+                                "{} {}() argument {} is {}, but input '{}' is {}",
+                                owner,
+                                function.name,
+                                i + 1,
+                                crate::compat::type_name(param),
+                                input.name,
+                                crate::compat::type_name(&input.typ)
+                            ));
+                        }
+                    }
+                }
+            }
+        }
+
+        // Extension points name rules of this spec, or its default
+        for point in &self.extension_points {
+            if point != crate::overlay::DEFAULT_POINT && !self.rules.iter().any(|r| &r.id == point)
+            {
+                errors.push(format!(
+                    "Extension point '{}' is neither a rule nor the default",
+                    point
+                ));
+            }
+        }
+
+        // PY-2: Warn if no default rule (exhaustiveness not guaranteed)
+        if self.default.is_none() && !self.rules.is_empty() {
+            errors.push("Warning: No default rule - exhaustiveness not guaranteed".into());
+        }
+
+        errors
+    }
+
+    /// Rules in the order generated code tries them
+    ///
+    /// Without explicit priorities this is the YAML order. Once any rule sets
+    /// a priority, rules are ordered by priority and then by ID, so the
+    /// result does not depend on how the file happens to be arranged.
+    /// With `resolution: most_specific`, more specific rules come first and
+    /// the above only breaks ties, so first-match code picks the most
+    /// specific matching rule.
+    pub fn evaluation_order(&self) -> Vec<&Rule> {
+        let mut rules: Vec<&Rule> = self.rules.iter().collect();
+        let prioritized = rules.windows(2).any(|w| w[0].priority != w[1].priority);
+        if prioritized {
+            rules.sort_by(|a, b| a.priority.cmp(&b.priority).then_with(|| a.id.cmp(&b.id)));
+        }
+        if self.resolution == Resolution::MostSpecific {
+            rules.sort_by_key(|r| std::cmp::Reverse(r.specificity()));
+        }
+        rules
+    }
+
+    /// The spec as code generation sees it: draft and retired rules
+    /// removed, and instrumentation off in a lean variant
+    pub fn for_codegen(&self) -> std::borrow::Cow<'_, Spec> {
+        if self.rules.iter().all(|r| r.state.is_active()) && !self.codegen.lean {
+            return std::borrow::Cow::Borrowed(self);
+        }
+        let mut spec = self.clone();
+        spec.rules.retain(|r| r.state.is_active());
+        if spec.codegen.lean {
+            spec.codegen.counters = false;
+            spec.codegen.logging = false;
+            spec.codegen.service = false;
+            spec.codegen.batch = false;
+            spec.codegen.stream = None;
+        }
+        std::borrow::Cow::Owned(spec)
+    }
+
+    /// The variant rendered for `codegen.lean_build`, if the spec has one;
+    /// it hashes like the spec, since it decides the same way
+    pub fn lean_variant(&self) -> Option<Spec> {
+        self.codegen.lean_build.as_ref()?;
+        let mut spec = self.clone();
+        spec.codegen.lean = true;
+        Some(spec)
+    }
+
+    /// Rules missing `owner` or `approved_by`, one message per missing field
+    pub fn ownership_issues(&self) -> Vec<String> {
+        let mut issues = Vec::new();
+        for rule in &self.rules {
+            if rule.owner.as_deref().is_none_or(|o| o.trim().is_empty()) {
+                issues.push(format!("rule {} has no owner", rule.id));
+            }
+            if rule
+                .approved_by
+                .as_deref()
+                .is_none_or(|a| a.trim().is_empty())
+            {
+                issues.push(format!("rule {} has no approved_by", rule.id));
+            }
+        }
+        issues
+    }
+
+    /// Default-language messages by message key, for localization
+    ///
+    /// A rule's description is its message, falling back to its reason code
+    /// and then its ID.
+    pub fn message_catalog(&self) -> BTreeMap<String, String> {
+        self.evaluation_order()
+            .into_iter()
+            .filter_map(|rule| {
+                let key = rule.message_key.clone()?;
+                let text = rule
+                    .description
+                    .clone()
+                    .or_else(|| rule.reason_code.clone())
+                    .unwrap_or_else(|| rule.id.clone());
+                Some((key, text))
+            })
+            .collect()
+    }
+
+    /// Messages for every postcondition violated by a result
+    ///
+    /// `values` binds both the inputs and the outputs by name.
+    pub fn postcondition_violations(
+        &self,
+        values: &HashMap<String, crate::cel::CelValue>,
+    ) -> Result<Vec<String>> {
+        let mut violations = Vec::new();
+        for postcondition in &self.postconditions {
+            if !crate::cel::CelCompiler::eval_bool(&postcondition.to_cel(), values)? {
+                violations.push(postcondition.violation_message());
+            }
+        }
+        Ok(violations)
+    }
+
+    /// Why a rule did not match an input: one message per failed conjunct
+    ///
+    /// An empty result means the rule's own condition holds; an earlier
+    /// rule may still have won.
+    pub fn explain(
+        &self,
+        rule_id: &str,
+        input: &HashMap<String, crate::cel::CelValue>,
+    ) -> Result<Vec<String>> {
+        let rule = self
+            .rules
+            .iter()
+            .find(|r| r.id == rule_id)
+            .ok_or_else(|| Error::Other(format!("Unknown rule: {}", rule_id)))?;
+
+        let mut failures = Vec::new();
+        for conjunct in rule.conjuncts() {
+            if crate::cel::CelCompiler::eval_bool(&conjunct, input)? {
+                continue;
+            }
+            let mut observed = Vec::new();
+            for name in crate::cel::CelCompiler::extract_variables(&conjunct)? {
+                if let Some(value) = input.get(&name) {
+                    observed.push(format!("{} was {}", name, describe_value(value)));
+                }
+            }
+            if observed.is_empty() {
+                failures.push(format!("{} did not match: {}", rule.id, conjunct));
+            } else {
+                failures.push(format!(
+                    "{} did not match: {} ({})",
+                    rule.id,
+                    conjunct,
+                    observed.join(", ")
+                ));
+            }
+        }
+        Ok(failures)
+    }
+
+    /// Messages for every constraint the given input violates
+    pub fn constraint_violations(
+        &self,
+        input: &HashMap<String, crate::cel::CelValue>,
+    ) -> Result<Vec<String>> {
+        let mut violations = Vec::new();
+        for constraint in &self.constraints {
+            if !crate::cel::CelCompiler::eval_bool(&constraint.to_cel(), input)? {
+                violations.push(constraint.violation_message());
+            }
+        }
+        Ok(violations)
+    }
+}
+
+impl Rule {
+    /// Who owns and approved the rule, e.g. `owner pricing-team, approved by
+    /// j.doe`; None when neither is set
+    pub fn ownership(&self) -> Option<String> {
+        let owner = self.owner.as_deref().map(str::trim);
+        let approver = self.approved_by.as_deref().map(str::trim);
+        let parts: Vec<String> = [("owner", owner), ("approved by", approver)]
+            .into_iter()
+            .filter_map(|(label, who)| {
+                who.filter(|w| !w.is_empty())
+                    .map(|w| format!("{} {}", label, w))
+            })
+            .collect();
+        (!parts.is_empty()).then(|| parts.join(", "))
+    }
+
+    /// Number of conjuncts the rule's condition requires
+    pub fn specificity(&self) -> usize {
+        match (&self.when, &self.conditions) {
+            (Some(WhenClause::Multiple(exprs)), _) => exprs
+                .iter()
+                .map(|e| crate::cel::CelCompiler::conjunct_count(e).unwrap_or(1))
+                .sum(),
+            (Some(WhenClause::Single(expr)), _) => {
+                crate::cel::CelCompiler::conjunct_count(expr).unwrap_or(1)
+            }
+            (None, Some(conditions)) => conditions.len(),
+            (None, None) => 0,
+        }
+    }
+
+    /// The rule's condition split into the CEL conjuncts that must all hold
+    pub fn conjuncts(&self) -> Vec<String> {
+        let split = |expr: &String| {
+            crate::cel::CelCompiler::conjuncts(expr).unwrap_or_else(|_| vec![expr.clone()])
+        };
+        match (&self.when, &self.conditions) {
+            (Some(WhenClause::Single(expr)), _) => split(expr),
+            (Some(WhenClause::Multiple(exprs)), _) => exprs.iter().flat_map(split).collect(),
+            (None, Some(conditions)) => conditions.iter().map(|c| c.to_cel()).collect(),
+            (None, None) => Vec::new(),
+        }
+    }
+
+    /// Get condition as CEL expression
+    pub fn as_cel(&self) -> Option<String> {
+        if let Some(when_clause) = &self.when {
+            return Some(when_clause.to_cel());
+        }
+
+        // Convert structured conditions to CEL
+        if let Some(conditions) = &self.conditions {
+            if conditions.is_empty() {
+                return None;
+            }
+            let parts: Vec<String> = conditions.iter().map(|c| c.to_cel()).collect();
+            return Some(parts.join(" && "));
+        }
+
+        None
+    }
+}
+
+impl Condition {
+    /// Convert to CEL expression fragment
+    pub fn to_cel(&self) -> String {
+        let val_str = match &self.value {
+            ConditionValue::Bool(b) => b.to_string(),
+            ConditionValue::Int(i) => i.to_string(),
+            ConditionValue::Float(f) => f.to_string(),
+            ConditionValue::String(s) => format!("\"{}\"", s),
+            ConditionValue::List(items) => {
+                let inner: Vec<_> = items
+                    .iter()
+                    .map(|v| match v {
+                        ConditionValue::String(s) => format!("\"{}\"", s),
+                        ConditionValue::Int(i) => i.to_string(),
+                        _ => "?".to_string(),
+                    })
+                    .collect();
+                format!("[{}]", inner.join(", "))
+            }
+            _ => "?".to_string(),
+        };
+
+        match self.op {
+            ConditionOp::Eq => format!("{} == {}", self.var, val_str),
+            ConditionOp::Ne => format!("{} != {}", self.var, val_str),
+            ConditionOp::Lt => format!("{} < {}", self.var, val_str),
+            ConditionOp::Le => format!("{} <= {}", self.var, val_str),
+            ConditionOp::Gt => format!("{} > {}", self.var, val_str),
+            ConditionOp::Ge => format!("{} >= {}", self.var, val_str),
+            ConditionOp::In => format!("{} in {}", self.var, val_str),
+            ConditionOp::Contains => format!("{}.contains({})", self.var, val_str),
+            ConditionOp::StartsWith => format!("{}.startsWith({})", self.var, val_str),
+            ConditionOp::EndsWith => format!("{}.endsWith({})", self.var, val_str),
+            ConditionOp::Matches => format!("{}.matches({})", self.var, val_str),
+        }
+    }
+}
+
+/// Render an input value for explanations (strings quoted CEL-style)
+fn describe_value(value: &crate::cel::CelValue) -> String {
+    use crate::cel::CelValue;
+    match value {
+        CelValue::String(s) => format!("'{}'", s),
+        CelValue::Int(i) => i.to_string(),
+        CelValue::UInt(u) => u.to_string(),
+        CelValue::Float(f) => f.to_string(),
+        CelValue::Bool(b) => b.to_string(),
+        CelValue::Null => "null".to_string(),
+        other => format!("{:?}", other),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_yaml() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: x
+    type: bool
+outputs:
+  - name: result
+    type: int
+rules:
+  - id: R1
+    when: "x"
+    then: 1
+  - id: R2
+    when: "!x"
+    then: 0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.id, "test_spec");
+        assert_eq!(spec.inputs.len(), 1);
+        assert_eq!(spec.rules.len(), 2);
+    }
+
+    #[test]
+    fn test_validate() {
+        let spec = Spec {
+            id: "".into(),
+            name: None,
+            description: None,
+            inputs: vec![],
+            outputs: vec![],
+            rules: vec![],
+            default: None,
+            meta: SpecMeta::default(),
+            scoping: None,
+            codegen: Default::default(),
+            constraints: Vec::new(),
+            postconditions: Vec::new(),
+            resolution: Default::default(),
+            cache: None,
+            normalize: Vec::new(),
+            assertions: Vec::new(),
+            tables: Vec::new(),
+            zones: Vec::new(),
+            constants: Vec::new(),
+            extends: None,
+            extension_points: Vec::new(),
+            functions: Vec::new(),
+            source: None,
+        };
+
+        let errors = spec.validate();
+        assert!(errors.iter().any(|e| e.contains("ID")));
+        assert!(errors.iter().any(|e| e.contains("input")));
+        assert!(errors.iter().any(|e| e.contains("rule")));
+    }
+
+    #[test]
+    fn test_optional_input_marker() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: member_tier?
+    type: string
+  - name: weight
+    type: float
+outputs:
+  - name: rate
+    type: float
+codegen:
+  nullable: sql_null
+rules:
+  - id: R1
+    when: "coalesce(member_tier, '') == 'gold'"
+    then: 1.0
+  - id: R2
+    when: "member_tier == 'silver'"
+    then: 2.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.inputs[0].name, "member_tier");
+        assert!(spec.inputs[0].optional);
+        assert!(!spec.inputs[1].optional);
+        assert_eq!(spec.codegen.nullable, NullableStyle::SqlNull);
+
+        let errors = spec.validate();
+        assert!(!errors.iter().any(|e| e.starts_with("Rule R1")));
+        assert!(errors
+            .iter()
+            .any(|e| e.starts_with("Rule R2 reads optional input 'member_tier'")));
+    }
+
+    #[test]
+    fn test_input_defaults() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: priority
+    type: bool
+    default: false
+  - name: zone
+    type: string
+    values: [domestic, international]
+    default: mars
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "priority"
+    then: 1.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.inputs[0].default, Some(ConditionValue::Bool(false)));
+
+        let errors = spec.validate();
+        assert!(!errors.iter().any(|e| e.contains("'priority'")));
+        assert!(errors.iter().any(|e| e.contains("Input 'zone' default")));
+    }
+
+    #[test]
+    fn test_rule_bindings() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: weight_kg
+    type: float
+  - name: surcharge
+    type: float
+outputs:
+  - name: price
+    type: float
+rules:
+  - id: R1
+    when: "weight_kg > 10.0"
+    vars:
+      - name: base
+        expr: "weight_kg * 8.0"
+      - name: surcharge
+        expr: "2.0"
+      - name: spare
+        expr: "base * 2.0"
+    then: "base + surcharge"
+  - id: R2
+    when: "base > 1.0"
+    vars:
+      - name: base
+        expr: "weight_kg +"
+    then: 1.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.rules[0].vars.len(), 3);
+        assert_eq!(spec.rules[0].vars[0].expr, "weight_kg * 8.0");
+
+        let errors = spec.validate();
+        assert!(errors
+            .iter()
+            .any(|e| e.contains("'surcharge' shadows an input")));
+        assert!(errors
+            .iter()
+            .any(|e| e == "Rule R1 binding 'spare' is never used"));
+        assert!(!errors
+            .iter()
+            .any(|e| e.contains("binding 'base' is never used")));
+        assert!(errors
+            .iter()
+            .any(|e| e.contains("Rule R2 binding 'base' is not a valid expression")));
+        assert!(errors
+            .iter()
+            .any(|e| e.contains("Rule R2 condition cannot use binding 'base'")));
+    }
+
+    #[test]
+    fn test_constraints() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: priority
+    type: bool
+  - name: zone
+    type: string
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+constraints:
+  - id: C1
+    when: "priority"
+    require: "zone != ''"
+    message: priority shipments need a zone
+  - id: C2
+    when: "zone == 'international'"
+    require: "weight_kg > 0.0"
+  - id: C3
+    require: "volume > 0.0"
+rules:
+  - id: R1
+    when: "priority"
+    then: 1.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.constraints[0].to_cel(), "!(priority) || (zone != '')");
+
+        let errors = spec.validate();
+        assert!(errors
+            .iter()
+            .any(|e| e.starts_with("Constraint C3") && e.contains("'volume'")));
+        assert!(!errors.iter().any(|e| e.starts_with("Constraint C1")));
+
+        let input: HashMap<String, crate::cel::CelValue> = [
+            ("priority".to_string(), true.into()),
+            ("zone".to_string(), "international".into()),
+            ("weight_kg".to_string(), 0.0.into()),
+        ]
+        .into_iter()
+        .collect();
+        let mut spec = spec;
+        spec.constraints.pop();
+        assert_eq!(
+            spec.constraint_violations(&input).unwrap(),
+            vec!["C2: zone == 'international' requires weight_kg > 0.0".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_postconditions() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: shipping_cost
+    type: float
+postconditions:
+  - id: P1
+    require: "shipping_cost >= 0.0"
+  - id: P2
+    when: "weight_kg > 10.0"
+    require: "shipping_cost >= 20.0"
+    message: heavy parcels cost at least 20
+  - id: P3
+    require: "discount <= shipping_cost"
+rules:
+  - id: R1
+    when: "weight_kg > 0.0"
+    then: 5.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        let errors = spec.validate();
+        assert!(errors.iter().any(|e| e.starts_with("Postcondition P3")));
+        assert!(!errors.iter().any(|e| e.starts_with("Postcondition P1")));
+
+        let values: HashMap<String, crate::cel::CelValue> = [
+            ("weight_kg".to_string(), 12.0.into()),
+            ("shipping_cost".to_string(), 5.0.into()),
+        ]
+        .into_iter()
+        .collect();
+        let mut spec = spec;
+        spec.postconditions.pop();
+        assert_eq!(
+            spec.postcondition_violations(&values).unwrap(),
+            vec!["P2: heavy parcels cost at least 20".to_string()]
+        );
+    }
+
+    #[test]
+    fn test_evaluation_order() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: tier
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R3
+    when: "tier == 'gold'"
+    then: 1.0
+  - id: R1
+    when: "tier == 'silver'"
+    then: 2.0
+  - id: R2
+    when: "tier != ''"
+    then: 3.0
+"#;
+        let mut spec = Spec::from_yaml(yaml).unwrap();
+        let ids = |spec: &Spec| -> Vec<String> {
+            spec.evaluation_order()
+                .iter()
+                .map(|r| r.id.clone())
+                .collect()
+        };
+        // No explicit priorities: file order
+        assert_eq!(ids(&spec), vec!["R3", "R1", "R2"]);
+
+        // Priority first, then ID as the tiebreaker
+        spec.rules[2].priority = 1;
+        assert_eq!(ids(&spec), vec!["R1", "R3", "R2"]);
+        spec.rules[2].priority = -1;
+        assert_eq!(ids(&spec), vec!["R2", "R1", "R3"]);
+    }
+
+    #[test]
+    fn test_most_specific_resolution() {
+        let yaml = r#"
+id: test_spec
+resolution: most_specific
+inputs:
+  - name: tier
+    type: string
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+  - id: R2
+    when:
+      - "tier == 'gold'"
+      - "zone == 'domestic'"
+    then: 2.0
+  - id: R3
+    conditions:
+      - var: tier
+        value: gold
+    then: 4.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.resolution, Resolution::MostSpecific);
+        assert_eq!(spec.rules[1].specificity(), 2);
+
+        let ids: Vec<&str> = spec
+            .evaluation_order()
+            .iter()
+            .map(|r| r.id.as_str())
+            .collect();
+        assert_eq!(ids, vec!["R2", "R1", "R3"]);
+        assert!(!spec.to_yaml().unwrap().contains("first_match"));
+    }
+
+    #[test]
+    fn test_rule_tags_and_lines() {
+        let yaml = r#"id: test_spec
+inputs:
+  - name: id
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "id == 'R2'"
+    tags: [pricing, promo]
+    then: 1.0
+  - when: "id == 'x'"
+    id: "R2"
+    then: 2.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.rules[0].tags, vec!["pricing", "promo"]);
+        assert_eq!(spec.rules[0].line, Some(9));
+        assert_eq!(spec.rules[1].line, Some(14));
+        assert!(!spec.to_yaml().unwrap().contains("line"));
+    }
+
+    #[test]
+    fn test_condition_to_cel() {
+        let cond = Condition {
+            var: "amount".into(),
+            op: ConditionOp::Gt,
+            value: ConditionValue::Int(1000),
+        };
+        assert_eq!(cond.to_cel(), "amount > 1000");
+
+        let cond2 = Condition {
+            var: "status".into(),
+            op: ConditionOp::In,
+            value: ConditionValue::List(vec![
+                ConditionValue::String("active".into()),
+                ConditionValue::String("pending".into()),
+            ]),
+        };
+        assert_eq!(cond2.to_cel(), "status in [\"active\", \"pending\"]");
+    }
+
+    #[test]
+    fn test_when_clause_single() {
+        let clause = WhenClause::Single("role == 'admin'".into());
+        assert_eq!(clause.to_cel(), "role == 'admin'");
+    }
+
+    #[test]
+    fn test_when_clause_multiple() {
+        let clause = WhenClause::Multiple(vec!["role == 'member'".into(), "verified".into()]);
+        assert_eq!(clause.to_cel(), "(role == 'member') && (verified)");
+    }
+
+    #[test]
+    fn test_when_clause_multiple_single_item() {
+        let clause = WhenClause::Multiple(vec!["role == 'admin'".into()]);
+        assert_eq!(clause.to_cel(), "role == 'admin'");
+    }
+
+    #[test]
+    fn test_when_clause_multiple_empty() {
+        let clause = WhenClause::Multiple(vec![]);
+        assert_eq!(clause.to_cel(), "true");
+    }
+
+    #[test]
+    fn test_parse_yaml_with_array_when() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: role
+    type: string
+  - name: verified
+    type: bool
+outputs:
+  - name: level
+    type: int
+rules:
+  - id: R1
+    when:
+      - role == 'admin'
+    then: 100
+  - id: R2
+    when:
+      - role == 'member'
+      - verified
+    then: 50
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.id, "test_spec");
+        assert_eq!(spec.rules.len(), 2);
+
+        // R1 has single condition in array
+        assert_eq!(spec.rules[0].as_cel(), Some("role == 'admin'".into()));
+
+        // R2 has multiple conditions
+        assert_eq!(
+            spec.rules[1].as_cel(),
+            Some("(role == 'member') && (verified)".into())
+        );
+    }
+
+    #[test]
+    fn test_parse_yaml_with_string_when() {
+        // Ensure backward compatibility with string when
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: x
+    type: bool
+outputs:
+  - name: result
+    type: int
+rules:
+  - id: R1
+    when: "x && y"
+    then: 1
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.rules[0].as_cel(), Some("x && y".into()));
+    }
+
+    #[test]
+    fn test_message_catalog() {
+        let yaml = r#"
+id: loan_decision
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score < 500"
+    then: false
+    reason_code: LOW_SCORE
+    message_key: loan.low_score
+    description: Credit score below 500
+  - id: R2
+    when: "score < 600"
+    then: false
+    reason_code: REVIEW
+  - id: R3
+    when: "score >= 600"
+    then: true
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.rules[1].reason_code.as_deref(), Some("REVIEW"));
+        assert_eq!(
+            spec.message_catalog(),
+            BTreeMap::from([(
+                "loan.low_score".to_string(),
+                "Credit score below 500".to_string()
+            )])
+        );
+    }
+
+    #[test]
+    fn test_rule_states() {
+        let yaml = r#"
+id: loan_decision
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score < 500"
+    then: false
+    state: retired
+  - id: R2
+    when: "score < 550"
+    then: false
+    state: draft
+  - id: R3
+    when: "score >= 600"
+    then: true
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.rules[0].state, RuleState::Retired);
+        assert_eq!(spec.rules[2].state, RuleState::Active);
+
+        let generated = spec.for_codegen();
+        let ids: Vec<&str> = generated.rules.iter().map(|r| r.id.as_str()).collect();
+        assert_eq!(ids, vec!["R3"]);
+        assert!(!spec.to_yaml().unwrap().contains("state: active"));
     }
 
     #[test]
-    fn test_when_clause_multiple_empty() {
-        let clause = WhenClause::Multiple(vec![]);
-        assert_eq!(clause.to_cel(), "true");
+    fn test_normalization() {
+        let yaml = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: tier
+    type: string
+  - name: weight_kg
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 1.0
+default: 0.0
+normalize:
+  - input: zone
+    trim: true
+    lowercase: true
+  - input: tier
+    map:
+      plat: platinum
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert!(spec.validate().is_empty());
+        assert_eq!(spec.normalize[0].apply("  Domestic "), "domestic");
+        assert_eq!(spec.normalize[1].apply("plat"), "platinum");
+        assert_eq!(spec.normalize[1].apply("gold"), "gold");
+
+        let bad = Spec::from_yaml(&yaml.replace("input: tier", "input: weight_kg").replace(
+            "    lowercase: true\n",
+            "    lowercase: true\n    uppercase: true\n",
+        ))
+        .unwrap();
+        let errors = bad.validate();
+        assert!(errors
+            .contains(&"Input 'weight_kg' is not a string and cannot be normalized".to_string()));
+        assert!(errors.contains(
+            &"Normalization of 'zone' cannot be both lowercase and uppercase".to_string()
+        ));
     }
 
     #[test]
-    fn test_parse_yaml_with_array_when() {
+    fn test_input_aliases() {
         let yaml = r#"
-id: test_spec
+id: shipping_rate
 inputs:
-  - name: role
+  - name: weight_kg
+    type: float
+    aliases: [weightKg, weight]
+  - name: zone
     type: string
-  - name: verified
-    type: bool
+rules:
+  - id: R1
+    when: "weight_kg > 10.0"
+    then: 1.0
+default: 0.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.inputs[0].aliases, vec!["weightKg", "weight"]);
+        assert!(spec.validate().is_empty());
+
+        let bad = Spec::from_yaml(&yaml.replace("weight]", "zone]")).unwrap();
+        assert_eq!(
+            bad.validate(),
+            vec!["Alias 'zone' of input 'weight_kg' is already an input name or alias"]
+        );
+    }
+
+    #[test]
+    fn test_redaction() {
+        let yaml = r#"
+id: credit_limit
+inputs:
+  - name: email
+    type: string
+    pii: true
+  - name: income
+    type: float
+    redact: hash
+  - name: region
+    type: string
+rules:
+  - id: R1
+    when: "income > 50000.0"
+    then: 1000
+default: 100
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        let redactions: Vec<_> = spec.inputs.iter().map(Variable::redaction).collect();
+        assert_eq!(
+            redactions,
+            vec![Some(Redact::Mask), Some(Redact::Hash), None]
+        );
+        assert_eq!(Redact::Mask.apply_keyed("ada@example.com", b"k1"), MASK);
+        let hash = Redact::Hash.apply_keyed("ada@example.com", b"k1");
+        assert_eq!(hash.len(), "hmac-sha256:".len() + 64);
+        assert_eq!(hash, Redact::Hash.apply_keyed("ada@example.com", b"k1"));
+        assert_ne!(hash, Redact::Hash.apply_keyed("bob@example.com", b"k1"));
+        assert_ne!(hash, Redact::Hash.apply_keyed("ada@example.com", b"k2"));
+        // Without a key there is nothing to hash safely with
+        assert_eq!(Redact::Hash.apply_keyed("ada@example.com", b""), MASK);
+        assert!(spec.to_yaml().unwrap().contains("redact: hash"));
+    }
+
+    #[test]
+    fn test_bucket_validation() {
+        let yaml = r#"
+id: free_shipping
+inputs:
+  - name: member_id
+    type: string
+  - name: total
+    type: float
+rules:
+  - id: R1
+    when: "bucket(member_id, 100) < 5"
+    then: true
+default: false
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert!(spec.validate().is_empty());
+
+        let bad =
+            Spec::from_yaml(&yaml.replace("bucket(member_id, 100)", "bucket(total, 0)")).unwrap();
+        assert_eq!(
+            bad.validate(),
+            vec![
+                "Rule R1 bucket() takes a key and a positive integer bucket count",
+                "Rule R1 must bucket on a string or int input"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_math_function_validation() {
+        let yaml = r#"
+id: dimensional_weight
+inputs:
+  - name: length
+    type: float
+  - name: width
+    type: float
+  - name: pieces
+    type: int
 outputs:
-  - name: level
+  - name: weight
+    type: float
+rules:
+  - id: R1
+    when: "pieces > 1"
+    then: "sqrt(pow(length, 2) + pow(width, 2))"
+default: "percentage(length, 10) + width"
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert!(spec.validate().is_empty());
+
+        let bad = Spec::from_yaml(
+            &yaml
+                .replace("pow(width, 2)", "abs(pieces)")
+                .replace("percentage(length, 10)", "percentage(length)"),
+        )
+        .unwrap();
+        assert_eq!(
+            bad.validate(),
+            vec![
+                "Rule R1 abs() takes floats, but input 'pieces' is not a float",
+                "Default percentage() takes 2 argument(s), not 1"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_aggregate_validation() {
+        let yaml = r#"
+id: parcel_rate
+inputs:
+  - name: items
+    type: !list object
+  - name: scores
+    type: !list int
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'eu'"
+    then: "sum(items.weight_kg) * 5.0 + 7.0"
+default: "avg(scores) + count(items)"
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert!(spec.validate().is_empty());
+
+        let bad = Spec::from_yaml(
+            &yaml
+                .replace("sum(items.weight_kg)", "sum(items) + max(zone)")
+                .replace("avg(scores)", "avg(scores.points)"),
+        )
+        .unwrap();
+        assert_eq!(
+            bad.validate(),
+            vec![
+                "Rule R1 sum() over 'items' needs a field of its items (items.<field>)",
+                "Rule R1 max() takes a list input, but 'zone' is not one",
+                "Default avg() reads 'points' of the items of 'scores', which are not objects"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_format_validation() {
+        let yaml = r#"
+id: parcel_label
+inputs:
+  - name: carrier
+    type: string
+  - name: tier
+    type: int
+  - name: items
+    type: !list object
+outputs:
+  - name: label
+    type: string
+  - name: code
     type: int
 rules:
   - id: R1
-    when:
-      - role == 'admin'
-    then: 100
-  - id: R2
-    when:
-      - role == 'member'
-      - verified
-    then: 50
+    when: "tier > 1"
+    then:
+      label: "format('{carrier}-{tier}')"
+      code: 1
+default:
+  label: "format('{carrier}')"
+  code: 0
 "#;
         let spec = Spec::from_yaml(yaml).unwrap();
-        assert_eq!(spec.id, "test_spec");
-        assert_eq!(spec.rules.len(), 2);
+        assert!(spec.validate().is_empty());
+
+        let bad = Spec::from_yaml(
+            &yaml
+                .replace("'{carrier}-{tier}'", "'{carrier}-{items}-{zone}'")
+                .replace("code: 0", "code: \"format('{tier')\""),
+        )
+        .unwrap();
+        assert_eq!(
+            bad.validate(),
+            vec![
+                "Rule R1 format() cannot format 'items', which is an object or list",
+                "Rule R1 format() placeholder '{zone}' is not an input",
+                "Default format() template '{tier' has an invalid placeholder '{tier' (write {name})",
+                "Default format() builds a string, but output 'code' is not a string"
+            ]
+        );
+    }
 
-        // R1 has single condition in array
-        assert_eq!(spec.rules[0].as_cel(), Some("role == 'admin'".into()));
+    #[test]
+    fn test_zone_validation() {
+        let yaml = r#"
+id: shipping_zone
+inputs:
+  - name: country
+    type: string
+outputs:
+  - name: rate
+    type: float
+zones:
+  - name: domestic
+    countries: [US]
+  - name: europe
+    countries: [eu, CH]
+rules:
+  - id: R1
+    when: "zone(country) == 'europe'"
+    then: 12.0
+default: 30.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert!(spec.validate().is_empty());
+        let tables = spec.geo_tables();
+        assert_eq!(tables.len(), 1);
+        assert_eq!(
+            tables[0].rows.get("FR"),
+            Some(&ConditionValue::String("europe".into()))
+        );
 
-        // R2 has multiple conditions
+        let bad = Spec::from_yaml(
+            &yaml
+                .replace("[US]", "[US, DE, XX]")
+                .replace("zone(country)", "zone(country, 'none')"),
+        )
+        .unwrap();
         assert_eq!(
-            spec.rules[1].as_cel(),
-            Some("(role == 'member') && (verified)".into())
+            bad.validate(),
+            vec![
+                "Zone 'domestic' lists 'XX', which is neither an ISO country code nor a region",
+                "Country 'DE' is in zones 'domestic' and 'europe'",
+                "Rule R1 zone() takes a country code"
+            ]
         );
     }
 
     #[test]
-    fn test_parse_yaml_with_string_when() {
-        // Ensure backward compatibility with string when
+    fn test_custom_function_validation() {
         let yaml = r#"
-id: test_spec
+id: parcel_rate
 inputs:
-  - name: x
+  - name: length_cm
+    type: int
+  - name: width_cm
+    type: float
+  - name: carrier
+    type: string
+outputs:
+  - name: rate
+    type: float
+  - name: billable
     type: bool
+rules:
+  - id: R1
+    when: "dimWeight(length_cm, width_cm) > 20.0"
+    then:
+      rate: 18.0
+      billable: "dimWeight(length_cm, width_cm)"
+  - id: R2
+    when: "dimWeight(carrier, width_cm) > 10.0 || dimWeight(width_cm) > 5.0"
+    then:
+      rate: "dimWeight(length_cm, width_cm)"
+      billable: true
+"#;
+        let mut spec = Spec::from_yaml(yaml).unwrap();
+        spec.functions = vec![CustomFunction {
+            name: "dimWeight".into(),
+            params: vec![VarType::Float, VarType::Float],
+            returns: VarType::Float,
+            go: "example.com/shipping/parcel.DimWeight".into(),
+        }];
+        assert_eq!(
+            spec.functions[0].go_call(),
+            Some((
+                "example.com/shipping/parcel",
+                "parcel.DimWeight".to_string()
+            ))
+        );
+        let errors: Vec<_> = spec
+            .validate()
+            .into_iter()
+            .filter(|e| !e.starts_with("Warning"))
+            .collect();
+        assert_eq!(
+            errors,
+            vec![
+                "Rule R1 dimWeight() returns float, but output 'billable' is bool",
+                "Rule R2 dimWeight() argument 1 is float, but input 'carrier' is string",
+                "Rule R2 dimWeight() takes 2 argument(s), not 1",
+            ]
+        );
+    }
+
+    #[test]
+    fn test_lookup_tables() {
+        let yaml = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: weight_kg
+    type: float
 outputs:
-  - name: result
+  - name: rate
+    type: float
+tables:
+  - name: zone_rates
+    file: zone_rates.csv
+    key: zone
+    value: base_rate
+    type: float
+rules:
+  - id: R1
+    when: "weight_kg > 20.0"
+    then: "lookup('zone_rates', zone, 40.0) * 2.0"
+default: "lookup('zone_rates', zone, 40.0)"
+"#;
+        let dir = tempfile::tempdir().unwrap();
+        std::fs::write(
+            dir.path().join("zone_rates.csv"),
+            "zone,base_rate,note\ndomestic,5,\n\"eu, north\",12.5,\"cold, far\"\n",
+        )
+        .unwrap();
+        let path = dir.path().join("shipping_rate.yaml");
+        std::fs::write(&path, yaml).unwrap();
+
+        let spec = Spec::from_file(&path).unwrap();
+        let rows = &spec.tables[0].rows;
+        assert_eq!(rows["domestic"], ConditionValue::Float(5.0));
+        assert_eq!(rows["eu, north"], ConditionValue::Float(12.5));
+        assert!(spec.validate().is_empty());
+        assert!(!spec.to_yaml().unwrap().contains("domestic"));
+        // The embedded spec carries the rows its table file held
+        let embedded = spec.embed_source().unwrap();
+        assert!(embedded.contains("rows:"));
+        assert!(embedded.contains("domestic: 5"));
+        assert!(Spec::from_yaml(&embedded).is_ok());
+
+        let json = |content: &str| {
+            let table = Table {
+                file: "zone_rates.json".into(),
+                ..spec.tables[0].clone()
+            };
+            table.parse(content)
+        };
+        assert_eq!(
+            json(r#"{"eu": 9.5}"#).unwrap()["eu"],
+            ConditionValue::Float(9.5)
+        );
+        assert_eq!(
+            json(r#"[{"zone": "eu", "base_rate": 9.5}]"#).unwrap()["eu"],
+            ConditionValue::Float(9.5)
+        );
+        assert!(json(r#"{"eu": "cheap"}"#).is_err());
+        assert!(
+            json(r#"[{"zone": "eu", "base_rate": 1}, {"zone": "eu", "base_rate": 2}]"#).is_err()
+        );
+
+        std::fs::write(
+            dir.path().join("zone_rates.csv"),
+            "zone,base_rate\neu,cheap\n",
+        )
+        .unwrap();
+        assert!(Spec::from_file(&path)
+            .unwrap_err()
+            .to_string()
+            .contains("'cheap' of row 'eu'"));
+
+        for file in ["../zone_rates.csv", "/etc/passwd"] {
+            let escaping = yaml.replace("file: zone_rates.csv", &format!("file: {}", file));
+            std::fs::write(&path, escaping).unwrap();
+            assert!(Spec::from_file(&path)
+                .unwrap_err()
+                .to_string()
+                .contains("must be a relative path inside the spec's directory"));
+        }
+
+        let bad =
+            Spec::from_yaml(&yaml.replace("'zone_rates', zone, 40.0)\"\n", "'rates', zone)\"\n"))
+                .unwrap();
+        assert_eq!(
+            bad.validate(),
+            vec!["Default lookup() takes a declared table's name, a key and a fallback"]
+        );
+    }
+
+    #[test]
+    fn test_form_decoding_scalars_only() {
+        let yaml = r#"
+id: shipping_rate
+codegen:
+  form_decoding: true
+inputs:
+  - name: attributes
+    type: object
+rules:
+  - id: R1
+    when: "has(attributes.fragile)"
+    then: 1.0
+default: 0.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(
+            spec.validate(),
+            vec!["Input 'attributes' is an object or list, which form_decoding cannot parse"]
+        );
+    }
+
+    #[test]
+    fn test_sql_functions_scalars_only() {
+        let yaml = r#"
+id: shipping_rate
+codegen:
+  sql_functions: true
+inputs:
+  - name: attributes
+    type: object
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "has(attributes.fragile)"
+    then: 1.0
+default: 0.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(
+            spec.validate(),
+            vec!["'attributes' is an object or list, which sql_functions cannot pass"]
+        );
+    }
+
+    #[test]
+    fn test_build_constraints() {
+        let mut spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  counters: true
+  build_tags: "linux || darwin"
+  lean_build: tinygo
+inputs:
+  - name: zone
+    type: string
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 1.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+        assert!(spec.validate().is_empty());
+        assert_eq!(
+            spec.codegen.go_build().as_deref(),
+            Some("(linux || darwin) && !tinygo")
+        );
+
+        let lean = spec.lean_variant().unwrap();
+        assert_eq!(
+            lean.codegen.go_build().as_deref(),
+            Some("(linux || darwin) && tinygo")
+        );
+        assert_eq!(lean.hash(), spec.hash());
+        assert!(!lean.for_codegen().codegen.counters);
+        assert!(spec.for_codegen().codegen.counters);
+
+        spec.codegen.build_tags = None;
+        assert_eq!(spec.codegen.go_build().as_deref(), Some("!tinygo"));
+        spec.codegen.lean_build = None;
+        assert!(spec.lean_variant().is_none());
+        assert_eq!(spec.codegen.go_build(), None);
+
+        for bad in ["linux &", "(linux", "linux tinygo", "!", "linux; rm"] {
+            spec.codegen.build_tags = Some(bad.into());
+            assert_eq!(
+                spec.validate(),
+                vec![format!("build_tags '{}' is not a Go build constraint", bad)]
+            );
+        }
+        spec.codegen.build_tags = Some("!(tinygo || wasm) && go1.21".into());
+        assert!(spec.validate().is_empty());
+    }
+
+    #[test]
+    fn test_assertion_validation() {
+        let yaml = r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 1.0
+default: 0.0
+assertions:
+  - id: A1
+    expect: "result > 0.0"
+  - id: A1
+    compare: { input: region, left: eu, right: us }
+    expect: "left.result >= right.result"
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(
+            spec.assertions[1].compare.as_ref().unwrap().left,
+            ConditionValue::String("eu".into())
+        );
+        assert_eq!(
+            spec.validate(),
+            vec![
+                "Duplicate assertion ID: A1",
+                "Assertion A1 compares unknown input 'region'"
+            ]
+        );
+    }
+
+    #[test]
+    fn test_ownership_issues() {
+        let yaml = r#"
+id: loan_decision
+inputs:
+  - name: score
     type: int
 rules:
   - id: R1
-    when: "x && y"
-    then: 1
+    when: "score < 500"
+    then: false
+    owner: risk-team
+    approved_by: j.doe
+  - id: R2
+    when: "score >= 600"
+    then: true
+    owner: risk-team
 "#;
         let spec = Spec::from_yaml(yaml).unwrap();
-        assert_eq!(spec.rules[0].as_cel(), Some("x && y".into()));
+        assert_eq!(spec.rules[0].approved_by.as_deref(), Some("j.doe"));
+        assert_eq!(spec.ownership_issues(), vec!["rule R2 has no approved_by"]);
+    }
+
+    #[test]
+    fn test_explain() {
+        let yaml = r#"
+id: test_spec
+inputs:
+  - name: member_tier
+    type: string
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when:
+      - "member_tier == 'silver' && weight_kg < 5.0"
+      - "weight_kg > 0.0"
+    then: 1.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(
+            spec.rules[0].conjuncts(),
+            vec![
+                "member_tier == 'silver'",
+                "weight_kg < 5.0",
+                "weight_kg > 0.0"
+            ]
+        );
+
+        let input: HashMap<String, crate::cel::CelValue> = [
+            ("member_tier".to_string(), "gold".into()),
+            ("weight_kg".to_string(), 2.5.into()),
+        ]
+        .into_iter()
+        .collect();
+        assert_eq!(
+            spec.explain("R1", &input).unwrap(),
+            vec!["R1 did not match: member_tier == 'silver' (member_tier was 'gold')"]
+        );
+
+        let input: HashMap<String, crate::cel::CelValue> = [
+            ("member_tier".to_string(), "silver".into()),
+            ("weight_kg".to_string(), 2.5.into()),
+        ]
+        .into_iter()
+        .collect();
+        assert!(spec.explain("R1", &input).unwrap().is_empty());
+        assert!(spec.explain("R9", &input).is_err());
+    }
+
+    #[test]
+    fn test_embed_source_of_overridden_constants() {
+        let yaml = r#"
+id: shipping_rate
+constants:
+  - name: heavy_kg
+    type: float
+    value: 20.0
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > ${heavy_kg}"
+    then: 25.0
+default: 5.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert_eq!(spec.embed_source().unwrap(), yaml);
+
+        let overrides = BTreeMap::from([("heavy_kg".to_string(), ConditionValue::Float(2.0))]);
+        let staging = Spec::from_yaml_overriding(yaml, &overrides).unwrap();
+        let embedded = staging.embed_source().unwrap();
+        assert!(embedded.contains("weight_kg > 2.0"));
+        assert_ne!(staging.embed_hash(), spec.embed_hash());
+    }
+
+    #[test]
+    fn test_hash_is_stable_across_parses() {
+        let yaml = r#"
+id: shipping_quote
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+  - name: carrier
+    type: string
+  - name: tier
+    type: string
+  - name: extras
+    type: object
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: {rate: 25.0, carrier: freight, tier: bulk, extras: {fuel: 2.5, toll: 1.0, handling: 4.0}}
+default: {rate: 5.0, carrier: post, tier: standard, extras: {fuel: 0.5, toll: 0.0, handling: 1.0}}
+"#;
+        let first = Spec::from_yaml(yaml).unwrap();
+        for _ in 0..32 {
+            let again = Spec::from_yaml(yaml).unwrap();
+            assert_eq!(again.to_yaml().unwrap(), first.to_yaml().unwrap());
+            assert_eq!(again.hash(), first.hash());
+        }
     }
 }
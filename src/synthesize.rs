@@ -0,0 +1,450 @@
+//! Input synthesis
+//!
+//! Finds an input that makes a spec do something: reach a given rule, or
+//! give outputs that satisfy a predicate. Gate tests, documentation
+//! examples and counterexamples all start from such an input, and QA can
+//! generate test data with them. The goal is translated to SMT-LIB as in
+//! [`crate::prove`] (with the same solver and the same limits), and the
+//! solver's input is checked with the runtime before it is returned.
+
+use crate::cel::CelCompiler;
+use crate::error::{Error, Result};
+use crate::prove::{
+    assume_constraints, condition, declare_inputs, define_outputs, first_line, run_solver,
+    solver_command, Problem, Sort,
+};
+use crate::runtime::{evaluate, to_cel_value, Values};
+use crate::spec::{ConditionValue, Spec};
+use std::collections::{BTreeMap, HashMap};
+
+/// What the synthesized input should do
+#[derive(Debug, Clone, PartialEq)]
+pub enum Goal {
+    /// Be decided by this rule
+    Rule(String),
+    /// Give outputs for which this CEL predicate, over the inputs and
+    /// outputs, holds
+    Output(String),
+}
+
+impl std::fmt::Display for Goal {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        match self {
+            Goal::Rule(id) => write!(f, "rule {}", id),
+            Goal::Output(predicate) => write!(f, "outputs where {}", predicate),
+        }
+    }
+}
+
+/// Outcome of synthesizing an input
+#[derive(Debug, Clone, PartialEq)]
+pub enum Synthesis {
+    /// An input reaching the goal, by input name
+    Found(BTreeMap<String, ConditionValue>),
+    /// No valid input reaches the goal
+    Infeasible,
+    /// The solver gave up
+    Unknown,
+    /// The spec or goal uses something with no SMT translation
+    Unsupported(String),
+}
+
+/// Synthesize an input reaching `goal` with the configured solver
+pub fn synthesize(spec: &Spec, goal: &Goal) -> Result<Synthesis> {
+    let command = solver_command();
+    synthesize_with(spec, goal, &mut |script| run_solver(&command, script))
+}
+
+/// Synthesize an input reaching `goal`, passing the SMT-LIB script to
+/// `solve` and reading back the solver's output
+pub fn synthesize_with(
+    spec: &Spec,
+    goal: &Goal,
+    solve: &mut dyn FnMut(&str) -> Result<String>,
+) -> Result<Synthesis> {
+    if let Goal::Rule(id) = goal {
+        if !deployed(spec).iter().any(|r| &r.id == id) {
+            return Err(Error::Other(format!(
+                "'{}' is not a deployed rule of '{}'",
+                id, spec.id
+            )));
+        }
+    }
+    let problem = match problem(spec, goal) {
+        Ok(problem) => problem,
+        Err(reason) => return Ok(Synthesis::Unsupported(reason)),
+    };
+
+    let output = solve(&problem.model())?;
+    match first_line(&output) {
+        "unsat" => Ok(Synthesis::Infeasible),
+        "unknown" => Ok(Synthesis::Unknown),
+        "sat" => {
+            let input = model(spec, output.trim_start().trim_start_matches("sat"))?;
+            check(spec, goal, &input)?;
+            Ok(Synthesis::Found(input))
+        }
+        other => Err(Error::Other(format!(
+            "Unexpected SMT solver output for {}: {}",
+            goal, other
+        ))),
+    }
+}
+
+fn deployed(spec: &Spec) -> Vec<&crate::spec::Rule> {
+    spec.evaluation_order()
+        .into_iter()
+        .filter(|r| r.state.is_active())
+        .collect()
+}
+
+/// Translate a goal over a spec; `Err` explains what has no SMT
+/// translation
+fn problem(spec: &Spec, goal: &Goal) -> std::result::Result<Problem, String> {
+    if !spec.normalize.is_empty() {
+        return Err("the spec normalizes its inputs".into());
+    }
+    let mut lines = vec!["(set-logic ALL)".to_string()];
+    let mut symbols = Vec::new();
+    let env = declare_inputs(spec, None, &mut lines, &mut symbols)?;
+    assume_constraints(spec, &env, &mut lines)?;
+
+    let target = match goal {
+        Goal::Rule(id) => {
+            // The rule applies and no rule before it does
+            let mut reach = Vec::new();
+            for rule in deployed(spec) {
+                let applies = match rule.as_cel() {
+                    Some(cel) => condition(&cel, &env)?,
+                    None => "true".to_string(),
+                };
+                if &rule.id == id {
+                    reach.push(applies);
+                    break;
+                }
+                reach.push(format!("(not {})", applies));
+            }
+            format!("(and true {})", reach.join(" "))
+        }
+        Goal::Output(predicate) => {
+            if spec.outputs.is_empty() {
+                return Err("the spec declares no output types".into());
+            }
+            let (outputs, matched) = define_outputs(spec, &env, "", &mut lines, &mut Vec::new())?;
+            let mut scope = env.clone();
+            scope.extend(outputs);
+            format!("(and {} {})", matched, condition(predicate, &scope)?)
+        }
+    };
+    lines.push(format!("(assert {})", target));
+
+    Ok(Problem {
+        script: lines.iter().map(|line| format!("{}\n", line)).collect(),
+        symbols,
+    })
+}
+
+/// The input values of a `get-value` response, `((name value) ...)`
+fn model(spec: &Spec, response: &str) -> Result<BTreeMap<String, ConditionValue>> {
+    let sorts: HashMap<String, Sort> = spec
+        .inputs
+        .iter()
+        .filter_map(|var| Some((var.name.clone(), Sort::of(&var.typ)?)))
+        .collect();
+    read_model(response, &sorts)
+}
+
+/// The values of the symbols in `sorts` in a `get-value` response; other
+/// symbols are ignored
+pub(crate) fn read_model(
+    response: &str,
+    sorts: &HashMap<String, Sort>,
+) -> Result<BTreeMap<String, ConditionValue>> {
+    let malformed = || Error::Other(format!("Unreadable SMT model: {}", response.trim()));
+    let tokens = tokens(response);
+    let mut at = 0;
+    let Some(Sexp::List(pairs)) = sexp(&tokens, &mut at) else {
+        return Err(malformed());
+    };
+
+    let mut input = BTreeMap::new();
+    for pair in pairs {
+        let Sexp::List(pair) = pair else {
+            return Err(malformed());
+        };
+        let [Sexp::Atom(name), value] = pair.as_slice() else {
+            return Err(malformed());
+        };
+        let Some(sort) = sorts.get(name) else {
+            continue;
+        };
+        let value = match sort {
+            Sort::Bool => ConditionValue::Bool(value.atom() == Some("true")),
+            Sort::Int => ConditionValue::Int(value.number().ok_or_else(malformed)? as i64),
+            Sort::Real => ConditionValue::Float(value.number().ok_or_else(malformed)?),
+            Sort::String => match value {
+                Sexp::Str(s) => ConditionValue::String(s.clone()),
+                _ => return Err(malformed()),
+            },
+        };
+        input.insert(name.clone(), value);
+    }
+    Ok(input)
+}
+
+/// Evaluate the synthesized input and confirm it reaches the goal, so a
+/// gap between the SMT translation and the runtime is an error rather than
+/// a wrong answer
+fn check(spec: &Spec, goal: &Goal, input: &BTreeMap<String, ConditionValue>) -> Result<()> {
+    let values: Values = input
+        .iter()
+        .map(|(name, value)| (name.clone(), to_cel_value(value)))
+        .collect();
+    let evaluation = evaluate(spec, &values)?;
+    let reached = match goal {
+        Goal::Rule(id) => evaluation.rule_id.as_ref() == Some(id),
+        Goal::Output(predicate) => {
+            let mut scope = values;
+            scope.extend(evaluation.outputs);
+            CelCompiler::eval_bool(predicate, &scope)?
+        }
+    };
+    if reached {
+        return Ok(());
+    }
+    Err(Error::Other(format!(
+        "The solver's input {} does not reach {} when evaluated",
+        input
+            .iter()
+            .map(|(name, value)| format!("{}={}", name, value))
+            .collect::<Vec<_>>()
+            .join(", "),
+        goal
+    )))
+}
+
+/// An S-expression of a solver response
+#[derive(Debug, Clone, PartialEq)]
+enum Sexp {
+    Atom(String),
+    Str(String),
+    List(Vec<Sexp>),
+}
+
+impl Sexp {
+    fn atom(&self) -> Option<&str> {
+        match self {
+            Sexp::Atom(a) => Some(a),
+            _ => None,
+        }
+    }
+
+    /// A numeral, decimal, `(- x)` or `(/ x y)`
+    fn number(&self) -> Option<f64> {
+        match self {
+            Sexp::Atom(a) => a.parse().ok(),
+            Sexp::List(items) => match items.as_slice() {
+                [Sexp::Atom(op), x] if op == "-" => Some(-x.number()?),
+                [Sexp::Atom(op), x, y] if op == "/" => Some(x.number()? / y.number()?),
+                _ => None,
+            },
+            Sexp::Str(_) => None,
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+enum Token {
+    Open,
+    Close,
+    Atom(String),
+    Str(String),
+}
+
+fn tokens(text: &str) -> Vec<Token> {
+    let mut tokens = Vec::new();
+    let mut chars = text.chars().peekable();
+    while let Some(c) = chars.next() {
+        match c {
+            '(' => tokens.push(Token::Open),
+            ')' => tokens.push(Token::Close),
+            '"' => {
+                // `""` is an escaped quote
+                let mut s = String::new();
+                while let Some(c) = chars.next() {
+                    if c == '"' {
+                        if chars.peek() == Some(&'"') {
+                            chars.next();
+                        } else {
+                            break;
+                        }
+                    }
+                    s.push(c);
+                }
+                tokens.push(Token::Str(s));
+            }
+            '|' => {
+                // A quoted symbol, `|name|`, stands for `name`
+                let name: String = chars.by_ref().take_while(|&c| c != '|').collect();
+                tokens.push(Token::Atom(name));
+            }
+            c if c.is_whitespace() => {}
+            c => {
+                let mut atom = c.to_string();
+                while let Some(&next) = chars.peek() {
+                    if next.is_whitespace() || next == '(' || next == ')' {
+                        break;
+                    }
+                    atom.push(next);
+                    chars.next();
+                }
+                tokens.push(Token::Atom(atom));
+            }
+        }
+    }
+    tokens
+}
+
+fn sexp(tokens: &[Token], at: &mut usize) -> Option<Sexp> {
+    let token = tokens.get(*at)?;
+    *at += 1;
+    match token {
+        Token::Atom(a) => Some(Sexp::Atom(a.clone())),
+        Token::Str(s) => Some(Sexp::Str(s.clone())),
+        Token::Close => None,
+        Token::Open => {
+            let mut items = Vec::new();
+            while tokens.get(*at) != Some(&Token::Close) {
+                items.push(sexp(tokens, at)?);
+            }
+            *at += 1;
+            Some(Sexp::List(items))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping
+inputs:
+  - name: weight_kg
+    type: float
+  - name: items
+    type: int
+  - name: tier
+    type: string
+    values: [standard, gold]
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+  - id: GOLD
+    when: "tier == 'gold'"
+    then: 0
+  - id: NEVER
+    when: "weight_kg > 30.0"
+    then: 40.0
+default: 10.0
+constraints:
+  - id: positive_weight
+    require: "weight_kg > 0.0"
+"#;
+
+    fn spec() -> Spec {
+        Spec::from_yaml(SPEC).unwrap()
+    }
+
+    #[test]
+    fn test_rule_problem() {
+        let spec = spec();
+        let reach = problem(&spec, &Goal::Rule("GOLD".into())).unwrap();
+        assert!(reach.script.contains("(assert (> |weight_kg| 0.0))\n"));
+        assert!(reach
+            .script
+            .ends_with("(assert (and true (not (> |weight_kg| 20.0)) (= |tier| \"gold\")))\n"));
+        assert_eq!(reach.symbols, vec!["|weight_kg|", "|items|", "|tier|"]);
+
+        let output = problem(&spec, &Goal::Output("rate < 10.0 && items > 3".into())).unwrap();
+        assert!(output.script.contains("(define-fun |rate| () Real"));
+        assert!(output
+            .script
+            .ends_with("(assert (and true (and (< |rate| 10.0) (> |items| 3))))\n"));
+    }
+
+    #[test]
+    fn test_synthesize_with_solver_output() {
+        let spec = spec();
+        let found = synthesize_with(&spec, &Goal::Rule("GOLD".into()), &mut |_| {
+            Ok("sat\n((|weight_kg| (/ 3.0 2.0))\n (items (- 4))\n (|tier| \"gold\"))\n".into())
+        })
+        .unwrap();
+        assert_eq!(
+            found,
+            Synthesis::Found(BTreeMap::from([
+                ("items".to_string(), ConditionValue::Int(-4)),
+                ("tier".to_string(), ConditionValue::String("gold".into())),
+                ("weight_kg".to_string(), ConditionValue::Float(1.5)),
+            ]))
+        );
+
+        let never = synthesize_with(&spec, &Goal::Rule("NEVER".into()), &mut |_| {
+            Ok("unsat\n(error \"model is not available\")\n".into())
+        })
+        .unwrap();
+        assert_eq!(never, Synthesis::Infeasible);
+
+        // A model that the runtime disagrees with is an error
+        let err = synthesize_with(&spec, &Goal::Rule("HEAVY".into()), &mut |_| {
+            Ok("sat\n((weight_kg 2.0) (items 0) (tier \"standard\"))\n".into())
+        })
+        .unwrap_err();
+        assert!(err.to_string().contains("does not reach rule HEAVY"));
+
+        let err = synthesize_with(&spec, &Goal::Rule("LIGHT".into()), &mut |_| {
+            Ok("unsat\n".into())
+        })
+        .unwrap_err();
+        assert!(err.to_string().contains("'LIGHT' is not a deployed rule"));
+    }
+
+    #[test]
+    fn test_unsupported_goal() {
+        let outcome = synthesize_with(
+            &spec(),
+            &Goal::Output("string(rate).matches('^2')".into()),
+            &mut |_| Ok("sat\n".into()),
+        )
+        .unwrap();
+        assert!(matches!(outcome, Synthesis::Unsupported(_)));
+    }
+
+    #[test]
+    fn test_synthesize_with_z3() {
+        if std::process::Command::new("z3")
+            .arg("-version")
+            .output()
+            .is_err()
+        {
+            return;
+        }
+        let spec = spec();
+        let Synthesis::Found(input) = synthesize(&spec, &Goal::Rule("GOLD".into())).unwrap() else {
+            panic!("GOLD is reachable");
+        };
+        assert_eq!(input["tier"], ConditionValue::String("gold".into()));
+        assert_eq!(
+            synthesize(&spec, &Goal::Rule("NEVER".into())).unwrap(),
+            Synthesis::Infeasible
+        );
+        assert!(matches!(
+            synthesize(&spec, &Goal::Output("rate == 25.0".into())).unwrap(),
+            Synthesis::Found(_)
+        ));
+    }
+}
@@ -3,7 +3,11 @@
 //! Converts Spec and Orchestrator into template-friendly data structures.
 
 use crate::cel::{CelCompiler, Target};
-use crate::spec::{ConditionOp, ConditionValue, Output, Rule, Spec, VarType, Variable};
+use crate::render::{is_expression, is_expression_calling};
+use crate::spec::{
+    ConditionOp, ConditionValue, Constraint, CustomFunction, Output, Redact, Rule, Spec,
+    StreamOptions, VarType, Variable,
+};
 use chrono::Utc;
 use serde::Serialize;
 use std::collections::HashMap;
@@ -29,6 +33,54 @@ pub struct SpecContext {
     pub outputs: Vec<OutputView>,
     /// Rules
     pub rules: Vec<RuleView>,
+    /// Cross-field input constraints
+    pub constraints: Vec<ConstraintView>,
+    /// Result checks asserted in debug builds (outputs bound as `result`)
+    pub postconditions: Vec<ConstraintView>,
+    /// Rule registry entries in evaluation order (empty unless `codegen.registry`)
+    pub registry: Vec<RegistryEntryView>,
+    /// Whether to count rule hits
+    pub counters: bool,
+    /// Whether to log rule matches through the generated slog logger
+    pub logging: bool,
+    /// Whether to emit the panic-recovering `Safe` wrapper
+    pub safe: bool,
+    /// Whether to emit the HTTP handler and typed client
+    pub service: bool,
+    /// Whether to emit the evaluator interface and its implementations
+    pub interface: bool,
+    /// Per-rule conjunct checks for the explain function (empty unless `codegen.explain`)
+    pub explain: Vec<ExplainView>,
+    /// Whether to emit the shadow-evaluation wrapper
+    pub shadow: bool,
+    /// Whether to emit the parallel batch-evaluation function
+    pub batch: bool,
+    /// Streaming evaluator sizing (set when `codegen.stream`)
+    pub stream: Option<StreamOptions>,
+    /// Whether Go evaluation goes through a pointer to the input
+    pub zero_alloc: bool,
+    /// Go rule chain split into per-file helpers (empty when not split)
+    pub chunks: Vec<RuleChunkView>,
+    /// Whether to emit Go String/MarshalJSON methods and typed errors
+    pub marshalers: bool,
+    /// Spec version stamped into decisions (set when `codegen.versioned`)
+    pub versioned: Option<SpecVersionView>,
+    /// File the spec YAML is embedded from (set when `codegen.embed_spec`)
+    pub embed_spec: Option<String>,
+    /// Hash the embedded file must have
+    pub embed_hash: String,
+    /// `//go:build` constraint of the generated Go files
+    pub go_build: Option<String>,
+    /// Every rule's reason in evaluation order (empty unless a rule declares one)
+    pub reasons: Vec<ReasonView>,
+    /// Message catalog entries, sorted by key
+    pub messages: Vec<MessageView>,
+    /// Ownership of the rules that declare an owner or approver, listed in
+    /// the provenance header
+    pub owners: Vec<RuleOwnerView>,
+    /// Result cache settings for the memoized wrapper (not emitted when
+    /// results depend on caller-supplied conversion rates)
+    pub cache: Option<CacheView>,
     /// Default output (if specified)
     pub default: Option<OutputValueView>,
     /// Whether to use match/switch vs if-else
@@ -50,6 +102,90 @@ pub struct SpecContext {
     pub module_path: Option<String>,
     /// Python/Rust/TypeScript module (e.g., "company.rules.auth")
     pub module: Option<String>,
+    /// Whether any input is optional
+    pub has_optional: bool,
+    /// Go representation of optional inputs ("pointer" or "sql_null")
+    pub nullable: String,
+    /// Whether any input declares a default value
+    pub has_defaults: bool,
+    /// Whether any input accepts legacy payload names
+    pub has_aliases: bool,
+    /// Input normalizations, applied by the generated Go `Normalize`
+    pub normalizations: Vec<NormalizationView>,
+    /// Inputs decoded from `url.Values`, when form decoding is enabled
+    pub form_fields: Vec<FormFieldView>,
+    /// SQL function arguments, one per input, when SQL functions are enabled
+    pub sql_args: Vec<SqlArgView>,
+    /// Scalar SQL functions: the spec's, or one per output of a spec with
+    /// several
+    pub sql_functions: Vec<SqlFunctionView>,
+    /// Argument conversions the SQL functions need (`Bool`, `Int`, `Float`,
+    /// `String`)
+    pub sql_kinds: Vec<String>,
+    /// Go standard library imports needed by the generated file
+    pub go_imports: Vec<String>,
+    /// Whether expressions call convert_currency() (adds a `rates` parameter)
+    pub uses_rates: bool,
+    /// Whether Go code uses the conditional-expression helper
+    pub uses_if_else: bool,
+    /// Whether Go code uses the bucket() helper
+    pub uses_bucket: bool,
+    /// Whether Python code uses the math module
+    pub uses_math_py: bool,
+    /// Whether Python code uses functools (bucket())
+    pub uses_functools_py: bool,
+    /// Lookup tables embedded in the generated code
+    pub tables: Vec<TableView>,
+}
+
+/// A lookup table and its rows, embedded as a constant map
+#[derive(Debug, Clone, Serialize)]
+pub struct TableView {
+    pub name: String,
+    pub name_pascal: String,
+    pub name_upper: String,
+    /// Data file the rows were read from
+    pub file: String,
+    pub rust_type: String,
+    pub ts_type: String,
+    pub py_type: String,
+    pub go_type: String,
+    pub java_type: String,
+    pub csharp_type: String,
+    pub rows: Vec<TableRowView>,
+}
+
+/// One row of a lookup table
+#[derive(Debug, Clone, Serialize)]
+pub struct TableRowView {
+    /// Key, escaped for a string literal
+    pub key: String,
+    pub value: NamedValueView,
+}
+
+impl TableView {
+    fn from_table(table: &crate::spec::Table) -> Self {
+        Self {
+            name: table.name.clone(),
+            name_pascal: to_pascal_case(&table.name),
+            name_upper: table.name.to_uppercase(),
+            file: table.file.clone(),
+            rust_type: map_type_rust(&table.typ),
+            ts_type: map_type_ts(&table.typ),
+            py_type: map_type_python(&table.typ),
+            go_type: map_type_go(&table.typ),
+            java_type: map_type_java_boxed(&table.typ),
+            csharp_type: map_type_csharp(&table.typ),
+            rows: table
+                .rows
+                .iter()
+                .map(|(key, value)| TableRowView {
+                    key: escape_string(key),
+                    value: NamedValueView::literal(value),
+                })
+                .collect(),
+        }
+    }
 }
 
 /// View of an input variable
@@ -75,6 +211,16 @@ pub struct InputView {
     pub java_type: String,
     /// C# type
     pub csharp_type: String,
+    /// Whether the input may be absent
+    pub optional: bool,
+    /// Value applied when the input is omitted
+    pub default: Option<NamedValueView>,
+    /// Unit of measure (canonical symbol)
+    pub unit: Option<String>,
+    /// Currency code for money inputs
+    pub currency: Option<String>,
+    /// Legacy payload names accepted by the generated JSON decoding
+    pub aliases: Vec<String>,
 }
 
 /// View of an output variable
@@ -100,6 +246,8 @@ pub struct OutputView {
     pub java_type: String,
     /// C# type
     pub csharp_type: String,
+    /// Redaction of a personal output in stored decisions: "mask" or "hash"
+    pub redact: Option<String>,
 }
 
 /// View of a rule
@@ -123,6 +271,8 @@ pub struct RuleView {
     pub pattern_rust: String,
     /// Pattern for match statements (Python)
     pub pattern_py: String,
+    /// Local bindings computed before the output, in order
+    pub vars: Vec<BindingView>,
     /// Output value
     pub output: OutputValueView,
     /// Whether this rule uses CEL (vs simple conditions)
@@ -152,6 +302,274 @@ pub struct OutputValueView {
     pub named: Option<HashMap<String, NamedValueView>>,
 }
 
+/// View of a cross-field input constraint
+#[derive(Debug, Clone, Serialize)]
+pub struct ConstraintView {
+    /// Constraint ID
+    pub id: String,
+    /// Violation message, escaped for use in a string literal
+    pub message: String,
+    /// Expression that must hold, rendered per target
+    pub check: NamedValueView,
+}
+
+/// View of one file's share of a split Go rule chain
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleChunkView {
+    /// Part number, from 1
+    pub part: usize,
+    /// Rules in evaluation order
+    pub rules: Vec<RuleView>,
+    /// Packages the rules call into (math, fmt)
+    pub imports: Vec<String>,
+}
+
+/// Identity of the rule set that produced a decision
+#[derive(Debug, Clone, Serialize)]
+pub struct SpecVersionView {
+    /// `meta.version`, escaped for a string literal (empty when unset)
+    pub version: String,
+    pub hash: String,
+}
+
+/// View of the reason a rule reports when it matches
+#[derive(Debug, Clone, Serialize)]
+pub struct ReasonView {
+    pub rule_id: String,
+    /// Reason code, escaped for a string literal
+    pub code: Option<String>,
+    /// Message catalog key, escaped for a string literal
+    pub message_key: Option<String>,
+    /// The rule's condition in Go
+    pub condition_go: String,
+}
+
+/// View of a message catalog entry
+#[derive(Debug, Clone, Serialize)]
+pub struct MessageView {
+    /// Message key, escaped for a string literal
+    pub key: String,
+    /// Default-language text, escaped for a string literal
+    pub text: String,
+}
+
+/// View of one input normalization
+#[derive(Debug, Clone, Serialize)]
+pub struct NormalizationView {
+    /// Go field name of the input
+    pub field: String,
+    /// Go expression for the trimmed/case-folded value, if either applies
+    pub expr_go: Option<String>,
+    /// Value replacements, escaped for string literals
+    pub mappings: Vec<MappingView>,
+}
+
+/// View of one value replacement
+#[derive(Debug, Clone, Serialize)]
+pub struct MappingView {
+    pub from: String,
+    pub to: String,
+}
+
+impl NormalizationView {
+    fn from_normalization(normalization: &crate::spec::Normalization) -> Self {
+        let field = to_pascal_case(&normalization.input);
+        let mut expr = format!("input.{}", field);
+        if normalization.trim {
+            expr = format!("strings.TrimSpace({})", expr);
+        }
+        if normalization.lowercase {
+            expr = format!("strings.ToLower({})", expr);
+        } else if normalization.uppercase {
+            expr = format!("strings.ToUpper({})", expr);
+        }
+        let rewrites = normalization.trim || normalization.lowercase || normalization.uppercase;
+        Self {
+            field,
+            expr_go: rewrites.then_some(expr),
+            mappings: normalization
+                .map
+                .iter()
+                .map(|(from, to)| MappingView {
+                    from: escape_string(from),
+                    to: escape_string(to),
+                })
+                .collect(),
+        }
+    }
+}
+
+/// View of one input decoded from URL query parameters or a form
+#[derive(Debug, Clone, Serialize)]
+pub struct FormFieldView {
+    /// Input name, used in decode errors
+    pub name: String,
+    /// Parameter names tried in order: the input name, then its aliases
+    pub params: Vec<String>,
+    /// Go field name of the input
+    pub field: String,
+    /// Go call parsing `raw` into `(value, error)`; None for strings
+    pub parse_go: Option<String>,
+    /// Type named in parse errors
+    pub kind: String,
+    /// Go expression storing `parsed` in the field
+    pub assign_go: String,
+    /// Go value applied when the parameter is absent
+    pub default_go: Option<String>,
+    /// Whether an absent parameter is an error
+    pub required: bool,
+    /// Allowed values of an enum input, escaped for string literals
+    pub values: Vec<String>,
+}
+
+impl FormFieldView {
+    fn from_var(var: &Variable, nullable: NullableStyle, spec_pascal: &str) -> Self {
+        let (parse_go, kind) = match var.typ {
+            VarType::Bool => (Some("strconv.ParseBool(raw)"), "bool"),
+            VarType::Int => (Some("strconv.ParseInt(raw, 10, 64)"), "int"),
+            VarType::Float => (Some("strconv.ParseFloat(raw, 64)"), "float"),
+            _ => (None, "string"),
+        };
+        let assign_go = match (var.optional, nullable) {
+            (false, _) => "parsed".to_string(),
+            (true, NullableStyle::Pointer) => "&parsed".to_string(),
+            (true, NullableStyle::SqlNull) => format!(
+                "{}Null[{t}]{{sql.Null[{t}]{{V: parsed, Valid: true}}}}",
+                spec_pascal,
+                t = map_type_go(&var.typ)
+            ),
+        };
+        let values = match &var.typ {
+            VarType::Enum(values) => values.clone(),
+            _ => var.values.clone().unwrap_or_default(),
+        };
+        Self {
+            name: var.name.clone(),
+            params: std::iter::once(&var.name)
+                .chain(&var.aliases)
+                .map(|name| escape_string(name))
+                .collect(),
+            field: to_pascal_case(&var.name),
+            parse_go: parse_go.map(str::to_string),
+            kind: kind.to_string(),
+            assign_go,
+            default_go: var.default.as_ref().map(|d| NamedValueView::literal(d).go),
+            required: !var.optional && var.default.is_none(),
+            values: values.iter().map(|v| escape_string(v)).collect(),
+        }
+    }
+}
+
+/// View of one argument of the generated SQL functions
+#[derive(Debug, Clone, Serialize)]
+pub struct SqlArgView {
+    /// Input name, used in conversion errors
+    pub name: String,
+    /// Go field name of the input
+    pub field: String,
+    /// Conversion helper suffix: `Bool`, `Int`, `Float` or `String`
+    pub kind: String,
+    /// Go expression storing `parsed` in the field
+    pub assign_go: String,
+    /// Go value applied when the argument is NULL
+    pub default_go: Option<String>,
+    /// Whether a NULL argument makes the result NULL
+    pub required: bool,
+    /// Allowed values of an enum input, escaped for string literals
+    pub values: Vec<String>,
+}
+
+impl SqlArgView {
+    fn from_var(var: &Variable, nullable: NullableStyle, spec_pascal: &str) -> Self {
+        let form = FormFieldView::from_var(var, nullable, spec_pascal);
+        let kind = match var.typ {
+            VarType::Bool => "Bool",
+            VarType::Int => "Int",
+            VarType::Float => "Float",
+            _ => "String",
+        };
+        Self {
+            name: form.name,
+            field: form.field,
+            kind: kind.to_string(),
+            assign_go: form.assign_go,
+            default_go: form.default_go,
+            required: form.required,
+            values: form.values,
+        }
+    }
+}
+
+/// View of one generated SQL function
+#[derive(Debug, Clone, Serialize)]
+pub struct SqlFunctionView {
+    /// Name queries call it by
+    pub name: String,
+    /// Go field of the output it returns, for specs with several outputs
+    pub field: Option<String>,
+}
+
+/// View of who owns and approved a rule
+#[derive(Debug, Clone, Serialize)]
+pub struct RuleOwnerView {
+    pub rule_id: String,
+    /// e.g. `owner risk-team, approved by j.doe`
+    pub text: String,
+}
+
+/// View of the result cache settings
+#[derive(Debug, Clone, Serialize)]
+pub struct CacheView {
+    /// Maximum number of entries
+    pub size: usize,
+    /// Seconds an entry stays valid
+    pub ttl_secs: Option<u64>,
+}
+
+/// View of one rule's conjuncts for the explain function
+#[derive(Debug, Clone, Serialize)]
+pub struct ExplainView {
+    /// Rule ID
+    pub id: String,
+    /// Conjuncts that must all hold for the rule to match
+    pub conjuncts: Vec<ConjunctView>,
+}
+
+/// View of one conjunct of a rule condition
+#[derive(Debug, Clone, Serialize)]
+pub struct ConjunctView {
+    /// The conjunct, rendered per target
+    pub check: NamedValueView,
+    /// String expression describing the failure and the inputs it read
+    pub message: NamedValueView,
+}
+
+/// View of one rule in the generated rule registry
+#[derive(Debug, Clone, Serialize)]
+pub struct RegistryEntryView {
+    /// Rule ID
+    pub id: String,
+    /// Condition source text, escaped for use in a string literal
+    pub condition: String,
+    /// Output source text, escaped for use in a string literal
+    pub output: String,
+    /// Tags, escaped for use in string literals
+    pub tags: Vec<String>,
+    /// Line in the spec file (0 when unknown)
+    pub line: usize,
+}
+
+/// View of a rule-local binding
+#[derive(Debug, Clone, Serialize)]
+pub struct BindingView {
+    /// Local name (snake_case)
+    pub name: String,
+    /// camelCase name
+    pub name_camel: String,
+    /// Bound expression
+    pub value: NamedValueView,
+}
+
 /// View of a named output value
 #[derive(Debug, Clone, Serialize)]
 pub struct NamedValueView {
@@ -166,21 +584,167 @@ pub struct NamedValueView {
 impl SpecContext {
     /// Create a SpecContext from a Spec
     pub fn from_spec(spec: &Spec, target: Target, provenance: bool) -> Self {
-        let inputs: Vec<InputView> = spec.inputs.iter().map(InputView::from_var).collect();
+        // Provenance hashes cover the whole spec, draft and retired rules included
+        let spec_hash = spec.hash();
+        let generated = spec.for_codegen();
+        let spec = generated.as_ref();
+
+        let inputs: Vec<InputView> = spec
+            .inputs
+            .iter()
+            .map(|v| InputView::from_var(v, spec.codegen.nullable, &to_pascal_case(&spec.id)))
+            .collect();
         let input_names: Vec<String> = inputs.iter().map(|i| i.name.clone()).collect();
 
         let outputs: Vec<OutputView> = spec.outputs.iter().map(OutputView::from_var).collect();
 
-        let rules: Vec<RuleView> = spec
-            .rules
-            .iter()
-            .map(|r| RuleView::from_rule(r, &input_names, &spec.inputs))
+        let mut rules: Vec<RuleView> = spec
+            .evaluation_order()
+            .into_iter()
+            .map(|r| RuleView::from_rule(r, &input_names, &spec.inputs, &spec.functions))
             .collect();
 
-        let default = spec
+        let mut default = spec
             .default
             .as_ref()
-            .map(|d| OutputValueView::from_output(d, &input_names));
+            .map(|d| OutputValueView::from_output(d, &input_names, &spec.functions));
+
+        // Go helpers are emitted per spec; prefix them so that several
+        // generated files can live in one package
+        let has_optional = spec.inputs.iter().any(|i| i.optional);
+        let id_camel = to_camel_case(&spec.id);
+        let functions = &spec.functions;
+        for rule in &mut rules {
+            rule.condition_go = scope_go_helpers(&rule.condition_go, &id_camel, functions);
+            for binding in &mut rule.vars {
+                binding.value.go = scope_go_helpers(&binding.value.go, &id_camel, functions);
+            }
+            rule.output.scope_go_helpers(&id_camel, functions);
+        }
+        if let Some(d) = &mut default {
+            d.scope_go_helpers(&id_camel, functions);
+        }
+        let mut constraints: Vec<ConstraintView> = spec
+            .constraints
+            .iter()
+            .map(|c| ConstraintView::from_constraint(c, &input_names))
+            .collect();
+        let registry: Vec<RegistryEntryView> = if spec.codegen.registry {
+            spec.evaluation_order()
+                .into_iter()
+                .map(|r| RegistryEntryView {
+                    id: r.id.clone(),
+                    condition: escape_string(&r.as_cel().unwrap_or_default()),
+                    output: escape_string(&match &r.then {
+                        Output::Single(ConditionValue::String(expr)) => expr.clone(),
+                        other => other.to_string(),
+                    }),
+                    tags: r.tags.iter().map(|t| escape_string(t)).collect(),
+                    line: r.line.unwrap_or(0),
+                })
+                .collect()
+        } else {
+            Vec::new()
+        };
+
+        let mut explain: Vec<ExplainView> = if spec.codegen.explain {
+            spec.evaluation_order()
+                .into_iter()
+                .map(|r| ExplainView::from_rule(r, &input_names, &spec.inputs))
+                .collect()
+        } else {
+            Vec::new()
+        };
+        for conjunct in explain.iter_mut().flat_map(|e| e.conjuncts.iter_mut()) {
+            conjunct.check.go = scope_go_helpers(&conjunct.check.go, &id_camel, functions);
+        }
+
+        let mut postconditions: Vec<ConstraintView> = spec
+            .postconditions
+            .iter()
+            .map(|c| ConstraintView::from_postcondition(c, &input_names, &spec.outputs))
+            .collect();
+        for constraint in constraints.iter_mut().chain(postconditions.iter_mut()) {
+            constraint.check.go = scope_go_helpers(&constraint.check.go, &id_camel, functions);
+        }
+
+        let has_reasons = spec
+            .rules
+            .iter()
+            .any(|r| r.reason_code.is_some() || r.message_key.is_some());
+        let reasons: Vec<ReasonView> = if has_reasons {
+            spec.evaluation_order()
+                .into_iter()
+                .zip(&rules)
+                .map(|(rule, view)| ReasonView {
+                    rule_id: rule.id.clone(),
+                    code: rule.reason_code.as_deref().map(escape_string),
+                    message_key: rule.message_key.as_deref().map(escape_string),
+                    condition_go: view.condition_go.clone(),
+                })
+                .collect()
+        } else {
+            Vec::new()
+        };
+        let messages: Vec<MessageView> = spec
+            .message_catalog()
+            .iter()
+            .map(|(key, text)| MessageView {
+                key: escape_string(key),
+                text: escape_string(text),
+            })
+            .collect();
+        let owners: Vec<RuleOwnerView> = spec
+            .rules
+            .iter()
+            .filter_map(|rule| {
+                rule.ownership().map(|text| RuleOwnerView {
+                    rule_id: rule.id.clone(),
+                    text,
+                })
+            })
+            .collect();
+
+        let chunks: Vec<RuleChunkView> = match spec.codegen.split_rules {
+            Some(size) if size > 0 && rules.len() > size => rules
+                .chunks(size)
+                .enumerate()
+                .map(|(i, chunk)| {
+                    let code = rendered_code(chunk, None, Target::Go);
+                    let mut imports: Vec<String> = [("fmt", "fmt.Sprintf("), ("math", "math.")]
+                        .into_iter()
+                        .filter(|(_, usage)| code.contains(usage))
+                        .map(|(pkg, _)| pkg.to_string())
+                        .collect();
+                    for (path, call) in spec.functions.iter().filter_map(|f| f.go_call()) {
+                        if code.contains(&format!("{}(", call)) {
+                            imports.push(path.to_string());
+                        }
+                    }
+                    imports.sort();
+                    imports.dedup();
+                    RuleChunkView {
+                        part: i + 1,
+                        rules: chunk.to_vec(),
+                        imports,
+                    }
+                })
+                .collect(),
+            _ => Vec::new(),
+        };
+
+        // Split rules live in their own files and import what they need
+        let main_rules: &[RuleView] = if chunks.is_empty() { &rules } else { &[] };
+        let mut go_code = rendered_code(main_rules, default.as_ref(), Target::Go);
+        let mut py_code = rendered_code(&rules, default.as_ref(), Target::Python);
+        for constraint in constraints.iter().chain(postconditions.iter()) {
+            go_code = format!("{}\n{}", go_code, constraint.check.go);
+            py_code = format!("{}\n{}", py_code, constraint.check.py);
+        }
+        for conjunct in explain.iter().flat_map(|e| &e.conjuncts) {
+            go_code = format!("{}\n{}", go_code, conjunct.check.go);
+            py_code = format!("{}\n{}", py_code, conjunct.check.py);
+        }
 
         // Check if return type should be HashMap (only when no outputs are defined in spec)
         // When spec.outputs is defined, we always use tuple/single return type
@@ -189,16 +753,172 @@ impl SpecContext {
                 || default.as_ref().is_some_and(|d| d.named.is_some()));
 
         // Determine if we should use match (all rules have simple equality conditions)
-        let use_match = spec.rules.iter().all(|r| {
-            r.conditions
-                .as_ref()
-                .map(|c| c.iter().all(|cond| cond.op == ConditionOp::Eq))
-                .unwrap_or(false)
-        });
+        // Match arms are single expressions, so rules with local bindings or
+        // hit counters need the if-chain
+        let use_match = !spec.codegen.counters
+            && spec.rules.iter().all(|r| {
+                r.vars.is_empty()
+                    && r.conditions
+                        .as_ref()
+                        .map(|c| c.iter().all(|cond| cond.op == ConditionOp::Eq))
+                        .unwrap_or(false)
+            });
 
         // Check if HashMap is needed (for Rust) - only when outputs are dynamic (not defined in spec)
         let needs_hashmap = has_named_outputs;
 
+        let uses_rates = spec_mentions(spec, "convert_currency(");
+        let cache = spec.cache.filter(|_| !uses_rates).map(|c| CacheView {
+            size: c.size,
+            ttl_secs: c.ttl_secs,
+        });
+
+        let has_defaults = spec.inputs.iter().any(|i| i.default.is_some());
+        let has_aliases = spec.inputs.iter().any(|i| !i.aliases.is_empty());
+        let normalizations: Vec<NormalizationView> = spec
+            .normalize
+            .iter()
+            .map(NormalizationView::from_normalization)
+            .collect();
+        let form_fields: Vec<FormFieldView> = if spec.codegen.form_decoding {
+            spec.inputs
+                .iter()
+                .map(|v| {
+                    FormFieldView::from_var(v, spec.codegen.nullable, &to_pascal_case(&spec.id))
+                })
+                .collect()
+        } else {
+            Vec::new()
+        };
+        let sql_args: Vec<SqlArgView> = if spec.codegen.sql_functions {
+            spec.inputs
+                .iter()
+                .map(|v| SqlArgView::from_var(v, spec.codegen.nullable, &to_pascal_case(&spec.id)))
+                .collect()
+        } else {
+            Vec::new()
+        };
+        let sql_functions: Vec<SqlFunctionView> = match spec.outputs.as_slice() {
+            _ if !spec.codegen.sql_functions => Vec::new(),
+            [] | [_] => vec![SqlFunctionView {
+                name: spec.id.clone(),
+                field: None,
+            }],
+            outputs => outputs
+                .iter()
+                .map(|output| SqlFunctionView {
+                    name: format!("{}_{}", spec.id, output.name),
+                    field: Some(to_pascal_case(&output.name)),
+                })
+                .collect(),
+        };
+        let mut sql_kinds: Vec<String> = Vec::new();
+        for arg in &sql_args {
+            if !sql_kinds.contains(&arg.kind) {
+                sql_kinds.push(arg.kind.clone());
+            }
+        }
+        let mut go_imports = Vec::new();
+        if has_optional && spec.codegen.nullable == NullableStyle::SqlNull {
+            go_imports.push("database/sql".to_string());
+            go_imports.push("encoding/json".to_string());
+        }
+        if has_defaults || has_aliases || cache.is_some() {
+            go_imports.push("encoding/json".to_string());
+        }
+        if let Some(cache) = &cache {
+            go_imports.push("container/list".to_string());
+            go_imports.push("sync".to_string());
+            if cache.ttl_secs.is_some() {
+                go_imports.push("time".to_string());
+            }
+        }
+        if !constraints.is_empty() && !spec.codegen.marshalers {
+            go_imports.push("errors".to_string());
+        }
+        if spec.codegen.marshalers {
+            go_imports.push("encoding/json".to_string());
+            go_imports.push("fmt".to_string());
+        }
+        if spec.codegen.batch {
+            go_imports.push("runtime".to_string());
+            go_imports.push("sync".to_string());
+        }
+        if let Some(stream) = &spec.codegen.stream {
+            go_imports.push("context".to_string());
+            go_imports.push("sync".to_string());
+            if stream.workers.is_none() {
+                go_imports.push("runtime".to_string());
+            }
+        }
+        if !postconditions.is_empty() || !explain.is_empty() || spec.codegen.safe {
+            go_imports.push("fmt".to_string());
+        }
+        if spec.codegen.counters {
+            go_imports.push("expvar".to_string());
+        }
+        if spec.codegen.logging {
+            go_imports.push("log/slog".to_string());
+        }
+        if normalizations.iter().any(|n| n.expr_go.is_some()) {
+            go_imports.push("strings".to_string());
+        }
+        if spec.codegen.interface {
+            go_imports.push("context".to_string());
+        }
+        if spec.codegen.service {
+            for pkg in ["bytes", "context", "encoding/json", "fmt", "io", "net/http"] {
+                go_imports.push(pkg.to_string());
+            }
+        }
+        if !form_fields.is_empty() {
+            go_imports.push("fmt".to_string());
+            go_imports.push("net/url".to_string());
+            if form_fields.iter().any(|f| f.parse_go.is_some()) {
+                go_imports.push("strconv".to_string());
+            }
+        }
+        if !sql_functions.is_empty() {
+            go_imports.push("database/sql/driver".to_string());
+            go_imports.push("fmt".to_string());
+        }
+        if go_code.contains("math.") {
+            go_imports.push("math".to_string());
+        }
+        if go_code.contains("fmt.Sprintf(") {
+            go_imports.push("fmt".to_string());
+        }
+        for (path, call) in spec.functions.iter().filter_map(|f| f.go_call()) {
+            if go_code.contains(&format!("{}(", call)) {
+                go_imports.push(path.to_string());
+            }
+        }
+        let uses_helper = |helper: String| {
+            go_code.contains(&helper)
+                || chunks
+                    .iter()
+                    .any(|c| rendered_code(&c.rules, None, Target::Go).contains(&helper))
+        };
+        let uses_if_else = uses_helper(format!("{}IfElse(", id_camel));
+        let uses_bucket = uses_helper(format!("{}Bucket(", id_camel));
+        if uses_bucket {
+            go_imports.push("fmt".to_string());
+            go_imports.push("hash/fnv".to_string());
+            go_imports.push("strconv".to_string());
+        }
+        if spec.codegen.versioned && outputs.iter().any(|o| o.redact.as_deref() == Some("hash")) {
+            for pkg in ["crypto/hmac", "crypto/sha256", "fmt"] {
+                go_imports.push(pkg.to_string());
+            }
+        }
+        if spec.codegen.embed_spec {
+            go_imports.push("crypto/sha256".to_string());
+            go_imports.push("embed".to_string());
+            go_imports.push("fmt".to_string());
+        }
+        go_imports.sort();
+        go_imports.dedup();
+
         // Extract namespace values from scoping config
         let (namespace, package, module_path, module) = extract_namespace_fields(spec, target);
 
@@ -206,12 +926,42 @@ impl SpecContext {
             id: spec.id.clone(),
             id_pascal: to_pascal_case(&spec.id),
             id_camel: to_camel_case(&spec.id),
-            spec_hash: spec.hash(),
+            spec_hash: spec_hash.clone(),
             provenance,
             generated_at: Utc::now().to_rfc3339(),
             inputs,
             outputs,
             rules,
+            constraints,
+            postconditions,
+            registry,
+            counters: spec.codegen.counters,
+            logging: spec.codegen.logging,
+            safe: spec.codegen.safe,
+            service: spec.codegen.service,
+            interface: spec.codegen.interface,
+            explain,
+            shadow: spec.codegen.shadow,
+            batch: spec.codegen.batch,
+            stream: spec.codegen.stream,
+            zero_alloc: spec.codegen.zero_alloc,
+            chunks,
+            marshalers: spec.codegen.marshalers,
+            embed_spec: spec.codegen.embed_spec.then(|| spec.embed_file_name()),
+            embed_hash: if spec.codegen.embed_spec {
+                spec.embed_hash()
+            } else {
+                String::new()
+            },
+            go_build: spec.codegen.go_build(),
+            versioned: spec.codegen.versioned.then(|| SpecVersionView {
+                version: escape_string(spec.meta.version.as_deref().unwrap_or_default()),
+                hash: spec_hash,
+            }),
+            reasons,
+            messages,
+            owners,
+            cache,
             default,
             use_match,
             needs_hashmap,
@@ -222,10 +972,93 @@ impl SpecContext {
             package,
             module_path,
             module,
+            has_optional,
+            nullable: match spec.codegen.nullable {
+                NullableStyle::Pointer => "pointer".into(),
+                NullableStyle::SqlNull => "sql_null".into(),
+            },
+            has_defaults,
+            has_aliases,
+            normalizations,
+            form_fields,
+            sql_args,
+            sql_functions,
+            sql_kinds,
+            go_imports,
+            uses_rates,
+            uses_if_else,
+            uses_bucket,
+            uses_math_py: py_code.contains("math."),
+            uses_functools_py: py_code.contains("functools."),
+            tables: spec
+                .tables
+                .iter()
+                .chain(&spec.geo_tables())
+                .map(TableView::from_table)
+                .collect(),
         }
     }
 }
 
+/// All rendered conditions and output values for one target, joined
+fn rendered_code(rules: &[RuleView], default: Option<&OutputValueView>, target: Target) -> String {
+    let single = |o: &OutputValueView| -> String {
+        match target {
+            Target::Rust => o.rust.clone(),
+            Target::TypeScript => o.ts.clone(),
+            Target::Python => o.py.clone(),
+            Target::Go => o.go.clone(),
+            Target::Java => o.java.clone(),
+            Target::CSharp => o.csharp.clone(),
+        }
+    };
+    let named = |v: &NamedValueView| -> String {
+        match target {
+            Target::Rust => v.rust.clone(),
+            Target::TypeScript => v.ts.clone(),
+            Target::Python => v.py.clone(),
+            Target::Go => v.go.clone(),
+            Target::Java => v.java.clone(),
+            Target::CSharp => v.csharp.clone(),
+        }
+    };
+
+    let mut code = Vec::new();
+    for rule in rules {
+        code.push(match target {
+            Target::Rust => rule.condition_rust.clone(),
+            Target::TypeScript => rule.condition_ts.clone(),
+            Target::Python => rule.condition_py.clone(),
+            Target::Go => rule.condition_go.clone(),
+            Target::Java => rule.condition_java.clone(),
+            Target::CSharp => rule.condition_csharp.clone(),
+        });
+        code.extend(rule.vars.iter().map(|b| named(&b.value)));
+    }
+    for output in rules.iter().map(|r| &r.output).chain(default) {
+        code.push(single(output));
+        if let Some(values) = &output.named {
+            code.extend(values.values().map(&named));
+        }
+    }
+    code.join("\n")
+}
+
+/// Whether any rule condition or output expression contains `needle`
+pub(crate) fn spec_mentions(spec: &Spec, needle: &str) -> bool {
+    let in_output = |output: &Output| match output {
+        Output::Single(ConditionValue::String(s)) => s.contains(needle),
+        Output::Single(ConditionValue::Map(map)) | Output::Named(map) => map
+            .values()
+            .any(|v| matches!(v, ConditionValue::String(s) if s.contains(needle))),
+        _ => false,
+    };
+    spec.rules
+        .iter()
+        .any(|r| r.as_cel().is_some_and(|c| c.contains(needle)) || in_output(&r.then))
+        || spec.default.as_ref().is_some_and(in_output)
+}
+
 /// Extract namespace fields from spec scoping config based on target language
 fn extract_namespace_fields(
     spec: &Spec,
@@ -275,8 +1108,33 @@ fn extract_namespace_fields(
 }
 
 impl InputView {
-    fn from_var(var: &Variable) -> Self {
+    fn from_var(var: &Variable, nullable: NullableStyle, spec_pascal: &str) -> Self {
         let var_type = format_var_type(&var.typ);
+        if var.optional {
+            let go_type = match nullable {
+                NullableStyle::Pointer => format!("*{}", map_type_go(&var.typ)),
+                NullableStyle::SqlNull => {
+                    format!("{}Null[{}]", spec_pascal, map_type_go(&var.typ))
+                }
+            };
+            return Self {
+                name: var.name.clone(),
+                name_pascal: to_pascal_case(&var.name),
+                name_camel: to_camel_case(&var.name),
+                var_type,
+                rust_type: format!("Option<{}>", map_type_rust(&var.typ)),
+                ts_type: format!("{} | undefined", map_type_ts(&var.typ)),
+                py_type: format!("{} | None", map_type_python(&var.typ)),
+                go_type,
+                java_type: map_type_java_boxed(&var.typ),
+                csharp_type: format!("{}?", map_type_csharp(&var.typ)),
+                optional: true,
+                default: None,
+                unit: var.unit.as_deref().map(crate::units::canonical),
+                currency: var.currency.clone(),
+                aliases: var.aliases.clone(),
+            };
+        }
         Self {
             name: var.name.clone(),
             name_pascal: to_pascal_case(&var.name),
@@ -288,6 +1146,11 @@ impl InputView {
             go_type: map_type_go(&var.typ),
             java_type: map_type_java(&var.typ),
             csharp_type: map_type_csharp(&var.typ),
+            optional: false,
+            default: var.default.as_ref().map(NamedValueView::literal),
+            unit: var.unit.as_deref().map(crate::units::canonical),
+            currency: var.currency.clone(),
+            aliases: var.aliases.clone(),
         }
     }
 }
@@ -306,12 +1169,21 @@ impl OutputView {
             go_type: map_type_go(&var.typ),
             java_type: map_type_java(&var.typ),
             csharp_type: map_type_csharp(&var.typ),
+            redact: var.redaction().map(|redact| match redact {
+                Redact::Mask => "mask".to_string(),
+                Redact::Hash => "hash".to_string(),
+            }),
         }
     }
 }
 
 impl RuleView {
-    fn from_rule(rule: &Rule, input_names: &[String], inputs: &[Variable]) -> Self {
+    fn from_rule(
+        rule: &Rule,
+        input_names: &[String],
+        inputs: &[Variable],
+        functions: &[CustomFunction],
+    ) -> Self {
         let cel_expr = rule.as_cel();
         let is_cel = cel_expr.is_some();
 
@@ -347,7 +1219,46 @@ impl RuleView {
         let pattern_rust = generate_rust_pattern(rule, inputs);
         let pattern_py = generate_python_pattern(rule, inputs);
 
-        let output = OutputValueView::from_output(&rule.then, input_names);
+        let local_names: Vec<String> = rule.vars.iter().map(|b| b.name.clone()).collect();
+        let mut vars: Vec<BindingView> = rule
+            .vars
+            .iter()
+            .map(|b| BindingView {
+                name: b.name.clone(),
+                name_camel: to_camel_case(&b.name),
+                value: NamedValueView::expression(&b.expr, input_names),
+            })
+            .collect();
+        for binding in &mut vars {
+            binding.value.rename_locals(&local_names);
+        }
+
+        let mut output = OutputValueView::from_output(&rule.then, input_names, functions);
+        // A bare binding name is a reference, not a string literal
+        match &rule.then {
+            Output::Single(ConditionValue::String(s)) if local_names.contains(s) => {
+                let local = NamedValueView::local(s);
+                output.rust = local.rust;
+                output.ts = local.ts;
+                output.py = local.py;
+                output.go = local.go;
+                output.java = local.java;
+                output.csharp = local.csharp;
+            }
+            Output::Single(ConditionValue::Map(map)) | Output::Named(map) => {
+                if let Some(named) = &mut output.named {
+                    for (key, value) in map {
+                        if let ConditionValue::String(s) = value {
+                            if local_names.contains(s) {
+                                named.insert(key.clone(), NamedValueView::local(s));
+                            }
+                        }
+                    }
+                }
+            }
+            _ => {}
+        }
+        output.rename_locals(&local_names);
 
         Self {
             id: rule.id.clone(),
@@ -359,6 +1270,7 @@ impl RuleView {
             condition_csharp,
             pattern_rust,
             pattern_py,
+            vars,
             output,
             is_cel,
             cel_expr,
@@ -367,7 +1279,8 @@ impl RuleView {
 }
 
 impl OutputValueView {
-    fn from_output(output: &Output, input_names: &[String]) -> Self {
+    /// `functions` are the project's, which only Go calls
+    fn from_output(output: &Output, input_names: &[String], functions: &[CustomFunction]) -> Self {
         // Helper to build named output view from a map
         let build_named = |map: &HashMap<String, ConditionValue>| -> Self {
             let named: HashMap<String, NamedValueView> = map
@@ -379,7 +1292,7 @@ impl OutputValueView {
                             rust: render_value_rust(v, input_names),
                             ts: render_value_ts(v, input_names),
                             py: render_value_python(v, input_names),
-                            go: render_value_go(v, input_names),
+                            go: render_value_go(v, input_names, functions),
                             java: render_value_java(v, input_names),
                             csharp: render_value_csharp(v, input_names),
                         },
@@ -406,7 +1319,7 @@ impl OutputValueView {
                 rust: render_value_rust(val, input_names),
                 ts: render_value_ts(val, input_names),
                 py: render_value_python(val, input_names),
-                go: render_value_go(val, input_names),
+                go: render_value_go(val, input_names, functions),
                 java: render_value_java(val, input_names),
                 csharp: render_value_csharp(val, input_names),
                 named: None,
@@ -416,6 +1329,236 @@ impl OutputValueView {
     }
 }
 
+impl NamedValueView {
+    /// Render a CEL expression in every target
+    fn expression(expr: &str, input_names: &[String]) -> Self {
+        Self {
+            rust: CelCompiler::compile(expr, Target::Rust).unwrap_or_else(|_| expr.to_string()),
+            ts: compile_ts_expression(expr, input_names),
+            py: CelCompiler::compile(expr, Target::Python).unwrap_or_else(|_| expr.to_string()),
+            go: compile_go_expression(expr, input_names),
+            java: compile_java_expression(expr, input_names),
+            csharp: compile_csharp_expression(expr, input_names),
+        }
+    }
+
+    /// Render a CEL condition in every target
+    fn condition(cel: &str, input_names: &[String]) -> Self {
+        Self {
+            rust: CelCompiler::compile(cel, Target::Rust).unwrap_or_else(|_| "true".into()),
+            ts: compile_ts_condition(cel, input_names),
+            py: CelCompiler::compile(cel, Target::Python).unwrap_or_else(|_| "True".into()),
+            go: compile_go_condition(cel, input_names),
+            java: compile_java_condition(cel, input_names),
+            csharp: compile_csharp_condition(cel, input_names),
+        }
+    }
+
+    /// Reference to a rule-local binding
+    fn local(name: &str) -> Self {
+        let camel = to_camel_case(name);
+        Self {
+            rust: name.to_string(),
+            ts: camel.clone(),
+            py: name.to_string(),
+            go: camel.clone(),
+            java: camel.clone(),
+            csharp: camel,
+        }
+    }
+
+    /// Use camelCase for local binding names in targets that expect it
+    fn rename_locals(&mut self, locals: &[String]) {
+        for name in locals.iter().filter(|n| n.contains('_')) {
+            let camel = to_camel_case(name);
+            self.ts = replace_var_name(&self.ts, name, &camel);
+            self.go = replace_var_name(&self.go, name, &camel);
+            self.java = replace_var_name(&self.java, name, &camel);
+            self.csharp = replace_var_name(&self.csharp, name, &camel);
+        }
+    }
+
+    /// Render a literal value (strings are never treated as expressions)
+    fn literal(val: &ConditionValue) -> Self {
+        if let ConditionValue::String(s) = val {
+            let quoted = format!("\"{}\"", escape_string(s));
+            return Self {
+                rust: format!("{}.to_string()", quoted),
+                ts: quoted.clone(),
+                py: quoted.clone(),
+                go: quoted.clone(),
+                java: quoted.clone(),
+                csharp: quoted,
+            };
+        }
+        Self {
+            rust: render_value_rust(val, &[]),
+            ts: render_value_ts(val, &[]),
+            py: render_value_python(val, &[]),
+            go: render_value_go(val, &[], &[]),
+            java: render_value_java(val, &[]),
+            csharp: render_value_csharp(val, &[]),
+        }
+    }
+}
+
+impl ConstraintView {
+    fn from_constraint(constraint: &Constraint, input_names: &[String]) -> Self {
+        let cel = constraint.to_cel();
+        Self {
+            id: constraint.id.clone(),
+            message: escape_string(&constraint.violation_message()),
+            check: NamedValueView::condition(&cel, input_names),
+        }
+    }
+}
+
+impl ExplainView {
+    /// Messages report the inputs each conjunct read, masking the ones
+    /// marked for redaction
+    fn from_rule(rule: &Rule, input_names: &[String], inputs: &[Variable]) -> Self {
+        let masked = |name: &String| {
+            inputs
+                .iter()
+                .any(|v| &v.name == name && v.redaction().is_some())
+        };
+        let conjuncts = rule
+            .conjuncts()
+            .into_iter()
+            .map(|conjunct| {
+                let prefix = escape_string(&format!("{} did not match: {}", rule.id, conjunct));
+                // Inputs the conjunct reads, reported alongside the failure
+                let read: Vec<String> = CelCompiler::extract_variables(&conjunct)
+                    .map(|vars| {
+                        input_names
+                            .iter()
+                            .filter(|n| vars.contains(n))
+                            .cloned()
+                            .collect()
+                    })
+                    .unwrap_or_default();
+                let message = if read.is_empty() {
+                    let quoted = format!("\"{}\"", prefix);
+                    NamedValueView {
+                        rust: format!("{}.to_string()", quoted),
+                        ts: quoted.clone(),
+                        py: quoted.clone(),
+                        go: quoted.clone(),
+                        java: quoted.clone(),
+                        csharp: quoted,
+                    }
+                } else {
+                    // "a was <a>, b was ***", with `shown` rendering a value read
+                    let join = |shown: &dyn Fn(&String) -> String| {
+                        read.iter()
+                            .map(|n| {
+                                if masked(n) {
+                                    format!("{} was {}", n, crate::spec::MASK)
+                                } else {
+                                    format!("{} was {}", n, shown(n))
+                                }
+                            })
+                            .collect::<Vec<_>>()
+                            .join(", ")
+                    };
+                    // Format arguments of the values shown
+                    let args = |render: &dyn Fn(&String) -> String| {
+                        read.iter()
+                            .filter(|n| !masked(n))
+                            .map(|n| format!(", {}", render(n)))
+                            .collect::<String>()
+                    };
+                    // "<prefix> (a was " + <a> + ", b was " + <b> + ")"
+                    let concat = |render: &dyn Fn(&String) -> String| {
+                        let parts = join(&|n| format!("\" + {} + \"", render(n)));
+                        format!("\"{} ({})\"", prefix, parts)
+                    };
+                    NamedValueView {
+                        rust: format!(
+                            "format!(\"{{}} ({})\", \"{}\"{})",
+                            join(&|_| "{:?}".to_string()),
+                            prefix,
+                            args(&|n| n.clone())
+                        ),
+                        ts: concat(&|n| format!("JSON.stringify({})", to_camel_case(n))),
+                        py: concat(&|n| format!("repr({})", n)),
+                        go: format!(
+                            "fmt.Sprintf(\"%s ({})\", \"{}\"{})",
+                            join(&|_| "%v".to_string()),
+                            prefix,
+                            args(&|n| format!("input.{}", to_pascal_case(n)))
+                        ),
+                        java: concat(&|n| format!("input.{}", to_camel_case(n))),
+                        csharp: concat(&|n| to_camel_case(n)),
+                    }
+                };
+                ConjunctView {
+                    check: NamedValueView::condition(&conjunct, input_names),
+                    message,
+                }
+            })
+            .collect();
+        Self {
+            id: rule.id.clone(),
+            conjuncts,
+        }
+    }
+}
+
+impl ConstraintView {
+    /// Postconditions read outputs from the evaluated `result`: Go and
+    /// TypeScript access it directly, the other targets bind each output
+    /// to a local of the same name first
+    fn from_postcondition(
+        postcondition: &Constraint,
+        input_names: &[String],
+        outputs: &[Variable],
+    ) -> Self {
+        let mut view = Self::from_constraint(postcondition, input_names);
+        for output in outputs {
+            let (go, ts) = if outputs.len() == 1 {
+                ("result".to_string(), "result".to_string())
+            } else {
+                (
+                    format!("result.{}", to_pascal_case(&output.name)),
+                    format!("result.{}", to_camel_case(&output.name)),
+                )
+            };
+            view.check.go = replace_var_name(&view.check.go, &output.name, &go);
+            view.check.ts = replace_var_name(&view.check.ts, &output.name, &ts);
+        }
+        view
+    }
+}
+
+impl OutputValueView {
+    /// Use camelCase for local binding names in targets that expect it
+    fn rename_locals(&mut self, locals: &[String]) {
+        for name in locals.iter().filter(|n| n.contains('_')) {
+            let camel = to_camel_case(name);
+            self.ts = replace_var_name(&self.ts, name, &camel);
+            self.go = replace_var_name(&self.go, name, &camel);
+            self.java = replace_var_name(&self.java, name, &camel);
+            self.csharp = replace_var_name(&self.csharp, name, &camel);
+        }
+        if let Some(named) = &mut self.named {
+            for value in named.values_mut() {
+                value.rename_locals(locals);
+            }
+        }
+    }
+
+    /// Prefix Go nullable helper calls with the spec name
+    fn scope_go_helpers(&mut self, id_camel: &str, functions: &[CustomFunction]) {
+        self.go = scope_go_helpers(&self.go, id_camel, functions);
+        if let Some(named) = &mut self.named {
+            for value in named.values_mut() {
+                value.go = scope_go_helpers(&value.go, id_camel, functions);
+            }
+        }
+    }
+}
+
 // Re-export from shared util module
 use crate::util::{to_camel_case, to_pascal_case};
 
@@ -617,13 +1760,17 @@ fn render_value_python(val: &ConditionValue, input_names: &[String]) -> String {
     }
 }
 
-fn render_value_go(val: &ConditionValue, input_names: &[String]) -> String {
+fn render_value_go(
+    val: &ConditionValue,
+    input_names: &[String],
+    functions: &[CustomFunction],
+) -> String {
     match val {
         ConditionValue::Bool(b) => b.to_string(),
         ConditionValue::Int(i) => format!("int64({})", i),
         ConditionValue::Float(f) => format!("float64({:?})", f),
         ConditionValue::String(s) => {
-            if is_expression(s) {
+            if is_expression_calling(s, functions) {
                 compile_go_expression(s, input_names)
             } else {
                 format!("\"{}\"", escape_string(s))
@@ -633,14 +1780,14 @@ fn render_value_go(val: &ConditionValue, input_names: &[String]) -> String {
         ConditionValue::List(items) => {
             let rendered: Vec<_> = items
                 .iter()
-                .map(|i| render_value_go(i, input_names))
+                .map(|i| render_value_go(i, input_names, functions))
                 .collect();
             format!("[]interface{{}}{{{}}}", rendered.join(", "))
         }
         ConditionValue::Map(map) => {
             let pairs: Vec<_> = map
                 .iter()
-                .map(|(k, v)| format!("\"{}\": {}", k, render_value_go(v, input_names)))
+                .map(|(k, v)| format!("\"{}\": {}", k, render_value_go(v, input_names, functions)))
                 .collect();
             format!("map[string]interface{{}}{{{}}}", pairs.join(", "))
         }
@@ -711,38 +1858,6 @@ fn render_value_csharp(val: &ConditionValue, input_names: &[String]) -> String {
 // Expression and pattern helpers
 // ============================================================================
 
-fn is_expression(s: &str) -> bool {
-    let has_operator = s.contains(" + ")
-        || s.contains(" - ")
-        || s.contains(" * ")
-        || s.contains(" / ")
-        || s.contains(" % ")
-        || s.contains(" == ")
-        || s.contains(" != ")
-        || s.contains(" < ")
-        || s.contains(" > ")
-        || s.contains(" <= ")
-        || s.contains(" >= ")
-        || s.contains(" && ")
-        || s.contains(" || ")
-        || s.contains(" ? ");
-
-    if has_operator {
-        return true;
-    }
-
-    // Check if it looks like a variable reference
-    if !s.contains(' ')
-        && s.chars()
-            .all(|c| c.is_alphanumeric() || c == '_' || c == '.')
-        && (s.contains('_') || s.contains('.'))
-    {
-        return true;
-    }
-
-    false
-}
-
 fn escape_string(s: &str) -> String {
     s.replace('\\', "\\\\")
         .replace('"', "\\\"")
@@ -787,6 +1902,48 @@ fn compile_go_condition(cel: &str, input_names: &[String]) -> String {
     result
 }
 
+/// Rename `isPresent`/`coalesce`/`ifElse`/`bucket` calls to the
+/// spec-scoped Go helpers, and project function calls to their
+/// implementations
+fn scope_go_helpers(code: &str, id_camel: &str, functions: &[CustomFunction]) -> String {
+    let code = replace_var_name(code, "isPresent", &format!("{}IsPresent", id_camel));
+    let code = replace_var_name(&code, "coalesce", &format!("{}Coalesce", id_camel));
+    let code = replace_var_name(&code, "bucket", &format!("{}Bucket", id_camel));
+    let code = scope_go_lookups(&code, id_camel);
+    let mut code = replace_var_name(&code, "ifElse", &format!("{}IfElse", id_camel));
+    for function in functions {
+        if let Some((_, call)) = function.go_call() {
+            code = replace_var_name(&code, &function.name, &call);
+        }
+    }
+    code
+}
+
+/// Rename `lookup<Table>` calls to the spec-scoped table helpers
+fn scope_go_lookups(code: &str, id_camel: &str) -> String {
+    let mut result = String::new();
+    let mut remaining = code;
+    while let Some(pos) = remaining.find("lookup") {
+        let before_ok = remaining[..pos]
+            .chars()
+            .next_back()
+            .is_none_or(|c| !c.is_ascii_alphanumeric() && c != '_' && c != '.');
+        let after_ok = remaining[pos + 6..]
+            .chars()
+            .next()
+            .is_some_and(|c| c.is_ascii_uppercase());
+        result.push_str(&remaining[..pos]);
+        if before_ok && after_ok {
+            result.push_str(&format!("{}Lookup", id_camel));
+        } else {
+            result.push_str("lookup");
+        }
+        remaining = &remaining[pos + 6..];
+    }
+    result.push_str(remaining);
+    result
+}
+
 fn compile_java_condition(cel: &str, input_names: &[String]) -> String {
     let mut result = CelCompiler::compile(cel, Target::Java).unwrap_or_else(|_| "true".into());
     // Java uses input.fieldName pattern
@@ -968,6 +2125,36 @@ pub struct OrchestratorContext {
     pub outputs: Vec<OutputView>,
     /// Steps
     pub steps: Vec<StepView>,
+    /// Topics of the event-driven trigger, if any
+    pub trigger: Option<TriggerView>,
+    /// Whether to emit the checkpointing runner
+    pub durable: bool,
+    /// Whether to emit transition callbacks
+    pub hooks: bool,
+    /// Whether the flow takes a context carrying a trace ID
+    pub tracing: bool,
+    /// Whether to log steps and gates through the generated slog logger
+    pub logging: bool,
+    /// Whether any call step is side-effecting, so a dry run is emitted
+    pub dry_run: bool,
+    /// Whether step results can be mocked, for generated scenario tests
+    pub mockable: bool,
+    /// Whether any call step is rate-limited or circuit-broken
+    pub guarded: bool,
+    /// Concurrency limit of the `Execute` variant, if any
+    pub bulkhead: Option<crate::orchestrate::Bulkhead>,
+    /// Time budget of a run (ms), if any call step shares it
+    pub deadline_ms: Option<u64>,
+    /// Whether to emit the dependency struct and its constructor
+    pub injectable: bool,
+    /// Whether any call step can degrade, so runs record degraded steps
+    pub degradable: bool,
+    /// Whether a gate failure reports a hashed input, so the hash helper
+    /// is emitted
+    pub hashes_values: bool,
+    /// Hashes of the called specs the flow is generated against, checked
+    /// at startup
+    pub dependencies: Vec<DependencyView>,
     /// Target language
     pub target: String,
     // Namespace fields for scoping
@@ -981,6 +2168,23 @@ pub struct OrchestratorContext {
     pub module: Option<String>,
 }
 
+/// A spec a flow calls, with the hash of the version it was generated against
+#[derive(Debug, Clone, Serialize)]
+pub struct DependencyView {
+    pub id: String,
+    pub id_pascal: String,
+    pub hash: String,
+}
+
+/// View of an orchestrator's trigger topics
+#[derive(Debug, Clone, Serialize)]
+pub struct TriggerView {
+    pub topic: String,
+    pub result_topic: String,
+    /// Falls back to the result topic
+    pub error_topic: String,
+}
+
 /// View of an orchestrator step
 #[derive(Debug, Clone, Serialize)]
 pub struct StepView {
@@ -1013,6 +2217,44 @@ pub struct StepView {
     pub input_mappings: Vec<InputMapping>,
     /// Output mappings for Call steps: local_name -> spec_output_name
     pub output_mappings: Vec<OutputMapping>,
+    /// For approval steps: the stage (from 1) the flow resumes into
+    pub approval_stage: Option<usize>,
+    /// For approval steps: what the reviewer is asked, escaped for a string literal
+    pub prompt: Option<String>,
+    /// For call steps: whether the call is declared side-effecting
+    pub side_effect: bool,
+    /// For call steps: rate limit, if declared
+    pub rate_limit: Option<crate::orchestrate::RateLimit>,
+    /// For call steps: circuit breaker, if declared
+    pub circuit_breaker: Option<crate::orchestrate::CircuitBreaker>,
+    /// For call steps: whether the call goes through the flow's limiter and
+    /// breaker
+    pub guarded: bool,
+    /// For call steps: whether the step is skipped when the deadline is short
+    pub optional: bool,
+    /// For call steps: share of the flow deadline (ms), when there is one
+    pub budget_ms: Option<u64>,
+    /// For call steps: whether a failed or skipped call falls back to
+    /// `default_go` instead of failing the flow
+    pub degrade: bool,
+    /// For degrading call steps: the declared default outputs in Go
+    pub default_go: Option<String>,
+    /// For gates: failure message, a Go format string taking `gate_values`
+    /// in order when there are any
+    pub gate_message: Option<String>,
+    /// For gates: the values the condition reads, reported when it fails
+    pub gate_values: Vec<GateValue>,
+}
+
+/// A value read by a gate condition
+#[derive(Debug, Clone, Serialize)]
+pub struct GateValue {
+    /// Reference as written in the condition ("check_access.level")
+    pub name: String,
+    /// Compiled expression in Go
+    pub expr_go: String,
+    /// Redaction of a personal flow input: "mask" or "hash"
+    pub redact: Option<String>,
 }
 
 /// Input mapping for a Call step
@@ -1046,17 +2288,20 @@ pub struct OutputMapping {
 impl OrchestratorContext {
     pub fn from_orchestrator(
         orch: &crate::orchestrate::Orchestrator,
-        _specs: &HashMap<String, Spec>,
+        specs: &HashMap<String, Spec>,
         target: Target,
         provenance: bool,
     ) -> Self {
-        use crate::orchestrate::ChainStep;
+        use crate::orchestrate::{ChainStep, OnError};
+        use crate::testgen::orchestrator::go_literal;
 
         let inputs: Vec<InputView> = orch.inputs.iter().map(InputView::from_orch_var).collect();
         let outputs: Vec<OutputView> = orch.outputs.iter().map(OutputView::from_orch_var).collect();
         let input_names: Vec<String> = inputs.iter().map(|i| i.name.clone()).collect();
 
-        let steps: Vec<StepView> = orch
+        // Approval steps split the flow into stages that can be resumed
+        let mut approvals = 0;
+        let mut steps: Vec<StepView> = orch
             .chain
             .iter()
             .map(|s| {
@@ -1130,10 +2375,27 @@ impl OrchestratorContext {
                             condition_csharp,
                             input_mappings,
                             output_mappings,
+                            approval_stage: None,
+                            prompt: None,
+                            side_effect: call.side_effect,
+                            rate_limit: call.rate_limit.clone(),
+                            circuit_breaker: call.circuit_breaker.clone(),
+                            guarded: call.rate_limit.is_some() || call.circuit_breaker.is_some(),
+                            optional: call.optional,
+                            budget_ms: None,
+                            degrade: call.on_error == Some(OnError::Default),
+                            default_go: (call.on_error == Some(OnError::Default)).then(|| {
+                                go_literal(&ConditionValue::Map(
+                                    call.default.clone().into_iter().collect(),
+                                ))
+                            }),
+                            gate_message: None,
+                            gate_values: Vec::new(),
                         }
                     }
                     ChainStep::Gate(gate) => {
                         let cond = gate.condition.clone();
+                        let references = condition_references(&cond);
                         StepView {
                             id: gate.id.clone(),
                             step_type: "Gate".to_string(),
@@ -1152,6 +2414,33 @@ impl OrchestratorContext {
                             condition_csharp: Some(compile_orch_expr_csharp(&cond, &input_names)),
                             input_mappings: Vec::new(),
                             output_mappings: Vec::new(),
+                            approval_stage: None,
+                            prompt: None,
+                            side_effect: false,
+                            rate_limit: None,
+                            circuit_breaker: None,
+                            guarded: false,
+                            optional: false,
+                            budget_ms: None,
+                            degrade: false,
+                            default_go: None,
+                            gate_message: Some(gate_message(&cond, &references)),
+                            gate_values: references
+                                .iter()
+                                .map(|name| GateValue {
+                                    name: name.clone(),
+                                    expr_go: compile_orch_expr_go(name, &input_names),
+                                    redact: orch
+                                        .inputs
+                                        .iter()
+                                        .find(|i| &i.name == name)
+                                        .and_then(|i| i.redaction())
+                                        .map(|r| match r {
+                                            Redact::Mask => "mask".to_string(),
+                                            Redact::Hash => "hash".to_string(),
+                                        }),
+                                })
+                                .collect(),
                         }
                     }
                     ChainStep::Compute(compute) => StepView {
@@ -1172,6 +2461,18 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
                     ChainStep::Branch(branch) => {
                         let cond = branch.on.clone();
@@ -1193,6 +2494,18 @@ impl OrchestratorContext {
                             condition_csharp: Some(compile_orch_expr_csharp(&cond, &input_names)),
                             input_mappings: Vec::new(),
                             output_mappings: Vec::new(),
+                            approval_stage: None,
+                            prompt: None,
+                            side_effect: false,
+                            rate_limit: None,
+                            circuit_breaker: None,
+                            guarded: false,
+                            optional: false,
+                            budget_ms: None,
+                            degrade: false,
+                            default_go: None,
+                            gate_message: None,
+                            gate_values: Vec::new(),
                         }
                     }
                     ChainStep::Loop(loop_step) => {
@@ -1227,6 +2540,18 @@ impl OrchestratorContext {
                                 .map(|c| compile_orch_expr_csharp(c, &input_names)),
                             input_mappings: Vec::new(),
                             output_mappings: Vec::new(),
+                            approval_stage: None,
+                            prompt: None,
+                            side_effect: false,
+                            rate_limit: None,
+                            circuit_breaker: None,
+                            guarded: false,
+                            optional: false,
+                            budget_ms: None,
+                            degrade: false,
+                            default_go: None,
+                            gate_message: None,
+                            gate_values: Vec::new(),
                         }
                     }
                     ChainStep::ForEach(foreach) => StepView {
@@ -1247,6 +2572,18 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
                     ChainStep::Parallel(par) => StepView {
                         id: par.id.clone(),
@@ -1266,6 +2603,18 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
                     ChainStep::Return(ret) => {
                         let cond = ret.condition.clone();
@@ -1299,6 +2648,18 @@ impl OrchestratorContext {
                                 .map(|c| compile_orch_expr_csharp(c, &input_names)),
                             input_mappings: Vec::new(),
                             output_mappings: Vec::new(),
+                            approval_stage: None,
+                            prompt: None,
+                            side_effect: false,
+                            rate_limit: None,
+                            circuit_breaker: None,
+                            guarded: false,
+                            optional: false,
+                            budget_ms: None,
+                            degrade: false,
+                            default_go: None,
+                            gate_message: None,
+                            gate_values: Vec::new(),
                         }
                     }
                     ChainStep::Set(set) => StepView {
@@ -1319,6 +2680,18 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
                     ChainStep::Try(try_step) => StepView {
                         id: try_step.id.clone(),
@@ -1338,6 +2711,18 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
                     ChainStep::Dynamic(dyn_step) => {
                         // Similar to Call step but with dynamic spec selection
@@ -1372,6 +2757,18 @@ impl OrchestratorContext {
                             condition_csharp: None,
                             input_mappings,
                             output_mappings: Vec::new(),
+                            approval_stage: None,
+                            prompt: None,
+                            side_effect: false,
+                            rate_limit: None,
+                            circuit_breaker: None,
+                            guarded: false,
+                            optional: false,
+                            budget_ms: None,
+                            degrade: false,
+                            default_go: None,
+                            gate_message: None,
+                            gate_values: Vec::new(),
                         }
                     }
                     ChainStep::Await(await_step) => StepView {
@@ -1392,6 +2789,18 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
                     ChainStep::Emit(emit) => StepView {
                         id: format!("emit_{}", emit.event),
@@ -1411,13 +2820,102 @@ impl OrchestratorContext {
                         condition_csharp: None,
                         input_mappings: Vec::new(),
                         output_mappings: Vec::new(),
+                        approval_stage: None,
+                        prompt: None,
+                        side_effect: false,
+                        rate_limit: None,
+                        circuit_breaker: None,
+                        guarded: false,
+                        optional: false,
+                        budget_ms: None,
+                        degrade: false,
+                        default_go: None,
+                        gate_message: None,
+                        gate_values: Vec::new(),
                     },
+                    ChainStep::AwaitApproval(approval) => {
+                        approvals += 1;
+                        StepView {
+                            id: approval.id.clone(),
+                            step_type: "AwaitApproval".to_string(),
+                            spec_id: None,
+                            is_gate: false,
+                            is_call: false,
+                            is_compute: false,
+                            is_branch: false,
+                            is_loop: false,
+                            condition: None,
+                            condition_rust: None,
+                            condition_ts: None,
+                            condition_py: None,
+                            condition_go: None,
+                            condition_java: None,
+                            condition_csharp: None,
+                            input_mappings: Vec::new(),
+                            output_mappings: Vec::new(),
+                            approval_stage: Some(approvals),
+                            prompt: approval.prompt.as_deref().map(escape_string),
+                            side_effect: false,
+                            rate_limit: None,
+                            circuit_breaker: None,
+                            guarded: false,
+                            optional: false,
+                            budget_ms: None,
+                            degrade: false,
+                            default_go: None,
+                            gate_message: None,
+                            gate_values: Vec::new(),
+                        }
+                    }
                 }
             })
             .collect();
 
         // Extract namespace from orchestrator's scoping config if present
         let (namespace, package, module_path, module) = extract_orch_namespace_fields(orch, target);
+        let dry_run = steps.iter().any(|s| s.side_effect);
+        let guarded = steps.iter().any(|s| s.guarded);
+        let degradable = steps.iter().any(|s| s.degrade);
+        let hashes_values = steps
+            .iter()
+            .flat_map(|s| &s.gate_values)
+            .any(|v| v.redact.as_deref() == Some("hash"));
+        let dependencies = orch
+            .referenced_specs()
+            .iter()
+            .filter_map(|id| specs.get(id))
+            .map(|spec| DependencyView {
+                id: spec.id.clone(),
+                id_pascal: to_pascal_case(&spec.id),
+                hash: spec.hash(),
+            })
+            .collect();
+
+        // Call steps share the deadline by weight
+        let deadline_ms = orch.deadline_ms.filter(|_| steps.iter().any(|s| s.is_call));
+        if let Some(deadline) = deadline_ms {
+            let weight = |id: &str| {
+                orch.chain
+                    .iter()
+                    .find_map(|s| match s {
+                        ChainStep::Call(call) if call.id == id => call.weight,
+                        _ => None,
+                    })
+                    .unwrap_or(1) as u64
+            };
+            let total: u64 = steps
+                .iter()
+                .filter(|s| s.is_call)
+                .map(|s| weight(&s.id))
+                .sum();
+            for step in steps.iter_mut().filter(|s| s.is_call) {
+                step.budget_ms = Some(if total == 0 {
+                    0
+                } else {
+                    deadline * weight(&step.id) / total
+                });
+            }
+        }
 
         Self {
             id: orch.id.clone(),
@@ -1428,6 +2926,24 @@ impl OrchestratorContext {
             inputs,
             outputs,
             steps,
+            trigger: orch.trigger.as_ref().map(|t| TriggerView {
+                topic: escape_string(&t.topic),
+                result_topic: escape_string(&t.result_topic),
+                error_topic: escape_string(t.error_topic.as_ref().unwrap_or(&t.result_topic)),
+            }),
+            durable: orch.durable,
+            hooks: orch.hooks,
+            tracing: orch.tracing,
+            logging: orch.logging,
+            dry_run,
+            mockable: !orch.scenarios.is_empty(),
+            guarded,
+            bulkhead: orch.bulkhead.clone(),
+            deadline_ms,
+            injectable: orch.injectable,
+            degradable,
+            hashes_values,
+            dependencies,
             target: format!("{:?}", target),
             namespace,
             package,
@@ -1499,6 +3015,11 @@ impl InputView {
             go_type: map_type_go(&var.var_type),
             java_type: map_type_java(&var.var_type),
             csharp_type: map_type_csharp(&var.var_type),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
         }
     }
 }
@@ -1517,6 +3038,7 @@ impl OutputView {
             go_type: map_type_go(&var.var_type),
             java_type: map_type_java(&var.var_type),
             csharp_type: map_type_csharp(&var.var_type),
+            redact: None,
         }
     }
 }
@@ -1525,6 +3047,84 @@ impl OutputView {
 // Orchestrator expression compilation helpers
 // ============================================================================
 
+/// Input and step references in a flow condition, in order of appearance
+fn condition_references(cond: &str) -> Vec<String> {
+    let mut refs: Vec<String> = Vec::new();
+    let mut chars = cond.char_indices().peekable();
+    while let Some((start, c)) = chars.next() {
+        if c == '\'' || c == '"' {
+            // Skip string literals
+            let mut escaped = false;
+            for (_, next) in chars.by_ref() {
+                if next == c && !escaped {
+                    break;
+                }
+                escaped = next == '\\' && !escaped;
+            }
+        } else if c.is_ascii_alphabetic() || c == '_' {
+            let mut end = start + c.len_utf8();
+            while let Some(&(i, next)) = chars.peek() {
+                if next.is_ascii_alphanumeric() || next == '_' || next == '.' {
+                    end = i + next.len_utf8();
+                    chars.next();
+                } else {
+                    break;
+                }
+            }
+            let name = cond[start..end].trim_end_matches('.');
+            let is_call = cond[end..].trim_start().starts_with('(');
+            let keyword = matches!(name, "true" | "false" | "null" | "in");
+            if !is_call && !keyword && !refs.iter().any(|r| r == name) {
+                refs.push(name.to_string());
+            }
+        } else if c.is_ascii_digit() {
+            // Skip numbers, including `1.5` and `1e3`
+            while matches!(chars.peek(), Some(&(_, next)) if next.is_ascii_alphanumeric() || next == '.')
+            {
+                chars.next();
+            }
+        }
+    }
+    refs
+}
+
+/// Go format string describing a failed gate
+///
+/// A single comparison against a constant reads as
+/// "check_access.level was %v, required >= 50"; anything else lists every
+/// value the condition read.
+fn gate_message(cond: &str, refs: &[String]) -> String {
+    if refs.is_empty() {
+        return escape_string(&format!("Gate condition failed: {}", cond));
+    }
+    let format_escape = |s: &str| escape_string(&s.replace('%', "%%"));
+    let compound = cond.contains("&&") || cond.contains("||");
+    if !compound && refs.len() == 1 {
+        for op in [">=", "<=", "==", "!=", ">", "<"] {
+            if let Some((lhs, rhs)) = cond.split_once(op) {
+                if lhs.trim() == refs[0] && !rhs.contains(op) {
+                    return format!(
+                        "{} was %v, required {} {}",
+                        format_escape(lhs.trim()),
+                        op,
+                        format_escape(rhs.trim())
+                    );
+                }
+                break;
+            }
+        }
+    }
+    let values: Vec<String> = refs
+        .iter()
+        .map(|r| format!("{}=%v", format_escape(r)))
+        .collect();
+    format!(
+        "Gate condition failed: {} ({})",
+        format_escape(cond),
+        values.join(", ")
+    )
+}
+
 /// Compile an orchestrator expression to Rust syntax
 /// Expressions can reference:
 /// - Input fields: "field_name" -> "input.field_name"
@@ -1646,15 +3246,6 @@ mod tests {
         assert_eq!(to_camel_case("foo"), "foo");
     }
 
-    #[test]
-    fn test_is_expression() {
-        assert!(is_expression("a + b"));
-        assert!(is_expression("x == 5"));
-        assert!(is_expression("foo_bar"));
-        assert!(!is_expression("hello"));
-        assert!(!is_expression("OK"));
-    }
-
     #[test]
     fn test_replace_var_name() {
         // Simple replacement
@@ -1708,4 +3299,27 @@ mod tests {
             result
         );
     }
+
+    #[test]
+    fn test_gate_message() {
+        let refs = condition_references("check_access.level >= 50");
+        assert_eq!(refs, vec!["check_access.level"]);
+        assert_eq!(
+            gate_message("check_access.level >= 50", &refs),
+            "check_access.level was %v, required >= 50"
+        );
+
+        let cond = "size(items) > 0 && role == 'admin' && total < 1.5e3";
+        let refs = condition_references(cond);
+        assert_eq!(refs, vec!["items", "role", "total"]);
+        assert_eq!(
+            gate_message(cond, &refs),
+            "Gate condition failed: size(items) > 0 && role == 'admin' && total < 1.5e3 (items=%v, role=%v, total=%v)"
+        );
+
+        assert_eq!(
+            gate_message("\"100%\" != \"\"", &[]),
+            "Gate condition failed: \\\"100%\\\" != \\\"\\\""
+        );
+    }
 }
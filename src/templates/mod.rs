@@ -21,6 +21,7 @@ mod embedded {
     pub const TYPESCRIPT_SPEC: &str = include_str!("../../templates/specs/typescript.jinja");
     pub const PYTHON_SPEC: &str = include_str!("../../templates/specs/python.jinja");
     pub const GO_SPEC: &str = include_str!("../../templates/specs/go.jinja");
+    pub const GO_PART_SPEC: &str = include_str!("../../templates/specs/go_part.jinja");
     pub const JAVA_SPEC: &str = include_str!("../../templates/specs/java.jinja");
     pub const CSHARP_SPEC: &str = include_str!("../../templates/specs/csharp.jinja");
 
@@ -30,6 +31,8 @@ mod embedded {
         include_str!("../../templates/orchestrators/typescript.jinja");
     pub const PYTHON_ORCH: &str = include_str!("../../templates/orchestrators/python.jinja");
     pub const GO_ORCH: &str = include_str!("../../templates/orchestrators/go.jinja");
+    pub const GO_TEMPORAL_ORCH: &str =
+        include_str!("../../templates/orchestrators/go_temporal.jinja");
     pub const JAVA_ORCH: &str = include_str!("../../templates/orchestrators/java.jinja");
     pub const CSHARP_ORCH: &str = include_str!("../../templates/orchestrators/csharp.jinja");
 }
@@ -53,6 +56,8 @@ fn init_engine() -> Environment<'static> {
         .expect("Failed to load python spec template");
     env.add_template("specs/go.jinja", embedded::GO_SPEC)
         .expect("Failed to load go spec template");
+    env.add_template("specs/go_part.jinja", embedded::GO_PART_SPEC)
+        .expect("Failed to load go part template");
     env.add_template("specs/java.jinja", embedded::JAVA_SPEC)
         .expect("Failed to load java spec template");
     env.add_template("specs/csharp.jinja", embedded::CSHARP_SPEC)
@@ -67,6 +72,11 @@ fn init_engine() -> Environment<'static> {
         .expect("Failed to load python orchestrator template");
     env.add_template("orchestrators/go.jinja", embedded::GO_ORCH)
         .expect("Failed to load go orchestrator template");
+    env.add_template(
+        "orchestrators/go_temporal.jinja",
+        embedded::GO_TEMPORAL_ORCH,
+    )
+    .expect("Failed to load go temporal template");
     env.add_template("orchestrators/java.jinja", embedded::JAVA_ORCH)
         .expect("Failed to load java orchestrator template");
     env.add_template("orchestrators/csharp.jinja", embedded::CSHARP_ORCH)
@@ -99,6 +109,8 @@ fn load_custom_templates(env: &mut Environment<'static>, dir: &Path) -> Result<(
         ("typescript", "typescript.jinja"),
         ("python", "python.jinja"),
         ("go", "go.jinja"),
+        ("go", "go_part.jinja"),
+        ("go", "go_temporal.jinja"),
         ("java", "java.jinja"),
         ("csharp", "csharp.jinja"),
     ] {
@@ -166,12 +178,50 @@ pub fn render_spec(
         .get_template(spec_template_name(target))
         .map_err(|e| TemplateError::TemplateNotFound(e.to_string()))?;
 
+    // Only the Go template applies input normalizations; other targets would
+    // silently compare the raw values
+    if target != Target::Go && !spec.normalize.is_empty() {
+        return Err(TemplateError::RenderError(format!(
+            "{}: only the Go target supports normalize",
+            spec.id
+        )));
+    }
     let ctx = context::SpecContext::from_spec(spec, target, provenance);
     template
         .render(&ctx)
         .map_err(|e| TemplateError::RenderError(e.to_string()))
 }
 
+/// Render the extra files of a spec whose rule chain is split with
+/// `codegen.split_rules`
+///
+/// Returns `(part number, code)` pairs, written next to the main file as
+/// `{id}_part{n}`. Only Go splits; other targets get no parts.
+pub fn render_spec_parts(
+    spec: &crate::spec::Spec,
+    target: Target,
+    provenance: bool,
+) -> Result<Vec<(usize, String)>, TemplateError> {
+    if target != Target::Go {
+        return Ok(Vec::new());
+    }
+    let env = engine();
+    let template = env
+        .get_template("specs/go_part.jinja")
+        .map_err(|e| TemplateError::TemplateNotFound(e.to_string()))?;
+
+    let ctx = context::SpecContext::from_spec(spec, target, provenance);
+    ctx.chunks
+        .iter()
+        .map(|chunk| {
+            template
+                .render(minijinja::context! { chunk, ..minijinja::Value::from_serialize(&ctx) })
+                .map(|code| (chunk.part, code))
+                .map_err(|e| TemplateError::RenderError(e.to_string()))
+        })
+        .collect()
+}
+
 /// Render an orchestrator using templates
 pub fn render_orchestrator(
     orch: &crate::orchestrate::Orchestrator,
@@ -184,12 +234,49 @@ pub fn render_orchestrator(
         .get_template(orchestrator_template_name(target))
         .map_err(|e| TemplateError::TemplateNotFound(e.to_string()))?;
 
+    if target != Target::Go {
+        let features = orch.go_only_features();
+        if !features.is_empty() {
+            return Err(TemplateError::RenderError(format!(
+                "{}: only the Go target supports {}",
+                orch.id,
+                features.join(", ")
+            )));
+        }
+    }
     let ctx = context::OrchestratorContext::from_orchestrator(orch, specs, target, provenance);
     template
         .render(&ctx)
         .map_err(|e| TemplateError::RenderError(e.to_string()))
 }
 
+/// Render a Temporal workflow and activities (Go) for an orchestrator
+///
+/// The output belongs in the same package as the orchestrator's regular Go
+/// code, whose input, context and error types it uses. Only call and gate
+/// steps can be exported; any other step kind is a render error.
+pub fn render_temporal(
+    orch: &crate::orchestrate::Orchestrator,
+    specs: &std::collections::HashMap<String, crate::spec::Spec>,
+    provenance: bool,
+) -> Result<String, TemplateError> {
+    let env = engine();
+    let template = env
+        .get_template("orchestrators/go_temporal.jinja")
+        .map_err(|e| TemplateError::TemplateNotFound(e.to_string()))?;
+
+    let ctx = context::OrchestratorContext::from_orchestrator(orch, specs, Target::Go, provenance);
+    if let Some(step) = ctx.steps.iter().find(|s| !s.is_call && !s.is_gate) {
+        return Err(TemplateError::RenderError(format!(
+            "{}: {} step '{}' is not supported in the Temporal export",
+            orch.id, step.step_type, step.id
+        )));
+    }
+    template
+        .render(&ctx)
+        .map_err(|e| TemplateError::RenderError(e.to_string()))
+}
+
 /// Template errors
 #[derive(Debug, Clone)]
 pub enum TemplateError {
@@ -318,100 +405,2223 @@ rules:
     }
 
     #[test]
-    fn test_render_go_spec() {
-        let spec = sample_spec();
-        let result = render_spec(&spec, Target::Go, true);
-        assert!(result.is_ok(), "Go render failed: {:?}", result.err());
+    fn test_render_go_optional_inputs() {
+        let mut spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: member_tier?
+    type: string
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "coalesce(member_tier, 'none') == 'gold'"
+    then: 5
+  - id: R2
+    when: "!is_present(member_tier)"
+    then: 10
+"#,
+        )
+        .unwrap();
 
-        let code = result.unwrap();
-        assert!(code.contains("func CheckStatus"), "Missing function name");
-        assert!(code.contains("CheckStatusInput"), "Missing input struct");
-        assert!(code.contains("429"), "Missing rule R1 output");
+        let code = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(code.contains("MemberTier *string"), "Missing pointer field");
+        assert!(code.contains("func shippingRateCoalesce[T any](v *T, fallback T) T"));
+        assert!(code.contains("shippingRateCoalesce(input.MemberTier, \"none\")"));
+        assert!(code.contains("shippingRateIsPresent(input.MemberTier)"));
+
+        spec.codegen.nullable = crate::spec::NullableStyle::SqlNull;
+        let code = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(code.contains("\"database/sql\""));
+        assert!(code.contains("\"encoding/json\""));
+        assert!(code.contains("MemberTier ShippingRateNull[string] `json:\"member_tier\"`"));
+        assert!(code.contains("type ShippingRateNull[T any] struct {\n\tsql.Null[T]\n}"));
+        assert!(code.contains("\treturn json.Marshal(v.V)"));
+        assert!(code.contains("return v.V"));
     }
 
     #[test]
-    fn test_render_java_spec() {
-        let spec = sample_spec();
-        let result = render_spec(&spec, Target::Java, true);
-        assert!(result.is_ok(), "Java render failed: {:?}", result.err());
+    fn test_render_input_defaults() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: priority
+    type: bool
+    default: false
+  - name: zone
+    type: string
+    default: domestic
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "priority && zone == 'domestic'"
+    then: 5
+"#,
+        )
+        .unwrap();
 
-        let code = result.unwrap();
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\"encoding/json\""));
+        assert!(go.contains("func (input *ShippingRateInput) UnmarshalJSON(data []byte) error"));
+        assert!(go.contains("Priority: false,"));
+        assert!(go.contains("Zone: \"domestic\","));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("priority?: boolean;"));
+        assert!(ts.contains("priority = false"));
+        assert!(ts.contains("zone = \"domestic\""));
+    }
+
+    #[test]
+    fn test_render_currency_conversion() {
+        let spec = Spec::from_yaml(
+            r#"
+id: intl_shipping
+inputs:
+  - name: base_price
+    type: money
+    currency: EUR
+outputs:
+  - name: price
+    type: money
+    currency: USD
+rules:
+  - id: R1
+    when: "base_price > 100.0"
+    then: "convert_currency(base_price, 'EUR', 'USD')"
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("type IntlShippingConversionRates interface"));
+        assert!(go.contains("rates IntlShippingConversionRates) float64"));
+        assert!(go.contains("rates.Convert(input.BasePrice, \"EUR\", \"USD\")"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("rates: IntlShippingConversionRates"));
+
+        let java = render_spec(&spec, Target::Java, false).unwrap();
+        assert!(java.contains("public interface ConversionRates {"));
+        assert!(java.contains("evaluate(Input input, ConversionRates rates)"));
+        assert!(java.contains("rates.convert(input.basePrice, \"EUR\", \"USD\")"));
+
+        let csharp = render_spec(&spec, Target::CSharp, false).unwrap();
+        assert!(csharp.contains("public interface IntlShippingConversionRates"));
         assert!(
-            code.contains("public class CheckStatus"),
-            "Missing class name"
+            csharp.contains("Evaluate(IntlShippingInput input, IntlShippingConversionRates rates)")
         );
-        assert!(code.contains("429"), "Missing rule R1 output");
+        assert!(csharp.contains("rates.Convert(basePrice, \"EUR\", \"USD\")"));
     }
 
     #[test]
-    fn test_render_csharp_spec() {
-        let spec = sample_spec();
-        let result = render_spec(&spec, Target::CSharp, true);
-        assert!(result.is_ok(), "C# render failed: {:?}", result.err());
+    fn test_render_pricing_functions() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: weight
+    type: float
+  - name: express
+    type: bool
+outputs:
+  - name: price
+    type: float
+rules:
+  - id: R1
+    when: "weight > 0.0"
+    then: "round(clamp(weight * 1.5, 5.0, 50.0), 2)"
+default: "express ? 10.0 : 5.0"
+"#,
+        )
+        .unwrap();
 
-        let code = result.unwrap();
-        assert!(code.contains("CheckStatusInput"), "Missing input class");
-        assert!(code.contains("Evaluate"), "Missing method name");
-        assert!(code.contains("429"), "Missing rule R1 output");
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"math\""));
+        assert!(go.contains("math.Round((min(max((input.Weight * 1.5), 5.0), 50.0) * 100.0))"));
+        assert!(go.contains("func shippingRateIfElse[T any](cond bool, a, b T) T"));
+        assert!(go.contains("shippingRateIfElse(input.Express, 10.0, 5.0)"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("import math"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains("if express { 10.0 } else { 5.0 }"));
     }
 
-    // Integration test: verify template output is properly formatted
     #[test]
-    fn test_template_output_formatting() {
-        let spec = sample_spec();
+    fn test_render_bucket() {
+        let spec = Spec::from_yaml(
+            r#"
+id: free_shipping
+inputs:
+  - name: member_id
+    type: string
+outputs:
+  - name: free
+    type: bool
+rules:
+  - id: R1
+    when: "bucket(member_id, 100) < 5"
+    then: true
+default: false
+"#,
+        )
+        .unwrap();
 
-        // Rust - check proper indentation
-        let rust_code = render_spec(&spec, Target::Rust, false).unwrap();
-        assert!(
-            rust_code.contains("    if"),
-            "Rust should have proper indentation"
-        );
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"hash/fnv\""));
+        assert!(go.contains("func freeShippingBucket(key any, n int64) int64 {\n\tif n <= 0 {\n"));
+        assert!(go.contains("\t\th.Write([]byte(strconv.FormatFloat(f, 'f', -1, 64)))\n"));
+        assert!(go.contains("\t\"strconv\""));
+        assert!(go.contains("freeShippingBucket(input.MemberId, 100)"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("import functools"));
+        assert!(py.contains("functools.reduce("));
+
+        let plain = render_spec(&sample_spec(), Target::Go, false).unwrap();
+        assert!(!plain.contains("hash/fnv"));
+    }
+
+    #[test]
+    fn test_render_lookup_tables() {
+        let mut spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+tables:
+  - name: zone_rates
+    file: zone_rates.csv
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'local'"
+    then: 0.0
+default: "lookup('zone_rates', zone, 40.0)"
+"#,
+        )
+        .unwrap();
+        spec.tables[0].rows = std::collections::BTreeMap::from([
+            (
+                "domestic".to_string(),
+                crate::spec::ConditionValue::Float(5.0),
+            ),
+            ("eu".to_string(), crate::spec::ConditionValue::Float(12.5)),
+        ]);
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("var shippingRateZoneRates = map[string]float64{"));
+        assert!(go.contains("\t\"eu\": float64(12.5),\n"));
         assert!(
-            rust_code.lines().count() > 5,
-            "Rust should have multiple lines"
+            go.contains("func shippingRateLookupZoneRates(key string, fallback float64) float64 {")
         );
+        assert!(go.contains("shippingRateLookupZoneRates(input.Zone, 40.0)"));
 
-        // Python - check proper indentation (4 spaces)
-        let py_code = render_spec(&spec, Target::Python, false).unwrap();
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains("fn lookup_zone_rates(key: &str) -> Option<f64> {"));
+        assert!(rust.contains("\"domestic\" => Some(5.0f64),"));
+        assert!(rust.contains("lookup_zone_rates(&zone).unwrap_or(40.0)"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("_LOOKUP_ZONE_RATES: dict[str, float] = {"));
+        assert!(py.contains("_LOOKUP_ZONE_RATES.get("));
+
+        let java = render_spec(&spec, Target::Java, false).unwrap();
+        assert!(java.contains("Map.entry(\"domestic\", 5.0),"));
+        assert!(java.contains("Map.entry(\"eu\", 12.5)\n"));
+    }
+
+    #[test]
+    fn test_render_rule_bindings() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+  - name: surcharge
+    type: float
+outputs:
+  - name: price
+    type: float
+rules:
+  - id: R1
+    when: "weight_kg > 10.0"
+    vars:
+      - name: base_cost
+        expr: "weight_kg * 8.0"
+    then: "base_cost + surcharge"
+  - id: R2
+    when: "weight_kg <= 10.0"
+    vars:
+      - name: flat
+        expr: "surcharge + 5.0"
+    then: flat
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("baseCost := (input.WeightKg * 8.0)"));
+        assert!(go.contains("return (baseCost + input.Surcharge)"));
+        assert!(go.contains("return flat\n"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains("let base_cost = (weight_kg * 8.0);"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("base_cost = (weight_kg * 8.0)"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("const baseCost = (weightKg * 8.0);"));
+        assert!(ts.contains("return (baseCost + surcharge);"));
+    }
+
+    #[test]
+    fn test_render_input_constraints() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: priority
+    type: bool
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+constraints:
+  - id: C1
+    when: "priority"
+    require: "zone != ''"
+    message: priority shipments need a "zone"
+rules:
+  - id: R1
+    when: "priority"
+    then: 1.0
+default: 2.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"errors\""));
+        assert!(go.contains("func (input ShippingRateInput) Validate() error"));
+        assert!(go.contains("return errors.New(\"C1: priority shipments need a \\\"zone\\\"\")"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains(
+            "export function validateShippingRateInput(input: ShippingRateInput): string | null"
+        ));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("    def validate(self) -> None:"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains(
+            "pub fn validate_shipping_rate(priority: bool, zone: String) -> Result<(), String>"
+        ));
+    }
+
+    #[test]
+    fn test_render_postconditions() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: shipping_cost
+    type: float
+postconditions:
+  - id: P1
+    require: "shipping_cost >= 0.0"
+rules:
+  - id: R1
+    when: "weight_kg > 0.0"
+    then: "weight_kg * 2.0"
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("var ShippingRateCheckPostconditions = false"));
+        assert!(go.contains("result := shippingRateEvaluate(input)"));
+        assert!(go.contains("if !((result >= 0.0)) {"));
+        assert!(go.contains("func shippingRateEvaluate(input ShippingRateInput) float64 {"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains("let result = shipping_rate_evaluate(weight_kg.clone());"));
+        assert!(rust.contains(
+            "debug_assert!((shipping_cost >= 0.0), \"P1: shipping_cost >= 0.0 must hold\");"
+        ));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
         assert!(
-            py_code.contains("    "),
-            "Python should have 4-space indentation"
+            py.contains("assert (shipping_cost >= 0.0), \"P1: shipping_cost >= 0.0 must hold\"")
         );
-        assert!(py_code.contains("if "), "Python should have if statements");
+        assert!(py.contains("def _shipping_rate_evaluate(input: ShippingRateInput) -> float:"));
 
-        // C# - check braces on new lines (proper formatting)
-        let cs_code = render_spec(&spec, Target::CSharp, false).unwrap();
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("if (shippingRateOptions.checkPostconditions) {"));
+        assert!(ts.contains("function shippingRateEvaluate(input: ShippingRateInput): number {"));
+    }
+
+    #[test]
+    fn test_render_orders_rules_by_priority() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone != ''"
+    then: 5.0
+  - id: R2
+    when: "zone == 'international'"
+    then: 20.0
+    priority: -1
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        let r1 = go.find("// R1").unwrap();
+        let r2 = go.find("// R2").unwrap();
         assert!(
-            cs_code.contains("{\n"),
-            "C# should have braces on proper lines"
+            r2 < r1,
+            "R2 has the higher priority and must be tried first"
         );
     }
 
-    // Orchestrator template tests
-    fn sample_orchestrator() -> crate::orchestrate::Orchestrator {
-        crate::orchestrate::Orchestrator::from_yaml(
-            r#"
-id: test_flow
+    #[test]
+    fn test_render_rule_registry() {
+        let spec = Spec::from_yaml(
+            r#"id: shipping_rate
+codegen:
+  registry: true
 inputs:
-  - name: user_id
+  - name: zone
     type: string
-  - name: amount
+outputs:
+  - name: rate
     type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    tags: [base]
+    then: 5.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("var ShippingRateRules = []ShippingRateRuleMeta{"));
+        assert!(go.contains(
+            "{ID: \"R1\", Condition: \"zone == 'domestic'\", Output: \"5\", Tags: []string{\"base\"}, Line: 11},"
+        ));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains("pub const SHIPPING_RATE_RULES: &[ShippingRateRuleMeta] = &["));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains(
+            "ShippingRateRuleMeta(\"R1\", \"zone == 'domestic'\", \"5\", (\"base\",), 11),"
+        ));
+
+        // Off by default
+        let mut spec = spec;
+        spec.codegen.registry = false;
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(!go.contains("ShippingRateRuleMeta"));
+    }
+
+    #[test]
+    fn test_render_rule_counters() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  counters: true
+inputs:
+  - name: zone
+    type: string
 outputs:
-  - name: approved
-    type: bool
-chain:
-  - step: gate
-    id: check_input
-    condition: "user_id != ''"
-  - step: call
-    id: validate
-    spec: validate_user
-    inputs:
-      id: "user_id"
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
 "#,
         )
-        .unwrap()
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"expvar\""));
+        assert!(
+            go.contains("var shippingRateRuleHits = expvar.NewMap(\"shipping_rate_rule_hits\")")
+        );
+        assert!(go.contains("shippingRateRuleHits.Add(\"R1\", 1)"));
+        assert!(go.contains("shippingRateRuleHits.Add(\"default\", 1)"));
+        assert!(go.contains("func ShippingRateStats() map[string]int64"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains("SHIPPING_RATE_HITS[0].fetch_add(1"));
+        assert!(rust.contains("SHIPPING_RATE_HITS[1].fetch_add(1"));
+        assert!(rust.contains("pub fn shipping_rate_stats() -> Vec<(&'static str, u64)>"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("_SHIPPING_RATE_HITS[\"R1\"] += 1"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("shippingRateHits[\"R1\"]++;"));
+    }
+
+    #[test]
+    fn test_render_go_interface() {
+        let mut spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  interface: true
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"context\""));
+        assert!(go.contains(
+            "type ShippingRateEvaluator interface {\n\tEvaluate(ctx context.Context, input ShippingRateInput) (float64, error)\n}"
+        ));
+        assert!(go.contains(
+            "type ShippingRateEvaluatorFunc func(ctx context.Context, input ShippingRateInput) (float64, error)"
+        ));
+        assert!(go.contains("\treturn ShippingRate(input), nil\n"));
+        assert!(go.contains("var _ ShippingRateEvaluator = ShippingRateRules{}"));
+        assert!(!go.contains("(*ShippingRateClient)(nil)"));
+
+        spec.codegen.safe = true;
+        spec.codegen.service = true;
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\treturn SafeShippingRate(input)\n"));
+        assert!(go.contains("var _ ShippingRateEvaluator = (*ShippingRateClient)(nil)"));
+    }
+
+    #[test]
+    fn test_render_go_build_variants() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  counters: true
+  lean_build: "tinygo || wasm"
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, true).unwrap();
+        assert!(go
+            .trim_start()
+            .starts_with("//go:build !(tinygo || wasm)\n\n"));
+        assert!(go.contains("\t\"expvar\""));
+
+        let lean = render_spec(&spec.lean_variant().unwrap(), Target::Go, true).unwrap();
+        assert!(lean
+            .trim_start()
+            .starts_with("//go:build (tinygo || wasm)\n\n"));
+        assert!(!lean.contains("expvar"));
+        assert!(lean.contains(&format!("// SPEC HASH: {}", spec.hash())));
+        assert!(lean.contains("func ShippingRate(input ShippingRateInput) float64 {"));
+    }
+
+    #[test]
+    fn test_render_explain() {
+        let yaml = r#"
+id: member_discount
+codegen:
+  explain: true
+inputs:
+  - name: member_tier
+    type: string
+  - name: total
+    type: float
+outputs:
+  - name: discount
+    type: float
+rules:
+  - id: R5
+    when: "member_tier == 'silver' && total > 100.0"
+    then: 0.1
+default: 0.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"fmt\""));
+        assert!(go.contains(
+            "func MemberDiscountExplain(input MemberDiscountInput, ruleID string) ([]string, error)"
+        ));
+        assert!(go.contains("case \"R5\":"));
+        assert!(go.contains(
+            "failed = append(failed, fmt.Sprintf(\"%s (member_tier was %v)\", \"R5 did not match: member_tier == 'silver'\", input.MemberTier))"
+        ));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains(
+            "def member_discount_explain(input: MemberDiscountInput, rule_id: str) -> list[str]:"
+        ));
+        assert!(py.contains("(total was \" + repr(total) + \")\""));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("export function memberDiscountExplain("));
+        assert!(ts.contains("JSON.stringify(memberTier)"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(rust.contains(
+            "pub fn member_discount_explain(rule_id: &str, member_tier: String, total: f64)"
+        ));
+        assert!(rust.contains("\"R5\" => {"));
+
+        let redacted = Spec::from_yaml(
+            &yaml.replace("    type: string\n", "    type: string\n    pii: true\n"),
+        )
+        .unwrap();
+        let go = render_spec(&redacted, Target::Go, false).unwrap();
+        assert!(go.contains(
+            "failed = append(failed, fmt.Sprintf(\"%s (member_tier was ***)\", \"R5 did not match: member_tier == 'silver'\"))"
+        ));
+        assert!(!go.contains("input.MemberTier)"));
+        let py = render_spec(&redacted, Target::Python, false).unwrap();
+        assert!(py.contains("(member_tier was ***)\""));
+        assert!(py.contains("(total was \" + repr(total) + \")\""));
+    }
+
+    #[test]
+    fn test_render_shadow_wrapper() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  shadow: true
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("func ShippingRateShadow(candidate func(ShippingRateInput) float64, onDivergence func(input ShippingRateInput, primary, candidate float64)) func(ShippingRateInput) float64 {"));
+        assert!(go.contains("if shadow := candidate(input); shadow != primary {"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains("export function shippingRateShadow("));
+        assert!(ts.contains("if (shadow !== primary) {"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("from typing import Any, Callable"));
+        assert!(py.contains("def shipping_rate_shadow("));
+        assert!(py.contains("on_divergence(input, primary, shadow)"));
+    }
+
+    #[test]
+    fn test_render_eval_batch() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  batch: true
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"runtime\""));
+        assert!(go.contains("\t\"sync\""));
+        assert!(go.contains("func ShippingRateEvalBatch(inputs []ShippingRateInput) []float64 {"));
+        assert!(go.contains("results[i] = ShippingRate(inputs[i])"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(ts.contains(
+            "export function shippingRateEvalBatch(inputs: ShippingRateInput[]): number[] {"
+        ));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("from concurrent.futures import ProcessPoolExecutor"));
+        assert!(py.contains("return list(pool.map(shipping_rate, inputs, chunksize=1024))"));
+
+        let rust = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(
+            rust.contains("pub fn shipping_rate_eval_batch(inputs: &[(String,)]) -> Vec<f64> {")
+        );
+        assert!(rust.contains(".map(|(zone,)| shipping_rate(zone.clone()))"));
+    }
+
+    #[test]
+    fn test_render_eval_stream() {
+        let yaml = r#"
+id: shipping_rate
+codegen:
+  stream: {}
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#;
+        let go = render_spec(&Spec::from_yaml(yaml).unwrap(), Target::Go, false).unwrap();
+        assert!(go.contains("\t\"context\""));
+        assert!(go.contains("\t\"runtime\""));
+        assert!(go.contains("type ShippingRateStreamResult struct {\n\tInput  ShippingRateInput\n\tOutput float64\n}"));
+        assert!(go.contains("func ShippingRateEvalStream(ctx context.Context, inputs <-chan ShippingRateInput) <-chan ShippingRateStreamResult {"));
+        assert!(go.contains("\tworkers := runtime.GOMAXPROCS(0)\n\tresults := make(chan ShippingRateStreamResult, workers)\n"));
+        assert!(go.contains(
+            "result := ShippingRateStreamResult{Input: input, Output: ShippingRate(input)}"
+        ));
+
+        let sized = yaml.replace("stream: {}", "stream:\n    workers: 4\n    buffer: 64");
+        let go = render_spec(&Spec::from_yaml(&sized).unwrap(), Target::Go, false).unwrap();
+        assert!(!go.contains("\t\"runtime\""));
+        assert!(
+            go.contains("\tworkers := 4\n\tresults := make(chan ShippingRateStreamResult, 64)\n")
+        );
+        assert!(go.contains("decides the inputs received on a channel with 4\n// workers."));
+
+        let invalid = yaml.replace("stream: {}", "stream:\n    workers: 0");
+        assert!(Spec::from_yaml(&invalid)
+            .unwrap()
+            .validate()
+            .iter()
+            .any(|e| e.contains("stream workers must be at least 1")));
+    }
+
+    #[test]
+    fn test_render_zero_alloc() {
+        let yaml = r#"
+id: shipping_rate
+codegen:
+  zero_alloc: true
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        assert!(spec.validate().is_empty());
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("func ShippingRate(input ShippingRateInput) float64 {"));
+        assert!(go.contains("\treturn ShippingRatePtr(&input)"));
+        assert!(go.contains("func ShippingRatePtr(input *ShippingRateInput) float64 {"));
+
+        let spec = Spec::from_yaml(&yaml.replace("type: string", "type: object")).unwrap();
+        assert!(spec.validate().iter().any(|e| e.contains("zero_alloc")));
+    }
+
+    #[test]
+    fn test_render_split_rules() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  split_rules: 2
+inputs:
+  - name: zone
+    type: string
+  - name: weight
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+  - id: R2
+    when: "zone == 'eu'"
+    then: 8.0
+  - id: R3
+    when: "zone == 'world'"
+    then: "ceil(weight) * 3.0"
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\tif result, ok := shippingRateRules1(&input); ok {"));
+        assert!(go.contains("\tif result, ok := shippingRateRules2(&input); ok {"));
+        assert!(!go.contains("// R1"));
+        assert!(!go.contains("\"math\""));
+
+        let parts = render_spec_parts(&spec, Target::Go, false).unwrap();
+        assert_eq!(parts.len(), 2);
+        assert_eq!(parts[0].0, 1);
+        assert!(parts[0]
+            .1
+            .contains("// shippingRateRules1 tries rules R1 to R2 in evaluation order"));
+        assert!(parts[0]
+            .1
+            .contains("func shippingRateRules1(input *ShippingRateInput) (float64, bool) {"));
+        assert!(!parts[0].1.contains("import"));
+        assert!(parts[1].1.contains("import \"math\""));
+        assert!(parts[1].1.contains("// R3"));
+
+        assert!(render_spec_parts(&spec, Target::Python, false)
+            .unwrap()
+            .is_empty());
+    }
+
+    #[test]
+    fn test_render_go_marshalers() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  marshalers: true
+inputs:
+  - name: zone
+    type: string
+  - name: weight
+    type: float
+constraints:
+  - id: positive_weight
+    require: "weight > 0.0"
+    message: "weight must be positive"
+outputs:
+  - name: rate
+    type: float
+  - name: carrier
+    type: string
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then:
+      rate: 5.0
+      carrier: "'ups'"
+default:
+  rate: 0.0
+  carrier: "'none'"
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(!go.contains("\t\"errors\""));
+        assert!(go.contains("return fmt.Sprintf(\"{rate: %v, carrier: %q}\", o.Rate, o.Carrier)"));
+        assert!(go.contains(
+            "return []byte(`{\"rate\":` + string(rateJSON) + `,\"carrier\":` + string(carrierJSON) + `}`), nil"
+        ));
+        assert!(go.contains("const ShippingRateRuleR1 ShippingRateRule = \"R1\""));
+        assert!(go.contains("\tcase ShippingRateRuleR1, ShippingRateRuleDefault:"));
+        assert!(go.contains(
+            "return &ShippingRateConstraintError{Constraint: \"positive_weight\", Message: \"weight must be positive\"}"
+        ));
+    }
+
+    #[test]
+    fn test_render_go_reasons() {
+        let spec = Spec::from_yaml(
+            r#"
+id: loan_decision
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score < 500"
+    then: false
+    reason_code: LOW_SCORE
+    message_key: loan.low_score
+    description: Credit score below 500
+  - id: R2
+    when: "score < 600"
+    then: false
+    reason_code: REVIEW
+  - id: R3
+    when: "score >= 600"
+    then: true
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains(
+            "\t\"R1\": {RuleID: \"R1\", Code: \"LOW_SCORE\", MessageKey: \"loan.low_score\"},\n"
+        ));
+        assert!(go.contains("\t\"R2\": {RuleID: \"R2\", Code: \"REVIEW\"},\n"));
+        assert!(!go.contains("{RuleID: \"R3\""));
+        assert!(go.contains("\t\"loan.low_score\": \"Credit score below 500\",\n"));
+        assert!(go.contains(
+            "func LoanDecisionWithReason(input LoanDecisionInput) (bool, LoanDecisionReason) {"
+        ));
+        assert!(go.contains(
+            "\tcase (input.Score >= 600):\n\t\treturn result, LoanDecisionReasons[\"R3\"]\n"
+        ));
+
+        let mut plain = spec.clone();
+        for rule in &mut plain.rules {
+            rule.reason_code = None;
+            rule.message_key = None;
+        }
+        let go = render_spec(&plain, Target::Go, false).unwrap();
+        assert!(!go.contains("LoanDecisionReason"));
+    }
+
+    #[test]
+    fn test_render_go_versioned() {
+        let spec = Spec::from_yaml(
+            r#"
+id: loan_decision
+meta:
+  version: "2.1"
+codegen:
+  versioned: true
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score >= 600"
+    then: true
+default: false
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\tOutput bool `json:\"output\"`\n"));
+        assert!(
+            go.contains("func LoanDecisionDecide(input LoanDecisionInput) LoanDecisionDecision {")
+        );
+        assert!(go.contains("\t\tVersion: \"2.1\",\n"));
+        assert!(go.contains(&format!("\t\tHash:    \"{}\",\n", spec.hash())));
+        assert!(go.contains("\t\tOutput:  LoanDecision(input),\n"));
+    }
+
+    #[test]
+    fn test_render_go_versioned_redacts_pii_outputs() {
+        let yaml = r#"
+id: lead_owner
+codegen:
+  versioned: true
+inputs:
+  - name: region
+    type: string
+outputs:
+  - name: agent
+    type: string
+    pii: true
+  - name: priority
+    type: int
+rules:
+  - id: R1
+    when: "region == 'eu'"
+    then: {agent: anna, priority: 1}
+default: {agent: desk, priority: 2}
+"#;
+        let spec = Spec::from_yaml(yaml).unwrap();
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\tRedacted map[string]string `json:\"redacted\"`\n"));
+        assert!(go.contains("\t\t\"agent\": \"***\",\n"));
+        assert!(go.contains("\toutput.Agent = *new(string)\n"));
+        assert!(go.contains("\tdecision.Output = output\n"));
+        assert!(!go.contains("LeadOwnerRedactKey"));
+        assert!(!go.contains("Output:  LeadOwner(input)"));
+
+        let hashed =
+            Spec::from_yaml(&yaml.replace("    pii: true\n", "    redact: hash\n")).unwrap();
+        let go = render_spec(&hashed, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"crypto/hmac\"\n"));
+        assert!(go.contains("var LeadOwnerRedactKey []byte"));
+        assert!(go.contains("\tmac := hmac.New(sha256.New, LeadOwnerRedactKey)\n"));
+        assert!(go.contains("\t\t\"agent\": leadOwnerHash(output.Agent),\n"));
+    }
+
+    #[test]
+    fn test_render_go_embed_spec() {
+        let spec = Spec::from_yaml(
+            r#"
+id: loan_decision
+codegen:
+  embed_spec: true
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score >= 600"
+    then: true
+default: false
+"#,
+        )
+        .unwrap();
+        assert_eq!(spec.embed_file_name(), "loan_decision.spec.yaml");
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        for pkg in ["crypto/sha256", "embed", "fmt"] {
+            assert!(go.contains(&format!("\t\"{}\"\n", pkg)));
+        }
+        assert!(
+            go.contains("//go:embed loan_decision.spec.yaml\nvar loanDecisionSpecFiles embed.FS\n")
+        );
+        assert!(go.contains("func LoanDecisionSpecSource() []byte {"));
+        assert!(go.contains(&format!("\treturn \"{}\"\n", spec.hash())));
+        assert!(go.contains("func LoanDecisionVerifySpec() error {"));
+
+        let mut plain = spec.clone();
+        plain.codegen.embed_spec = false;
+        let go = render_spec(&plain, Target::Go, false).unwrap();
+        assert!(!go.contains("go:embed"));
+        assert!(!go.contains("\t\"embed\"\n"));
+    }
+
+    #[test]
+    fn test_render_skips_inactive_rules() {
+        let spec = Spec::from_yaml(
+            r#"
+id: loan_decision
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score < 500"
+    then: false
+    state: retired
+  - id: R2
+    when: "score >= 600"
+    then: true
+  - id: R3
+    when: "score >= 550"
+    then: true
+    state: draft
+default: false
+"#,
+        )
+        .unwrap();
+
+        for target in [Target::Rust, Target::Go] {
+            let code = render_spec(&spec, target, true).unwrap();
+            assert!(code.contains(">= 600"));
+            assert!(!code.contains("< 500") && !code.contains(">= 550"));
+            assert!(code.contains(&format!("SPEC HASH: {}", spec.hash())));
+        }
+    }
+
+    #[test]
+    fn test_render_rule_owners() {
+        let spec = Spec::from_yaml(
+            r#"
+id: loan_decision
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score >= 600"
+    then: true
+    owner: risk-team
+    approved_by: j.doe
+  - id: R2
+    when: "score < 400"
+    then: false
+default: false
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, true).unwrap();
+        assert!(go.contains(
+            "// DO NOT EDIT - regenerate from spec\n// RULE R1: owner risk-team, approved by j.doe\n\n"
+        ));
+        assert!(!go.contains("RULE R2"));
+        let py = render_spec(&spec, Target::Python, true).unwrap();
+        assert!(py.contains("# RULE R1: owner risk-team, approved by j.doe\n"));
+    }
+
+    #[test]
+    fn test_render_go_logging() {
+        let spec = Spec::from_yaml(
+            r#"
+id: loan_decision
+codegen:
+  logging: true
+inputs:
+  - name: score
+    type: int
+outputs:
+  - name: approved
+    type: bool
+rules:
+  - id: R1
+    when: "score >= 600"
+    then: true
+default: false
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"log/slog\""));
+        assert!(go.contains("var LoanDecisionLogger *slog.Logger"));
+        assert!(go.contains("\t\tloanDecisionLogRule(\"R1\")\n"));
+        assert!(go.contains("\t\tloanDecisionLogRule(\"default\")\n"));
+
+        let mut plain = spec.clone();
+        plain.codegen.logging = false;
+        assert!(!render_spec(&plain, Target::Go, false)
+            .unwrap()
+            .contains("slog"));
+    }
+
+    #[test]
+    fn test_render_go_format() {
+        let spec = Spec::from_yaml(
+            r#"
+id: parcel_label
+inputs:
+  - name: carrier
+    type: string
+  - name: zone
+    type: string
+  - name: tier
+    type: int
+outputs:
+  - name: label
+    type: string
+rules:
+  - id: R1
+    when: "tier > 1"
+    then: "format('{carrier}-{zone}-{tier}')"
+default: "format('{carrier}-STD')"
+"#,
+        )
+        .unwrap();
+        assert!(spec.validate().is_empty());
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"fmt\""));
+        assert!(go.contains("fmt.Sprintf(\"%v-%v-%v\", input.Carrier, input.Zone, input.Tier)"));
+        assert!(go.contains("fmt.Sprintf(\"%v-STD\", input.Carrier)"));
+    }
+
+    #[test]
+    fn test_render_go_custom_functions() {
+        let mut spec = Spec::from_yaml(
+            r#"
+id: parcel_rate
+inputs:
+  - name: length_cm
+    type: float
+  - name: width_cm
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "dimWeight(length_cm, width_cm) > 20.0"
+    then: "dimWeight(length_cm, width_cm) * 1.5"
+default: 9.0
+"#,
+        )
+        .unwrap();
+        spec.functions = vec![crate::spec::CustomFunction {
+            name: "dimWeight".into(),
+            params: vec![VarType::Float, VarType::Float],
+            returns: VarType::Float,
+            go: "example.com/shipping/parcel.DimWeight".into(),
+        }];
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"example.com/shipping/parcel\""));
+        assert!(go.contains("parcel.DimWeight(input.LengthCm, input.WidthCm) > 20.0"));
+        assert!(!go.contains("dimWeight("));
+    }
+
+    #[test]
+    fn test_render_go_geo_helpers() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_zone
+inputs:
+  - name: country
+    type: string
+outputs:
+  - name: rate
+    type: float
+zones:
+  - name: nordics
+    countries: [DK, FI, NO, SE]
+rules:
+  - id: R1
+    when: "zone(country) == 'nordics'"
+    then: 12.0
+  - id: R2
+    when: "region(country) == 'europe'"
+    then: 15.0
+default: 30.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("shippingZoneLookupZone(input.Country, \"\") == \"nordics\""));
+        assert!(go.contains("shippingZoneLookupRegion(input.Country, \"\") == \"europe\""));
+        assert!(go.contains("\t\"SE\": \"nordics\",\n"));
+        assert!(go.contains("\t\"DE\": \"europe\",\n"));
+    }
+
+    #[test]
+    fn test_render_go_safe() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  safe: true
+inputs:
+  - name: weight
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "weight < 10.0"
+    then: 5.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"fmt\""));
+        assert!(go.contains("type ShippingRateEvaluationError struct {"));
+        assert!(go.contains(
+            "func SafeShippingRate(input ShippingRateInput) (result float64, err error) {"
+        ));
+        assert!(go.contains("\t\t\terr = &ShippingRateEvaluationError{Panic: r, Input: input}\n"));
+        assert!(go.contains("\treturn ShippingRate(input), nil\n"));
+    }
+
+    #[test]
+    fn test_render_go_normalize() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: zone
+    type: string
+  - name: tier
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic' && tier == 'platinum'"
+    then: 5.0
+default: 10.0
+codegen:
+  explain: true
+normalize:
+  - input: zone
+    trim: true
+    lowercase: true
+  - input: tier
+    map:
+      plat: platinum
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"strings\""));
+        assert!(go.contains("func (input *ShippingRateInput) Normalize() {"));
+        assert!(go.contains("\tinput.Zone = strings.ToLower(strings.TrimSpace(input.Zone))\n"));
+        assert!(go.contains("\tcase \"plat\":\n\t\tinput.Tier = \"platinum\"\n"));
+        assert!(go.contains("\tinput.Normalize()\n"));
+        assert!(go.contains(
+            "ruleID string) ([]string, error) {\n\n\tinput.Normalize()\n\n\tvar failed []string\n"
+        ));
+
+        for target in [Target::Rust, Target::TypeScript, Target::Python] {
+            assert!(matches!(render_spec(&spec, target, false),
+                Err(TemplateError::RenderError(m)) if m == "shipping_rate: only the Go target supports normalize"));
+        }
+    }
+
+    #[test]
+    fn test_render_go_aliases() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+    aliases: [weightKg, weight]
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "weight_kg < 10.0"
+    then: 5.0
+default: 10.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"encoding/json\""));
+        assert!(go.contains("func (input *ShippingRateInput) UnmarshalJSON(data []byte) error {"));
+        assert!(go.contains("\tif _, ok := fields[\"weight_kg\"]; !ok {\n"));
+        assert!(go.contains("\t\tfor _, alias := range []string{\"weightKg\", \"weight\"} {\n"));
+        assert!(go.contains("json.Unmarshal(value, &decoded.WeightKg)"));
+        assert!(go.contains("`json:\"weight_kg\"`"));
+    }
+
+    #[test]
+    fn test_render_go_form_decoding() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  form_decoding: true
+inputs:
+  - name: zone
+    type: string
+    values: [domestic, intl]
+  - name: weight_kg
+    type: float
+    aliases: [weight]
+  - name: express
+    type: bool
+    default: false
+  - name: coupon?
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 10.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"net/url\""));
+        assert!(go.contains("\t\"strconv\""));
+        assert!(go.contains(
+            "func ShippingRateInputFromValues(values url.Values) (ShippingRateInput, error) {"
+        ));
+        assert!(go.contains("shippingRateParam(values, \"weight_kg\", \"weight\"); ok {"));
+        assert!(go.contains("\t\tparsed, err := strconv.ParseFloat(raw, 64)\n"));
+        assert!(go.contains("Reason: \"must be one of domestic, intl\"}"));
+        assert!(go.contains("\t\tinput.Express = false\n"));
+        assert!(go.contains("\t\tinput.Coupon = &parsed\n"));
+        assert!(go.contains(
+            "return input, &ShippingRateDecodeError{Field: \"zone\", Reason: \"is required\"}"
+        ));
+    }
+
+    #[test]
+    fn test_render_go_sql_functions() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  sql_functions: true
+inputs:
+  - name: weight_kg
+    type: float
+  - name: zone
+    type: string
+    values: [domestic, intl]
+    default: domestic
+outputs:
+  - name: rate
+    type: float
+  - name: carrier
+    type: string
+rules:
+  - id: R1
+    when: "weight_kg > 20.0"
+    then: {rate: 25.0, carrier: freight}
+default: {rate: 5.0, carrier: post}
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"database/sql/driver\""));
+        assert!(go.contains(
+            "func shippingRateSQLFloat(name string, arg driver.Value) (float64, error) {"
+        ));
+        assert!(go.contains(
+            "func shippingRateSQLString(name string, arg driver.Value) (string, error) {"
+        ));
+        assert!(!go.contains("shippingRateSQLBool"));
+        assert!(go.contains("\t\tparsed, err := shippingRateSQLFloat(\"weight_kg\", args[0])\n"));
+        assert!(go.contains("\t\tinput.Zone = \"domestic\"\n"));
+        assert!(go.contains("zone must be one of domestic, intl, got %q"));
+        // A NULL weight gives a NULL result
+        assert!(go.contains("\t} else {\n\t\treturn input, false, nil\n"));
+        assert!(go.contains("\t\t\"shipping_rate_rate\": func(args []driver.Value)"));
+        assert!(go.contains("return ShippingRate(input).Carrier, nil"));
+        assert!(go.contains("if err := register(name, 2, fn); err != nil {"));
+
+        let single = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  sql_functions: true
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "weight_kg > 20.0"
+    then: 25.0
+default: 5.0
+"#,
+        )
+        .unwrap();
+        let go = render_spec(&single, Target::Go, false).unwrap();
+        assert!(go.contains("taking the inputs in declaration order: shipping_rate(weight_kg)"));
+        assert!(go.contains("\t\t\"shipping_rate\": func(args []driver.Value)"));
+        assert!(go.contains("return ShippingRate(input), nil"));
+    }
+
+    #[test]
+    fn test_render_go_service() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+codegen:
+  service: true
+inputs:
+  - name: weight
+    type: float
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "weight < 10.0"
+    then: 5.0
+default: 10.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(go.contains("\t\"net/http\""));
+        assert!(go.contains("func ShippingRateHandler() http.Handler {"));
+        assert!(go.contains("\t\tresult := ShippingRate(input)\n"));
+        assert!(go.contains("type ShippingRateClient struct {"));
+        assert!(go.contains(
+            "func (c *ShippingRateClient) Evaluate(ctx context.Context, input ShippingRateInput) (float64, error) {"
+        ));
+        assert!(!go.contains("case http.MethodGet:"));
+    }
+
+    #[test]
+    fn test_render_cached_wrapper() {
+        let spec = Spec::from_yaml(
+            r#"
+id: shipping_rate
+cache:
+  size: 500
+  ttl_secs: 60
+inputs:
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 5.0
+default: 0.0
+"#,
+        )
+        .unwrap();
+
+        let go = render_spec(&spec, Target::Go, false).unwrap();
+        for pkg in ["container/list", "encoding/json", "sync", "time"] {
+            assert!(
+                go.contains(&format!("\t\"{}\"", pkg)),
+                "missing import {}",
+                pkg
+            );
+        }
+        assert!(go.contains("func ShippingRateCached(input ShippingRateInput) float64 {"));
+        assert!(go.contains("if cache.order.Len() > 500 {"));
+        assert!(go.contains("var ShippingRateClock = time.Now"));
+        assert!(go.contains("expires: ShippingRateClock().Add(60 * time.Second)"));
+
+        let ts = render_spec(&spec, Target::TypeScript, false).unwrap();
+        assert!(
+            ts.contains("export function shippingRateCached(input: ShippingRateInput): number {")
+        );
+        assert!(ts.contains("expires: now + 60000"));
+        assert!(ts.contains("export const shippingRateClock = { now: Date.now };"));
+        assert!(ts.contains("    const now = shippingRateClock.now();\n"));
+
+        let py = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(py.contains("from collections import OrderedDict"));
+        assert!(py.contains("def shipping_rate_cached(input: ShippingRateInput) -> float:"));
+        assert!(py.contains("if len(_SHIPPING_RATE_CACHE) > 500:"));
+        assert!(py.contains("SHIPPING_RATE_CLOCK = time.monotonic"));
+        assert!(py.contains("    now = SHIPPING_RATE_CLOCK()\n"));
+    }
+
+    #[test]
+    fn test_render_go_spec() {
+        let spec = sample_spec();
+        let result = render_spec(&spec, Target::Go, true);
+        assert!(result.is_ok(), "Go render failed: {:?}", result.err());
+
+        let code = result.unwrap();
+        assert!(code.contains("func CheckStatus"), "Missing function name");
+        assert!(code.contains("CheckStatusInput"), "Missing input struct");
+        assert!(code.contains("429"), "Missing rule R1 output");
+    }
+
+    #[test]
+    fn test_render_java_spec() {
+        let spec = sample_spec();
+        let result = render_spec(&spec, Target::Java, true);
+        assert!(result.is_ok(), "Java render failed: {:?}", result.err());
+
+        let code = result.unwrap();
+        assert!(
+            code.contains("public class CheckStatus"),
+            "Missing class name"
+        );
+        assert!(code.contains("429"), "Missing rule R1 output");
+    }
+
+    #[test]
+    fn test_render_csharp_spec() {
+        let spec = sample_spec();
+        let result = render_spec(&spec, Target::CSharp, true);
+        assert!(result.is_ok(), "C# render failed: {:?}", result.err());
+
+        let code = result.unwrap();
+        assert!(code.contains("CheckStatusInput"), "Missing input class");
+        assert!(code.contains("Evaluate"), "Missing method name");
+        assert!(code.contains("429"), "Missing rule R1 output");
+    }
+
+    // Integration test: verify template output is properly formatted
+    #[test]
+    fn test_template_output_formatting() {
+        let spec = sample_spec();
+
+        // Rust - check proper indentation
+        let rust_code = render_spec(&spec, Target::Rust, false).unwrap();
+        assert!(
+            rust_code.contains("    if"),
+            "Rust should have proper indentation"
+        );
+        assert!(
+            rust_code.lines().count() > 5,
+            "Rust should have multiple lines"
+        );
+
+        // Python - check proper indentation (4 spaces)
+        let py_code = render_spec(&spec, Target::Python, false).unwrap();
+        assert!(
+            py_code.contains("    "),
+            "Python should have 4-space indentation"
+        );
+        assert!(py_code.contains("if "), "Python should have if statements");
+
+        // C# - check braces on new lines (proper formatting)
+        let cs_code = render_spec(&spec, Target::CSharp, false).unwrap();
+        assert!(
+            cs_code.contains("{\n"),
+            "C# should have braces on proper lines"
+        );
+    }
+
+    // Orchestrator template tests
+    fn sample_orchestrator() -> crate::orchestrate::Orchestrator {
+        crate::orchestrate::Orchestrator::from_yaml(
+            r#"
+id: test_flow
+inputs:
+  - name: user_id
+    type: string
+  - name: amount
+    type: float
+outputs:
+  - name: approved
+    type: bool
+chain:
+  - step: gate
+    id: check_input
+    condition: "user_id != ''"
+  - step: call
+    id: validate
+    spec: validate_user
+    inputs:
+      id: "user_id"
+"#,
+        )
+        .unwrap()
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_trigger() {
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!code.contains("\"context\""));
+        assert!(!code.contains("TestFlowConsume"));
+
+        let mut orch = sample_orchestrator();
+        orch.trigger = Some(crate::orchestrate::Trigger {
+            topic: "payments".into(),
+            result_topic: "payment-decisions".into(),
+            error_topic: None,
+        });
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        assert!(code.contains("\t\"context\""));
+        assert!(code.contains("const TestFlowTopic = \"payments\""));
+        assert!(code.contains("const TestFlowErrorTopic = \"payment-decisions\""));
+        assert!(code.contains("func TestFlowConsume(ctx context.Context, consumer TestFlowConsumer, publisher TestFlowPublisher, codec TestFlowCodec) error {"));
+        assert!(code.contains("output, err := TestFlow(input)"));
+    }
+
+    #[test]
+    fn test_render_temporal() {
+        let specs = std::collections::HashMap::new();
+        let code = render_temporal(&sample_orchestrator(), &specs, false).unwrap();
+        assert!(code.contains("\t\"go.temporal.io/sdk/workflow\""));
+        assert!(code.contains(
+            "func (TestFlowActivities) Validate(_ context.Context, input ValidateUserInput) (interface{}, error) {"
+        ));
+        assert!(code.contains(
+            "func TestFlowWorkflow(wctx workflow.Context, input TestFlowInput) (TestFlowContext, error) {"
+        ));
+        assert!(code.contains("\tctx := TestFlowContext{}\n"));
+        assert!(code.contains("workflow.ExecuteActivity(wctx, activities.Validate, validateInput).Get(wctx, &ctx.Validate)"));
+        assert!(code.contains("\t// Gate: check_input\n\tif !("));
+        assert!(code.contains("w.RegisterActivity(TestFlowActivities{})"));
+
+        let mut orch = sample_orchestrator();
+        orch.chain.push(crate::orchestrate::ChainStep::Set(
+            crate::orchestrate::SetStep {
+                name: "note".into(),
+                value: "'done'".into(),
+            },
+        ));
+        let err = render_temporal(&orch, &specs, false).unwrap_err();
+        assert!(matches!(err, TemplateError::RenderError(ref m) if m.contains("Set step")));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_approval() {
+        let orch = crate::orchestrate::Orchestrator::from_yaml(
+            r#"
+id: order_flow
+inputs:
+  - name: order_id
+    type: string
+outputs:
+  - name: approved
+    type: bool
+chain:
+  - step: call
+    id: risk
+    spec: risk_score
+    inputs:
+      order: "order_id"
+  - step: await_approval
+    id: review
+    prompt: "Large order: approve?"
+  - step: call
+    id: ship
+    spec: ship_order
+    inputs:
+      order: "order_id"
+"#,
+        )
+        .unwrap();
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\tReview OrderFlowApproval\n"));
+        assert!(code.contains("\treturn orderFlowStage0(input, OrderFlowContext{})"));
+        assert!(code.contains("\t\tPrompt:  \"Large order: approve?\",\n"));
+        let stage1 = code
+            .find(
+                "func orderFlowStage1(input OrderFlowInput, ctx OrderFlowContext) (OrderFlowOutput, error) {",
+            )
+            .unwrap();
+        assert!(code.find("// Step: risk").unwrap() < stage1);
+        assert!(code.find("// Step: ship").unwrap() > stage1);
+        assert!(code.contains(
+            "\t\tctx.Review = approval\n\t\treturn orderFlowStage1(checkpoint.Input, ctx)"
+        ));
+
+        let err = render_orchestrator(&orch, &specs, Target::Python, false).unwrap_err();
+        assert!(matches!(err, TemplateError::RenderError(ref m)
+            if m == "order_flow: only the Go target supports await_approval (step 'review')"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_durable() {
+        let mut orch = sample_orchestrator();
+        orch.durable = true;
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\t\"context\""));
+        assert!(code.contains("func TestFlowRun(runCtx context.Context, store TestFlowStore, runID string, input TestFlowInput) (TestFlowContext, error) {"));
+        assert!(code.contains("\t\t{\"check_input\", testFlowStepCheckInput},\n"));
+        assert!(code.contains("\t\t{\"validate\", testFlowStepValidate},\n"));
+        assert!(code.contains(
+            "func testFlowStepValidate(input TestFlowInput, ctx *TestFlowContext) error {"
+        ));
+        assert!(code.contains("\tctx.Validate = ValidateUser(validateInput)"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_hooks() {
+        let mut orch = sample_orchestrator();
+        orch.hooks = true;
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\t\"time\""));
+        assert!(code.contains("type TestFlowHooks struct {"));
+        assert!(code.contains("var TestFlowEvents TestFlowHooks"));
+        assert!(code.contains(
+            "\tTestFlowEvents.step(TestFlowEvents.StepStarted, \"validate\", \"validate_user\", time.Time{})"
+        ));
+        assert!(code.contains(
+            "\tTestFlowEvents.step(TestFlowEvents.StepCompleted, \"validate\", \"validate_user\", validateStarted)"
+        ));
+        assert!(
+            code.contains("\t\tTestFlowEvents.gate(TestFlowEvents.GateFailed, \"check_input\",")
+        );
+        assert!(code.contains("\tTestFlowEvents.gate(TestFlowEvents.GatePassed, \"check_input\","));
+        assert!(code.contains("\tTestFlowEvents.flowCompleted(nil)\n\n\treturn TestFlowOutput{"));
+
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("TestFlowEvents"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_dry_run() {
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!code.contains("TestFlowDryRun"));
+
+        let yaml = r#"
+id: test_flow
+inputs:
+  - name: user_id
+    type: string
+chain:
+  - step: gate
+    id: check_input
+    condition: "user_id != ''"
+  - step: call
+    id: quote
+    spec: price_order
+    inputs:
+      id: "user_id"
+  - step: call
+    id: charge
+    spec: charge_card
+    side_effect: true
+    inputs:
+      id: "user_id"
+"#;
+        let orch = crate::orchestrate::Orchestrator::from_yaml(yaml).unwrap();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        let dry_run = &code[code
+            .find("func TestFlowDryRun(input TestFlowInput) (TestFlowPlan, error) {")
+            .unwrap()..];
+
+        assert!(dry_run.contains("\tctx.Quote = PriceOrder(quoteInput)"));
+        assert!(dry_run.contains("\tplan.Intents = append(plan.Intents, TestFlowIntent{Step: \"charge\", Spec: \"charge_card\", Input: chargeInput})"));
+        assert!(!dry_run.contains("ChargeCard(chargeInput)"));
+        assert!(dry_run.contains(
+            "\t\tplan.Context = ctx\n\t\treturn plan, testFlowCheckInputFailed(input, ctx)"
+        ));
+        // The live flow still makes the call
+        assert!(code.contains("\tchargeResult := ChargeCard(chargeInput)"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_scenario_mocks() {
+        let mut orch = sample_orchestrator();
+        orch.scenarios.push(crate::orchestrate::Scenario {
+            name: "valid".into(),
+            description: None,
+            inputs: Default::default(),
+            steps: Default::default(),
+            gates: Default::default(),
+            outputs: Default::default(),
+        });
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("var testFlowMocks map[string]interface{}"));
+        assert!(code.contains("\tif mock, ok := testFlowMocks[\"validate\"]; ok {\n\t\tvalidateResult = mock\n\t} else {\n\t\tvalidateResult = ValidateUser(validateInput)\n\t}"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_guarded_calls() {
+        let specs = std::collections::HashMap::new();
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("testFlowGuard"));
+
+        let yaml = r#"
+id: test_flow
+inputs:
+  - name: user_id
+    type: string
+chain:
+  - step: call
+    id: charge
+    spec: charge_card
+    rate_limit:
+      per_second: 2.5
+      burst: 10
+    circuit_breaker:
+      failures: 3
+      cooldown_ms: 5000
+    inputs:
+      id: "user_id"
+"#;
+        let mut orch = crate::orchestrate::Orchestrator::from_yaml(yaml).unwrap();
+        orch.durable = true;
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\t\"sync\"\n"));
+        assert!(code.contains("\t\"time\"\n"));
+        assert!(code.contains("type TestFlowRateLimiter interface {"));
+        assert!(code.contains("type TestFlowCircuitBreaker interface {"));
+        assert!(code.contains("\t\"charge\": {PerSecond: 2.5, Burst: 10},\n"));
+        assert!(code.contains("\t\"charge\": {Failures: 3, Cooldown: 5000 * time.Millisecond},\n"));
+        assert!(code
+            .contains("\tchargeResult, err := testFlowGuard(\"charge\", func() interface{} {\n"));
+        assert!(code.contains("\t\treturn ChargeCard(chargeInput)\n\t})\n\tif err != nil {\n"));
+        assert!(code.contains("\t\treturn TestFlowOutput{}, err\n"));
+        // The durable runner guards the call too
+        assert!(code.contains("\tresult, err := testFlowGuard(\"charge\", func() interface{} {"));
+        assert!(!code.contains("ctx.Charge = ChargeCard(chargeInput)"));
+        // A call returning an error counts against the breaker like a panic
+        assert!(code.contains("\tif callErr, ok := result.(error); ok {\n\t\treturn nil, TestFlowError{Step: step, Type: \"call_failed\", Message: callErr.Error()}\n\t}"));
+
+        for target in [
+            Target::Rust,
+            Target::TypeScript,
+            Target::Python,
+            Target::Java,
+            Target::CSharp,
+        ] {
+            let err = render_orchestrator(&orch, &specs, target, false).unwrap_err();
+            assert!(matches!(err, TemplateError::RenderError(ref m)
+                if m == "test_flow: only the Go target supports rate_limit (step 'charge'), circuit_breaker (step 'charge')"));
+        }
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_bulkhead() {
+        let specs = std::collections::HashMap::new();
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("TestFlowExecute"));
+        assert!(!plain.contains("\t\"context\""));
+
+        let mut orch = sample_orchestrator();
+        orch.bulkhead = Some(crate::orchestrate::Bulkhead {
+            max_concurrent: 4,
+            max_queued: Some(16),
+            wait_ms: Some(250),
+        });
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\t\"context\"\n"));
+        assert!(code.contains("\t\"errors\"\n\t\"expvar\"\n"));
+        assert!(code.contains("\t\"time\"\n"));
+        assert!(code.contains("var testFlowBulkhead = expvar.NewMap(\"test_flow_bulkhead\")"));
+        assert!(code.contains("var testFlowSlots = make(chan struct{}, 4)"));
+        assert!(code.contains("var testFlowAdmitted = make(chan struct{}, 20)"));
+        assert!(code.contains("func TestFlowExecute(runCtx context.Context, input TestFlowInput) (TestFlowOutput, error) {"));
+        assert!(code.contains("\ttimer := time.NewTimer(250 * time.Millisecond)"));
+        assert!(code.contains("\treturn TestFlow(input)\n}"));
+
+        orch.bulkhead = Some(crate::orchestrate::Bulkhead {
+            max_concurrent: 4,
+            max_queued: None,
+            wait_ms: None,
+        });
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        assert!(code.contains("func TestFlowExecute("));
+        assert!(!code.contains("testFlowAdmitted"));
+        assert!(!code.contains("\t\"time\""));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_deadline() {
+        let specs = std::collections::HashMap::new();
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("Deadline"));
+
+        let yaml = r#"
+id: test_flow
+deadline_ms: 1000
+inputs:
+  - name: user_id
+    type: string
+chain:
+  - step: call
+    id: quote
+    spec: price_order
+    weight: 3
+    inputs:
+      id: "user_id"
+  - step: call
+    id: charge
+    spec: charge_card
+    inputs:
+      id: "user_id"
+  - step: call
+    id: notify
+    spec: send_receipt
+    optional: true
+    inputs:
+      id: "user_id"
+"#;
+        let orch = crate::orchestrate::Orchestrator::from_yaml(yaml).unwrap();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\tdeadline time.Time\n"));
+        assert!(code.contains("const TestFlowDeadline = 1000 * time.Millisecond"));
+        assert!(code.contains("\t\"quote\": 600 * time.Millisecond,\n"));
+        assert!(code.contains("\t\"charge\": 200 * time.Millisecond,\n"));
+        assert!(code.contains("\t\"notify\": 200 * time.Millisecond,\n"));
+        assert!(code.contains("func testFlowStartDeadline() time.Time {"));
+        assert!(code.contains("\tctx.deadline = testFlowStartDeadline()\n"));
+        assert!(code.contains("\tif TestFlowClock().After(ctx.deadline) {\n\t\terr := TestFlowError{Step: \"charge\", Type: \"deadline_exceeded\", Message: \"flow deadline of 1000ms exceeded\"}\n"));
+        assert!(code.contains("\tif testFlowHasBudget(ctx.deadline, \"notify\") {\n"));
+        assert!(!code.contains("testFlowHasBudget(ctx.deadline, \"charge\")"));
+
+        let mut traced = orch.clone();
+        traced.tracing = true;
+        let code = render_orchestrator(&traced, &specs, Target::Go, false).unwrap();
+        assert!(code.contains("func testFlowStartDeadline(runCtx context.Context) time.Time {"));
+        assert!(code.contains("\tctx.deadline = testFlowStartDeadline(runCtx)\n"));
+
+        let err = render_orchestrator(&orch, &specs, Target::TypeScript, false).unwrap_err();
+        assert!(matches!(err, TemplateError::RenderError(ref m)
+            if m == "test_flow: only the Go target supports deadline_ms, weight (step 'quote'), optional (step 'notify')"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_degraded_steps() {
+        let specs = std::collections::HashMap::new();
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("Degraded"));
+
+        let yaml = r#"
+id: test_flow
+deadline_ms: 500
+inputs:
+  - name: user_id
+    type: string
+outputs:
+  - name: approved
+    type: bool
+chain:
+  - step: call
+    id: loyalty
+    spec: loyalty_points
+    optional: true
+    on_error: default
+    default:
+      points: 0
+    inputs:
+      id: "user_id"
+"#;
+        let mut orch = crate::orchestrate::Orchestrator::from_yaml(yaml).unwrap();
+        orch.durable = true;
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\tDegraded []string `json:\"degraded,omitempty\"`\n"));
+        assert!(code.contains("\tDegraded []string\n"));
+        assert!(code
+            .contains("\tloyaltyResult, err := testFlowTry(\"loyalty\", func() interface{} {\n"));
+        assert!(code.contains("\t\tloyaltyResult = map[string]interface{}{\"points\": 0}\n\t\ttestFlowDegrade(&ctx, \"loyalty\", err)\n"));
+        // Skipped for lack of time, the step degrades too
+        assert!(
+            code.contains("\t} else {\n\t\tctx.Loyalty = map[string]interface{}{\"points\": 0}\n")
+        );
+        assert!(code.contains("\t\tDegraded: ctx.Degraded,\n"));
+        // The durable runner degrades instead of failing the run
+        assert!(code.contains("\t\tresult = map[string]interface{}{\"points\": 0}\n\t\ttestFlowDegrade(ctx, \"loyalty\", err)\n"));
+
+        // A returned error degrades the step like a panic
+        assert!(code.contains("\tresult = call()\n\tif callErr, ok := result.(error); ok {\n"));
+
+        orch.logging = true;
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        assert!(code.contains("\t\tTestFlowLogger.Warn(\"step degraded\", attrs...)"));
+
+        let err = render_orchestrator(&orch, &specs, Target::Java, false).unwrap_err();
+        assert!(matches!(err, TemplateError::RenderError(ref m)
+            if m.ends_with("optional (step 'loyalty'), on_error: default (step 'loyalty')")));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_gate_values() {
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("func testFlowCheckInputFailed(input TestFlowInput, ctx TestFlowContext) TestFlowError {"));
+        assert!(code.contains("\t\t\"user_id\": input.UserId,\n"));
+        assert!(code.contains(
+            "\t\tMessage: fmt.Sprintf(\"user_id was %v, required != ''\", values[\"user_id\"]),\n\t\tValues:  values,\n"
+        ));
+        assert!(
+            code.contains("\t\treturn TestFlowOutput{}, testFlowCheckInputFailed(input, ctx)\n")
+        );
+        assert!(!code.contains("crypto/sha256"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_redacted_gate_values() {
+        use crate::spec::Redact;
+        let specs = std::collections::HashMap::new();
+        let mut orch = sample_orchestrator();
+        orch.inputs[0].pii = true;
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        assert!(code.contains("\t\t\"user_id\": \"***\",\n"));
+        assert!(!code.contains("testFlowHash"));
+
+        orch.inputs[0].redact = Some(Redact::Hash);
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        assert!(code.contains("\t\"crypto/hmac\"\n\t\"crypto/sha256\""));
+        assert!(code.contains("var TestFlowRedactKey []byte"));
+        assert!(code.contains("func testFlowHash(value interface{}) string {"));
+        assert!(code.contains("\tmac := hmac.New(sha256.New, TestFlowRedactKey)\n"));
+        assert!(code.contains("\t\t\"user_id\": testFlowHash(input.UserId),\n"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_checks_spec_versions() {
+        let mut spec = sample_spec();
+        spec.id = "validate_user".into();
+        let spec_go = render_spec(&spec, Target::Go, false).unwrap();
+        assert!(spec_go.contains(&format!(
+            "func ValidateUserSpecHash() string {{\n\treturn \"{}\"\n}}",
+            spec.hash()
+        )));
+
+        let specs = std::collections::HashMap::from([(spec.id.clone(), spec.clone())]);
+        let code = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(code.contains("func init() {"));
+        assert!(code.contains(&format!(
+            "\t\t{{\"validate_user\", \"{}\", ValidateUserSpecHash()}},\n",
+            spec.hash()
+        )));
+        assert!(code.contains("regenerate test_flow"));
+
+        let unknown = std::collections::HashMap::new();
+        let code =
+            render_orchestrator(&sample_orchestrator(), &unknown, Target::Go, false).unwrap();
+        assert!(!code.contains("func init() {"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_injectable() {
+        let mut orch = sample_orchestrator();
+        orch.injectable = true;
+        orch.logging = true;
+        orch.deadline_ms = Some(1000);
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("type TestFlowDeps struct {"));
+        assert!(code.contains("\tValidate func(ValidateUserInput) interface{}\n"));
+        assert!(code.contains("\tInterceptors []TestFlowInterceptor\n"));
+        assert!(code.contains("\tLogger *slog.Logger\n"));
+        assert!(code.contains("\tClock func() time.Time\n}"));
+        assert!(code.contains("func NewTestFlow(deps TestFlowDeps) *TestFlowRunner {"));
+        assert!(code.contains("var testFlowDefault = NewTestFlow(TestFlowDeps{})"));
+        assert!(code.contains(
+            "func TestFlow(input TestFlowInput) (TestFlowOutput, error) {\n\treturn testFlowDefault.Run(input)\n}"
+        ));
+        assert!(code.contains(
+            "\tvalidateResult := ctx.call(\"validate\", func() interface{} { return ctx.deps.Validate(validateInput) })\n"
+        ));
+        assert!(code.contains("\tctx.deadline = testFlowStartDeadline(ctx.now())\n"));
+        assert!(code.contains("\tif ctx.now().After(ctx.deadline) {\n"));
+        assert!(code.contains("\t\ttestFlowLogGate(ctx.logger(), \"check_input\", false)\n"));
+        assert!(!code.contains("ValidateUser(validateInput)"));
+
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("TestFlowDeps"));
+        assert!(plain.contains("\tvalidateResult := ValidateUser(validateInput)\n"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_tracing() {
+        let mut orch = sample_orchestrator();
+        orch.tracing = true;
+        orch.hooks = true;
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\t\"context\""));
+        assert!(code.contains(
+            "func TestFlow(runCtx context.Context, input TestFlowInput) (TestFlowOutput, error) {"
+        ));
+        assert!(code.contains(
+            "func TestFlowWithTrace(runCtx context.Context, traceID string) context.Context {"
+        ));
+        assert!(code.contains("\tTraceID string\n"));
+        assert!(code.contains("\tTraceID string `json:\"trace_id,omitempty\"`"));
+        assert!(code.contains(
+            "\t\terr := testFlowCheckInputFailed(input, ctx).withTrace(TestFlowTraceID(runCtx))\n"
+        ));
+        assert!(code.contains("\t\tTestFlowEvents.flowCompleted(err.TraceID, err)\n"));
+        assert!(code.contains(
+            "\tTestFlowEvents.step(TestFlowEvents.StepStarted, TestFlowTraceID(runCtx), \"validate\", \"validate_user\", time.Time{})"
+        ));
+
+        let plain = render_orchestrator(&sample_orchestrator(), &specs, Target::Go, false).unwrap();
+        assert!(!plain.contains("TraceID"));
+        assert!(plain.contains("func TestFlow(input TestFlowInput) (TestFlowOutput, error) {"));
+    }
+
+    #[test]
+    fn test_render_orchestrator_go_logging() {
+        let mut orch = sample_orchestrator();
+        orch.logging = true;
+        let specs = std::collections::HashMap::new();
+        let code = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+
+        assert!(code.contains("\t\"log/slog\""));
+        assert!(code.contains("var TestFlowLogger *slog.Logger"));
+        assert!(code.contains("var TestFlowClock = time.Now"));
+        assert!(code.contains("\tvalidateStarted := TestFlowClock()\n"));
+        assert!(
+            code.contains("\ttestFlowLogStep(\"validate\", \"validate_user\", validateStarted)\n")
+        );
+        assert!(code.contains("\t\ttestFlowLogGate(\"check_input\", false)\n"));
+        assert!(code.contains("\ttestFlowLogGate(\"check_input\", true)\n"));
+
+        orch.tracing = true;
+        let traced = render_orchestrator(&orch, &specs, Target::Go, false).unwrap();
+        assert!(traced.contains(
+            "\ttestFlowLogGate(\"check_input\", true, \"trace_id\", TestFlowTraceID(runCtx))\n"
+        ));
     }
 
     #[test]
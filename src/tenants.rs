@@ -0,0 +1,237 @@
+//! Per-tenant spec namespaces
+//!
+//! A SaaS host serving many customers' rules from one process keeps an
+//! [`Engine`] per tenant, spec and version in a [`Tenants`] registry. Each
+//! engine has its own cache, so one tenant's traffic never evicts or reads
+//! another's results, and its own counters, exported with `tenant`, `spec`
+//! and `version` labels by [`Tenants::metrics`].
+
+use crate::error::{Error, Result};
+use crate::runtime::{Engine, Evaluation, Limits, Values};
+use crate::spec::Spec;
+use std::collections::HashMap;
+use std::fmt::Write;
+use std::sync::{Arc, RwLock};
+
+/// Identifies one spec version of one tenant
+#[derive(Debug, Clone, PartialEq, Eq, Hash, PartialOrd, Ord)]
+pub struct SpecKey {
+    pub tenant: String,
+    pub spec: String,
+    pub version: String,
+}
+
+impl SpecKey {
+    pub fn new(tenant: &str, spec: &str, version: &str) -> Self {
+        Self {
+            tenant: tenant.to_string(),
+            spec: spec.to_string(),
+            version: version.to_string(),
+        }
+    }
+}
+
+impl std::fmt::Display for SpecKey {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        write!(f, "{}/{}@{}", self.tenant, self.spec, self.version)
+    }
+}
+
+/// Engines by tenant, spec and version
+#[derive(Default)]
+pub struct Tenants {
+    engines: RwLock<HashMap<SpecKey, Arc<Engine>>>,
+    /// Limits every registered spec is held to (see [`Engine::with_limits`])
+    limits: Option<Limits>,
+}
+
+impl Tenants {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Hold every spec registered from now on to `limits`
+    pub fn with_limits(mut self, limits: Limits) -> Self {
+        self.limits = Some(limits);
+        self
+    }
+
+    /// Serve `spec` to `tenant` under its id and `meta.version`, replacing
+    /// (and dropping the cache of) an engine registered under the same key
+    pub fn register(&self, tenant: &str, spec: Spec) -> Result<SpecKey> {
+        let version = spec.meta.version.clone().ok_or_else(|| {
+            Error::Other(format!(
+                "{}: spec has no meta.version to register under",
+                spec.id
+            ))
+        })?;
+        let key = SpecKey::new(tenant, &spec.id, &version);
+        let mut engine = Engine::new(spec);
+        if let Some(limits) = self.limits {
+            engine = engine.with_limits(limits)?;
+        }
+        self.engines
+            .write()
+            .unwrap()
+            .insert(key.clone(), Arc::new(engine));
+        Ok(key)
+    }
+
+    /// Stop serving one spec version; false when it was not registered
+    pub fn remove(&self, key: &SpecKey) -> bool {
+        self.engines.write().unwrap().remove(key).is_some()
+    }
+
+    /// Stop serving every spec of a tenant, returning how many there were
+    pub fn remove_tenant(&self, tenant: &str) -> usize {
+        let mut engines = self.engines.write().unwrap();
+        let before = engines.len();
+        engines.retain(|key, _| key.tenant != tenant);
+        before - engines.len()
+    }
+
+    /// The engine serving a spec version
+    pub fn engine(&self, key: &SpecKey) -> Option<Arc<Engine>> {
+        self.engines.read().unwrap().get(key).cloned()
+    }
+
+    /// Registered keys, sorted
+    pub fn keys(&self) -> Vec<SpecKey> {
+        let mut keys: Vec<_> = self.engines.read().unwrap().keys().cloned().collect();
+        keys.sort();
+        keys
+    }
+
+    /// Evaluate an input with a tenant's spec version
+    pub fn evaluate(&self, key: &SpecKey, input: &Values) -> Result<Evaluation> {
+        let engine = self
+            .engine(key)
+            .ok_or_else(|| Error::Other(format!("{}: not registered", key)))?;
+        engine.evaluate(input)
+    }
+
+    /// Counters of every engine in the Prometheus text format, labelled
+    /// with its tenant, spec and version
+    pub fn metrics(&self) -> String {
+        let engines = self.engines.read().unwrap();
+        let mut keys: Vec<_> = engines.keys().collect();
+        keys.sort();
+        let mut out = String::new();
+        let families: [(&str, &str, fn(crate::runtime::Stats) -> u64); 3] = [
+            ("imacs_evaluations_total", "Evaluations", |s| s.evaluations),
+            (
+                "imacs_evaluation_errors_total",
+                "Evaluations that failed",
+                |s| s.errors,
+            ),
+            (
+                "imacs_cache_hits_total",
+                "Evaluations served from the cache",
+                |s| s.cache_hits,
+            ),
+        ];
+        for (name, help, count) in families {
+            let _ = writeln!(out, "# HELP {} {}", name, help);
+            let _ = writeln!(out, "# TYPE {} counter", name);
+            for key in &keys {
+                let _ = writeln!(
+                    out,
+                    "{}{{tenant=\"{}\",spec=\"{}\",version=\"{}\"}} {}",
+                    name,
+                    label(&key.tenant),
+                    label(&key.spec),
+                    label(&key.version),
+                    count(engines[*key].stats())
+                );
+            }
+        }
+        out
+    }
+}
+
+/// Escape a Prometheus label value
+fn label(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('"', "\\\"")
+        .replace('\n', "\\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::cel::CelValue;
+
+    fn spec(rate: f64, version: &str) -> Spec {
+        Spec::from_yaml(&format!(
+            r#"
+id: shipping_rate
+meta:
+  version: "{}"
+inputs:
+  - name: weight_kg
+    type: float
+outputs:
+  - name: rate
+    type: float
+cache:
+  size: 10
+rules:
+  - id: R1
+    when: "weight_kg > 10.0"
+    then: 25.0
+default: {:.1}
+"#,
+            version, rate
+        ))
+        .unwrap()
+    }
+
+    fn weight(kg: f64) -> Values {
+        HashMap::from([("weight_kg".to_string(), CelValue::Float(kg))])
+    }
+
+    #[test]
+    fn test_tenants_are_isolated() {
+        let tenants = Tenants::new();
+        let acme = tenants.register("acme", spec(5.0, "1.0.0")).unwrap();
+        let globex = tenants.register("globex", spec(7.0, "1.0.0")).unwrap();
+        let acme_next = tenants.register("acme", spec(6.0, "1.1.0")).unwrap();
+        assert_eq!(acme.to_string(), "acme/shipping_rate@1.0.0");
+
+        let rate =
+            |key: &SpecKey| tenants.evaluate(key, &weight(1.0)).unwrap().outputs["rate"].clone();
+        assert_eq!(rate(&acme), CelValue::Float(5.0));
+        assert_eq!(rate(&acme), CelValue::Float(5.0));
+        assert_eq!(rate(&globex), CelValue::Float(7.0));
+        assert_eq!(rate(&acme_next), CelValue::Float(6.0));
+
+        // Each engine caches and counts on its own
+        assert_eq!(tenants.engine(&acme).unwrap().stats().cache_hits, 1);
+        assert_eq!(tenants.engine(&globex).unwrap().stats().cache_hits, 0);
+
+        let metrics = tenants.metrics();
+        assert!(metrics.contains("# TYPE imacs_evaluations_total counter\n"));
+        assert!(metrics.contains(
+            "imacs_evaluations_total{tenant=\"acme\",spec=\"shipping_rate\",version=\"1.0.0\"} 2\n"
+        ));
+        assert!(metrics.contains(
+            "imacs_cache_hits_total{tenant=\"globex\",spec=\"shipping_rate\",version=\"1.0.0\"} 0\n"
+        ));
+
+        assert_eq!(tenants.remove_tenant("acme"), 2);
+        assert_eq!(tenants.keys(), vec![globex]);
+        let err = tenants.evaluate(&acme, &weight(1.0)).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("acme/shipping_rate@1.0.0: not registered"));
+    }
+
+    #[test]
+    fn test_register_needs_version() {
+        let mut unversioned = spec(5.0, "1.0.0");
+        unversioned.meta.version = None;
+        let err = Tenants::new().register("acme", unversioned).unwrap_err();
+        assert!(err.to_string().contains("no meta.version"));
+    }
+}
@@ -1,6 +1,7 @@
 //! Go test generation
 
 use crate::spec::*;
+use crate::templates::context::spec_mentions;
 use chrono::Utc;
 
 use super::{extract_test_values, to_pascal_case, TestConfig};
@@ -15,8 +16,30 @@ pub fn generate(spec: &Spec, _config: &TestConfig) -> String {
     out.push_str(&format!("// GENERATED: {}\n", Utc::now().to_rfc3339()));
     out.push_str("// DO NOT EDIT — regenerate from spec\n\n");
 
+    // Stateful entry points; a spec converting currencies has no cache and
+    // is called with rates, so it is left out
+    let uses_rates = spec_mentions(spec, "convert_currency(");
+    let cached = spec.cache.is_some() && !uses_rates;
+    let stateful = !uses_rates
+        && !spec.rules.is_empty()
+        && (cached || spec.codegen.counters || spec.codegen.batch);
+
     out.push_str("package main\n\n");
-    out.push_str("import \"testing\"\n\n");
+    if stateful {
+        // Outputs may be structs holding slices or maps, which `!=` cannot
+        // compare
+        let reflect = if cached || spec.codegen.batch {
+            "\t\"reflect\"\n"
+        } else {
+            ""
+        };
+        out.push_str(&format!(
+            "import (\n{}\t\"sync\"\n\t\"testing\"\n)\n\n",
+            reflect
+        ));
+    } else {
+        out.push_str("import \"testing\"\n\n");
+    }
 
     for rule in &spec.rules {
         let test_name = format!("Test{}_{}", func_name, to_pascal_case(&rule.id));
@@ -41,9 +64,176 @@ pub fn generate(spec: &Spec, _config: &TestConfig) -> String {
         out.push_str("}\n\n");
     }
 
+    if spec.codegen.zero_alloc {
+        generate_alloc_checks(&mut out, spec, &func_name, &struct_name);
+    }
+
+    if stateful {
+        generate_concurrency_checks(&mut out, spec, cached, &func_name, &struct_name);
+    }
+
     out
 }
 
+/// Goroutines calling a stateful entry point at once in the concurrency
+/// checks, and calls made by each
+const GOROUTINES: usize = 8;
+const CALLS: usize = 200;
+
+/// Tests calling the memoized wrapper, the counted entry point and the
+/// batch function from many goroutines at once, one input per rule; under
+/// `go test -race` they verify the generated synchronization
+fn generate_concurrency_checks(
+    out: &mut String,
+    spec: &Spec,
+    cached: bool,
+    func_name: &str,
+    struct_name: &str,
+) {
+    let mut inputs = format!("\tinputs := []{}{{\n", struct_name);
+    for rule in &spec.rules {
+        inputs.push_str(&format!(
+            "\t\t{}, // {}\n",
+            generate_go_input(spec, rule, struct_name),
+            rule.id
+        ));
+    }
+    inputs.push_str("\t}\n");
+
+    out.push_str("// The concurrent tests are meant for `go test -race`\n\n");
+    if cached {
+        out.push_str(&format!(
+            "func Test{}CachedConcurrent(t *testing.T) {{\n",
+            func_name
+        ));
+        out.push_str(&inputs);
+        push_goroutines(
+            out,
+            &[
+                "input := inputs[(g+i)%len(inputs)]".to_string(),
+                format!(
+                    "if got, want := {}Cached(input), {}(input); !reflect.DeepEqual(got, want) {{",
+                    func_name, func_name
+                ),
+                "\tt.Errorf(\"%+v: cached %v, expected %v\", input, got, want)".to_string(),
+                "\treturn".to_string(),
+                "}".to_string(),
+            ],
+        );
+        out.push_str("}\n\n");
+    }
+
+    if spec.codegen.counters {
+        let calls = GOROUTINES * CALLS;
+        out.push_str(&format!(
+            "func Test{}StatsConcurrent(t *testing.T) {{\n",
+            func_name
+        ));
+        out.push_str(&inputs);
+        out.push_str("\ttotal := func() (hits int64) {\n");
+        out.push_str(&format!("\t\tfor _, n := range {}Stats() {{\n", func_name));
+        out.push_str("\t\t\thits += n\n");
+        out.push_str("\t\t}\n");
+        out.push_str("\t\treturn hits\n");
+        out.push_str("\t}\n");
+        out.push_str("\tbefore := total()\n");
+        push_goroutines(out, &[format!("{}(inputs[(g+i)%len(inputs)])", func_name)]);
+        out.push_str(&format!(
+            "\tif hits := total() - before; hits != {} {{\n",
+            calls
+        ));
+        out.push_str(&format!(
+            "\t\tt.Errorf(\"expected {} rule hits, got %d\", hits)\n",
+            calls
+        ));
+        out.push_str("\t}\n");
+        out.push_str("}\n\n");
+    }
+
+    if spec.codegen.batch {
+        out.push_str(&format!(
+            "func Test{}EvalBatchConcurrent(t *testing.T) {{\n",
+            func_name
+        ));
+        out.push_str(&inputs);
+        push_goroutines(
+            out,
+            &[
+                format!("results := {}EvalBatch(inputs)", func_name),
+                "for j, input := range inputs {".to_string(),
+                format!(
+                    "\tif want := {}(input); !reflect.DeepEqual(results[j], want) {{",
+                    func_name
+                ),
+                "\t\tt.Errorf(\"%+v: batch %v, expected %v\", input, results[j], want)".to_string(),
+                "\t\treturn".to_string(),
+                "\t}".to_string(),
+                "}".to_string(),
+            ],
+        );
+        out.push_str("}\n\n");
+    }
+}
+
+/// Run `body` `CALLS` times in each of `GOROUTINES` goroutines (`g` and `i`
+/// in scope) and wait for them
+fn push_goroutines(out: &mut String, body: &[String]) {
+    out.push_str("\tvar wg sync.WaitGroup\n");
+    out.push_str(&format!("\tfor g := 0; g < {}; g++ {{\n", GOROUTINES));
+    out.push_str("\t\twg.Add(1)\n");
+    out.push_str("\t\tgo func(g int) {\n");
+    out.push_str("\t\t\tdefer wg.Done()\n");
+    out.push_str(&format!("\t\t\tfor i := 0; i < {}; i++ {{\n", CALLS));
+    for line in body {
+        out.push_str(&format!("\t\t\t\t{}\n", line));
+    }
+    out.push_str("\t\t\t}\n");
+    out.push_str("\t\t}(g)\n");
+    out.push_str("\t}\n");
+    out.push_str("\twg.Wait()\n");
+}
+
+/// Benchmarks and allocation checks for the pointer entry point, one case
+/// per rule
+fn generate_alloc_checks(out: &mut String, spec: &Spec, func_name: &str, struct_name: &str) {
+    let cases: Vec<(String, String)> = spec
+        .rules
+        .iter()
+        .map(|rule| (rule.id.clone(), generate_go_input(spec, rule, struct_name)))
+        .collect();
+
+    out.push_str(&format!("func Benchmark{}(b *testing.B) {{\n", func_name));
+    for (id, input) in &cases {
+        out.push_str(&format!("\tb.Run(\"{}\", func(b *testing.B) {{\n", id));
+        out.push_str(&format!("\t\tinput := {}\n", input));
+        out.push_str("\t\tb.ReportAllocs()\n");
+        out.push_str("\t\tfor i := 0; i < b.N; i++ {\n");
+        out.push_str(&format!("\t\t\t{}Ptr(&input)\n", func_name));
+        out.push_str("\t\t}\n");
+        out.push_str("\t})\n");
+    }
+    out.push_str("}\n\n");
+
+    out.push_str(&format!(
+        "func Test{}ZeroAlloc(t *testing.T) {{\n",
+        func_name
+    ));
+    for (id, input) in &cases {
+        out.push_str(&format!("\tinput{} := {}\n", to_pascal_case(id), input));
+        out.push_str(&format!(
+            "\tif allocs := testing.AllocsPerRun(100, func() {{ {}Ptr(&input{}) }}); allocs != 0 {{\n",
+            func_name,
+            to_pascal_case(id)
+        ));
+        out.push_str(&format!(
+            "\t\tt.Errorf(\"{}: expected 0 allocations, got %v\", allocs)\n",
+            id
+        ));
+        out.push_str("\t}\n");
+    }
+    out.push_str("}\n\n");
+}
+
 fn generate_go_input(spec: &Spec, rule: &Rule, struct_name: &str) -> String {
     let values = extract_test_values(rule, &spec.inputs);
     let fields: Vec<String> = spec
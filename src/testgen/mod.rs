@@ -129,6 +129,8 @@ impl TestGenerator {
 
     /// Generate test file
     pub fn generate(&self, spec: &Spec) -> String {
+        let generated = spec.for_codegen();
+        let spec = generated.as_ref();
         match self.target {
             Target::Rust => rust::generate(spec, &self.config),
             Target::TypeScript => typescript::generate(spec, &self.config),
@@ -508,4 +510,46 @@ rules:
         assert!(tests.contains("def test_"));
         assert!(tests.contains("assert"));
     }
+
+    #[test]
+    fn test_generate_go_zero_alloc() {
+        let mut spec = sample_spec();
+        assert!(!generate_tests(&spec, Target::Go).contains("Benchmark"));
+
+        spec.codegen.zero_alloc = true;
+        let tests = generate_tests(&spec, Target::Go);
+        assert!(tests.contains("func BenchmarkCheckStatus(b *testing.B) {"));
+        assert!(tests.contains("\tb.Run(\"R2\", func(b *testing.B) {"));
+        assert!(tests.contains("CheckStatusPtr(&input)"));
+        assert!(tests.contains("testing.AllocsPerRun(100, func() { CheckStatusPtr(&inputR3) })"));
+    }
+
+    #[test]
+    fn test_generate_go_concurrency() {
+        let mut spec = sample_spec();
+        let tests = generate_tests(&spec, Target::Go);
+        assert!(tests.contains("import \"testing\"\n"));
+        assert!(!tests.contains("Concurrent"));
+
+        spec.cache = Some(CacheConfig {
+            size: 10,
+            ttl_secs: None,
+        });
+        spec.codegen.counters = true;
+        spec.codegen.batch = true;
+        let tests = generate_tests(&spec, Target::Go);
+        assert!(tests.contains("import (\n\t\"reflect\"\n\t\"sync\"\n\t\"testing\"\n)\n"));
+        assert!(tests.contains("\t\tCheckStatusInput{RateExceeded: true, Locked: false}, // R1\n"));
+        assert!(tests.contains("func TestCheckStatusCachedConcurrent(t *testing.T) {"));
+        assert!(tests.contains(
+            "\t\t\t\tif got, want := CheckStatusCached(input), CheckStatus(input); !reflect.DeepEqual(got, want) {\n"
+        ));
+        assert!(tests.contains("\t\t\t\tCheckStatus(inputs[(g+i)%len(inputs)])\n"));
+        assert!(tests.contains("\tif hits := total() - before; hits != 1600 {\n"));
+        assert!(tests.contains("\t\t\t\tresults := CheckStatusEvalBatch(inputs)\n"));
+        assert!(tests.contains(
+            "\t\t\t\t\tif want := CheckStatus(input); !reflect.DeepEqual(results[j], want) {\n"
+        ));
+        assert!(tests.contains("\tfor g := 0; g < 8; g++ {\n"));
+    }
 }
@@ -4,10 +4,11 @@
 //! - Happy path tests (all gates pass, steps execute)
 //! - Gate failure tests (each gate checked individually)
 //! - Step execution verification
+//! - Scenario tests declared in the flow (Go)
 
 use crate::cel::Target;
-use crate::orchestrate::{ChainStep, Orchestrator};
-use crate::spec::VarType;
+use crate::orchestrate::{ChainStep, Orchestrator, Scenario};
+use crate::spec::{ConditionValue, VarType};
 use chrono::Utc;
 
 use super::{to_camel_case, to_pascal_case};
@@ -516,6 +517,9 @@ fn generate_go(orch: &Orchestrator) -> String {
 
     out.push_str("package main\n\n");
     out.push_str("import (\n");
+    if orch.tracing {
+        out.push_str("\t\"context\"\n");
+    }
     out.push_str("\t\"testing\"\n");
     out.push_str(")\n\n");
 
@@ -534,7 +538,7 @@ fn generate_go(orch: &Orchestrator) -> String {
         ));
     }
     out.push_str("\t}\n\n");
-    out.push_str(&format!("\t_, err := {}(input)\n", fn_name));
+    out.push_str(&format!("\t_, err := {}\n", go_flow_call(orch)));
     out.push_str("\tif err != nil {\n");
     out.push_str("\t\tt.Errorf(\"expected success, got error: %v\", err)\n");
     out.push_str("\t}\n");
@@ -558,7 +562,7 @@ fn generate_go(orch: &Orchestrator) -> String {
                 ));
             }
             out.push_str("\t}\n\n");
-            out.push_str(&format!("\t_, err := {}(input)\n", fn_name));
+            out.push_str(&format!("\t_, err := {}\n", go_flow_call(orch)));
             out.push_str("\tif err == nil {\n");
             out.push_str("\t\tt.Error(\"expected error, got success\")\n");
             out.push_str("\t}\n");
@@ -577,9 +581,141 @@ fn generate_go(orch: &Orchestrator) -> String {
         }
     }
 
+    for scenario in &orch.scenarios {
+        out.push_str(&generate_go_scenario(orch, scenario));
+    }
+
+    out
+}
+
+/// Call of the Go flow function on `input`
+fn go_flow_call(orch: &Orchestrator) -> String {
+    if orch.tracing {
+        format!("{}(context.Background(), input)", to_pascal_case(&orch.id))
+    } else {
+        format!("{}(input)", to_pascal_case(&orch.id))
+    }
+}
+
+/// End-to-end test for a declared scenario, with its step outputs mocked
+fn generate_go_scenario(orch: &Orchestrator, scenario: &Scenario) -> String {
+    let mut out = String::new();
+    let fn_name = to_pascal_case(&orch.id);
+    let test_name = to_pascal_case(&scenario.name.replace([' ', '-'], "_"));
+
+    out.push_str(&format!(
+        "func Test{}_Scenario_{}(t *testing.T) {{\n",
+        fn_name, test_name
+    ));
+    if let Some(description) = &scenario.description {
+        out.push_str(&format!("\t// {}\n", description));
+    }
+    if !scenario.steps.is_empty() {
+        out.push_str(&format!(
+            "\t{}Mocks = map[string]interface{{}}{{\n",
+            to_camel_case(&orch.id)
+        ));
+        for (step, outputs) in &scenario.steps {
+            let fields: Vec<String> = outputs
+                .iter()
+                .map(|(name, value)| format!("\"{}\": {}", name, go_literal(value)))
+                .collect();
+            out.push_str(&format!(
+                "\t\t\"{}\": map[string]interface{{}}{{{}}},\n",
+                step,
+                fields.join(", ")
+            ));
+        }
+        out.push_str("\t}\n");
+        out.push_str(&format!(
+            "\tdefer func() {{ {}Mocks = nil }}()\n\n",
+            to_camel_case(&orch.id)
+        ));
+    }
+
+    out.push_str(&format!("\tinput := {}Input{{\n", fn_name));
+    for input in &orch.inputs {
+        let value = match scenario.inputs.get(&input.name) {
+            Some(value) => go_literal(value),
+            None => go_default_value(&input.var_type),
+        };
+        out.push_str(&format!(
+            "\t\t{}: {},\n",
+            to_pascal_case(&input.name),
+            value
+        ));
+    }
+    out.push_str("\t}\n\n");
+
+    // The flow stops at the first gate expected to fail
+    let failing_gate = orch.chain.iter().find_map(|step| match step {
+        ChainStep::Gate(gate) if scenario.gates.get(&gate.id) == Some(&false) => Some(&gate.id),
+        _ => None,
+    });
+    match failing_gate {
+        Some(gate) => {
+            out.push_str(&format!("\t_, err := {}\n", go_flow_call(orch)));
+            out.push_str(&format!(
+                "\tif orchErr, ok := err.({}Error); !ok || orchErr.Step != \"{}\" {{\n",
+                fn_name, gate
+            ));
+            out.push_str(&format!(
+                "\t\tt.Fatalf(\"expected gate '{}' to fail, got %v\", err)\n",
+                gate
+            ));
+            out.push_str("\t}\n");
+        }
+        None if scenario.outputs.is_empty() => {
+            out.push_str(&format!("\t_, err := {}\n", go_flow_call(orch)));
+            out.push_str("\tif err != nil {\n");
+            out.push_str("\t\tt.Fatalf(\"expected success, got error: %v\", err)\n");
+            out.push_str("\t}\n");
+        }
+        None => {
+            out.push_str(&format!("\tresult, err := {}\n", go_flow_call(orch)));
+            out.push_str("\tif err != nil {\n");
+            out.push_str("\t\tt.Fatalf(\"expected success, got error: %v\", err)\n");
+            out.push_str("\t}\n");
+            for (name, value) in &scenario.outputs {
+                let field = to_pascal_case(name);
+                let expected = go_literal(value);
+                out.push_str(&format!("\tif result.{} != {} {{\n", field, expected));
+                out.push_str(&format!(
+                    "\t\tt.Errorf(\"expected {} = %v, got %v\", {}, result.{})\n",
+                    name, expected, field
+                ));
+                out.push_str("\t}\n");
+            }
+        }
+    }
+    out.push_str("}\n\n");
     out
 }
 
+/// Go literal of a value; lists and maps hold `interface{}`
+pub(crate) fn go_literal(value: &ConditionValue) -> String {
+    match value {
+        ConditionValue::Bool(b) => b.to_string(),
+        ConditionValue::Int(i) => i.to_string(),
+        ConditionValue::Float(f) => format!("{:?}", f),
+        ConditionValue::String(s) => format!("{:?}", s),
+        ConditionValue::List(items) => {
+            let items: Vec<String> = items.iter().map(go_literal).collect();
+            format!("[]interface{{}}{{{}}}", items.join(", "))
+        }
+        ConditionValue::Map(fields) => {
+            let mut fields: Vec<_> = fields.iter().collect();
+            fields.sort_by_key(|(k, _)| *k);
+            let fields: Vec<String> = fields
+                .iter()
+                .map(|(k, v)| format!("\"{}\": {}", k, go_literal(v)))
+                .collect();
+            format!("map[string]interface{{}}{{{}}}", fields.join(", "))
+        }
+        ConditionValue::Null => "nil".into(),
+    }
+}
+
 fn go_sample_value(typ: &VarType) -> String {
     match typ {
         VarType::Bool => "true".into(),
@@ -780,6 +916,68 @@ chain:
         assert!(tests.contains("should succeed with valid inputs"));
     }
 
+    #[test]
+    fn test_generate_go_scenario_tests() {
+        let yaml = r#"
+id: order_flow
+inputs:
+  - name: role
+    type: string
+  - name: verified
+    type: bool
+outputs:
+  - name: approved
+    type: bool
+chain:
+  - step: call
+    id: check_access
+    spec: access_level
+    inputs:
+      role: "role"
+  - step: gate
+    id: require_access
+    condition: "check_access.level >= 50"
+scenarios:
+  - name: admin approved
+    inputs:
+      role: admin
+    steps:
+      check_access:
+        level: 90
+    gates:
+      require_access: true
+    outputs:
+      approved: true
+  - name: guest-denied
+    steps:
+      check_access:
+        level: 10
+    gates:
+      require_access: false
+"#;
+        let orch = Orchestrator::from_yaml(yaml).unwrap();
+        let tests = generate_go(&orch);
+
+        assert!(tests.contains("func TestOrderFlow_Scenario_AdminApproved(t *testing.T) {"));
+        assert!(tests.contains(
+            "\torderFlowMocks = map[string]interface{}{\n\t\t\"check_access\": map[string]interface{}{\"level\": 90},\n\t}\n"
+        ));
+        assert!(tests.contains("\t\tRole: \"admin\",\n\t\tVerified: false,\n"));
+        assert!(tests.contains("\tif result.Approved != true {"));
+
+        assert!(tests.contains("func TestOrderFlow_Scenario_GuestDenied(t *testing.T) {"));
+        assert!(tests.contains(
+            "\tif orchErr, ok := err.(OrderFlowError); !ok || orchErr.Step != \"require_access\" {"
+        ));
+        assert!(!tests.contains("context.Background()"));
+
+        let mut traced = orch.clone();
+        traced.tracing = true;
+        let tests = generate_go(&traced);
+        assert!(tests.contains("import (\n\t\"context\"\n\t\"testing\"\n)"));
+        assert!(tests.contains("\tresult, err := OrderFlow(context.Background(), input)\n"));
+    }
+
     #[test]
     fn test_generate_python_orchestrator_tests() {
         let orch = sample_orchestrator();
@@ -0,0 +1,394 @@
+//! Interactive rule browser and tester (`imacs tui`)
+//!
+//! Shows a spec's inputs, the rule the current input matches with its
+//! outputs, and every rule in evaluation order, redrawn after each
+//! command. Inputs are edited with a widget suited to their type: bools
+//! toggle, enums and fields with declared `values` cycle through (or pick
+//! from) their choices, numbers are parsed and checked, lists and objects
+//! take JSON.
+//!
+//! The screen is plain ANSI output driven by line input, so it works in
+//! any terminal and over a pipe.
+
+use crate::assertions::literal;
+use crate::cel::{CelCompiler, CelValue};
+use crate::error::{Error, Result};
+use crate::runtime::{canonical_input, evaluate_with_drafts, to_cel_value, Values};
+use crate::spec::{ConditionValue, RuleState, Spec, VarType, Variable};
+use std::io::{BufRead, Write};
+
+/// Clear the screen and move the cursor home
+const CLEAR: &str = "\x1b[2J\x1b[H";
+
+const HELP: &str = "<field> [value]  edit a field (bools toggle, choices cycle without a value)
+unset <field>    clear an optional field
+n / p            inspect the next / previous rule
+rule <id>        inspect a rule
+q                quit";
+
+/// State of one browsing session
+pub struct Session<'a> {
+    spec: &'a Spec,
+    /// Current input; fields without a value are left out
+    pub input: Values,
+    /// Index (in evaluation order) of the rule being inspected
+    selected: usize,
+    status: String,
+}
+
+impl<'a> Session<'a> {
+    /// Start with every input at its default, or the first value its type
+    /// allows; optional inputs without a default start unset
+    pub fn new(spec: &'a Spec) -> Self {
+        let mut input = Values::new();
+        for var in &spec.inputs {
+            let initial = match (&var.default, choices(var)) {
+                (Some(default), _) => Some(to_cel_value(default)),
+                _ if var.optional => None,
+                (None, Some(choices)) => choices.first().map(|c| c.clone().into()),
+                (None, None) => match &var.typ {
+                    VarType::Bool => Some(false.into()),
+                    VarType::Int => Some(0i64.into()),
+                    VarType::Float => Some(0.0f64.into()),
+                    VarType::String | VarType::Enum(_) => Some(String::new().into()),
+                    VarType::List(_) => Some(Vec::<CelValue>::new().into()),
+                    VarType::Object => None,
+                },
+            };
+            if let Some(value) = initial {
+                input.insert(var.name.clone(), value);
+            }
+        }
+        Session {
+            spec,
+            input,
+            selected: 0,
+            status: "Type ? for help".to_string(),
+        }
+    }
+
+    /// Apply one command line; false once the user quits
+    pub fn command(&mut self, line: &str) -> bool {
+        let line = line.trim();
+        let (word, rest) = line.split_once(' ').unwrap_or((line, ""));
+        let rest = rest.trim();
+        let rules = self.spec.rules.len();
+        self.status.clear();
+        match word {
+            "" => {}
+            "q" | "quit" | "exit" => return false,
+            "?" | "help" => self.status = HELP.to_string(),
+            "n" if rules > 0 => self.selected = (self.selected + 1) % rules,
+            "p" if rules > 0 => self.selected = (self.selected + rules - 1) % rules,
+            "rule" => {
+                match self
+                    .spec
+                    .evaluation_order()
+                    .iter()
+                    .position(|r| r.id == rest)
+                {
+                    Some(i) => self.selected = i,
+                    None => self.status = format!("No rule '{}'", rest),
+                }
+            }
+            "unset" => match self.field(rest) {
+                Some(var) if var.optional || var.default.is_some() => {
+                    self.input.remove(&var.name);
+                }
+                Some(var) => self.status = format!("'{}' is required", var.name),
+                None => self.status = format!("No field '{}'", rest),
+            },
+            _ => match self.field(word) {
+                Some(var) => {
+                    let current = self.input.get(&var.name);
+                    match edit(var, current, rest) {
+                        Ok(value) => {
+                            self.input.insert(var.name.clone(), value);
+                        }
+                        Err(e) => self.status = e,
+                    }
+                }
+                None => self.status = format!("Unknown command or field '{}' (? for help)", word),
+            },
+        }
+        true
+    }
+
+    /// A field by 1-based number or name
+    fn field(&self, name: &str) -> Option<&'a Variable> {
+        let spec = self.spec;
+        match name.parse::<usize>() {
+            Ok(n) => spec.inputs.get(n.checked_sub(1)?),
+            Err(_) => spec.inputs.iter().find(|v| v.name == name),
+        }
+    }
+
+    /// The screen for the current state
+    pub fn render(&self) -> String {
+        let spec = self.spec;
+        let mut out = format!(
+            "{} — {} rule(s)\n\nINPUTS\n",
+            spec.name.as_deref().unwrap_or(&spec.id),
+            spec.rules.len()
+        );
+        let width = spec.inputs.iter().map(|v| v.name.len()).max().unwrap_or(0);
+        for (i, var) in spec.inputs.iter().enumerate() {
+            let value = self.input.get(&var.name);
+            out.push_str(&format!(
+                "  {:>2}  {:width$}  {}\n",
+                i + 1,
+                var.name,
+                widget(var, value),
+                width = width
+            ));
+        }
+
+        out.push_str("\nRESULT\n");
+        let evaluation = evaluate_with_drafts(spec, &self.input);
+        match &evaluation {
+            Ok(evaluation) => {
+                let mut outputs: Vec<String> = evaluation
+                    .outputs
+                    .iter()
+                    .map(|(name, value)| format!("{} = {}", name, literal(value)))
+                    .collect();
+                outputs.sort();
+                let decided = match &evaluation.rule_id {
+                    Some(id) => format!("rule {}", id),
+                    None => "default".to_string(),
+                };
+                out.push_str(&format!("  {}: {}\n", decided, outputs.join(", ")));
+            }
+            Err(e) => out.push_str(&format!("  error: {}\n", e)),
+        }
+
+        out.push_str("\nRULES  (▶ decides, ✓ also holds, - retired)\n");
+        let scope = canonical_input(spec, &self.input);
+        let matched = evaluation.as_ref().ok().and_then(|e| e.rule_id.as_deref());
+        let order = spec.evaluation_order();
+        for (i, rule) in order.iter().enumerate() {
+            let holds = rule
+                .as_cel()
+                .map_or(Ok(true), |cel| CelCompiler::eval_bool(&cel, &scope));
+            let marker = match (&holds, rule.state) {
+                (_, RuleState::Retired) => "-",
+                _ if matched == Some(rule.id.as_str()) => "▶",
+                (Ok(true), _) => "✓",
+                (Ok(false), _) => " ",
+                (Err(_), _) => "!",
+            };
+            let cursor = if i == self.selected { "›" } else { " " };
+            out.push_str(&format!(
+                "{} {} {}  {}  → {}\n",
+                cursor,
+                marker,
+                rule.id,
+                rule.as_cel().unwrap_or_else(|| "true".to_string()),
+                rule.then
+            ));
+        }
+        if let Some(default) = &spec.default {
+            out.push_str(&format!("    (default)  → {}\n", default));
+        }
+
+        if let Some(rule) = order.get(self.selected) {
+            out.push_str(&format!("\nRULE {}", rule.id));
+            if rule.state != RuleState::Active {
+                out.push_str(&format!(" ({})", rule.state));
+            }
+            out.push('\n');
+            if let Some(description) = &rule.description {
+                out.push_str(&format!("  {}\n", description));
+            }
+            out.push_str(&format!(
+                "  when: {}\n  then: {}\n",
+                rule.as_cel().unwrap_or_else(|| "true".to_string()),
+                rule.then
+            ));
+            if let Some(owner) = &rule.owner {
+                out.push_str(&format!("  owner: {}\n", owner));
+            }
+        }
+
+        if !self.status.is_empty() {
+            out.push_str(&format!("\n{}\n", self.status));
+        }
+        out
+    }
+}
+
+/// Run a session, reading commands from `input` until `q` or end of input
+pub fn run(spec: &Spec, input: impl BufRead, mut out: impl Write) -> Result<()> {
+    let mut session = Session::new(spec);
+    let mut lines = input.lines();
+    loop {
+        write!(out, "{}{}\n> ", CLEAR, session.render()).map_err(Error::Io)?;
+        out.flush().map_err(Error::Io)?;
+        let Some(line) = lines.next() else {
+            return Ok(());
+        };
+        if !session.command(&line.map_err(Error::Io)?) {
+            return Ok(());
+        }
+    }
+}
+
+/// Declared choices of a field: its enum variants or `values`
+fn choices(var: &Variable) -> Option<&Vec<String>> {
+    match &var.typ {
+        VarType::Enum(variants) => Some(variants),
+        _ => var.values.as_ref(),
+    }
+}
+
+/// How a field's value is shown, e.g. `[x]` or `eu [us] uk`
+fn widget(var: &Variable, value: Option<&CelValue>) -> String {
+    let Some(value) = value else {
+        return "(unset)".to_string();
+    };
+    match (&var.typ, value, choices(var)) {
+        (VarType::Bool, CelValue::Bool(b), _) => (if *b { "[x]" } else { "[ ]" }).to_string(),
+        (_, CelValue::String(current), Some(choices)) => choices
+            .iter()
+            .map(|c| {
+                if c == current.as_str() {
+                    format!("[{}]", c)
+                } else {
+                    c.clone()
+                }
+            })
+            .collect::<Vec<_>>()
+            .join(" "),
+        _ => {
+            let unit = var.unit.as_ref().or(var.currency.as_ref());
+            match unit {
+                Some(unit) => format!("{} {}", literal(value), unit),
+                None => literal(value),
+            }
+        }
+    }
+}
+
+/// New value of a field from what the user typed after its name
+fn edit(
+    var: &Variable,
+    current: Option<&CelValue>,
+    text: &str,
+) -> std::result::Result<CelValue, String> {
+    if let Some(choices) = choices(var) {
+        if text.is_empty() {
+            let at = current
+                .and_then(|c| match c {
+                    CelValue::String(s) => choices.iter().position(|v| v == s.as_str()),
+                    _ => None,
+                })
+                .map_or(0, |i| (i + 1) % choices.len());
+            return choices
+                .get(at)
+                .map(|c| c.clone().into())
+                .ok_or_else(|| format!("'{}' has no values", var.name));
+        }
+        let text = text.trim_matches(|c| c == '\'' || c == '"');
+        return if choices.iter().any(|c| c == text) {
+            Ok(text.to_string().into())
+        } else {
+            Err(format!("'{}' is one of: {}", var.name, choices.join(", ")))
+        };
+    }
+    match &var.typ {
+        VarType::Bool => match (text, current) {
+            ("", Some(CelValue::Bool(b))) => Ok((!b).into()),
+            ("" | "true" | "yes" | "y" | "1", _) => Ok(true.into()),
+            ("false" | "no" | "n" | "0", _) => Ok(false.into()),
+            _ => Err(format!("'{}' is a bool: true or false", var.name)),
+        },
+        VarType::Int => text
+            .parse::<i64>()
+            .map(Into::into)
+            .map_err(|_| format!("'{}' is an int", var.name)),
+        VarType::Float => text
+            .parse::<f64>()
+            .map(Into::into)
+            .map_err(|_| format!("'{}' is a number", var.name)),
+        VarType::String | VarType::Enum(_) => Ok(text
+            .trim_matches(|c| c == '\'' || c == '"')
+            .to_string()
+            .into()),
+        VarType::List(_) | VarType::Object => serde_json::from_str::<ConditionValue>(text)
+            .map(|value| to_cel_value(&value))
+            .map_err(|e| format!("'{}' takes JSON: {}", var.name, e)),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping
+inputs:
+  - name: zone
+    type: string
+    values: [domestic, eu, world]
+  - name: express
+    type: bool
+  - name: weight
+    type: float
+    unit: kg
+outputs:
+  - name: rate
+    type: int
+rules:
+  - id: R1
+    when: "zone == 'domestic' && !express"
+    then: 0
+  - id: R2
+    when: "zone == 'eu'"
+    then: 500
+  - id: R3
+    when: "weight > 10.0"
+    then: 2000
+default: 900
+"#;
+
+    #[test]
+    fn test_session_widgets() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let mut session = Session::new(&spec);
+        let screen = session.render();
+        assert!(screen.contains("zone     [domestic] eu world"));
+        assert!(screen.contains("express  [ ]"));
+        assert!(screen.contains("weight   0.0 kg"));
+        assert!(screen.contains("rule R1: rate = 0"));
+
+        // Choices cycle, bools toggle, numbers are checked
+        assert!(session.command("zone"));
+        assert!(session.command("2"));
+        assert!(session.command("weight 12.5"));
+        let screen = session.render();
+        assert!(screen.contains("domestic [eu] world"));
+        assert!(screen.contains("express  [x]"));
+        assert!(screen.contains("rule R2: rate = 500"));
+        assert!(screen.contains("  ✓ R3"));
+
+        session.command("weight heavy");
+        assert!(session.render().contains("'weight' is a number"));
+        session.command("zone mars");
+        assert!(session
+            .render()
+            .contains("'zone' is one of: domestic, eu, world"));
+        assert!(!session.command("q"));
+    }
+
+    #[test]
+    fn test_run_reads_commands() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let mut out = Vec::new();
+        run(&spec, "zone world\nn\nn\n".as_bytes(), &mut out).unwrap();
+        let out = String::from_utf8(out).unwrap();
+        let last = out.rsplit(CLEAR).next().unwrap();
+        assert!(last.contains("default: rate = 900"));
+        assert!(last.contains("›   R3"));
+        assert!(last.contains("RULE R3"));
+    }
+}
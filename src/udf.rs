@@ -0,0 +1,286 @@
+//! Spark and Beam UDF export (`imacs udf`)
+//!
+//! Data engineering re-scores historical datasets with the rules production
+//! runs. The export is one self-contained Python module: the spec's pure
+//! Python function (no counters, cache or other state), a JSON codec around
+//! it, and an adapter for the engine — a PySpark UDF over a struct column
+//! returning a struct of the outputs, or a Beam `DoFn`. It imports nothing
+//! but the standard library and the engine, so it ships to workers as is.
+
+use crate::cel::Target;
+use crate::error::{Error, Result};
+use crate::spec::{ConditionValue, Spec, VarType};
+use crate::util::to_pascal_case;
+use std::fmt::Write;
+
+/// Engine the exported function is packaged for
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Engine {
+    Spark,
+    Beam,
+}
+
+impl std::str::FromStr for Engine {
+    type Err = Error;
+
+    fn from_str(s: &str) -> Result<Self> {
+        match s {
+            "spark" => Ok(Engine::Spark),
+            "beam" => Ok(Engine::Beam),
+            other => Err(Error::Other(format!(
+                "Unknown UDF engine: {} (expected spark or beam)",
+                other
+            ))),
+        }
+    }
+}
+
+/// Render a spec as a Python module for `engine`
+///
+/// Besides `{id}(input)`, the module defines `{id}_decode` (a JSON object,
+/// or its text, to the input; absent inputs take their defaults),
+/// `{id}_record` (a dict of inputs to a dict of outputs) and `{id}_json`
+/// (JSON text to JSON text). Outputs are keyed by name, as `imacs eval`
+/// reports them.
+pub fn render_udf(spec: &Spec, engine: Engine) -> Result<String> {
+    let refuse = |reason: &str| {
+        Error::Other(format!(
+            "'{}' cannot be exported as a UDF: {}",
+            spec.id, reason
+        ))
+    };
+    if spec.outputs.is_empty() {
+        return Err(refuse("it declares no output types"));
+    }
+    if crate::templates::context::spec_mentions(spec, "convert_currency(") {
+        return Err(refuse(
+            "it needs conversion rates, which a UDF is not given",
+        ));
+    }
+
+    let mut pure = spec.clone();
+    pure.codegen.lean = true;
+    pure.codegen.shadow = false;
+    pure.cache = None;
+    let mut out = crate::templates::render_spec(&pure, Target::Python, true)
+        .map_err(|e| Error::Other(e.to_string()))?;
+
+    let id = &spec.id;
+    let pascal = to_pascal_case(id);
+    let floats = spec.inputs.iter().any(|v| v.typ == VarType::Float);
+
+    let _ = write!(
+        out,
+        "\n\n# JSON codec\n\nimport json\n{}\n",
+        if spec.outputs.len() > 1 {
+            "from dataclasses import asdict\n"
+        } else {
+            ""
+        }
+    );
+    if floats {
+        out.push_str(
+            "\ndef _as_float(value: Any) -> Any:\n    \
+             # JSON and most engines hand whole numbers over as ints\n    \
+             return None if value is None else float(value)\n\n",
+        );
+    }
+    let _ = write!(
+        out,
+        "\ndef {id}_decode(record: str | bytes | dict[str, Any]) -> {pascal}Input:\n    \
+         \"\"\"The input in a JSON object, or its text\"\"\"\n    \
+         data = json.loads(record) if isinstance(record, (str, bytes)) else record\n    \
+         return {pascal}Input(\n"
+    );
+    for var in &spec.inputs {
+        let field = match (&var.default, var.optional) {
+            (Some(default), _) => {
+                format!("data.get(\"{}\", {})", var.name, python_literal(default))
+            }
+            (None, true) => format!("data.get(\"{}\")", var.name),
+            (None, false) => format!("data[\"{}\"]", var.name),
+        };
+        let field = if var.typ == VarType::Float {
+            format!("_as_float({})", field)
+        } else {
+            field
+        };
+        let _ = writeln!(out, "        {}={},", var.name, field);
+    }
+    out.push_str("    )\n\n\n");
+
+    let encoded = if spec.outputs.len() > 1 {
+        "asdict(result)".to_string()
+    } else {
+        format!("{{\"{}\": result}}", spec.outputs[0].name)
+    };
+    let _ = write!(
+        out,
+        "def {id}_record(data: dict[str, Any]) -> dict[str, Any]:\n    \
+         \"\"\"Outputs by name for inputs by name\"\"\"\n    \
+         result = {id}({id}_decode(data))\n    \
+         return {encoded}\n\n\n\
+         def {id}_json(record: str | bytes) -> str:\n    \
+         \"\"\"Decide a JSON-encoded input, returning the JSON-encoded outputs\"\"\"\n    \
+         return json.dumps({id}_record(json.loads(record)))\n"
+    );
+
+    match engine {
+        Engine::Spark => {
+            let fields = spec
+                .outputs
+                .iter()
+                .map(|var| {
+                    let typ = spark_type(&var.typ)
+                        .ok_or_else(|| refuse(&format!("output '{}' is an object", var.name)))?;
+                    Ok(format!(
+                        "        spark_types.StructField(\"{}\", {}),\n",
+                        var.name, typ
+                    ))
+                })
+                .collect::<Result<String>>()?;
+            let _ = write!(
+                out,
+                "\n\n# PySpark UDF\n\n\
+                 from pyspark.sql import types as spark_types\n\
+                 from pyspark.sql.functions import udf\n\n\
+                 {upper}_SCHEMA = spark_types.StructType(\n    [\n{fields}    ]\n)\n\n\n\
+                 @udf(returnType={upper}_SCHEMA)\n\
+                 def {id}_udf(row: Any) -> dict[str, Any]:\n    \
+                 \"\"\"{id} over a struct of the inputs, e.g.\n\n    \
+                 df.withColumn(\"{id}\", {id}_udf(struct(*df.columns)))\n    \
+                 \"\"\"\n    \
+                 return {id}_record(row.asDict(recursive=True))\n",
+                upper = id.to_uppercase()
+            );
+        }
+        Engine::Beam => {
+            let _ = write!(
+                out,
+                "\n\n# Beam DoFn\n\n\
+                 import apache_beam as beam\n\n\n\
+                 class {pascal}DoFn(beam.DoFn):\n    \
+                 \"\"\"Decides each element: a dict of the inputs, or its JSON text.\n\n    \
+                 Yields the outputs by name, e.g.\n\n    \
+                 records | beam.ParDo({pascal}DoFn())\n    \
+                 \"\"\"\n\n    \
+                 def process(self, element: Any):\n        \
+                 if isinstance(element, (str, bytes)):\n            \
+                 element = json.loads(element)\n        \
+                 yield {id}_record(element)\n"
+            );
+        }
+    }
+    Ok(out)
+}
+
+/// PySpark type of a spec type; `None` for objects
+fn spark_type(typ: &VarType) -> Option<String> {
+    Some(match typ {
+        VarType::Bool => "spark_types.BooleanType()".into(),
+        VarType::Int => "spark_types.LongType()".into(),
+        VarType::Float => "spark_types.DoubleType()".into(),
+        VarType::String | VarType::Enum(_) => "spark_types.StringType()".into(),
+        VarType::List(inner) => format!("spark_types.ArrayType({})", spark_type(inner)?),
+        VarType::Object => return None,
+    })
+}
+
+/// A default value as a Python literal
+fn python_literal(value: &ConditionValue) -> String {
+    match value {
+        ConditionValue::Bool(b) => if *b { "True" } else { "False" }.to_string(),
+        ConditionValue::Int(i) => i.to_string(),
+        ConditionValue::Float(f) => format!("{:?}", f),
+        ConditionValue::String(s) => serde_json::to_string(s).unwrap_or_default(),
+        ConditionValue::Null => "None".to_string(),
+        ConditionValue::List(items) => format!(
+            "[{}]",
+            items
+                .iter()
+                .map(python_literal)
+                .collect::<Vec<_>>()
+                .join(", ")
+        ),
+        ConditionValue::Map(map) => {
+            let mut pairs: Vec<_> = map
+                .iter()
+                .map(|(k, v)| format!("{:?}: {}", k, python_literal(v)))
+                .collect();
+            pairs.sort();
+            format!("{{{}}}", pairs.join(", "))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const SPEC: &str = r#"
+id: shipping_rate
+inputs:
+  - name: weight_kg
+    type: float
+  - name: zone
+    type: string
+    default: domestic
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: HEAVY
+    when: "weight_kg > 20.0"
+    then: 25.0
+  - id: INTL
+    when: "zone != 'domestic'"
+    then: 12.0
+default: 5.0
+codegen:
+  counters: true
+"#;
+
+    #[test]
+    fn test_render_spark_udf() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let code = render_udf(&spec, Engine::Spark).unwrap();
+        assert!(code.contains("def shipping_rate(input: ShippingRateInput) -> float:"));
+        // The exported function is pure
+        assert!(!code.contains("_SHIPPING_RATE_HITS"));
+        assert!(code.contains("        weight_kg=_as_float(data[\"weight_kg\"]),\n"));
+        assert!(code.contains("        zone=data.get(\"zone\", \"domestic\"),\n"));
+        assert!(code.contains("    return {\"rate\": result}\n"));
+        assert!(code.contains("spark_types.StructField(\"rate\", spark_types.DoubleType()),"));
+        assert!(code.contains("@udf(returnType=SHIPPING_RATE_SCHEMA)"));
+        assert!(!code.contains("apache_beam"));
+    }
+
+    #[test]
+    fn test_render_beam_dofn() {
+        let yaml = SPEC.replace(
+            "outputs:\n  - name: rate\n    type: float\n",
+            "outputs:\n  - name: rate\n    type: float\n  - name: carrier\n    type: string\n",
+        );
+        let yaml = yaml
+            .replace("then: 25.0", "then: {rate: 25.0, carrier: freight}")
+            .replace("then: 12.0", "then: {rate: 12.0, carrier: post}")
+            .replace("default: 5.0", "default: {rate: 5.0, carrier: post}");
+        let spec = Spec::from_yaml(&yaml).unwrap();
+        let code = render_udf(&spec, Engine::Beam).unwrap();
+        assert!(code.contains("    return asdict(result)\n"));
+        assert!(code.contains("class ShippingRateDoFn(beam.DoFn):"));
+        assert!(code.contains("        yield shipping_rate_record(element)\n"));
+        assert!(!code.contains("pyspark"));
+    }
+
+    #[test]
+    fn test_render_udf_refusals() {
+        let untyped = SPEC.replace("outputs:\n  - name: rate\n    type: float\n", "");
+        let err = render_udf(&Spec::from_yaml(&untyped).unwrap(), Engine::Spark).unwrap_err();
+        assert!(err
+            .to_string()
+            .contains("'shipping_rate' cannot be exported as a UDF: it declares no output types"));
+        assert!("flink".parse::<Engine>().is_err());
+        assert_eq!("beam".parse::<Engine>().unwrap(), Engine::Beam);
+    }
+}
@@ -0,0 +1,204 @@
+//! Units of measure for numeric spec fields
+//!
+//! Inputs may declare a unit (`unit: kg`). Expressions convert between
+//! units explicitly with `convert(weight, 'lb', 'kg')`, which compiles to a
+//! multiplication by a constant factor. Validation uses the same table to
+//! reject expressions that mix incompatible units.
+
+use crate::error::{Error, Result};
+
+/// Physical dimension of a unit
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Dimension {
+    Mass,
+    Length,
+    Volume,
+    Time,
+}
+
+/// A known unit with its factor relative to the dimension's base unit
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Unit {
+    /// Canonical symbol
+    pub symbol: &'static str,
+    /// Accepted spellings
+    pub aliases: &'static [&'static str],
+    pub dimension: Dimension,
+    /// Multiply by this to get the base unit (kg, m, l, s)
+    pub factor: f64,
+}
+
+const UNITS: &[Unit] = &[
+    // Mass (base: kg)
+    Unit {
+        symbol: "kg",
+        aliases: &["kilogram", "kilograms"],
+        dimension: Dimension::Mass,
+        factor: 1.0,
+    },
+    Unit {
+        symbol: "g",
+        aliases: &["gram", "grams"],
+        dimension: Dimension::Mass,
+        factor: 0.001,
+    },
+    Unit {
+        symbol: "lb",
+        aliases: &["lbs", "pound", "pounds"],
+        dimension: Dimension::Mass,
+        factor: 0.453_592_37,
+    },
+    Unit {
+        symbol: "oz",
+        aliases: &["ounce", "ounces"],
+        dimension: Dimension::Mass,
+        factor: 0.028_349_523_125,
+    },
+    // Length (base: m)
+    Unit {
+        symbol: "m",
+        aliases: &["meter", "meters"],
+        dimension: Dimension::Length,
+        factor: 1.0,
+    },
+    Unit {
+        symbol: "cm",
+        aliases: &["centimeter", "centimeters"],
+        dimension: Dimension::Length,
+        factor: 0.01,
+    },
+    Unit {
+        symbol: "mm",
+        aliases: &["millimeter", "millimeters"],
+        dimension: Dimension::Length,
+        factor: 0.001,
+    },
+    Unit {
+        symbol: "km",
+        aliases: &["kilometer", "kilometers"],
+        dimension: Dimension::Length,
+        factor: 1000.0,
+    },
+    Unit {
+        symbol: "in",
+        aliases: &["inch", "inches"],
+        dimension: Dimension::Length,
+        factor: 0.0254,
+    },
+    Unit {
+        symbol: "ft",
+        aliases: &["foot", "feet"],
+        dimension: Dimension::Length,
+        factor: 0.3048,
+    },
+    Unit {
+        symbol: "mi",
+        aliases: &["mile", "miles"],
+        dimension: Dimension::Length,
+        factor: 1609.344,
+    },
+    // Volume (base: l)
+    Unit {
+        symbol: "l",
+        aliases: &["liter", "liters"],
+        dimension: Dimension::Volume,
+        factor: 1.0,
+    },
+    Unit {
+        symbol: "ml",
+        aliases: &["milliliter", "milliliters"],
+        dimension: Dimension::Volume,
+        factor: 0.001,
+    },
+    Unit {
+        symbol: "gal",
+        aliases: &["gallon", "gallons"],
+        dimension: Dimension::Volume,
+        factor: 3.785_411_784,
+    },
+    // Time (base: s)
+    Unit {
+        symbol: "s",
+        aliases: &["sec", "second", "seconds"],
+        dimension: Dimension::Time,
+        factor: 1.0,
+    },
+    Unit {
+        symbol: "ms",
+        aliases: &["millisecond", "milliseconds"],
+        dimension: Dimension::Time,
+        factor: 0.001,
+    },
+    Unit {
+        symbol: "min",
+        aliases: &["minute", "minutes"],
+        dimension: Dimension::Time,
+        factor: 60.0,
+    },
+    Unit {
+        symbol: "h",
+        aliases: &["hr", "hour", "hours"],
+        dimension: Dimension::Time,
+        factor: 3600.0,
+    },
+    Unit {
+        symbol: "d",
+        aliases: &["day", "days"],
+        dimension: Dimension::Time,
+        factor: 86400.0,
+    },
+];
+
+/// Look up a unit by symbol or alias (case-insensitive)
+pub fn lookup(symbol: &str) -> Option<&'static Unit> {
+    let symbol = symbol.trim().to_ascii_lowercase();
+    UNITS
+        .iter()
+        .find(|u| u.symbol == symbol || u.aliases.contains(&symbol.as_str()))
+}
+
+/// Canonical symbol for a unit, or the input unchanged if it is not known
+pub fn canonical(symbol: &str) -> String {
+    lookup(symbol)
+        .map(|u| u.symbol.to_string())
+        .unwrap_or_else(|| symbol.trim().to_string())
+}
+
+/// Factor that converts a value in `from` to `to`
+pub fn conversion_factor(from: &str, to: &str) -> Result<f64> {
+    let from_unit = lookup(from).ok_or_else(|| Error::Other(format!("Unknown unit: {}", from)))?;
+    let to_unit = lookup(to).ok_or_else(|| Error::Other(format!("Unknown unit: {}", to)))?;
+    if from_unit.dimension != to_unit.dimension {
+        return Err(Error::Other(format!(
+            "Cannot convert {} ({:?}) to {} ({:?})",
+            from_unit.symbol, from_unit.dimension, to_unit.symbol, to_unit.dimension
+        )));
+    }
+    Ok(from_unit.factor / to_unit.factor)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lookup_aliases() {
+        assert_eq!(lookup("lbs").unwrap().symbol, "lb");
+        assert_eq!(lookup("KG").unwrap().symbol, "kg");
+        assert!(lookup("furlong").is_none());
+        assert_eq!(canonical("pounds"), "lb");
+        assert_eq!(canonical("USD"), "USD");
+    }
+
+    #[test]
+    fn test_conversion_factor() {
+        let factor = conversion_factor("lb", "kg").unwrap();
+        assert!((factor - 0.45359237).abs() < 1e-12);
+
+        let factor = conversion_factor("kg", "g").unwrap();
+        assert!((factor - 1000.0).abs() < 1e-9);
+
+        assert!(conversion_factor("kg", "m").is_err());
+        assert!(conversion_factor("kg", "furlong").is_err());
+    }
+}
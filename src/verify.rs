@@ -119,6 +119,10 @@ impl Verifier {
     }
 
     pub fn verify(&self, spec: &Spec, code: &CodeAst) -> VerificationResult {
+        // Draft and retired rules are not expected in the code
+        let generated = spec.for_codegen();
+        let spec = generated.as_ref();
+
         let func = code
             .get_function(&spec.id)
             .or_else(|| code.functions.first());
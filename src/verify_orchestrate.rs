@@ -342,6 +342,10 @@ fn verify_chain_steps(
             ChainStep::Await(await_) => {
                 expected_order.push(await_.id.clone());
             }
+
+            ChainStep::AwaitApproval(approval) => {
+                expected_order.push(approval.id.clone());
+            }
         }
     }
 }
@@ -470,6 +474,7 @@ fn collect_all_step_ids(steps: &[ChainStep]) -> Vec<String> {
             }
             ChainStep::Dynamic(d) => ids.push(d.id.clone()),
             ChainStep::Await(a) => ids.push(a.id.clone()),
+            ChainStep::AwaitApproval(a) => ids.push(a.id.clone()),
             _ => {}
         }
     }
@@ -526,6 +531,13 @@ mod tests {
                 condition: None,
                 async_: false,
                 retry: None,
+                side_effect: false,
+                rate_limit: None,
+                circuit_breaker: None,
+                weight: None,
+                optional: false,
+                on_error: None,
+                default: Default::default(),
             }),
             ChainStep::Call(CallStep {
                 id: "step2".into(),
@@ -535,6 +547,13 @@ mod tests {
                 condition: None,
                 async_: false,
                 retry: None,
+                side_effect: false,
+                rate_limit: None,
+                circuit_breaker: None,
+                weight: None,
+                optional: false,
+                on_error: None,
+                default: Default::default(),
             }),
         ];
 
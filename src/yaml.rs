@@ -0,0 +1,333 @@
+//! Comment-preserving YAML rewriting
+//!
+//! Tools that change a spec (`validate --fix`, completeness fixes) work on
+//! the parsed [`crate::Spec`] and serialize it again, which on its own drops
+//! every comment. [`carry_comments`] puts them back: each comment in the
+//! original is attached to the node it annotates — by its key path, with
+//! list items identified by their `id` or `name` when they have one — and
+//! re-emitted next to the same node in the rewritten document.
+//!
+//! Comments whose node was removed move to the nearest surviving parent,
+//! so no annotation is lost. Block-style YAML (what specs are written in
+//! and what the serializer emits) is supported; flow collections are
+//! treated as opaque values.
+
+use std::collections::{HashMap, HashSet};
+
+/// Re-attach the comments of `original` to the same nodes in `rewritten`
+pub fn carry_comments(original: &str, rewritten: &str) -> String {
+    let mut leading: HashMap<String, Vec<String>> = HashMap::new();
+    let mut trailing: HashMap<String, String> = HashMap::new();
+    let mut order: Vec<String> = Vec::new();
+    let mut pending: Vec<String> = Vec::new();
+    let mut tracker = Tracker::default();
+    let lines: Vec<&str> = original.lines().collect();
+    for (i, line) in lines.iter().enumerate() {
+        let trimmed = line.trim_start();
+        let paths = tracker.paths(&lines, i);
+        if paths.is_empty() {
+            if trimmed.starts_with('#') && !tracker.in_block(line) {
+                pending.push(trimmed.to_string());
+            }
+            continue;
+        }
+        if !pending.is_empty() {
+            order.push(paths[0].clone());
+            leading
+                .entry(paths[0].clone())
+                .or_default()
+                .append(&mut pending);
+        }
+        let (_, comment) = split_comment(trimmed);
+        if !comment.is_empty() {
+            let deepest = paths.last().unwrap().clone();
+            order.push(deepest.clone());
+            trailing.insert(deepest, comment.to_string());
+        }
+    }
+    let mut tail = pending;
+
+    // Nodes that no longer exist hand their comments to their parent
+    let lines: Vec<&str> = rewritten.lines().collect();
+    let mut tracker = Tracker::default();
+    let present: HashSet<String> = (0..lines.len())
+        .flat_map(|i| tracker.paths(&lines, i))
+        .collect();
+    let mut seen = HashSet::new();
+    for path in order {
+        if present.contains(&path) || !seen.insert(path.clone()) {
+            continue;
+        }
+        let mut orphaned = leading.remove(&path).unwrap_or_default();
+        orphaned.extend(trailing.remove(&path).map(|c| c.trim().to_string()));
+        match ancestor(&path, &present) {
+            Some(parent) => leading.entry(parent).or_default().extend(orphaned),
+            None => tail.extend(orphaned),
+        }
+    }
+
+    let mut out = String::new();
+    let mut tracker = Tracker::default();
+    for (i, line) in lines.iter().enumerate() {
+        let paths = tracker.paths(&lines, i);
+        let indent = &line[..line.len() - line.trim_start().len()];
+        for path in &paths {
+            for comment in leading.remove(path).into_iter().flatten() {
+                out.push_str(indent);
+                out.push_str(&comment);
+                out.push('\n');
+            }
+        }
+        out.push_str(line);
+        if let Some(comment) = paths.iter().rev().find_map(|p| trailing.remove(p)) {
+            out.push_str(&comment);
+        }
+        out.push('\n');
+    }
+    for comment in tail {
+        out.push_str(&comment);
+        out.push('\n');
+    }
+    if !rewritten.ends_with('\n') {
+        out.pop();
+    }
+    out
+}
+
+/// Split a trailing comment (` # ...`, outside quotes) off a line,
+/// returning the content and the comment with its leading whitespace
+pub(crate) fn split_comment(body: &str) -> (&str, &str) {
+    let mut quote: Option<char> = None;
+    let mut prev = ' ';
+    for (i, c) in body.char_indices() {
+        match quote {
+            Some(q) if c == q => quote = None,
+            Some(_) => {}
+            None if c == '"' || c == '\'' => quote = Some(c),
+            None if c == '#' && prev.is_whitespace() => {
+                let content = body[..i].trim_end();
+                return (content, &body[content.len()..]);
+            }
+            None => {}
+        }
+        prev = c;
+    }
+    (body, "")
+}
+
+/// The nearest ancestor of a path that is in `present`
+fn ancestor(path: &str, present: &HashSet<String>) -> Option<String> {
+    let mut path = path;
+    while let Some((parent, _)) = path.rsplit_once('/') {
+        if present.contains(parent) {
+            return Some(parent.to_string());
+        }
+        path = parent;
+    }
+    None
+}
+
+/// Tracks the key path of each line of a block-style YAML document
+#[derive(Default)]
+struct Tracker {
+    /// Open nodes: indent, whether it is a list item, path
+    stack: Vec<(usize, bool, String)>,
+    /// Items seen so far in each list, by the list's path
+    counters: HashMap<String, usize>,
+    /// Indent of the key whose block scalar (`|`, `>`) is being read
+    block: Option<usize>,
+}
+
+impl Tracker {
+    fn in_block(&self, line: &str) -> bool {
+        let indent = line.len() - line.trim_start().len();
+        self.block.is_some_and(|b| indent > b)
+    }
+
+    /// Paths of the nodes line `i` starts, outermost first; empty for
+    /// blank, comment and continuation lines
+    fn paths(&mut self, lines: &[&str], i: usize) -> Vec<String> {
+        let line = lines[i];
+        let trimmed = line.trim_start();
+        let indent = line.len() - trimmed.len();
+        if self.in_block(line) || (self.block.is_some() && trimmed.is_empty()) {
+            return Vec::new();
+        }
+        self.block = None;
+        if trimmed.is_empty() || trimmed.starts_with('#') || trimmed.starts_with("---") {
+            return Vec::new();
+        }
+        let (content, _) = split_comment(trimmed);
+
+        let mut paths = Vec::new();
+        let (key_indent, body) = if content == "-" || content.starts_with("- ") {
+            while self
+                .stack
+                .last()
+                .is_some_and(|&(i, item, _)| i > indent || (i == indent && item))
+            {
+                self.stack.pop();
+            }
+            let body = content[1..].trim_start();
+            let list = self.parent();
+            let key_indent = indent + (content.len() - body.len());
+            let segment = match identity(lines, i, body, key_indent) {
+                Some((key, value)) => format!("[{}={}]", key, value),
+                None => {
+                    let n = self.counters.entry(list.clone()).or_default();
+                    *n += 1;
+                    format!("[{}]", *n - 1)
+                }
+            };
+            let path = format!("{}/{}", list, segment);
+            self.stack.push((indent, true, path.clone()));
+            paths.push(path);
+            (key_indent, body)
+        } else {
+            (indent, content)
+        };
+
+        if let Some((key, value)) = split_key(body) {
+            while self.stack.last().is_some_and(|&(i, _, _)| i >= key_indent) {
+                self.stack.pop();
+            }
+            let path = format!("{}/{}", self.parent(), unquote(key));
+            self.stack.push((key_indent, false, path.clone()));
+            paths.push(path);
+            if matches!(value.trim().chars().next(), Some('|' | '>')) {
+                self.block = Some(key_indent);
+            }
+        }
+        paths
+    }
+
+    fn parent(&self) -> String {
+        self.stack
+            .last()
+            .map(|(_, _, path)| path.clone())
+            .unwrap_or_default()
+    }
+}
+
+/// The `id` or `name` of the list item starting at line `i`, wherever
+/// in the item it is
+fn identity<'a>(
+    lines: &[&'a str],
+    i: usize,
+    body: &'a str,
+    key_indent: usize,
+) -> Option<(&'a str, &'a str)> {
+    let item_lines = lines[i + 1..]
+        .iter()
+        .filter(|l| !l.trim().is_empty() && !l.trim_start().starts_with('#'))
+        .take_while(|l| l.len() - l.trim_start().len() >= key_indent)
+        .filter(|l| l.len() - l.trim_start().len() == key_indent)
+        .map(|l| split_comment(l.trim_start()).0);
+    std::iter::once(body)
+        .chain(item_lines)
+        .filter_map(split_key)
+        .find(|(key, _)| matches!(*key, "id" | "name"))
+        .map(|(key, value)| (key, unquote(value)))
+}
+
+/// Split `key: value` (or a bare `key:`) at the mapping colon
+fn split_key(content: &str) -> Option<(&str, &str)> {
+    let end = match content.chars().next()? {
+        q @ ('"' | '\'') => content[1..].find(q)? + 2,
+        _ => 0,
+    };
+    let colon = end + content[end..].find(':')?;
+    let value = &content[colon + 1..];
+    let key = &content[..colon];
+    let plain = end > 0 || !key.contains(['{', '[', '"', '\'']);
+    (plain && !key.is_empty() && (value.is_empty() || value.starts_with(' ')))
+        .then_some((key, value))
+}
+
+fn unquote(s: &str) -> &str {
+    s.trim().trim_matches(|c| c == '"' || c == '\'')
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::spec::Spec;
+
+    const SPEC: &str = r#"# Shipping rates, owned by logistics
+id: shipping
+inputs:
+  # Destination zone
+  - name: zone
+    type: string
+outputs:
+  - name: rate
+    type: int # cents
+rules:
+  # Domestic shipping is free
+  - id: R1
+    when: "zone == 'domestic'"
+    then: 0
+  - when: "zone == 'eu'" # EU flat rate
+    id: R2
+    then: 500
+  # Retired promo, kept for audit
+  - id: R3
+    when: "zone == 'promo'"
+    then: 1
+default: 900
+# end of rates
+"#;
+
+    #[test]
+    fn test_carry_comments_through_reserialization() {
+        let spec = Spec::from_yaml(SPEC).unwrap();
+        let rewritten = carry_comments(SPEC, &spec.to_yaml().unwrap());
+        for comment in [
+            "# Shipping rates, owned by logistics",
+            "# Destination zone",
+            "# Domestic shipping is free",
+            "# Retired promo, kept for audit",
+            "# end of rates",
+        ] {
+            assert!(
+                rewritten.contains(comment),
+                "missing {:?} in\n{}",
+                comment,
+                rewritten
+            );
+        }
+        let line_of = |comment: &str| {
+            rewritten
+                .lines()
+                .find(|l| l.contains(comment))
+                .unwrap()
+                .trim_start()
+        };
+        assert!(line_of("# cents").starts_with("type:"));
+        // Found by id although the rule lists `when` first
+        assert!(line_of("# EU flat rate").starts_with("when:"));
+        assert_eq!(Spec::from_yaml(&rewritten).unwrap().rules.len(), 3);
+        // Comments stay with their rule
+        let promo = rewritten.find("# Retired promo").unwrap();
+        assert!(promo > rewritten.find("id: R2").unwrap());
+        assert!(promo < rewritten.find("id: R3").unwrap());
+    }
+
+    #[test]
+    fn test_orphaned_comments_move_to_parent() {
+        let mut spec = Spec::from_yaml(SPEC).unwrap();
+        spec.rules.retain(|r| r.id != "R3");
+        let rewritten = carry_comments(SPEC, &spec.to_yaml().unwrap());
+        assert!(rewritten.contains("# Retired promo, kept for audit\nrules:"));
+    }
+
+    #[test]
+    fn test_block_scalars_are_content() {
+        let original = "description: |\n  # not a comment\n  text\nid: x # the id\n";
+        let rewritten = "id: x\ndescription: |\n  # not a comment\n  text\n";
+        assert_eq!(
+            carry_comments(original, rewritten),
+            "id: x # the id\ndescription: |\n  # not a comment\n  text\n"
+        );
+    }
+}
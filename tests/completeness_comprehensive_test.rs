@@ -4,7 +4,7 @@
 //! and ensure the tool works correctly for all edge cases.
 
 use imacs::completeness::analyze_completeness;
-use imacs::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable, WhenClause};
+use imacs::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable, WhenClause};
 use rstest::rstest;
 
 // ============================================================================
@@ -218,6 +218,14 @@ fn make_2_bool_spec(complete: bool) -> Spec {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R2".into(),
@@ -226,6 +234,14 @@ fn make_2_bool_spec(complete: bool) -> Spec {
             then: Output::Single(ConditionValue::Int(2)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R3".into(),
@@ -234,6 +250,14 @@ fn make_2_bool_spec(complete: bool) -> Spec {
             then: Output::Single(ConditionValue::Int(3)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
     ];
 
@@ -245,6 +269,14 @@ fn make_2_bool_spec(complete: bool) -> Spec {
             then: Output::Single(ConditionValue::Int(4)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         });
     }
 
@@ -259,12 +291,26 @@ fn make_2_bool_spec(complete: bool) -> Spec {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
             Variable {
                 name: "b".into(),
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
         ],
         outputs: vec![Variable {
@@ -272,10 +318,25 @@ fn make_2_bool_spec(complete: bool) -> Spec {
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules,
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -291,18 +352,39 @@ fn make_3_bool_spec() -> Spec {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
             Variable {
                 name: "b".into(),
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
             Variable {
                 name: "c".into(),
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
         ],
         outputs: vec![Variable {
@@ -310,6 +392,13 @@ fn make_3_bool_spec() -> Spec {
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: (0..8)
             .map(|i| {
@@ -341,11 +430,27 @@ fn make_3_bool_spec() -> Spec {
                     then: Output::Single(ConditionValue::Int(i as i64)),
                     priority: 0,
                     description: None,
+                    vars: Vec::new(),
+                    tags: Vec::new(),
+                    line: None,
+                    reason_code: None,
+                    message_key: None,
+                    state: RuleState::Active,
+                    owner: None,
+                    approved_by: None,
                 }
             })
             .collect(),
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -360,12 +465,26 @@ fn make_overlapping_spec() -> Spec {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             Rule {
@@ -375,6 +494,14 @@ fn make_overlapping_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -383,10 +510,26 @@ fn make_overlapping_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(2)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -403,12 +546,26 @@ fn make_minimizable_spec() -> Spec {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
             Variable {
                 name: "b".into(),
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             },
         ],
         outputs: vec![Variable {
@@ -416,6 +573,13 @@ fn make_minimizable_spec() -> Spec {
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             Rule {
@@ -425,6 +589,14 @@ fn make_minimizable_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -433,10 +605,26 @@ fn make_minimizable_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -451,16 +639,38 @@ fn make_empty_spec() -> Spec {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -475,12 +685,26 @@ fn make_comparison_spec() -> Spec {
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             Rule {
@@ -490,6 +714,14 @@ fn make_comparison_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -498,10 +730,26 @@ fn make_comparison_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(0)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -516,12 +764,26 @@ fn make_equality_spec() -> Spec {
             typ: VarType::String,
             description: None,
             values: Some(vec!["active".into(), "inactive".into()]),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             Rule {
@@ -531,6 +793,14 @@ fn make_equality_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -539,10 +809,26 @@ fn make_equality_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(0)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -557,12 +843,26 @@ fn make_membership_spec() -> Spec {
             typ: VarType::String,
             description: None,
             values: Some(vec!["US".into(), "EU".into(), "APAC".into()]),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             Rule {
@@ -572,6 +872,14 @@ fn make_membership_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -580,10 +888,26 @@ fn make_membership_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(0)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -598,16 +922,38 @@ fn make_spec_with_var(name: &str, values: Vec<String>) -> Spec {
             typ: VarType::String,
             description: None,
             values: Some(values),
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -622,11 +968,26 @@ fn make_spec_with_input(name: &str, typ: VarType) -> Spec {
             typ,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -642,10 +1003,25 @@ fn make_spec_with_output(name: &str, typ: VarType) -> Spec {
             typ,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -660,12 +1036,26 @@ fn make_spec_with_rule(when: &str) -> Spec {
             typ: VarType::String,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![Rule {
             id: "R1".into(),
@@ -674,9 +1064,25 @@ fn make_spec_with_rule(when: &str) -> Spec {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -693,11 +1099,26 @@ fn make_spec_with_vars(names: Vec<String>) -> Spec {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             })
             .collect(),
         outputs: vec![],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
@@ -3,7 +3,7 @@
 //! Tests all public APIs and edge cases to ensure 100% coverage
 
 use imacs::completeness::*;
-use imacs::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable};
+use imacs::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable};
 
 // ============================================================================
 // analyze_completeness() Edge Cases
@@ -21,16 +21,38 @@ fn test_analyze_empty_spec() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let report = analyze_completeness(&spec);
@@ -53,12 +75,26 @@ fn test_analyze_single_rule() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![Rule {
             id: "R1".into(),
@@ -67,9 +103,25 @@ fn test_analyze_single_rule() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let report = analyze_completeness(&spec);
@@ -91,6 +143,13 @@ fn test_analyze_no_predicates() {
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![Rule {
             id: "R1".into(),
@@ -99,9 +158,25 @@ fn test_analyze_no_predicates() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let report = analyze_completeness(&spec);
@@ -121,12 +196,26 @@ fn test_analyze_invalid_cel() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![Rule {
             id: "R1".into(),
@@ -135,9 +224,25 @@ fn test_analyze_invalid_cel() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let report = analyze_completeness(&spec);
@@ -256,6 +361,14 @@ fn test_rules_to_cover_multiple() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R2".into(),
@@ -264,6 +377,14 @@ fn test_rules_to_cover_multiple() {
             then: Output::Single(ConditionValue::Int(2)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
     ];
 
@@ -295,11 +416,26 @@ fn test_analyze_suite_single() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let specs = vec![("single".into(), spec)];
@@ -319,11 +455,26 @@ fn test_analyze_suite_full_mode() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let specs = vec![("test".into(), spec)];
@@ -348,6 +499,13 @@ fn test_detect_collisions_none() {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         ),
         (
@@ -357,6 +515,13 @@ fn test_detect_collisions_none() {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         ),
     ];
@@ -375,6 +540,13 @@ fn test_detect_collisions_ambiguous() {
                 typ: VarType::String,
                 description: None,
                 values: None, // No values = ambiguous
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         ),
         (
@@ -384,6 +556,13 @@ fn test_detect_collisions_ambiguous() {
                 typ: VarType::String,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         ),
     ];
@@ -411,6 +590,13 @@ fn test_detect_duplicates_none() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![Rule {
@@ -420,9 +606,25 @@ fn test_detect_duplicates_none() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let spec_b = Spec {
@@ -435,6 +637,13 @@ fn test_detect_duplicates_none() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![Rule {
@@ -444,9 +653,25 @@ fn test_detect_duplicates_none() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let specs = vec![("spec_a".into(), &spec_a), ("spec_b".into(), &spec_b)];
@@ -472,11 +697,26 @@ fn test_detect_relationships_none() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let spec_b = Spec {
@@ -489,11 +729,26 @@ fn test_detect_relationships_none() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let specs = vec![("spec_a".into(), &spec_a), ("spec_b".into(), &spec_b)];
@@ -517,6 +772,13 @@ fn test_match_variables_no_matches() {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         ),
         (
@@ -526,6 +788,13 @@ fn test_match_variables_no_matches() {
                 typ: VarType::Bool,
                 description: None,
                 values: None,
+                optional: false,
+                default: None,
+                unit: None,
+                currency: None,
+                aliases: Vec::new(),
+                pii: false,
+                redact: None,
             }],
         ),
     ];
@@ -568,8 +837,24 @@ fn test_analyze_orchestrator_suite_missing_specs() {
             condition: None,
             timeout: None,
             retry: None,
+            side_effect: false,
+            rate_limit: None,
+            circuit_breaker: None,
+            weight: None,
+            optional: false,
+            on_error: None,
+            default: Default::default(),
         })],
         scoping: None,
+        trigger: None,
+        durable: false,
+        hooks: false,
+        scenarios: Vec::new(),
+        tracing: false,
+        logging: false,
+        bulkhead: None,
+        deadline_ms: None,
+        injectable: false,
     };
 
     let specs = HashMap::new();
@@ -3,7 +3,7 @@
 //! Uses proptest to generate random specs and verify invariants
 
 use imacs::completeness::analyze_completeness;
-use imacs::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable};
+use imacs::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable};
 use proptest::prelude::*;
 
 proptest! {
@@ -46,12 +46,26 @@ fn any_spec() -> impl Strategy<Value = Spec> {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }),
         Just(Variable {
             name: "b".into(),
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }),
     ];
 
@@ -64,6 +78,14 @@ fn any_spec() -> impl Strategy<Value = Spec> {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }),
             Just(Rule {
                 id: "R2".into(),
@@ -72,6 +94,14 @@ fn any_spec() -> impl Strategy<Value = Spec> {
                 then: Output::Single(ConditionValue::Int(2)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }),
             Just(Rule {
                 id: "R3".into(),
@@ -80,6 +110,14 @@ fn any_spec() -> impl Strategy<Value = Spec> {
                 then: Output::Single(ConditionValue::Int(3)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             }),
         ],
         0..5,
@@ -96,9 +134,24 @@ fn any_spec() -> impl Strategy<Value = Spec> {
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules,
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     })
 }
@@ -3,7 +3,7 @@
 use imacs::completeness::{
     apply_fixes, apply_fixes_to_yaml, validate_spec, FixConfidence, FixOperation, SpecFix,
 };
-use imacs::spec::{ConditionValue, Output, Spec, VarType};
+use imacs::spec::{ConditionValue, Output, RuleState, Spec, VarType};
 
 fn make_test_spec() -> Spec {
     Spec {
@@ -16,12 +16,26 @@ fn make_test_spec() -> Spec {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![imacs::spec::Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             imacs::spec::Rule {
@@ -31,6 +45,14 @@ fn make_test_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             imacs::spec::Rule {
                 id: "R2".into(),
@@ -39,10 +61,26 @@ fn make_test_spec() -> Spec {
                 then: Output::Single(ConditionValue::Int(2)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -217,6 +255,13 @@ fn test_rename_variable_fix() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![],
         rules: vec![imacs::spec::Rule {
@@ -226,9 +271,25 @@ fn test_rename_variable_fix() {
             then: Output::Single(ConditionValue::Bool(true)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         }],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let fix = SpecFix {
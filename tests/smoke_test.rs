@@ -1,7 +1,7 @@
 //! Smoke test to verify basic functionality
 
 use imacs::completeness::analyze_completeness;
-use imacs::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable};
+use imacs::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable};
 
 #[test]
 fn smoke_test_basic_completeness() {
@@ -15,12 +15,26 @@ fn smoke_test_basic_completeness() {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![
             Rule {
@@ -30,6 +44,14 @@ fn smoke_test_basic_completeness() {
                 then: Output::Single(ConditionValue::Int(1)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
             Rule {
                 id: "R2".into(),
@@ -38,10 +60,26 @@ fn smoke_test_basic_completeness() {
                 then: Output::Single(ConditionValue::Int(0)),
                 priority: 0,
                 description: None,
+                vars: Vec::new(),
+                tags: Vec::new(),
+                line: None,
+                reason_code: None,
+                message_key: None,
+                state: RuleState::Active,
+                owner: None,
+                approved_by: None,
             },
         ],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     };
 
     let report = analyze_completeness(&spec);
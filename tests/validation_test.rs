@@ -1,7 +1,7 @@
 //! Tests for spec validation - impossible situation detection
 
 use imacs::completeness::{validate_spec, IssueType};
-use imacs::spec::{ConditionValue, Output, Rule, Spec, VarType, Variable};
+use imacs::spec::{ConditionValue, Output, Rule, RuleState, Spec, VarType, Variable};
 
 fn make_base_spec() -> Spec {
     Spec {
@@ -14,16 +14,38 @@ fn make_base_spec() -> Spec {
             typ: VarType::Bool,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         outputs: vec![Variable {
             name: "result".into(),
             typ: VarType::Int,
             description: None,
             values: None,
+            optional: false,
+            default: None,
+            unit: None,
+            currency: None,
+            aliases: Vec::new(),
+            pii: false,
+            redact: None,
         }],
         rules: vec![],
         default: None,
         meta: Default::default(),
+        codegen: Default::default(),
+        constraints: Vec::new(),
+        postconditions: Vec::new(),
+        resolution: Default::default(),
+        cache: None,
+        normalize: Vec::new(),
+        assertions: Vec::new(),
+        tables: Vec::new(),
     }
 }
 
@@ -47,6 +69,14 @@ fn test_detect_contradictory_rules() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R2".into(),
@@ -55,6 +85,14 @@ fn test_detect_contradictory_rules() {
             then: Output::Single(ConditionValue::Int(2)), // Different output!
             priority: 0,                                  // Same priority!
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
     ];
 
@@ -78,6 +116,14 @@ fn test_contradiction_with_priority() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R2".into(),
@@ -86,6 +132,14 @@ fn test_contradiction_with_priority() {
             then: Output::Single(ConditionValue::Int(2)),
             priority: 1, // Different priority - not a contradiction!
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
     ];
 
@@ -97,6 +151,91 @@ fn test_contradiction_with_priority() {
         .any(|i| matches!(i.issue_type, IssueType::ContradictoryRules)));
 }
 
+#[test]
+fn test_dead_rule_follows_priority_order() {
+    let mut spec = make_base_spec();
+    spec.rules = vec![
+        Rule {
+            id: "R1".into(),
+            when: Some("a".into()),
+            conditions: None,
+            then: Output::Single(ConditionValue::Int(1)),
+            priority: 1,
+            description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
+        },
+        Rule {
+            id: "R2".into(),
+            when: Some("a".into()),
+            conditions: None,
+            then: Output::Single(ConditionValue::Int(2)),
+            priority: 0,
+            description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
+        },
+    ];
+
+    let report = validate_spec(&spec, false);
+    let dead: Vec<_> = report
+        .issues
+        .iter()
+        .filter(|i| matches!(i.issue_type, IssueType::DeadRule))
+        .collect();
+    assert_eq!(dead.len(), 1);
+    // R2 is tried first, so R1 is the shadowed rule
+    assert_eq!(
+        dead[0].affected_rules,
+        vec!["R1".to_string(), "R2".to_string()]
+    );
+}
+
+#[test]
+fn test_most_specific_overlap_is_not_contradiction() {
+    let spec = Spec::from_yaml(
+        r#"
+id: tiered
+resolution: most_specific
+inputs:
+  - name: gold
+    type: bool
+  - name: domestic
+    type: bool
+outputs:
+  - name: rate
+    type: float
+rules:
+  - id: R1
+    when: "domestic"
+    then: 5.0
+  - id: R2
+    when: "gold && domestic"
+    then: 2.0
+default: 9.0
+"#,
+    )
+    .unwrap();
+
+    let report = validate_spec(&spec, false);
+    assert!(!report.issues.iter().any(|i| matches!(
+        i.issue_type,
+        IssueType::ContradictoryRules | IssueType::DeadRule
+    )));
+}
+
 #[test]
 fn test_detect_dead_rule() {
     let mut spec = make_base_spec();
@@ -108,6 +247,14 @@ fn test_detect_dead_rule() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R2".into(),
@@ -116,6 +263,14 @@ fn test_detect_dead_rule() {
             then: Output::Single(ConditionValue::Int(2)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R3".into(),
@@ -124,6 +279,14 @@ fn test_detect_dead_rule() {
             then: Output::Single(ConditionValue::Int(3)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
     ];
 
@@ -145,6 +308,14 @@ fn test_detect_tautology() {
         then: Output::Single(ConditionValue::Int(1)),
         priority: 0,
         description: None,
+        vars: Vec::new(),
+        tags: Vec::new(),
+        line: None,
+        reason_code: None,
+        message_key: None,
+        state: RuleState::Active,
+        owner: None,
+        approved_by: None,
     }];
 
     let report = validate_spec(&spec, false);
@@ -163,6 +334,13 @@ fn test_detect_type_mismatch() {
         typ: VarType::Int,
         description: None,
         values: None,
+        optional: false,
+        default: None,
+        unit: None,
+        currency: None,
+        aliases: Vec::new(),
+        pii: false,
+        redact: None,
     }];
     spec.rules = vec![Rule {
         id: "R1".into(),
@@ -171,6 +349,14 @@ fn test_detect_type_mismatch() {
         then: Output::Single(ConditionValue::Int(1)),
         priority: 0,
         description: None,
+        vars: Vec::new(),
+        tags: Vec::new(),
+        line: None,
+        reason_code: None,
+        message_key: None,
+        state: RuleState::Active,
+        owner: None,
+        approved_by: None,
     }];
 
     let report = validate_spec(&spec, false);
@@ -181,6 +367,158 @@ fn test_detect_type_mismatch() {
         .any(|i| matches!(i.issue_type, IssueType::TypeMismatch)));
 }
 
+#[test]
+fn test_detect_unit_mismatch() {
+    let spec = Spec::from_yaml(
+        r#"
+id: shipping
+inputs:
+  - name: weight
+    type: float
+    unit: kg
+  - name: limit
+    type: float
+    unit: lb
+outputs:
+  - name: cost
+    type: float
+rules:
+  - id: R1
+    when: "weight > limit"
+    then: 10.0
+  - id: R2
+    when: "weight <= convert(limit, 'lb', 'kg')"
+    then: 5.0
+"#,
+    )
+    .unwrap();
+
+    let report = validate_spec(&spec, false);
+    let unit_issues: Vec<_> = report
+        .issues
+        .iter()
+        .filter(|i| matches!(i.issue_type, IssueType::UnitMismatch))
+        .collect();
+    assert_eq!(unit_issues.len(), 1);
+    assert_eq!(unit_issues[0].affected_rules, vec!["R1".to_string()]);
+}
+
+#[test]
+fn test_unit_checks_through_min_max() {
+    let spec = Spec::from_yaml(
+        r#"
+id: shipping_cap
+inputs:
+  - name: weight
+    type: float
+    unit: kg
+  - name: cap
+    type: float
+    unit: lb
+outputs:
+  - name: billable
+    type: float
+    unit: kg
+rules:
+  - id: R1
+    when: "weight > 0.0"
+    then: "min(weight, cap)"
+  - id: R2
+    when: "weight <= 0.0"
+    then: "round(min(weight, convert(cap, 'lb', 'kg')), 1)"
+"#,
+    )
+    .unwrap();
+
+    let report = validate_spec(&spec, false);
+    let flagged: Vec<_> = report
+        .issues
+        .iter()
+        .filter(|i| matches!(i.issue_type, IssueType::UnitMismatch))
+        .flat_map(|i| i.affected_rules.clone())
+        .collect();
+    assert_eq!(flagged, vec!["R1".to_string()]);
+}
+
+#[test]
+fn test_unit_checks_through_math_functions() {
+    let spec = Spec::from_yaml(
+        r#"
+id: shipping_surcharge
+inputs:
+  - name: weight
+    type: float
+    unit: kg
+  - name: cap
+    type: float
+    unit: lb
+outputs:
+  - name: surcharge
+    type: float
+    unit: kg
+rules:
+  - id: R1
+    when: "weight > 0.0"
+    then: "percentage(cap, 10.0) + 1.0"
+  - id: R2
+    when: "weight <= 0.0"
+    then: "abs(weight) + percentage(weight, 5.0)"
+"#,
+    )
+    .unwrap();
+
+    let report = validate_spec(&spec, false);
+    let flagged: Vec<_> = report
+        .issues
+        .iter()
+        .filter(|i| matches!(i.issue_type, IssueType::UnitMismatch))
+        .flat_map(|i| i.affected_rules.clone())
+        .collect();
+    assert_eq!(flagged, vec!["R1".to_string()]);
+}
+
+#[test]
+fn test_detect_currency_mixing() {
+    let spec = Spec::from_yaml(
+        r#"
+id: intl_shipping
+inputs:
+  - name: base_price
+    type: money
+    currency: EUR
+  - name: surcharge
+    type: money
+    currency: USD
+outputs:
+  - name: price
+    type: money
+    currency: USD
+rules:
+  - id: R1
+    when: "base_price > 100.0"
+    then: "base_price + surcharge"
+  - id: R2
+    when: "base_price <= 100.0"
+    then: "convert_currency(base_price, 'EUR', 'USD') + surcharge"
+  - id: R3
+    when: "base_price > 1000.0"
+    then: "base_price"
+"#,
+    )
+    .unwrap();
+    assert_eq!(spec.inputs[0].typ, VarType::Float);
+
+    let report = validate_spec(&spec, false);
+    let mut flagged: Vec<_> = report
+        .issues
+        .iter()
+        .filter(|i| matches!(i.issue_type, IssueType::UnitMismatch))
+        .flat_map(|i| i.affected_rules.clone())
+        .collect();
+    flagged.sort();
+    assert_eq!(flagged, vec!["R1".to_string(), "R3".to_string()]);
+}
+
 #[test]
 fn test_strict_mode() {
     let mut spec = make_base_spec();
@@ -191,6 +529,14 @@ fn test_strict_mode() {
         then: Output::Single(ConditionValue::Int(1)),
         priority: 0,
         description: None,
+        vars: Vec::new(),
+        tags: Vec::new(),
+        line: None,
+        reason_code: None,
+        message_key: None,
+        state: RuleState::Active,
+        owner: None,
+        approved_by: None,
     }];
 
     let report_normal = validate_spec(&spec, false);
@@ -217,6 +563,14 @@ fn test_valid_spec() {
             then: Output::Single(ConditionValue::Int(1)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
         Rule {
             id: "R2".into(),
@@ -225,6 +579,14 @@ fn test_valid_spec() {
             then: Output::Single(ConditionValue::Int(2)),
             priority: 0,
             description: None,
+            vars: Vec::new(),
+            tags: Vec::new(),
+            line: None,
+            reason_code: None,
+            message_key: None,
+            state: RuleState::Active,
+            owner: None,
+            approved_by: None,
         },
     ];
 